@@ -0,0 +1,39 @@
+// Package chiratelimit adds chi route parameters as keys of
+// ratelimit.Middleware. Middleware.Handler already is a chi middleware,
+// so it is used as it is:
+//
+//	middleware := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+//		KeyFunc:        chiratelimit.URLParamKeyFunc("tenant", nil),
+//		LimiterFactory: factory,
+//	})
+//	router.Route("/tenants/{tenant}", func(r chi.Router) {
+//		r.Use(middleware.Handler)
+//		r.Get("/", handler)
+//	})
+package chiratelimit
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// URLParamKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// URL parameter name, such as "tenant" in /tenants/{tenant}. Chi fills
+// the parameters while routing, after the middleware of the router
+// itself has run, so add the middleware inside a Route group that matched
+// the parameter or to a route with r.With, not with Use on the top-level
+// router. Requests without it are keyed by fallback; a nil fallback uses
+// ratelimit.IPKeyFunc.
+func URLParamKeyFunc(name string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	if fallback == nil {
+		fallback = ratelimit.IPKeyFunc
+	}
+	return func(r *http.Request) string {
+		if v := chi.URLParam(r, name); v != "" {
+			return v
+		}
+		return fallback(r)
+	}
+}
@@ -0,0 +1,50 @@
+package chiratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chiratelimit "github.com/rRateLimit/client/adapters/chi"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func TestURLParamKeyFunc(t *testing.T) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc: chiratelimit.URLParamKeyFunc("tenant", nil),
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour))
+		},
+	})
+	defer m.Close()
+
+	router := chi.NewRouter()
+	router.Route("/tenants/{tenant}", func(r chi.Router) {
+		r.Use(m.Handler)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	router.With(m.Handler).Get("/users/{tenant}", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/tenants/a/", http.StatusOK},
+		{"/tenants/b/", http.StatusOK},
+		{"/tenants/a/", http.StatusTooManyRequests},
+		{"/users/c", http.StatusOK},
+		{"/users/c", http.StatusTooManyRequests},
+		{"/users/b", http.StatusTooManyRequests}, // shares tenant b's limiter
+	} {
+		if code := serve(tc.path); code != tc.want {
+			t.Errorf("GET %s: status %d, want %d", tc.path, code, tc.want)
+		}
+	}
+}
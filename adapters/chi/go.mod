@@ -0,0 +1,10 @@
+module github.com/rRateLimit/client/adapters/chi
+
+go 1.21
+
+require (
+	github.com/go-chi/chi/v5 v5.2.3
+	github.com/rRateLimit/client v0.0.0
+)
+
+replace github.com/rRateLimit/client => ../..
@@ -0,0 +1,86 @@
+// Package echoratelimit adapts ratelimit.Middleware to echo:
+//
+//	middleware := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+//		KeyFunc:        echoratelimit.ContextKeyFunc("user", nil),
+//		LimiterFactory: factory,
+//	})
+//	e.Use(echoratelimit.Middleware(middleware))
+package echoratelimit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// contextKey is the request context key of the echo.Context.
+type contextKey struct{}
+
+// Middleware returns an echo middleware that applies m to each request.
+// Denied requests are answered by m and the chain stops there; admitted
+// ones continue with the request m passed on, so CostReporter and the
+// other values m puts in the context reach the handlers. The error of the
+// next handler is returned to echo.
+func Middleware(m *ratelimit.Middleware) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var err error
+			admitted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				err = next(c)
+			})
+			r := c.Request().WithContext(context.WithValue(c.Request().Context(), contextKey{}, c))
+			m.Handler(admitted).ServeHTTP(c.Response(), r)
+			return err
+		}
+	}
+}
+
+// Context returns the echo.Context of a request passed through
+// Middleware, or nil.
+func Context(r *http.Request) echo.Context {
+	c, _ := r.Context().Value(contextKey{}).(echo.Context)
+	return c
+}
+
+// KeyFunc returns a ratelimit.KeyFunc that keys requests by key applied
+// to their echo.Context. Requests outside Middleware or for which key
+// returns "" are keyed by fallback; a nil fallback uses
+// ratelimit.IPKeyFunc.
+func KeyFunc(key func(echo.Context) string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	if fallback == nil {
+		fallback = ratelimit.IPKeyFunc
+	}
+	return func(r *http.Request) string {
+		if c := Context(r); c != nil {
+			if v := key(c); v != "" {
+				return v
+			}
+		}
+		return fallback(r)
+	}
+}
+
+// ContextKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// string an earlier middleware stored with c.Set(name, ...), such as a
+// user ID set by authentication. Requests without it are keyed by
+// fallback; a nil fallback uses ratelimit.IPKeyFunc.
+func ContextKeyFunc(name string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	return KeyFunc(func(c echo.Context) string {
+		v, _ := c.Get(name).(string)
+		return v
+	}, fallback)
+}
+
+// ParamKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// path parameter name, such as "tenant" in /tenants/:tenant. Echo routes
+// before running the middleware added with e.Use, so this works there but
+// not with e.Pre. Requests without it are keyed by fallback; a nil
+// fallback uses ratelimit.IPKeyFunc.
+func ParamKeyFunc(name string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	return KeyFunc(func(c echo.Context) string {
+		return c.Param(name)
+	}, fallback)
+}
@@ -0,0 +1,96 @@
+package echoratelimit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	echoratelimit "github.com/rRateLimit/client/adapters/echo"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func newServer(keyFunc ratelimit.KeyFunc, handled *int) (*echo.Echo, *ratelimit.Middleware) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc: keyFunc,
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour))
+		},
+	})
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", c.Request().Header.Get("X-User"))
+			return next(c)
+		}
+	})
+	e.Use(echoratelimit.Middleware(m))
+	e.GET("/tenants/:tenant", func(c echo.Context) error {
+		*handled++
+		return c.NoContent(http.StatusOK)
+	})
+	return e, m
+}
+
+func serve(h http.Handler, path, user string) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("X-User", user)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestMiddlewareStopsDeniedRequests(t *testing.T) {
+	handled := 0
+	e, m := newServer(echoratelimit.ContextKeyFunc("user", nil), &handled)
+	defer m.Close()
+
+	if code := serve(e, "/tenants/a", "alice"); code != http.StatusOK {
+		t.Fatalf("first request: status %d", code)
+	}
+	if code := serve(e, "/tenants/a", "alice"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", code)
+	}
+	if code := serve(e, "/tenants/a", "bob"); code != http.StatusOK {
+		t.Fatalf("other user: status %d", code)
+	}
+	if handled != 2 {
+		t.Errorf("handler ran %d times, want 2", handled)
+	}
+}
+
+func TestMiddlewareReturnsHandlerError(t *testing.T) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc: ratelimit.IPKeyFunc,
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour))
+		},
+	})
+	defer m.Close()
+
+	want := errors.New("handler failed")
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	err := echoratelimit.Middleware(m)(func(echo.Context) error { return want })(c)
+	if err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+}
+
+func TestParamKeyFunc(t *testing.T) {
+	handled := 0
+	e, m := newServer(echoratelimit.ParamKeyFunc("tenant", nil), &handled)
+	defer m.Close()
+
+	if code := serve(e, "/tenants/a", ""); code != http.StatusOK {
+		t.Fatalf("tenant a: status %d", code)
+	}
+	if code := serve(e, "/tenants/b", ""); code != http.StatusOK {
+		t.Fatalf("tenant b: status %d", code)
+	}
+	if code := serve(e, "/tenants/a", ""); code != http.StatusTooManyRequests {
+		t.Fatalf("tenant a again: status %d, want 429", code)
+	}
+}
@@ -0,0 +1,102 @@
+// Package fasthttpratelimit applies ratelimit.Middleware to fasthttp
+// handlers:
+//
+//	middleware := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+//		LimiterFactory: factory,
+//	})
+//	limit := fasthttpratelimit.Middleware(middleware, &fasthttpratelimit.Config{
+//		KeyFunc: fasthttpratelimit.HeaderKeyFunc("X-API-Key", nil),
+//	})
+//	fasthttp.ListenAndServe(":8080", limit(handler))
+//
+// Requests are decided by Middleware.AllowKey, so the per-key limiters,
+// blocked and exempt keys, DryRun, counters, logs and events of m apply;
+// the settings of m that take an *http.Request, such as its KeyFunc,
+// CostFunc, Deny and Bypass rules and callbacks, do not.
+package fasthttpratelimit
+
+import (
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/valyala/fasthttp"
+)
+
+// KeyFunc extracts the rate limiting key from a request.
+type KeyFunc func(ctx *fasthttp.RequestCtx) string
+
+// Config configures Middleware.
+type Config struct {
+	// KeyFunc returns the key of a request. Defaults to IPKeyFunc.
+	KeyFunc KeyFunc
+
+	// CostFunc returns how many tokens a request consumes. Defaults to 1.
+	CostFunc func(ctx *fasthttp.RequestCtx) int
+
+	// OnRateLimited answers a rejected request. The default sets the
+	// headers of info.Headers and replies 429 "Rate limit exceeded", as
+	// the default response of Middleware.Handler in plain text.
+	OnRateLimited func(ctx *fasthttp.RequestCtx, info ratelimit.RateLimitInfo)
+}
+
+// IPKeyFunc keys requests by the IP address of the connection. Unlike
+// ratelimit.IPKeyFunc it believes no forwarding headers; behind a proxy,
+// key by a header the proxy sets and strips with HeaderKeyFunc.
+func IPKeyFunc(ctx *fasthttp.RequestCtx) string {
+	return ctx.RemoteIP().String()
+}
+
+// HeaderKeyFunc returns a KeyFunc that keys requests by the value of the
+// header name, such as "X-API-Key". Requests without it are keyed by
+// fallback; a nil fallback uses IPKeyFunc.
+func HeaderKeyFunc(name string, fallback KeyFunc) KeyFunc {
+	if fallback == nil {
+		fallback = IPKeyFunc
+	}
+	return func(ctx *fasthttp.RequestCtx) string {
+		if v := ctx.Request.Header.Peek(name); len(v) > 0 {
+			return string(v)
+		}
+		return fallback(ctx)
+	}
+}
+
+// Middleware returns a function that wraps fasthttp handlers so that
+// requests rejected by m are answered by config.OnRateLimited and never
+// reach the handler. A nil config uses the defaults.
+func Middleware(m *ratelimit.Middleware, config *Config) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+	var c Config
+	if config != nil {
+		c = *config
+	}
+	if c.KeyFunc == nil {
+		c.KeyFunc = IPKeyFunc
+	}
+	if c.OnRateLimited == nil {
+		c.OnRateLimited = defaultOnRateLimited
+	}
+
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			cost := 1
+			if c.CostFunc != nil {
+				cost = c.CostFunc(ctx)
+			}
+			if info, ok := m.AllowKey(c.KeyFunc(ctx), cost); !ok {
+				c.OnRateLimited(ctx, info)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// defaultOnRateLimited writes the response itself rather than with
+// ctx.Error, which would reset the headers.
+func defaultOnRateLimited(ctx *fasthttp.RequestCtx, info ratelimit.RateLimitInfo) {
+	for name, value := range info.Headers() {
+		ctx.Response.Header.Set(name, value)
+	}
+	ctx.Response.Header.Set("X-Content-Type-Options", "nosniff")
+	ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString("Rate limit exceeded\n")
+}
@@ -0,0 +1,90 @@
+package fasthttpratelimit_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	fasthttpratelimit "github.com/rRateLimit/client/adapters/fasthttp"
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/valyala/fasthttp"
+)
+
+func newMiddleware(rate int) *ratelimit.Middleware {
+	return ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(rate), ratelimit.WithPeriod(time.Hour))
+		},
+	})
+}
+
+// serve runs handler on a request from ip with the given API key.
+func serve(handler fasthttp.RequestHandler, ip, apiKey string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.SetRequestURI("/")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}, nil)
+	handler(ctx)
+	return ctx
+}
+
+func TestMiddlewareRejectsWithHeaders(t *testing.T) {
+	m := newMiddleware(1)
+	defer m.Close()
+	handled := 0
+	handler := fasthttpratelimit.Middleware(m, nil)(func(ctx *fasthttp.RequestCtx) {
+		handled++
+	})
+
+	if ctx := serve(handler, "10.0.0.1", ""); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("first request: status %d", ctx.Response.StatusCode())
+	}
+	ctx := serve(handler, "10.0.0.1", "")
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", code)
+	}
+	if v := ctx.Response.Header.Peek("Retry-After"); len(v) == 0 {
+		t.Error("rejected response has no Retry-After header")
+	}
+	if v := ctx.Response.Header.Peek("X-RateLimit-Limit"); string(v) != "1" {
+		t.Errorf("X-RateLimit-Limit = %q, want 1", v)
+	}
+	if ctx := serve(handler, "10.0.0.2", ""); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("other client: status %d", ctx.Response.StatusCode())
+	}
+	if handled != 2 {
+		t.Errorf("handler ran %d times, want 2", handled)
+	}
+
+	counters := m.Counters()
+	if counters.Allowed != 2 || counters.RateLimited != 1 {
+		t.Errorf("counters = %+v, want 2 allowed and 1 rate limited", counters)
+	}
+}
+
+func TestMiddlewareKeyAndCost(t *testing.T) {
+	m := newMiddleware(10)
+	defer m.Close()
+	handler := fasthttpratelimit.Middleware(m, &fasthttpratelimit.Config{
+		KeyFunc:  fasthttpratelimit.HeaderKeyFunc("X-API-Key", nil),
+		CostFunc: func(ctx *fasthttp.RequestCtx) int { return 5 },
+	})(func(ctx *fasthttp.RequestCtx) {})
+
+	for i, tc := range []struct {
+		ip, apiKey string
+		want       int
+	}{
+		{"10.0.0.1", "a", fasthttp.StatusOK},
+		{"10.0.0.2", "a", fasthttp.StatusOK},
+		{"10.0.0.3", "a", fasthttp.StatusTooManyRequests}, // key a spent its 10
+		{"10.0.0.3", "b", fasthttp.StatusOK},
+		{"10.0.0.3", "", fasthttp.StatusOK}, // keyed by IP
+	} {
+		if code := serve(handler, tc.ip, tc.apiKey).Response.StatusCode(); code != tc.want {
+			t.Errorf("request %d: status %d, want %d", i, code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+module github.com/rRateLimit/client/adapters/fasthttp
+
+go 1.21
+
+require (
+	github.com/rRateLimit/client v0.0.0
+	github.com/valyala/fasthttp v1.59.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+)
+
+replace github.com/rRateLimit/client => ../..
@@ -0,0 +1,85 @@
+// Package ginratelimit adapts ratelimit.Middleware to gin:
+//
+//	middleware := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+//		KeyFunc:        ginratelimit.ContextKeyFunc("user", nil),
+//		LimiterFactory: factory,
+//	})
+//	router.Use(ginratelimit.Middleware(middleware))
+package ginratelimit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// contextKey is the request context key of the *gin.Context.
+type contextKey struct{}
+
+// Middleware returns a gin handler that applies m to each request. Denied
+// requests are answered by m and aborted; admitted ones continue down the
+// chain with the request m passed on, so CostReporter and the other
+// values m puts in the context reach the handlers.
+func Middleware(m *ratelimit.Middleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admitted := false
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admitted = true
+			c.Request = r
+			c.Next()
+		})
+		r := c.Request.WithContext(context.WithValue(c.Request.Context(), contextKey{}, c))
+		m.Handler(next).ServeHTTP(c.Writer, r)
+		if !admitted {
+			c.Abort()
+		}
+	}
+}
+
+// Context returns the *gin.Context of a request passed through
+// Middleware, or nil.
+func Context(r *http.Request) *gin.Context {
+	c, _ := r.Context().Value(contextKey{}).(*gin.Context)
+	return c
+}
+
+// KeyFunc returns a ratelimit.KeyFunc that keys requests by key applied
+// to their *gin.Context. Requests outside Middleware or for which key
+// returns "" are keyed by fallback; a nil fallback uses
+// ratelimit.IPKeyFunc.
+func KeyFunc(key func(*gin.Context) string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	if fallback == nil {
+		fallback = ratelimit.IPKeyFunc
+	}
+	return func(r *http.Request) string {
+		if c := Context(r); c != nil {
+			if v := key(c); v != "" {
+				return v
+			}
+		}
+		return fallback(r)
+	}
+}
+
+// ContextKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// string an earlier gin handler stored with c.Set(name, ...), such as a
+// user ID set by authentication. Requests without it are keyed by
+// fallback; a nil fallback uses ratelimit.IPKeyFunc.
+func ContextKeyFunc(name string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	return KeyFunc(func(c *gin.Context) string {
+		return c.GetString(name)
+	}, fallback)
+}
+
+// ParamKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// route parameter name, such as "tenant" in /tenants/:tenant. Gin fills
+// the parameters before running the handlers, so this works with
+// router.Use. Requests without it are keyed by fallback; a nil fallback
+// uses ratelimit.IPKeyFunc.
+func ParamKeyFunc(name string, fallback ratelimit.KeyFunc) ratelimit.KeyFunc {
+	return KeyFunc(func(c *gin.Context) string {
+		return c.Param(name)
+	}, fallback)
+}
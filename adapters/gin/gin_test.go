@@ -0,0 +1,76 @@
+package ginratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginratelimit "github.com/rRateLimit/client/adapters/gin"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func newRouter(keyFunc ratelimit.KeyFunc, handled *int) (*gin.Engine, *ratelimit.Middleware) {
+	gin.SetMode(gin.TestMode)
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc: keyFunc,
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour))
+		},
+	})
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user", c.GetHeader("X-User"))
+		c.Next()
+	})
+	router.Use(ginratelimit.Middleware(m))
+	router.GET("/tenants/:tenant", func(c *gin.Context) {
+		*handled++
+		c.Status(http.StatusOK)
+	})
+	return router, m
+}
+
+func serve(router http.Handler, path, user string) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("X-User", user)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestMiddlewareAbortsDeniedRequests(t *testing.T) {
+	handled := 0
+	router, m := newRouter(ginratelimit.ContextKeyFunc("user", nil), &handled)
+	defer m.Close()
+
+	if code := serve(router, "/tenants/a", "alice"); code != http.StatusOK {
+		t.Fatalf("first request: status %d", code)
+	}
+	if code := serve(router, "/tenants/a", "alice"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", code)
+	}
+	if code := serve(router, "/tenants/a", "bob"); code != http.StatusOK {
+		t.Fatalf("other user: status %d", code)
+	}
+	if handled != 2 {
+		t.Errorf("handler ran %d times, want 2", handled)
+	}
+}
+
+func TestParamKeyFunc(t *testing.T) {
+	handled := 0
+	router, m := newRouter(ginratelimit.ParamKeyFunc("tenant", nil), &handled)
+	defer m.Close()
+
+	if code := serve(router, "/tenants/a", ""); code != http.StatusOK {
+		t.Fatalf("tenant a: status %d", code)
+	}
+	if code := serve(router, "/tenants/b", ""); code != http.StatusOK {
+		t.Fatalf("tenant b: status %d", code)
+	}
+	if code := serve(router, "/tenants/a", ""); code != http.StatusTooManyRequests {
+		t.Fatalf("tenant a again: status %d, want 429", code)
+	}
+}
@@ -0,0 +1,43 @@
+// Command rrl bundles the project's tools in one binary:
+//
+//	rrl client run [flags]   load test a server (same as the client binary)
+//	rrl client agent [flags] worker agent for distributed runs (client -agents)
+//	rrl replay LOG [flags]   replay an access log (client run -replay LOG)
+//	rrl server [flags]       TCP/UDP test server
+//	rrl bench [flags]        measure the limiters' Allow in process
+//	rrl daemon [flags]       sidecar rate limit daemon (ratelimitd)
+//	rrl plan [flags]         recommend limiter parameters from a trace
+//	rrl ctl fetch|view       save and summarise limiter state dumps
+//
+// Every command accepts -config FILE (or $RRL_CONFIG), a JSON file with
+// flag defaults in one section per command; see internal/cli.
+package main
+
+import (
+	"os"
+
+	"github.com/rRateLimit/client/internal/bench"
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/internal/client"
+	"github.com/rRateLimit/client/internal/planner"
+	"github.com/rRateLimit/client/internal/ratelimitd"
+	"github.com/rRateLimit/client/internal/server"
+	"github.com/rRateLimit/client/internal/statedump"
+)
+
+var commands = []*cli.Command{
+	{Name: "client", Summary: "load test a rate limited server", Commands: []*cli.Command{
+		{Name: "run", Summary: "send messages at a fixed rate or through a scenario", Run: client.Main},
+		{Name: "agent", Summary: "run parts of distributed runs for a coordinating client", Run: client.AgentMain},
+	}},
+	{Name: "replay", Summary: "replay an access log against a server", Run: client.ReplayMain},
+	{Name: "server", Summary: "run the TCP/UDP test server", Run: server.Main},
+	{Name: "bench", Summary: "measure the cost of the limiters' Allow", Run: bench.Main},
+	{Name: "daemon", Summary: "run the sidecar rate limit daemon", Run: ratelimitd.Main},
+	{Name: "plan", Summary: "recommend limiter parameters from a traffic trace", Run: planner.Main},
+	{Name: "ctl", Summary: "save and summarise limiter state dumps", Commands: statedump.Commands},
+}
+
+func main() {
+	cli.Dispatch("rrl", commands, os.Args[1:])
+}
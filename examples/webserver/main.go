@@ -18,12 +18,6 @@ type Response struct {
 	Method    string    `json:"method"`
 }
 
-// StatsResponse represents rate limiter statistics
-type StatsResponse struct {
-	Limiters map[string]int `json:"limiters"`
-	Message  string         `json:"message"`
-}
-
 func main() {
 	fmt.Println("=== Rate Limiting Web Server Example ===")
 	fmt.Println("Server starting on :8080")
@@ -33,7 +27,7 @@ func main() {
 	fmt.Println("  GET  /api/user      - User endpoint (50 req/min per user)")
 	fmt.Println("  GET  /api/admin     - Admin endpoint (10 req/min, strict)")
 	fmt.Println("  GET  /health        - Health check (no rate limit)")
-	fmt.Println("  GET  /stats         - Rate limiter statistics")
+	fmt.Println("  GET  /stats/{name}  - Rate limiter statistics (public, user, admin)")
 	fmt.Println()
 
 	// Create different rate limiters for different endpoints
@@ -91,14 +85,11 @@ func main() {
 				ratelimit.WithPeriod(time.Minute),
 			)
 		},
-		KeyFunc: func(r *http.Request) string {
-			// Combine user and path for admin endpoints
-			user := r.Header.Get("X-Admin-ID")
-			if user == "" {
-				user = "anonymous"
-			}
-			return fmt.Sprintf("admin:%s:%s", user, r.URL.Path)
-		},
+		// Combine user and path for admin endpoints
+		KeyFunc: ratelimit.KeyPrefix("admin:", ratelimit.KeyJoin(
+			ratelimit.HeaderKeyFunc("X-Admin-ID", ratelimit.ConstantKeyFunc("anonymous")),
+			ratelimit.PathKeyFunc,
+		)),
 		OnRateLimited: func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusTooManyRequests)
@@ -134,14 +125,10 @@ func main() {
 	// Health check (no rate limiting)
 	mux.HandleFunc("/health", healthHandler)
 
-	// Stats endpoint
-	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		statsHandler(w, r, map[string]*ratelimit.Middleware{
-			"public": publicMiddleware,
-			"user":   userMiddleware,
-			"admin":  adminMiddleware,
-		})
-	})
+	// Stats endpoints
+	mux.Handle("/stats/public", publicMiddleware.StatsHandler())
+	mux.Handle("/stats/user", userMiddleware.StatsHandler())
+	mux.Handle("/stats/admin", adminMiddleware.StatsHandler())
 
 	// Add logging middleware
 	handler := loggingMiddleware(mux)
@@ -214,21 +201,6 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func statsHandler(w http.ResponseWriter, r *http.Request, middlewares map[string]*ratelimit.Middleware) {
-	stats := make(map[string]interface{})
-	
-	for name, mw := range middlewares {
-		stats[name] = mw.Stats()
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":   "Rate limiter statistics",
-		"timestamp": time.Now(),
-		"limiters":  stats,
-	})
-}
-
 // Middleware
 
 var startTime = time.Now()
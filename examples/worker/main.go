@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Job is a message taken from a queue.
+type Job struct {
+	ID int
+}
+
+// Queue stands in for an SQS or Kafka style source that is polled for
+// batches of messages.
+type Queue struct {
+	mu   sync.Mutex
+	jobs []Job
+}
+
+// Receive returns up to max jobs, or none once the queue is drained.
+func (q *Queue) Receive(ctx context.Context, max int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := min(max, len(q.jobs))
+	batch := q.jobs[:n]
+	q.jobs = q.jobs[n:]
+	return batch, nil
+}
+
+func main() {
+	fmt.Println("=== Paced Queue Consumers ===")
+	fmt.Println()
+
+	channelConsumers()
+	fmt.Println()
+	polledConsumer()
+}
+
+// channelConsumers paces three workers reading one channel with a shared
+// limiter of 20 jobs per second.
+func channelConsumers() {
+	fmt.Println("--- 3 workers on a channel, 20 jobs/sec shared ---")
+
+	limiter := ratelimit.NewTokenBucket(
+		ratelimit.WithRate(20),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithBurst(1),
+	)
+
+	jobs := make(chan Job)
+	go func() {
+		for i := 1; i <= 40; i++ {
+			jobs <- Job{ID: i}
+		}
+		close(jobs)
+	}()
+
+	var processed int64
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 1; w <= 3; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			err := ratelimit.Paced(context.Background(), limiter, func(ctx context.Context) error {
+				job, ok := <-jobs
+				if !ok {
+					return ratelimit.ErrStop
+				}
+				atomic.AddInt64(&processed, 1)
+				_ = job // process the job
+				return nil
+			})
+			if err != nil {
+				fmt.Printf("worker %d: %v\n", worker, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	fmt.Printf("processed %d jobs in %v (%.1f jobs/sec)\n",
+		processed, elapsed.Round(time.Millisecond), float64(processed)/elapsed.Seconds())
+}
+
+// polledConsumer receives batches of up to 10 messages, charging each
+// batch by its size so that the consumer stays within 50 messages per
+// second, and stops after two seconds.
+func polledConsumer() {
+	fmt.Println("--- Polled batches, 50 messages/sec, stopped after 2s ---")
+
+	queue := &Queue{}
+	for i := 1; i <= 500; i++ {
+		queue.jobs = append(queue.jobs, Job{ID: i})
+	}
+	limiter := ratelimit.NewTokenBucket(
+		ratelimit.WithRate(50),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithBurst(10),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	received := 0
+	err := ratelimit.Paced(ctx, limiter, func(ctx context.Context) error {
+		batch, err := queue.Receive(ctx, 10)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return ratelimit.ErrStop
+		}
+		received += len(batch)
+		// Paced charged one unit; charge the rest of the batch
+		if len(batch) > 1 {
+			return limiter.WaitN(ctx, len(batch)-1)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		fmt.Println("consumer:", err)
+	}
+	fmt.Printf("received %d messages before stopping\n", received)
+}
@@ -0,0 +1,329 @@
+// Command apicheck guards the compatibility promise of the public
+// packages. It lists their exported API, one feature per line, and
+// compares it with the manifest in api/v1.txt:
+//
+//	go run ./internal/apicheck        fail if a recorded feature changed or disappeared
+//	go run ./internal/apicheck -w     also record new features in the manifest
+//
+// A feature is a constant, variable, function, method, struct field or
+// interface method together with its type, so any change that could break
+// a caller shows up as a removed line. An interface is also recorded with
+// its full method set, because adding a method breaks every
+// implementation outside the module. Additions are reported but allowed
+// within v1.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// module is the import path prefix of the checked packages.
+const module = "github.com/rRateLimit/client"
+
+// packages are the directories, relative to the module root, whose API
+// is covered by the v1 promise.
+var packages = []string{
+	"ratelimit",
+	"ratelimit/admin",
+	"ratelimit/anomaly",
+	"ratelimit/clocktest",
+	"ratelimit/config",
+	"ratelimit/coordinator",
+	"ratelimit/distributed",
+	"ratelimit/forecast",
+	"ratelimit/geo",
+	"ratelimit/health",
+	"ratelimit/metering",
+	"ratelimit/quota",
+	"ratelimit/ratelimittest",
+	"ratelimit/sharding",
+	"ratelimit/sidecar",
+	"ratelimit/simulate",
+}
+
+func main() {
+	manifest := flag.String("manifest", "api/v1.txt", "API manifest file")
+	write := flag.Bool("w", false, "Add new features to the manifest")
+	flag.Parse()
+
+	current, err := moduleFeatures(".")
+	if err != nil {
+		log.Fatal(err)
+	}
+	recorded, err := readManifest(*manifest)
+	if err != nil {
+		log.Fatalf("Failed to read manifest: %v", err)
+	}
+	removed, added := diff(recorded, current)
+
+	for _, f := range added {
+		fmt.Printf("+%s\n", f)
+	}
+	for _, f := range removed {
+		fmt.Printf("-%s\n", f)
+	}
+
+	if *write && len(added) > 0 {
+		for _, f := range added {
+			recorded[f] = true
+		}
+		if err := writeManifest(*manifest, recorded); err != nil {
+			log.Fatalf("Failed to write manifest: %v", err)
+		}
+		fmt.Printf("Recorded %d new features in %s\n", len(added), *manifest)
+	}
+
+	if len(removed) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d features of the v1 API changed or were removed; this breaks users.\n", len(removed))
+		fmt.Fprintf(os.Stderr, "Restore them, or add the replacement alongside and deprecate the old API.\n")
+		os.Exit(1)
+	}
+	if len(added) > 0 && !*write {
+		fmt.Fprintf(os.Stderr, "\n%d new features are not in %s; run with -w to record them.\n", len(added), *manifest)
+	}
+}
+
+// moduleFeatures lists the exported features of packages in the module
+// rooted at root.
+func moduleFeatures(root string) (map[string]bool, error) {
+	current := make(map[string]bool)
+	fset := token.NewFileSet()
+	imp := importer.ForCompiler(fset, "source", nil)
+	for _, dir := range packages {
+		features, err := packageFeatures(fset, imp, root, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", dir, err)
+		}
+		for _, f := range features {
+			current[f] = true
+		}
+	}
+	return current, nil
+}
+
+// diff returns the recorded features missing from current, which break
+// callers, and the current features not recorded yet, both sorted.
+func diff(recorded, current map[string]bool) (removed, added []string) {
+	for f := range recorded {
+		if !current[f] {
+			removed = append(removed, f)
+		}
+	}
+	for f := range current {
+		if !recorded[f] {
+			added = append(added, f)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	return removed, added
+}
+
+// packageFeatures type-checks the package in dir, relative to the module
+// root, and lists its exported features.
+func packageFeatures(fset *token.FileSet, imp types.Importer, root, dir string) ([]string, error) {
+	pkgs, err := parser.ParseDir(fset, filepath.Join(root, dir), func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			files = append(files, f)
+		}
+	}
+
+	path := module + "/" + filepath.ToSlash(dir)
+	conf := types.Config{Importer: imp}
+	pkg, err := conf.Check(path, fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &walker{pkg: pkg, prefix: "pkg " + path + ", "}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		if obj := scope.Lookup(name); obj.Exported() {
+			w.object(obj)
+		}
+	}
+	return w.features, nil
+}
+
+// walker collects the features of one package.
+type walker struct {
+	pkg      *types.Package
+	prefix   string
+	features []string
+}
+
+func (w *walker) emit(format string, args ...interface{}) {
+	w.features = append(w.features, w.prefix+fmt.Sprintf(format, args...))
+}
+
+// qualifier writes types of other packages by package name, as in Go
+// source.
+func (w *walker) qualifier(p *types.Package) string {
+	if p == w.pkg {
+		return ""
+	}
+	return p.Name()
+}
+
+func (w *walker) typeString(t types.Type) string {
+	return types.TypeString(t, w.qualifier)
+}
+
+// signature writes sig without parameter names, which callers cannot
+// depend on.
+func (w *walker) signature(sig *types.Signature) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i := 0; i < sig.Params().Len(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		t := sig.Params().At(i).Type()
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			b.WriteString("..." + w.typeString(t.(*types.Slice).Elem()))
+		} else {
+			b.WriteString(w.typeString(t))
+		}
+	}
+	b.WriteByte(')')
+
+	switch results := sig.Results(); results.Len() {
+	case 0:
+	case 1:
+		b.WriteString(" " + w.typeString(results.At(0).Type()))
+	default:
+		b.WriteString(" (")
+		for i := 0; i < results.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(w.typeString(results.At(i).Type()))
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+func (w *walker) object(obj types.Object) {
+	switch obj := obj.(type) {
+	case *types.Const:
+		w.emit("const %s %s", obj.Name(), w.typeString(obj.Type()))
+	case *types.Var:
+		w.emit("var %s %s", obj.Name(), w.typeString(obj.Type()))
+	case *types.Func:
+		w.emit("func %s%s", obj.Name(), w.signature(obj.Type().(*types.Signature)))
+	case *types.TypeName:
+		w.typeName(obj)
+	}
+}
+
+func (w *walker) typeName(obj *types.TypeName) {
+	name := obj.Name()
+	if obj.IsAlias() {
+		w.emit("type %s = %s", name, w.typeString(obj.Type()))
+		return
+	}
+
+	switch u := obj.Type().Underlying().(type) {
+	case *types.Struct:
+		w.emit("type %s struct", name)
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if !f.Exported() {
+				continue
+			}
+			if f.Embedded() {
+				w.emit("type %s struct, embedded %s", name, w.typeString(f.Type()))
+			} else {
+				w.emit("type %s struct, %s %s", name, f.Name(), w.typeString(f.Type()))
+			}
+		}
+	case *types.Interface:
+		var methods []string
+		for i := 0; i < u.NumMethods(); i++ {
+			m := u.Method(i)
+			methods = append(methods, m.Name())
+			if m.Exported() {
+				w.emit("type %s interface, %s%s", name, m.Name(), w.signature(m.Type().(*types.Signature)))
+			}
+		}
+		sort.Strings(methods)
+		w.emit("type %s interface { %s }", name, strings.Join(methods, ", "))
+	default:
+		w.emit("type %s %s", name, w.typeString(u))
+	}
+
+	// Methods on T and *T.
+	for _, recv := range []types.Type{obj.Type(), types.NewPointer(obj.Type())} {
+		mset := types.NewMethodSet(recv)
+		for i := 0; i < mset.Len(); i++ {
+			sel := mset.At(i)
+			m := sel.Obj().(*types.Func)
+			if !m.Exported() || len(sel.Index()) > 1 {
+				// Promoted methods are covered by the embedded field.
+				continue
+			}
+			sig := m.Type().(*types.Signature)
+			if _, ptr := sig.Recv().Type().(*types.Pointer); ptr != (recv != obj.Type()) {
+				continue
+			}
+			if _, isIface := obj.Type().Underlying().(*types.Interface); isIface {
+				continue
+			}
+			recvName := name
+			if recv != obj.Type() {
+				recvName = "*" + name
+			}
+			w.emit("method (%s) %s%s", recvName, m.Name(), w.signature(sig))
+		}
+	}
+}
+
+func readManifest(path string) (map[string]bool, error) {
+	features := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return features, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			features[line] = true
+		}
+	}
+	return features, scanner.Err()
+}
+
+func writeManifest(path string, features map[string]bool) error {
+	lines := make([]string, 0, len(features))
+	for f := range features {
+		lines = append(lines, f)
+	}
+	sort.Strings(lines)
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestAPICompatible fails when the exported API no longer has a feature
+// recorded in api/v1.txt, or has features that are not recorded yet, so
+// that go test catches what go run ./internal/apicheck would.
+func TestAPICompatible(t *testing.T) {
+	if testing.Short() {
+		t.Skip("type-checks every public package from source")
+	}
+	root := filepath.Join("..", "..")
+
+	recorded, err := readManifest(filepath.Join(root, "api", "v1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) == 0 {
+		t.Fatal("api/v1.txt records no features")
+	}
+	current, err := moduleFeatures(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, added := diff(recorded, current)
+	for _, f := range removed {
+		t.Errorf("changed or removed: %s", f)
+	}
+	if len(removed) > 0 {
+		t.Log("Restore them, or add the replacement alongside and deprecate the old API.")
+	}
+	for _, f := range added {
+		t.Errorf("not recorded: %s", f)
+	}
+	if len(added) > 0 {
+		t.Log("Run go run ./internal/apicheck -w to record the new features.")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	recorded := map[string]bool{"func A()": true, "func B()": true}
+	current := map[string]bool{"func A()": true, "func B(int)": true, "func C()": true}
+
+	removed, added := diff(recorded, current)
+	if len(removed) != 1 || removed[0] != "func B()" {
+		t.Errorf("removed = %q, want [func B()]", removed)
+	}
+	if len(added) != 2 || added[0] != "func B(int)" || added[1] != "func C()" {
+		t.Errorf("added = %q, want [func B(int) func C()]", added)
+	}
+}
@@ -0,0 +1,136 @@
+// Package bench measures the limiters of package ratelimit in process. It
+// backs rrl bench.
+package bench
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// limiter is a measured limiter.
+type limiter struct {
+	name string
+	new  func() ratelimit.Limiter
+}
+
+// limiters are the measured limiters by name. Their limit is never reached,
+// so every call takes the admitting path, and their period is short, so
+// that the warm-up fills the windows to their working set.
+var limiters = []limiter{
+	{"TokenBucket", func() ratelimit.Limiter { return ratelimit.NewTokenBucket(benchOptions()...) }},
+	{"FixedWindow", func() ratelimit.Limiter { return ratelimit.NewFixedWindow(benchOptions()...) }},
+	{"SlidingWindow", func() ratelimit.Limiter { return ratelimit.NewSlidingWindow(benchOptions()...) }},
+	{"SlidingLog", func() ratelimit.Limiter { return ratelimit.NewSlidingLog(benchOptions()...) }},
+}
+
+func benchOptions() []ratelimit.Option {
+	return []ratelimit.Option{ratelimit.WithRate(1 << 30), ratelimit.WithPeriod(time.Millisecond)}
+}
+
+// Result is the measurement of one limiter and call.
+type Result struct {
+	Limiter     string  `json:"limiter"`
+	Call        string  `json:"call"`
+	Parallel    bool    `json:"parallel"`
+	Ops         int     `json:"ops"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+}
+
+// Main runs rrl bench with the command line args.
+func Main(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	names := fs.String("limiters", "", "Comma-separated limiters to measure (default all: TokenBucket, FixedWindow, SlidingWindow, SlidingLog)")
+	parallel := fs.Bool("parallel", true, "Share each limiter between GOMAXPROCS goroutines instead of calling it from one")
+	output := fs.String("output", cli.FormatText, "Output format: text, json (one object per line) or csv")
+	cli.Parse(fs, args)
+
+	if !cli.ValidFormat(*output) {
+		log.Fatalf("Invalid output format: %s", *output)
+	}
+	selected, err := selectLimiters(*names)
+	if err != nil {
+		log.Fatalf("Invalid -limiters: %v", err)
+	}
+
+	var records *cli.RecordWriter
+	if *output != cli.FormatText {
+		records = cli.NewRecordWriter(os.Stdout, *output)
+	} else {
+		fmt.Printf("%-14s %-10s %12s %10s %12s\n", "LIMITER", "CALL", "NS/OP", "B/OP", "ALLOCS/OP")
+	}
+	for _, l := range selected {
+		for _, call := range []struct {
+			name string
+			n    int
+		}{{"Allow", 1}, {"AllowN(2)", 2}} {
+			r := measure(l.new(), call.n, *parallel)
+			r.Limiter, r.Call = l.name, call.name
+			if records != nil {
+				if err := records.Write(r); err != nil {
+					log.Fatalf("Failed to write results: %v", err)
+				}
+				continue
+			}
+			fmt.Printf("%-14s %-10s %12.1f %10d %12d\n", r.Limiter, r.Call, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp)
+		}
+	}
+}
+
+// selectLimiters returns the limiters named in the comma-separated list,
+// or all of them for an empty list.
+func selectLimiters(list string) ([]limiter, error) {
+	if list == "" {
+		return limiters, nil
+	}
+	var selected []limiter
+next:
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		for _, l := range limiters {
+			if strings.EqualFold(l.name, name) {
+				selected = append(selected, l)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("unknown limiter %q", name)
+	}
+	return selected, nil
+}
+
+// measure benchmarks AllowN(n) on limiter after warming it up.
+func measure(l ratelimit.Limiter, n int, parallel bool) Result {
+	for end := time.Now().Add(5 * time.Millisecond); time.Now().Before(end); {
+		l.AllowN(n)
+	}
+	result := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		if parallel {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					l.AllowN(n)
+				}
+			})
+			return
+		}
+		for i := 0; i < b.N; i++ {
+			l.AllowN(n)
+		}
+	})
+	return Result{
+		Parallel:    parallel,
+		Ops:         result.N,
+		NsPerOp:     float64(result.T.Nanoseconds()) / float64(max(result.N, 1)),
+		BytesPerOp:  result.AllocedBytesPerOp(),
+		AllocsPerOp: result.AllocsPerOp(),
+	}
+}
@@ -0,0 +1,73 @@
+// Command benchcheck guards the allocation-free hot path of the limiters.
+// It runs BenchmarkAllowParallel and BenchmarkAllowNParallel of package
+// ratelimit and fails if Allow or AllowN allocates, by count or by bytes:
+//
+//	go run ./internal/benchcheck        fail if the hot path allocates
+//	go run ./internal/benchcheck -v     also print the timings
+//
+// Allocations do not depend on the machine, so unlike timings they can
+// gate changes anywhere. Bytes are checked as well as allocations because
+// buffers growing now and then amortize to 0 allocs/op while still
+// allocating. SlidingLog is exempt from the bytes check: it records every
+// request and drops idle keys, so its memory follows the traffic by
+// design.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// result matches a benchmark result line reported with -benchmem.
+var result = regexp.MustCompile(`^(Benchmark\w+/(\w+))\S*\s+\d+\s+\S+ ns/op\s+(\d+) B/op\s+(\d+) allocs/op`)
+
+// growing lists the limiters whose buffers follow the traffic, for which
+// only allocations are checked.
+var growing = map[string]bool{"SlidingLog": true}
+
+func main() {
+	verbose := flag.Bool("v", false, "print the timings")
+	flag.Parse()
+	log.SetFlags(0)
+
+	cmd := exec.Command("go", "test", "-run", "^$", "-bench", "^BenchmarkAllowN?Parallel$", "-benchmem", "./ratelimit")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		os.Stdout.Write(out)
+		log.Fatalf("benchcheck: %v", err)
+	}
+
+	failed := false
+	checked := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := result.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		checked++
+		if *verbose {
+			fmt.Println(scanner.Text())
+		}
+		bytesPerOp, _ := strconv.Atoi(m[3])
+		allocsPerOp, _ := strconv.Atoi(m[4])
+		if allocsPerOp > 0 || bytesPerOp > 0 && !growing[m[2]] {
+			log.Printf("%s: %d B/op, %d allocs/op, want 0", m[1], bytesPerOp, allocsPerOp)
+			failed = true
+		}
+	}
+	if checked == 0 {
+		log.Fatal("benchcheck: no benchmark results")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,199 @@
+// Package cli holds what the rrl commands share: subcommand dispatch,
+// flag parsing with a common config file, and JSON output.
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConfigEnv names the environment variable that supplies the config file
+// when -config is not given.
+const ConfigEnv = "RRL_CONFIG"
+
+// Command is one subcommand. A command either runs or groups further
+// subcommands.
+type Command struct {
+	Name     string
+	Summary  string
+	Run      func(args []string)
+	Commands []*Command
+}
+
+// Dispatch runs the subcommand named by args[0] among commands, or prints
+// usage and exits with status 2. prog is the command path so far, used in
+// messages.
+func Dispatch(prog string, commands []*Command, args []string) {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage(prog, commands)
+		if len(args) == 0 {
+			os.Exit(2)
+		}
+		return
+	}
+
+	for _, c := range commands {
+		if c.Name != args[0] {
+			continue
+		}
+		if c.Run != nil {
+			c.Run(args[1:])
+		} else {
+			Dispatch(prog+" "+c.Name, c.Commands, args[1:])
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", prog, args[0])
+	usage(prog, commands)
+	os.Exit(2)
+}
+
+func usage(prog string, commands []*Command) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", prog)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", prog)
+}
+
+// Parse parses args into fs after adding a -config flag. Flags not given
+// on the command line are then taken from the config file, if any.
+//
+// The config file is JSON with one object per command, keyed by the name
+// of fs, holding flag values by flag name:
+//
+//	{
+//	  "client": {"server": "10.0.0.5:8080", "rate": 500, "label": ["env=staging"]},
+//	  "server": {"protocol": "udp", "port": 9000}
+//	}
+//
+// Arrays set a repeatable flag once per element. Commands without a
+// section use only their defaults and the command line.
+func Parse(fs *flag.FlagSet, args []string) {
+	path := fs.String("config", os.Getenv(ConfigEnv), "JSON config file with flag defaults per command (default $"+ConfigEnv+")")
+	fs.Parse(args)
+
+	if *path == "" {
+		return
+	}
+	if err := applyConfig(fs, *path); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Name(), err)
+		os.Exit(2)
+	}
+}
+
+// applyConfig sets the flags of fs that were not given on the command line
+// from fs's section of the config file at path.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sections map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("config %s: %v", path, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	section := sections[fs.Name()]
+	names := make([]string, 0, len(section))
+	for name := range section {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" {
+			return fmt.Errorf("config %s: %s.config is not allowed", path, fs.Name())
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config %s: %s has no flag -%s", path, fs.Name(), name)
+		}
+		if given[name] {
+			continue
+		}
+		values, err := configValues(section[name])
+		if err != nil {
+			return fmt.Errorf("config %s: %s.%s: %v", path, fs.Name(), name, err)
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("config %s: %s.%s: %v", path, fs.Name(), name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues converts a config value to the flag values it stands for.
+func configValues(raw json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			v, err := configValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	v, err := configValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []string{v}, nil
+}
+
+// configValue converts a string, number or bool to its flag form.
+func configValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v.(type) {
+	case float64, bool:
+		return strings.TrimSpace(string(raw)), nil
+	}
+	return "", fmt.Errorf("want a string, number, bool or array, got %s", raw)
+}
+
+// WriteJSON writes v as indented JSON to path, or to stdout if path is
+// "-".
+func WriteJSON(path string, v interface{}, perm os.FileMode) error {
+	if path == "-" {
+		return EncodeJSON(os.Stdout, v)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := EncodeJSON(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// EncodeJSON writes v to w as indented JSON followed by a newline.
+func EncodeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
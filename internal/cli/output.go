@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output formats selected with -output.
+const (
+	// FormatText is the human-readable output the commands print by
+	// default.
+	FormatText = "text"
+
+	// FormatJSON writes one JSON object per line.
+	FormatJSON = "json"
+
+	// FormatCSV writes a header row followed by one row per record.
+	FormatCSV = "csv"
+)
+
+// ValidFormat reports whether format is a known output format.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatText, FormatJSON, FormatCSV:
+		return true
+	}
+	return false
+}
+
+// RecordWriter writes machine-readable records, such as periodic stats
+// snapshots, for dashboards and run-to-run comparison. Records are flat
+// structs; their json tags name the JSON fields and the CSV columns, so
+// every record written to one RecordWriter must have the same type. It is
+// safe for concurrent use.
+type RecordWriter struct {
+	mu     sync.Mutex
+	format string
+	enc    *json.Encoder
+	csv    *csv.Writer
+	header bool
+}
+
+// NewRecordWriter returns a RecordWriter writing format, FormatJSON or
+// FormatCSV, to w.
+func NewRecordWriter(w io.Writer, format string) *RecordWriter {
+	r := &RecordWriter{format: format}
+	if format == FormatCSV {
+		r.csv = csv.NewWriter(w)
+	} else {
+		r.enc = json.NewEncoder(w)
+	}
+	return r
+}
+
+// Write writes one record.
+func (r *RecordWriter) Write(record interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.enc != nil {
+		return r.enc.Encode(record)
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("cli: CSV record must be a struct, got %T", record)
+	}
+	t := v.Type()
+	if !r.header {
+		names := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := columnName(t.Field(i)); ok {
+				names = append(names, name)
+			}
+		}
+		r.csv.Write(names)
+		r.header = true
+	}
+	row := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := columnName(t.Field(i)); ok {
+			row = append(row, formatColumn(v.Field(i)))
+		}
+	}
+	r.csv.Write(row)
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+// columnName returns the CSV column name of f from its json tag, and
+// false if the field is not written.
+func columnName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return f.Name, true
+	}
+	return name, true
+}
+
+// formatColumn formats a field value for CSV.
+func formatColumn(v reflect.Value) string {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	}
+	return fmt.Sprint(v.Interface())
+}
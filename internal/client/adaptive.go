@@ -0,0 +1,167 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Adaptive mode backs off when the server rate limits and creeps back up
+// otherwise, additive increase and multiplicative decrease, so the offered
+// rate settles just around the server's limit.
+const (
+	// adaptiveDecrease scales the rate down on each rate limit response.
+	adaptiveDecrease = 0.8
+	// adaptiveIncrease is the fraction of -rate added back per second
+	// without rate limit responses.
+	adaptiveIncrease = 0.05
+)
+
+// AdaptiveConfig holds the settings of adaptive mode.
+type AdaptiveConfig struct {
+	Enabled    bool
+	Backoff    time.Duration // first pause without Retry-After, doubled per rejection
+	MaxBackoff time.Duration
+}
+
+// adaptive is the rate controller shared by the senders of an adaptive run.
+// A nil *adaptive is a run without one; its methods do nothing.
+type adaptive struct {
+	config AdaptiveConfig
+	max    float64 // -rate
+
+	mu      sync.Mutex
+	rate    float64   // offered rate at since
+	since   time.Time // when rate was last lowered
+	until   time.Time // sends are held until then
+	backoff time.Duration
+
+	backoffs    int64
+	retryAfters int64
+}
+
+func newAdaptive(config AdaptiveConfig, rate int) *adaptive {
+	return &adaptive{
+		config:  config,
+		max:     float64(rate),
+		rate:    float64(rate),
+		since:   time.Now(),
+		backoff: config.Backoff,
+	}
+}
+
+// current returns the rate to offer now.
+func (a *adaptive) current() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.currentLocked(time.Now())
+}
+
+func (a *adaptive) currentLocked(now time.Time) float64 {
+	grown := a.rate + a.max*adaptiveIncrease*now.Sub(a.since).Seconds()
+	return math.Max(math.Min(grown, a.max), 1)
+}
+
+// holdUntil returns the time sends are held until.
+func (a *adaptive) holdUntil() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.until
+}
+
+// rejected records a rate limit response. Sends pause for retryAfter if
+// the server gave one, or else for an exponentially growing backoff, and
+// the rate is lowered. Responses arriving during a pause belong to the same
+// overload and are ignored.
+func (a *adaptive) rejected(retryAfter time.Duration) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if now.Before(a.until) {
+		return
+	}
+	a.rate = a.currentLocked(now) * adaptiveDecrease
+	a.since = now
+	a.backoffs++
+	if retryAfter > 0 {
+		a.retryAfters++
+		a.until = now.Add(retryAfter)
+		return
+	}
+	a.until = now.Add(a.backoff)
+	a.backoff = min(2*a.backoff, a.config.MaxBackoff)
+}
+
+// succeeded records an accepted message, which resets the backoff.
+func (a *adaptive) succeeded() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.backoff = a.config.Backoff
+	a.mu.Unlock()
+}
+
+// retryAfter parses the Retry-After header of resp, in seconds or as an
+// HTTP date, and returns 0 without one.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// AdaptiveSummary is the outcome of an adaptive run.
+type AdaptiveSummary struct {
+	SteadyRate  float64  `json:"steady_state_rate"` // accepted messages per second
+	Window      Duration `json:"window"`            // over the end of the run
+	FinalRate   float64  `json:"final_offered_rate"`
+	Backoffs    int64    `json:"backoffs"`
+	RetryAfters int64    `json:"retry_after_honored"`
+}
+
+// adaptiveSummary summarizes an adaptive run from its timeline: the
+// accepted rate over the second half of the run, once the rate has
+// settled.
+func adaptiveSummary(a *adaptive, timeline []TimelinePoint) *AdaptiveSummary {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	s := &AdaptiveSummary{
+		FinalRate:   a.currentLocked(time.Now()),
+		Backoffs:    a.backoffs,
+		RetryAfters: a.retryAfters,
+	}
+	a.mu.Unlock()
+
+	window := timeline[len(timeline)/2:]
+	var accepted int64
+	for _, p := range window {
+		accepted += p.Sent - p.Rejected
+	}
+	if len(window) > 0 {
+		s.Window = Duration{time.Duration(len(window)) * time.Second}
+		s.SteadyRate = float64(accepted) / s.Window.Seconds()
+	}
+	return s
+}
+
+// describeAdaptive formats s for the stats output.
+func describeAdaptive(s *AdaptiveSummary) string {
+	return fmt.Sprintf("steady state %.2f messages/second accepted over the last %s (offered %.2f at the end), %d backoffs, %d honoring Retry-After",
+		s.SteadyRate, s.Window, s.FinalRate, s.Backoffs, s.RetryAfters)
+}
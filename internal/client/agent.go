@@ -0,0 +1,351 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// A distributed run spreads the load of one client over several machines.
+// Each machine runs an agent (rrl client agent); the client given -agents
+// coordinates: it splits the rate across the agents, sends each its part
+// of the run with a common start time, and merges the statistics the
+// agents send back into one report. Coordinator and agents speak gRPC with
+// JSON messages:
+//
+//	service Agent {
+//	  rpc Run(AgentRequest) returns (AgentResult);
+//	}
+//
+// The standard library speaks HTTP/2 only over TLS, so agents always serve
+// TLS, and coordinators authenticate with a shared token.
+const grpcAgentRun = "/rrl.Agent/Run"
+
+// AgentTokenEnv names the environment variable with the agent token when
+// -token or -agent-token is not given.
+const AgentTokenEnv = "RRL_AGENT_TOKEN"
+
+// agentLead is how far in the future a distributed run starts, so that
+// every agent has its part by then.
+const agentLead = 2 * time.Second
+
+// agentMaxLead bounds how far ahead of its clock an agent accepts a start
+// time, which catches clocks far apart.
+const agentMaxLead = time.Minute
+
+// agentRequest is one agent's part of a distributed run.
+type agentRequest struct {
+	Config   Config    `json:"config"`
+	Scenario *Scenario `json:"scenario,omitempty"`
+	Start    time.Time `json:"start"`
+}
+
+// agentResult is what an agent sends back: the statistics of the run, or
+// of each scenario phase.
+type agentResult struct {
+	Phases []agentStats `json:"phases"`
+}
+
+// agentStats are the statistics of a run or phase in transit from an agent
+// to the coordinator.
+type agentStats struct {
+	Elapsed     Duration        `json:"elapsed"`
+	Sent        int64           `json:"sent"`
+	Succeeded   int64           `json:"succeeded"`
+	Failed      int64           `json:"failed"`
+	RateLimited int64           `json:"rate_limited"`
+	StatusCodes map[int]int64   `json:"status_codes,omitempty"`
+	GRPCCodes   map[int]int64   `json:"grpc_codes,omitempty"`
+	Latency     histogramData   `json:"latency"`
+	Connect     histogramData   `json:"connect"`
+	Handshake   histogramData   `json:"tls_handshake"`
+	SendError   histogramData   `json:"send_error"`
+	Delivery    *Delivery       `json:"delivery,omitempty"`
+	Timeline    []TimelinePoint `json:"timeline,omitempty"`
+	Users       *userData       `json:"users,omitempty"`
+}
+
+// exportStats returns stats collected over elapsed for sending to the
+// coordinator.
+func exportStats(stats *Stats, elapsed time.Duration) agentStats {
+	a := agentStats{
+		Elapsed:     Duration{elapsed},
+		Sent:        atomic.LoadInt64(&stats.Sent),
+		Succeeded:   atomic.LoadInt64(&stats.Succeeded),
+		Failed:      atomic.LoadInt64(&stats.Failed),
+		RateLimited: atomic.LoadInt64(&stats.RateLimited),
+		StatusCodes: stats.statusCodes.snapshot(),
+		GRPCCodes:   stats.grpcCodes.snapshot(),
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	a.Latency = stats.latency.export()
+	a.Connect = stats.connect.export()
+	a.Handshake = stats.handshake.export()
+	a.SendError = stats.sendError.export()
+	a.Delivery = stats.delivery
+	a.Timeline = stats.timeline
+	a.Users = stats.users.export()
+	return a
+}
+
+// mergeAgent adds the statistics of one agent to s.
+func (s *Stats) mergeAgent(a *agentStats) {
+	atomic.AddInt64(&s.Sent, a.Sent)
+	atomic.AddInt64(&s.Succeeded, a.Succeeded)
+	atomic.AddInt64(&s.Failed, a.Failed)
+	atomic.AddInt64(&s.RateLimited, a.RateLimited)
+	s.statusCodes.merge(a.StatusCodes)
+	s.grpcCodes.merge(a.GRPCCodes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency.mergeData(a.Latency)
+	s.connect.mergeData(a.Connect)
+	s.handshake.mergeData(a.Handshake)
+	s.sendError.mergeData(a.SendError)
+	if a.Users != nil {
+		if s.users == nil {
+			s.users = newUserCounts(len(a.Users.Sent))
+		}
+		s.users.mergeData(a.Users)
+	}
+	if d := a.Delivery; d != nil {
+		if s.delivery == nil {
+			s.delivery = &Delivery{}
+		}
+		s.delivery.Received += d.Received
+		s.delivery.Lost += d.Lost
+		s.delivery.Duplicated += d.Duplicated
+		s.delivery.Reordered += d.Reordered
+	}
+	// Agents start together, so their timelines line up second by second.
+	for i, p := range a.Timeline {
+		if i == len(s.timeline) {
+			s.timeline = append(s.timeline, TimelinePoint{Elapsed: p.Elapsed})
+		}
+		t := &s.timeline[i]
+		t.OfferedRate += p.OfferedRate
+		t.ActualRate += p.ActualRate
+		t.Sent += p.Sent
+		t.Rejected += p.Rejected
+	}
+}
+
+// AgentMain runs a worker agent for distributed runs with the given
+// command-line arguments.
+func AgentMain(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	port := fs.Int("port", 7070, "Port to listen on")
+	token := fs.String("token", os.Getenv(AgentTokenEnv), "Token coordinators must present (default $"+AgentTokenEnv+", or a random one that is printed)")
+	certFile := fs.String("tls-cert", "", "PEM certificate (default a self-signed one)")
+	keyFile := fs.String("tls-key", "", "PEM private key of -tls-cert")
+	cli.Parse(fs, args)
+
+	if (*certFile == "") != (*keyFile == "") {
+		log.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+	var cert tls.Certificate
+	var err error
+	if *certFile != "" {
+		cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+	} else {
+		cert, err = wire.SelfSignedCertificate("rate limit test agent")
+	}
+	if err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+
+	a := &agent{token: *token}
+	if a.token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalf("Failed to generate a token: %v", err)
+		}
+		a.token = hex.EncodeToString(b)
+	}
+
+	addr := fmt.Sprintf(":%d", *port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	server := &http.Server{
+		Handler:   http.HandlerFunc(a.serve),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		ErrorLog:  log.New(io.Discard, "", 0),
+	}
+
+	fmt.Fprintf(console, "Agent listening on %s\n", addr)
+	if *certFile != "" {
+		fmt.Fprintf(console, "TLS: certificate %s\n", *certFile)
+	} else {
+		fmt.Fprintf(console, "TLS: self-signed certificate, SHA-256 %s\n", wire.Fingerprint(cert))
+	}
+	if *token == "" {
+		fmt.Fprintf(console, "Token: %s\n", a.token)
+	}
+	if err := server.ServeTLS(listener, "", ""); err != http.ErrServerClosed {
+		log.Fatalf("Agent failed: %v", err)
+	}
+}
+
+// agent serves runs to coordinators, one at a time.
+type agent struct {
+	token string
+	busy  sync.Mutex
+}
+
+// serve handles one RPC to the agent service.
+func (a *agent) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || r.Header.Get("Content-Type") != wire.GRPCJSONContentType {
+		http.Error(w, "gRPC requests with JSON messages only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", wire.GRPCJSONContentType)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	var code int
+	var message string
+	switch {
+	case subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1:
+		code, message = wire.GRPCUnauthenticated, "invalid token"
+	case r.URL.Path != grpcAgentRun:
+		code, message = wire.GRPCUnimplemented, "unknown method "+r.URL.Path
+	default:
+		code, message = a.run(r.Context(), w, r.Body, r.RemoteAddr)
+	}
+	if code != wire.GRPCOK {
+		log.Printf("Run from %s: %s: %s", r.RemoteAddr, wire.GRPCCodeName(code), message)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", message)
+	}
+}
+
+// run carries out the part of a distributed run in the request read from
+// body and writes the result to w. It returns the RPC status.
+func (a *agent) run(ctx context.Context, w io.Writer, body io.Reader, from string) (int, string) {
+	msg, _, err := wire.ReadGRPCFrame(body, nil)
+	if err != nil {
+		return wire.GRPCInvalidArgument, err.Error()
+	}
+	var req agentRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return wire.GRPCInvalidArgument, err.Error()
+	}
+	config := &req.Config
+	if err := prepareAgentConfig(config, req.Scenario); err != nil {
+		return wire.GRPCInvalidArgument, err.Error()
+	}
+	wait := time.Until(req.Start)
+	if wait > agentMaxLead {
+		return wire.GRPCInvalidArgument, fmt.Sprintf("start time %s ahead; are the clocks in sync?", wait.Round(time.Second))
+	}
+
+	if !a.busy.TryLock() {
+		return wire.GRPCUnavailable, "agent is busy with another run"
+	}
+	defer a.busy.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return wire.GRPCCanceled, "canceled"
+	case <-time.After(wait):
+	}
+	if wait < 0 {
+		log.Printf("Run from %s: starting %s late", from, (-wait).Round(time.Millisecond))
+	}
+
+	var result agentResult
+	if req.Scenario != nil {
+		fmt.Fprintf(console, "Run from %s: scenario %s (%d phases)\n", from, req.Scenario.Name, len(req.Scenario.Phases))
+		for _, pr := range runScenario(ctx, req.Scenario, config) {
+			result.Phases = append(result.Phases, exportStats(pr.Stats, pr.Elapsed))
+		}
+	} else {
+		fmt.Fprintf(console, "Run from %s: %s %d messages/second for %s\n", from, config.Protocol, config.Rate, config.Duration)
+		stats := &Stats{StartTime: time.Now()}
+		runCtx, cancel := context.WithTimeout(ctx, config.Duration)
+		runTest(runCtx, config, stats)
+		cancel()
+		result.Phases = append(result.Phases, exportStats(stats, time.Since(stats.StartTime)))
+	}
+	if ctx.Err() != nil {
+		return wire.GRPCCanceled, "coordinator went away"
+	}
+
+	for _, p := range result.Phases {
+		fmt.Fprintf(console, "Run from %s: %d sent, %d succeeded, %d rate limited, %d failed\n",
+			from, p.Sent, p.Succeeded, p.RateLimited, p.Failed)
+	}
+	out, err := json.Marshal(&result)
+	if err != nil {
+		return wire.GRPCInternal, err.Error()
+	}
+	if _, err := w.Write(wire.AppendGRPCFrame(nil, out)); err != nil {
+		return wire.GRPCUnavailable, err.Error()
+	}
+	return wire.GRPCOK, ""
+}
+
+// prepareAgentConfig checks a config received from a coordinator and sets
+// it up as parseFlags would. Files it names, such as -tls-ca or -body
+// @file, are read on the agent.
+func prepareAgentConfig(config *Config, scenario *Scenario) error {
+	switch {
+	case !validProtocol(config.Protocol):
+		return fmt.Errorf("invalid protocol %q", config.Protocol)
+	case !validPacing(config.Pacing):
+		return fmt.Errorf("invalid pacing %q", config.Pacing)
+	case !validRamp(config.Ramp.Profile):
+		return fmt.Errorf("invalid ramp %q", config.Ramp.Profile)
+	case config.Rate < 1 || config.Connections < 1:
+		return errors.New("rate and connections must be positive")
+	case scenario == nil && config.Duration <= 0:
+		return errors.New("duration must be positive")
+	case scenario != nil && len(scenario.Phases) == 0:
+		return errors.New("scenario has no phases")
+	case config.Adaptive.Enabled || config.Coordinate != "" || len(config.Agents.Addrs) > 0:
+		return errors.New("adaptive, coordinated and distributed runs cannot be run by an agent")
+	}
+	if scenario != nil {
+		for _, phase := range scenario.Phases {
+			if phase.Duration.Duration <= 0 || phase.Protocol != "" && !validProtocol(phase.Protocol) {
+				return fmt.Errorf("invalid phase %s", phase.Name)
+			}
+			c := phaseConfig(config, phase)
+			if err := validUsers(&c.Users, c.Protocol, c.GRPC.Mode); err != nil {
+				return fmt.Errorf("phase %s: %v", phase.Name, err)
+			}
+		}
+	}
+	if err := validUsers(&config.Users, config.Protocol, config.GRPC.Mode); err != nil {
+		return err
+	}
+	tlsConfig, err := clientTLS(config.TLS, config.ServerAddr)
+	if err != nil {
+		return err
+	}
+	config.tls = tlsConfig
+	return nil
+}
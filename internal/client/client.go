@@ -0,0 +1,902 @@
+// Package client is the rate limit test client. It backs both the client
+// binary at the module root and rrl client run.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+type Config struct {
+	ServerAddr       string
+	Protocol         string
+	Rate             int
+	Duration         time.Duration
+	Connections      int
+	MessageSize      int
+	ScenarioFile     string
+	Pacing           string
+	SpinAhead        time.Duration
+	Coordinate       string
+	Labels           Labels
+	ReportFile       string
+	LatencyFile      string
+	Ramp             RampConfig
+	Adaptive         AdaptiveConfig
+	HTTP             HTTPConfig
+	GRPC             GRPCConfig
+	TLS              TLSConfig
+	ReconnectEvery   int
+	Output           string
+	SnapshotInterval time.Duration
+	TUI              bool
+	Agents           AgentConfig
+	Users            UserConfig
+	Replay           ReplayConfig
+	
+	// tls is set when TLS is enabled.
+	tls *tls.Config
+	// coordination is set when Coordinate joined a coordinated run.
+	coordination *coordination
+	// records is set when Output selects a machine-readable format.
+	records *cli.RecordWriter
+	// phase is the name of the scenario phase being run, if any.
+	phase string
+	// replay is the log loaded for Replay.
+	replay *replayLog
+}
+
+type Stats struct {
+	Sent        int64
+	Succeeded   int64
+	Failed      int64
+	RateLimited int64 // HTTP 429, gRPC RESOURCE_EXHAUSTED and server rejections, not counted as failed
+	StartTime   time.Time
+	
+	mu          sync.Mutex
+	sendError   sendErrors
+	latency     latencyHistogram
+	statusCodes statusCodes
+	grpcCodes   statusCodes
+	timeline    []TimelinePoint
+	delivery    *Delivery
+	adaptive    *adaptive
+	live        *liveStats // set with -tui
+	users       *userCounts // set with -users
+	connect     latencyHistogram // TCP connection setup
+	handshake   latencyHistogram // TLS handshakes
+}
+
+// addSendErrors merges a sender's send-time errors into the totals.
+func (s *Stats) addSendErrors(e *sendErrors) {
+	s.mu.Lock()
+	s.sendError.merge(e)
+	s.mu.Unlock()
+}
+
+// addLatency merges a worker's request latencies into the totals.
+func (s *Stats) addLatency(h *latencyHistogram) {
+	s.mu.Lock()
+	s.latency.merge(h)
+	s.mu.Unlock()
+}
+
+// addConnectLatency merges a worker's connection setup and TLS handshake
+// times into the totals.
+func (s *Stats) addConnectLatency(connect, handshake *latencyHistogram) {
+	s.mu.Lock()
+	s.connect.merge(connect)
+	s.handshake.merge(handshake)
+	s.mu.Unlock()
+}
+
+// setDelivery records the UDP delivery accounting of a run.
+func (s *Stats) setDelivery(d *Delivery) {
+	s.mu.Lock()
+	s.delivery = d
+	s.mu.Unlock()
+}
+
+// Main runs the test client with the given command-line arguments.
+func Main(args []string) {
+	config := parseFlags(args)
+	if config.Output != cli.FormatText {
+		console = os.Stderr
+		config.records = cli.NewRecordWriter(os.Stdout, config.Output)
+	}
+	
+	fmt.Fprintf(console, "Starting rate limit test client\n")
+	fmt.Fprintf(console, "Protocol: %s\n", config.Protocol)
+	fmt.Fprintf(console, "Server: %s\n", config.ServerAddr)
+	if config.Protocol == "http" {
+		fmt.Fprintf(console, "Request: %s %s\n", config.HTTP.Method, requestURL(config))
+	}
+	if config.Protocol == "grpc" {
+		fmt.Fprintf(console, "gRPC: %s\n", describeGRPC(config))
+	}
+	if config.tls != nil {
+		fmt.Fprintf(console, "TLS: %s\n", describeTLS(config.TLS))
+	}
+	if config.ReconnectEvery > 0 {
+		fmt.Fprintf(console, "Reconnect: every %d messages\n", config.ReconnectEvery)
+	}
+	if len(config.Labels) > 0 {
+		fmt.Fprintf(console, "Labels: %s\n", config.Labels)
+	}
+	if config.Users.Count > 0 {
+		fmt.Fprintf(console, "Users: %s\n", describeUsers(config.Users))
+	}
+	if len(config.Agents.Addrs) > 0 {
+		fmt.Fprintf(console, "Agents: %s\n", describeAgents(config))
+	}
+	
+	report := newRunReport(config, time.Now())
+	
+	if config.ScenarioFile != "" {
+		scenario, err := loadScenario(config.ScenarioFile)
+		if err != nil {
+			log.Fatalf("Failed to load scenario: %v", err)
+		}
+		for _, phase := range scenario.Phases {
+			c := phaseConfig(config, phase)
+			if err := validUsers(&c.Users, c.Protocol, c.GRPC.Mode); err != nil {
+				log.Fatalf("Phase %s: invalid user settings: %v", phase.Name, err)
+			}
+		}
+		fmt.Fprintf(console, "Scenario: %s (%d phases)\n\n", scenario.Name, len(scenario.Phases))
+		
+		var results []*PhaseResult
+		if len(config.Agents.Addrs) > 0 {
+			results = runOnAgents(config, scenario)
+		} else {
+			results = runScenario(context.Background(), scenario, config)
+		}
+		passed := printScenarioReport(scenario, results)
+		
+		report.addPhases(results)
+		saveReport(config, report)
+		names := make([]string, len(results))
+		histograms := make([]*latencyHistogram, len(results))
+		for i, result := range results {
+			names[i] = result.Phase.Name
+			histograms[i] = &result.Stats.latency
+		}
+		saveLatency(config, names, histograms)
+		if !passed {
+			os.Exit(1)
+		}
+		return
+	}
+	
+	if config.replay != nil {
+		fmt.Fprintf(console, "Replay: %s\n", describeReplay(config))
+	} else if config.Ramp.Profile != "" {
+		fmt.Fprintf(console, "Ramp: %s\n", describeRamp(config))
+	} else if config.Adaptive.Enabled {
+		fmt.Fprintf(console, "Rate: up to %d messages/second, adapting to rate limiting\n", config.Rate)
+	} else {
+		fmt.Fprintf(console, "Rate: %d messages/second\n", config.Rate)
+	}
+	fmt.Fprintf(console, "Duration: %s\n", config.Duration)
+	fmt.Fprintf(console, "Connections: %d\n", config.Connections)
+	fmt.Fprintf(console, "Message size: %d bytes\n", config.MessageSize)
+	fmt.Fprintf(console, "Pacing: %s\n", config.Pacing)
+	
+	if config.Coordinate != "" {
+		coord, err := joinCoordination(config.Coordinate, config.Rate)
+		if err != nil {
+			log.Fatalf("Failed to coordinate: %v", err)
+		}
+		defer coord.close()
+		config.coordination = coord
+	}
+	fmt.Fprintln(console)
+	
+	var stats *Stats
+	var elapsed time.Duration
+	if len(config.Agents.Addrs) > 0 {
+		result := runOnAgents(config, nil)[0]
+		stats, elapsed = result.Stats, result.Elapsed
+	} else {
+		stats = &Stats{StartTime: time.Now()}
+		ctx, cancel := context.WithTimeout(context.Background(), config.Duration)
+		runTest(ctx, config, stats)
+		cancel()
+		elapsed = time.Since(stats.StartTime)
+	}
+	
+	printStats(stats, elapsed)
+	writeFinal(config, stats, elapsed)
+	
+	result := runResult(stats, elapsed)
+	report.Result = &result
+	saveReport(config, report)
+	saveLatency(config, []string{""}, []*latencyHistogram{&stats.latency})
+}
+
+// saveReport writes the run report if -report was given.
+func saveReport(config *Config, report *RunReport) {
+	if config.ReportFile == "" {
+		return
+	}
+	if err := writeReport(config.ReportFile, report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	if config.ReportFile != "-" {
+		fmt.Fprintf(console, "Report written to %s\n", config.ReportFile)
+	}
+}
+
+// saveLatency writes the raw latency histograms if -latency-histogram was
+// given.
+func saveLatency(config *Config, names []string, histograms []*latencyHistogram) {
+	if config.LatencyFile == "" {
+		return
+	}
+	if err := writeLatencyHistograms(config.LatencyFile, names, histograms); err != nil {
+		log.Fatalf("Failed to write latency histogram: %v", err)
+	}
+	if config.LatencyFile != "-" {
+		fmt.Fprintf(console, "Latency histogram written to %s\n", config.LatencyFile)
+	}
+}
+
+func parseFlags(args []string) *Config {
+	config := &Config{Labels: Labels{}, HTTP: HTTPConfig{Headers: Headers{}}}
+	
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	fs.StringVar(&config.ServerAddr, "server", "localhost:8080", "Server address")
+	fs.StringVar(&config.Protocol, "protocol", "tcp", "Protocol (tcp, udp, http or grpc)")
+	fs.IntVar(&config.Rate, "rate", 100, "Messages per second")
+	fs.DurationVar(&config.Duration, "duration", 10*time.Second, "Test duration")
+	fs.IntVar(&config.Connections, "connections", 1, "Number of concurrent connections (TCP, HTTP and gRPC)")
+	fs.IntVar(&config.MessageSize, "size", 64, "Message size in bytes (at least 16 over UDP)")
+	fs.StringVar(&config.ScenarioFile, "scenario", "", "Scenario file describing test phases (JSON or YAML)")
+	fs.StringVar(&config.Pacing, "pacing", PacingTicker, "Pacing engine: ticker, precise (sleep then busy-wait) or spin (busy-wait)")
+	fs.DurationVar(&config.SpinAhead, "spin-ahead", 200*time.Microsecond, "How long before each send precise pacing starts busy-waiting")
+	fs.StringVar(&config.Coordinate, "coordinate", "", "Unix socket through which client processes on this host share one -rate budget")
+	fs.Var(config.Labels, "label", "Label key=value recorded in reports, repeatable; a value of @file records the file's SHA-256")
+	fs.StringVar(&config.ReportFile, "report", "", "Write a JSON report of the run, including labels, to this file (- for stdout)")
+	fs.StringVar(&config.LatencyFile, "latency-histogram", "", "Write the raw latency histogram in HdrHistogram percentile format (ms) to this file (- for stdout)")
+	fs.StringVar(&config.Ramp.Profile, "ramp", "", "Ramp the rate from -ramp-from to -rate over the run: linear, step or sine")
+	fs.IntVar(&config.Ramp.From, "ramp-from", 0, "Rate in messages per second at the start of a ramp")
+	fs.IntVar(&config.Ramp.Steps, "ramp-steps", 10, "Number of rate levels of a step ramp")
+	fs.DurationVar(&config.Ramp.Period, "ramp-period", 0, "Period of a sine ramp (default the test duration)")
+	fs.BoolVar(&config.Adaptive.Enabled, "adaptive", false, "Back off on rate limiting, honoring Retry-After, and report the steady-state rate the server accepts")
+	fs.DurationVar(&config.Adaptive.Backoff, "adaptive-backoff", 100*time.Millisecond, "First pause after a rate limit response without Retry-After, doubled on each further one")
+	fs.DurationVar(&config.Adaptive.MaxBackoff, "adaptive-max-backoff", 10*time.Second, "Longest pause without Retry-After")
+	fs.StringVar(&config.HTTP.Method, "method", http.MethodGet, "HTTP request method")
+	fs.StringVar(&config.HTTP.Path, "path", "/", "HTTP request path, with query string if any")
+	fs.Var(config.HTTP.Headers, "header", "HTTP request header \"Name: value\", repeatable")
+	fs.StringVar(&config.HTTP.Body, "body", "", "HTTP request body; @file reads it from a file")
+	fs.DurationVar(&config.HTTP.Timeout, "timeout", 5*time.Second, "HTTP request timeout")
+	fs.StringVar(&config.GRPC.Mode, "grpc-mode", GRPCUnary, "gRPC calls: unary or stream (one bidirectional stream per connection)")
+	fs.DurationVar(&config.GRPC.Deadline, "grpc-deadline", 5*time.Second, "Deadline of each gRPC call; 0 for none")
+	fs.BoolVar(&config.TLS.Enabled, "tls", false, "Connect over TLS (TCP and HTTP; always on for gRPC)")
+	fs.StringVar(&config.TLS.CAFile, "tls-ca", "", "PEM file of CA certificates that verify the server (default the system roots)")
+	fs.StringVar(&config.TLS.CertFile, "tls-cert", "", "PEM client certificate for mutual TLS")
+	fs.StringVar(&config.TLS.KeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	fs.StringVar(&config.TLS.ServerName, "tls-server-name", "", "Server name to verify (default the host of -server)")
+	fs.BoolVar(&config.TLS.Insecure, "tls-insecure", false, "Do not verify the server certificate")
+	fs.IntVar(&config.ReconnectEvery, "reconnect-every", 0, "Open a new TCP connection every this many messages, to measure connection and handshake overhead (default never)")
+	fs.StringVar(&config.Output, "output", cli.FormatText, "Output format: text, json (one object per line) or csv; json and csv go to stdout and the text to stderr")
+	fs.DurationVar(&config.SnapshotInterval, "snapshot-interval", time.Second, "Interval between stats snapshots with -output json or csv")
+	fs.BoolVar(&config.TUI, "tui", false, "Show a live dashboard of rate, success, latency and connections while the test runs")
+	fs.Var(&config.Agents, "agents", "Distribute the run across these agents (host:port, comma-separated or repeated), each sending its share of -rate; see rrl client agent")
+	fs.StringVar(&config.Agents.Token, "agent-token", os.Getenv(AgentTokenEnv), "Token the agents require (default $"+AgentTokenEnv+")")
+	fs.StringVar(&config.Agents.CAFile, "agent-ca", "", "PEM file of CA certificates that verify the agents (default no verification)")
+	fs.IntVar(&config.Users.Count, "users", 0, "Simulate this many distinct users, so keyed rate limiters see many keys (default none)")
+	fs.StringVar(&config.Users.Distribution, "user-distribution", UserUniform, "Distribution of messages over the users: uniform or zipf")
+	fs.Float64Var(&config.Users.ZipfS, "user-zipf-s", 1.1, "Exponent of the zipf distribution, above 1; larger sends more from the busiest users")
+	fs.StringVar(&config.Users.Via, "user-via", "", "How messages carry their user: payload (a user= field), header or port (a source port per user); default header over HTTP, payload otherwise")
+	fs.StringVar(&config.Users.Header, "user-header", "X-User-ID", "HTTP or gRPC header naming the user with -user-via header; X-Forwarded-For and similar get an IP address per user")
+	fs.StringVar(&config.Replay.File, "replay", "", "Replay the requests of this access log at their recorded times instead of sending at -rate")
+	fs.StringVar(&config.Replay.Format, "replay-format", ReplayAuto, "Format of -replay: clf (Common or Combined Log Format), csv (timestamp,key,path) or auto")
+	fs.Float64Var(&config.Replay.Speed, "replay-speed", 1, "Speed of -replay; 2 replays twice as fast")
+	cli.Parse(fs, args)
+	
+	if !cli.ValidFormat(config.Output) {
+		log.Fatalf("Invalid output format: %s", config.Output)
+	}
+	if config.SnapshotInterval <= 0 {
+		log.Fatalf("-snapshot-interval must be positive")
+	}
+	if !validProtocol(config.Protocol) {
+		log.Fatalf("Invalid protocol: %s", config.Protocol)
+	}
+	if config.Protocol == "grpc" {
+		config.TLS.Enabled = true
+		if config.GRPC.Mode != GRPCUnary && config.GRPC.Mode != GRPCStream {
+			log.Fatalf("Invalid gRPC mode: %s", config.GRPC.Mode)
+		}
+		if config.GRPC.Deadline < 0 {
+			log.Fatalf("-grpc-deadline must not be negative")
+		}
+	}
+	tlsConfig, err := clientTLS(config.TLS, config.ServerAddr)
+	if err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+	config.tls = tlsConfig
+	if config.tls != nil && config.Protocol == "udp" {
+		log.Fatalf("-tls is not supported over UDP")
+	}
+	if config.ReconnectEvery < 0 {
+		log.Fatalf("-reconnect-every must not be negative")
+	}
+	if err := validUsers(&config.Users, config.Protocol, config.GRPC.Mode); err != nil {
+		log.Fatalf("Invalid user settings: %v", err)
+	}
+	if config.Users.Via == UserViaPort && config.ReconnectEvery > 0 {
+		log.Fatalf("-user-via port cannot be combined with -reconnect-every")
+	}
+	if !validPacing(config.Pacing) {
+		log.Fatalf("Invalid pacing: %s", config.Pacing)
+	}
+	if config.Adaptive.Enabled {
+		if config.Coordinate != "" {
+			log.Fatalf("-adaptive cannot be combined with -coordinate")
+		}
+		if config.Adaptive.Backoff <= 0 || config.Adaptive.MaxBackoff < config.Adaptive.Backoff {
+			log.Fatalf("-adaptive-backoff must be positive and at most -adaptive-max-backoff")
+		}
+	}
+	if len(config.Agents.Addrs) > 0 {
+		if config.Coordinate != "" || config.Adaptive.Enabled || config.TUI {
+			log.Fatalf("-agents cannot be combined with -coordinate, -adaptive or -tui")
+		}
+		if config.Rate < len(config.Agents.Addrs) {
+			log.Fatalf("-rate must be at least one message per second per agent")
+		}
+	}
+	if config.Replay.File != "" {
+		if config.ScenarioFile != "" || len(config.Agents.Addrs) > 0 || config.Coordinate != "" ||
+			config.Adaptive.Enabled || config.Ramp.Profile != "" || config.Users.Count > 0 {
+			log.Fatalf("-replay cannot be combined with -scenario, -agents, -coordinate, -adaptive, -ramp or -users")
+		}
+		if config.Replay.Speed <= 0 {
+			log.Fatalf("-replay-speed must be positive")
+		}
+		if err := validUserVia(&config.Users, config.Protocol, config.GRPC.Mode); err != nil {
+			log.Fatalf("Invalid user settings: %v", err)
+		}
+		if config.Users.Via == UserViaPort {
+			log.Fatalf("-user-via port is not supported with -replay")
+		}
+		replay, err := loadReplay(config.Replay)
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", config.Replay.File, err)
+		}
+		config.replay = replay
+		// The run ends when every request has been answered, or a second
+		// after the last was due.
+		config.Duration = (time.Duration(float64(replay.span())/config.Replay.Speed) + time.Second).Round(time.Millisecond)
+	}
+	if config.Coordinate != "" && config.ScenarioFile != "" {
+		log.Fatalf("-coordinate cannot be combined with -scenario")
+	}
+	if !validRamp(config.Ramp.Profile) {
+		log.Fatalf("Invalid ramp: %s", config.Ramp.Profile)
+	}
+	if config.Ramp.Profile != "" {
+		if config.Coordinate != "" {
+			log.Fatalf("-coordinate cannot be combined with -ramp")
+		}
+		if config.Adaptive.Enabled {
+			log.Fatalf("-adaptive cannot be combined with -ramp")
+		}
+		if config.Ramp.From < 0 || config.Ramp.Steps < 1 {
+			log.Fatalf("-ramp-from must not be negative and -ramp-steps must be positive")
+		}
+	}
+	
+	return config
+}
+
+// validProtocol reports whether protocol is one the client speaks.
+func validProtocol(protocol string) bool {
+	switch protocol {
+	case "tcp", "udp", "http", "grpc":
+		return true
+	}
+	return false
+}
+
+// runTest dispatches to the protocol-specific test runner. Ramped and
+// adaptive runs also record a timeline of each second, machine-readable
+// output gets periodic snapshots, and -tui shows the live dashboard.
+func runTest(ctx context.Context, config *Config, stats *Stats) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// Samplers and the dashboard stop once the senders are done, such
+	// as at the end of a replay.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rate := rampRate(config)
+	if config.Adaptive.Enabled {
+		a := newAdaptive(config.Adaptive, config.Rate)
+		stats.adaptive = a
+		rate = func(time.Duration) float64 { return a.current() }
+	}
+	if rate != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sampleTimeline(ctx, rate, stats)
+		}()
+	}
+	if config.records != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writeSnapshots(ctx, config, stats)
+		}()
+	}
+	if config.replay != nil {
+		stats.users = newUserCounts(len(config.replay.keys))
+	} else {
+		stats.users = newUserCounts(config.Users.Count)
+	}
+	if config.TUI {
+		senders := config.Connections
+		if config.Protocol == "udp" {
+			senders = 1
+		}
+		stats.live = newLiveStats(senders)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runDashboard(ctx, config, stats)
+		}()
+	}
+	
+	switch config.Protocol {
+	case "tcp":
+		runTCPTest(ctx, config, stats)
+	case "udp":
+		runUDPTest(ctx, config, stats)
+	case "http":
+		runHTTPTest(ctx, config, stats)
+	case "grpc":
+		runGRPCTest(ctx, config, stats)
+	default:
+		log.Fatalf("Invalid protocol: %s", config.Protocol)
+	}
+}
+
+func runTCPTest(ctx context.Context, config *Config, stats *Stats) {
+	var wg sync.WaitGroup
+	
+	var users *userConns
+	if config.Users.Via == UserViaPort {
+		users = &userConns{}
+		defer users.close()
+	}
+	
+	for i := 0; i < config.Connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			tcpWorker(ctx, id, config, stats, users)
+		}(i)
+	}
+	
+	wg.Wait()
+}
+
+// tcpWorker sends messages over its connection, or with -user-via port
+// over the connection of each message's user in users.
+func tcpWorker(ctx context.Context, id int, config *Config, stats *Stats, users *userConns) {
+	connect := new(latencyHistogram)
+	handshake := new(latencyHistogram)
+	defer stats.addConnectLatency(connect, handshake)
+	
+	status := stats.live.conn(id)
+	var conn net.Conn
+	if users == nil {
+		var err error
+		conn, err = dialTCP(config, connect, handshake)
+		if err != nil {
+			status.set(connFailed)
+			log.Printf("Worker %d: Failed to connect: %v", id, err)
+			return
+		}
+	}
+	status.set(connActive)
+	defer func() {
+		status.set(connDone)
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	
+	message := makeMessage(config.MessageSize)
+	picker := newUserPicker(config, id, config.Connections)
+	var out []byte
+	
+	pacer := newSenderPacer(config, stats, id, config.Connections)
+	defer stats.addSendErrors(&pacer.errors)
+	defer pacer.stop()
+	
+	latency := new(latencyHistogram)
+	defer stats.addLatency(latency)
+	
+	buf := make([]byte, 1024)
+	messages := 0 // sent over conn
+	for pacer.wait(ctx) {
+		atomic.AddInt64(&stats.Sent, 1)
+		status.send()
+		user := picker.next()
+		stats.users.send(user)
+		out = message
+		if config.Users.Via == UserViaPayload {
+			out = userMessage(out, message, 0, picker.key(config.Users, user))
+		}
+		
+		// With -user-via port the user's connection is held for the
+		// exchange, so its messages and replies do not interleave.
+		c := conn
+		var slot *userConn
+		if users != nil {
+			slot = users.lock(user)
+			c = slot.conn
+		}
+		if c == nil || (slot == nil && config.ReconnectEvery > 0 && messages == config.ReconnectEvery) {
+			if c != nil {
+				c.Close()
+			}
+			status.set(connReconnecting)
+			var err error
+			c, err = dialTCP(config, connect, handshake)
+			if slot != nil {
+				slot.conn = c
+			} else {
+				conn = c
+			}
+			if err != nil {
+				if slot != nil {
+					slot.mu.Unlock()
+				}
+				atomic.AddInt64(&stats.Failed, 1)
+				status.fail()
+				log.Printf("Worker %d: Failed to reconnect: %v", id, err)
+				continue
+			}
+			status.set(connActive)
+			messages = 0
+		}
+		messages++
+		start := time.Now()
+		
+		n, err := exchangeTCP(c, out, buf)
+		if slot != nil {
+			if err != nil {
+				c.Close()
+				slot.conn = nil
+			}
+			slot.mu.Unlock()
+		}
+		if err != nil {
+			atomic.AddInt64(&stats.Failed, 1)
+			status.fail()
+			log.Printf("Worker %d: %v", id, err)
+			continue
+		}
+		
+		elapsed := time.Since(start)
+		latency.record(elapsed)
+		if wire.Rejected(buf[:n]) {
+			atomic.AddInt64(&stats.RateLimited, 1)
+			status.limit()
+			stats.users.limit(user)
+			stats.adaptive.rejected(0)
+		} else if n > 0 {
+			atomic.AddInt64(&stats.Succeeded, 1)
+			status.succeed(elapsed)
+			stats.adaptive.succeeded()
+		}
+	}
+}
+
+// exchangeTCP writes message to conn and reads the reply into buf.
+func exchangeTCP(conn net.Conn, message, buf []byte) (int, error) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(message); err != nil {
+		return 0, fmt.Errorf("Write error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, fmt.Errorf("Read error: %v", err)
+	}
+	return n, nil
+}
+
+// makeMessage returns the message payload of size bytes.
+func makeMessage(size int) []byte {
+	message := make([]byte, size)
+	for i := range message {
+		message[i] = byte('A' + (i % 26))
+	}
+	return message
+}
+
+// dialTCP connects to the server, over TLS if configured, recording the
+// connection setup and handshake times separately.
+func dialTCP(config *Config, connect, handshake *latencyHistogram) (net.Conn, error) {
+	start := time.Now()
+	conn, err := net.Dial("tcp", config.ServerAddr)
+	if err != nil {
+		return nil, err
+	}
+	connect.record(time.Since(start))
+	if config.tls == nil {
+		return conn, nil
+	}
+	
+	start = time.Now()
+	tlsConn := tls.Client(conn, config.tls)
+	tlsConn.SetDeadline(start.Add(5 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	handshake.record(time.Since(start))
+	return tlsConn, nil
+}
+
+func runUDPTest(ctx context.Context, config *Config, stats *Stats) {
+	// With -user-via port each user gets its own socket, opened on its
+	// first message.
+	var conn net.Conn
+	sockets := map[int]net.Conn{}
+	if config.Users.Via != UserViaPort {
+		var err error
+		conn, err = net.Dial("udp", config.ServerAddr)
+		if err != nil {
+			log.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+	}
+	
+	// Each message starts with a sequence number and send time, so
+	// replies can be matched to what was sent.
+	message := makeMessage(max(config.MessageSize, wire.HeaderSize))
+	picker := newUserPicker(config, 0, 1)
+	var out []byte
+	
+	// issued counts sequence numbers handed out, written the messages
+	// written without error.
+	var issued, written int64
+	done := make(chan struct{})
+	
+	status := stats.live.conn(0)
+	status.set(connActive)
+	defer status.set(connDone)
+	
+	// The receivers of all sockets share the latency and delivery
+	// accounting.
+	var mu sync.Mutex
+	var latency latencyHistogram
+	var tracker sequenceTracker
+	var receivers sync.WaitGroup
+	
+	// receive reads the replies on conn, all to user's messages or, if
+	// user is negative, to messages naming their user in the payload.
+	receive := func(conn net.Conn, user int) {
+		defer receivers.Done()
+		buf := make([]byte, len(wire.RejectPrefix)+len(message)+64)
+		
+		// After the run, keep reading for udpDrain or until every
+		// message written has its reply.
+		var drain time.Time
+		for {
+			if drain.IsZero() {
+				select {
+				case <-done:
+					drain = time.Now().Add(udpDrain)
+				default:
+				}
+			}
+			mu.Lock()
+			received := tracker.received
+			mu.Unlock()
+			if !drain.IsZero() && (time.Now().After(drain) || received >= atomic.LoadInt64(&written)) {
+				return
+			}
+			deadline := time.Now().Add(100 * time.Millisecond)
+			if !drain.IsZero() && drain.Before(deadline) {
+				deadline = drain
+			}
+			conn.SetReadDeadline(deadline)
+			n, err := conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					continue
+				}
+				if ctx.Err() == nil {
+					log.Printf("UDP read error: %v", err)
+				}
+				continue
+			}
+			// Duplicates and stray datagrams are not counted again.
+			reply := wire.Message(buf[:n])
+			seq, sent, ok := wire.Header(reply)
+			mu.Lock()
+			ok = ok && tracker.observe(seq, uint64(atomic.LoadInt64(&issued)))
+			elapsed := time.Since(sent)
+			if ok {
+				latency.record(elapsed)
+			}
+			mu.Unlock()
+			if !ok {
+				continue
+			}
+			if wire.Rejected(buf[:n]) {
+				atomic.AddInt64(&stats.RateLimited, 1)
+				status.limit()
+				if user < 0 {
+					key, _ := wire.User(reply[wire.HeaderSize:])
+					stats.users.limit(userOf(config, key))
+				} else {
+					stats.users.limit(user)
+				}
+				stats.adaptive.rejected(0)
+			} else {
+				atomic.AddInt64(&stats.Succeeded, 1)
+				status.succeed(elapsed)
+				stats.adaptive.succeeded()
+			}
+		}
+	}
+	if conn != nil {
+		receivers.Add(1)
+		go receive(conn, -1)
+	}
+	
+	// Sender
+	func() {
+		defer close(done)
+		pacer := newSenderPacer(config, stats, 0, 1)
+		defer stats.addSendErrors(&pacer.errors)
+		defer pacer.stop()
+		
+		for seq := uint64(0); pacer.wait(ctx); seq++ {
+			atomic.AddInt64(&stats.Sent, 1)
+			status.send()
+			user := picker.next()
+			stats.users.send(user)
+			wire.PutHeader(message, seq, time.Now())
+			atomic.StoreInt64(&issued, int64(seq+1))
+			out = message
+			if config.Users.Via == UserViaPayload {
+				out = userMessage(out, message, wire.HeaderSize, picker.key(config.Users, user))
+			}
+			
+			c := conn
+			if c == nil {
+				c = sockets[user]
+				if c == nil {
+					var err error
+					c, err = net.Dial("udp", config.ServerAddr)
+					if err != nil {
+						atomic.AddInt64(&stats.Failed, 1)
+						status.fail()
+						log.Printf("Failed to connect: %v", err)
+						continue
+					}
+					sockets[user] = c
+					receivers.Add(1)
+					go receive(c, user)
+				}
+			}
+			_, err := c.Write(out)
+			if err != nil {
+				atomic.AddInt64(&stats.Failed, 1)
+				status.fail()
+				log.Printf("UDP write error: %v", err)
+				continue
+			}
+			atomic.AddInt64(&written, 1)
+		}
+	}()
+	
+	receivers.Wait()
+	for _, c := range sockets {
+		c.Close()
+	}
+	stats.addLatency(&latency)
+	stats.setDelivery(tracker.delivery(atomic.LoadInt64(&written)))
+}
+
+// newSenderPacer returns the pacer for sender id of senders, which split
+// the rate evenly, or follow the shared schedule when coordinating, or the
+// adaptive rate.
+func newSenderPacer(config *Config, stats *Stats, id, senders int) *pacer {
+	p := newPacer(config.Pacing, float64(config.Rate)/float64(senders), config.SpinAhead)
+	if config.coordination != nil {
+		p.follow(config.coordination.slots(id, senders))
+	}
+	if l := config.replay; l != nil {
+		p.replay(stats.StartTime, l.schedule(id, senders, config.Replay.Speed))
+	}
+	if rate := rampRate(config); rate != nil {
+		// Rates below one message per second are raised to it, so a ramp
+		// from zero starts sending within a second.
+		p.ramp(func(elapsed time.Duration) float64 {
+			return math.Max(rate(elapsed), 1) / float64(senders)
+		})
+	}
+	if a := stats.adaptive; a != nil {
+		p.ramp(func(time.Duration) float64 {
+			return a.current() / float64(senders)
+		})
+		p.hold = a.holdUntil
+	}
+	return p
+}
+
+func printStats(stats *Stats, duration time.Duration) {
+	sent := atomic.LoadInt64(&stats.Sent)
+	succeeded := atomic.LoadInt64(&stats.Succeeded)
+	failed := atomic.LoadInt64(&stats.Failed)
+	
+	fmt.Fprintln(console, "\n--- Test Statistics ---")
+	fmt.Fprintf(console, "Duration: %s\n", duration.Round(time.Millisecond))
+	fmt.Fprintf(console, "Messages sent: %d\n", sent)
+	fmt.Fprintf(console, "Messages succeeded: %d\n", succeeded)
+	fmt.Fprintf(console, "Messages failed: %d\n", failed)
+	codes, grpcCodes := stats.statusCodes.snapshot(), stats.grpcCodes.snapshot()
+	if limited := atomic.LoadInt64(&stats.RateLimited); limited > 0 || codes != nil || grpcCodes != nil {
+		fmt.Fprintf(console, "Rate limited: %d\n", limited)
+	}
+	if codes != nil {
+		fmt.Fprintf(console, "Status codes: %s\n", stats.statusCodes.summary())
+	}
+	if grpcCodes != nil {
+		fmt.Fprintf(console, "gRPC status: %s\n", stats.grpcCodes.format(wire.GRPCCodeName))
+	}
+	fmt.Fprintf(console, "Success rate: %.2f%%\n", float64(succeeded)/float64(sent)*100)
+	fmt.Fprintf(console, "Actual rate: %.2f messages/second\n", float64(sent)/duration.Seconds())
+	
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.latency.count > 0 {
+		fmt.Fprintf(console, "Latency: %s\n", stats.latency.summary())
+	}
+	if stats.connect.count > 0 {
+		fmt.Fprintf(console, "Connect: %s (%d connections)\n", stats.connect.summary(), stats.connect.count)
+	}
+	if stats.handshake.count > 0 {
+		fmt.Fprintf(console, "TLS handshake: %s\n", stats.handshake.summary())
+	}
+	if stats.delivery != nil {
+		fmt.Fprintf(console, "Delivery: %s\n", describeDelivery(stats.delivery))
+	}
+	if stats.timeline != nil {
+		fmt.Fprintf(console, "Rejections: %s\n", describeRejection(stats.timeline))
+	}
+	if s := adaptiveSummary(stats.adaptive, stats.timeline); s != nil {
+		fmt.Fprintf(console, "Adaptive: %s\n", describeAdaptive(s))
+	}
+	if stats.sendError.count > 0 {
+		fmt.Fprintf(console, "Send-time error: %s\n", stats.sendError.summary())
+	}
+	if s := userSummary(stats.users); s != nil {
+		fmt.Fprintf(console, "Users: %s\n", describeUserSummary(s))
+	}
+}
\ No newline at end of file
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Client processes on one host share a single offered-rate budget through
+// a Unix socket given with -coordinate. The first process to bind it acts
+// as coordinator; later ones join it. Whenever membership changes the
+// coordinator splits the rate into a schedule of interleaved slots, one
+// per process, and sends each process its slot. Processes then pace
+// locally with no per-send communication, so their combined offered load
+// matches the coordinator's -rate. If the coordinator exits, the remaining
+// processes elect a new one by racing to bind the socket.
+
+// ratePlan is a process's part of the shared schedule.
+type ratePlan struct {
+	// Rate is the combined rate of all processes, in sends per second.
+	Rate float64 `json:"rate"`
+
+	// Members is the number of processes and Index this process's
+	// position among them.
+	Members int `json:"members"`
+	Index   int `json:"index"`
+
+	// Start is the schedule origin in Unix nanoseconds.
+	Start int64 `json:"start"`
+}
+
+// planLead is how far in the future a new schedule starts, so that every
+// process has received it by then.
+const planLead = 20 * time.Millisecond
+
+// coordination is this process's membership in a coordinated run.
+type coordination struct {
+	path string
+	rate int
+
+	plan  atomic.Pointer[ratePlan]
+	ready chan struct{}
+	once  sync.Once
+
+	mu     sync.Mutex
+	conn   net.Conn // to the coordinator, while a member
+	closed bool
+
+	// Set while this process is the coordinator.
+	ln      net.Listener
+	members []member
+}
+
+// member is a process that joined this coordinator.
+type member struct {
+	conn net.Conn
+	enc  *json.Encoder
+}
+
+// joinCoordination joins or starts the coordinated run on the socket at
+// path and waits for the first plan.
+func joinCoordination(path string, rate int) (*coordination, error) {
+	c := &coordination{path: path, rate: rate, ready: make(chan struct{})}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-c.ready:
+		return c, nil
+	case <-time.After(5 * time.Second):
+		c.close()
+		return nil, errors.New("no plan from coordinator")
+	}
+}
+
+// connect joins a running coordinator or becomes one.
+func (c *coordination) connect() error {
+	for attempt := 0; attempt < 10; attempt++ {
+		conn, err := net.Dial("unix", c.path)
+		if err == nil {
+			c.mu.Lock()
+			c.conn = conn
+			c.mu.Unlock()
+			go c.follow(conn)
+			return nil
+		}
+
+		ln, err := net.Listen("unix", c.path)
+		if err == nil {
+			c.lead(ln)
+			return nil
+		}
+		if errors.Is(err, syscall.EADDRINUSE) {
+			// Nobody answered on the socket a moment ago; remove it if it
+			// is still dead, then race the other processes to bind it.
+			if conn, err := net.Dial("unix", c.path); err == nil {
+				conn.Close()
+			} else if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(c.path)
+			}
+		}
+		time.Sleep(time.Duration(attempt+1) * 10 * time.Millisecond)
+	}
+	return fmt.Errorf("cannot join or start coordinator at %s", c.path)
+}
+
+// follow receives plans from the coordinator until it goes away, then
+// rejoins.
+func (c *coordination) follow(conn net.Conn) {
+	defer conn.Close()
+
+	json.NewEncoder(conn).Encode(map[string]int{"rate": c.rate})
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var plan ratePlan
+		if err := dec.Decode(&plan); err != nil {
+			break
+		}
+		if plan.Rate != float64(c.rate) {
+			log.Printf("Coordination: using the coordinator's rate %.0f/s instead of %d/s", plan.Rate, c.rate)
+		}
+		c.adopt(&plan)
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+
+	log.Printf("Coordination: lost coordinator, rejoining")
+	if err := c.connect(); err != nil {
+		log.Printf("Coordination: %v", err)
+	}
+}
+
+// lead runs the coordinator on ln. The coordinator is always member 0.
+func (c *coordination) lead(ln net.Listener) {
+	c.mu.Lock()
+	c.ln = ln
+	c.replanLocked()
+	c.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go c.serveMember(conn)
+		}
+	}()
+}
+
+// serveMember registers a member and removes it when it disconnects.
+func (c *coordination) serveMember(conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	var hello struct {
+		Rate int `json:"rate"`
+	}
+	if err := dec.Decode(&hello); err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(conn)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.members = append(c.members, member{conn: conn, enc: enc})
+	c.replanLocked()
+	c.mu.Unlock()
+
+	// Members send nothing more; a read error means it left.
+	var discard json.RawMessage
+	for dec.Decode(&discard) == nil {
+	}
+
+	c.mu.Lock()
+	for i, m := range c.members {
+		if m.enc == enc {
+			c.members = append(c.members[:i], c.members[i+1:]...)
+			break
+		}
+	}
+	c.replanLocked()
+	c.mu.Unlock()
+}
+
+// replanLocked gives every process a new slot in a fresh schedule. c.mu
+// must be held.
+func (c *coordination) replanLocked() {
+	if c.closed {
+		return
+	}
+
+	start := time.Now().Add(planLead).UnixNano()
+	members := len(c.members) + 1
+
+	c.adopt(&ratePlan{Rate: float64(c.rate), Members: members, Index: 0, Start: start})
+	for i, m := range c.members {
+		// A failed write shows up as a read error in serveMember.
+		m.enc.Encode(ratePlan{Rate: float64(c.rate), Members: members, Index: i + 1, Start: start})
+	}
+}
+
+// adopt makes plan current.
+func (c *coordination) adopt(plan *ratePlan) {
+	prev := c.plan.Swap(plan)
+	c.once.Do(func() { close(c.ready) })
+
+	if prev == nil || prev.Members != plan.Members || prev.Index != plan.Index {
+		fmt.Fprintf(console, "Coordination: %d processes sharing %.0f msg/s, this is #%d\n",
+			plan.Members, plan.Rate, plan.Index+1)
+	}
+}
+
+// slots returns the schedule source for sender j of senders in this
+// process. Senders of all processes are interleaved so that, with equal
+// sender counts, sends are evenly spaced across the host.
+func (c *coordination) slots(j, senders int) func() *slot {
+	var (
+		last *ratePlan
+		s    *slot
+	)
+	return func() *slot {
+		plan := c.plan.Load()
+		if plan != last {
+			last = plan
+			perSend := float64(time.Second) / plan.Rate
+			s = &slot{
+				start:    time.Unix(0, plan.Start).Add(time.Duration(float64(plan.Index+j*plan.Members) * perSend)),
+				interval: float64(plan.Members*senders) * perSend,
+			}
+		}
+		return s
+	}
+}
+
+// close leaves the coordinated run. A coordinator stops accepting members
+// and disconnects them, so they elect a new one.
+func (c *coordination) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	if c.ln != nil {
+		c.ln.Close()
+	}
+	for _, m := range c.members {
+		m.conn.Close()
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// socketPath returns a path for a coordination socket. Socket paths are
+// limited to about 100 bytes, too short for t.TempDir.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "coordinate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "sock")
+}
+
+func quietConsole(t *testing.T) {
+	prev := console
+	console = io.Discard
+	t.Cleanup(func() { console = prev })
+}
+
+// join joins the run on path, leaving it when the test ends.
+func join(t *testing.T, path string, rate int) *coordination {
+	t.Helper()
+	c, err := joinCoordination(path, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+	return c
+}
+
+// waitForPlans waits until the plans of procs form one schedule of
+// len(procs) members with distinct slots and the given rate.
+func waitForPlans(t *testing.T, rate float64, procs ...*coordination) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok := true
+		seen := make(map[int]bool)
+		first := procs[0].plan.Load()
+		for _, c := range procs {
+			p := c.plan.Load()
+			if p.Members != len(procs) || p.Rate != rate || p.Start != first.Start || seen[p.Index] {
+				ok = false
+				break
+			}
+			seen[p.Index] = true
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			for i, c := range procs {
+				t.Logf("process %d: %+v", i, *c.plan.Load())
+			}
+			t.Fatalf("no shared schedule of %d processes", len(procs))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCoordinationSharesSchedule(t *testing.T) {
+	quietConsole(t)
+	path := socketPath(t)
+
+	a := join(t, path, 300)
+	if p := a.plan.Load(); p.Members != 1 || p.Index != 0 {
+		t.Fatalf("first process plan = %+v, want the only member", *p)
+	}
+	b := join(t, path, 300)
+	c := join(t, path, 100)
+	// The coordinator's rate wins.
+	waitForPlans(t, 300, a, b, c)
+
+	b.close()
+	waitForPlans(t, 300, a, c)
+}
+
+func TestCoordinationElectsNewCoordinator(t *testing.T) {
+	quietConsole(t)
+	path := socketPath(t)
+
+	a := join(t, path, 200)
+	b := join(t, path, 200)
+	c := join(t, path, 200)
+	waitForPlans(t, 200, a, b, c)
+
+	a.close()
+	waitForPlans(t, 200, b, c)
+
+	// The new coordinator accepts newcomers.
+	d := join(t, path, 200)
+	waitForPlans(t, 200, b, c, d)
+}
+
+func TestCoordinationSlots(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	c := &coordination{}
+	c.plan.Store(&ratePlan{Rate: 100, Members: 2, Index: 1, Start: start.UnixNano()})
+
+	// Two processes of three senders each send every 10ms in turn, so
+	// each sender sends every 60ms; this process's third sender is the
+	// sixth in the rotation.
+	next := c.slots(2, 3)
+	s := next()
+	if want := start.Add(50 * time.Millisecond); !s.start.Equal(want) || s.interval != float64(60*time.Millisecond) {
+		t.Errorf("slot = %v every %v, want %v every 60ms", s.start, time.Duration(s.interval), want)
+	}
+	if next() != s {
+		t.Error("slot rebuilt without a new plan")
+	}
+
+	c.plan.Store(&ratePlan{Rate: 100, Members: 1, Start: start.UnixNano()})
+	if s := next(); !s.start.Equal(start.Add(20*time.Millisecond)) || s.interval != float64(30*time.Millisecond) {
+		t.Errorf("slot after replanning = %v every %v", s.start, time.Duration(s.interval))
+	}
+}
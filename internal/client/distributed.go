@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// agentSlack is how long after the scheduled end of a distributed run the
+// coordinator waits for agents to report.
+const agentSlack = 30 * time.Second
+
+// AgentConfig lists the agents of a distributed run; see agent.go.
+type AgentConfig struct {
+	Addrs  []string
+	Token  string
+	CAFile string // verifies the agents; default no verification
+}
+
+// Set implements the repeatable -agents flag, which also takes a
+// comma-separated list.
+func (c *AgentConfig) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			c.Addrs = append(c.Addrs, addr)
+		}
+	}
+	return nil
+}
+
+// String implements flag.Value.
+func (c *AgentConfig) String() string {
+	if c == nil {
+		return ""
+	}
+	return strings.Join(c.Addrs, ",")
+}
+
+// describeAgents describes the agents for the run banner.
+func describeAgents(config *Config) string {
+	desc := fmt.Sprintf("%d (%s), sharing the rate", len(config.Agents.Addrs), strings.Join(config.Agents.Addrs, ", "))
+	if config.Agents.CAFile == "" {
+		desc += ", certificates not verified"
+	}
+	return desc
+}
+
+// share returns part i of n of total, the remainder going to the first
+// parts.
+func share(total, i, n int) int {
+	s := total / n
+	if i < total%n {
+		s++
+	}
+	return s
+}
+
+// agentPart returns agent i's part of a run on n agents: the same run at
+// its share of the rate, without what only the coordinator does.
+func agentPart(config *Config, scenario *Scenario, i, n int) *agentRequest {
+	c := *config
+	c.Rate = share(config.Rate, i, n)
+	c.Ramp.From = share(config.Ramp.From, i, n)
+	c.Agents = AgentConfig{}
+	c.Labels = nil
+	c.ScenarioFile = ""
+	c.ReportFile = ""
+	c.LatencyFile = ""
+	c.Output = cli.FormatText
+	c.TUI = false
+	req := &agentRequest{Config: c}
+
+	if scenario != nil {
+		s := *scenario
+		s.Phases = make([]Phase, len(scenario.Phases))
+		for j, phase := range scenario.Phases {
+			phase.Rate = share(phase.Rate, i, n)
+			if phase.Ramp != nil {
+				ramp := *phase.Ramp
+				ramp.From = share(ramp.From, i, n)
+				phase.Ramp = &ramp
+			}
+			s.Phases[j] = phase
+		}
+		req.Scenario = &s
+	}
+	return req
+}
+
+// runOnAgents runs config, or scenario if not nil, on the agents and
+// merges their statistics into one result per phase, or one for a run
+// without a scenario. Agents that fail are reported and left out.
+func runOnAgents(config *Config, scenario *Scenario) []*PhaseResult {
+	agents := config.Agents.Addrs
+	total := config.Duration
+	if scenario != nil {
+		total = 0
+		for _, phase := range scenario.Phases {
+			if phase.Rate > 0 && phase.Rate < len(agents) {
+				log.Fatalf("Phase %s: rate %d is below one message per second per agent", phase.Name, phase.Rate)
+			}
+			total += phase.Duration.Duration
+		}
+	}
+
+	start := time.Now().Add(agentLead)
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(total+agentSlack))
+	defer cancel()
+
+	results := make([]*agentResult, len(agents))
+	errs := make([]error, len(agents))
+	var wg sync.WaitGroup
+	for i, addr := range agents {
+		req := agentPart(config, scenario, i, len(agents))
+		req.Start = start
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			results[i], errs[i] = callAgent(ctx, config.Agents, addr, req)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	phases := 1
+	if scenario != nil {
+		phases = len(scenario.Phases)
+	}
+	merged := make([]*PhaseResult, phases)
+	for j := range merged {
+		merged[j] = &PhaseResult{Stats: &Stats{StartTime: start}}
+		if scenario != nil {
+			merged[j].Phase = scenario.Phases[j]
+		}
+	}
+
+	completed := 0
+	for i, addr := range agents {
+		if errs[i] == nil && len(results[i].Phases) != phases {
+			errs[i] = fmt.Errorf("%d results for %d phases", len(results[i].Phases), phases)
+		}
+		if errs[i] != nil {
+			fmt.Fprintf(console, "Agent %s: failed: %v\n", addr, errs[i])
+			continue
+		}
+		completed++
+		var sent, succeeded int64
+		for j := range results[i].Phases {
+			a := &results[i].Phases[j]
+			merged[j].Stats.mergeAgent(a)
+			merged[j].Elapsed = max(merged[j].Elapsed, a.Elapsed.Duration)
+			sent += a.Sent
+			succeeded += a.Succeeded
+		}
+		fmt.Fprintf(console, "Agent %s: %d sent, %d succeeded\n", addr, sent, succeeded)
+	}
+	if completed == 0 {
+		log.Fatalf("No agent completed the run")
+	}
+	if completed < len(agents) {
+		fmt.Fprintf(console, "Results are from %d of %d agents\n", completed, len(agents))
+	}
+
+	if scenario != nil {
+		for _, result := range merged {
+			writeFinal(phaseConfig(config, result.Phase), result.Stats, result.Elapsed)
+			result.Assertions = evaluateExpectation(result.Phase.Expect, result.Stats, result.Elapsed)
+		}
+	}
+	return merged
+}
+
+// callAgent sends req to the agent at addr and waits for its result.
+func callAgent(ctx context.Context, config AgentConfig, addr string, req *agentRequest) (*agentResult, error) {
+	tlsConfig, err := clientTLS(TLSConfig{Enabled: true, CAFile: config.CAFile, Insecure: config.CAFile == ""}, addr)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+	defer transport.CloseIdleConnections()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	url := addr
+	if !strings.Contains(url, "://") {
+		url = "https://" + url
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(url, "/")+grpcAgentRun,
+		bytes.NewReader(wire.AppendGRPCFrame(nil, body)))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", wire.GRPCJSONContentType)
+	httpReq.Header.Set("Te", "trailers")
+	httpReq.Header.Set("Authorization", "Bearer "+config.Token)
+
+	resp, err := transport.RoundTrip(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// A failed RPC has no message, only a status.
+	var msg []byte
+	if resp.StatusCode == http.StatusOK {
+		msg, _, err = wire.ReadGRPCFrame(resp.Body, nil)
+		if err == io.EOF {
+			err = nil
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+		}
+	}
+	if code, message := grpcStatus(resp, err); code != wire.GRPCOK {
+		return nil, fmt.Errorf("%s: %s", wire.GRPCCodeName(code), message)
+	}
+	if msg == nil {
+		return nil, errors.New("no result")
+	}
+	var result agentResult
+	if err := json.Unmarshal(msg, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// gRPC call modes.
+const (
+	GRPCUnary  = "unary"
+	GRPCStream = "stream"
+)
+
+// GRPCConfig holds the settings of gRPC mode.
+type GRPCConfig struct {
+	Mode     string        // unary RPCs or one bidirectional stream per connection
+	Deadline time.Duration // per RPC; 0 for none
+}
+
+// runGRPCTest calls the server's gRPC echo service, which the client
+// speaks over HTTP/2 with TLS. Each connection is a worker issuing unary
+// RPCs or sending messages over a bidirectional stream; responses are
+// counted by gRPC status, with RESOURCE_EXHAUSTED as rate limited.
+func runGRPCTest(ctx context.Context, config *Config, stats *Stats) {
+	transport := &http.Transport{
+		TLSClientConfig:     config.tls,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: config.Connections,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if config.GRPC.Mode == GRPCStream {
+				grpcStreamWorker(ctx, id, client, config, stats)
+			} else {
+				grpcUnaryWorker(ctx, id, client, config, stats)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// describeGRPC describes the gRPC settings for the run banner.
+func describeGRPC(config *Config) string {
+	desc := config.GRPC.Mode + " calls to " + grpcURL(config, "")
+	if config.GRPC.Deadline > 0 {
+		desc += ", deadline " + config.GRPC.Deadline.String()
+	}
+	return desc
+}
+
+// grpcURL returns the URL of method on the server.
+func grpcURL(config *Config, method string) string {
+	base := config.ServerAddr
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return strings.TrimSuffix(base, "/") + method
+}
+
+// newGRPCRequest returns a request for method whose deadline, if any, is
+// carried in the grpc-timeout header and bounds ctx.
+func newGRPCRequest(ctx context.Context, config *Config, method string, body io.Reader) (*http.Request, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if config.GRPC.Deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, config.GRPC.Deadline)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, grpcURL(config, method), body)
+	if err != nil {
+		log.Fatalf("Invalid request: %v", err)
+	}
+	req.Header.Set("Content-Type", wire.GRPCContentType)
+	req.Header.Set("Te", "trailers")
+	if config.GRPC.Deadline > 0 {
+		req.Header.Set("Grpc-Timeout", wire.FormatGRPCTimeout(config.GRPC.Deadline))
+	}
+	return req, cancel
+}
+
+// grpcStatus returns the status of a finished RPC whose body has been
+// read to the end, and the status of an RPC that failed with err.
+func grpcStatus(resp *http.Response, err error) (int, string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return wire.GRPCDeadlineExceeded, "deadline exceeded"
+	case err != nil:
+		return wire.GRPCUnavailable, err.Error()
+	case resp.StatusCode != http.StatusOK:
+		return wire.GRPCUnknown, "HTTP status " + resp.Status
+	}
+	// A response without messages may carry its status in the headers.
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return wire.GRPCInternal, "missing grpc-status"
+	}
+	return code, message
+}
+
+// countGRPCStatus counts the outcome of one message sent as user by its
+// gRPC status, answered after latency.
+func countGRPCStatus(id, user int, code int, message string, latency time.Duration, stats *Stats) {
+	stats.grpcCodes.add(code)
+	status := stats.live.conn(id)
+	switch code {
+	case wire.GRPCOK:
+		atomic.AddInt64(&stats.Succeeded, 1)
+		status.succeed(latency)
+		stats.adaptive.succeeded()
+	case wire.GRPCResourceExhausted:
+		atomic.AddInt64(&stats.RateLimited, 1)
+		status.limit()
+		stats.users.limit(user)
+		stats.adaptive.rejected(0)
+	default:
+		atomic.AddInt64(&stats.Failed, 1)
+		status.fail()
+		log.Printf("Worker %d: RPC failed: %s: %s", id, wire.GRPCCodeName(code), message)
+	}
+}
+
+func grpcUnaryWorker(ctx context.Context, id int, client *http.Client, config *Config, stats *Stats) {
+	pacer := newSenderPacer(config, stats, id, config.Connections)
+	defer stats.addSendErrors(&pacer.errors)
+	defer pacer.stop()
+
+	latency := new(latencyHistogram)
+	defer stats.addLatency(latency)
+
+	status := stats.live.conn(id)
+	status.set(connActive)
+	defer status.set(connDone)
+
+	message := makeMessage(config.MessageSize)
+	request := wire.AppendGRPCMessage(nil, message)
+	picker := newUserPicker(config, id, config.Connections)
+	var out, buf []byte
+	for pacer.wait(ctx) {
+		atomic.AddInt64(&stats.Sent, 1)
+		status.send()
+		user := picker.next()
+		stats.users.send(user)
+		if config.Users.Via == UserViaPayload {
+			out = userMessage(out, message, 0, picker.key(config.Users, user))
+			request = wire.AppendGRPCMessage(request[:0], out)
+		}
+
+		// RPCs in flight when the run ends are finished rather than
+		// cancelled, so they are counted by their real outcome.
+		req, cancel := newGRPCRequest(context.WithoutCancel(ctx), config, wire.GRPCEcho, bytes.NewReader(request))
+		if config.Users.Via == UserViaHeader {
+			req.Header.Set(config.Users.Header, picker.key(config.Users, user))
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		var elapsed time.Duration
+		if err == nil {
+			for err == nil {
+				_, buf, err = wire.ReadGRPCMessage(resp.Body, buf)
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			resp.Body.Close()
+			elapsed = time.Since(start)
+			latency.record(elapsed)
+		}
+		cancel()
+		code, message := grpcStatus(resp, err)
+		countGRPCStatus(id, user, code, message, elapsed, stats)
+	}
+}
+
+// grpcStream is an open bidirectional stream.
+type grpcStream struct {
+	w        *io.PipeWriter
+	resp     *http.Response
+	cancel   context.CancelFunc
+	deadline time.Time // zero without a deadline
+}
+
+// openGRPCStream starts an EchoStream RPC.
+func openGRPCStream(ctx context.Context, client *http.Client, config *Config) (*grpcStream, error) {
+	r, w := io.Pipe()
+	req, cancel := newGRPCRequest(ctx, config, wire.GRPCEchoStream, r)
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		w.Close()
+		return nil, err
+	}
+	s := &grpcStream{w: w, resp: resp, cancel: cancel}
+	if config.GRPC.Deadline > 0 {
+		s.deadline, _ = req.Context().Deadline()
+	}
+	return s, nil
+}
+
+// finish reads the rest of the stream and returns its status.
+func (s *grpcStream) finish(err error) (int, string) {
+	var buf []byte
+	for err == nil {
+		_, buf, err = wire.ReadGRPCMessage(s.resp.Body, buf)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	s.resp.Body.Close()
+	s.cancel()
+	return grpcStatus(s.resp, err)
+}
+
+// close half-closes the stream and waits for it to end.
+func (s *grpcStream) close() {
+	s.w.Close()
+	s.finish(nil)
+}
+
+// grpcStreamWorker sends its messages over a bidirectional stream, waiting
+// for each echo before the next send. A stream ended by the server, such as
+// by rate limiting, is replaced by a new one for the next message, and a
+// stream is replaced before its deadline runs out, so only messages still
+// unanswered at the deadline fail with DEADLINE_EXCEEDED.
+func grpcStreamWorker(ctx context.Context, id int, client *http.Client, config *Config, stats *Stats) {
+	pacer := newSenderPacer(config, stats, id, config.Connections)
+	defer stats.addSendErrors(&pacer.errors)
+	defer pacer.stop()
+
+	latency := new(latencyHistogram)
+	defer stats.addLatency(latency)
+
+	status := stats.live.conn(id)
+	status.set(connActive)
+	message := makeMessage(config.MessageSize)
+	request := wire.AppendGRPCMessage(nil, message)
+	picker := newUserPicker(config, id, config.Connections)
+	var out []byte
+	var stream *grpcStream
+	defer func() {
+		if stream != nil {
+			stream.close()
+		}
+		status.set(connDone)
+	}()
+
+	var buf []byte
+	for pacer.wait(ctx) {
+		atomic.AddInt64(&stats.Sent, 1)
+		status.send()
+		user := picker.next()
+		stats.users.send(user)
+		if config.Users.Via == UserViaPayload {
+			out = userMessage(out, message, 0, picker.key(config.Users, user))
+			request = wire.AppendGRPCMessage(request[:0], out)
+		}
+		if stream != nil && !stream.deadline.IsZero() && time.Until(stream.deadline) < config.GRPC.Deadline/10 {
+			stream.close()
+			stream = nil
+		}
+		start := time.Now()
+		if stream == nil {
+			var err error
+			stream, err = openGRPCStream(context.WithoutCancel(ctx), client, config)
+			if err != nil {
+				code, message := grpcStatus(nil, err)
+				countGRPCStatus(id, user, code, message, 0, stats)
+				continue
+			}
+		}
+
+		// If the stream ended, its status is this message's outcome. A
+		// failed write means the server stopped reading, and the status
+		// follows in the response.
+		ended := false
+		_, err := stream.w.Write(request)
+		if err != nil {
+			ended, err = true, nil
+		} else if _, buf, err = wire.ReadGRPCMessage(stream.resp.Body, buf); err != nil {
+			ended = true
+		}
+		elapsed := time.Since(start)
+		latency.record(elapsed)
+		if ended {
+			code, message := stream.finish(err)
+			countGRPCStatus(id, user, code, message, elapsed, stats)
+			stream = nil
+			continue
+		}
+		countGRPCStatus(id, user, wire.GRPCOK, "", elapsed, stats)
+	}
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPConfig describes the requests sent with -protocol http.
+type HTTPConfig struct {
+	Method  string
+	Path    string
+	Headers Headers
+	Body    string
+	Timeout time.Duration
+}
+
+// Headers are request headers given as repeated -header "Name: value"
+// flags.
+type Headers http.Header
+
+// String implements flag.Value.
+func (h Headers) String() string {
+	var parts []string
+	for name, values := range h {
+		for _, v := range values {
+			parts = append(parts, name+": "+v)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// Set implements flag.Value.
+func (h Headers) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("header must be Name: value, got %q", value)
+	}
+	http.Header(h).Add(name, strings.TrimSpace(v))
+	return nil
+}
+
+// statusCodes counts HTTP responses by status code.
+type statusCodes struct {
+	mu     sync.Mutex
+	counts map[int]int64
+}
+
+func (s *statusCodes) add(code int) {
+	s.mu.Lock()
+	if s.counts == nil {
+		s.counts = make(map[int]int64)
+	}
+	s.counts[code]++
+	s.mu.Unlock()
+}
+
+// merge adds counts by code.
+func (s *statusCodes) merge(counts map[int]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for code, n := range counts {
+		if s.counts == nil {
+			s.counts = make(map[int]int64)
+		}
+		s.counts[code] += n
+	}
+}
+
+// snapshot returns a copy of the counts, or nil if there are none.
+func (s *statusCodes) snapshot() map[int]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.counts) == 0 {
+		return nil
+	}
+	counts := make(map[int]int64, len(s.counts))
+	for code, n := range s.counts {
+		counts[code] = n
+	}
+	return counts
+}
+
+// summary formats the counts as "200: 950, 429: 50".
+func (s *statusCodes) summary() string {
+	return s.format(strconv.Itoa)
+}
+
+// format formats the counts in code order, naming each code with name.
+func (s *statusCodes) format(name func(int) string) string {
+	counts := s.snapshot()
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%s: %d", name(code), counts[code])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// requestURL returns the URL requests go to. The server may be given as
+// host:port, served over http or with -tls https, or as a URL with a
+// scheme, such as https://api.example.com.
+func requestURL(config *Config) string {
+	return serverURL(config, config.HTTP.Path)
+}
+
+// serverURL returns the URL of path on the server.
+func serverURL(config *Config, path string) string {
+	base := config.ServerAddr
+	if !strings.Contains(base, "://") {
+		if config.tls != nil {
+			base = "https://" + base
+		} else {
+			base = "http://" + base
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// requestBody returns the body to send; a body of @path is read from the
+// file.
+func requestBody(body string) ([]byte, error) {
+	if path, ok := strings.CutPrefix(body, "@"); ok {
+		return os.ReadFile(path)
+	}
+	return []byte(body), nil
+}
+
+// runHTTPTest sends HTTP requests from Connections workers that share the
+// rate. Responses with status 429 count as rate limited, other 4xx and 5xx
+// responses and transport errors as failed.
+func runHTTPTest(ctx context.Context, config *Config, stats *Stats) {
+	url := requestURL(config)
+	body, err := requestBody(config.HTTP.Body)
+	if err != nil {
+		log.Fatalf("Failed to read request body: %v", err)
+	}
+
+	client := &http.Client{
+		Timeout: config.HTTP.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: config.Connections,
+			IdleConnTimeout:     90 * time.Second,
+			TLSClientConfig:     config.tls,
+		},
+		// Report redirects as they are instead of following them.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			httpWorker(ctx, id, client, url, body, config, stats)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func httpWorker(ctx context.Context, id int, client *http.Client, url string, body []byte, config *Config, stats *Stats) {
+	pacer := newSenderPacer(config, stats, id, config.Connections)
+	defer stats.addSendErrors(&pacer.errors)
+	defer pacer.stop()
+
+	latency := new(latencyHistogram)
+	defer stats.addLatency(latency)
+
+	status := stats.live.conn(id)
+	status.set(connActive)
+	defer status.set(connDone)
+
+	picker := newUserPicker(config, id, config.Connections)
+	for pacer.wait(ctx) {
+		atomic.AddInt64(&stats.Sent, 1)
+		status.send()
+		user := picker.next()
+		stats.users.send(user)
+
+		// Requests in flight when the run ends are finished rather than
+		// cancelled, so they are counted by their real outcome.
+		method, target := config.HTTP.Method, url
+		if e := picker.replayed(); e != nil {
+			if e.method != "" {
+				method = e.method
+			}
+			if e.path != "" {
+				target = serverURL(config, e.path)
+			}
+		}
+		req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), method, target, bytes.NewReader(body))
+		if err != nil && picker.replayed() == nil {
+			log.Fatalf("Invalid request: %v", err)
+		}
+		if err != nil {
+			// A request of the replayed log that cannot be sent.
+			atomic.AddInt64(&stats.Failed, 1)
+			status.fail()
+			log.Printf("Worker %d: Invalid request: %v", id, err)
+			continue
+		}
+		for name, values := range config.HTTP.Headers {
+			req.Header[name] = values
+		}
+		if config.Users.Via == UserViaHeader {
+			req.Header.Set(config.Users.Header, picker.key(config.Users, user))
+		}
+		if host := req.Header.Get("Host"); host != "" {
+			req.Host = host
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			atomic.AddInt64(&stats.Failed, 1)
+			status.fail()
+			log.Printf("Worker %d: Request error: %v", id, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		elapsed := time.Since(start)
+		latency.record(elapsed)
+
+		stats.statusCodes.add(resp.StatusCode)
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			atomic.AddInt64(&stats.RateLimited, 1)
+			status.limit()
+			stats.users.limit(user)
+			stats.adaptive.rejected(retryAfter(resp))
+		case resp.StatusCode >= 400:
+			atomic.AddInt64(&stats.Failed, 1)
+			status.fail()
+		default:
+			atomic.AddInt64(&stats.Succeeded, 1)
+			status.succeed(elapsed)
+			stats.adaptive.succeeded()
+		}
+	}
+}
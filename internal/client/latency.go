@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// latencySubBits sets the histogram resolution: 2^latencySubBits buckets
+// per power of two, so recorded values are within 1% of the true latency.
+const latencySubBits = 7
+
+// latencyHistogram is an HDR histogram of request latencies in
+// nanoseconds. Values below 2^latencySubBits are exact; above that each
+// power of two is split into 2^latencySubBits linear buckets, so the
+// relative error is the same from microseconds to minutes.
+type latencyHistogram struct {
+	count   int64
+	sum     float64
+	max     time.Duration
+	buckets [(64 - latencySubBits + 1) << latencySubBits]int64
+}
+
+// record adds one request that took d.
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.count++
+	h.sum += float64(d)
+	if d > h.max {
+		h.max = d
+	}
+	h.buckets[latencyBucket(uint64(d))]++
+}
+
+// merge adds the requests recorded in o.
+func (h *latencyHistogram) merge(o *latencyHistogram) {
+	if o.count == 0 {
+		return
+	}
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+	for i, n := range o.buckets {
+		h.buckets[i] += n
+	}
+}
+
+// export returns h for sending to another process.
+func (h *latencyHistogram) export() histogramData {
+	return histogramData{Count: h.count, Sum: h.sum, Max: h.max, Buckets: nonEmpty(h.buckets[:])}
+}
+
+// mergeData adds the requests of a histogram received from another
+// process.
+func (h *latencyHistogram) mergeData(d histogramData) {
+	h.count += d.Count
+	h.sum += d.Sum
+	h.max = max(h.max, d.Max)
+	d.addBuckets(h.buckets[:])
+}
+
+// mean returns the average latency.
+func (h *latencyHistogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// quantile returns the latency below which a fraction q of requests fell.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q*float64(h.count))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			return h.clamp(latencyUpper(i))
+		}
+	}
+	return h.max
+}
+
+// clamp limits a bucket bound to the largest recorded value, so the top
+// percentiles never exceed the real maximum.
+func (h *latencyHistogram) clamp(d time.Duration) time.Duration {
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// summary formats the median, tail percentiles and maximum latency.
+func (h *latencyHistogram) summary() string {
+	return fmt.Sprintf("p50 %s  p90 %s  p99 %s  p999 %s  max %s",
+		h.quantile(0.50), h.quantile(0.90), h.quantile(0.99), h.quantile(0.999), h.max)
+}
+
+// writeDistribution writes the histogram in HdrHistogram's percentile
+// distribution format, one line per non-empty bucket with values in
+// milliseconds, so it can be loaded into the usual HdrHistogram plotters.
+func (h *latencyHistogram) writeDistribution(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+
+	var seen int64
+	for i, n := range h.buckets {
+		if n == 0 {
+			continue
+		}
+		seen += n
+		value := float64(h.clamp(latencyUpper(i))) / float64(time.Millisecond)
+		percentile := float64(seen) / float64(h.count)
+		if seen == h.count {
+			fmt.Fprintf(bw, "%12.6f %14.12f %10d\n", value, percentile, seen)
+		} else {
+			fmt.Fprintf(bw, "%12.6f %14.12f %10d %14.2f\n", value, percentile, seen, 1/(1-percentile))
+		}
+	}
+
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, Max            = %12.3f]\n",
+		float64(h.mean())/float64(time.Millisecond), float64(h.max)/float64(time.Millisecond))
+	fmt.Fprintf(bw, "#[Total count    = %12d, SubBuckets     = %12d]\n", h.count, 1<<latencySubBits)
+	return bw.Flush()
+}
+
+// latencyBucket maps v to a bucket: exact below 2^latencySubBits, then
+// 2^latencySubBits buckets per power of two.
+func latencyBucket(v uint64) int {
+	const sub = 1 << latencySubBits
+	if v < sub {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	offset := int(v>>uint(exp-latencySubBits)) & (sub - 1)
+	return sub + (exp-latencySubBits)*sub + offset
+}
+
+// latencyUpper returns the largest value in bucket i.
+func latencyUpper(i int) time.Duration {
+	const sub = 1 << latencySubBits
+	if i < sub {
+		return time.Duration(i)
+	}
+	exp := (i-sub)/sub + latencySubBits
+	offset := (i - sub) % sub
+	upper := uint64(sub+offset+1)<<uint(exp-latencySubBits) - 1
+	if upper > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(upper)
+}
+
+// histogramData is a histogram in transit between processes, from the
+// agents of a distributed run to its coordinator, with only the non-empty
+// buckets.
+type histogramData struct {
+	Count   int64         `json:"count"`
+	Sum     float64       `json:"sum"`
+	Max     time.Duration `json:"max"`
+	Buckets map[int]int64 `json:"buckets,omitempty"`
+}
+
+// nonEmpty returns the non-empty buckets by index.
+func nonEmpty(buckets []int64) map[int]int64 {
+	m := make(map[int]int64)
+	for i, n := range buckets {
+		if n != 0 {
+			m[i] = n
+		}
+	}
+	return m
+}
+
+// addBuckets adds the counts of d to buckets, ignoring indexes out of
+// range.
+func (d histogramData) addBuckets(buckets []int64) {
+	for i, n := range d.Buckets {
+		if i >= 0 && i < len(buckets) {
+			buckets[i] += n
+		}
+	}
+}
+
+// LatencySummary summarizes request latencies.
+type LatencySummary struct {
+	Count int64    `json:"count"`
+	Mean  Duration `json:"mean"`
+	P50   Duration `json:"p50"`
+	P90   Duration `json:"p90"`
+	P99   Duration `json:"p99"`
+	P999  Duration `json:"p999"`
+	Max   Duration `json:"max"`
+}
+
+// latencySummary returns the summary of h, or nil if it is empty.
+func latencySummary(h *latencyHistogram) *LatencySummary {
+	if h.count == 0 {
+		return nil
+	}
+	return &LatencySummary{
+		Count: h.count,
+		Mean:  Duration{h.mean()},
+		P50:   Duration{h.quantile(0.50)},
+		P90:   Duration{h.quantile(0.90)},
+		P99:   Duration{h.quantile(0.99)},
+		P999:  Duration{h.quantile(0.999)},
+		Max:   Duration{h.max},
+	}
+}
+
+// writeLatencyHistograms writes the latency distribution of each named
+// run to path, or to stdout if path is "-". Scenario phases each get a
+// section headed by a "#Phase:" comment.
+func writeLatencyHistograms(path string, names []string, histograms []*latencyHistogram) error {
+	if path == "-" {
+		return writeDistributions(os.Stdout, names, histograms)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeDistributions(f, names, histograms); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeDistributions(w io.Writer, names []string, histograms []*latencyHistogram) error {
+	for i, h := range histograms {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if names[i] != "" {
+			fmt.Fprintf(w, "#Phase: %s\n", names[i])
+		}
+		if err := h.writeDistribution(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
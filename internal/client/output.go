@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// console receives the human-readable output. It is stderr when -output
+// selects a machine-readable format, leaving stdout to the records.
+var console io.Writer = os.Stdout
+
+// Record types.
+const (
+	RecordSnapshot = "snapshot" // written every -snapshot-interval during a run
+	RecordFinal    = "final"    // written at the end of a run or phase
+)
+
+// StatsRecord is one machine-readable stats record, written with -output
+// json or csv. Latencies are only known in final records.
+type StatsRecord struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Phase       string    `json:"phase,omitempty"`
+	Elapsed     float64   `json:"elapsed_seconds"`
+	Sent        int64     `json:"sent"`
+	Succeeded   int64     `json:"succeeded"`
+	Failed      int64     `json:"failed"`
+	RateLimited int64     `json:"rate_limited"`
+	Rate        float64   `json:"rate"` // sends per second since the previous snapshot, or over the run
+	SuccessRate float64   `json:"success_rate"`
+	LatencyP50  float64   `json:"latency_p50_ms,omitempty"`
+	LatencyP90  float64   `json:"latency_p90_ms,omitempty"`
+	LatencyP99  float64   `json:"latency_p99_ms,omitempty"`
+	LatencyP999 float64   `json:"latency_p999_ms,omitempty"`
+	LatencyMax  float64   `json:"latency_max_ms,omitempty"`
+}
+
+// newStatsRecord fills the counters of a record from stats.
+func newStatsRecord(kind string, config *Config, stats *Stats, now time.Time) StatsRecord {
+	sent := atomic.LoadInt64(&stats.Sent)
+	succeeded := atomic.LoadInt64(&stats.Succeeded)
+	return StatsRecord{
+		Type:        kind,
+		Time:        now,
+		Phase:       config.phase,
+		Elapsed:     now.Sub(stats.StartTime).Seconds(),
+		Sent:        sent,
+		Succeeded:   succeeded,
+		Failed:      atomic.LoadInt64(&stats.Failed),
+		RateLimited: atomic.LoadInt64(&stats.RateLimited),
+		SuccessRate: percentage(succeeded, sent),
+	}
+}
+
+// writeSnapshots writes a snapshot record every -snapshot-interval until
+// ctx is done.
+func writeSnapshots(ctx context.Context, config *Config, stats *Stats) {
+	ticker := time.NewTicker(config.SnapshotInterval)
+	defer ticker.Stop()
+
+	last := stats.StartTime
+	var lastSent int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r := newStatsRecord(RecordSnapshot, config, stats, now)
+			r.Rate = float64(r.Sent-lastSent) / now.Sub(last).Seconds()
+			writeRecord(config, r)
+			last, lastSent = now, r.Sent
+		}
+	}
+}
+
+// writeFinal writes the final record of a run or phase that took elapsed.
+func writeFinal(config *Config, stats *Stats, elapsed time.Duration) {
+	if config.records == nil {
+		return
+	}
+	r := newStatsRecord(RecordFinal, config, stats, time.Now())
+	r.Elapsed = elapsed.Seconds()
+	r.Rate = float64(r.Sent) / elapsed.Seconds()
+
+	stats.mu.Lock()
+	if h := &stats.latency; h.count > 0 {
+		ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+		r.LatencyP50 = ms(h.quantile(0.50))
+		r.LatencyP90 = ms(h.quantile(0.90))
+		r.LatencyP99 = ms(h.quantile(0.99))
+		r.LatencyP999 = ms(h.quantile(0.999))
+		r.LatencyMax = ms(h.max)
+	}
+	stats.mu.Unlock()
+	writeRecord(config, r)
+}
+
+// writeRecord writes r to the -output stream.
+func writeRecord(config *Config, r StatsRecord) {
+	if err := config.records.Write(r); err != nil {
+		log.Printf("Failed to write stats record: %v", err)
+	}
+}
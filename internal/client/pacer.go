@@ -0,0 +1,379 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Pacing modes selected with -pacing.
+const (
+	// PacingTicker sends on a time.Ticker. Cheap, but limited to about a
+	// million ticks per second, and late ticks are dropped rather than
+	// caught up.
+	PacingTicker = "ticker"
+
+	// PacingPrecise sleeps until shortly before each send and busy-waits
+	// the rest, following an absolute nanosecond schedule. Sends that fall
+	// behind are made at once until the schedule is caught up, so the
+	// offered rate stays exact. Costs some CPU per sender.
+	PacingPrecise = "precise"
+
+	// PacingSpin busy-waits for every send. Lowest jitter; keeps one core
+	// per sender busy.
+	PacingSpin = "spin"
+)
+
+// validPacing reports whether mode is a known pacing mode.
+func validPacing(mode string) bool {
+	switch mode {
+	case PacingTicker, PacingPrecise, PacingSpin:
+		return true
+	}
+	return false
+}
+
+// pacer schedules sends at a fixed rate and records how late each send was
+// against its ideal time. It is used by a single sender goroutine.
+type pacer struct {
+	mode     string
+	interval float64 // nanoseconds between sends
+	spin     time.Duration
+	start    time.Time // time of send 0
+	next     int64     // index of the next send
+	ticker   *time.Ticker
+	errors   sendErrors
+
+	// source, if set, supplies a schedule shared with other processes.
+	source func() *slot
+	slot   *slot
+
+	// rate, if set, gives the send rate at each point since start, and due
+	// is the time of the next send.
+	rate func(elapsed time.Duration) float64
+	due  time.Time
+
+	// hold, if set, returns a time before which nothing is sent.
+	hold func() time.Time
+
+	// schedule, if set, gives the time since start of every send; the
+	// pacer stops after the last.
+	schedule []time.Duration
+}
+
+// slot is one sender's part of a shared schedule: sends are due at
+// start + k*interval for k >= 0.
+type slot struct {
+	start    time.Time
+	interval float64
+}
+
+// newPacer creates a pacer for rate sends per second. spin is how long
+// before each send PacingPrecise stops sleeping and starts busy-waiting.
+func newPacer(mode string, rate float64, spin time.Duration) *pacer {
+	p := &pacer{
+		mode:     mode,
+		interval: float64(time.Second) / rate,
+		spin:     spin,
+		start:    time.Now(),
+	}
+	if mode == PacingTicker {
+		p.ticker = time.NewTicker(tickerInterval(p.interval))
+	}
+	return p
+}
+
+// follow makes the pacer send on the schedule returned by source, which is
+// consulted before every send and may change at any time.
+func (p *pacer) follow(source func() *slot) {
+	p.source = source
+	p.resync()
+}
+
+// resync adopts the current shared schedule if it changed, continuing with
+// its first send that is not in the past.
+func (p *pacer) resync() {
+	s := p.source()
+	if s == p.slot {
+		return
+	}
+	p.slot = s
+	p.start = s.start
+	p.interval = s.interval
+	p.next = 0
+	if behind := time.Since(s.start); behind > 0 {
+		p.next = int64(math.Ceil(float64(behind) / s.interval))
+	}
+	if p.ticker != nil {
+		p.ticker.Reset(tickerInterval(p.interval))
+	}
+}
+
+// ramp makes the pacer send at rate(elapsed) per second instead of a
+// fixed rate. rate must be positive.
+func (p *pacer) ramp(rate func(elapsed time.Duration) float64) {
+	p.rate = rate
+	p.due = p.start
+	p.interval = float64(time.Second) / rate(0)
+	if p.ticker != nil {
+		p.ticker.Reset(tickerInterval(p.interval))
+	}
+}
+
+// replay makes the pacer send at the times of schedule since start,
+// instead of at a rate, and stop after the last send.
+func (p *pacer) replay(start time.Time, schedule []time.Duration) {
+	p.start = start
+	p.schedule = schedule
+	p.next = 0
+	if p.ticker != nil {
+		p.ticker.Stop()
+	}
+}
+
+// rampInterval returns the time from a send at t to the next one,
+// averaging the rate at both ends so steep ramps keep their shape.
+func (p *pacer) rampInterval(t time.Time) time.Duration {
+	r0 := p.rate(t.Sub(p.start))
+	r1 := p.rate(t.Sub(p.start) + time.Duration(float64(time.Second)/r0))
+	return time.Duration(2 * float64(time.Second) / (r0 + r1))
+}
+
+// tickerInterval converts a send interval to a valid ticker period.
+func tickerInterval(interval float64) time.Duration {
+	if d := time.Duration(interval); d >= 1 {
+		return d
+	}
+	return 1
+}
+
+// stop releases the pacer's resources.
+func (p *pacer) stop() {
+	if p.ticker != nil {
+		p.ticker.Stop()
+	}
+}
+
+// wait blocks until the next send is due. It returns false if ctx is done
+// first.
+func (p *pacer) wait(ctx context.Context) bool {
+	if p.source != nil {
+		p.resync()
+	}
+	if p.hold != nil && !p.waitHold(ctx) {
+		return false
+	}
+
+	if p.mode == PacingTicker && p.schedule == nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case t := <-p.ticker.C:
+			if p.rate != nil {
+				// The ticker follows the ramp by being reset to the
+				// current interval after every tick.
+				p.errors.record(time.Since(t))
+				if interval := float64(time.Second) / p.rate(t.Sub(p.start)); tickerInterval(interval) != tickerInterval(p.interval) {
+					p.interval = interval
+					p.ticker.Reset(tickerInterval(interval))
+				}
+				return true
+			}
+			// Measure against the schedule slot the tick belongs to; the
+			// ticker drops ticks it cannot deliver.
+			slot := math.Round(float64(t.Sub(p.start)) / p.interval)
+			p.errors.record(time.Since(p.start.Add(time.Duration(slot * p.interval))))
+			return true
+		}
+	}
+
+	var due time.Time
+	switch {
+	case p.schedule != nil:
+		if p.next == int64(len(p.schedule)) {
+			return false
+		}
+		due = p.start.Add(p.schedule[p.next])
+		p.next++
+	case p.rate != nil:
+		due = p.due
+		p.due = due.Add(p.rampInterval(due))
+	default:
+		due = p.start.Add(time.Duration(float64(p.next) * p.interval))
+		p.next++
+	}
+
+	// A replay schedule is irregular, so ticker pacing sleeps until each
+	// send instead.
+	if p.mode == PacingPrecise || p.mode == PacingTicker && p.schedule != nil {
+		if d := time.Until(due) - p.spin; d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return false
+			case <-timer.C:
+			}
+		}
+	}
+	if !spinUntil(ctx, due) {
+		return false
+	}
+
+	p.errors.record(time.Since(due))
+	return true
+}
+
+// waitHold blocks until the hold is over, then continues the schedule
+// from there instead of catching up. It returns false if ctx is done
+// first.
+func (p *pacer) waitHold(ctx context.Context) bool {
+	until := p.hold()
+	d := time.Until(until)
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	case <-timer.C:
+	}
+	if p.due.Before(until) {
+		p.due = until
+	}
+	if p.ticker != nil {
+		// Drop the tick that came due during the hold.
+		select {
+		case <-p.ticker.C:
+		default:
+		}
+		p.ticker.Reset(tickerInterval(p.interval))
+	}
+	return true
+}
+
+// spinUntil busy-waits until t. It returns false if ctx is done first.
+func spinUntil(ctx context.Context, t time.Time) bool {
+	done := ctx.Done()
+	for i := 0; ; i++ {
+		if !time.Now().Before(t) {
+			return true
+		}
+		if i%1024 == 0 {
+			select {
+			case <-done:
+				return false
+			default:
+			}
+		}
+	}
+}
+
+// sendErrors is a histogram of how late sends were, in nanoseconds, with
+// about 12% resolution. Early sends count as zero.
+type sendErrors struct {
+	count   int64
+	sum     float64
+	max     time.Duration
+	buckets [512]int64
+}
+
+// record adds one send that was d late.
+func (h *sendErrors) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.count++
+	h.sum += float64(d)
+	if d > h.max {
+		h.max = d
+	}
+	h.buckets[errorBucket(uint64(d))]++
+}
+
+// merge adds the sends recorded in o.
+func (h *sendErrors) merge(o *sendErrors) {
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+	for i, n := range o.buckets {
+		h.buckets[i] += n
+	}
+}
+
+// export returns h for sending to another process.
+func (h *sendErrors) export() histogramData {
+	return histogramData{Count: h.count, Sum: h.sum, Max: h.max, Buckets: nonEmpty(h.buckets[:])}
+}
+
+// mergeData adds the sends of a histogram received from another process.
+func (h *sendErrors) mergeData(d histogramData) {
+	h.count += d.Count
+	h.sum += d.Sum
+	h.max = max(h.max, d.Max)
+	d.addBuckets(h.buckets[:])
+}
+
+// mean returns the average lateness.
+func (h *sendErrors) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// quantile returns the lateness below which a fraction q of sends fell.
+func (h *sendErrors) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(h.count))
+	if rank >= h.count {
+		rank = h.count - 1
+	}
+
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			if d := bucketUpper(i); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// summary formats the mean, median, p99 and maximum lateness.
+func (h *sendErrors) summary() string {
+	return fmt.Sprintf("mean %s  p50 %s  p99 %s  max %s",
+		h.mean(), h.quantile(0.50), h.quantile(0.99), h.max)
+}
+
+// errorBucket maps v to a bucket: exact below 8, then 8 buckets per power
+// of two.
+func errorBucket(v uint64) int {
+	if v < 8 {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	sub := int(v>>(uint(exp)-3)) & 7
+	return 8 + (exp-3)*8 + sub
+}
+
+// bucketUpper returns the largest value in bucket i.
+func bucketUpper(i int) time.Duration {
+	if i < 8 {
+		return time.Duration(i)
+	}
+	exp := (i-8)/8 + 3
+	sub := (i - 8) % 8
+	return time.Duration(uint64(8+sub+1)<<(uint(exp)-3) - 1)
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Ramp profiles selected with -ramp.
+const (
+	// RampLinear rises steadily from the start rate to -rate over the run.
+	RampLinear = "linear"
+
+	// RampStep rises in equal steps from the start rate to -rate, holding
+	// each level for an equal share of the run.
+	RampStep = "step"
+
+	// RampSine oscillates between the start rate and -rate, starting at
+	// the start rate.
+	RampSine = "sine"
+)
+
+// rejectionThreshold is the share of sends in a second that must be
+// rejected for rejections to count as begun, so an odd stray error does
+// not.
+const rejectionThreshold = 0.01
+
+// RampConfig describes a rate that changes over the run instead of
+// staying at -rate.
+type RampConfig struct {
+	Profile string        // RampLinear, RampStep or RampSine; empty for a constant rate
+	From    int           // start rate, messages per second
+	Steps   int           // number of levels for RampStep
+	Period  time.Duration // wave period for RampSine; 0 means the run duration
+}
+
+// validRamp reports whether profile is a known ramp profile or empty.
+func validRamp(profile string) bool {
+	switch profile {
+	case "", RampLinear, RampStep, RampSine:
+		return true
+	}
+	return false
+}
+
+// rampRate returns the rate profile of a run: the offered rate, in
+// messages per second, at each point of the run. It returns nil if config
+// has no ramp.
+func rampRate(config *Config) func(elapsed time.Duration) float64 {
+	ramp := config.Ramp
+	from, to := float64(ramp.From), float64(config.Rate)
+	duration := config.Duration
+	if ramp.Profile == "" || duration <= 0 {
+		return nil
+	}
+
+	switch ramp.Profile {
+	case RampLinear:
+		return func(elapsed time.Duration) float64 {
+			return from + (to-from)*progress(elapsed, duration)
+		}
+	case RampStep:
+		steps := ramp.Steps
+		return func(elapsed time.Duration) float64 {
+			if steps <= 1 {
+				return to
+			}
+			level := int(progress(elapsed, duration) * float64(steps))
+			if level >= steps {
+				level = steps - 1
+			}
+			return from + (to-from)*float64(level)/float64(steps-1)
+		}
+	case RampSine:
+		period := rampPeriod(config)
+		return func(elapsed time.Duration) float64 {
+			phase := 2 * math.Pi * float64(elapsed) / float64(period)
+			return from + (to-from)*(1-math.Cos(phase))/2
+		}
+	}
+	return nil
+}
+
+// rampPeriod returns the wave period of a sine ramp.
+func rampPeriod(config *Config) time.Duration {
+	if config.Ramp.Period > 0 {
+		return config.Ramp.Period
+	}
+	return config.Duration
+}
+
+// progress returns how far elapsed is through duration, from 0 to 1.
+func progress(elapsed, duration time.Duration) float64 {
+	return math.Max(0, math.Min(1, float64(elapsed)/float64(duration)))
+}
+
+// describeRamp formats the ramp of config for the startup banner.
+func describeRamp(config *Config) string {
+	ramp := config.Ramp
+	desc := fmt.Sprintf("%s from %d to %d messages/second", ramp.Profile, ramp.From, config.Rate)
+	switch ramp.Profile {
+	case RampStep:
+		desc += fmt.Sprintf(" in %d steps", ramp.Steps)
+	case RampSine:
+		desc += fmt.Sprintf(", period %s", rampPeriod(config))
+	}
+	return desc
+}
+
+// TimelinePoint is one second of a ramped run.
+type TimelinePoint struct {
+	Elapsed     Duration `json:"elapsed"`      // start of the second
+	OfferedRate float64  `json:"offered_rate"` // profile rate in the middle of the second
+	ActualRate  float64  `json:"actual_rate"`
+	Sent        int64    `json:"sent"`
+	Rejected    int64    `json:"rejected"` // failed or rate limited
+}
+
+// sampleTimeline records a TimelinePoint for every second of a ramped
+// run until ctx is done. A partial second at the end is dropped.
+func sampleTimeline(ctx context.Context, rate func(time.Duration) float64, stats *Stats) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := stats.StartTime
+	var lastSent, lastRejected int64
+	sample := func(now time.Time) {
+		sent := atomic.LoadInt64(&stats.Sent)
+		rejected := atomic.LoadInt64(&stats.Failed) + atomic.LoadInt64(&stats.RateLimited)
+		elapsed := last.Sub(stats.StartTime)
+		interval := now.Sub(last)
+
+		stats.mu.Lock()
+		stats.timeline = append(stats.timeline, TimelinePoint{
+			Elapsed:     Duration{elapsed.Round(time.Millisecond)},
+			OfferedRate: rate(elapsed + interval/2),
+			ActualRate:  float64(sent-lastSent) / interval.Seconds(),
+			Sent:        sent - lastSent,
+			Rejected:    rejected - lastRejected,
+		})
+		stats.mu.Unlock()
+		last, lastSent, lastRejected = now, sent, rejected
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The run usually ends together with the last tick.
+			if now := time.Now(); now.Sub(last) >= 900*time.Millisecond {
+				sample(now)
+			}
+			return
+		case now := <-ticker.C:
+			sample(now)
+		}
+	}
+}
+
+// Rejection records where a ramped run started being rejected: the first
+// second in which at least rejectionThreshold of sends were rejected.
+type Rejection struct {
+	Elapsed     Duration `json:"elapsed"`
+	OfferedRate float64  `json:"offered_rate"`
+	ActualRate  float64  `json:"actual_rate"`
+}
+
+// firstRejection returns where rejections began in timeline, or nil if
+// they never did.
+func firstRejection(timeline []TimelinePoint) *Rejection {
+	for _, p := range timeline {
+		if p.Sent > 0 && float64(p.Rejected) >= rejectionThreshold*float64(p.Sent) {
+			return &Rejection{
+				Elapsed:     p.Elapsed,
+				OfferedRate: p.OfferedRate,
+				ActualRate:  p.ActualRate,
+			}
+		}
+	}
+	return nil
+}
+
+// describeRejection formats where rejections began in timeline.
+func describeRejection(timeline []TimelinePoint) string {
+	r := firstRejection(timeline)
+	if r == nil {
+		peak := 0.0
+		for _, p := range timeline {
+			peak = math.Max(peak, p.ActualRate)
+		}
+		return fmt.Sprintf("none, up to %.2f messages/second", peak)
+	}
+	return fmt.Sprintf("began %s into the run at %.2f messages/second (offered %.2f)",
+		r.Elapsed, r.ActualRate, r.OfferedRate)
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access log formats read by -replay.
+const (
+	ReplayAuto = "auto" // by extension, then by the first line
+	ReplayCLF  = "clf"  // Common or Combined Log Format, keyed by client host
+	ReplayCSV  = "csv"  // timestamp,key,path
+)
+
+// clfTime is the timestamp layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// clfLine matches the host, timestamp and request of a Common or Combined
+// Log Format line.
+var clfLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "([^"]*)"`)
+
+// ReplayConfig replays a recorded access log instead of sending at -rate.
+type ReplayConfig struct {
+	File   string
+	Format string
+	Speed  float64 // 2 replays twice as fast
+}
+
+// replayEntry is one request of a replayed log.
+type replayEntry struct {
+	at     time.Duration // since the first request
+	user   int           // index of the key in replayLog.keys
+	method string        // empty to use -method
+	path   string        // empty to use -path
+}
+
+// replayLog is a loaded access log. Each distinct key is replayed as one
+// simulated user.
+type replayLog struct {
+	format  string
+	entries []replayEntry
+	keys    []string
+	users   map[string]int
+}
+
+// ReplayMain runs rrl replay: args are the access log followed by the
+// flags of the client, which replays the log as with -replay. The client
+// section of the config file applies.
+func ReplayMain(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "Usage: rrl replay LOG [client flags]\n\nRun 'rrl client run -h' for the client flags.\n")
+		os.Exit(2)
+	}
+	Main(append([]string{"-replay", args[0]}, args[1:]...))
+}
+
+// loadReplay reads and parses the log of config, ordered by time.
+func loadReplay(config ReplayConfig) (*replayLog, error) {
+	file, err := os.Open(config.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 64*1024)
+
+	format := config.Format
+	if format == ReplayAuto {
+		format = ReplayCSV
+		if !strings.EqualFold(filepath.Ext(config.File), ".csv") {
+			first, err := firstLine(r)
+			if err != nil {
+				return nil, err
+			}
+			if clfLine.MatchString(first) {
+				format = ReplayCLF
+			}
+		}
+	}
+
+	l := &replayLog{format: format, users: map[string]int{}}
+	var times []time.Time
+	add := func(t time.Time, key, method, path string) {
+		user, ok := l.users[key]
+		if !ok {
+			user = len(l.keys)
+			l.users[key] = user
+			l.keys = append(l.keys, key)
+		}
+		times = append(times, t)
+		l.entries = append(l.entries, replayEntry{user: user, method: method, path: path})
+	}
+	switch format {
+	case ReplayCLF:
+		err = readCLF(r, add)
+	case ReplayCSV:
+		err = readReplayCSV(r, add)
+	default:
+		return nil, fmt.Errorf("invalid format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(l.entries) == 0 {
+		return nil, errors.New("no requests in the log")
+	}
+
+	first := times[0]
+	for _, t := range times {
+		if t.Before(first) {
+			first = t
+		}
+	}
+	for i, t := range times {
+		l.entries[i].at = t.Sub(first)
+	}
+	sort.SliceStable(l.entries, func(i, j int) bool { return l.entries[i].at < l.entries[j].at })
+	if format == ReplayCLF {
+		spreadSeconds(l.entries)
+	}
+	return l, nil
+}
+
+// spreadSeconds spreads requests logged in the same second evenly over
+// it. Common Log Format times have one-second resolution, and replaying
+// them as logged would send a burst at the start of every second.
+func spreadSeconds(entries []replayEntry) {
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && entries[j].at == entries[i].at {
+			j++
+		}
+		for k := i + 1; k < j; k++ {
+			entries[k].at += time.Duration(k-i) * time.Second / time.Duration(j-i)
+		}
+		i = j
+	}
+}
+
+// firstLine returns the first line of r that is not blank or a comment,
+// looking no further than r's buffer and without consuming it.
+func firstLine(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(r.Size())
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+	return "", nil
+}
+
+// readCLF reads Common or Combined Log Format lines, each a request by the
+// client host.
+func readCLF(r io.Reader, add func(t time.Time, key, method, path string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := clfLine.FindStringSubmatch(line)
+		if m == nil {
+			return fmt.Errorf("line %d: not in Common Log Format", n)
+		}
+		t, err := time.Parse(clfTime, m[2])
+		if err != nil {
+			return fmt.Errorf("line %d: invalid time %q", n, m[2])
+		}
+		// Requests the server could not parse are logged as "-".
+		var method, path string
+		if request := strings.Fields(m[3]); len(request) >= 2 {
+			method, path = request[0], request[1]
+		}
+		add(t, m[1], method, path)
+	}
+	return scanner.Err()
+}
+
+// readReplayCSV reads timestamp,key,path rows, skipping a header row. The
+// timestamp is in seconds, such as a Unix time, or RFC 3339; key and path
+// may be left out.
+func readReplayCSV(r io.Reader, add func(t time.Time, key, method, path string)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+		t, ok := parseReplayTime(row[0])
+		if !ok {
+			if first {
+				continue // header
+			}
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %d: invalid timestamp %q", line, row[0])
+		}
+		var key, path string
+		if len(row) > 1 {
+			key = row[1]
+		}
+		if len(row) > 2 {
+			path = row[2]
+		}
+		add(t, key, "", path)
+	}
+}
+
+// parseReplayTime parses a CSV timestamp.
+func parseReplayTime(s string) (time.Time, bool) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), true
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// span returns the time from the first request to the last.
+func (l *replayLog) span() time.Duration {
+	return l.entries[len(l.entries)-1].at
+}
+
+// part returns sender's share of the requests, every senders-th.
+func (l *replayLog) part(sender, senders int) []replayEntry {
+	part := make([]replayEntry, 0, len(l.entries)/senders+1)
+	for i := sender; i < len(l.entries); i += senders {
+		part = append(part, l.entries[i])
+	}
+	return part
+}
+
+// schedule returns when each request of sender's part is due, replayed at
+// speed.
+func (l *replayLog) schedule(sender, senders int, speed float64) []time.Duration {
+	part := l.part(sender, senders)
+	schedule := make([]time.Duration, len(part))
+	for i, e := range part {
+		schedule[i] = time.Duration(float64(e.at) / speed)
+	}
+	return schedule
+}
+
+// describeReplay describes the replayed log for the run banner.
+func describeReplay(config *Config) string {
+	l := config.replay
+	return fmt.Sprintf("%s (%s, %d requests from %d keys over %s) at %gx speed",
+		config.Replay.File, l.format, len(l.entries), len(l.keys), l.span().Round(time.Millisecond), config.Replay.Speed)
+}
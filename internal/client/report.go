@@ -0,0 +1,274 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// Labels are user-supplied key=value pairs describing a test run, such as
+// the git SHA or environment under test. They are printed at startup and
+// embedded in every report file, so results can be correlated with what
+// was tested later.
+type Labels map[string]string
+
+// String implements flag.Value.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + l[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value. It accepts key=value; a value of @path is
+// replaced by the SHA-256 of the file at path, e.g. to record the hash of
+// the server's limiter config.
+func (l Labels) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("label %q is not key=value", s)
+	}
+
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("label %s: %w", key, err)
+		}
+		sum := sha256.Sum256(data)
+		value = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	l[key] = value
+	return nil
+}
+
+// RunReport is the machine-readable record of a test run written with
+// -report.
+type RunReport struct {
+	Labels    Labels       `json:"labels,omitempty"`
+	Host      string       `json:"host"`
+	StartedAt time.Time    `json:"started_at"`
+	Config    ReportConfig `json:"config"`
+
+	// Result is set for single runs and Phases for scenarios.
+	Result *RunResult    `json:"result,omitempty"`
+	Phases []PhaseReport `json:"phases,omitempty"`
+}
+
+// ReportConfig is the client configuration a run used.
+type ReportConfig struct {
+	Server         string      `json:"server"`
+	Protocol       string      `json:"protocol"`
+	Rate           int         `json:"rate"`
+	Duration       Duration    `json:"duration"`
+	Connections    int         `json:"connections"`
+	MessageSize    int         `json:"size"`
+	Pacing         string      `json:"pacing"`
+	Scenario       string      `json:"scenario,omitempty"`
+	Method         string      `json:"method,omitempty"`
+	Path           string      `json:"path,omitempty"`
+	Ramp           *RampReport `json:"ramp,omitempty"`
+	Adaptive       bool        `json:"adaptive,omitempty"`
+	TLS            bool        `json:"tls,omitempty"`
+	ReconnectEvery int         `json:"reconnect_every,omitempty"`
+	GRPCMode       string      `json:"grpc_mode,omitempty"`
+	GRPCDeadline   *Duration   `json:"grpc_deadline,omitempty"`
+	Agents         []string    `json:"agents,omitempty"`
+	Users          *UserReport `json:"users,omitempty"`
+	Replay         string      `json:"replay,omitempty"`
+	ReplaySpeed    float64     `json:"replay_speed,omitempty"`
+}
+
+// UserReport is the simulated users of a run.
+type UserReport struct {
+	Count        int     `json:"count"`
+	Distribution string  `json:"distribution"`
+	ZipfS        float64 `json:"zipf_s,omitempty"`
+	Via          string  `json:"via"`
+	Header       string  `json:"header,omitempty"`
+}
+
+// RampReport is the ramp a run used.
+type RampReport struct {
+	Profile string    `json:"profile"`
+	From    int       `json:"from"`
+	Steps   int       `json:"steps,omitempty"`
+	Period  *Duration `json:"period,omitempty"`
+}
+
+// RunResult is the outcome of a run or phase.
+type RunResult struct {
+	Elapsed     Duration          `json:"elapsed"`
+	Sent        int64             `json:"sent"`
+	Succeeded   int64             `json:"succeeded"`
+	Failed      int64             `json:"failed"`
+	RateLimited int64             `json:"rate_limited,omitempty"`
+	StatusCodes map[int]int64     `json:"status_codes,omitempty"`
+	GRPCStatus  map[string]int64  `json:"grpc_status,omitempty"`
+	SuccessRate float64           `json:"success_rate"`
+	ActualRate  float64           `json:"actual_rate"`
+	Latency     *LatencySummary   `json:"latency,omitempty"`
+	Connect     *LatencySummary   `json:"connect,omitempty"`
+	Handshake   *LatencySummary   `json:"tls_handshake,omitempty"`
+	Delivery    *Delivery         `json:"delivery,omitempty"`
+	Timeline    []TimelinePoint   `json:"timeline,omitempty"`
+	Rejection   *Rejection        `json:"first_rejection,omitempty"`
+	Adaptive    *AdaptiveSummary  `json:"adaptive,omitempty"`
+	SendError   *SendErrorSummary `json:"send_error,omitempty"`
+	Users       *UserSummary      `json:"users,omitempty"`
+}
+
+// SendErrorSummary summarizes how late sends were against the schedule.
+type SendErrorSummary struct {
+	Mean Duration `json:"mean"`
+	P50  Duration `json:"p50"`
+	P99  Duration `json:"p99"`
+	Max  Duration `json:"max"`
+}
+
+// PhaseReport is the outcome of one scenario phase.
+type PhaseReport struct {
+	Name       string            `json:"name"`
+	Result     RunResult         `json:"result"`
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+	Passed     bool              `json:"passed"`
+}
+
+// newRunReport starts a report for config.
+func newRunReport(config *Config, startedAt time.Time) *RunReport {
+	host, _ := os.Hostname()
+	report := &RunReport{
+		Labels:    config.Labels,
+		Host:      host,
+		StartedAt: startedAt,
+		Config: ReportConfig{
+			Server:         config.ServerAddr,
+			Protocol:       config.Protocol,
+			Rate:           config.Rate,
+			Duration:       Duration{config.Duration},
+			Connections:    config.Connections,
+			MessageSize:    config.MessageSize,
+			Pacing:         config.Pacing,
+			Scenario:       config.ScenarioFile,
+			TLS:            config.tls != nil,
+			ReconnectEvery: config.ReconnectEvery,
+			Adaptive:       config.Adaptive.Enabled,
+			Agents:         config.Agents.Addrs,
+		},
+	}
+	if ramp := config.Ramp; ramp.Profile != "" {
+		report.Config.Ramp = &RampReport{Profile: ramp.Profile, From: ramp.From}
+		switch ramp.Profile {
+		case RampStep:
+			report.Config.Ramp.Steps = ramp.Steps
+		case RampSine:
+			report.Config.Ramp.Period = &Duration{rampPeriod(config)}
+		}
+	}
+	if config.replay != nil {
+		report.Config.Replay = config.Replay.File
+		report.Config.ReplaySpeed = config.Replay.Speed
+	}
+	if users := config.Users; users.Count > 0 {
+		report.Config.Users = &UserReport{Count: users.Count, Distribution: users.Distribution, Via: users.Via}
+		if users.Distribution == UserZipf {
+			report.Config.Users.ZipfS = users.ZipfS
+		}
+		if users.Via == UserViaHeader {
+			report.Config.Users.Header = users.Header
+		}
+	}
+	if config.Protocol == "http" {
+		report.Config.Method = config.HTTP.Method
+		report.Config.Path = config.HTTP.Path
+	}
+	if config.Protocol == "grpc" {
+		report.Config.GRPCMode = config.GRPC.Mode
+		if config.GRPC.Deadline > 0 {
+			report.Config.GRPCDeadline = &Duration{config.GRPC.Deadline}
+		}
+	}
+	return report
+}
+
+// runResult summarizes stats collected over elapsed.
+func runResult(stats *Stats, elapsed time.Duration) RunResult {
+	sent := atomic.LoadInt64(&stats.Sent)
+	succeeded := atomic.LoadInt64(&stats.Succeeded)
+
+	result := RunResult{
+		Elapsed:     Duration{elapsed},
+		Sent:        sent,
+		Succeeded:   succeeded,
+		Failed:      atomic.LoadInt64(&stats.Failed),
+		RateLimited: atomic.LoadInt64(&stats.RateLimited),
+		StatusCodes: stats.statusCodes.snapshot(),
+		GRPCStatus:  grpcStatusCounts(stats.grpcCodes.snapshot()),
+		SuccessRate: percentage(succeeded, sent),
+		ActualRate:  float64(sent) / elapsed.Seconds(),
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	result.Latency = latencySummary(&stats.latency)
+	result.Connect = latencySummary(&stats.connect)
+	result.Handshake = latencySummary(&stats.handshake)
+	result.Delivery = stats.delivery
+	result.Timeline = stats.timeline
+	result.Rejection = firstRejection(stats.timeline)
+	result.Adaptive = adaptiveSummary(stats.adaptive, stats.timeline)
+	result.Users = userSummary(stats.users)
+	if h := &stats.sendError; h.count > 0 {
+		result.SendError = &SendErrorSummary{
+			Mean: Duration{h.mean()},
+			P50:  Duration{h.quantile(0.50)},
+			P99:  Duration{h.quantile(0.99)},
+			Max:  Duration{h.max},
+		}
+	}
+	return result
+}
+
+// addPhases records the results of a scenario.
+func (r *RunReport) addPhases(results []*PhaseResult) {
+	for _, pr := range results {
+		r.Phases = append(r.Phases, PhaseReport{
+			Name:       pr.Phase.Name,
+			Result:     runResult(pr.Stats, pr.Elapsed),
+			Assertions: pr.Assertions,
+			Passed:     pr.Passed(),
+		})
+	}
+}
+
+// writeReport writes r as indented JSON to path, or to stdout if path is
+// "-".
+func writeReport(path string, r *RunReport) error {
+	return cli.WriteJSON(path, r, 0o644)
+}
+
+// grpcStatusCounts keys gRPC status counts by code name.
+func grpcStatusCounts(counts map[int]int64) map[string]int64 {
+	if counts == nil {
+		return nil
+	}
+	named := make(map[string]int64, len(counts))
+	for code, n := range counts {
+		named[wire.GRPCCodeName(code)] = n
+	}
+	return named
+}
@@ -0,0 +1,367 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/wire"
+	"github.com/rRateLimit/client/internal/yaml"
+)
+
+// Scenario describes a multi-phase load test loaded from a JSON or YAML
+// file.
+type Scenario struct {
+	Name   string  `json:"name"`
+	Phases []Phase `json:"phases"`
+}
+
+// Phase is a single step of a scenario. Zero-valued fields inherit the
+// corresponding command-line flag.
+type Phase struct {
+	Name        string       `json:"name"`
+	Rate        int          `json:"rate"`
+	Duration    Duration     `json:"duration"`
+	Connections int          `json:"connections"`
+	MessageSize int          `json:"size"`
+	Protocol    string       `json:"protocol,omitempty"`
+	Ramp        *PhaseRamp   `json:"ramp,omitempty"`
+	HTTP        *PhaseHTTP   `json:"http,omitempty"`
+	Expect      *Expectation `json:"expect,omitempty"`
+}
+
+// PhaseRamp ramps the rate of a phase from From to the phase rate, like
+// -ramp. A zero Steps inherits -ramp-steps.
+type PhaseRamp struct {
+	Profile string   `json:"profile"`
+	From    int      `json:"from"`
+	Steps   int      `json:"steps,omitempty"`
+	Period  Duration `json:"period"`
+}
+
+// PhaseHTTP overrides the HTTP request of a phase. Headers are added to
+// those given with -header, replacing any of the same name.
+type PhaseHTTP struct {
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Timeout Duration          `json:"timeout"`
+}
+
+// Expectation declares the outcome a phase must produce to pass.
+// Ratios are percentages of sent messages; nil fields are not checked.
+type Expectation struct {
+	MinSuccessRatio *float64 `json:"min_success_ratio,omitempty"`
+	MaxSuccessRatio *float64 `json:"max_success_ratio,omitempty"`
+	MinFailureRatio *float64 `json:"min_failure_ratio,omitempty"`
+	MaxFailureRatio *float64 `json:"max_failure_ratio,omitempty"`
+	MinActualRate   *float64 `json:"min_actual_rate,omitempty"`
+
+	// Rate limited ratios count HTTP 429 responses, gRPC RESOURCE_EXHAUSTED
+	// statuses and messages the test server's limiter rejected.
+	MinRateLimitedRatio *float64 `json:"min_rate_limited_ratio,omitempty"`
+	MaxRateLimitedRatio *float64 `json:"max_rate_limited_ratio,omitempty"`
+}
+
+// Duration wraps time.Duration so it can be written as "30s" in scenario files.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON accepts either a duration string or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	d.Duration = time.Duration(n)
+	return nil
+}
+
+// MarshalJSON writes the duration in its string form.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// AssertionResult is the outcome of checking one expectation.
+type AssertionResult struct {
+	Description string  `json:"description"`
+	Actual      float64 `json:"actual"`
+	Passed      bool    `json:"passed"`
+}
+
+// PhaseResult holds the statistics and assertion outcomes of a finished phase.
+type PhaseResult struct {
+	Phase      Phase
+	Stats      *Stats
+	Elapsed    time.Duration
+	Assertions []AssertionResult
+}
+
+// Passed reports whether every assertion of the phase passed.
+func (pr *PhaseResult) Passed() bool {
+	for _, a := range pr.Assertions {
+		if !a.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// loadScenario reads and validates a scenario file. Files ending in .yaml
+// or .yml are read as YAML, others as JSON.
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	unmarshal := json.Unmarshal
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		unmarshal = yaml.Unmarshal
+	}
+	var scenario Scenario
+	if err := unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+
+	if len(scenario.Phases) == 0 {
+		return nil, fmt.Errorf("scenario %s has no phases", path)
+	}
+	for i, phase := range scenario.Phases {
+		if phase.Name == "" {
+			scenario.Phases[i].Name = fmt.Sprintf("phase-%d", i+1)
+		}
+		if phase.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("phase %d (%s): duration must be positive", i, phase.Name)
+		}
+		if phase.Protocol != "" && !validProtocol(phase.Protocol) {
+			return nil, fmt.Errorf("phase %d (%s): invalid protocol %q", i, phase.Name, phase.Protocol)
+		}
+		if phase.Ramp != nil && (phase.Ramp.Profile == "" || !validRamp(phase.Ramp.Profile)) {
+			return nil, fmt.Errorf("phase %d (%s): invalid ramp profile %q", i, phase.Name, phase.Ramp.Profile)
+		}
+	}
+
+	return &scenario, nil
+}
+
+// phaseConfig returns a copy of base with the phase overrides applied.
+func phaseConfig(base *Config, phase Phase) *Config {
+	config := *base
+	config.phase = phase.Name
+	config.Duration = phase.Duration.Duration
+	if phase.Rate > 0 {
+		config.Rate = phase.Rate
+	}
+	if phase.Connections > 0 {
+		config.Connections = phase.Connections
+	}
+	if phase.MessageSize > 0 {
+		config.MessageSize = phase.MessageSize
+	}
+	if phase.Protocol != "" {
+		config.Protocol = phase.Protocol
+	}
+	if r := phase.Ramp; r != nil {
+		config.Ramp = RampConfig{Profile: r.Profile, From: r.From, Steps: r.Steps, Period: r.Period.Duration}
+		if r.Steps <= 0 {
+			config.Ramp.Steps = base.Ramp.Steps
+		}
+	}
+	if h := phase.HTTP; h != nil {
+		if h.Method != "" {
+			config.HTTP.Method = h.Method
+		}
+		if h.Path != "" {
+			config.HTTP.Path = h.Path
+		}
+		if len(h.Headers) > 0 {
+			config.HTTP.Headers = Headers(http.Header(base.HTTP.Headers).Clone())
+			if config.HTTP.Headers == nil {
+				config.HTTP.Headers = Headers{}
+			}
+			for name, value := range h.Headers {
+				http.Header(config.HTTP.Headers).Set(name, value)
+			}
+		}
+		if h.Body != "" {
+			config.HTTP.Body = h.Body
+		}
+		if h.Timeout.Duration > 0 {
+			config.HTTP.Timeout = h.Timeout.Duration
+		}
+	}
+	return &config
+}
+
+// runScenario executes each phase in order and evaluates its expectations.
+// Cancelling ctx ends the phase running and skips the rest.
+func runScenario(ctx context.Context, scenario *Scenario, base *Config) []*PhaseResult {
+	results := make([]*PhaseResult, 0, len(scenario.Phases))
+
+	for _, phase := range scenario.Phases {
+		if ctx.Err() != nil {
+			break
+		}
+		config := phaseConfig(base, phase)
+
+		rate := fmt.Sprintf("%d messages/second", config.Rate)
+		if config.Ramp.Profile != "" {
+			rate = describeRamp(config)
+		}
+		fmt.Fprintf(console, "=== Phase %s: %s %s for %s ===\n",
+			phase.Name, config.Protocol, rate, config.Duration)
+
+		stats := &Stats{StartTime: time.Now()}
+		phaseCtx, cancel := context.WithTimeout(ctx, config.Duration)
+		runTest(phaseCtx, config, stats)
+		cancel()
+
+		result := &PhaseResult{
+			Phase:   phase,
+			Stats:   stats,
+			Elapsed: time.Since(stats.StartTime),
+		}
+		writeFinal(config, stats, result.Elapsed)
+		result.Assertions = evaluateExpectation(phase.Expect, stats, result.Elapsed)
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// evaluateExpectation checks the phase statistics against its expectation.
+func evaluateExpectation(expect *Expectation, stats *Stats, elapsed time.Duration) []AssertionResult {
+	if expect == nil {
+		return nil
+	}
+
+	sent := atomic.LoadInt64(&stats.Sent)
+	succeeded := atomic.LoadInt64(&stats.Succeeded)
+	failed := atomic.LoadInt64(&stats.Failed)
+
+	successRatio := percentage(succeeded, sent)
+	failureRatio := percentage(failed, sent)
+	limitedRatio := percentage(atomic.LoadInt64(&stats.RateLimited), sent)
+	actualRate := float64(sent) / elapsed.Seconds()
+
+	var results []AssertionResult
+	check := func(bound *float64, actual float64, atLeast bool, label string) {
+		if bound == nil {
+			return
+		}
+		op, passed := "<=", actual <= *bound
+		if atLeast {
+			op, passed = ">=", actual >= *bound
+		}
+		results = append(results, AssertionResult{
+			Description: fmt.Sprintf("%s %s %.2f", label, op, *bound),
+			Actual:      actual,
+			Passed:      passed,
+		})
+	}
+
+	check(expect.MinSuccessRatio, successRatio, true, "success ratio %")
+	check(expect.MaxSuccessRatio, successRatio, false, "success ratio %")
+	check(expect.MinFailureRatio, failureRatio, true, "failure ratio %")
+	check(expect.MaxFailureRatio, failureRatio, false, "failure ratio %")
+	check(expect.MinRateLimitedRatio, limitedRatio, true, "rate limited ratio %")
+	check(expect.MaxRateLimitedRatio, limitedRatio, false, "rate limited ratio %")
+	check(expect.MinActualRate, actualRate, true, "actual rate msg/s")
+
+	return results
+}
+
+// percentage returns part/total*100, or 0 when total is zero.
+func percentage(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// printScenarioReport prints per-phase statistics and assertion outcomes.
+// It returns false if any assertion failed.
+func printScenarioReport(scenario *Scenario, results []*PhaseResult) bool {
+	allPassed := true
+
+	fmt.Fprintf(console, "\n--- Scenario Report: %s ---\n", scenario.Name)
+	for _, result := range results {
+		sent := atomic.LoadInt64(&result.Stats.Sent)
+		succeeded := atomic.LoadInt64(&result.Stats.Succeeded)
+		failed := atomic.LoadInt64(&result.Stats.Failed)
+
+		status := "PASS"
+		if len(result.Assertions) == 0 {
+			status = "N/A"
+		} else if !result.Passed() {
+			status = "FAIL"
+			allPassed = false
+		}
+
+		fmt.Fprintf(console, "\n[%s] %s (%s)\n", status, result.Phase.Name, result.Elapsed.Round(time.Millisecond))
+		fmt.Fprintf(console, "  Sent: %d, Succeeded: %d, Failed: %d, Success rate: %.2f%%\n",
+			sent, succeeded, failed, percentage(succeeded, sent))
+		if codes := result.Stats.statusCodes.snapshot(); codes != nil {
+			fmt.Fprintf(console, "  Rate limited: %d, Status codes: %s\n",
+				atomic.LoadInt64(&result.Stats.RateLimited), result.Stats.statusCodes.summary())
+		} else if codes := result.Stats.grpcCodes.snapshot(); codes != nil {
+			fmt.Fprintf(console, "  Rate limited: %d, gRPC status: %s\n",
+				atomic.LoadInt64(&result.Stats.RateLimited), result.Stats.grpcCodes.format(wire.GRPCCodeName))
+		} else if limited := atomic.LoadInt64(&result.Stats.RateLimited); limited > 0 {
+			fmt.Fprintf(console, "  Rate limited: %d\n", limited)
+		}
+		if result.Stats.latency.count > 0 {
+			fmt.Fprintf(console, "  Latency: %s\n", result.Stats.latency.summary())
+		}
+		if h := &result.Stats.handshake; h.count > 0 {
+			fmt.Fprintf(console, "  TLS handshake: %s\n", h.summary())
+		}
+		if result.Stats.delivery != nil {
+			fmt.Fprintf(console, "  Delivery: %s\n", describeDelivery(result.Stats.delivery))
+		}
+		if result.Stats.timeline != nil {
+			fmt.Fprintf(console, "  Rejections: %s\n", describeRejection(result.Stats.timeline))
+		}
+		if s := adaptiveSummary(result.Stats.adaptive, result.Stats.timeline); s != nil {
+			fmt.Fprintf(console, "  Adaptive: %s\n", describeAdaptive(s))
+		}
+		if s := userSummary(result.Stats.users); s != nil {
+			fmt.Fprintf(console, "  Users: %s\n", describeUserSummary(s))
+		}
+		if result.Stats.sendError.count > 0 {
+			fmt.Fprintf(console, "  Send-time error: %s\n", result.Stats.sendError.summary())
+		}
+		for _, a := range result.Assertions {
+			mark := "ok"
+			if !a.Passed {
+				mark = "FAILED"
+			}
+			fmt.Fprintf(console, "  - %-30s actual %.2f  %s\n", a.Description, a.Actual, mark)
+		}
+	}
+
+	if allPassed {
+		fmt.Fprintln(console, "\nAll phase assertions passed")
+	} else {
+		fmt.Fprintln(console, "\nSome phase assertions failed")
+	}
+	return allPassed
+}
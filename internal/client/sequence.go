@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// udpDrain is how long the UDP receiver keeps reading after the run ends,
+// so replies still in flight are not counted as lost.
+const udpDrain = 500 * time.Millisecond
+
+// Delivery accounts for UDP replies by the sequence numbers the client
+// embeds in each message.
+type Delivery struct {
+	Received   int64 `json:"received"`   // distinct messages that got a reply
+	Lost       int64 `json:"lost"`       // messages written that never got one
+	Duplicated int64 `json:"duplicated"` // replies to a message already answered
+	Reordered  int64 `json:"reordered"`  // replies arriving after a later message's
+}
+
+// sequenceTracker matches replies to the sequence numbers of the messages
+// sent. It is used by one receiver goroutine.
+type sequenceTracker struct {
+	seen       []uint64 // bitmap of sequence numbers replied to
+	received   int64
+	duplicated int64
+	reordered  int64
+	next       uint64 // one past the highest sequence number replied to
+}
+
+// observe records a reply to message seq and reports whether it is the
+// first. issued is the number of sequence numbers handed out so far;
+// replies claiming a later one are ignored, so a stray datagram cannot grow
+// the bitmap.
+func (t *sequenceTracker) observe(seq, issued uint64) bool {
+	if seq >= issued {
+		return false
+	}
+	word, bit := seq/64, uint64(1)<<(seq%64)
+	for uint64(len(t.seen)) <= word {
+		t.seen = append(t.seen, 0)
+	}
+	if t.seen[word]&bit != 0 {
+		t.duplicated++
+		return false
+	}
+	t.seen[word] |= bit
+	t.received++
+	if seq < t.next {
+		t.reordered++
+	} else {
+		t.next = seq + 1
+	}
+	return true
+}
+
+// delivery summarizes the replies to written messages.
+func (t *sequenceTracker) delivery(written int64) *Delivery {
+	return &Delivery{
+		Received:   t.received,
+		Lost:       max(written-t.received, 0),
+		Duplicated: t.duplicated,
+		Reordered:  t.reordered,
+	}
+}
+
+// describeDelivery formats d for the stats output.
+func describeDelivery(d *Delivery) string {
+	return fmt.Sprintf("%d received, %d lost (%.2f%%), %d duplicated, %d reordered",
+		d.Received, d.Lost, percentage(d.Lost, d.Received+d.Lost), d.Duplicated, d.Reordered)
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// TLSConfig selects TLS for TCP and HTTP runs.
+type TLSConfig struct {
+	Enabled    bool
+	CAFile     string // verifies the server; default the system roots
+	CertFile   string // client certificate for mutual TLS
+	KeyFile    string
+	ServerName string // default the host of -server
+	Insecure   bool   // skip verifying the server, e.g. a self-signed one
+}
+
+// clientTLS returns the tls.Config for connecting to addr, or nil if TLS
+// is off.
+func clientTLS(config TLSConfig, addr string) (*tls.Config, error) {
+	if !config.Enabled {
+		if config.CAFile != "" || config.CertFile != "" || config.KeyFile != "" || config.ServerName != "" || config.Insecure {
+			return nil, fmt.Errorf("-tls-* flags require -tls")
+		}
+		return nil, nil
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+
+	c := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.Insecure,
+	}
+	if c.ServerName == "" {
+		host := addr
+		if _, rest, ok := strings.Cut(host, "://"); ok {
+			host, _, _ = strings.Cut(rest, "/")
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		c.ServerName = host
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", config.CAFile)
+		}
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// describeTLS describes the TLS settings for the run banner.
+func describeTLS(config TLSConfig) string {
+	var parts []string
+	switch {
+	case config.Insecure:
+		parts = append(parts, "server not verified")
+	case config.CAFile != "":
+		parts = append(parts, "server verified against "+config.CAFile)
+	default:
+		parts = append(parts, "server verified against system roots")
+	}
+	if config.CertFile != "" {
+		parts = append(parts, "client certificate "+config.CertFile)
+	}
+	return strings.Join(parts, ", ")
+}
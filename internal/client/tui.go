@@ -0,0 +1,232 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tuiInterval is how often the -tui dashboard is redrawn.
+const tuiInterval = 500 * time.Millisecond
+
+// tuiMaxConnections is how many connections the dashboard lists.
+const tuiMaxConnections = 16
+
+// tuiLogLines is how many log lines are kept while the dashboard is shown.
+const tuiLogLines = 100
+
+// Connection states shown by the dashboard.
+const (
+	connConnecting   = "connecting"
+	connActive       = "active"
+	connReconnecting = "reconnecting"
+	connFailed       = "failed"
+	connDone         = "done"
+)
+
+// liveStats is what the -tui dashboard shows beyond Stats: the latency of
+// the last refresh interval and the state of every connection.
+type liveStats struct {
+	mu      sync.Mutex
+	latency latencyHistogram
+	conns   []*connStatus
+}
+
+func newLiveStats(connections int) *liveStats {
+	l := &liveStats{conns: make([]*connStatus, connections)}
+	for i := range l.conns {
+		l.conns[i] = &connStatus{live: l}
+		l.conns[i].state.Store(connConnecting)
+	}
+	return l
+}
+
+// conn returns the status of connection id. Without a dashboard it returns
+// nil, whose methods do nothing.
+func (l *liveStats) conn(id int) *connStatus {
+	if l == nil || id >= len(l.conns) {
+		return nil
+	}
+	return l.conns[id]
+}
+
+// connStatus is the live state of one connection or sender.
+type connStatus struct {
+	live                                 *liveStats
+	state                                atomic.Value // string
+	sent, succeeded, rateLimited, failed int64
+}
+
+// set records the connection's state.
+func (c *connStatus) set(state string) {
+	if c != nil {
+		c.state.Store(state)
+	}
+}
+
+// send records a message sent.
+func (c *connStatus) send() {
+	if c != nil {
+		atomic.AddInt64(&c.sent, 1)
+	}
+}
+
+// succeed records a message answered after latency.
+func (c *connStatus) succeed(latency time.Duration) {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.succeeded, 1)
+	c.live.mu.Lock()
+	c.live.latency.record(latency)
+	c.live.mu.Unlock()
+}
+
+// limit records a rate limited message.
+func (c *connStatus) limit() {
+	if c != nil {
+		atomic.AddInt64(&c.rateLimited, 1)
+	}
+}
+
+// fail records a failed message.
+func (c *connStatus) fail() {
+	if c != nil {
+		atomic.AddInt64(&c.failed, 1)
+	}
+}
+
+// logRing keeps the last lines logged while the dashboard is shown, so log
+// output does not tear the screen.
+type logRing struct {
+	mu      sync.Mutex
+	lines   []string
+	dropped int
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(r.lines) == tuiLogLines {
+			r.lines = r.lines[1:]
+			r.dropped++
+		}
+		r.lines = append(r.lines, line)
+	}
+	return len(p), nil
+}
+
+// last returns up to n of the most recent lines.
+func (r *logRing) last(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines[max(len(r.lines)-n, 0):]...)
+}
+
+// runDashboard draws the live dashboard on the console until ctx is done,
+// then clears it and replays the log lines it held back.
+func runDashboard(ctx context.Context, config *Config, stats *Stats) {
+	logs := &logRing{}
+	output := log.Writer()
+	log.SetOutput(logs)
+	fmt.Fprint(console, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer func() {
+		fmt.Fprint(console, "\x1b[?25h\x1b[?1049l")
+		log.SetOutput(output)
+		if logs.dropped > 0 {
+			log.Printf("(%d earlier log lines dropped)", logs.dropped)
+		}
+		for _, line := range logs.last(tuiLogLines) {
+			fmt.Fprintln(output, line)
+		}
+	}()
+
+	ticker := time.NewTicker(tuiInterval)
+	defer ticker.Stop()
+	var frame bytes.Buffer
+	last, lastSent := stats.StartTime, int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent := atomic.LoadInt64(&stats.Sent)
+			rate := float64(sent-lastSent) / now.Sub(last).Seconds()
+			last, lastSent = now, sent
+
+			frame.Reset()
+			drawDashboard(&frame, config, stats, now, rate, logs)
+			console.Write(frame.Bytes())
+		}
+	}
+}
+
+// drawDashboard renders one frame of the dashboard to w.
+func drawDashboard(w io.Writer, config *Config, stats *Stats, now time.Time, rate float64, logs *logRing) {
+	const clearLine = "\x1b[K"
+	elapsed := now.Sub(stats.StartTime)
+	fmt.Fprint(w, "\x1b[H") // home
+
+	title := fmt.Sprintf("Rate limit test client: %s %s", config.Protocol, config.ServerAddr)
+	if config.phase != "" {
+		title += ", phase " + config.phase
+	}
+	fmt.Fprintf(w, "%s%s\n", title, clearLine)
+	progress := min(elapsed.Seconds()/config.Duration.Seconds(), 1)
+	bar := int(progress * 30)
+	fmt.Fprintf(w, "[%s%s] %3.0f%%  %s / %s%s\n\n", strings.Repeat("#", bar), strings.Repeat(".", 30-bar),
+		progress*100, elapsed.Truncate(time.Second), config.Duration, clearLine)
+
+	target := float64(config.Rate)
+	if f := rampRate(config); f != nil {
+		target = f(elapsed)
+	} else if stats.adaptive != nil {
+		target = stats.adaptive.current()
+	}
+	sent := atomic.LoadInt64(&stats.Sent)
+	succeeded := atomic.LoadInt64(&stats.Succeeded)
+	fmt.Fprintf(w, "Rate:         %.2f messages/second (target %.2f, average %.2f)%s\n",
+		rate, target, float64(sent)/elapsed.Seconds(), clearLine)
+	fmt.Fprintf(w, "Messages:     %d sent, %d succeeded, %d rate limited, %d failed%s\n",
+		sent, succeeded, atomic.LoadInt64(&stats.RateLimited), atomic.LoadInt64(&stats.Failed), clearLine)
+	fmt.Fprintf(w, "Success rate: %.2f%%%s\n", percentage(succeeded, sent), clearLine)
+
+	live := stats.live
+	live.mu.Lock()
+	latency := "no responses"
+	if live.latency.count > 0 {
+		latency = live.latency.summary()
+	}
+	live.latency = latencyHistogram{}
+	live.mu.Unlock()
+	fmt.Fprintf(w, "Latency:      %s (last %s)%s\n\n", latency, tuiInterval, clearLine)
+
+	fmt.Fprintf(w, "%-6s %-13s %10s %10s %10s %10s%s\n", "Conn", "State", "Sent", "Succeeded", "Limited", "Failed", clearLine)
+	for i, c := range live.conns {
+		if i == tuiMaxConnections {
+			fmt.Fprintf(w, "... and %d more%s\n", len(live.conns)-i, clearLine)
+			break
+		}
+		fmt.Fprintf(w, "#%-5d %-13s %10d %10d %10d %10d%s\n", i, c.state.Load(),
+			atomic.LoadInt64(&c.sent), atomic.LoadInt64(&c.succeeded),
+			atomic.LoadInt64(&c.rateLimited), atomic.LoadInt64(&c.failed), clearLine)
+	}
+
+	fmt.Fprintf(w, "\nRecent log:%s\n", clearLine)
+	lines := logs.last(5)
+	for i := 0; i < 5; i++ {
+		line := ""
+		if i < len(lines) {
+			line = "  " + lines[i]
+		}
+		fmt.Fprintf(w, "%s%s\n", line, clearLine)
+	}
+	fmt.Fprint(w, "\x1b[J") // clear the rest of the screen
+}
@@ -0,0 +1,364 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// Ways of sending as a simulated user, selected with -user-via.
+const (
+	UserViaPayload = "payload" // a user field in the message (TCP, UDP and gRPC)
+	UserViaHeader  = "header"  // a request header (HTTP and gRPC unary)
+	UserViaPort    = "port"    // a source port per user (TCP and UDP)
+)
+
+// Distributions of messages over the simulated users.
+const (
+	UserUniform = "uniform"
+	UserZipf    = "zipf" // a few users send most messages
+)
+
+// UserConfig simulates distinct users, so servers with keyed rate limiters
+// see the key spread of real traffic rather than one client.
+type UserConfig struct {
+	Count        int // 0 for no simulated users
+	Distribution string
+	ZipfS        float64 // Zipf exponent, above 1; larger is more skewed
+	Via          string  // default header over HTTP, payload otherwise
+	Header       string
+}
+
+// validUsers checks the simulated user settings for protocol and fills in
+// the default -user-via.
+func validUsers(config *UserConfig, protocol, grpcMode string) error {
+	if config.Count == 0 {
+		return nil
+	}
+	if config.Count < 0 {
+		return fmt.Errorf("-users must not be negative")
+	}
+	switch config.Distribution {
+	case UserUniform:
+	case UserZipf:
+		if config.ZipfS <= 1 {
+			return fmt.Errorf("-user-zipf-s must be above 1")
+		}
+	default:
+		return fmt.Errorf("invalid -user-distribution %q", config.Distribution)
+	}
+	return validUserVia(config, protocol, grpcMode)
+}
+
+// validUserVia checks -user-via for protocol and fills in its default.
+func validUserVia(config *UserConfig, protocol, grpcMode string) error {
+	if config.Via == "" {
+		config.Via = UserViaPayload
+		if protocol == "http" {
+			config.Via = UserViaHeader
+		}
+	}
+	var ok bool
+	switch config.Via {
+	case UserViaPayload:
+		ok = protocol != "http"
+	case UserViaHeader:
+		ok = protocol == "http" || protocol == "grpc" && grpcMode == GRPCUnary
+	case UserViaPort:
+		ok = protocol == "tcp" || protocol == "udp"
+	default:
+		return fmt.Errorf("invalid -user-via %q", config.Via)
+	}
+	if !ok && protocol == "grpc" {
+		return fmt.Errorf("-user-via %s is not supported with gRPC %s calls", config.Via, grpcMode)
+	}
+	if !ok {
+		return fmt.Errorf("-user-via %s is not supported over %s", config.Via, protocol)
+	}
+	if config.Via == UserViaHeader && config.Header == "" {
+		return fmt.Errorf("-user-header must not be empty")
+	}
+	return nil
+}
+
+// describeUsers describes the simulated users for the run banner.
+func describeUsers(config UserConfig) string {
+	desc := fmt.Sprintf("%d, %s", config.Count, config.Distribution)
+	if config.Distribution == UserZipf {
+		desc += fmt.Sprintf(" (s=%g)", config.ZipfS)
+	}
+	switch config.Via {
+	case UserViaHeader:
+		desc += ", in header " + config.Header
+	case UserViaPort:
+		desc += ", one source port each"
+	default:
+		desc += ", in the payload"
+	}
+	return desc
+}
+
+// userPicker picks the user of each message, or with -replay the next
+// request of the sender's part of the log. Each sender has its own, as
+// random sources are not safe for concurrent use. A nil *userPicker picks
+// user 0 and is used without simulated users.
+type userPicker struct {
+	rng   *rand.Rand
+	zipf  *rand.Zipf
+	count int
+
+	replay []replayEntry
+	keys   []string
+	entry  *replayEntry // last replayed
+}
+
+func newUserPicker(config *Config, sender, senders int) *userPicker {
+	if l := config.replay; l != nil {
+		return &userPicker{replay: l.part(sender, senders), keys: l.keys}
+	}
+	if config.Users.Count == 0 {
+		return nil
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(sender)))
+	p := &userPicker{rng: rng, count: config.Users.Count}
+	if config.Users.Distribution == UserZipf {
+		p.zipf = rand.NewZipf(rng, config.Users.ZipfS, 1, uint64(config.Users.Count-1))
+	}
+	return p
+}
+
+// next returns the user of the next message, in [0, count).
+func (p *userPicker) next() int {
+	switch {
+	case p == nil:
+		return 0
+	case p.keys != nil:
+		// The pacer follows the same part of the log, so it stops before
+		// the requests run out.
+		p.entry = &p.replay[0]
+		p.replay = p.replay[1:]
+		return p.entry.user
+	case p.zipf != nil:
+		return int(p.zipf.Uint64())
+	default:
+		return p.rng.Intn(p.count)
+	}
+}
+
+// key returns how user is named on the wire: its key in the replayed log,
+// or as given by userKey.
+func (p *userPicker) key(config UserConfig, user int) string {
+	if p != nil && p.keys != nil {
+		return p.keys[user]
+	}
+	return userKey(config, user)
+}
+
+// replayed returns the request last returned by next with -replay, or nil.
+func (p *userPicker) replayed() *replayEntry {
+	if p == nil {
+		return nil
+	}
+	return p.entry
+}
+
+// userKey returns how user is named on the wire: an address in 10.0.0.0/8
+// in a header naming the client address, such as X-Forwarded-For, and
+// "user-N" otherwise.
+func userKey(config UserConfig, user int) string {
+	if config.Via == UserViaHeader {
+		switch http.CanonicalHeaderKey(config.Header) {
+		case "X-Forwarded-For", "X-Real-Ip", "True-Client-Ip", "Cf-Connecting-Ip":
+			n := user + 1
+			return net.IPv4(10, byte(n>>16), byte(n>>8), byte(n)).String()
+		}
+	}
+	return "user-" + strconv.Itoa(user+1)
+}
+
+// userMessage returns in dst message with the user field for key at
+// offset, keeping the message size if it has room for the field.
+func userMessage(dst, message []byte, offset int, key string) []byte {
+	dst = append(dst[:0], message[:offset]...)
+	dst = wire.AppendUser(dst, key)
+	if len(dst) < len(message) {
+		dst = append(dst, message[len(dst):]...)
+	}
+	return dst
+}
+
+// userCounts counts messages and rate limit responses per simulated user.
+type userCounts struct {
+	sent    []int64
+	limited []int64
+}
+
+func newUserCounts(count int) *userCounts {
+	if count == 0 {
+		return nil
+	}
+	return &userCounts{sent: make([]int64, count), limited: make([]int64, count)}
+}
+
+// send records a message sent as user.
+func (u *userCounts) send(user int) {
+	if u != nil {
+		atomic.AddInt64(&u.sent[user], 1)
+	}
+}
+
+// limit records a rate limit response to a message sent as user.
+func (u *userCounts) limit(user int) {
+	if u != nil && user >= 0 && user < len(u.limited) {
+		atomic.AddInt64(&u.limited[user], 1)
+	}
+}
+
+// userData is the per-user counts of an agent's run.
+type userData struct {
+	Sent    []int64 `json:"sent"`
+	Limited []int64 `json:"limited"`
+}
+
+// export returns the counts for sending to the coordinator, or nil without
+// simulated users.
+func (u *userCounts) export() *userData {
+	if u == nil {
+		return nil
+	}
+	d := &userData{Sent: make([]int64, len(u.sent)), Limited: make([]int64, len(u.limited))}
+	for i := range u.sent {
+		d.Sent[i] = atomic.LoadInt64(&u.sent[i])
+		d.Limited[i] = atomic.LoadInt64(&u.limited[i])
+	}
+	return d
+}
+
+// mergeData adds the counts of d, exported by export, to u. Users beyond
+// those of u are ignored.
+func (u *userCounts) mergeData(d *userData) {
+	if u == nil {
+		return
+	}
+	for i := 0; i < len(u.sent) && i < len(d.Sent) && i < len(d.Limited); i++ {
+		atomic.AddInt64(&u.sent[i], d.Sent[i])
+		atomic.AddInt64(&u.limited[i], d.Limited[i])
+	}
+}
+
+// UserSummary is how the messages of a run spread over its simulated
+// users.
+type UserSummary struct {
+	Simulated    int     `json:"simulated"`
+	Active       int     `json:"active"`        // sent at least one message
+	Limited      int     `json:"limited"`       // rate limited at least once
+	TopShare     float64 `json:"top_share"`     // % of messages sent by the busiest user
+	TopLimited   float64 `json:"top_limited"`   // % of the busiest user's messages rate limited
+	OtherLimited float64 `json:"other_limited"` // % of the other users' messages rate limited
+}
+
+// userSummary summarizes u, or returns nil without simulated users.
+func userSummary(u *userCounts) *UserSummary {
+	if u == nil {
+		return nil
+	}
+	s := &UserSummary{Simulated: len(u.sent)}
+	var total, limited, topSent, topLimited int64
+	for i := range u.sent {
+		sent, lim := atomic.LoadInt64(&u.sent[i]), atomic.LoadInt64(&u.limited[i])
+		if sent > 0 {
+			s.Active++
+		}
+		if lim > 0 {
+			s.Limited++
+		}
+		if sent > topSent {
+			topSent, topLimited = sent, lim
+		}
+		total += sent
+		limited += lim
+	}
+	s.TopShare = percentage(topSent, total)
+	s.TopLimited = percentage(topLimited, topSent)
+	s.OtherLimited = percentage(limited-topLimited, total-topSent)
+	return s
+}
+
+// describeUserSummary formats s for the stats output.
+func describeUserSummary(s *UserSummary) string {
+	return fmt.Sprintf("%d of %d active, %d rate limited; busiest user sent %.2f%% of messages, %.2f%% rate limited (others %.2f%%)",
+		s.Active, s.Simulated, s.Limited, s.TopShare, s.TopLimited, s.OtherLimited)
+}
+
+// userConns holds one connection per simulated user with -user-via port,
+// so each user sends from its own source port. A user's connection is
+// opened on its first message and used by one sender at a time.
+type userConns struct {
+	mu    sync.Mutex
+	slots map[int]*userConn
+}
+
+type userConn struct {
+	mu   sync.Mutex
+	conn net.Conn // nil until opened, or after an error
+}
+
+// lock returns the locked slot of user.
+func (p *userConns) lock(user int) *userConn {
+	p.mu.Lock()
+	if p.slots == nil {
+		p.slots = make(map[int]*userConn)
+	}
+	slot, ok := p.slots[user]
+	if !ok {
+		slot = &userConn{}
+		p.slots[user] = slot
+	}
+	p.mu.Unlock()
+	slot.mu.Lock()
+	return slot
+}
+
+// close closes every user's connection.
+func (p *userConns) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, slot := range p.slots {
+		slot.mu.Lock()
+		if slot.conn != nil {
+			slot.conn.Close()
+			slot.conn = nil
+		}
+		slot.mu.Unlock()
+	}
+}
+
+// userOf returns the user that key names, or -1.
+func userOf(config *Config, key []byte) int {
+	if l := config.replay; l != nil {
+		if user, ok := l.users[string(key)]; ok {
+			return user
+		}
+		return -1
+	}
+	return userIndex(key)
+}
+
+// userIndex returns the user that userKey names key, or -1 for keys other
+// than "user-N".
+func userIndex(key []byte) int {
+	digits, ok := bytes.CutPrefix(key, []byte("user-"))
+	n, err := strconv.Atoi(string(digits))
+	if !ok || err != nil {
+		return -1
+	}
+	return n - 1
+}
@@ -0,0 +1,50 @@
+// Package filewatch calls a function whenever a file changes or the
+// process receives SIGHUP, for configuration that is reloaded in place.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch calls reload whenever file changes, checked by its size and
+// modification time every interval, and whenever the process receives
+// SIGHUP, until ctx is done. An interval of zero or less reacts to SIGHUP
+// only.
+func Watch(ctx context.Context, file string, interval time.Duration, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	last, _ := os.Stat(file)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last, _ = os.Stat(file)
+		case <-tick:
+			info, err := os.Stat(file)
+			if err != nil {
+				// Perhaps being replaced; check again at the next tick.
+				continue
+			}
+			if last != nil && info.Size() == last.Size() && info.ModTime().Equal(last.ModTime()) {
+				continue
+			}
+			last = info
+		}
+
+		reload()
+	}
+}
@@ -0,0 +1,116 @@
+// Package leakcheck finds goroutines that tests leave running:
+//
+//	func TestClose(t *testing.T) {
+//		defer leakcheck.Check(t)()
+//		...
+//	}
+//
+// Goroutines are told apart by ID rather than counted, so a leaked
+// goroutine is found even if an unrelated one exits meanwhile.
+package leakcheck
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ignored are functions at the top of the stack of goroutines that the
+// testing package and os/signal keep running on their own.
+var ignored = []string{
+	"testing.(*T).Run",
+	"testing.(*T).Parallel",
+	"os/signal.signal_recv",
+}
+
+// goroutine is one goroutine of a stack dump.
+type goroutine struct {
+	id    uint64
+	top   string // function at the top of the stack
+	stack string
+}
+
+// Check records the running goroutines and returns a function that fails
+// t if goroutines started since are still running. Goroutines may take a
+// moment to exit after a Close, so they are given a second.
+func Check(t testing.TB) func() {
+	before := make(map[uint64]bool)
+	for _, g := range goroutines() {
+		before[g.id] = true
+	}
+
+	return func() {
+		t.Helper()
+		var leaked []goroutine
+		for i := 0; i < 100; i++ {
+			leaked = leaked[:0]
+			for _, g := range goroutines() {
+				if !before[g.id] && !isIgnored(g) {
+					leaked = append(leaked, g)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var b strings.Builder
+		for _, g := range leaked {
+			b.WriteString("\n\n" + g.stack)
+		}
+		t.Errorf("%d goroutines leaked:%s", len(leaked), b.String())
+	}
+}
+
+// goroutines returns the running goroutines.
+func goroutines() []goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var gs []goroutine
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		if g, ok := parse(string(block)); ok {
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
+// parse parses the stack of one goroutine, which starts with a line such
+// as "goroutine 7 [chan receive]:" followed by the function on top.
+func parse(stack string) (goroutine, bool) {
+	header, rest, _ := strings.Cut(stack, "\n")
+	fields := strings.Fields(header)
+	if len(fields) < 2 || fields[0] != "goroutine" {
+		return goroutine{}, false
+	}
+	id, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return goroutine{}, false
+	}
+	top, _, _ := strings.Cut(rest, "\n")
+	if i := strings.LastIndexByte(top, '('); i > 0 {
+		top = top[:i]
+	}
+	return goroutine{id: id, top: top, stack: stack}, true
+}
+
+func isIgnored(g goroutine) bool {
+	for _, f := range ignored {
+		if g.top == f {
+			return true
+		}
+	}
+	return false
+}
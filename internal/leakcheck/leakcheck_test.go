@@ -0,0 +1,70 @@
+package leakcheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recorder is a testing.TB that records failures instead of failing.
+type recorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+func TestCheckFindsLeak(t *testing.T) {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	defer close(done)
+
+	check := Check(r)
+	go leak(done)
+	check()
+
+	if !strings.Contains(r.failure, "1 goroutines leaked") || !strings.Contains(r.failure, "leakcheck.leak") {
+		t.Errorf("failure = %q, want the stack of the leaked goroutine", r.failure)
+	}
+}
+
+func TestCheckLeakHiddenByExit(t *testing.T) {
+	r := &recorder{TB: t}
+	exiting := make(chan struct{})
+	go func() { <-exiting }()
+	done := make(chan struct{})
+	defer close(done)
+
+	// The count of goroutines is the same before and after, but a
+	// different goroutine is running.
+	check := Check(r)
+	close(exiting)
+	go leak(done)
+	check()
+
+	if r.failure == "" {
+		t.Error("leak not found when another goroutine exited")
+	}
+}
+
+func TestCheckWaitsForExit(t *testing.T) {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+
+	check := Check(r)
+	go leak(done)
+	close(done)
+	check()
+
+	if r.failure != "" {
+		t.Errorf("failure = %q for a goroutine that exited", r.failure)
+	}
+}
+
+func leak(done chan struct{}) {
+	<-done
+}
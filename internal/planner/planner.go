@@ -0,0 +1,545 @@
+// Package planner recommends limiter parameters from a traffic trace. It
+// backs both the planner binary and rrl plan.
+package planner
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+)
+
+// planner recommends rate limiter parameters for a backend. It replays an
+// arrival trace through a token bucket with a bounded FIFO wait queue in
+// front of a backend whose capacity may vary over time, for every
+// combination of candidate rate, burst and queue depth, and reports the
+// settings that reject the least traffic while keeping the backend within
+// its latency target.
+
+type Config struct {
+	TraceFile      string
+	CapacityFile   string
+	Capacity       float64
+	Rates          string
+	Bursts         string
+	Queues         string
+	MaxWait        time.Duration
+	MaxBackendWait time.Duration
+	Top            int
+
+	// Synthetic trace, used when no trace file is given
+	Duration   time.Duration
+	BaseRate   float64
+	SpikeRate  float64
+	SpikeEvery time.Duration
+	SpikeLen   time.Duration
+	Seed       int64
+}
+
+// Arrival is one request in the trace.
+type Arrival struct {
+	At   float64 // seconds from the start of the trace
+	Cost float64
+}
+
+// CapacityPoint sets the backend capacity from At onwards.
+type CapacityPoint struct {
+	At  float64 // seconds from the start of the trace
+	RPS float64
+}
+
+// Params is one candidate limiter configuration.
+type Params struct {
+	Rate  float64 // tokens per second
+	Burst float64
+	Queue int
+}
+
+// Result is the simulated outcome of one Params.
+type Result struct {
+	Params
+	Sent          int
+	Rejected      int
+	WaitP50       time.Duration
+	WaitP99       time.Duration
+	BackendP99    time.Duration
+	BackendMax    time.Duration
+	MeetsBackend  bool
+	RejectPercent float64
+}
+
+// Main runs the parameter planner with the given command-line arguments.
+func Main(args []string) {
+	config := parseFlags(args)
+
+	arrivals, err := loadArrivals(config)
+	if err != nil {
+		log.Fatalf("Failed to load trace: %v", err)
+	}
+	if len(arrivals) == 0 {
+		log.Fatalf("Trace is empty")
+	}
+
+	capacity := []CapacityPoint{{At: 0, RPS: config.Capacity}}
+	if config.CapacityFile != "" {
+		capacity, err = loadCapacity(config.CapacityFile)
+		if err != nil {
+			log.Fatalf("Failed to load capacity curve: %v", err)
+		}
+	}
+
+	span := arrivals[len(arrivals)-1].At - arrivals[0].At
+	meanCapacity := averageCapacity(capacity, span)
+	fmt.Printf("Trace: %d requests over %.1fs (mean %.1f req/s, peak 1s window %d req)\n",
+		len(arrivals), span, float64(len(arrivals))/math.Max(span, 1), peakWindow(arrivals, 1))
+	fmt.Printf("Backend capacity: mean %.1f req/s, minimum %.1f req/s\n", meanCapacity, minCapacity(capacity))
+	fmt.Printf("Targets: limiter wait <= %s, backend queueing p99 <= %s\n\n", config.MaxWait, config.MaxBackendWait)
+
+	rates, err := parseRates(config.Rates, meanCapacity)
+	if err != nil {
+		log.Fatalf("Invalid -rates: %v", err)
+	}
+	bursts, err := parseFloats(config.Bursts)
+	if err != nil {
+		log.Fatalf("Invalid -bursts: %v", err)
+	}
+	queues, err := parseInts(config.Queues)
+	if err != nil {
+		log.Fatalf("Invalid -queues: %v", err)
+	}
+
+	var results []Result
+	for _, rate := range rates {
+		for _, burst := range bursts {
+			for _, queue := range queues {
+				p := Params{Rate: rate, Burst: burst, Queue: queue}
+				results = append(results, simulate(arrivals, capacity, p, config))
+			}
+		}
+	}
+
+	rank(results)
+	printResults(results, config.Top)
+}
+
+func parseFlags(args []string) *Config {
+	config := &Config{}
+
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	fs.StringVar(&config.TraceFile, "trace", "", "Arrival trace CSV: offset_seconds[,cost] per line (synthetic if empty)")
+	fs.StringVar(&config.CapacityFile, "capacity-curve", "", "Backend capacity CSV: offset_seconds,requests_per_second per line")
+	fs.Float64Var(&config.Capacity, "capacity", 100, "Constant backend capacity in requests per second (without -capacity-curve)")
+	fs.StringVar(&config.Rates, "rates", "50%,60%,70%,80%,90%,100%,110%", "Candidate rates in req/s, or percentages of mean capacity")
+	fs.StringVar(&config.Bursts, "bursts", "1,5,10,20,50,100", "Candidate burst sizes")
+	fs.StringVar(&config.Queues, "queues", "0,10,50,100,500", "Candidate wait queue depths")
+	fs.DurationVar(&config.MaxWait, "max-wait", time.Second, "Longest a request may wait in the limiter queue before it is rejected")
+	fs.DurationVar(&config.MaxBackendWait, "max-backend-wait", 100*time.Millisecond, "Target p99 queueing delay at the backend")
+	fs.IntVar(&config.Top, "top", 10, "Number of candidates to print")
+
+	fs.DurationVar(&config.Duration, "duration", 5*time.Minute, "Synthetic trace length")
+	fs.Float64Var(&config.BaseRate, "base-rate", 60, "Synthetic baseline arrival rate (req/s)")
+	fs.Float64Var(&config.SpikeRate, "spike-rate", 400, "Synthetic arrival rate during spikes (req/s)")
+	fs.DurationVar(&config.SpikeEvery, "spike-every", time.Minute, "Interval between synthetic spikes")
+	fs.DurationVar(&config.SpikeLen, "spike-len", 5*time.Second, "Length of each synthetic spike")
+	fs.Int64Var(&config.Seed, "seed", 1, "Random seed for the synthetic trace")
+	cli.Parse(fs, args)
+
+	if config.Capacity <= 0 && config.CapacityFile == "" {
+		log.Fatalf("Invalid capacity: %g", config.Capacity)
+	}
+
+	return config
+}
+
+// simulate replays arrivals through a limiter with parameters p and a
+// backend with the given capacity curve.
+//
+// The limiter is a token bucket with a FIFO wait queue, like
+// Middleware.QueueHandler: a request takes tokens if available, otherwise
+// it reserves future tokens and waits, unless the queue is full or the
+// wait would exceed MaxWait, in which case it is rejected. Admitted
+// requests are served by the backend in order at its current capacity.
+func simulate(arrivals []Arrival, capacity []CapacityPoint, p Params, config *Config) Result {
+	result := Result{Params: p, Sent: len(arrivals)}
+
+	var (
+		tokens      = p.Burst
+		last        = arrivals[0].At
+		grants      []float64 // grant times of queued requests, ascending
+		waits       []float64
+		backendFree float64
+		backendWait []float64
+		maxWait     = config.MaxWait.Seconds()
+	)
+
+	for _, a := range arrivals {
+		// Refill; a negative balance represents tokens reserved by waiters
+		tokens = math.Min(p.Burst, tokens+(a.At-last)*p.Rate)
+		last = a.At
+
+		// Waiters whose grant time has passed have left the queue
+		for len(grants) > 0 && grants[0] <= a.At {
+			grants = grants[1:]
+		}
+
+		cost := a.Cost
+		var grant float64
+		if len(grants) == 0 && tokens >= cost {
+			tokens -= cost
+			grant = a.At
+		} else {
+			wait := (cost - tokens) / p.Rate
+			if len(grants) >= p.Queue || wait > maxWait {
+				result.Rejected++
+				continue
+			}
+			tokens -= cost
+			grant = a.At + wait
+			grants = append(grants, grant)
+		}
+		waits = append(waits, grant-a.At)
+
+		// Backend: single FIFO server running at the capacity in effect
+		start := math.Max(grant, backendFree)
+		backendFree = start + cost/capacityAt(capacity, start)
+		backendWait = append(backendWait, start-grant)
+	}
+
+	sort.Float64s(waits)
+	sort.Float64s(backendWait)
+	result.WaitP50 = seconds(percentile(waits, 0.50))
+	result.WaitP99 = seconds(percentile(waits, 0.99))
+	result.BackendP99 = seconds(percentile(backendWait, 0.99))
+	if len(backendWait) > 0 {
+		result.BackendMax = seconds(backendWait[len(backendWait)-1])
+	}
+	result.MeetsBackend = result.BackendP99 <= config.MaxBackendWait
+	result.RejectPercent = float64(result.Rejected) / float64(result.Sent) * 100
+	return result
+}
+
+// rank orders results by suitability: candidates meeting the backend target
+// first, then by fewest rejections, then by lower limiter wait, then by the
+// smaller (more conservative) configuration.
+func rank(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.MeetsBackend != b.MeetsBackend {
+			return a.MeetsBackend
+		}
+		if !a.MeetsBackend && a.BackendP99 != b.BackendP99 {
+			return a.BackendP99 < b.BackendP99
+		}
+		if a.Rejected != b.Rejected {
+			return a.Rejected < b.Rejected
+		}
+		if a.WaitP99 != b.WaitP99 {
+			return a.WaitP99 < b.WaitP99
+		}
+		if a.Rate != b.Rate {
+			return a.Rate < b.Rate
+		}
+		if a.Burst != b.Burst {
+			return a.Burst < b.Burst
+		}
+		return a.Queue < b.Queue
+	})
+}
+
+func printResults(results []Result, top int) {
+	if top > len(results) {
+		top = len(results)
+	}
+
+	fmt.Printf("%-10s %-8s %-7s %-9s %-10s %-10s %-12s %-12s\n",
+		"Rate", "Burst", "Queue", "Reject%", "Wait p50", "Wait p99", "Backend p99", "Backend max")
+	fmt.Println(strings.Repeat("-", 84))
+	for _, r := range results[:top] {
+		mark := ""
+		if !r.MeetsBackend {
+			mark = "  (backend target missed)"
+		}
+		fmt.Printf("%-10.1f %-8.0f %-7d %-9.2f %-10s %-10s %-12s %-12s%s\n",
+			r.Rate, r.Burst, r.Queue, r.RejectPercent,
+			r.WaitP50.Round(time.Millisecond), r.WaitP99.Round(time.Millisecond),
+			r.BackendP99.Round(time.Millisecond), r.BackendMax.Round(time.Millisecond), mark)
+	}
+
+	best := results[0]
+	fmt.Println()
+	if !best.MeetsBackend {
+		fmt.Println("No candidate meets the backend latency target; widen -rates downwards or add capacity.")
+		return
+	}
+	fmt.Printf("Recommended: rate %.1f/s, burst %.0f, queue depth %d\n", best.Rate, best.Burst, best.Queue)
+	fmt.Printf("  Expect %.2f%% rejected (%d of %d), limiter wait p99 %s, backend queueing p99 %s\n",
+		best.RejectPercent, best.Rejected, best.Sent,
+		best.WaitP99.Round(time.Millisecond), best.BackendP99.Round(time.Millisecond))
+}
+
+// loadArrivals reads the trace file, or generates a synthetic trace.
+func loadArrivals(config *Config) ([]Arrival, error) {
+	if config.TraceFile == "" {
+		return syntheticTrace(config), nil
+	}
+
+	file, err := os.Open(config.TraceFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows, err := readCSV(file)
+	if err != nil {
+		return nil, err
+	}
+
+	arrivals := make([]Arrival, 0, len(rows))
+	for i, row := range rows {
+		at, err := strconv.ParseFloat(row[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid offset %q", i+1, row[0])
+		}
+		cost := 1.0
+		if len(row) > 1 && row[1] != "" {
+			if cost, err = strconv.ParseFloat(row[1], 64); err != nil || cost <= 0 {
+				return nil, fmt.Errorf("line %d: invalid cost %q", i+1, row[1])
+			}
+		}
+		arrivals = append(arrivals, Arrival{At: at, Cost: cost})
+	}
+
+	sort.SliceStable(arrivals, func(i, j int) bool { return arrivals[i].At < arrivals[j].At })
+	return arrivals, nil
+}
+
+// loadCapacity reads a piecewise-constant capacity curve.
+func loadCapacity(path string) ([]CapacityPoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows, err := readCSV(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []CapacityPoint
+	for i, row := range rows {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d: expected offset_seconds,requests_per_second", i+1)
+		}
+		at, err1 := strconv.ParseFloat(row[0], 64)
+		rps, err2 := strconv.ParseFloat(row[1], 64)
+		if err1 != nil || err2 != nil || rps <= 0 {
+			return nil, fmt.Errorf("line %d: invalid capacity point", i+1)
+		}
+		points = append(points, CapacityPoint{At: at, RPS: rps})
+	}
+	if len(points) == 0 {
+		return nil, errors.New("capacity curve is empty")
+	}
+
+	sort.SliceStable(points, func(i, j int) bool { return points[i].At < points[j].At })
+	return points, nil
+}
+
+// readCSV reads comma separated rows, skipping blank lines, comments and a
+// non-numeric header row.
+func readCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var rows [][]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+		if len(rows) == 0 {
+			if _, err := strconv.ParseFloat(row[0], 64); err != nil {
+				continue // header
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// syntheticTrace generates Poisson arrivals at BaseRate with periodic
+// spikes at SpikeRate.
+func syntheticTrace(config *Config) []Arrival {
+	rng := rand.New(rand.NewSource(config.Seed))
+	end := config.Duration.Seconds()
+	every := config.SpikeEvery.Seconds()
+	length := config.SpikeLen.Seconds()
+
+	var arrivals []Arrival
+	for t := 0.0; t < end; {
+		rate := config.BaseRate
+		if every > 0 && math.Mod(t, every) >= every-length {
+			rate = config.SpikeRate
+		}
+		if rate <= 0 {
+			t += 0.01
+			continue
+		}
+		t += rng.ExpFloat64() / rate
+		arrivals = append(arrivals, Arrival{At: t, Cost: 1})
+	}
+	return arrivals
+}
+
+// capacityAt returns the backend capacity in effect at t.
+func capacityAt(points []CapacityPoint, t float64) float64 {
+	i := sort.Search(len(points), func(i int) bool { return points[i].At > t })
+	if i == 0 {
+		return points[0].RPS
+	}
+	return points[i-1].RPS
+}
+
+// averageCapacity returns the time-weighted mean capacity over [0, span].
+func averageCapacity(points []CapacityPoint, span float64) float64 {
+	if len(points) == 1 || span <= 0 {
+		return points[0].RPS
+	}
+
+	total := 0.0
+	for i, p := range points {
+		start := math.Max(p.At, 0)
+		end := span
+		if i+1 < len(points) {
+			end = math.Min(points[i+1].At, span)
+		}
+		if i == 0 {
+			start = 0
+		}
+		if end > start {
+			total += p.RPS * (end - start)
+		}
+	}
+	return total / span
+}
+
+func minCapacity(points []CapacityPoint) float64 {
+	m := points[0].RPS
+	for _, p := range points[1:] {
+		m = math.Min(m, p.RPS)
+	}
+	return m
+}
+
+// peakWindow returns the largest number of arrivals within any window of
+// the given length in seconds.
+func peakWindow(arrivals []Arrival, window float64) int {
+	peak, j := 0, 0
+	for i := range arrivals {
+		for arrivals[i].At-arrivals[j].At >= window {
+			j++
+		}
+		if n := i - j + 1; n > peak {
+			peak = n
+		}
+	}
+	return peak
+}
+
+// parseRates parses rates given as req/s or as percentages of capacity.
+func parseRates(s string, capacity float64) ([]float64, error) {
+	var rates []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		scale := 1.0
+		if strings.HasSuffix(field, "%") {
+			field = strings.TrimSuffix(field, "%")
+			scale = capacity / 100
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid rate %q", field)
+		}
+		rates = append(rates, v*scale)
+	}
+	if len(rates) == 0 {
+		return nil, errors.New("no rates given")
+	}
+	return rates, nil
+}
+
+func parseFloats(s string) ([]float64, error) {
+	var values []float64
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid value %q", field)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no values given")
+	}
+	return values, nil
+}
+
+func parseInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		v, err := strconv.Atoi(field)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid value %q", field)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no values given")
+	}
+	return values, nil
+}
+
+// percentile returns the q-th quantile of sorted values.
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
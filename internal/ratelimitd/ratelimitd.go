@@ -0,0 +1,291 @@
+// Package ratelimitd is the sidecar rate limit daemon. It backs both the
+// ratelimitd binary and rrl daemon.
+package ratelimitd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/admin"
+	"github.com/rRateLimit/client/ratelimit/coordinator"
+	"github.com/rRateLimit/client/ratelimit/health"
+	"github.com/rRateLimit/client/ratelimit/sidecar"
+)
+
+type Config struct {
+	Network     string
+	Address     string
+	Rate        int
+	Period      time.Duration
+	Burst       int
+	MaxIdleTime time.Duration
+	StateFile   string
+	HTTPAddr    string
+	AdminAddr   string
+	PolicyFile  string
+}
+
+// Main runs the rate limit daemon with the given command-line arguments.
+func Main(args []string) {
+	config := parseFlags(args)
+
+	fmt.Printf("Starting ratelimitd\n")
+	fmt.Printf("Listen: %s %s\n", config.Network, config.Address)
+	fmt.Printf("Limit: %d per %s (burst %d)\n\n", config.Rate, config.Period, config.Burst)
+
+	policies, err := coordinator.NewStore(coordinator.Policy{
+		Rate:   config.Rate,
+		Period: coordinator.Duration(config.Period),
+		Burst:  config.Burst,
+	}, "flags")
+	if err != nil {
+		log.Fatalf("Invalid policy: %v", err)
+	}
+
+	server := sidecar.NewServer(sidecar.ServerConfig{
+		LimiterFactory: policies.LimiterFactory(newLimiter),
+		RetryInterval:  retryInterval(policies.Current().Policy),
+		MaxIdleTime:    config.MaxIdleTime,
+	})
+
+	// Every applied or rolled back policy, and every canary change, takes
+	// effect immediately; the history is persisted so it survives restarts
+	policies.OnChange(func(v coordinator.Version) {
+		log.Printf("Applying policy version %d by %s: %d per %s (burst %d)",
+			v.Number, v.Author, v.Policy.Rate, time.Duration(v.Policy.Period), v.Policy.Burst)
+		if c, ok := policies.CurrentCanary(); ok {
+			log.Printf("Canary by %s on %d%% of keys: %d per %s (burst %d)",
+				c.Author, c.Percent, c.Policy.Rate, time.Duration(c.Policy.Period), c.Policy.Burst)
+		}
+		server.Reconfigure(policies.LimiterFactory(newLimiter), retryInterval(v.Policy))
+		if config.PolicyFile != "" {
+			if err := savePolicies(policies, config.PolicyFile); err != nil {
+				log.Printf("Failed to save policy history: %v", err)
+			}
+		}
+	})
+	if config.PolicyFile != "" {
+		if err := loadPolicies(policies, config.PolicyFile); err != nil {
+			log.Fatalf("Failed to load policy history: %v", err)
+		}
+	}
+
+	// stateLoaded feeds the readiness report with the state restore outcome
+	var stateLoaded health.Flag
+	if config.StateFile != "" {
+		err := loadState(server, config.StateFile)
+		if err != nil {
+			log.Printf("Failed to load state: %v", err)
+		}
+		stateLoaded.Set(err)
+	} else {
+		stateLoaded.Set(nil)
+	}
+
+	if config.Network == "unix" {
+		// Remove a stale socket left behind by a previous run
+		os.Remove(config.Address)
+	}
+	listener, err := net.Listen(config.Network, config.Address)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", config.Address, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go server.RunCleanup(ctx, time.Minute)
+
+	if config.HTTPAddr != "" {
+		go serveHealth(config, &stateLoaded)
+	}
+
+	if config.AdminAddr != "" {
+		go serveAdmin(config, policies, server)
+	}
+
+	// Setup signal handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Println("\nShutting down ratelimitd...")
+		cancel()
+		server.Close()
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, sidecar.ErrServerClosed) {
+		log.Fatalf("Serve error: %v", err)
+	}
+
+	if config.StateFile != "" {
+		if err := saveState(server, config.StateFile); err != nil {
+			log.Printf("Failed to save state: %v", err)
+		} else {
+			fmt.Printf("Saved state of %d keys to %s\n", server.Keys(), config.StateFile)
+		}
+	}
+}
+
+func parseFlags(args []string) *Config {
+	config := &Config{}
+
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	fs.StringVar(&config.Network, "network", "unix", "Listen network (unix or tcp)")
+	fs.StringVar(&config.Address, "listen", "/tmp/ratelimitd.sock", "Socket path or host:port to listen on")
+	fs.IntVar(&config.Rate, "rate", 100, "Requests allowed per period for each key")
+	fs.DurationVar(&config.Period, "period", time.Second, "Rate limit period")
+	fs.IntVar(&config.Burst, "burst", 10, "Burst size for each key")
+	fs.DurationVar(&config.MaxIdleTime, "max-idle", 10*time.Minute, "Drop keys idle for longer than this")
+	fs.StringVar(&config.StateFile, "state", "", "File to restore limiter state from and save it to on shutdown")
+	fs.StringVar(&config.HTTPAddr, "http", "", "Address for /healthz and /readyz (disabled if empty)")
+	fs.StringVar(&config.AdminAddr, "admin", "", "Address for the policy admin API (disabled if empty)")
+	fs.StringVar(&config.PolicyFile, "policy-history", "", "File to keep the policy version history in")
+	cli.Parse(fs, args)
+
+	if config.Rate <= 0 {
+		log.Fatalf("Invalid rate: %d", config.Rate)
+	}
+
+	return config
+}
+
+// serveHealth exposes liveness and readiness endpoints. Readiness requires
+// the socket to answer a ping and the saved state to have loaded cleanly.
+func serveHealth(config *Config, stateLoaded *health.Flag) {
+	client := sidecar.NewClient(sidecar.ClientConfig{
+		Network:      config.Network,
+		Address:      config.Address,
+		MaxIdleConns: 1,
+	})
+
+	registry := health.NewRegistry(time.Second)
+	registry.AddReadiness("socket", func(ctx context.Context) error {
+		return client.Ping()
+	})
+	registry.AddReadiness("state", stateLoaded.Check)
+
+	mux := http.NewServeMux()
+	registry.Register(mux)
+
+	fmt.Printf("Health endpoints listening on %s\n", config.HTTPAddr)
+	if err := http.ListenAndServe(config.HTTPAddr, mux); err != nil {
+		log.Printf("Health endpoint error: %v", err)
+	}
+}
+
+// serveAdmin exposes the policy history and rollback API, and a dump of
+// all limiter states at /debug/state. Bearer tokens are
+// read from RATELIMITD_OPERATOR_TOKEN and RATELIMITD_READONLY_TOKEN rather
+// than flags, so they do not show up in process listings.
+func serveAdmin(config *Config, policies *coordinator.Store, server *sidecar.Server) {
+	tokens := make(map[string]admin.Principal)
+	if token := os.Getenv("RATELIMITD_OPERATOR_TOKEN"); token != "" {
+		tokens[token] = admin.Principal{Name: "operator", Role: admin.RoleOperator}
+	}
+	if token := os.Getenv("RATELIMITD_READONLY_TOKEN"); token != "" {
+		tokens[token] = admin.Principal{Name: "read-only", Role: admin.RoleReadOnly}
+	}
+	if len(tokens) == 0 {
+		log.Printf("Admin API disabled: no RATELIMITD_OPERATOR_TOKEN or RATELIMITD_READONLY_TOKEN set")
+		return
+	}
+
+	guard := &admin.Guard{
+		Auth:  admin.NewTokenAuth(tokens),
+		Audit: admin.NewJSONAuditSink(os.Stderr),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/policy", coordinator.Handler(policies, guard))
+	mux.Handle("/policy/", coordinator.Handler(policies, guard))
+	mux.Handle("/debug/state", guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(server.Dump())
+	})))
+
+	fmt.Printf("Admin API listening on %s\n", config.AdminAddr)
+	if err := http.ListenAndServe(config.AdminAddr, mux); err != nil {
+		log.Printf("Admin API error: %v", err)
+	}
+}
+
+// newLimiter creates the token bucket for one key under a policy.
+func newLimiter(p coordinator.Policy) ratelimit.Limiter {
+	return ratelimit.NewTokenBucket(
+		ratelimit.WithRate(p.Rate),
+		ratelimit.WithPeriod(time.Duration(p.Period)),
+		ratelimit.WithBurst(p.Burst),
+	)
+}
+
+// retryInterval is the time a policy takes to earn one unit.
+func retryInterval(p coordinator.Policy) time.Duration {
+	return time.Duration(p.Period) / time.Duration(p.Rate)
+}
+
+// loadPolicies restores the policy history saved by a previous run, if any.
+// The restored live version replaces the policy given by flags.
+func loadPolicies(policies *coordinator.Store, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return policies.Restore(data)
+}
+
+// savePolicies writes the policy history atomically via a temporary file.
+func savePolicies(policies *coordinator.Store, path string) error {
+	data, err := policies.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadState restores limiter state saved by a previous run, if any.
+func loadState(server *sidecar.Server, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return server.Restore(data)
+}
+
+// saveState writes limiter state atomically via a temporary file.
+func saveState(server *sidecar.Server, path string) error {
+	data, err := server.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
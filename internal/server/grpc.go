@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// remoteAddrKey is the context key of a gRPC connection's remote address.
+type remoteAddrKey struct{}
+
+// runGRPCServer serves the gRPC echo service. The standard library speaks
+// HTTP/2 only over TLS, so gRPC is always served with TLS.
+func runGRPCServer(ctx context.Context, config *Config, stats *Stats) {
+	addr := fmt.Sprintf(":%d", config.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveGRPC(w, r, config, stats)
+		}),
+		TLSConfig: config.tls,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, remoteAddrKey{}, c.RemoteAddr())
+		},
+	}
+	if !config.Verbose {
+		server.ErrorLog = log.New(io.Discard, "", 0)
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	fmt.Fprintf(console, "gRPC server listening on %s\n", addr)
+	if err := server.ServeTLS(throttle(listener, config.ConnBandwidth), "", ""); err != http.ErrServerClosed {
+		log.Fatalf("gRPC server failed: %v", err)
+	}
+}
+
+// serveGRPC handles one RPC to the echo service.
+func serveGRPC(w http.ResponseWriter, r *http.Request, config *Config, stats *Stats) {
+	ct := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || ct != wire.GRPCContentType && !strings.HasPrefix(ct, wire.GRPCContentType+"+") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", wire.GRPCContentType)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	ctx := r.Context()
+	if timeout, ok := wire.ParseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+	}
+	addr, _ := r.Context().Value(remoteAddrKey{}).(net.Addr)
+
+	var code int
+	var message string
+	switch r.URL.Path {
+	case wire.GRPCEcho:
+		code, message = echoRPC(ctx, w, r.Body, addr, config, stats, false)
+	case wire.GRPCEchoStream:
+		code, message = echoRPC(ctx, w, r.Body, addr, config, stats, true)
+	default:
+		code, message = wire.GRPCUnimplemented, "unknown method "+r.URL.Path
+	}
+	if code != wire.GRPCOK && code != wire.GRPCResourceExhausted && config.Verbose {
+		log.Printf("gRPC %s from %s: %s: %s", r.URL.Path, addr, wire.GRPCCodeName(code), message)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", message)
+	}
+}
+
+// echoRPC echoes the request messages of a unary or streaming RPC and
+// returns its status. A rejected message ends the RPC with
+// RESOURCE_EXHAUSTED, as a rate limiting interceptor would.
+func echoRPC(ctx context.Context, w http.ResponseWriter, body io.Reader, addr net.Addr, config *Config, stats *Stats, stream bool) (int, string) {
+	if stream {
+		// Send the headers now, so the client can start streaming.
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+	}
+
+	var buf, out []byte
+	for n := 0; stream || n == 0; n++ {
+		payload, b, err := wire.ReadGRPCMessage(body, buf)
+		buf = b
+		switch {
+		case err == io.EOF && (stream || n > 0):
+			return wire.GRPCOK, ""
+		case ctx.Err() == context.DeadlineExceeded || errors.Is(err, os.ErrDeadlineExceeded):
+			return wire.GRPCDeadlineExceeded, "deadline exceeded"
+		case ctx.Err() != nil:
+			return wire.GRPCCanceled, "canceled"
+		case err == io.EOF:
+			atomic.AddInt64(&stats.Errors, 1)
+			return wire.GRPCInvalidArgument, "missing request message"
+		case err != nil:
+			atomic.AddInt64(&stats.Errors, 1)
+			return wire.GRPCInvalidArgument, err.Error()
+		}
+
+		atomic.AddInt64(&stats.Received, 1)
+		if !admit(config, addr, payload, stats) {
+			return wire.GRPCResourceExhausted, "rate limited"
+		}
+		out = wire.AppendGRPCMessage(out[:0], payload)
+		if _, err := w.Write(out); err != nil {
+			atomic.AddInt64(&stats.Errors, 1)
+			return wire.GRPCUnavailable, err.Error()
+		}
+		if stream {
+			http.NewResponseController(w).Flush()
+		}
+		atomic.AddInt64(&stats.Processed, 1)
+	}
+	return wire.GRPCOK, ""
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/internal/wire"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Limiter algorithms selected with -limit, named as in limiter snapshots.
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmFixedWindow   = "fixed_window"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmSlidingLog    = "sliding_log"
+)
+
+// Limit keys selected with -limit-key.
+const (
+	KeyGlobal = "global" // one limiter for all traffic
+	KeyIP     = "ip"     // one limiter per source IP
+	KeyAddr   = "addr"   // one limiter per source IP and port
+	KeyUser   = "user"   // one limiter per user field (wire.UserPrefix)
+)
+
+// Actions on rejected messages selected with -limit-action.
+const (
+	ActionReply = "reply" // answer with wire.RejectPrefix and the message
+	ActionDrop  = "drop"  // discard the message without answering
+)
+
+// LimitConfig attaches a rate limiter to the server.
+type LimitConfig struct {
+	Algorithm string // empty for no limiting
+	Rate      int
+	Period    time.Duration
+	Burst     int // 0 means Rate
+	Key       string
+	Action    string
+}
+
+// limiter enforces a LimitConfig, keeping one ratelimit.Limiter per key.
+type limiter struct {
+	config  LimitConfig
+	factory func() ratelimit.Limiter
+
+	mu   sync.Mutex
+	keys map[string]*keyLimiter
+}
+
+type keyLimiter struct {
+	limiter  ratelimit.Limiter
+	lastUsed time.Time
+}
+
+// newLimiter returns the limiter for config, or nil if config has no
+// algorithm.
+func newLimiter(config LimitConfig) (*limiter, error) {
+	if config.Algorithm == "" {
+		return nil, nil
+	}
+	if config.Rate <= 0 || config.Period <= 0 {
+		return nil, fmt.Errorf("-limit-rate and -limit-period must be positive")
+	}
+	switch config.Key {
+	case KeyGlobal, KeyIP, KeyAddr, KeyUser:
+	default:
+		return nil, fmt.Errorf("invalid -limit-key %q", config.Key)
+	}
+	switch config.Action {
+	case ActionReply, ActionDrop:
+	default:
+		return nil, fmt.Errorf("invalid -limit-action %q", config.Action)
+	}
+
+	burst := config.Burst
+	if burst <= 0 {
+		burst = config.Rate
+	}
+	opts := []ratelimit.Option{
+		ratelimit.WithRate(config.Rate),
+		ratelimit.WithPeriod(config.Period),
+		ratelimit.WithBurst(burst),
+	}
+	var factory func() ratelimit.Limiter
+	switch config.Algorithm {
+	case AlgorithmTokenBucket:
+		factory = func() ratelimit.Limiter { return ratelimit.NewTokenBucket(opts...) }
+	case AlgorithmFixedWindow:
+		factory = func() ratelimit.Limiter { return ratelimit.NewFixedWindow(opts...) }
+	case AlgorithmSlidingWindow:
+		factory = func() ratelimit.Limiter { return ratelimit.NewSlidingWindow(opts...) }
+	case AlgorithmSlidingLog:
+		factory = func() ratelimit.Limiter { return ratelimit.NewSlidingLog(opts...) }
+	default:
+		return nil, fmt.Errorf("invalid -limit algorithm %q", config.Algorithm)
+	}
+
+	return &limiter{
+		config:  config,
+		factory: factory,
+		keys:    make(map[string]*keyLimiter),
+	}, nil
+}
+
+// allow reports whether message, from addr, may be processed. message
+// starts where a user field would be.
+func (l *limiter) allow(addr net.Addr, message []byte) bool {
+	key := l.key(addr, message)
+	now := time.Now()
+
+	l.mu.Lock()
+	k, ok := l.keys[key]
+	if !ok {
+		k = &keyLimiter{limiter: l.factory()}
+		l.keys[key] = k
+	}
+	k.lastUsed = now
+	l.mu.Unlock()
+
+	return k.limiter.Allow()
+}
+
+// key returns the limiter key of message from addr. Messages without a
+// user field share one limiter under KeyUser.
+func (l *limiter) key(addr net.Addr, message []byte) string {
+	switch l.config.Key {
+	case KeyUser:
+		user, _ := wire.User(message)
+		return string(user)
+	case KeyIP:
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			return host
+		}
+		return addr.String()
+	case KeyAddr:
+		return addr.String()
+	}
+	return ""
+}
+
+// sweep forgets keys unused for longer than idle, so sources that went
+// away do not hold memory. Their next message starts a fresh limiter.
+func (l *limiter) sweep(idle time.Duration) {
+	cutoff := time.Now().Add(-idle)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, k := range l.keys {
+		if k.lastUsed.Before(cutoff) {
+			delete(l.keys, key)
+		}
+	}
+}
+
+// idleTimeout is how long a key must be unused before sweep forgets it:
+// long enough that its limiter has fully recovered.
+func (l *limiter) idleTimeout() time.Duration {
+	if idle := 2 * l.config.Period; idle > time.Minute {
+		return idle
+	}
+	return time.Minute
+}
+
+// describe formats the limit for the startup banner.
+func (l *limiter) describe() string {
+	burst := l.config.Burst
+	if burst <= 0 {
+		burst = l.config.Rate
+	}
+	return fmt.Sprintf("%s, %d per %s, burst %d, per %s, %s rejected messages",
+		l.config.Algorithm, l.config.Rate, l.config.Period, burst, l.config.Key, l.config.Action)
+}
+
+// throttle returns listener with each accepted connection throttled to
+// bandwidth bytes per second in each direction, or listener itself if
+// bandwidth is 0.
+func throttle(listener net.Listener, bandwidth int) net.Listener {
+	if bandwidth == 0 {
+		return listener
+	}
+	return &throttledListener{Listener: listener, bandwidth: bandwidth}
+}
+
+type throttledListener struct {
+	net.Listener
+	bandwidth int
+}
+
+func (l *throttledListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.NewConn(conn,
+		ratelimit.NewTokenBucket(ratelimit.WithBytesPerSecond(l.bandwidth)),
+		ratelimit.NewTokenBucket(ratelimit.WithBytesPerSecond(l.bandwidth))), nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// console receives the human-readable output. It is stderr when -output
+// selects a machine-readable format, leaving stdout to the records.
+var console io.Writer = os.Stdout
+
+// Record types.
+const (
+	RecordSnapshot = "snapshot" // written every -stats-interval
+	RecordFinal    = "final"    // written once at shutdown
+)
+
+// StatsRecord is one machine-readable stats record, written with -output
+// json or csv.
+type StatsRecord struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Elapsed   float64   `json:"elapsed_seconds"`
+	Received  int64     `json:"received"`
+	Processed int64     `json:"processed"`
+	Rejected  int64     `json:"rejected"`
+	Errors    int64     `json:"errors"`
+	Rate      float64   `json:"rate"` // messages per second since the previous snapshot, or over the run
+}
+
+// writeRecord writes r to the -output stream.
+func writeRecord(config *Config, r StatsRecord) {
+	if err := config.records.Write(r); err != nil {
+		log.Printf("Failed to write stats record: %v", err)
+	}
+}
@@ -0,0 +1,487 @@
+// Package server is the TCP/UDP/gRPC test server. It echoes messages back,
+// optionally through a rate limiter. It backs both the server binary and
+// rrl server.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+type Config struct {
+	Protocol      string
+	Port          int
+	Verbose       bool
+	Output        string
+	StatsInterval time.Duration
+	Limit         LimitConfig
+	TLS           TLSConfig
+	ConnBandwidth int // bytes per second each way per connection, 0 for no limit
+	
+	// tls is set when TLS is enabled.
+	tls *tls.Config
+	// records is set when Output selects a machine-readable format.
+	records *cli.RecordWriter
+	// limiter is set when Limit selects an algorithm.
+	limiter *limiter
+}
+
+type Stats struct {
+	Received   int64
+	Processed  int64
+	Rejected   int64
+	Errors     int64
+	StartTime  time.Time
+	mu         sync.Mutex
+	LastPrint  time.Time
+	
+	// lastReceived is Received at LastPrint.
+	lastReceived int64
+}
+
+// Main runs the test server with the given command-line arguments.
+func Main(args []string) {
+	config := parseFlags(args)
+	if config.Output != cli.FormatText {
+		console = os.Stderr
+		config.records = cli.NewRecordWriter(os.Stdout, config.Output)
+	}
+	
+	fmt.Fprintf(console, "Starting rate limit test server\n")
+	fmt.Fprintf(console, "Protocol: %s\n", config.Protocol)
+	fmt.Fprintf(console, "Port: %d\n", config.Port)
+	if config.tls != nil {
+		fmt.Fprintf(console, "TLS: %s\n", describeTLS(config.TLS, config.tls))
+	}
+	if config.limiter != nil {
+		fmt.Fprintf(console, "Limit: %s\n", config.limiter.describe())
+	}
+	if config.ConnBandwidth > 0 {
+		fmt.Fprintf(console, "Bandwidth: %d bytes/second each way per connection\n", config.ConnBandwidth)
+	}
+	fmt.Fprintln(console)
+	
+	stats := &Stats{
+		StartTime: time.Now(),
+		LastPrint: time.Now(),
+	}
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	
+	// Setup signal handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	
+	var wg sync.WaitGroup
+	
+	// Start stats printer
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		statsPrinter(ctx, config, stats)
+	}()
+	
+	if config.limiter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limitSweeper(ctx, config.limiter)
+		}()
+	}
+	
+	// Start server
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		switch config.Protocol {
+		case "tcp":
+			runTCPServer(ctx, config, stats)
+		case "udp":
+			runUDPServer(ctx, config, stats)
+		case "grpc":
+			runGRPCServer(ctx, config, stats)
+		default:
+			log.Fatalf("Invalid protocol: %s", config.Protocol)
+		}
+	}()
+	
+	// Wait for signal
+	<-sigChan
+	fmt.Fprintln(console, "\nShutting down server...")
+	cancel()
+	
+	// Wait for graceful shutdown
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	
+	select {
+	case <-done:
+		fmt.Fprintln(console, "Server shut down gracefully")
+	case <-time.After(5 * time.Second):
+		fmt.Fprintln(console, "Shutdown timeout exceeded")
+	}
+	
+	printFinalStats(config, stats)
+}
+
+func parseFlags(args []string) *Config {
+	config := &Config{}
+	
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	fs.StringVar(&config.Protocol, "protocol", "tcp", "Protocol (tcp, udp or grpc)")
+	fs.IntVar(&config.Port, "port", 8080, "Port to listen on")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	fs.StringVar(&config.Output, "output", cli.FormatText, "Output format: text, json (one object per line) or csv; json and csv go to stdout and the text to stderr")
+	fs.DurationVar(&config.StatsInterval, "stats-interval", 5*time.Second, "Interval between periodic stats snapshots")
+	fs.StringVar(&config.Limit.Algorithm, "limit", "", "Rate limit messages with this algorithm: token_bucket, fixed_window, sliding_window or sliding_log (default no limit)")
+	fs.IntVar(&config.Limit.Rate, "limit-rate", 100, "Messages allowed per -limit-period")
+	fs.DurationVar(&config.Limit.Period, "limit-period", time.Second, "Period of -limit-rate")
+	fs.IntVar(&config.Limit.Burst, "limit-burst", 0, "Burst size of the limiter (default -limit-rate)")
+	fs.StringVar(&config.Limit.Key, "limit-key", KeyGlobal, "Limit per global, ip (source IP), addr (source IP and port) or user (the user field of client -users)")
+	fs.StringVar(&config.Limit.Action, "limit-action", ActionReply, "On rejection, reply (with a RATE-LIMITED marker) or drop")
+	fs.BoolVar(&config.TLS.Enabled, "tls", false, "Serve TCP over TLS (always on for gRPC)")
+	fs.StringVar(&config.TLS.CertFile, "tls-cert", "", "PEM certificate (default a self-signed one for localhost)")
+	fs.StringVar(&config.TLS.KeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	fs.StringVar(&config.TLS.ClientCAFile, "tls-client-ca", "", "Require client certificates signed by the CAs in this PEM file (mutual TLS)")
+	fs.IntVar(&config.ConnBandwidth, "conn-bandwidth", 0, "Throttle each TCP or gRPC connection to this many bytes per second in each direction (default no limit)")
+	cli.Parse(fs, args)
+	
+	l, err := newLimiter(config.Limit)
+	if err != nil {
+		log.Fatalf("Invalid limit: %v", err)
+	}
+	config.limiter = l
+	if !cli.ValidFormat(config.Output) {
+		log.Fatalf("Invalid output format: %s", config.Output)
+	}
+	if config.StatsInterval <= 0 {
+		log.Fatalf("-stats-interval must be positive")
+	}
+	if config.Protocol == "grpc" {
+		config.TLS.Enabled = true
+	}
+	config.tls, err = serverTLS(config.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+	if config.tls != nil && config.Protocol == "udp" {
+		log.Fatalf("-tls is not supported over UDP")
+	}
+	if config.ConnBandwidth < 0 {
+		log.Fatalf("-conn-bandwidth must not be negative")
+	}
+	if config.ConnBandwidth > 0 && config.Protocol == "udp" {
+		log.Fatalf("-conn-bandwidth is not supported over UDP")
+	}
+	
+	return config
+}
+
+func runTCPServer(ctx context.Context, config *Config, stats *Stats) {
+	addr := fmt.Sprintf(":%d", config.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	defer listener.Close()
+	
+	listener = throttle(listener, config.ConnBandwidth)
+	if config.tls != nil {
+		listener = tls.NewListener(listener, config.tls)
+	}
+	
+	fmt.Fprintf(console, "TCP server listening on %s\n", addr)
+	
+	// Accept connections in a separate goroutine
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					log.Printf("Accept error: %v", err)
+					continue
+				}
+			}
+			
+			go handleTCPConnection(ctx, conn, config, stats)
+		}
+	}()
+	
+	<-ctx.Done()
+}
+
+func handleTCPConnection(ctx context.Context, conn net.Conn, config *Config, stats *Stats) {
+	defer conn.Close()
+	
+	if config.Verbose {
+		log.Printf("New TCP connection from %s", conn.RemoteAddr())
+	}
+	
+	// Complete the TLS handshake up front, so failures such as a missing
+	// client certificate are counted as errors.
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			atomic.AddInt64(&stats.Errors, 1)
+			if config.Verbose {
+				log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+	
+	buf := make([]byte, 65536)
+	var reply []byte
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					continue
+				}
+				if err.Error() != "EOF" && config.Verbose {
+					log.Printf("Read error from %s: %v", conn.RemoteAddr(), err)
+				}
+				return
+			}
+			
+			atomic.AddInt64(&stats.Received, 1)
+			
+			out := buf[:n]
+			allowed := admit(config, conn.RemoteAddr(), out, stats)
+			if !allowed {
+				if config.Limit.Action == ActionDrop {
+					continue
+				}
+				reply = rejectReply(reply, out)
+				out = reply
+			}
+			
+			// Echo back
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			_, err = conn.Write(out)
+			if err != nil {
+				atomic.AddInt64(&stats.Errors, 1)
+				if config.Verbose {
+					log.Printf("Write error to %s: %v", conn.RemoteAddr(), err)
+				}
+				return
+			}
+			
+			if allowed {
+				atomic.AddInt64(&stats.Processed, 1)
+			}
+		}
+	}
+}
+
+func runUDPServer(ctx context.Context, config *Config, stats *Stats) {
+	addr := fmt.Sprintf(":%d", config.Port)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Fatalf("Failed to resolve UDP address: %v", err)
+	}
+	
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	defer conn.Close()
+	
+	fmt.Fprintf(console, "UDP server listening on %s\n", addr)
+	
+	buf := make([]byte, 65536)
+	var reply []byte
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, clientAddr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					continue
+				}
+				atomic.AddInt64(&stats.Errors, 1)
+				if config.Verbose {
+					log.Printf("UDP read error: %v", err)
+				}
+				continue
+			}
+			
+			atomic.AddInt64(&stats.Received, 1)
+			
+			out := buf[:n]
+			// The user field follows the header the client starts
+			// UDP messages with.
+			allowed := admit(config, clientAddr, out[min(n, wire.HeaderSize):], stats)
+			if !allowed {
+				if config.Limit.Action == ActionDrop {
+					continue
+				}
+				reply = rejectReply(reply, out)
+				out = reply
+			}
+			
+			// Echo back
+			_, err = conn.WriteToUDP(out, clientAddr)
+			if err != nil {
+				atomic.AddInt64(&stats.Errors, 1)
+				if config.Verbose {
+					log.Printf("UDP write error to %s: %v", clientAddr, err)
+				}
+				continue
+			}
+			
+			if allowed {
+				atomic.AddInt64(&stats.Processed, 1)
+			}
+		}
+	}
+}
+
+// admit applies the server's limiter, if any, to message from addr and
+// counts it if rejected.
+func admit(config *Config, addr net.Addr, message []byte, stats *Stats) bool {
+	if config.limiter == nil || config.limiter.allow(addr, message) {
+		return true
+	}
+	atomic.AddInt64(&stats.Rejected, 1)
+	return false
+}
+
+// rejectReply builds the reply to a rejected message in buf.
+func rejectReply(buf, message []byte) []byte {
+	buf = append(buf[:0], wire.RejectPrefix...)
+	return append(buf, message...)
+}
+
+func limitSweeper(ctx context.Context, l *limiter) {
+	ticker := time.NewTicker(l.idleTimeout())
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.sweep(l.idleTimeout())
+		}
+	}
+}
+
+func statsPrinter(ctx context.Context, config *Config, stats *Stats) {
+	ticker := time.NewTicker(config.StatsInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			printCurrentStats(config, stats)
+		}
+	}
+}
+
+func printCurrentStats(config *Config, stats *Stats) {
+	received := atomic.LoadInt64(&stats.Received)
+	processed := atomic.LoadInt64(&stats.Processed)
+	rejected := atomic.LoadInt64(&stats.Rejected)
+	errors := atomic.LoadInt64(&stats.Errors)
+	
+	stats.mu.Lock()
+	now := time.Now()
+	duration := now.Sub(stats.LastPrint)
+	interval := received - stats.lastReceived
+	stats.LastPrint = now
+	stats.lastReceived = received
+	stats.mu.Unlock()
+	
+	// Rate over the interval since the previous snapshot.
+	rate := float64(interval) / duration.Seconds()
+	
+	if config.records != nil {
+		writeRecord(config, StatsRecord{
+			Type:      RecordSnapshot,
+			Time:      now,
+			Elapsed:   now.Sub(stats.StartTime).Seconds(),
+			Received:  received,
+			Processed: processed,
+			Rejected:  rejected,
+			Errors:    errors,
+			Rate:      rate,
+		})
+		return
+	}
+	
+	if config.limiter != nil {
+		fmt.Fprintf(console, "[%s] Received: %d, Processed: %d, Rejected: %d, Errors: %d, Rate: %.2f msg/s\n",
+			now.Format("15:04:05"),
+			received, processed, rejected, errors, rate)
+		return
+	}
+	fmt.Fprintf(console, "[%s] Received: %d, Processed: %d, Errors: %d, Rate: %.2f msg/s\n",
+		now.Format("15:04:05"),
+		received, processed, errors, rate)
+}
+
+func printFinalStats(config *Config, stats *Stats) {
+	duration := time.Since(stats.StartTime)
+	received := atomic.LoadInt64(&stats.Received)
+	processed := atomic.LoadInt64(&stats.Processed)
+	rejected := atomic.LoadInt64(&stats.Rejected)
+	errors := atomic.LoadInt64(&stats.Errors)
+	
+	if config.records != nil {
+		writeRecord(config, StatsRecord{
+			Type:      RecordFinal,
+			Time:      time.Now(),
+			Elapsed:   duration.Seconds(),
+			Received:  received,
+			Processed: processed,
+			Rejected:  rejected,
+			Errors:    errors,
+			Rate:      float64(received) / duration.Seconds(),
+		})
+	}
+	
+	fmt.Fprintln(console, "\n--- Final Statistics ---")
+	fmt.Fprintf(console, "Total duration: %s\n", duration.Round(time.Millisecond))
+	fmt.Fprintf(console, "Messages received: %d\n", received)
+	fmt.Fprintf(console, "Messages processed: %d\n", processed)
+	if config.limiter != nil {
+		fmt.Fprintf(console, "Messages rejected: %d\n", rejected)
+	}
+	fmt.Fprintf(console, "Errors: %d\n", errors)
+	fmt.Fprintf(console, "Success rate: %.2f%%\n", float64(processed)/float64(received)*100)
+	fmt.Fprintf(console, "Average rate: %.2f messages/second\n", float64(received)/duration.Seconds())
+}
\ No newline at end of file
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/rRateLimit/client/internal/wire"
+)
+
+// TLSConfig selects TLS for the TCP server.
+type TLSConfig struct {
+	Enabled      bool
+	CertFile     string // default a self-signed certificate
+	KeyFile      string
+	ClientCAFile string // requires client certificates signed by these CAs
+}
+
+// serverTLS returns the tls.Config for config, or nil if TLS is off.
+func serverTLS(config TLSConfig) (*tls.Config, error) {
+	if !config.Enabled {
+		if config.CertFile != "" || config.KeyFile != "" || config.ClientCAFile != "" {
+			return nil, fmt.Errorf("-tls-* flags require -tls")
+		}
+		return nil, nil
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+
+	var cert tls.Certificate
+	var err error
+	if config.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	} else {
+		cert, err = wire.SelfSignedCertificate("rate limit test server")
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", config.ClientCAFile)
+		}
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// describeTLS describes the TLS settings for the startup banner.
+func describeTLS(config TLSConfig, c *tls.Config) string {
+	desc := "certificate " + config.CertFile
+	if config.CertFile == "" {
+		desc = "self-signed certificate, SHA-256 " + wire.Fingerprint(c.Certificates[0])
+	}
+	if config.ClientCAFile != "" {
+		desc += ", client certificates verified against " + config.ClientCAFile
+	}
+	return desc
+}
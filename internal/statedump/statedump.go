@@ -0,0 +1,251 @@
+// Package statedump saves and summarises limiter state dumps. It backs
+// both the statedump binary and rrl ctl.
+package statedump
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rRateLimit/client/internal/cli"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// statedump saves and summarises limiter state dumps.
+//
+//	statedump fetch -url http://localhost:9092/debug/state -o state.json
+//	statedump view state.json
+//
+// fetch works against ratelimitd's admin API or any application serving
+// Middleware.DumpHandler. The bearer token is read from STATEDUMP_TOKEN.
+
+// Commands are the statedump subcommands.
+var Commands = []*cli.Command{
+	{Name: "fetch", Summary: "save a dump: fetch -url URL [-o FILE] (token from STATEDUMP_TOKEN)", Run: fetch},
+	{Name: "view", Summary: "summarise a dump: view [-top N] FILE (\"-\" reads stdin)", Run: view},
+}
+
+// Main runs the statedump subcommand named by args[0].
+func Main(args []string) {
+	cli.Dispatch("statedump", Commands, args)
+}
+
+// fetch downloads a dump and writes it to a file.
+func fetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:9092/debug/state", "Dump endpoint")
+	output := fs.String("o", "", "Output file (default state-<time>.json)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Request timeout")
+	cli.Parse(fs, args)
+
+	req, err := http.NewRequest(http.MethodGet, *url, nil)
+	if err != nil {
+		log.Fatalf("Invalid URL: %v", err)
+	}
+	if token := os.Getenv("STATEDUMP_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Fetch failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Fatalf("Fetch failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Fetch failed: %v", err)
+	}
+
+	var dump ratelimit.StateDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		log.Fatalf("Response is not a state dump: %v", err)
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("state-%s.json", dump.TakenAt.UTC().Format("20060102T150405Z"))
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		log.Fatalf("Failed to write %s: %v", path, err)
+	}
+	fmt.Printf("Saved %d keys taken at %s to %s\n", len(dump.Keys), dump.TakenAt.Format(time.RFC3339), path)
+}
+
+// keyStat is one key in the summary.
+type keyStat struct {
+	key        string
+	available  int
+	lastAccess time.Time
+}
+
+// view prints the distribution of remaining budgets across keys.
+func view(args []string) {
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	top := fs.Int("top", 10, "Number of most constrained keys to list")
+	cli.Parse(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		log.Fatalf("Failed to read dump: %v", err)
+	}
+
+	var dump ratelimit.StateDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		log.Fatalf("Failed to decode dump: %v", err)
+	}
+
+	fmt.Printf("Source: %s\n", dump.Source)
+	fmt.Printf("Taken at: %s\n", dump.TakenAt.Format(time.RFC3339))
+	fmt.Printf("Keys: %d\n", len(dump.Keys))
+	if len(dump.Keys) == 0 {
+		return
+	}
+
+	stats := make([]keyStat, 0, len(dump.Keys))
+	algorithms := make(map[string]int)
+	for key, kd := range dump.Keys {
+		stats = append(stats, keyStat{key: key, available: kd.Available, lastAccess: kd.LastAccess})
+		algorithms[algorithmOf(kd.State)]++
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].available != stats[j].available {
+			return stats[i].available < stats[j].available
+		}
+		return stats[i].key < stats[j].key
+	})
+
+	fmt.Print("Algorithms:")
+	for _, name := range sortedKeys(algorithms) {
+		fmt.Printf(" %s=%d", name, algorithms[name])
+	}
+	fmt.Println()
+
+	exhausted := 0
+	for _, s := range stats {
+		if s.available <= 0 {
+			exhausted++
+		}
+	}
+	fmt.Printf("Exhausted (no budget left): %d (%.1f%%)\n\n", exhausted, float64(exhausted)/float64(len(stats))*100)
+
+	fmt.Println("Remaining budget:")
+	fmt.Printf("  min %d  p10 %d  p50 %d  p90 %d  max %d\n\n",
+		stats[0].available,
+		stats[quantileIndex(len(stats), 0.10)].available,
+		stats[quantileIndex(len(stats), 0.50)].available,
+		stats[quantileIndex(len(stats), 0.90)].available,
+		stats[len(stats)-1].available)
+
+	printHistogram(stats)
+
+	if *top > len(stats) {
+		*top = len(stats)
+	}
+	fmt.Printf("\nMost constrained keys:\n")
+	fmt.Printf("  %-40s %-10s %s\n", "Key", "Available", "Last access")
+	for _, s := range stats[:*top] {
+		fmt.Printf("  %-40s %-10d %s ago\n", truncate(s.key, 40), s.available,
+			dump.TakenAt.Sub(s.lastAccess).Round(time.Second))
+	}
+}
+
+// printHistogram prints the number of keys per budget range.
+func printHistogram(stats []keyStat) {
+	const buckets = 10
+	min, max := stats[0].available, stats[len(stats)-1].available
+	width := (max - min + buckets) / buckets
+	if width < 1 {
+		width = 1
+	}
+
+	counts := make([]int, buckets)
+	for _, s := range stats {
+		i := (s.available - min) / width
+		if i >= buckets {
+			i = buckets - 1
+		}
+		counts[i]++
+	}
+
+	peak := 0
+	for _, c := range counts {
+		if c > peak {
+			peak = c
+		}
+	}
+
+	fmt.Println("Distribution:")
+	for i, c := range counts {
+		lo := min + i*width
+		hi := lo + width - 1
+		if lo > max {
+			break
+		}
+		bar := strings.Repeat("#", c*40/peak)
+		fmt.Printf("  %6d-%-6d %6d %s\n", lo, hi, c, bar)
+	}
+}
+
+// algorithmOf returns the algorithm named in a snapshot envelope.
+func algorithmOf(state json.RawMessage) string {
+	if len(state) == 0 {
+		return "unknown"
+	}
+	var envelope struct {
+		Algorithm string `json:"algorithm"`
+	}
+	if err := json.Unmarshal(state, &envelope); err != nil || envelope.Algorithm == "" {
+		return "unknown"
+	}
+	return envelope.Algorithm
+}
+
+func quantileIndex(n int, q float64) int {
+	i := int(q * float64(n))
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
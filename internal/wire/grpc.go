@@ -0,0 +1,223 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The gRPC echo service, in protobuf terms:
+//
+//	service Echo {
+//	  rpc Echo(EchoMessage) returns (EchoMessage);
+//	  rpc EchoStream(stream EchoMessage) returns (stream EchoMessage);
+//	}
+//	message EchoMessage { bytes payload = 1; }
+//
+// Both sides speak the gRPC wire protocol over HTTP/2 directly, so any gRPC
+// client or server with these definitions interoperates with them.
+const (
+	GRPCEcho       = "/rrl.Echo/Echo"
+	GRPCEchoStream = "/rrl.Echo/EchoStream"
+
+	GRPCContentType = "application/grpc"
+
+	// GRPCJSONContentType marks gRPC messages encoded as JSON rather than
+	// protobuf, which the client's agent service uses.
+	GRPCJSONContentType = GRPCContentType + "+json"
+)
+
+// gRPC status codes used by the client and server.
+const (
+	GRPCOK                = 0
+	GRPCCanceled          = 1
+	GRPCUnknown           = 2
+	GRPCInvalidArgument   = 3
+	GRPCDeadlineExceeded  = 4
+	GRPCResourceExhausted = 8
+	GRPCUnimplemented     = 12
+	GRPCInternal          = 13
+	GRPCUnavailable       = 14
+	GRPCUnauthenticated   = 16
+)
+
+var grpcCodeNames = map[int]string{
+	GRPCOK:                "OK",
+	GRPCCanceled:          "CANCELLED",
+	GRPCUnknown:           "UNKNOWN",
+	GRPCInvalidArgument:   "INVALID_ARGUMENT",
+	GRPCDeadlineExceeded:  "DEADLINE_EXCEEDED",
+	GRPCResourceExhausted: "RESOURCE_EXHAUSTED",
+	GRPCUnimplemented:     "UNIMPLEMENTED",
+	GRPCInternal:          "INTERNAL",
+	GRPCUnavailable:       "UNAVAILABLE",
+	GRPCUnauthenticated:   "UNAUTHENTICATED",
+}
+
+// GRPCCodeName returns the canonical name of a gRPC status code.
+func GRPCCodeName(code int) string {
+	if name, ok := grpcCodeNames[code]; ok {
+		return name
+	}
+	return "CODE_" + strconv.Itoa(code)
+}
+
+// GRPCMaxMessage is the largest message accepted, gRPC's default limit.
+const GRPCMaxMessage = 4 << 20
+
+// AppendGRPCMessage appends payload to dst as a length-prefixed gRPC
+// message carrying an EchoMessage.
+func AppendGRPCMessage(dst, payload []byte) []byte {
+	size := 1 + uvarintLen(uint64(len(payload))) + len(payload)
+	dst = append(dst, 0)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(size))
+	dst = append(dst, 0x0a) // field 1, length-delimited
+	dst = binary.AppendUvarint(dst, uint64(len(payload)))
+	return append(dst, payload...)
+}
+
+// ReadGRPCMessage reads one length-prefixed gRPC message from r into buf,
+// growing it as needed, and returns the EchoMessage payload and the buffer.
+// It returns io.EOF if r ends before a message starts.
+func ReadGRPCMessage(r io.Reader, buf []byte) (payload, newBuf []byte, err error) {
+	msg, buf, err := ReadGRPCFrame(r, buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	payload, err = echoPayload(msg)
+	return payload, buf, err
+}
+
+// ReadGRPCFrame reads one length-prefixed gRPC message from r into buf,
+// growing it as needed, and returns the encoded message and the buffer.
+// It returns io.EOF if r ends before a message starts.
+func ReadGRPCFrame(r io.Reader, buf []byte) (msg, newBuf []byte, err error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("grpc: truncated message prefix")
+		}
+		return nil, buf, err
+	}
+	if prefix[0] != 0 {
+		return nil, buf, errors.New("grpc: compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > GRPCMaxMessage {
+		return nil, buf, fmt.Errorf("grpc: message of %d bytes exceeds %d", size, GRPCMaxMessage)
+	}
+	if cap(buf) < int(size) {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, buf, errors.New("grpc: truncated message")
+	}
+	return buf, buf, nil
+}
+
+// AppendGRPCFrame appends msg, already encoded, to dst as a
+// length-prefixed gRPC message.
+func AppendGRPCFrame(dst, msg []byte) []byte {
+	dst = append(dst, 0)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(msg)))
+	return append(dst, msg...)
+}
+
+// echoPayload decodes an EchoMessage, skipping unknown fields.
+func echoPayload(msg []byte) ([]byte, error) {
+	var payload []byte
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errors.New("grpc: malformed message")
+		}
+		msg = msg[n:]
+		var skip uint64
+		switch tag & 7 {
+		case 0:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return nil, errors.New("grpc: malformed message")
+			}
+			skip = uint64(n)
+		case 1:
+			skip = 8
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return nil, errors.New("grpc: malformed message")
+			}
+			msg = msg[n:]
+			if tag>>3 == 1 {
+				payload = msg[:size]
+			}
+			skip = size
+		case 5:
+			skip = 4
+		default:
+			return nil, errors.New("grpc: malformed message")
+		}
+		if skip > uint64(len(msg)) {
+			return nil, errors.New("grpc: malformed message")
+		}
+		msg = msg[skip:]
+	}
+	return payload, nil
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// grpcTimeoutUnits are the grpc-timeout header units, finest first.
+var grpcTimeoutUnits = []struct {
+	unit byte
+	d    time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// FormatGRPCTimeout formats d as a grpc-timeout header value, in the
+// finest unit that fits the protocol's eight digits.
+func FormatGRPCTimeout(d time.Duration) string {
+	for _, u := range grpcTimeoutUnits {
+		if v := (d + u.d - 1) / u.d; v < 1e8 {
+			return strconv.FormatInt(int64(v), 10) + string(u.unit)
+		}
+	}
+	return "99999999H"
+}
+
+// ParseGRPCTimeout parses a grpc-timeout header value.
+func ParseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	for _, u := range grpcTimeoutUnits {
+		if u.unit == s[len(s)-1] {
+			if v > int64(math.MaxInt64/u.d) {
+				return math.MaxInt64, true
+			}
+			return time.Duration(v) * u.d, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+// Package wire defines what the test client and server exchange besides
+// the echoed payload.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// RejectPrefix starts the reply to a message the server's limiter
+// rejected; the rejected message follows it. Accepted messages are echoed
+// unchanged.
+var RejectPrefix = []byte("RATE-LIMITED\n")
+
+// Rejected reports whether reply is a rejection.
+func Rejected(reply []byte) bool {
+	return bytes.HasPrefix(reply, RejectPrefix)
+}
+
+// Message returns the message a reply carries: the reply itself, or the
+// rejected message following RejectPrefix.
+func Message(reply []byte) []byte {
+	return bytes.TrimPrefix(reply, RejectPrefix)
+}
+
+// HeaderSize is the size of the header the client starts UDP messages
+// with: a big-endian sequence number followed by the send time in Unix
+// nanoseconds. The server echoes it with the rest of the message, so the
+// client can match replies to what it sent.
+const HeaderSize = 16
+
+// PutHeader writes the header for message seq sent at sent into the start
+// of message, which must be at least HeaderSize long.
+func PutHeader(message []byte, seq uint64, sent time.Time) {
+	binary.BigEndian.PutUint64(message, seq)
+	binary.BigEndian.PutUint64(message[8:], uint64(sent.UnixNano()))
+}
+
+// Header reads the header at the start of message. ok is false if the
+// message is too short to carry one.
+func Header(message []byte) (seq uint64, sent time.Time, ok bool) {
+	if len(message) < HeaderSize {
+		return 0, time.Time{}, false
+	}
+	seq = binary.BigEndian.Uint64(message)
+	sent = time.Unix(0, int64(binary.BigEndian.Uint64(message[8:])))
+	return seq, sent, true
+}
+
+// UserPrefix starts the user field of a message the client sends as a
+// simulated user: "user=<key>\n" at the start of the message, or over UDP
+// right after the header. The server can rate limit per user with it.
+var UserPrefix = []byte("user=")
+
+// AppendUser appends the user field for key to dst.
+func AppendUser(dst []byte, key string) []byte {
+	dst = append(dst, UserPrefix...)
+	dst = append(dst, key...)
+	return append(dst, '\n')
+}
+
+// User returns the key in the user field at the start of message. ok is
+// false if there is none.
+func User(message []byte) (key []byte, ok bool) {
+	rest, ok := bytes.CutPrefix(message, UserPrefix)
+	if !ok {
+		return nil, false
+	}
+	key, _, ok = bytes.Cut(rest, []byte{'\n'})
+	return key, ok
+}
@@ -0,0 +1,582 @@
+// Package yaml reads the subset of YAML used for the repository's
+// configuration and scenario files, so they can be written in YAML without
+// a third-party dependency. Documents are converted to JSON and decoded
+// with encoding/json, so the same struct tags serve both formats.
+//
+// Supported are block mappings and sequences, flow collections ([a, b]
+// and {k: v}), plain, single- and double-quoted scalars, literal (|) and
+// folded (>) block scalars, and comments. Anchors, aliases, tags, complex
+// keys and multi-document streams are not.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal decodes the YAML document in data into v, following the rules
+// of json.Unmarshal.
+func Unmarshal(data []byte, v interface{}) error {
+	js, err := ToJSON(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+// ToJSON converts the YAML document in data to JSON. An empty document
+// becomes null.
+func ToJSON(data []byte) ([]byte, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	// The final line break ends the last line rather than starting an
+	// empty one, which a kept (|+) block scalar would count.
+	p := &parser{lines: strings.Split(strings.TrimSuffix(text, "\n"), "\n")}
+	value, err := p.document()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// parser reads a document line by line. Block structure is decided by
+// indentation; each line holds at most one mapping entry or sequence
+// item, plus its inline value.
+type parser struct {
+	lines []string
+	i     int // index of the next line to read
+	cur   int // index of the line being parsed, for errors
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", p.cur+1, fmt.Sprintf(format, args...))
+}
+
+// document parses the whole input.
+func (p *parser) document() (interface{}, error) {
+	// The document ends at a "..." marker, which the block parsers would
+	// otherwise read as a node; only comments may follow it.
+	lines := p.lines
+	for i, raw := range lines {
+		if raw == "..." || strings.HasPrefix(raw, "... ") {
+			p.lines, p.i = lines[i+1:], 0
+			if line, _, ok, err := p.peek(); err != nil {
+				return nil, err
+			} else if ok {
+				p.cur += i + 1
+				return nil, p.errorf("unexpected content %q after the end of the document", line)
+			}
+			p.lines, p.i = lines[:i], 0
+			break
+		}
+	}
+
+	line, indent, ok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if ok && line == "---" {
+		p.i++
+		line, indent, ok, err = p.peek()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+	value, err := p.node(indent)
+	if err != nil {
+		return nil, err
+	}
+	if line, _, ok, err := p.peek(); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, p.errorf("unexpected content %q", line)
+	}
+	return value, nil
+}
+
+// peek skips blank and comment lines and returns the current line without
+// indentation or trailing comment, and its indentation. ok is false at
+// the end of the input.
+func (p *parser) peek() (line string, indent int, ok bool, err error) {
+	for ; p.i < len(p.lines); p.i++ {
+		p.cur = p.i
+		raw := p.lines[p.i]
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return "", 0, false, p.errorf("tabs are not allowed in indentation")
+		}
+		content := strings.TrimSpace(stripComment(trimmed))
+		if content == "" {
+			continue
+		}
+		return content, len(raw) - len(trimmed), true, nil
+	}
+	return "", 0, false, nil
+}
+
+// node parses the block node starting at the current line, which is
+// indented by indent.
+func (p *parser) node(indent int) (interface{}, error) {
+	line, _, _, _ := p.peek()
+	switch {
+	case isSeqItem(line):
+		return p.sequence(indent)
+	case keyEnd(line) >= 0:
+		return p.mapping(indent)
+	}
+	p.i++
+	return inline(line, p)
+}
+
+// mapping parses the entries of a block mapping indented by indent.
+func (p *parser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for {
+		line, ind, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || ind < indent {
+			return m, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		end := keyEnd(line)
+		if end < 0 {
+			if isSeqItem(line) {
+				return nil, p.errorf("sequence item where a mapping key is expected")
+			}
+			return nil, p.errorf("expected \"key: value\", got %q", line)
+		}
+		key, err := scalarKey(line[:end], p)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		rest := strings.TrimSpace(line[end+1:])
+		p.i++
+
+		m[key], err = p.value(rest, indent, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sequence parses the items of a block sequence indented by indent.
+func (p *parser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for {
+		line, ind, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || ind < indent || (ind == indent && !isSeqItem(line)) {
+			return items, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+
+		rest := strings.TrimLeft(line[1:], " ")
+		if rest != "" && (isSeqItem(rest) || keyEnd(rest) >= 0) {
+			// A compact nested node, as in "- name: x": blank out the
+			// dash and parse the rest as a block at its own column.
+			raw := p.lines[p.i]
+			col := len(raw) - len(strings.TrimLeft(raw[indent+1:], " "))
+			p.lines[p.i] = strings.Repeat(" ", col) + raw[col:]
+			item, err := p.node(col)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		p.i++
+		item, err := p.value(rest, indent, false)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// value parses the value following a mapping key or sequence dash on a
+// line indented by indent: inline, a block scalar, or a nested block on
+// the following lines. In a mapping, a sequence may sit at the key's own
+// indentation.
+func (p *parser) value(rest string, indent int, inMapping bool) (interface{}, error) {
+	if rest != "" {
+		if rest[0] == '|' || rest[0] == '>' {
+			return p.blockScalar(rest, indent)
+		}
+		return inline(rest, p)
+	}
+
+	line, ind, ok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ok && ind > indent:
+		return p.node(ind)
+	case ok && inMapping && ind == indent && isSeqItem(line):
+		return p.sequence(ind)
+	}
+	return nil, nil
+}
+
+// blockScalar reads a literal (|) or folded (>) block scalar whose
+// header is header, from the lines indented more than indent.
+func (p *parser) blockScalar(header string, indent int) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := byte(0)
+	for _, c := range strings.TrimSpace(stripComment(header[1:])) {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			// Explicit indentation indicators are accepted but the
+			// indentation is always taken from the first line.
+		default:
+			return nil, p.errorf("invalid block scalar header %q", header)
+		}
+	}
+
+	var lines []string
+	blockIndent := -1
+	for ; p.i < len(p.lines); p.i++ {
+		raw := p.lines[p.i]
+		trimmed := strings.TrimLeft(raw, " ")
+		ind := len(raw) - len(trimmed)
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if ind <= indent || (blockIndent >= 0 && ind < blockIndent) {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = ind
+		}
+		lines = append(lines, raw[blockIndent:])
+	}
+
+	// Trailing blank lines belong to the chomping, not the content.
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var text string
+	if folded {
+		var b strings.Builder
+		for i, line := range lines {
+			switch {
+			case i == 0:
+			case line == "" || lines[i-1] == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(lines[i-1], " "):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line)
+		}
+		text = strings.ReplaceAll(b.String(), "\n\n", "\n")
+	} else {
+		text = strings.Join(lines, "\n")
+	}
+
+	switch {
+	case len(lines) == 0:
+	case chomp == '-':
+	case chomp == '+':
+		text += strings.Repeat("\n", trailing+1)
+	default:
+		text += "\n"
+	}
+	return text, nil
+}
+
+// isSeqItem reports whether line starts a block sequence item.
+func isSeqItem(line string) bool {
+	return line == "-" || strings.HasPrefix(line, "- ")
+}
+
+// keyEnd returns the index of the colon ending the mapping key at the
+// start of line, or -1 if line is not a mapping entry.
+func keyEnd(line string) int {
+	if line == "" || line[0] == '[' || line[0] == '{' {
+		return -1
+	}
+	if q := line[0]; q == '"' || q == '\'' {
+		end := quoteEnd(line, 0)
+		if end < 0 || end+1 >= len(line) || line[end+1] != ':' {
+			return -1
+		}
+		if end+2 < len(line) && line[end+2] != ' ' {
+			return -1
+		}
+		return end + 1
+	}
+	for i := 0; i < len(line); i++ {
+		if line[i] == ':' && (i+1 == len(line) || line[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// quoteEnd returns the index of the quote closing the quoted scalar that
+// starts at s[start], or -1.
+func quoteEnd(s string, start int) int {
+	q := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q && q == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a trailing comment: a # at the start or after a
+// space, outside quotes.
+func stripComment(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			if i == 0 || strings.ContainsRune(" [{,:", rune(s[i-1])) {
+				if end := quoteEnd(s, i); end >= 0 {
+					i = end
+				}
+			}
+		case '#':
+			if i == 0 || s[i-1] == ' ' {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+// scalarKey returns the mapping key written as s.
+func scalarKey(s string, p *parser) (string, error) {
+	s = strings.TrimSpace(s)
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		return unquote(s, p)
+	}
+	return s, nil
+}
+
+// inline parses a value written on one line: a flow collection or a
+// scalar.
+func inline(s string, p *parser) (interface{}, error) {
+	if s[0] == '[' || s[0] == '{' {
+		f := &flow{s: s, p: p}
+		value, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.space()
+		if f.pos != len(s) {
+			return nil, p.errorf("unexpected %q after flow collection", s[f.pos:])
+		}
+		return value, nil
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		if end := quoteEnd(s, 0); end != len(s)-1 {
+			return nil, p.errorf("invalid quoted scalar %s", s)
+		}
+		return unquote(s, p)
+	}
+	if s[0] == '&' || s[0] == '*' || s[0] == '!' {
+		return nil, p.errorf("anchors, aliases and tags are not supported")
+	}
+	return plain(s), nil
+}
+
+// unquote returns the content of a single- or double-quoted scalar.
+func unquote(s string, p *parser) (string, error) {
+	if s[0] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	// YAML double-quoted escapes are a superset of JSON's and mostly
+	// Go's; \/ and \e are not Go escapes.
+	u, err := strconv.Unquote(strings.NewReplacer(`\/`, `/`, `\e`, `\x1b`).Replace(s))
+	if err != nil {
+		return "", p.errorf("invalid quoted scalar %s", s)
+	}
+	return u, nil
+}
+
+var (
+	intPattern   = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)$`)
+	floatPattern = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// plain resolves a plain scalar to null, a boolean, a number or a string,
+// following the YAML 1.2 core schema.
+func plain(s string) interface{} {
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if intPattern.MatchString(s) {
+		return json.Number(strings.TrimPrefix(s, "+"))
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0o") {
+		if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+	}
+	if floatPattern.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	return s
+}
+
+// flow parses a flow collection.
+type flow struct {
+	s   string
+	pos int
+	p   *parser
+}
+
+func (f *flow) space() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *flow) value() (interface{}, error) {
+	f.space()
+	if f.pos == len(f.s) {
+		return nil, f.p.errorf("unterminated flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		return f.sequence()
+	case '{':
+		return f.mapping()
+	}
+	return f.scalar()
+}
+
+func (f *flow) sequence() (interface{}, error) {
+	f.pos++
+	items := []interface{}{}
+	for {
+		f.space()
+		if f.pos < len(f.s) && f.s[f.pos] == ']' {
+			f.pos++
+			return items, nil
+		}
+		item, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if err := f.separator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *flow) mapping() (interface{}, error) {
+	f.pos++
+	m := make(map[string]interface{})
+	for {
+		f.space()
+		if f.pos < len(f.s) && f.s[f.pos] == '}' {
+			f.pos++
+			return m, nil
+		}
+		k, err := f.scalar()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		f.space()
+		var value interface{}
+		if f.pos < len(f.s) && f.s[f.pos] == ':' {
+			f.pos++
+			if value, err = f.value(); err != nil {
+				return nil, err
+			}
+		}
+		m[key] = value
+		if err := f.separator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// separator consumes the comma after an item, or leaves the closing
+// bracket for the caller.
+func (f *flow) separator(closing byte) error {
+	f.space()
+	switch {
+	case f.pos < len(f.s) && f.s[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.s) && f.s[f.pos] == closing:
+		return nil
+	}
+	return f.p.errorf("expected ',' or '%c' in flow collection", closing)
+}
+
+func (f *flow) scalar() (interface{}, error) {
+	f.space()
+	start := f.pos
+	if f.pos < len(f.s) && (f.s[f.pos] == '"' || f.s[f.pos] == '\'') {
+		end := quoteEnd(f.s, f.pos)
+		if end < 0 {
+			return nil, f.p.errorf("unterminated quoted scalar")
+		}
+		f.pos = end + 1
+		return unquote(f.s[start:f.pos], f.p)
+	}
+	for f.pos < len(f.s) {
+		c := f.s[f.pos]
+		if c == ',' || c == ']' || c == '}' || (c == ':' && (f.pos+1 == len(f.s) || strings.IndexByte(" ,]}", f.s[f.pos+1]) >= 0)) {
+			break
+		}
+		f.pos++
+	}
+	s := strings.TrimSpace(f.s[start:f.pos])
+	if s == "" {
+		return nil, f.p.errorf("empty value in flow collection")
+	}
+	return plain(s), nil
+}
@@ -0,0 +1,134 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/client/internal/yaml"
+)
+
+func TestToJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", `null`},
+		{"only comments", "# nothing\n\n  # here\n", `null`},
+		{"document markers", "---\na: 1\n...\n", `{"a":1}`},
+		{"end marker only", "...\n# after\n", `null`},
+		{"end marker after sequence", "- a\n... # end\n", `["a"]`},
+
+		{"block mapping", "a: 1\nb: two\n", `{"a":1,"b":"two"}`},
+		{"nested mapping", "a:\n  b:\n    c: x\n  d: y\n", `{"a":{"b":{"c":"x"},"d":"y"}}`},
+		{"empty value", "a:\nb: 1\n", `{"a":null,"b":1}`},
+		{"quoted keys", "\"a b\": 1\n'c:d': 2\n", `{"a b":1,"c:d":2}`},
+		{"colon in plain value", "url: http://example.com:8080/x\n", `{"url":"http://example.com:8080/x"}`},
+
+		{"block sequence", "- 1\n- x\n- true\n", `[1,"x",true]`},
+		{"nested sequence", "- - a\n  - b\n- c\n", `[["a","b"],"c"]`},
+		{"sequence at key indent", "items:\n- a\n- b\nn: 1\n", `{"items":["a","b"],"n":1}`},
+		{"indented sequence", "items:\n  - a\n  - b\n", `{"items":["a","b"]}`},
+		{"compact mappings", "- name: x\n  rate: 2\n- name: y\n", `[{"name":"x","rate":2},{"name":"y"}]`},
+		{"empty item", "-\n- a\n", `[null,"a"]`},
+
+		{"flow sequence", "a: [x, 1, true, null]\n", `{"a":["x",1,true,null]}`},
+		{"flow mapping", "a: {k: v, n: 2}\n", `{"a":{"k":"v","n":2}}`},
+		{"nested flow", "{a: [1, {b: c}], d: []}\n", `{"a":[1,{"b":"c"}],"d":[]}`},
+		{"flow quoted", "[\"a, b\", 'c]']\n", `["a, b","c]"]`},
+		{"flow url", "[http://x/y, a:b]\n", `["http://x/y","a:b"]`},
+		{"flow key without value", "{a, b: 1}\n", `{"a":null,"b":1}`},
+
+		{"double quoted escapes", `a: "x\ty\n\u00e9\/"` + "\n", `{"a":"x\ty\né/"}`},
+		{"single quoted", "a: 'it''s \\n'\n", `{"a":"it's \\n"}`},
+		{"quoted number stays string", "a: \"1\"\n", `{"a":"1"}`},
+		{"null forms", "a: ~\nb: null\nc: NULL\n", `{"a":null,"b":null,"c":null}`},
+		{"booleans", "a: true\nb: False\nc: yes\n", `{"a":true,"b":false,"c":"yes"}`},
+		{"integers", "a: 42\nb: -7\nc: +5\nd: 0x10\ne: 0o17\n", `{"a":42,"b":-7,"c":5,"d":16,"e":15}`},
+		{"floats", "a: 1.5\nb: 2e3\nc: .5\n", `{"a":1.5,"b":2000,"c":0.5}`},
+		{"not numbers", "a: 1m\nb: 1.2.3\nc: 007x\n", `{"a":"1m","b":"1.2.3","c":"007x"}`},
+
+		{"comments", "a: 1 # one\n# line\nb: x#y\nc: \"#z\" # quoted\n", `{"a":1,"b":"x#y","c":"#z"}`},
+		{"comment in flow", "a: [1, 2] # list\n", `{"a":[1,2]}`},
+
+		{"literal", "a: |\n  l1\n    l2\n\n  l3\nb: 1\n", `{"a":"l1\n  l2\n\nl3\n","b":1}`},
+		{"literal strip", "a: |-\n  x\n\n", `{"a":"x"}`},
+		{"literal keep", "a: |+\n  x\n\n\n", `{"a":"x\n\n\n"}`},
+		{"literal with comment chars", "a: |\n  # not a comment\n", `{"a":"# not a comment\n"}`},
+		{"folded", "a: >\n  one\n  two\n\n  three\n", `{"a":"one two\nthree\n"}`},
+		{"folded more indented", "a: >\n  one\n    two\n  three\n", `{"a":"one\n  two\nthree\n"}`},
+		{"block scalar in sequence", "- |\n  x\n- y\n", `["x\n","y"]`},
+		{"empty block scalar", "a: |\nb: 1\n", `{"a":"","b":1}`},
+
+		{"top-level scalar", "hello\n", `"hello"`},
+		{"crlf", "a: 1\r\nb: 2\r\n", `{"a":1,"b":2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := yaml.ToJSON([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("ToJSON(%q): %v", tt.in, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ToJSON(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "line 2: tabs are not allowed"},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: duplicate key "a"`},
+		{"over-indented entry", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"over-indented item", "- a\n   - b\n", "line 2: unexpected indentation"},
+		{"item in mapping", "a:\n  b: 1\n  - c\n", "line 3: sequence item where a mapping key is expected"},
+		{"scalar in mapping", "a: 1\nb\n", `line 2: expected "key: value", got "b"`},
+		{"content after document", "x\ny\n", `line 2: unexpected content "y"`},
+		{"content after end marker", "a: 1\n...\n\nb: 2\n", `line 4: unexpected content "b: 2" after the end of the document`},
+		{"unterminated flow", "a: 1\nb: [1, 2\n", "line 2: expected ',' or ']' in flow collection"},
+		{"text after flow", "a: [1] x\n", `line 1: unexpected "x" after flow collection`},
+		{"empty flow item", "a: [1, , 2]\n", "line 1: empty value in flow collection"},
+		{"unterminated quote in flow", "a: [\"x]\n", "line 1: unterminated quoted scalar"},
+		{"text after quote", "a: \"x\" y\n", "line 1: invalid quoted scalar"},
+		{"bad escape", "a: \"\\q\"\n", "line 1: invalid quoted scalar"},
+		{"anchor", "a: &x 1\n", "line 1: anchors, aliases and tags are not supported"},
+		{"alias", "a: 1\nb: *x\n", "line 2: anchors, aliases and tags are not supported"},
+		{"block scalar header", "a: |x\n  y\n", `line 1: invalid block scalar header "|x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := yaml.ToJSON([]byte(tt.in))
+			if err == nil {
+				t.Fatalf("ToJSON(%q) = %s, want error containing %q", tt.in, got, tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), "yaml: ") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ToJSON(%q) error = %q, want it to contain %q", tt.in, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var v struct {
+		Name  string   `json:"name"`
+		Rate  int      `json:"rate"`
+		Ratio float64  `json:"ratio"`
+		Tags  []string `json:"tags"`
+	}
+	in := "name: api\nrate: 100\nratio: 0.25\ntags: [a, b]\n"
+	if err := yaml.Unmarshal([]byte(in), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "api" || v.Rate != 100 || v.Ratio != 0.25 || len(v.Tags) != 2 || v.Tags[1] != "b" {
+		t.Errorf("Unmarshal = %+v", v)
+	}
+
+	if err := yaml.Unmarshal([]byte("rate: fast\n"), &v); err == nil {
+		t.Error("Unmarshal of a string into an int succeeded")
+	}
+}
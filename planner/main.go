@@ -0,0 +1,12 @@
+// Command planner is the same program as rrl plan; see internal/planner.
+package main
+
+import (
+	"os"
+
+	"github.com/rRateLimit/client/internal/planner"
+)
+
+func main() {
+	planner.Main(os.Args[1:])
+}
@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AccessRule matches requests by client network, API key or header value.
+// A request matches if any of the configured criteria match.
+type AccessRule struct {
+	// Networks are client networks, matched against the address the
+	// connection came from (RemoteAddr), never against forwarding headers.
+	Networks []*net.IPNet
+
+	// APIKeys are API key values, read from APIKeyHeader.
+	APIKeys []string
+
+	// APIKeyHeader is the header carrying the API key. Defaults to
+	// "X-API-Key".
+	APIKeyHeader string
+
+	// Headers maps header names to values that match. Clients can send
+	// any header, so only match headers that a trusted proxy sets and
+	// strips from incoming requests. Match probes and internal services
+	// by Networks instead.
+	Headers map[string][]string
+}
+
+// ParseCIDRs parses CIDR ranges for AccessRule.Networks. A bare address is
+// treated as a single-host range.
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// MustParseCIDRs is like ParseCIDRs but panics on error. It is intended for
+// static configuration.
+func MustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := ParseCIDRs(cidrs...)
+	if err != nil {
+		panic("ratelimit: " + err.Error())
+	}
+	return nets
+}
+
+// accessMatcher is a compiled AccessRule.
+type accessMatcher struct {
+	networks  []*net.IPNet
+	apiKeys   map[string]struct{}
+	apiHeader string
+	headers   map[string]map[string]struct{}
+}
+
+// compileAccessRule prepares rule for matching. It returns nil for a nil
+// or empty rule.
+func compileAccessRule(rule *AccessRule) *accessMatcher {
+	if rule == nil || (len(rule.Networks) == 0 && len(rule.APIKeys) == 0 && len(rule.Headers) == 0) {
+		return nil
+	}
+
+	am := &accessMatcher{
+		networks:  rule.Networks,
+		apiHeader: rule.APIKeyHeader,
+	}
+	if am.apiHeader == "" {
+		am.apiHeader = "X-API-Key"
+	}
+	if len(rule.APIKeys) > 0 {
+		am.apiKeys = make(map[string]struct{}, len(rule.APIKeys))
+		for _, k := range rule.APIKeys {
+			am.apiKeys[k] = struct{}{}
+		}
+	}
+	if len(rule.Headers) > 0 {
+		am.headers = make(map[string]map[string]struct{}, len(rule.Headers))
+		for name, values := range rule.Headers {
+			set := make(map[string]struct{}, len(values))
+			for _, v := range values {
+				set[v] = struct{}{}
+			}
+			am.headers[http.CanonicalHeaderKey(name)] = set
+		}
+	}
+	return am
+}
+
+// match reports whether r matches the rule.
+func (am *accessMatcher) match(r *http.Request) bool {
+	if am == nil {
+		return false
+	}
+
+	if len(am.networks) > 0 {
+		if ip := remoteIP(r); ip != nil {
+			for _, n := range am.networks {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+
+	if am.apiKeys != nil {
+		if key := r.Header.Get(am.apiHeader); key != "" {
+			if _, ok := am.apiKeys[key]; ok {
+				return true
+			}
+		}
+	}
+
+	for name, values := range am.headers {
+		for _, v := range r.Header[name] {
+			if _, ok := values[v]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the IP of the connection r arrived on.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,268 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveConcurrencyConfig configures an AdaptiveConcurrency limiter.
+// Zero fields take their defaults.
+type AdaptiveConcurrencyConfig struct {
+	// InitialLimit is the concurrency limit to start from. Defaults to 20.
+	InitialLimit int
+
+	// MinLimit and MaxLimit bound the limit. Default to 1 and 1000.
+	MinLimit, MaxLimit int
+
+	// TargetLatency selects the algorithm. If set, the limit follows AIMD:
+	// it grows by one for every request completing within the target while
+	// at least half the limit is in use, and shrinks by Backoff when a
+	// request is slower than the target or dropped, at most once per round
+	// trip. If zero, the limit follows
+	// the latency gradient: it shrinks as recent latency rises above the
+	// lowest latency seen, so no target needs to be known.
+	TargetLatency time.Duration
+
+	// Backoff is the factor applied to the limit when a request is dropped,
+	// or in AIMD mode exceeds the target. Defaults to 0.9.
+	Backoff float64
+
+	// OnLimitChange is called with the old and new limit whenever the limit
+	// changes.
+	OnLimitChange func(old, new int)
+
+	// Clock is the time source for latencies. Defaults to SystemClock.
+	Clock Clock
+}
+
+// AdaptiveConcurrency is a concurrency limiter whose limit adjusts to the
+// latency of the work it admits. By Little's law, the concurrency a
+// service sustains is its throughput times its latency: while latency
+// holds, more concurrency means more throughput, and once latency grows,
+// extra concurrency only queues. The limiter looks for that point, so a
+// service degrades by rejecting the excess instead of slowing down every
+// request.
+//
+// Callers acquire a slot, do the work and report its outcome by calling
+// the returned done function:
+//
+//	done, err := limiter.Acquire(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	err = call(ctx)
+//	done(err == nil) // false reports a drop, such as a timeout or overload
+type AdaptiveConcurrency struct {
+	config AdaptiveConcurrencyConfig
+	sem    *Semaphore
+
+	mu        sync.Mutex
+	limit     float64
+	shortRTT  float64   // recent latency in seconds, for the gradient
+	minRTT    float64   // latency without queueing in seconds, for the gradient
+	decreased time.Time // when the limit was last backed off
+}
+
+// Parameters of the gradient algorithm.
+const (
+	shortRTTAlpha     = 0.2
+	minRTTDrift       = 1e-4 // per request, so the baseline follows lasting change
+	gradientTolerance = 1.5  // latency growth accepted before backing off
+	gradientSmoothing = 0.2
+)
+
+// NewAdaptiveConcurrency returns a limiter configured by config, which may
+// be nil for the defaults.
+func NewAdaptiveConcurrency(config *AdaptiveConcurrencyConfig) *AdaptiveConcurrency {
+	a := &AdaptiveConcurrency{}
+	if config != nil {
+		a.config = *config
+	}
+	c := &a.config
+	if c.MinLimit <= 0 {
+		c.MinLimit = 1
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 1000
+	}
+	c.MaxLimit = max(c.MaxLimit, c.MinLimit)
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = 20
+	}
+	c.InitialLimit = max(c.MinLimit, c.InitialLimit)
+	if c.InitialLimit > c.MaxLimit {
+		c.InitialLimit = c.MaxLimit
+	}
+	if c.Backoff <= 0 || c.Backoff >= 1 {
+		c.Backoff = 0.9
+	}
+	if c.Clock == nil {
+		c.Clock = SystemClock{}
+	}
+
+	a.limit = float64(c.InitialLimit)
+	a.sem = NewSemaphore(int64(c.InitialLimit))
+	return a
+}
+
+// Acquire blocks until a slot is free or ctx is done. On success, done
+// must be called once the work is over, with ok false if it was dropped,
+// timed out or found the service overloaded.
+func (a *AdaptiveConcurrency) Acquire(ctx context.Context) (done func(ok bool), err error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return a.done(), nil
+}
+
+// TryAcquire takes a slot if one is free now, and reports whether it did.
+// On success, done must be called as for Acquire.
+func (a *AdaptiveConcurrency) TryAcquire() (done func(ok bool), ok bool) {
+	if !a.sem.TryAcquire(1) {
+		return nil, false
+	}
+	return a.done(), true
+}
+
+// done returns the function that releases a slot acquired now and
+// records its outcome.
+func (a *AdaptiveConcurrency) done() func(ok bool) {
+	start := a.config.Clock.Now()
+	var once sync.Once
+	return func(ok bool) {
+		once.Do(func() {
+			// The slot counts as in flight while the sample is judged.
+			a.observe(start, a.config.Clock.Now(), ok)
+			a.sem.Release(1)
+		})
+	}
+}
+
+// observe adjusts the limit for a request that ran from start to end.
+func (a *AdaptiveConcurrency) observe(start, end time.Time, ok bool) {
+	inFlight := float64(a.sem.InUse())
+	latency := end.Sub(start)
+
+	a.mu.Lock()
+	old := int(a.limit)
+	switch {
+	case !ok || a.config.TargetLatency > 0 && latency > a.config.TargetLatency:
+		// Back off at most once per round trip, like TCP: requests that
+		// started before the last backoff ran under the older, higher
+		// limit and say nothing about the current one.
+		if start.After(a.decreased) {
+			a.limit *= a.config.Backoff
+			a.decreased = end
+		}
+	case a.config.TargetLatency > 0:
+		a.aimd(inFlight)
+	default:
+		a.gradient(latency, inFlight)
+	}
+	a.limit = math.Max(float64(a.config.MinLimit), math.Min(a.limit, float64(a.config.MaxLimit)))
+	limit := int(a.limit)
+	a.mu.Unlock()
+
+	if limit != old {
+		a.sem.Resize(int64(limit))
+		if a.config.OnLimitChange != nil {
+			a.config.OnLimitChange(old, limit)
+		}
+	}
+}
+
+// aimd is the additive increase of AIMD, for a request within the target
+// latency. The caller must hold a.mu.
+func (a *AdaptiveConcurrency) aimd(inFlight float64) {
+	// Grow only while the limit is what holds traffic back.
+	if inFlight*2 >= a.limit {
+		a.limit++
+	}
+}
+
+// gradient moves the limit by the ratio of the latency without queueing
+// to recent latency, plus headroom of about the square root of the limit
+// for growth. The latency without queueing is the lowest seen, drifting
+// up slowly so that the baseline follows a service that became slower for
+// good. The caller must hold a.mu.
+func (a *AdaptiveConcurrency) gradient(latency time.Duration, inFlight float64) {
+	rtt := latency.Seconds()
+	if a.minRTT == 0 {
+		a.shortRTT, a.minRTT = rtt, rtt
+		return
+	}
+	a.shortRTT += shortRTTAlpha * (rtt - a.shortRTT)
+	a.minRTT = math.Min(rtt, a.minRTT*(1+minRTTDrift))
+	if inFlight*2 < a.limit {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, gradientTolerance*a.minRTT/a.shortRTT))
+	target := a.limit*gradient + math.Sqrt(a.limit)
+	a.limit = a.limit*(1-gradientSmoothing) + target*gradientSmoothing
+}
+
+// Limit returns the current concurrency limit.
+func (a *AdaptiveConcurrency) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+// InFlight returns the number of slots in use.
+func (a *AdaptiveConcurrency) InFlight() int {
+	return int(a.sem.InUse())
+}
+
+// Stats returns the statistics of the underlying semaphore, whose size is
+// the current limit.
+func (a *AdaptiveConcurrency) Stats() SemaphoreStats {
+	return a.sem.Stats()
+}
+
+// Handler returns a handler that caps the requests in flight in next at
+// the adaptive limit. Requests beyond it get a 503 at once rather than
+// queue, as queueing would add the latency the limit is there to avoid.
+// Requests whose context ends before they complete, or that get a 503 or
+// 504 from next, count as dropped.
+func (a *AdaptiveConcurrency) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done, ok := a.TryAcquire()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			dropped := r.Context().Err() != nil ||
+				sw.status == http.StatusServiceUnavailable || sw.status == http.StatusGatewayTimeout
+			done(!dropped)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader records the status before sending it.
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
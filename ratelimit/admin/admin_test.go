@@ -0,0 +1,211 @@
+package admin_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/admin"
+)
+
+// auditLog collects audit events.
+type auditLog struct {
+	mu     sync.Mutex
+	events []admin.AuditEvent
+}
+
+func (l *auditLog) Record(event admin.AuditEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, event)
+}
+
+// take returns the events recorded since the last call.
+func (l *auditLog) take() []admin.AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := l.events
+	l.events = nil
+	return events
+}
+
+// newServer returns the admin handler of a registry with an "api" policy
+// holding a limiter for key k1, guarded by tokens "ro" and "op".
+func newServer(t *testing.T) (http.Handler, *auditLog) {
+	t.Helper()
+
+	registry := ratelimit.NewRegistry()
+	t.Cleanup(registry.Close)
+	err := registry.Register("api", func() ratelimit.Limiter {
+		return ratelimit.NewFixedWindow(ratelimit.WithRate(10), ratelimit.WithPeriod(time.Hour))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Allow("api", "k1")
+
+	audit := &auditLog{}
+	guard := &admin.Guard{
+		Auth: admin.NewTokenAuth(map[string]admin.Principal{
+			"ro": {Name: "viewer", Role: admin.RoleReadOnly},
+			"op": {Name: "oncall", Role: admin.RoleOperator},
+		}),
+		Audit: audit,
+	}
+	return admin.Handler(registry, guard), audit
+}
+
+func serve(h http.Handler, method, target, token, body string) int {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+// mutations are the requests of the handler that change state, each valid
+// for an operator.
+var mutations = []struct {
+	method, target, body string
+}{
+	{http.MethodPost, "/policies/api/reset?key=k1", ""},
+	{http.MethodPut, "/policies/api/block?key=k1&duration=1m", ""},
+	{http.MethodDelete, "/policies/api/block?key=k1", ""},
+	{http.MethodPut, "/policies/api/exempt?key=k1&duration=1m", ""},
+	{http.MethodDelete, "/policies/api/exempt?key=k1", ""},
+	{http.MethodPut, "/policies/api/limit", `{"rate": 5, "period": "1s"}`},
+}
+
+func TestHandlerRequiresRole(t *testing.T) {
+	h, _ := newServer(t)
+
+	reads := []string{"/policies", "/policies/api/keys", "/policies/api/key?key=k1", "/policies/api/blocks", "/policies/api/exempts"}
+	for _, target := range reads {
+		if got := serve(h, http.MethodGet, target, "", ""); got != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token = %d, want %d", target, got, http.StatusUnauthorized)
+		}
+		if got := serve(h, http.MethodGet, target, "wrong", ""); got != http.StatusUnauthorized {
+			t.Errorf("GET %s with an unknown token = %d, want %d", target, got, http.StatusUnauthorized)
+		}
+		if got := serve(h, http.MethodGet, target, "ro", ""); got != http.StatusOK {
+			t.Errorf("GET %s as read-only = %d, want %d", target, got, http.StatusOK)
+		}
+	}
+
+	for _, m := range mutations {
+		if got := serve(h, m.method, m.target, "", m.body); got != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token = %d, want %d", m.method, m.target, got, http.StatusUnauthorized)
+		}
+		if got := serve(h, m.method, m.target, "ro", m.body); got != http.StatusForbidden {
+			t.Errorf("%s %s as read-only = %d, want %d", m.method, m.target, got, http.StatusForbidden)
+		}
+		if got := serve(h, m.method, m.target, "op", m.body); got >= 300 {
+			t.Errorf("%s %s as operator = %d, want success", m.method, m.target, got)
+		}
+	}
+}
+
+func TestHandlerAuditsEveryMutation(t *testing.T) {
+	h, audit := newServer(t)
+
+	for _, m := range mutations {
+		for _, c := range []struct {
+			token, principal, role string
+			status                 int
+		}{
+			{"", "anonymous", "none", http.StatusUnauthorized},
+			{"ro", "viewer", "read-only", http.StatusForbidden},
+			{"op", "oncall", "operator", 0},
+		} {
+			status := serve(h, m.method, m.target, c.token, m.body)
+			events := audit.take()
+			if len(events) != 1 {
+				t.Errorf("%s %s as %s: %d audit events, want 1", m.method, m.target, c.principal, len(events))
+				continue
+			}
+			e := events[0]
+			if e.Principal != c.principal || e.Role != c.role || e.Method != m.method || e.Status != status {
+				t.Errorf("%s %s as %s: audit event %+v, want principal %s, role %s, status %d", m.method, m.target, c.principal, e, c.principal, c.role, status)
+			}
+			if c.status != 0 && status != c.status {
+				t.Errorf("%s %s as %s = %d, want %d", m.method, m.target, c.principal, status, c.status)
+			}
+			if path, query, _ := strings.Cut(m.target, "?"); e.Path != path || e.Query != query {
+				t.Errorf("%s %s: audit event for %s?%s", m.method, m.target, e.Path, e.Query)
+			}
+		}
+	}
+
+	serve(h, http.MethodGet, "/policies/api/keys", "op", "")
+	if events := audit.take(); len(events) != 0 {
+		t.Errorf("GET recorded %d audit events, want 0", len(events))
+	}
+}
+
+func TestCertAuthMapsCommonNameToRole(t *testing.T) {
+	auth := admin.NewCertAuth(map[string]admin.Role{
+		"deployer": admin.RoleOperator,
+		"grafana":  admin.RoleReadOnly,
+	})
+	request := func(cn string, verified bool) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return r
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want admin.Principal
+		ok   bool
+	}{
+		{"operator", request("deployer", true), admin.Principal{Name: "cert:deployer", Role: admin.RoleOperator}, true},
+		{"read-only", request("grafana", true), admin.Principal{Name: "cert:grafana", Role: admin.RoleReadOnly}, true},
+		{"unknown name", request("intruder", true), admin.Principal{}, false},
+		{"unverified", request("deployer", false), admin.Principal{}, false},
+		{"plain HTTP", httptest.NewRequest(http.MethodGet, "/", nil), admin.Principal{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := auth.Authenticate(tt.r)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Authenticate = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGuardPassesCertPrincipal(t *testing.T) {
+	guard := &admin.Guard{Auth: admin.AnyAuth(
+		admin.NewTokenAuth(map[string]admin.Principal{"ro": {Name: "viewer", Role: admin.RoleReadOnly}}),
+		admin.NewCertAuth(map[string]admin.Role{"deployer": admin.RoleOperator}),
+	)}
+	var got admin.Principal
+	h := guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = admin.PrincipalFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || got.Name != "cert:deployer" {
+		t.Errorf("status %d, principal %+v, want 200 and cert:deployer", w.Code, got)
+	}
+}
@@ -0,0 +1,140 @@
+// Package admin provides the access control layer for administrative
+// endpoints that inspect or change limiter configuration at runtime:
+// bearer-token and mTLS authentication, read-only and operator roles, and
+// an audit trail of every mutation.
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Role is the access level granted to an authenticated principal.
+// Higher roles include the permissions of lower ones.
+type Role int
+
+// Roles, in increasing order of privilege.
+const (
+	RoleNone Role = iota
+	// RoleReadOnly may inspect limiter state and configuration.
+	RoleReadOnly
+	// RoleOperator may additionally mutate state and configuration.
+	RoleOperator
+)
+
+// String returns the role name used in audit records.
+func (r Role) String() string {
+	switch r {
+	case RoleReadOnly:
+		return "read-only"
+	case RoleOperator:
+		return "operator"
+	default:
+		return "none"
+	}
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// Authenticator identifies the caller of an admin request.
+type Authenticator interface {
+	// Authenticate returns the caller and true, or false if the request
+	// carries no valid credentials.
+	Authenticate(r *http.Request) (Principal, bool)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator.
+type AuthenticatorFunc func(r *http.Request) (Principal, bool)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Principal, bool) {
+	return f(r)
+}
+
+// TokenAuth authenticates "Authorization: Bearer <token>" headers against
+// a fixed set of tokens. Tokens are compared in constant time.
+type TokenAuth struct {
+	tokens map[[sha256.Size]byte]Principal
+}
+
+// NewTokenAuth creates a TokenAuth from a token → principal mapping.
+func NewTokenAuth(tokens map[string]Principal) *TokenAuth {
+	ta := &TokenAuth{tokens: make(map[[sha256.Size]byte]Principal, len(tokens))}
+	for token, p := range tokens {
+		ta.tokens[sha256.Sum256([]byte(token))] = p
+	}
+	return ta
+}
+
+// Authenticate implements Authenticator.
+func (ta *TokenAuth) Authenticate(r *http.Request) (Principal, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return Principal{}, false
+	}
+
+	// Hashing first gives fixed-length inputs to the constant-time compare
+	// and keeps the map lookup from leaking token prefixes.
+	sum := sha256.Sum256([]byte(auth[len(prefix):]))
+	for known, p := range ta.tokens {
+		if subtle.ConstantTimeCompare(sum[:], known[:]) == 1 {
+			return p, true
+		}
+	}
+	return Principal{}, false
+}
+
+// CertAuth authenticates TLS client certificates by subject common name.
+// The server must verify client certificates (tls.RequireAndVerifyClientCert
+// or VerifyClientCertIfGiven) for the mapping to be meaningful.
+type CertAuth struct {
+	roles map[string]Role
+}
+
+// NewCertAuth creates a CertAuth from a common name → role mapping.
+func NewCertAuth(roles map[string]Role) *CertAuth {
+	return &CertAuth{roles: roles}
+}
+
+// Authenticate implements Authenticator.
+func (ca *CertAuth) Authenticate(r *http.Request) (Principal, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return Principal{}, false
+	}
+
+	cn := r.TLS.PeerCertificates[0].Subject.CommonName
+	role, ok := ca.roles[cn]
+	if !ok {
+		return Principal{}, false
+	}
+	return Principal{Name: "cert:" + cn, Role: role}, true
+}
+
+// AnyAuth returns an Authenticator that tries each authenticator in turn.
+func AnyAuth(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, bool) {
+		for _, a := range auths {
+			if p, ok := a.Authenticate(r); ok {
+				return p, true
+			}
+		}
+		return Principal{}, false
+	})
+}
+
+// principalKey is the context key for the authenticated principal.
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal of an authorised admin request.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
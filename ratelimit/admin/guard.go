@@ -0,0 +1,155 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditEvent records one mutating admin request.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"`
+	Role       string    `json:"role"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Status     int       `json:"status"`
+}
+
+// AuditSink receives audit events.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to AuditSink.
+type AuditSinkFunc func(event AuditEvent)
+
+// Record calls f(event).
+func (f AuditSinkFunc) Record(event AuditEvent) {
+	f(event)
+}
+
+// JSONAuditSink writes audit events to w as JSON lines.
+type JSONAuditSink struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+// NewJSONAuditSink creates a sink that writes one JSON object per event.
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{enc: json.NewEncoder(w)}
+}
+
+// Record implements AuditSink.
+func (s *JSONAuditSink) Record(event AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enc.Encode(event)
+}
+
+// Guard enforces authentication and role checks on admin handlers and
+// audits every mutation.
+type Guard struct {
+	// Auth identifies callers. Requests it rejects receive 401.
+	Auth Authenticator
+
+	// Audit receives an event for every request that is not GET, HEAD or
+	// OPTIONS, including denied ones. May be nil.
+	Audit AuditSink
+}
+
+// Require wraps h so that only principals with at least role may call it.
+func (g *Guard) Require(role Role, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := Principal{}, false
+		if g.Auth != nil {
+			p, ok = g.Auth.Authenticate(r)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if isMutation(r.Method) {
+				g.record(r, p, rec.status)
+			}
+		}()
+
+		if !ok {
+			rec.Header().Set("WWW-Authenticate", `Bearer realm="ratelimit-admin"`)
+			http.Error(rec, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if p.Role < role {
+			http.Error(rec, "insufficient role", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		h.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// ReadOnly is shorthand for Require(RoleReadOnly, h).
+func (g *Guard) ReadOnly(h http.Handler) http.Handler {
+	return g.Require(RoleReadOnly, h)
+}
+
+// Operator is shorthand for Require(RoleOperator, h).
+func (g *Guard) Operator(h http.Handler) http.Handler {
+	return g.Require(RoleOperator, h)
+}
+
+func (g *Guard) record(r *http.Request, p Principal, status int) {
+	if g.Audit == nil {
+		return
+	}
+
+	name := p.Name
+	if name == "" {
+		name = "anonymous"
+	}
+	g.Audit.Record(AuditEvent{
+		Time:       time.Now(),
+		Principal:  name,
+		Role:       p.Role.String(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		RemoteAddr: r.RemoteAddr,
+		Status:     status,
+	})
+}
+
+// isMutation reports whether method may change server state.
+func isMutation(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,263 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// policyInfo is an entry of GET /policies.
+type policyInfo struct {
+	Name     string                       `json:"name"`
+	Keys     int                          `json:"keys"`
+	Blocked  int                          `json:"blocked"`
+	Exempted int                          `json:"exempted"`
+	Counters ratelimit.MiddlewareCounters `json:"counters"`
+	Limit    *limitBody                   `json:"limit,omitempty"`
+}
+
+// limitBody is the body of GET and PUT /policies/{name}/limit.
+type limitBody struct {
+	Rate   int    `json:"rate"`
+	Period string `json:"period"`
+	Burst  int    `json:"burst,omitempty"`
+}
+
+// keyInfo is the body of GET /policies/{name}/key.
+type keyInfo struct {
+	Key string `json:"key"`
+	ratelimit.KeyDump
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	ExemptUntil  *time.Time `json:"exempt_until,omitempty"`
+}
+
+// Handler serves the live management endpoints of the policies of
+// registry, so that operators can unblock or stop a client without a
+// deploy:
+//
+//	GET    /policies                      policies with their counters
+//	GET    /policies/{name}/keys          keys with a limiter
+//	GET    /policies/{name}/key?key=k     state of key k
+//	POST   /policies/{name}/reset?key=k   reset key k
+//	GET    /policies/{name}/blocks        blocked keys and their ends
+//	PUT    /policies/{name}/block?key=k&duration=d  block key k for d
+//	DELETE /policies/{name}/block?key=k   unblock key k
+//	GET    /policies/{name}/exempts       exempt keys and their ends
+//	PUT    /policies/{name}/exempt?key=k&duration=d  exempt key k for d
+//	DELETE /policies/{name}/exempt?key=k  end the exemption of key k
+//	GET    /policies/{name}/limit         limits set at runtime
+//	PUT    /policies/{name}/limit         set {"rate","period","burst"}
+//
+// Keys are passed as a query parameter as they may contain slashes. A
+// single Middleware is served by registering it in a Registry with
+// RegisterMiddleware. Reads require RoleReadOnly and changes RoleOperator.
+func Handler(registry *ratelimit.Registry, guard *Guard) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/policies", guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := registry.Names()
+		policies := make([]policyInfo, 0, len(names))
+		for _, name := range names {
+			m := registry.Policy(name)
+			if m == nil {
+				continue
+			}
+			info := policyInfo{
+				Name:     name,
+				Keys:     len(m.Keys()),
+				Blocked:  len(m.Blocked()),
+				Exempted: len(m.Exempted()),
+				Counters: m.Counters(),
+			}
+			if limit, ok := m.CurrentLimit(); ok {
+				info.Limit = newLimitBody(limit)
+			}
+			policies = append(policies, info)
+		}
+		writeJSON(w, http.StatusOK, policies)
+	})))
+
+	mux.Handle("/policies/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/policies/")
+		i := strings.LastIndexByte(rest, '/')
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		m := registry.Policy(rest[:i])
+		if m == nil {
+			http.Error(w, "unknown policy", http.StatusNotFound)
+			return
+		}
+
+		switch action := rest[i+1:]; action {
+		case "keys":
+			allow(w, r, guard.ReadOnly, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, m.Keys())
+			})
+		case "key":
+			allow(w, r, guard.ReadOnly, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+				key, ok := keyParam(w, r)
+				if !ok {
+					return
+				}
+				dump, ok := m.Inspect(key)
+				if !ok {
+					http.Error(w, "unknown key", http.StatusNotFound)
+					return
+				}
+				info := keyInfo{Key: key, KeyDump: dump}
+				if until, ok := m.Blocked()[key]; ok {
+					info.BlockedUntil = &until
+				}
+				if until, ok := m.Exempted()[key]; ok {
+					info.ExemptUntil = &until
+				}
+				writeJSON(w, http.StatusOK, info)
+			})
+		case "reset":
+			allow(w, r, guard.Operator, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+				key, ok := keyParam(w, r)
+				if !ok {
+					return
+				}
+				if !m.ResetKey(key) {
+					http.Error(w, "unknown key", http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+		case "blocks":
+			allow(w, r, guard.ReadOnly, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, m.Blocked())
+			})
+		case "block":
+			serveOverride(w, r, guard, m.Block, m.Unblock, m.Blocked, "key not blocked")
+		case "exempts":
+			allow(w, r, guard.ReadOnly, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, m.Exempted())
+			})
+		case "exempt":
+			serveOverride(w, r, guard, m.Exempt, m.Unexempt, m.Exempted, "key not exempt")
+		case "limit":
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					limit, ok := m.CurrentLimit()
+					if !ok {
+						http.Error(w, "no limit set at runtime", http.StatusNotFound)
+						return
+					}
+					writeJSON(w, http.StatusOK, newLimitBody(limit))
+				})).ServeHTTP(w, r)
+			case http.MethodPut:
+				guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var body limitBody
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+						return
+					}
+					limit, err := body.limit()
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					changed := m.SetLimit(limit)
+					writeJSON(w, http.StatusOK, map[string]int{"changed": changed})
+				})).ServeHTTP(w, r)
+			default:
+				w.Header().Set("Allow", "GET, HEAD, PUT")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return mux
+}
+
+// allow serves h behind wrap if r uses method, or HEAD for GET, and
+// answers 405 otherwise.
+func allow(w http.ResponseWriter, r *http.Request, wrap func(http.Handler) http.Handler, method string, h http.HandlerFunc) {
+	if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	wrap(h).ServeHTTP(w, r)
+}
+
+// serveOverride serves PUT and DELETE of a block or exemption, which set
+// and unset change and list returns.
+func serveOverride(w http.ResponseWriter, r *http.Request, guard *Guard, set func(string, time.Duration), unset func(string) bool, list func() map[string]time.Time, notFound string) {
+	switch r.Method {
+	case http.MethodPut:
+		guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := keyParam(w, r)
+			if !ok {
+				return
+			}
+			d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+			set(key, d)
+			writeJSON(w, http.StatusOK, map[string]time.Time{key: list()[key]})
+		})).ServeHTTP(w, r)
+	case http.MethodDelete:
+		guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := keyParam(w, r)
+			if !ok {
+				return
+			}
+			if !unset(key) {
+				http.Error(w, notFound, http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// keyParam returns the key query parameter, answering 400 without one.
+func keyParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
+
+func newLimitBody(limit ratelimit.Limit) *limitBody {
+	return &limitBody{Rate: limit.Rate, Period: limit.Period.String(), Burst: limit.Burst}
+}
+
+// limit validates b.
+func (b limitBody) limit() (ratelimit.Limit, error) {
+	period, err := time.ParseDuration(b.Period)
+	if err != nil {
+		return ratelimit.Limit{}, errors.New("invalid period")
+	}
+	if b.Rate <= 0 || period <= 0 || b.Burst < 0 {
+		return ratelimit.Limit{}, errors.New("rate and period must be positive and burst not negative")
+	}
+	return ratelimit.Limit{Rate: b.Rate, Period: period, Burst: b.Burst}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
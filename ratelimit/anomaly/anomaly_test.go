@@ -0,0 +1,167 @@
+package anomaly_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/anomaly"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+// steady returns n values around 100.
+func steady(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = float64(95 + i%3*5)
+	}
+	return values
+}
+
+func TestDetectors(t *testing.T) {
+	detectors := []struct {
+		name string
+		new  func() anomaly.Detector
+	}{
+		{"MovingStats", func() anomaly.Detector { return anomaly.NewMovingStats(10, 3) }},
+		{"EWMA", func() anomaly.Detector { return anomaly.NewEWMA(0.2, 3) }},
+	}
+	tests := []struct {
+		name      string
+		history   []float64
+		value     float64
+		anomalous bool
+	}{
+		{"spike", steady(20), 300, true},
+		{"small rise", steady(20), 115, false},
+		{"drop", steady(20), 0, false},
+		{"spike before warm-up", steady(2), 300, false},
+		{"quiet series", make([]float64, 20), 3, false},
+	}
+	for _, d := range detectors {
+		for _, tt := range tests {
+			t.Run(d.name+"/"+tt.name, func(t *testing.T) {
+				detector := d.new()
+				for i, v := range tt.history {
+					if _, anomalous := detector.Observe(v); anomalous {
+						t.Fatalf("history value %d (%g) flagged", i, v)
+					}
+				}
+				score, anomalous := detector.Observe(tt.value)
+				if anomalous != tt.anomalous {
+					t.Errorf("Observe(%g) = %.1f, %v, want anomalous %v", tt.value, score, anomalous, tt.anomalous)
+				}
+			})
+		}
+	}
+}
+
+func TestDetectorsAdoptNewLevel(t *testing.T) {
+	for name, detector := range map[string]anomaly.Detector{
+		"MovingStats": anomaly.NewMovingStats(10, 3),
+		"EWMA":        anomaly.NewEWMA(0.2, 3),
+	} {
+		for _, v := range steady(20) {
+			detector.Observe(v)
+		}
+		normal := -1
+		for i := 0; i < 100 && normal < 0; i++ {
+			if _, anomalous := detector.Observe(300); !anomalous {
+				normal = i
+			}
+		}
+		if normal <= 0 {
+			t.Errorf("%s: a lasting level of 300 is normal after %d values, want a spike that is then adopted", name, normal)
+		}
+	}
+}
+
+// threshold flags values above a fixed limit and counts its calls.
+type threshold struct {
+	limit float64
+	calls int
+}
+
+func (d *threshold) Observe(value float64) (float64, bool) {
+	d.calls++
+	return value / d.limit, value > d.limit
+}
+
+func TestMonitorSpike(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	clock := clocktest.NewFakeClock(start)
+	m := anomaly.NewMonitor(&threshold{limit: 10}, &anomaly.Config{
+		Interval: time.Second,
+		Factor:   0.25,
+		Cooldown: 3 * time.Second,
+		Clock:    clock,
+	})
+	var events []anomaly.Event
+	m.Subscribe(func(e anomaly.Event) { events = append(events, e) })
+
+	m.Add(5)
+	clock.Advance(time.Second)
+	if m.Spiking() {
+		t.Fatal("spiking after a normal interval")
+	}
+
+	m.Add(20)
+	clock.Advance(time.Second)
+	if got := m.Multiplier(); got != 0.25 {
+		t.Fatalf("Multiplier after an anomalous interval = %g, want 0.25", got)
+	}
+	want := anomaly.Event{Kind: anomaly.SpikeStarted, Time: start.Add(2 * time.Second), Value: 20, Score: 2, Multiplier: 0.25}
+	if len(events) != 1 || events[0] != want {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+
+	clock.Advance(2 * time.Second)
+	if !m.Spiking() {
+		t.Fatal("spike ended before the cooldown")
+	}
+	clock.Advance(time.Second)
+	if m.Spiking() {
+		t.Fatal("spike lasts beyond the cooldown")
+	}
+	want = anomaly.Event{Kind: anomaly.SpikeEnded, Time: start.Add(5 * time.Second), Multiplier: 1}
+	if len(events) != 2 || events[1] != want {
+		t.Errorf("events = %+v, want %+v last", events, want)
+	}
+}
+
+func TestMonitorBoundsCatchUp(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	d := &threshold{limit: 10}
+	m := anomaly.NewMonitor(d, &anomaly.Config{Interval: time.Millisecond, Clock: clock})
+
+	m.Add(1)
+	clock.Advance(time.Hour)
+	m.Add(1)
+	if d.calls != 1000 {
+		t.Errorf("an hour idle observed %d intervals, want 1000", d.calls)
+	}
+}
+
+func TestAdaptiveTightensDuringSpike(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	d := &threshold{limit: 10}
+	m := anomaly.NewMonitor(d, &anomaly.Config{Interval: time.Second, Factor: 0.5, Clock: clock})
+	a := anomaly.NewAdaptive(m, ratelimit.WithRate(20), ratelimit.WithPeriod(time.Second), ratelimit.WithBurst(20))
+
+	if got := a.Available(); got != 20 {
+		t.Fatalf("Available = %d, want 20", got)
+	}
+	if !a.AllowN(15) {
+		t.Fatal("AllowN(15) rejected")
+	}
+	clock.Advance(time.Second)
+	if !m.Spiking() {
+		t.Fatal("the requests of the limiter did not reach the monitor")
+	}
+	if got := a.Available(); got > 10 {
+		t.Errorf("Available during the spike = %d, want at most the halved burst of 10", got)
+	}
+	if err := a.CheckN(11); err == nil {
+		t.Error("CheckN(11) admitted beyond the halved burst")
+	}
+}
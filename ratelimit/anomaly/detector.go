@@ -0,0 +1,143 @@
+// Package anomaly detects traffic spikes and tightens limits while they
+// last. A Monitor counts requests per interval and feeds the counts to a
+// Detector; when an interval is anomalous it emits an Event to its
+// subscribers and scales the limits of its Adaptive limiters down until
+// traffic has been normal for a cooldown:
+//
+//	monitor := anomaly.NewMonitor(anomaly.NewEWMA(0.1, 4), nil)
+//	monitor.Subscribe(func(e anomaly.Event) {
+//		log.Printf("%s: %.0f requests (score %.1f)", e.Kind, e.Value, e.Score)
+//	})
+//
+//	config := ratelimit.DefaultMiddlewareConfig()
+//	config.LimiterFactory = func() ratelimit.Limiter {
+//		return anomaly.NewAdaptive(monitor, ratelimit.WithRate(100), ratelimit.WithPeriod(time.Minute))
+//	}
+//
+// Adaptive limiters report the requests they see to their Monitor, so all
+// the keys of a Middleware together make up the observed traffic, and all
+// of them tighten during a spike. Other traffic can be reported with
+// Monitor.Add or Monitor.Handler.
+package anomaly
+
+import "math"
+
+// Detector judges a series of observations, such as the number of requests
+// in consecutive intervals.
+type Detector interface {
+	// Observe adds value to the series and returns how far it is above
+	// the values before it, in standard deviations, and whether that
+	// makes it anomalous. Only values above normal are anomalous.
+	Observe(value float64) (score float64, anomalous bool)
+}
+
+// deviation returns the standard deviation used to score against a series
+// with mean and variance. It is never below the square root of the mean,
+// the natural noise of independent arrivals, so a steady or quiet series
+// is not flagged for small changes.
+func deviation(mean, variance float64) float64 {
+	return math.Max(math.Sqrt(math.Max(variance, 0)), math.Sqrt(math.Max(mean, 1)))
+}
+
+// MovingStats is a Detector that scores a value by the mean and standard
+// deviation of the last window values (a z-score). It flags nothing until
+// the window is full. Anomalous values join the window clipped to the
+// threshold, so a short spike stays anomalous while it lasts, but a
+// lasting change of level becomes the new normal after a few windows.
+//
+// MovingStats is not safe for concurrent use; a Monitor serializes its
+// calls.
+type MovingStats struct {
+	values    []float64
+	next      int
+	full      bool
+	threshold float64
+}
+
+// NewMovingStats returns a detector over the last window values that flags
+// values more than threshold standard deviations above their mean.
+func NewMovingStats(window int, threshold float64) *MovingStats {
+	if window < 2 {
+		window = 2
+	}
+	return &MovingStats{values: make([]float64, window), threshold: threshold}
+}
+
+// Observe implements Detector.
+func (s *MovingStats) Observe(value float64) (float64, bool) {
+	var score float64
+	anomalous := false
+	stored := value
+	if s.full {
+		var sum, sq float64
+		for _, v := range s.values {
+			sum += v
+		}
+		mean := sum / float64(len(s.values))
+		for _, v := range s.values {
+			sq += (v - mean) * (v - mean)
+		}
+		dev := deviation(mean, sq/float64(len(s.values)-1))
+		score = (value - mean) / dev
+		if score > s.threshold {
+			anomalous = true
+			stored = mean + s.threshold*dev
+		}
+	}
+
+	s.values[s.next] = stored
+	s.next = (s.next + 1) % len(s.values)
+	if s.next == 0 {
+		s.full = true
+	}
+	return score, anomalous
+}
+
+// EWMA is a Detector that scores a value by an exponentially weighted
+// moving mean and variance, so recent values count most and no window has
+// to be kept. It flags nothing until it has seen about 1/alpha values.
+// Anomalous values update the averages clipped to the threshold, as with
+// MovingStats.
+//
+// EWMA is not safe for concurrent use; a Monitor serializes its calls.
+type EWMA struct {
+	alpha     float64
+	threshold float64
+	mean      float64
+	variance  float64
+	seen      int
+	warmup    int
+}
+
+// NewEWMA returns a detector with smoothing factor alpha, between 0 and 1,
+// that flags values more than threshold standard deviations above the
+// mean. A smaller alpha remembers longer.
+func NewEWMA(alpha, threshold float64) *EWMA {
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.1
+	}
+	return &EWMA{alpha: alpha, threshold: threshold, warmup: int(math.Ceil(1 / alpha))}
+}
+
+// Observe implements Detector.
+func (e *EWMA) Observe(value float64) (float64, bool) {
+	if e.seen == 0 {
+		e.mean = value
+		e.seen++
+		return 0, false
+	}
+
+	dev := deviation(e.mean, e.variance)
+	score := (value - e.mean) / dev
+	anomalous := e.seen >= e.warmup && score > e.threshold
+	if anomalous {
+		value = e.mean + e.threshold*dev
+	}
+
+	diff := value - e.mean
+	incr := e.alpha * diff
+	e.mean += incr
+	e.variance = (1 - e.alpha) * (e.variance + diff*incr)
+	e.seen++
+	return score, anomalous
+}
@@ -0,0 +1,305 @@
+package anomaly
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Config configures a Monitor. Zero fields take their defaults.
+type Config struct {
+	// Interval is the length of the intervals whose request counts are
+	// observed. Defaults to one second.
+	Interval time.Duration
+
+	// Factor scales the limits of Adaptive limiters during a spike.
+	// Defaults to 0.5; values above 1 are treated as 1.
+	Factor float64
+
+	// Cooldown is how long traffic must stay normal after the last
+	// anomalous interval for a spike to end. Defaults to ten intervals.
+	Cooldown time.Duration
+
+	// Clock is the time source. Defaults to ratelimit.SystemClock.
+	Clock ratelimit.Clock
+}
+
+// EventKind tells what an Event reports.
+type EventKind int
+
+const (
+	// SpikeStarted reports the first anomalous interval after normal
+	// traffic; Adaptive limiters tighten from then on.
+	SpikeStarted EventKind = iota + 1
+
+	// SpikeEnded reports that traffic has been normal for the cooldown;
+	// Adaptive limiters return to their limits.
+	SpikeEnded
+)
+
+// String returns "spike started" or "spike ended".
+func (k EventKind) String() string {
+	switch k {
+	case SpikeStarted:
+		return "spike started"
+	case SpikeEnded:
+		return "spike ended"
+	}
+	return "unknown"
+}
+
+// Event reports the start or end of a spike.
+type Event struct {
+	Kind EventKind
+
+	// Time is the end of the interval that caused the event.
+	Time time.Time
+
+	// Value and Score are the request count of that interval and its
+	// score from the Detector.
+	Value float64
+	Score float64
+
+	// Multiplier is the multiplier of Adaptive limiters from now on.
+	Multiplier float64
+}
+
+// maxCatchUp bounds the empty intervals observed after a quiet period, so
+// the first request after a long idle time does not replay all of it.
+const maxCatchUp = 1000
+
+// Monitor counts requests per interval, watches the counts with a Detector
+// and reports spikes. It needs no goroutine: intervals are closed by the
+// first call after they end, so events are emitted from Add, Multiplier
+// or the calls of Adaptive limiters. A Monitor is safe for concurrent use.
+type Monitor struct {
+	detector Detector
+	interval time.Duration
+	factor   float64
+	cooldown time.Duration
+	clock    ratelimit.Clock
+
+	mu          sync.Mutex
+	start       time.Time // of the current interval; zero before the first call
+	count       float64
+	spike       bool
+	lastAnomaly time.Time
+
+	subMu       sync.RWMutex
+	subscribers []func(Event)
+}
+
+// NewMonitor returns a monitor that judges traffic with d. config may be
+// nil for the defaults.
+func NewMonitor(d Detector, config *Config) *Monitor {
+	m := &Monitor{detector: d, interval: time.Second, factor: 0.5, clock: ratelimit.SystemClock{}}
+	if config != nil {
+		if config.Interval > 0 {
+			m.interval = config.Interval
+		}
+		if config.Factor > 0 {
+			m.factor = math.Min(config.Factor, 1)
+		}
+		m.cooldown = config.Cooldown
+		if config.Clock != nil {
+			m.clock = config.Clock
+		}
+	}
+	if m.cooldown <= 0 {
+		m.cooldown = 10 * m.interval
+	}
+	return m
+}
+
+// Subscribe calls fn with every event from now on. fn is called
+// synchronously by the call that closed the interval, so it should return
+// quickly.
+func (m *Monitor) Subscribe(fn func(Event)) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Add records n requests in the current interval.
+func (m *Monitor) Add(n int) {
+	m.mu.Lock()
+	events := m.roll(m.clock.Now())
+	m.count += float64(n)
+	m.mu.Unlock()
+
+	m.emit(events)
+}
+
+// Multiplier returns the multiplier of Adaptive limiters now: 1, or the
+// configured Factor during a spike.
+func (m *Monitor) Multiplier() float64 {
+	m.mu.Lock()
+	events := m.roll(m.clock.Now())
+	multiplier := m.multiplier()
+	m.mu.Unlock()
+
+	m.emit(events)
+	return multiplier
+}
+
+// Spiking reports whether a spike is in progress.
+func (m *Monitor) Spiking() bool {
+	return m.Multiplier() != 1
+}
+
+// multiplier returns the multiplier in force. The caller must hold m.mu.
+func (m *Monitor) multiplier() float64 {
+	if m.spike {
+		return m.factor
+	}
+	return 1
+}
+
+// roll closes the intervals that ended by now, observing their counts,
+// and returns the events they caused. The caller must hold m.mu.
+func (m *Monitor) roll(now time.Time) []Event {
+	if m.start.IsZero() {
+		m.start = now
+		return nil
+	}
+	ended := int(now.Sub(m.start) / m.interval)
+	if ended <= 0 {
+		return nil
+	}
+
+	var events []Event
+	skipped := max(ended-maxCatchUp, 0)
+	for i := skipped; i < ended; i++ {
+		end := m.start.Add(time.Duration(i+1) * m.interval)
+		value := m.count
+		m.count = 0
+
+		score, anomalous := m.detector.Observe(value)
+		switch {
+		case anomalous:
+			m.lastAnomaly = end
+			if !m.spike {
+				m.spike = true
+				events = append(events, Event{Kind: SpikeStarted, Time: end, Value: value, Score: score, Multiplier: m.factor})
+			}
+		case m.spike && end.Sub(m.lastAnomaly) >= m.cooldown:
+			m.spike = false
+			events = append(events, Event{Kind: SpikeEnded, Time: end, Value: value, Score: score, Multiplier: 1})
+		}
+	}
+	m.start = m.start.Add(time.Duration(ended) * m.interval)
+	return events
+}
+
+// emit passes events to the subscribers.
+func (m *Monitor) emit(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+
+	for _, e := range events {
+		for _, fn := range m.subscribers {
+			fn(e)
+		}
+	}
+}
+
+// Handler returns a handler that records every request before passing it
+// to next, for traffic that does not go through Adaptive limiters.
+func (m *Monitor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Adaptive is a token bucket whose limits are scaled by the multiplier of
+// its Monitor, so it tightens while a spike lasts, and which reports the
+// requests it sees to the Monitor. The multiplier is checked on every
+// call, and a change keeps the bucket's state (see ratelimit.Scaled).
+type Adaptive struct {
+	monitor *Monitor
+	scaled  *ratelimit.Scaled
+}
+
+// NewAdaptive returns a token bucket configured by opts, as
+// ratelimit.NewTokenBucket, that follows m. It uses the clock of m unless
+// opts set one.
+func NewAdaptive(m *Monitor, opts ...ratelimit.Option) *Adaptive {
+	opts = append([]ratelimit.Option{ratelimit.WithClock(m.clock)}, opts...)
+	return &Adaptive{
+		monitor: m,
+		scaled:  ratelimit.NewScaled(ratelimit.NewTokenBucket(opts...), m.Multiplier),
+	}
+}
+
+// Allow checks if a single request can proceed.
+func (a *Adaptive) Allow() bool {
+	return a.AllowN(1)
+}
+
+// AllowN records n requests with the monitor and checks if they can
+// proceed at the current limits.
+func (a *Adaptive) AllowN(n int) bool {
+	a.monitor.Add(n)
+	return a.scaled.AllowN(n)
+}
+
+// Wait blocks until a request can proceed or context is cancelled.
+func (a *Adaptive) Wait(ctx context.Context) error {
+	return a.WaitN(ctx, 1)
+}
+
+// WaitN records n requests with the monitor and blocks until they can
+// proceed at the current limits or context is cancelled.
+func (a *Adaptive) WaitN(ctx context.Context, n int) error {
+	a.monitor.Add(n)
+	return a.scaled.WaitN(ctx, n)
+}
+
+// Check returns nil if a single request would be admitted now, otherwise
+// an *ratelimit.ErrLimited describing when to retry.
+func (a *Adaptive) Check() error {
+	return a.CheckN(1)
+}
+
+// CheckN returns nil if n requests would be admitted now, otherwise an
+// *ratelimit.ErrLimited describing when to retry. It records nothing with
+// the monitor.
+func (a *Adaptive) CheckN(n int) error {
+	return a.scaled.CheckN(n)
+}
+
+// Reset resets the bucket. The monitor is unaffected.
+func (a *Adaptive) Reset() {
+	a.scaled.Reset()
+}
+
+// Available returns the number of requests the bucket would admit now.
+func (a *Adaptive) Available() int {
+	return a.scaled.Available()
+}
+
+// Reconfigure changes the unscaled limits, which the monitor keeps
+// scaling.
+func (a *Adaptive) Reconfigure(rate int, period time.Duration, burst int) {
+	a.scaled.Reconfigure(rate, period, burst)
+}
+
+// Snapshot encodes the state of the bucket.
+func (a *Adaptive) Snapshot() ([]byte, error) {
+	return a.scaled.Snapshot()
+}
+
+// Restore replaces the state of the bucket with a snapshot.
+func (a *Adaptive) Restore(data []byte) error {
+	return a.scaled.Restore(data)
+}
@@ -0,0 +1,264 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// streamChunk is the most Reader and Writer pass through per WaitN, so a
+// transfer is paced smoothly rather than in bursts of the full burst size.
+const streamChunk = 32 * 1024
+
+// WithBytesPerSecond configures a limiter to throttle throughput instead
+// of counting requests: one token is one byte, AllowN and WaitN take n
+// bytes, and the burst is one second of transfer unless WithBurst follows.
+// Use it with TokenBucket, FixedWindow or SlidingWindow, directly with
+// AllowN(len(payload)) or through NewReader and NewWriter.
+func WithBytesPerSecond(bytes int) Option {
+	return func(c *Config) {
+		c.Rate = bytes
+		c.Period = time.Second
+		c.Burst = bytes
+	}
+}
+
+// Burster is implemented by limiters that can tell the most tokens a
+// single AllowN or WaitN may ask for, such as the burst size of a
+// TokenBucket or the rate of a window. Reader, Writer and Conn use it to
+// size their chunks, so wrappers should implement it by asking the
+// limiter they wrap.
+type Burster interface {
+	// Burst returns the most tokens one call may take under the limits
+	// in force now.
+	Burst() int
+}
+
+// Burst returns the burst size.
+func (tb *TokenBucket) Burst() int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.config.Burst
+}
+
+// Burst returns the rate, the most one window admits.
+func (fw *FixedWindow) Burst() int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.config.Rate
+}
+
+// Burst returns the rate, the most one window admits.
+func (sw *SlidingWindow) Burst() int {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.config.Rate
+}
+
+// Burst returns the rate, the most one window admits for any key.
+func (sl *SlidingLog) Burst() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.config.Rate
+}
+
+// Burst returns the rate of the sliding log.
+func (k *slidingLogKey) Burst() int { return k.log.Burst() }
+
+// Burst returns the burst of the base limiter at the scaled limits.
+func (s *Scaled) Burst() int {
+	s.apply()
+	return s.base.(Burster).Burst()
+}
+
+// Burst returns the burst of the base limiter at the scaled limits.
+func (s *Scheduled) Burst() int {
+	return s.scaled.Burst()
+}
+
+// Reader is an io.Reader whose reads are throttled by a Limiter, one
+// token per byte.
+type Reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter Limiter
+}
+
+// NewReader returns a Reader that reads from r no faster than limiter
+// admits. A limiter shared by several Readers and Writers limits their
+// combined throughput. Reads fail with ctx's error once it is done.
+func NewReader(ctx context.Context, r io.Reader, limiter Limiter) *Reader {
+	return &Reader{ctx: ctx, r: r, limiter: limiter}
+}
+
+// Read reads up to one chunk from the underlying reader, then waits until
+// the limiter admits the bytes read.
+func (r *Reader) Read(p []byte) (int, error) {
+	if chunk := chunkSize(r.limiter); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Writer is an io.Writer whose writes are throttled by a Limiter, one
+// token per byte.
+type Writer struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter Limiter
+}
+
+// NewWriter returns a Writer that writes to w no faster than limiter
+// admits. A limiter shared by several Readers and Writers limits their
+// combined throughput. Writes fail with ctx's error once it is done.
+func NewWriter(ctx context.Context, w io.Writer, limiter Limiter) *Writer {
+	return &Writer{ctx: ctx, w: w, limiter: limiter}
+}
+
+// Write writes p to the underlying writer a chunk at a time, each once
+// the limiter admits it.
+func (w *Writer) Write(p []byte) (int, error) {
+	return writeChunks(w.ctx, w.w, p, w.limiter)
+}
+
+// writeChunks writes p to w a chunk at a time, each once limiter admits
+// it. The chunk size is read again for every chunk, as the limits may
+// change during a long write.
+func writeChunks(ctx context.Context, w io.Writer, p []byte, limiter Limiter) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if chunk := chunkSize(limiter); n > chunk {
+			n = chunk
+		}
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CopyWithLimit copies from src to dst until EOF or an error, like
+// io.Copy, no faster than limiter admits, one token per byte. It returns
+// the number of bytes copied.
+func CopyWithLimit(dst io.Writer, src io.Reader, limiter Limiter) (int64, error) {
+	return io.Copy(dst, NewReader(context.Background(), src, limiter))
+}
+
+// Conn is a net.Conn whose reads and writes are throttled by Limiters, one
+// token per byte.
+type Conn struct {
+	net.Conn
+	read, write   Limiter
+	writeDeadline atomic.Int64 // Unix nanoseconds, 0 for none
+	closed        context.Context
+	close         context.CancelFunc
+}
+
+// NewConn returns conn with its reads throttled by read and its writes by
+// write; a nil Limiter leaves that direction unthrottled. Give each
+// connection its own limiters to limit connections one by one, or share
+// them to limit connections together.
+func NewConn(conn net.Conn, read, write Limiter) *Conn {
+	c := &Conn{Conn: conn, read: read, write: write}
+	c.closed, c.close = context.WithCancel(context.Background())
+	return c
+}
+
+// Read reads up to one chunk, then waits until the read limiter admits
+// the bytes read. As they have left the connection already, the wait does
+// not end at the read deadline, which it may overrun by the time one
+// chunk takes; it ends when the connection is closed.
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(p)
+	}
+	if chunk := chunkSize(c.read); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if werr := c.read.WaitN(c.closed, n); werr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+// Write writes p a chunk at a time, each once the write limiter admits
+// it. Waits end at the write deadline with os.ErrDeadlineExceeded, as the
+// connection's own writes do.
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(p)
+	}
+	ctx := c.closed
+	if d := c.writeDeadline.Load(); d != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, d))
+		defer cancel()
+	}
+	n, err := writeChunks(ctx, c.Conn, p, c.write)
+	switch {
+	case err == nil:
+	case c.closed.Err() != nil:
+		err = net.ErrClosed
+	case errors.Is(err, context.DeadlineExceeded):
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.setWriteDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.setWriteDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) setWriteDeadline(t time.Time) {
+	var d int64
+	if !t.IsZero() {
+		d = t.UnixNano()
+	}
+	c.writeDeadline.Store(d)
+}
+
+// Close closes the connection and ends its waits.
+func (c *Conn) Close() error {
+	c.close()
+	return c.Conn.Close()
+}
+
+// chunkSize returns the most bytes to pass through limiter per WaitN:
+// streamChunk, or less if the limiter implements Burster and cannot admit
+// that much at once.
+func chunkSize(limiter Limiter) int {
+	if b, ok := limiter.(Burster); ok {
+		if most := b.Burst(); most > 0 && most < streamChunk {
+			return most
+		}
+	}
+	return streamChunk
+}
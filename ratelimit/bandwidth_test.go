@@ -0,0 +1,86 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+// runUnderClock runs fn, advancing clock whenever fn blocks on it.
+func runUnderClock(t *testing.T, clock *clocktest.FakeClock, fn func() error) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		default:
+		}
+		if clock.Waiters() > 0 {
+			clock.Advance(time.Second)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestReaderWrappedLimiter(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	limiter := ratelimit.NewScaled(
+		ratelimit.NewTokenBucket(ratelimit.WithBytesPerSecond(4096), ratelimit.WithClock(clock)),
+		func() float64 { return 1 },
+	)
+
+	payload := bytes.Repeat([]byte("x"), 10000)
+	var dst bytes.Buffer
+	r := ratelimit.NewReader(context.Background(), bytes.NewReader(payload), limiter)
+	runUnderClock(t, clock, func() error {
+		_, err := io.Copy(&dst, r)
+		return err
+	})
+	if dst.Len() != len(payload) {
+		t.Fatalf("copied %d bytes, want %d", dst.Len(), len(payload))
+	}
+}
+
+func TestWriterFollowsSmallerBurst(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	multiplier := 1.0
+	limiter := ratelimit.NewScaled(
+		ratelimit.NewTokenBucket(ratelimit.WithBytesPerSecond(4096), ratelimit.WithClock(clock)),
+		func() float64 { return multiplier },
+	)
+	w := ratelimit.NewWriter(context.Background(), io.Discard, limiter)
+
+	// Halving the limits after the Writer was created halves its chunks
+	multiplier = 0.5
+	runUnderClock(t, clock, func() error {
+		_, err := w.Write(make([]byte, 4096))
+		return err
+	})
+}
+
+func TestReaderAfterReconfigure(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	tb := ratelimit.NewTokenBucket(ratelimit.WithBytesPerSecond(1<<20), ratelimit.WithClock(clock))
+	r := ratelimit.NewReader(context.Background(), bytes.NewReader(make([]byte, 8192)), tb)
+
+	tb.Reconfigure(1024, time.Second, 0)
+	var dst bytes.Buffer
+	runUnderClock(t, clock, func() error {
+		_, err := io.Copy(&dst, r)
+		return err
+	})
+	if dst.Len() != 8192 {
+		t.Fatalf("copied %d bytes, want 8192", dst.Len())
+	}
+}
@@ -0,0 +1,85 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// benchLimiters returns the core limiters by name, with a limit they never
+// reach so that every call takes the admitting path. The period is short
+// so that warmUp fills the windows to their working set and the benchmarks
+// measure the steady state rather than the buffers growing.
+func benchLimiters() []struct {
+	name string
+	new  func() ratelimit.Limiter
+} {
+	opts := []ratelimit.Option{ratelimit.WithRate(1 << 30), ratelimit.WithPeriod(time.Millisecond)}
+	return []struct {
+		name string
+		new  func() ratelimit.Limiter
+	}{
+		{"TokenBucket", func() ratelimit.Limiter { return ratelimit.NewTokenBucket(opts...) }},
+		{"FixedWindow", func() ratelimit.Limiter { return ratelimit.NewFixedWindow(opts...) }},
+		{"SlidingWindow", func() ratelimit.Limiter { return ratelimit.NewSlidingWindow(opts...) }},
+		{"SlidingLog", func() ratelimit.Limiter { return ratelimit.NewSlidingLog(opts...) }},
+	}
+}
+
+// warmUp calls limiter for a few periods, so that the buffers of the
+// windows have grown to hold a period of calls.
+func warmUp(limiter ratelimit.Limiter) {
+	for end := time.Now().Add(5 * time.Millisecond); time.Now().Before(end); {
+		limiter.AllowN(2)
+	}
+}
+
+// BenchmarkAllowParallel measures Allow on a limiter shared by GOMAXPROCS
+// goroutines.
+func BenchmarkAllowParallel(b *testing.B) {
+	for _, l := range benchLimiters() {
+		b.Run(l.name, func(b *testing.B) {
+			limiter := l.new()
+			warmUp(limiter)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					limiter.Allow()
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkAllowNParallel measures AllowN(2) on a limiter shared by
+// GOMAXPROCS goroutines.
+func BenchmarkAllowNParallel(b *testing.B) {
+	for _, l := range benchLimiters() {
+		b.Run(l.name, func(b *testing.B) {
+			limiter := l.new()
+			warmUp(limiter)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					limiter.AllowN(2)
+				}
+			})
+		})
+	}
+}
+
+func TestAllowDoesNotAllocate(t *testing.T) {
+	for _, l := range benchLimiters() {
+		limiter := l.new()
+		warmUp(limiter)
+		if n := testing.AllocsPerRun(1000, func() { limiter.Allow() }); n > 0 {
+			t.Errorf("%s: Allow allocates %v times, want 0", l.name, n)
+		}
+		if n := testing.AllocsPerRun(1000, func() { limiter.AllowN(2) }); n > 0 {
+			t.Errorf("%s: AllowN allocates %v times, want 0", l.name, n)
+		}
+	}
+}
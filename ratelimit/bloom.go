@@ -0,0 +1,105 @@
+package ratelimit
+
+import "math"
+
+// DefaultFalsePositiveRate is the false positive rate of Bloom filters
+// sized without one.
+const DefaultFalsePositiveRate = 0.01
+
+// BloomFilter is a set of keys that answers membership in a fixed amount
+// of memory. It never forgets a key it holds, but may report a key it
+// never held, with a probability that grows as keys are added. It is not
+// safe for concurrent use.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    int    // number of hashes
+	set  uint64 // bits that are set
+}
+
+// NewBloomFilter returns a filter sized to hold n keys with a false
+// positive rate of fpRate, between 0 and 1 exclusive; other rates mean
+// DefaultFalsePositiveRate.
+func NewBloomFilter(n int, fpRate float64) *BloomFilter {
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultFalsePositiveRate
+	}
+	n = max(n, 1)
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := max(int(math.Round(float64(m)/float64(n)*math.Ln2)), 1)
+
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// indexes calls fn with the k bit positions of key, derived from one
+// 64-bit hash by double hashing.
+func (b *BloomFilter) indexes(key string, fn func(i uint64) bool) {
+	x := hashKey(key)
+	h1, h2 := x&0xffffffff, x>>32|1
+	for i := 0; i < b.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % b.m) {
+			return
+		}
+	}
+}
+
+// Add adds key and reports whether it was new, that is whether the filter
+// did not already appear to hold it.
+func (b *BloomFilter) Add(key string) bool {
+	added := false
+	b.indexes(key, func(i uint64) bool {
+		word, mask := i/64, uint64(1)<<(i%64)
+		if b.bits[word]&mask == 0 {
+			b.bits[word] |= mask
+			b.set++
+			added = true
+		}
+		return true
+	})
+	return added
+}
+
+// Contains reports whether the filter appears to hold key. False
+// positives occur at about FalsePositiveRate.
+func (b *BloomFilter) Contains(key string) bool {
+	found := true
+	b.indexes(key, func(i uint64) bool {
+		found = b.bits[i/64]&(1<<(i%64)) != 0
+		return found
+	})
+	return found
+}
+
+// Saturation returns the fraction of bits set, from 0 when empty to 1
+// when every key would be reported present. A filter holding as many keys
+// as it was sized for is about half saturated.
+func (b *BloomFilter) Saturation() float64 {
+	return float64(b.set) / float64(b.m)
+}
+
+// FalsePositiveRate returns the probability that Contains reports a key
+// that was never added, given the keys added so far.
+func (b *BloomFilter) FalsePositiveRate() float64 {
+	return math.Pow(b.Saturation(), float64(b.k))
+}
+
+// Count returns the estimated number of distinct keys added.
+func (b *BloomFilter) Count() int {
+	if b.set >= b.m {
+		return math.MaxInt
+	}
+	m := float64(b.m)
+	return int(math.Round(-m / float64(b.k) * math.Log(1-float64(b.set)/m)))
+}
+
+// Reset empties the filter.
+func (b *BloomFilter) Reset() {
+	clear(b.bits)
+	b.set = 0
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownCompartment is returned by Bulkhead for a name that was not
+// added.
+var ErrUnknownCompartment = errors.New("ratelimit: unknown compartment")
+
+// Bulkhead isolates dependencies or kinds of work from each other by
+// giving each a compartment with its own concurrency limit, so a slow
+// dependency can tie up only the slots of its own compartment. Each
+// compartment is a Semaphore.
+type Bulkhead struct {
+	mu           sync.RWMutex
+	compartments map[string]*Semaphore
+}
+
+// NewBulkhead returns a bulkhead without compartments.
+func NewBulkhead() *Bulkhead {
+	return &Bulkhead{compartments: make(map[string]*Semaphore)}
+}
+
+// Add adds a compartment of size concurrent units, or resizes it if it
+// exists. Add returns b so compartments can be chained.
+func (b *Bulkhead) Add(name string, size int64) *Bulkhead {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.compartments[name]; ok {
+		s.Resize(size)
+		return b
+	}
+	b.compartments[name] = NewSemaphore(size)
+	return b
+}
+
+// Compartment returns the semaphore of a compartment, or nil if there is
+// none.
+func (b *Bulkhead) Compartment(name string) *Semaphore {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.compartments[name]
+}
+
+// Acquire acquires one unit of a compartment, blocking until it is
+// available or ctx is done.
+func (b *Bulkhead) Acquire(ctx context.Context, name string) error {
+	s := b.Compartment(name)
+	if s == nil {
+		return ErrUnknownCompartment
+	}
+	return s.Acquire(ctx, 1)
+}
+
+// TryAcquire acquires one unit of a compartment without blocking and
+// reports whether it did. It reports false for unknown compartments.
+func (b *Bulkhead) TryAcquire(name string) bool {
+	s := b.Compartment(name)
+	return s != nil && s.TryAcquire(1)
+}
+
+// Release releases one unit of a compartment.
+func (b *Bulkhead) Release(name string) {
+	if s := b.Compartment(name); s != nil {
+		s.Release(1)
+	}
+}
+
+// Do runs fn in a compartment once a unit is acquired, and releases it
+// when fn returns.
+func (b *Bulkhead) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	if err := b.Acquire(ctx, name); err != nil {
+		return err
+	}
+	defer b.Release(name)
+	return fn(ctx)
+}
+
+// Handler returns a handler that serves requests in a compartment. A
+// request that cannot get a unit before its context ends, or at once if
+// the compartment is unknown, gets a 503.
+func (b *Bulkhead) Handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := b.Acquire(r.Context(), name); err != nil {
+			http.Error(w, "Service overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		defer b.Release(name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Names returns the names of the compartments, sorted.
+func (b *Bulkhead) Names() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names := make([]string, 0, len(b.compartments))
+	for name := range b.compartments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns the statistics of every compartment by name.
+func (b *Bulkhead) Stats() map[string]SemaphoreStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make(map[string]SemaphoreStats, len(b.compartments))
+	for name, s := range b.compartments {
+		stats[name] = s.Stats()
+	}
+	return stats
+}
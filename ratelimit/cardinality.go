@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Cardinality limits the number of distinct keys, such as users or client
+// addresses, seen per window rather than the requests of each key. It
+// catches abuse where every key stays far below its own limit but the
+// number of keys explodes, like credential stuffing from a botnet or
+// enumeration of user IDs.
+//
+// Keys are counted with a HyperLogLog, so memory is fixed whatever their
+// number and the count is an estimate. Once the limit is reached, keys
+// already seen in the current window keep being allowed and new ones are
+// rejected. Like SlidingWindow, the count blends the previous window into
+// the current one, weighting the keys seen only in the previous window by
+// the part of it still inside the sliding period.
+type Cardinality struct {
+	config *Config
+	limit  int
+
+	mu          sync.Mutex
+	current     *HyperLogLog
+	union       *HyperLogLog // current and previous windows
+	windowStart time.Time
+	stats       CardinalityStats
+}
+
+// CardinalityStats describes a Cardinality limiter. The counters run since
+// it was created or last reset.
+type CardinalityStats struct {
+	Limit int `json:"limit"`
+
+	// Keys is the estimated number of distinct keys in the sliding window.
+	Keys int `json:"keys"`
+
+	Allowed  int64 `json:"allowed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// NewCardinality creates a limiter allowing up to limit distinct keys per
+// Config.Period. Of the options, Period sets the window, Precision the
+// accuracy of the HyperLogLog sketches and Clock the time source.
+func NewCardinality(limit int, opts ...Option) *Cardinality {
+	cfg := NewConfig(opts...)
+	if cfg.Period <= 0 {
+		cfg.Period = time.Second
+	}
+
+	return &Cardinality{
+		config:      cfg,
+		limit:       limit,
+		current:     NewHyperLogLog(cfg.Precision),
+		union:       NewHyperLogLog(cfg.Precision),
+		windowStart: cfg.Clock.Now(),
+		stats:       CardinalityStats{Limit: limit},
+	}
+}
+
+// AllowKey records key and reports whether it is allowed: always if it was
+// already seen in the current window, otherwise only while the estimated
+// number of distinct keys is below the limit.
+func (c *Cardinality) AllowKey(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.config.Clock.Now()
+	c.rotate(now)
+
+	if c.current.Changes(key) && c.count(now) >= float64(c.limit) {
+		c.stats.Rejected++
+		return false
+	}
+	c.current.Add(key)
+	c.union.Add(key)
+	c.stats.Allowed++
+	return true
+}
+
+// rotate starts a new window if the current one has ended. The caller
+// must hold c.mu.
+func (c *Cardinality) rotate(now time.Time) {
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < c.config.Period {
+		return
+	}
+
+	c.union.Reset()
+	if elapsed < 2*c.config.Period {
+		// The current window becomes the previous one.
+		c.union.Merge(c.current)
+	}
+	c.current.Reset()
+	c.windowStart = c.windowStart.Add(elapsed.Truncate(c.config.Period))
+}
+
+// count returns the estimated number of distinct keys in the sliding
+// window ending now. The caller must hold c.mu.
+func (c *Cardinality) count(now time.Time) float64 {
+	current := estimate(&c.current.hist, len(c.current.registers))
+	union := estimate(&c.union.hist, len(c.union.registers))
+	weight := 1 - float64(now.Sub(c.windowStart))/float64(c.config.Period)
+	return current + math.Max(union-current, 0)*weight
+}
+
+// Count returns the estimated number of distinct keys in the sliding
+// window.
+func (c *Cardinality) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.config.Clock.Now()
+	c.rotate(now)
+	return int(math.Round(c.count(now)))
+}
+
+// Available returns how many more distinct keys would be allowed now.
+func (c *Cardinality) Available() int {
+	return max(c.limit-c.Count(), 0)
+}
+
+// Reset forgets every key and clears the counters.
+func (c *Cardinality) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.current.Reset()
+	c.union.Reset()
+	c.windowStart = c.config.Clock.Now()
+	c.stats = CardinalityStats{Limit: c.limit}
+}
+
+// Stats returns the counters and the estimated number of keys.
+func (c *Cardinality) Stats() CardinalityStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.config.Clock.Now()
+	c.rotate(now)
+	stats := c.stats
+	stats.Keys = int(math.Round(c.count(now)))
+	return stats
+}
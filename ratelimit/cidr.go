@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// CIDRPolicy assigns clients to tiers by network, for example a higher
+// limit for internal ranges and a lower one for known scrapers:
+//
+//	policy := ratelimit.NewCIDRPolicy()
+//	policy.Add("10.0.0.0/8", "internal", internalFactory)
+//	policy.Add("10.9.0.0/16", "batch", batchFactory)
+//	policy.Add("203.0.113.0/24", "scraper", scraperFactory)
+//
+//	config := ratelimit.DefaultMiddlewareConfig()
+//	config.TierFunc = policy.TierFunc
+//	config.Tiers = policy.Tiers()
+//
+// A client in several networks belongs to the most specific one, so
+// 10.9.1.1 above is "batch". Networks are kept in a binary radix tree, so
+// a lookup takes at most one step per address bit however many networks
+// there are. Clients are matched by the address the connection came from
+// (RemoteAddr), never by forwarding headers.
+//
+// Networks must be added before the policy is used; Add must not be
+// called concurrently with lookups.
+type CIDRPolicy struct {
+	v4, v6 cidrNode
+	tiers  map[string]func() Limiter
+}
+
+// cidrNode is a node of the radix tree; the path from the root spells the
+// network's leading bits.
+type cidrNode struct {
+	children [2]*cidrNode
+	network  *net.IPNet // nil unless a network ends here
+	tier     string
+}
+
+// NewCIDRPolicy creates an empty policy.
+func NewCIDRPolicy() *CIDRPolicy {
+	return &CIDRPolicy{tiers: make(map[string]func() Limiter)}
+}
+
+// Add assigns the clients of network, in CIDR notation or a single
+// address, to tier, whose limiters come from factory. Several networks
+// may share a tier; a nil factory keeps the one the tier already has.
+// Adding a network again replaces its tier.
+func (p *CIDRPolicy) Add(network, tier string, factory func() Limiter) error {
+	nets, err := ParseCIDRs(network)
+	if err != nil {
+		return err
+	}
+	if factory == nil && p.tiers[tier] == nil {
+		return fmt.Errorf("ratelimit: tier %q has no limiter factory", tier)
+	}
+	if factory != nil {
+		p.tiers[tier] = factory
+	}
+
+	n := nets[0]
+	ip, root := p.root(n.IP)
+	ones, _ := n.Mask.Size()
+	node := root
+	for i := 0; i < ones; i++ {
+		b := bit(ip, i)
+		if node.children[b] == nil {
+			node.children[b] = &cidrNode{}
+		}
+		node = node.children[b]
+	}
+	node.network = &net.IPNet{IP: ip.Mask(n.Mask), Mask: n.Mask}
+	node.tier = tier
+	return nil
+}
+
+// root returns ip in its 4- or 16-byte form and the tree it belongs to.
+func (p *CIDRPolicy) root(ip net.IP) (net.IP, *cidrNode) {
+	if v4 := ip.To4(); v4 != nil {
+		return v4, &p.v4
+	}
+	return ip.To16(), &p.v6
+}
+
+// bit returns bit i of ip, counting from the most significant.
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
+
+// Lookup returns the tier and network of the most specific network that
+// contains ip, or ok false if none does.
+func (p *CIDRPolicy) Lookup(ip net.IP) (tier string, network *net.IPNet, ok bool) {
+	if ip == nil {
+		return "", nil, false
+	}
+	ip, node := p.root(ip)
+
+	var best *cidrNode
+	for i := 0; node != nil; i++ {
+		if node.network != nil {
+			best = node
+		}
+		if i == len(ip)*8 {
+			break
+		}
+		node = node.children[bit(ip, i)]
+	}
+	if best == nil {
+		return "", nil, false
+	}
+	return best.tier, best.network, true
+}
+
+// TierFunc returns the tier of the client of r, or "" if its address is
+// in no network. It is a MiddlewareConfig.TierFunc; requests in no tier
+// use the LimiterFactory of the configuration.
+func (p *CIDRPolicy) TierFunc(r *http.Request) string {
+	tier, _, _ := p.Lookup(remoteIP(r))
+	return tier
+}
+
+// KeyFunc returns the network the client of r is in, so all its clients
+// share one budget, or the client address if it is in no network. Use it
+// as the MiddlewareConfig.KeyFunc to limit a scraper range as a whole;
+// with IPKeyFunc instead every client of a tier has its own budget.
+func (p *CIDRPolicy) KeyFunc(r *http.Request) string {
+	if _, network, ok := p.Lookup(remoteIP(r)); ok {
+		return network.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Tiers returns the limiter factory of every tier, for
+// MiddlewareConfig.Tiers.
+func (p *CIDRPolicy) Tiers() map[string]func() Limiter {
+	tiers := make(map[string]func() Limiter, len(p.tiers))
+	for tier, factory := range p.tiers {
+		tiers[tier] = factory
+	}
+	return tiers
+}
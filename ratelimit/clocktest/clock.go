@@ -0,0 +1,181 @@
+// Package clocktest provides a manual clock for testing code that uses the
+// limiters of package ratelimit, so that tests are deterministic and never
+// sleep:
+//
+//	clock := clocktest.NewFakeClock(time.Time{})
+//	limiter := ratelimit.NewTokenBucket(
+//		ratelimit.WithRate(1),
+//		ratelimit.WithBurst(1),
+//		ratelimit.WithClock(clock),
+//	)
+//
+//	limiter.Allow()  // true
+//	limiter.Allow()  // false
+//	clock.Advance(time.Second)
+//	limiter.Allow()  // true
+//
+// Time only moves when the test calls Advance or Set, which also wake the
+// callers blocked in Sleep, After and thus the limiters' Wait methods.
+package clocktest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// DefaultStart is the time of a FakeClock created with a zero time.
+var DefaultStart = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// FakeClock is a ratelimit.Clock whose time is moved by hand. It is safe
+// for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter     // pending After and Sleep calls, by deadline
+	changed chan struct{} // closed and replaced when a waiter is added
+}
+
+// waiter is a pending After or Sleep call.
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+var _ ratelimit.Clock = (*FakeClock)(nil)
+
+// NewFakeClock returns a clock set to now, or to DefaultStart if now is
+// the zero time.
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = DefaultStart
+	}
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the deadline once the clock has
+// been moved d past the current time. A d of zero or less fires at once.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	deadline := c.now.Add(d)
+	if d <= 0 {
+		ch <- deadline
+		return ch
+	}
+
+	w := &waiter{deadline: deadline, ch: ch}
+	i := sort.Search(len(c.waiters), func(i int) bool { return c.waiters[i].deadline.After(deadline) })
+	c.waiters = append(c.waiters, nil)
+	copy(c.waiters[i+1:], c.waiters[i:])
+	c.waiters[i] = w
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return ch
+}
+
+// Sleep blocks until the clock has been moved d past the current time.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the clock forward by d and wakes the waiters whose
+// deadline it reached, earliest first. A negative d is ignored.
+func (c *FakeClock) Advance(d time.Duration) {
+	if d < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to t and wakes the waiters whose deadline it
+// reached, earliest first. The clock never moves backwards: a t before
+// the current time is ignored.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	if t.Before(c.now) {
+		c.mu.Unlock()
+		return
+	}
+	c.set(t)
+}
+
+// set moves the clock to t and fires the due waiters. c.mu must be held;
+// it is released.
+func (c *FakeClock) set(t time.Time) {
+	c.now = t
+	n := 0
+	for n < len(c.waiters) && !c.waiters[n].deadline.After(t) {
+		n++
+	}
+	due := c.waiters[:n:n]
+	c.waiters = c.waiters[n:]
+	c.mu.Unlock()
+
+	for _, w := range due {
+		w.ch <- w.deadline
+	}
+}
+
+// Waiters returns the number of pending After and Sleep calls. Calls
+// whose caller has given up, such as a Wait whose context was cancelled,
+// remain pending until the clock reaches their deadline, as with
+// time.After.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Deadlines returns the deadlines of the pending After and Sleep calls,
+// earliest first.
+func (c *FakeClock) Deadlines() []time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadlines := make([]time.Time, len(c.waiters))
+	for i, w := range c.waiters {
+		deadlines[i] = w.deadline
+	}
+	return deadlines
+}
+
+// BlockUntil blocks until at least n After or Sleep calls are pending.
+// Use it to wait for goroutines to block, for example in a limiter's Wait,
+// before calling Advance.
+func (c *FakeClock) BlockUntil(n int) {
+	c.BlockUntilContext(context.Background(), n)
+}
+
+// BlockUntilContext is BlockUntil bounded by ctx. It returns ctx.Err() if
+// ctx is done first.
+func (c *FakeClock) BlockUntilContext(ctx context.Context, n int) error {
+	for {
+		c.mu.Lock()
+		pending, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+
+		if pending >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,510 @@
+// Package config builds rate limiters, and the policies that apply them to
+// requests, from a declarative YAML or JSON file, so a service's limits can
+// be changed without changing its code:
+//
+//	limiters:
+//	  search:
+//	    algorithm: sliding_window
+//	    rate: 600
+//	    period: 1m
+//	  login:
+//	    rate: 5
+//	    period: 1m
+//	  partner:
+//	    rate: 100
+//	    period: 1s
+//	    burst: 200
+//	    backend: redis
+//	policies:
+//	  - limiter: partner
+//	    key: header:X-API-Key
+//	    keys: [partner-*]
+//	  - limiter: login
+//	    paths: [/login]
+//	    methods: [POST]
+//	  - limiter: search
+//	    paths: [/search, /search/*]
+//
+// Load and Parse return a Registry whose Handler limits each request by
+// the first policy that matches it. Requests matching no policy pass
+// through unlimited.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/internal/yaml"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Limiter algorithms.
+const (
+	TokenBucket   = "token_bucket"
+	FixedWindow   = "fixed_window"
+	SlidingWindow = "sliding_window"
+	SlidingLog    = "sliding_log"
+)
+
+// Memory is the default backend: limiters are kept in the process, so
+// every instance of a service has its own budget.
+const Memory = "memory"
+
+// Policy keys, besides "header:Name".
+const (
+	KeyIP     = "ip"
+	KeyUser   = "user"
+	KeyPath   = "path"
+	KeyGlobal = "global"
+)
+
+// File is the content of a configuration file.
+type File struct {
+	Limiters map[string]Limiter `json:"limiters"`
+	Policies []Policy           `json:"policies"`
+}
+
+// Limiter defines a named limiter. Each key a policy limits gets its own
+// limiter built from the definition.
+type Limiter struct {
+	// Algorithm is TokenBucket, the default, FixedWindow, SlidingWindow
+	// or SlidingLog.
+	Algorithm string `json:"algorithm,omitempty"`
+
+	Rate   int      `json:"rate"`
+	Period Duration `json:"period"`
+
+	// Burst is the token bucket capacity. Zero means Rate.
+	Burst int `json:"burst,omitempty"`
+
+	// Align starts FixedWindow windows on multiples of Period of the wall
+	// clock, so that every instance resets at the same moment.
+	Align bool `json:"align,omitempty"`
+
+	// Pacing spreads out TokenBucket bursts, admitting requests at least
+	// Period/Rate apart.
+	Pacing bool `json:"pacing,omitempty"`
+
+	// Jitter lengthens waits by a random part of up to this fraction of
+	// them, between 0 and 1, so that clients do not retry in lockstep.
+	Jitter float64 `json:"jitter,omitempty"`
+
+	// Backend is where limiter state is kept: Memory, the default, or a
+	// backend registered with WithBackend.
+	Backend string `json:"backend,omitempty"`
+}
+
+// Policy binds a limiter to the requests it matches. A request matches if
+// its path matches one of Paths, its method is one of Methods and its key
+// matches one of Keys; an empty list matches everything.
+type Policy struct {
+	// Name identifies the policy to Registry.Policy. Defaults to the
+	// limiter name.
+	Name string `json:"name,omitempty"`
+
+	// Limiter names the limiter definition to apply.
+	Limiter string `json:"limiter"`
+
+	// Paths are route patterns, with the wildcards of ratelimit.Router.
+	// The paths of one policy share each key's budget; put them in
+	// separate policies to give them separate budgets.
+	Paths []string `json:"paths,omitempty"`
+
+	Methods []string `json:"methods,omitempty"`
+
+	// Key is what requests are limited by: KeyIP, the default, KeyUser,
+	// KeyPath, KeyGlobal for one budget shared by all requests, or
+	// "header:Name" for the value of a header, falling back to the client
+	// address when it is missing.
+	Key string `json:"key,omitempty"`
+
+	// Keys are patterns, in path.Match syntax such as "partner-*", the key
+	// must match for the policy to apply.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// Duration is a time.Duration that encodes as a Go duration string.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string ("1m") or nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// Backend builds the limiters of a definition whose state is kept outside
+// the process, such as in a distributed.WindowLimiter. name is the
+// definition's name, for example to prefix store keys. The returned
+// function is called once per key.
+type Backend func(name string, spec Limiter) (func(key string) ratelimit.Limiter, error)
+
+// Option configures how a File is built into a Registry.
+type Option func(*options)
+
+type options struct {
+	backends   map[string]Backend
+	middleware *ratelimit.MiddlewareConfig
+}
+
+// WithBackend registers a backend that limiters can name.
+func WithBackend(name string, backend Backend) Option {
+	return func(o *options) {
+		o.backends[name] = backend
+	}
+}
+
+// WithMiddlewareConfig sets the responses, cleanup and key bounds shared
+// by the policies, which otherwise use ratelimit.DefaultMiddlewareConfig.
+// Its key, tier and limiter functions are replaced by each policy's own.
+func WithMiddlewareConfig(config *ratelimit.MiddlewareConfig) Option {
+	return func(o *options) {
+		o.middleware = config
+	}
+}
+
+// Load reads a configuration file and builds its registry.
+func Load(file string, opts ...Option) (*Registry, error) {
+	f, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r, err := New(f, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", file, err)
+	}
+	return r, nil
+}
+
+// Parse parses a configuration, JSON if it starts with "{" and YAML
+// otherwise, and builds its registry. Unknown fields are rejected, so a
+// misspelt setting is not silently ignored.
+func Parse(data []byte, opts ...Option) (*Registry, error) {
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return New(f, opts...)
+}
+
+// readFile reads and decodes a configuration file.
+func readFile(file string) (*File, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", file, err)
+	}
+	return f, nil
+}
+
+// decode decodes a configuration as described on Parse.
+func decode(data []byte) (*File, error) {
+	js := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(js, []byte("{")) {
+		var err error
+		if js, err = yaml.ToJSON(data); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	var file File
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Registry holds the limiters and policies of a configuration. It is safe
+// for concurrent use, and Apply, Reload and Watch change its configuration
+// while it serves requests.
+type Registry struct {
+	opts  *options
+	apply sync.Mutex // serializes Apply
+
+	mu        sync.RWMutex
+	specs     map[string]Limiter
+	factories map[string]func(key string) ratelimit.Limiter
+	policies  []*policy
+}
+
+// policy is a Policy ready to match requests.
+type policy struct {
+	spec    Policy
+	methods map[string]bool
+	keyFunc ratelimit.KeyFunc
+	mw      *ratelimit.Middleware
+}
+
+// New validates file and builds its registry.
+func New(file *File, opts ...Option) (*Registry, error) {
+	o := &options{backends: make(map[string]Backend)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.middleware == nil {
+		o.middleware = ratelimit.DefaultMiddlewareConfig()
+	}
+
+	r := &Registry{opts: o}
+	if err := r.Apply(file); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// normalize fills in the defaults of a limiter definition.
+func normalize(spec Limiter) Limiter {
+	if spec.Algorithm == "" {
+		spec.Algorithm = TokenBucket
+	}
+	if spec.Backend == "" {
+		spec.Backend = Memory
+	}
+	return spec
+}
+
+// factory validates a normalized limiter definition and returns its
+// per-key factory.
+func (o *options) factory(name string, spec Limiter) (func(key string) ratelimit.Limiter, error) {
+	if spec.Rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive, got %d", spec.Rate)
+	}
+	if spec.Period <= 0 {
+		return nil, fmt.Errorf("period must be positive, got %s", time.Duration(spec.Period))
+	}
+	if spec.Burst < 0 {
+		return nil, fmt.Errorf("burst must not be negative, got %d", spec.Burst)
+	}
+	if spec.Align && spec.Algorithm != FixedWindow {
+		return nil, fmt.Errorf("align applies to %s only", FixedWindow)
+	}
+	if spec.Pacing && spec.Algorithm != TokenBucket {
+		return nil, fmt.Errorf("pacing applies to %s only", TokenBucket)
+	}
+	if spec.Jitter < 0 || spec.Jitter > 1 {
+		return nil, fmt.Errorf("jitter must be between 0 and 1, got %g", spec.Jitter)
+	}
+
+	var newLimiter func(...ratelimit.Option) ratelimit.Limiter
+	switch spec.Algorithm {
+	case TokenBucket:
+		newLimiter = func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewTokenBucket(opts...) }
+	case FixedWindow:
+		newLimiter = func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewFixedWindow(opts...) }
+	case SlidingWindow:
+		newLimiter = func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewSlidingWindow(opts...) }
+	case SlidingLog:
+		newLimiter = func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewSlidingLog(opts...) }
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", spec.Algorithm)
+	}
+
+	if spec.Backend != Memory {
+		backend, ok := o.backends[spec.Backend]
+		if !ok {
+			return nil, fmt.Errorf("unknown backend %q", spec.Backend)
+		}
+		return backend(name, spec)
+	}
+	opts := []ratelimit.Option{
+		ratelimit.WithRate(spec.Rate),
+		ratelimit.WithPeriod(time.Duration(spec.Period)),
+		ratelimit.WithBurst(spec.Burst),
+	}
+	if spec.Align {
+		opts = append(opts, ratelimit.WithAlignedWindows())
+	}
+	if spec.Pacing {
+		opts = append(opts, ratelimit.WithPacing(true))
+	}
+	if spec.Jitter > 0 {
+		opts = append(opts, ratelimit.WithJitter(spec.Jitter))
+	}
+	return func(string) ratelimit.Limiter {
+		return newLimiter(opts...)
+	}, nil
+}
+
+// compile validates a policy against the limiters in factories. The
+// middleware is left for the caller to build or carry over.
+func compile(spec Policy, factories map[string]func(key string) ratelimit.Limiter) (*policy, error) {
+	if spec.Name == "" {
+		spec.Name = spec.Limiter
+	}
+	if spec.Key == "" {
+		spec.Key = KeyIP
+	}
+	if _, ok := factories[spec.Limiter]; !ok {
+		return nil, fmt.Errorf("unknown limiter %q", spec.Limiter)
+	}
+	keyFunc, err := keyFunc(spec.Key)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range spec.Keys {
+		if _, err := path.Match(k, ""); err != nil {
+			return nil, fmt.Errorf("invalid key pattern %q", k)
+		}
+	}
+
+	p := &policy{spec: spec, keyFunc: keyFunc}
+	if len(spec.Methods) > 0 {
+		p.methods = make(map[string]bool, len(spec.Methods))
+		for _, m := range spec.Methods {
+			p.methods[strings.ToUpper(m)] = true
+		}
+	}
+	return p, nil
+}
+
+// newMiddleware builds the middleware of p from the shared base.
+func (p *policy) newMiddleware(base *ratelimit.MiddlewareConfig, factory func(key string) ratelimit.Limiter) *ratelimit.Middleware {
+	cfg := *base
+	cfg.KeyFunc = p.keyFunc
+	cfg.TierFunc, cfg.Tiers = nil, nil
+	cfg.LimiterFactory = nil
+	cfg.KeyedLimiterFactory = factory
+	return ratelimit.NewMiddleware(&cfg)
+}
+
+// keyFunc returns the key function of a policy key.
+func keyFunc(key string) (ratelimit.KeyFunc, error) {
+	switch key {
+	case KeyIP:
+		return ratelimit.IPKeyFunc, nil
+	case KeyUser:
+		return ratelimit.UserKeyFunc, nil
+	case KeyPath:
+		return ratelimit.PathKeyFunc, nil
+	case KeyGlobal:
+		return func(*http.Request) string { return KeyGlobal }, nil
+	}
+	name, ok := strings.CutPrefix(key, "header:")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("unknown key %q", key)
+	}
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+		return ratelimit.IPKeyFunc(r)
+	}, nil
+}
+
+// matches reports whether p applies to r.
+func (p *policy) matches(r *http.Request) bool {
+	if p.methods != nil && !p.methods[r.Method] {
+		return false
+	}
+	if len(p.spec.Paths) > 0 && !anyMatch(p.spec.Paths, r.URL.Path, ratelimit.MatchPath) {
+		return false
+	}
+	if len(p.spec.Keys) > 0 && !anyMatch(p.spec.Keys, p.keyFunc(r), func(pattern, key string) bool {
+		ok, _ := path.Match(pattern, key)
+		return ok
+	}) {
+		return false
+	}
+	return true
+}
+
+// anyMatch reports whether s matches one of patterns.
+func anyMatch(patterns []string, s string, match func(pattern, s string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns an HTTP handler that limits each request by the first
+// policy that matches it.
+func (r *Registry) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mw := r.Match(req)
+		if mw == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		mw.Handler(next).ServeHTTP(w, req)
+	})
+}
+
+// Match returns the middleware of the first policy that matches req, or
+// nil if req is not limited.
+func (r *Registry) Match(req *http.Request) *ratelimit.Middleware {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.policies {
+		if p.matches(req) {
+			return p.mw
+		}
+	}
+	return nil
+}
+
+// Policy returns the middleware of the first policy named name, for
+// example to read its Counters or Stats, or nil if there is none.
+func (r *Registry) Policy(name string) *ratelimit.Middleware {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.policies {
+		if p.spec.Name == name {
+			return p.mw
+		}
+	}
+	return nil
+}
+
+// Factory returns the per-key factory of the limiter named name, for call
+// sites that limit something other than HTTP requests, or nil if there is
+// none. The factory reflects the configuration at the time of the call.
+func (r *Registry) Factory(name string) func(key string) ratelimit.Limiter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.factories[name]
+}
+
+// Close stops the cleanup goroutines of all policies.
+func (r *Registry) Close() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.policies {
+		p.mw.Close()
+	}
+}
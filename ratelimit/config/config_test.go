@@ -0,0 +1,210 @@
+package config_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/config"
+)
+
+func TestParseRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "zero rate",
+			data: "limiters:\n  api:\n    rate: 0\n    period: 1s\n",
+			want: `limiter "api": rate must be positive, got 0`,
+		},
+		{
+			name: "missing period",
+			data: "limiters:\n  api:\n    rate: 5\n",
+			want: `limiter "api": period must be positive, got 0s`,
+		},
+		{
+			name: "negative burst",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\n    burst: -1\n",
+			want: `limiter "api": burst must not be negative, got -1`,
+		},
+		{
+			name: "align on token bucket",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\n    align: true\n",
+			want: `limiter "api": align applies to fixed_window only`,
+		},
+		{
+			name: "pacing on sliding window",
+			data: "limiters:\n  api:\n    algorithm: sliding_window\n    rate: 5\n    period: 1s\n    pacing: true\n",
+			want: `limiter "api": pacing applies to token_bucket only`,
+		},
+		{
+			name: "jitter above one",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\n    jitter: 2\n",
+			want: `limiter "api": jitter must be between 0 and 1, got 2`,
+		},
+		{
+			name: "unknown algorithm",
+			data: "limiters:\n  api:\n    algorithm: leaky_bucket\n    rate: 5\n    period: 1s\n",
+			want: `limiter "api": unknown algorithm "leaky_bucket"`,
+		},
+		{
+			name: "unknown backend",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\n    backend: redis\n",
+			want: `limiter "api": unknown backend "redis"`,
+		},
+		{
+			name: "invalid duration",
+			data: "limiters:\n  api:\n    rate: 5\n    period: a minute\n",
+			want: "invalid duration",
+		},
+		{
+			name: "unknown field",
+			data: "limiters:\n  api:\n    rte: 5\n    period: 1s\n",
+			want: `unknown field "rte"`,
+		},
+		{
+			name: "unknown limiter",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\npolicies:\n  - limiter: search\n",
+			want: `policy 0 (search): unknown limiter "search"`,
+		},
+		{
+			name: "unknown key",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\npolicies:\n  - limiter: api\n    key: cookie\n",
+			want: `policy 0 (api): unknown key "cookie"`,
+		},
+		{
+			name: "header key without name",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\npolicies:\n  - name: partners\n    limiter: api\n    key: \"header:\"\n",
+			want: `policy 0 (partners): unknown key "header:"`,
+		},
+		{
+			name: "invalid key pattern",
+			data: "limiters:\n  api:\n    rate: 5\n    period: 1s\npolicies:\n  - limiter: api\n    keys: [\"[\"]\n",
+			want: `policy 0 (api): invalid key pattern "["`,
+		},
+		{
+			name: "json",
+			data: `{"limiters": {"api": {"rate": -3, "period": "1s"}}}`,
+			want: `limiter "api": rate must be positive, got -3`,
+		},
+		{
+			name: "yaml syntax",
+			data: "limiters:\n  api:\n    rate: [5\n",
+			want: "yaml: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := config.Parse([]byte(tt.data))
+			if err == nil {
+				r.Close()
+				t.Fatal("Parse succeeded, want error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAppliesFirstMatchingPolicy(t *testing.T) {
+	r, err := config.Parse([]byte(`
+limiters:
+  login:
+    rate: 2
+    period: 1m
+  search:
+    algorithm: sliding_window
+    rate: 1
+    period: 1m
+policies:
+  - limiter: login
+    paths: [/login]
+    methods: [post]
+  - limiter: search
+    paths: [/search/*]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := serve(http.MethodPost, "/login"); got != want {
+			t.Errorf("POST /login #%d = %d, want %d", i+1, got, want)
+		}
+	}
+	if got := serve(http.MethodGet, "/login"); got != http.StatusOK {
+		t.Errorf("GET /login = %d, want %d: no policy matches it", got, http.StatusOK)
+	}
+	if got := serve(http.MethodGet, "/search/a"); got != http.StatusOK {
+		t.Errorf("GET /search/a = %d, want %d", got, http.StatusOK)
+	}
+	if got := serve(http.MethodGet, "/search/b"); got != http.StatusTooManyRequests {
+		t.Errorf("GET /search/b = %d, want %d: the paths share a budget", got, http.StatusTooManyRequests)
+	}
+	if got := serve(http.MethodGet, "/other"); got != http.StatusOK {
+		t.Errorf("GET /other = %d, want %d", got, http.StatusOK)
+	}
+	if r.Policy("login") == nil || r.Policy("missing") != nil {
+		t.Error("Policy does not look up policies by name")
+	}
+}
+
+func TestParseUsesRegisteredBackend(t *testing.T) {
+	var got config.Limiter
+	backend := func(name string, spec config.Limiter) (func(string) ratelimit.Limiter, error) {
+		got = spec
+		return func(string) ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(spec.Rate), ratelimit.WithPeriod(1<<62))
+		}, nil
+	}
+	r, err := config.Parse([]byte("limiters:\n  api:\n    rate: 3\n    period: 1s\n    backend: shared\n"), config.WithBackend("shared", backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if got.Rate != 3 || got.Algorithm != config.TokenBucket || got.Backend != "shared" {
+		t.Errorf("backend called with %+v, want the normalized definition", got)
+	}
+	if r.Factory("api") == nil {
+		t.Error("Factory(api) = nil")
+	}
+}
+
+func TestApplyInvalidFileChangesNothing(t *testing.T) {
+	r, err := config.Parse([]byte("limiters:\n  api:\n    rate: 1\n    period: 1m\npolicies:\n  - limiter: api\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	bad := &config.File{
+		Limiters: map[string]config.Limiter{"api": {Rate: 0, Period: config.Duration(1)}},
+		Policies: []config.Policy{{Limiter: "api"}},
+	}
+	if err := r.Apply(bad); err == nil {
+		t.Fatal("Apply succeeded with a zero rate")
+	}
+
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != want {
+			t.Errorf("request #%d = %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
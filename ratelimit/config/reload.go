@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rRateLimit/client/internal/filewatch"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Apply replaces the configuration of the registry with file. The change
+// is atomic: every request is limited by either the old policies or the
+// new ones, and a file that does not validate changes nothing.
+//
+// State survives the change. A policy whose name and key are unchanged
+// keeps its limiters. Those whose definition changed in rate, period or
+// burst only are reconfigured in place (see ratelimit.Reconfigurer), so
+// clients keep their usage and callers blocked in Wait keep their place;
+// those whose algorithm or backend changed are rebuilt, carrying their
+// state over where both algorithms support snapshots. Definitions that
+// did not change are not rebuilt, so backends are only called for new or
+// changed ones.
+func (r *Registry) Apply(file *File) error {
+	r.apply.Lock()
+	defer r.apply.Unlock()
+
+	r.mu.RLock()
+	oldSpecs, oldFactories, oldPolicies := r.specs, r.factories, r.policies
+	r.mu.RUnlock()
+
+	specs := make(map[string]Limiter, len(file.Limiters))
+	factories := make(map[string]func(key string) ratelimit.Limiter, len(file.Limiters))
+	for name, spec := range file.Limiters {
+		spec = normalize(spec)
+		specs[name] = spec
+		if old, ok := oldSpecs[name]; ok && old == spec {
+			factories[name] = oldFactories[name]
+			continue
+		}
+		factory, err := r.opts.factory(name, spec)
+		if err != nil {
+			return fmt.Errorf("limiter %q: %w", name, err)
+		}
+		factories[name] = factory
+	}
+
+	policies := make([]*policy, len(file.Policies))
+	for i, spec := range file.Policies {
+		p, err := compile(spec, factories)
+		if err != nil {
+			if spec.Name == "" {
+				spec.Name = spec.Limiter
+			}
+			return fmt.Errorf("policy %d (%s): %w", i, spec.Name, err)
+		}
+		policies[i] = p
+	}
+
+	// The file is valid; carry over the middleware of every policy that
+	// keeps its name and key, each at most once.
+	kept := make(map[*policy]bool, len(oldPolicies))
+	for _, p := range policies {
+		factory := factories[p.spec.Limiter]
+		for _, old := range oldPolicies {
+			if kept[old] || old.spec.Name != p.spec.Name || old.spec.Key != p.spec.Key {
+				continue
+			}
+			kept[old] = true
+			p.mw = old.mw
+			p.mw.Reconfigure(factory, adjust(oldSpecs[old.spec.Limiter], specs[p.spec.Limiter]))
+			break
+		}
+		if p.mw == nil {
+			p.mw = p.newMiddleware(r.opts.middleware, factory)
+		}
+	}
+
+	r.mu.Lock()
+	r.specs, r.factories, r.policies = specs, factories, policies
+	r.mu.Unlock()
+
+	for _, old := range oldPolicies {
+		if !kept[old] {
+			old.mw.Close()
+		}
+	}
+	return nil
+}
+
+// adjust returns the Middleware.Reconfigure callback that moves a limiter
+// built from old to spec, keeping it if it can be changed in place.
+func adjust(old, spec Limiter) func(key string, limiter ratelimit.Limiter) bool {
+	return func(_ string, limiter ratelimit.Limiter) bool {
+		if old == spec {
+			return true
+		}
+		if old.Algorithm != spec.Algorithm || old.Align != spec.Align || old.Pacing != spec.Pacing || old.Jitter != spec.Jitter || old.Backend != Memory || spec.Backend != Memory {
+			return false
+		}
+		rc, ok := limiter.(ratelimit.Reconfigurer)
+		if !ok {
+			return false
+		}
+		rc.Reconfigure(spec.Rate, time.Duration(spec.Period), spec.Burst)
+		return true
+	}
+}
+
+// Reload reads file and applies it as described on Apply.
+func (r *Registry) Reload(file string) error {
+	f, err := readFile(file)
+	if err != nil {
+		return err
+	}
+	if err := r.Apply(f); err != nil {
+		return fmt.Errorf("config %s: %w", file, err)
+	}
+	return nil
+}
+
+// Watch reloads the registry from file whenever the file changes, checked
+// by its size and modification time every interval, and whenever the
+// process receives SIGHUP, until ctx is done. An interval of zero or less
+// reloads on SIGHUP only. onReload, if not nil, is called after every
+// reload with its error; a file that fails to load leaves the current
+// configuration in place.
+func (r *Registry) Watch(ctx context.Context, file string, interval time.Duration, onReload func(error)) {
+	filewatch.Watch(ctx, file, interval, func() {
+		err := r.Reload(file)
+		if onReload != nil {
+			onReload(err)
+		}
+	})
+}
@@ -0,0 +1,274 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Canary is a candidate policy applied to a fixed share of keys while the
+// rest stay on the live version. Keys are assigned by hash, so a key stays
+// in the same arm for the whole canary.
+type Canary struct {
+	Policy    Policy    `json:"policy"`
+	Percent   int       `json:"percent"`
+	StartedAt time.Time `json:"started_at"`
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// Includes reports whether key is in the canary arm.
+func (c *Canary) Includes(key string) bool {
+	if c == nil || c.Percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%100) < c.Percent
+}
+
+// ErrNoCanary is returned when promoting or aborting without a canary.
+var ErrNoCanary = errors.New("coordinator: no canary in progress")
+
+// Arm identifies the stable or canary side of a canary.
+type Arm int
+
+// Arms.
+const (
+	ArmStable Arm = iota
+	ArmCanary
+)
+
+// ArmStats counts decisions made by one arm.
+type ArmStats struct {
+	Keys    int64 `json:"keys"`
+	Allowed int64 `json:"allowed"`
+	Denied  int64 `json:"denied"`
+
+	// DenyRatio is the fraction of decisions that were denials.
+	DenyRatio float64 `json:"deny_ratio"`
+}
+
+// CanaryReport compares the two arms of a running canary.
+type CanaryReport struct {
+	Canary  *Canary `json:"canary"`
+	Stable  Version `json:"stable"`
+	Metrics struct {
+		Stable ArmStats `json:"stable"`
+		Canary ArmStats `json:"canary"`
+	} `json:"metrics"`
+}
+
+// armCounters are the live, atomically updated ArmStats.
+type armCounters struct {
+	keys    int64
+	allowed int64
+	denied  int64
+}
+
+func (c *armCounters) stats() ArmStats {
+	s := ArmStats{
+		Keys:    atomic.LoadInt64(&c.keys),
+		Allowed: atomic.LoadInt64(&c.allowed),
+		Denied:  atomic.LoadInt64(&c.denied),
+	}
+	if total := s.Allowed + s.Denied; total > 0 {
+		s.DenyRatio = float64(s.Denied) / float64(total)
+	}
+	return s
+}
+
+// StartCanary applies p to percent of keys. Any running canary is replaced
+// and the arm metrics start from zero.
+func (s *Store) StartCanary(p Policy, percent int, author, comment string) (Canary, error) {
+	if err := p.Validate(); err != nil {
+		return Canary{}, err
+	}
+	if percent < 1 || percent > 100 {
+		return Canary{}, fmt.Errorf("percent must be between 1 and 100, got %d", percent)
+	}
+
+	c := &Canary{
+		Policy:    p,
+		Percent:   percent,
+		StartedAt: time.Now(),
+		Author:    author,
+		Comment:   comment,
+	}
+	s.setCanary(c)
+	return *c, nil
+}
+
+// CurrentCanary returns the running canary, if any.
+func (s *Store) CurrentCanary() (Canary, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.canary == nil {
+		return Canary{}, false
+	}
+	return *s.canary, true
+}
+
+// PromoteCanary ends the canary by applying its policy to all keys as a
+// new version.
+func (s *Store) PromoteCanary(author string) (Version, error) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	c := s.canary
+	s.canary = nil
+	s.mu.Unlock()
+	if c == nil {
+		return Version{}, ErrNoCanary
+	}
+
+	comment := fmt.Sprintf("promote canary started by %s at %d%%", c.Author, c.Percent)
+	if c.Comment != "" {
+		comment += ": " + c.Comment
+	}
+
+	return s.appendLocked(Version{
+		Policy:  c.Policy,
+		Author:  author,
+		Comment: comment,
+	}), nil
+}
+
+// AbortCanary ends the canary and returns all keys to the live version.
+func (s *Store) AbortCanary() error {
+	s.mu.RLock()
+	running := s.canary != nil
+	s.mu.RUnlock()
+	if !running {
+		return ErrNoCanary
+	}
+
+	s.setCanary(nil)
+	return nil
+}
+
+// setCanary replaces the canary and notifies listeners of the live version.
+func (s *Store) setCanary(c *Canary) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	s.canary = c
+	s.arms = [2]*armCounters{{}, {}}
+	current := s.versions[len(s.versions)-1]
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(current)
+	}
+}
+
+// CanaryReport returns the running canary with side-by-side arm metrics.
+func (s *Store) CanaryReport() (CanaryReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var report CanaryReport
+	if s.canary == nil {
+		return report, ErrNoCanary
+	}
+	c := *s.canary
+	report.Canary = &c
+	report.Stable = s.versions[len(s.versions)-1]
+	report.Metrics.Stable = s.arms[ArmStable].stats()
+	report.Metrics.Canary = s.arms[ArmCanary].stats()
+	return report, nil
+}
+
+// LimiterFactory returns a per-key factory for the current state of the
+// store: keys in the canary arm get limiters for the canary policy, all
+// others for the live version. newLimiter builds a limiter for a policy.
+// While a canary runs, limiters record their decisions in the arm metrics.
+//
+// The factory reflects the state at the time of the call; listeners
+// registered with OnChange should fetch a new one on every notification.
+func (s *Store) LimiterFactory(newLimiter func(Policy) ratelimit.Limiter) func(key string) ratelimit.Limiter {
+	s.mu.RLock()
+	stable := s.versions[len(s.versions)-1].Policy
+	canary := s.canary
+	arms := s.arms
+	s.mu.RUnlock()
+
+	if canary == nil {
+		return func(key string) ratelimit.Limiter {
+			return newLimiter(stable)
+		}
+	}
+
+	return func(key string) ratelimit.Limiter {
+		arm, p := ArmStable, stable
+		if canary.Includes(key) {
+			arm, p = ArmCanary, canary.Policy
+		}
+		counters := arms[arm]
+		atomic.AddInt64(&counters.keys, 1)
+		return &meteredLimiter{Limiter: newLimiter(p), counters: counters}
+	}
+}
+
+// meteredLimiter records the decisions of a limiter in an arm's counters.
+// It forwards the optional Snapshotter and Refunder interfaces.
+type meteredLimiter struct {
+	ratelimit.Limiter
+	counters *armCounters
+}
+
+func (m *meteredLimiter) record(ok bool) bool {
+	if ok {
+		atomic.AddInt64(&m.counters.allowed, 1)
+	} else {
+		atomic.AddInt64(&m.counters.denied, 1)
+	}
+	return ok
+}
+
+func (m *meteredLimiter) Allow() bool { return m.record(m.Limiter.Allow()) }
+
+func (m *meteredLimiter) AllowN(n int) bool { return m.record(m.Limiter.AllowN(n)) }
+
+func (m *meteredLimiter) Wait(ctx context.Context) error { return m.WaitN(ctx, 1) }
+
+func (m *meteredLimiter) WaitN(ctx context.Context, n int) error {
+	err := m.Limiter.WaitN(ctx, n)
+	m.record(err == nil)
+	return err
+}
+
+// Snapshot implements ratelimit.Snapshotter if the wrapped limiter does.
+func (m *meteredLimiter) Snapshot() ([]byte, error) {
+	s, ok := m.Limiter.(ratelimit.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support snapshots", m.Limiter)
+	}
+	return s.Snapshot()
+}
+
+// Restore implements ratelimit.Snapshotter if the wrapped limiter does.
+func (m *meteredLimiter) Restore(data []byte) error {
+	s, ok := m.Limiter.(ratelimit.Snapshotter)
+	if !ok {
+		return fmt.Errorf("%T does not support snapshots", m.Limiter)
+	}
+	return s.Restore(data)
+}
+
+// ReturnN implements ratelimit.Refunder; it is a no-op if the wrapped
+// limiter does not support refunds.
+func (m *meteredLimiter) ReturnN(n int) {
+	if r, ok := m.Limiter.(ratelimit.Refunder); ok {
+		r.ReturnN(n)
+	}
+}
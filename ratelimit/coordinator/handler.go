@@ -0,0 +1,185 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rRateLimit/client/ratelimit/admin"
+)
+
+// applyRequest is the body accepted by PUT /policy.
+type applyRequest struct {
+	Policy
+	Comment string `json:"comment,omitempty"`
+}
+
+// canaryRequest is the body accepted by PUT /policy/canary.
+type canaryRequest struct {
+	Policy
+	Percent int    `json:"percent"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Handler serves the policy endpoints:
+//
+//	GET  /policy                    live version
+//	PUT  /policy                    apply {"rate","period","burst","comment"}
+//	GET  /policy/history            all versions, newest first
+//	GET  /policy/versions/{n}       one version
+//	POST /policy/rollback[?version=n]  restore version n, or the previous one
+//	GET  /policy/canary             running canary with arm metrics
+//	PUT  /policy/canary             start {"rate","period","burst","percent","comment"}
+//	DELETE /policy/canary           abort the canary
+//	POST /policy/canary/promote     apply the canary policy to all keys
+//
+// Reads require admin.RoleReadOnly and changes admin.RoleOperator. The
+// author of each change is the authenticated principal.
+func Handler(store *Store, guard *admin.Guard) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/policy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, store.Current())
+			})).ServeHTTP(w, r)
+		case http.MethodPut:
+			guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req applyRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "invalid policy: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				v, err := store.Apply(req.Policy, author(r), req.Comment)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				writeJSON(w, http.StatusOK, v)
+			})).ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.Handle("/policy/history", guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.History())
+	})))
+
+	mux.Handle("/policy/versions/", guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Path[len("/policy/versions/"):])
+		if err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		v, err := store.Get(n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	})))
+
+	mux.Handle("/policy/rollback", guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		n := 0
+		if s := r.URL.Query().Get("version"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil || n < 1 {
+				http.Error(w, "invalid version", http.StatusBadRequest)
+				return
+			}
+		}
+
+		v, err := store.Rollback(n, author(r))
+		switch {
+		case errors.Is(err, ErrUnknownVersion):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrNoPrevious):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, v)
+		}
+	})))
+
+	mux.Handle("/policy/canary", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			guard.ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				report, err := store.CanaryReport()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				writeJSON(w, http.StatusOK, report)
+			})).ServeHTTP(w, r)
+		case http.MethodPut:
+			guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req canaryRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "invalid canary: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				c, err := store.StartCanary(req.Policy, req.Percent, author(r), req.Comment)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				writeJSON(w, http.StatusOK, c)
+			})).ServeHTTP(w, r)
+		case http.MethodDelete:
+			guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := store.AbortCanary(); err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.Handle("/policy/canary/promote", guard.Operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := store.PromoteCanary(author(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	})))
+
+	return mux
+}
+
+// author names the principal making a change.
+func author(r *http.Request) string {
+	if p, ok := admin.PrincipalFromContext(r.Context()); ok && p.Name != "" {
+		return p.Name
+	}
+	return "anonymous"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,256 @@
+// Package coordinator manages the limit policy shared by a fleet of limiter
+// instances. Every applied policy is kept as a numbered version with its
+// time and author, so a bad limit change can be rolled back in one step.
+package coordinator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy is the limit configuration applied to every key.
+type Policy struct {
+	Rate   int      `json:"rate"`
+	Period Duration `json:"period"`
+	Burst  int      `json:"burst"`
+}
+
+// Validate reports whether p can be applied.
+func (p Policy) Validate() error {
+	if p.Rate <= 0 {
+		return fmt.Errorf("rate must be positive, got %d", p.Rate)
+	}
+	if p.Period <= 0 {
+		return fmt.Errorf("period must be positive, got %s", time.Duration(p.Period))
+	}
+	if p.Burst < 0 {
+		return fmt.Errorf("burst must not be negative, got %d", p.Burst)
+	}
+	return nil
+}
+
+// Duration is a time.Duration that encodes as a Go duration string.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string ("1m") or nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// Version is one applied policy.
+type Version struct {
+	Number    int       `json:"version"`
+	Policy    Policy    `json:"policy"`
+	AppliedAt time.Time `json:"applied_at"`
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment,omitempty"`
+
+	// RollbackOf is the version whose policy this one restored, if any.
+	RollbackOf int `json:"rollback_of,omitempty"`
+}
+
+// Errors returned by Store.
+var (
+	ErrUnknownVersion = errors.New("coordinator: unknown policy version")
+	ErrNoPrevious     = errors.New("coordinator: no previous policy version")
+)
+
+// Store holds the policy history. History is append-only: a rollback is
+// recorded as a new version carrying the old policy, so the record of
+// what was live when is never rewritten.
+type Store struct {
+	versions  []Version
+	listeners []func(Version)
+	canary    *Canary
+	arms      [2]*armCounters
+	mu        sync.RWMutex
+
+	// applyMu serialises changes so listeners see versions in order.
+	applyMu sync.Mutex
+}
+
+// NewStore creates a store whose first version is initial.
+func NewStore(initial Policy, author string) (*Store, error) {
+	if err := initial.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		arms: [2]*armCounters{{}, {}},
+		versions: []Version{{
+			Number:    1,
+			Policy:    initial,
+			AppliedAt: time.Now(),
+			Author:    author,
+			Comment:   "initial policy",
+		}},
+	}, nil
+}
+
+// OnChange registers fn to be called with every newly applied version and
+// whenever a canary starts or ends. Callbacks run synchronously, in
+// registration order, after the store lock is released.
+func (s *Store) OnChange(fn func(Version)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+}
+
+// Current returns the live version.
+func (s *Store) Current() Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.versions[len(s.versions)-1]
+}
+
+// History returns all versions, newest first.
+func (s *Store) History() []Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := make([]Version, len(s.versions))
+	for i, v := range s.versions {
+		history[len(s.versions)-1-i] = v
+	}
+	return history
+}
+
+// Get returns version number n.
+func (s *Store) Get(n int) (Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if n < 1 || n > len(s.versions) {
+		return Version{}, ErrUnknownVersion
+	}
+	return s.versions[n-1], nil
+}
+
+// Apply makes p the live policy.
+func (s *Store) Apply(p Policy, author, comment string) (Version, error) {
+	if err := p.Validate(); err != nil {
+		return Version{}, err
+	}
+
+	return s.append(Version{
+		Policy:  p,
+		Author:  author,
+		Comment: comment,
+	}), nil
+}
+
+// Rollback re-applies the policy of version n. If n is zero, it restores
+// the version that was live before the current one.
+func (s *Store) Rollback(n int, author string) (Version, error) {
+	s.mu.RLock()
+	if n == 0 {
+		n = len(s.versions) - 1
+		if n < 1 {
+			s.mu.RUnlock()
+			return Version{}, ErrNoPrevious
+		}
+	}
+	if n < 1 || n > len(s.versions) {
+		s.mu.RUnlock()
+		return Version{}, ErrUnknownVersion
+	}
+	target := s.versions[n-1]
+	s.mu.RUnlock()
+
+	return s.append(Version{
+		Policy:     target.Policy,
+		Author:     author,
+		Comment:    fmt.Sprintf("rollback to version %d", target.Number),
+		RollbackOf: target.Number,
+	}), nil
+}
+
+// append numbers and timestamps v, records it and notifies listeners.
+func (s *Store) append(v Version) Version {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	return s.appendLocked(v)
+}
+
+// appendLocked is append for callers holding applyMu.
+func (s *Store) appendLocked(v Version) Version {
+	s.mu.Lock()
+	v.Number = len(s.versions) + 1
+	v.AppliedAt = time.Now()
+	s.versions = append(s.versions, v)
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(v)
+	}
+	return v
+}
+
+// Snapshot encodes the full history.
+func (s *Store) Snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal(s.versions)
+}
+
+// Restore replaces the history with a Snapshot and notifies listeners of
+// the restored live version.
+func (s *Store) Restore(data []byte) error {
+	var versions []Version
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return fmt.Errorf("decode policy history: %w", err)
+	}
+	if len(versions) == 0 {
+		return errors.New("decode policy history: empty history")
+	}
+	for i, v := range versions {
+		if v.Number != i+1 {
+			return fmt.Errorf("decode policy history: version %d out of sequence", v.Number)
+		}
+		if err := v.Policy.Validate(); err != nil {
+			return fmt.Errorf("decode policy history: version %d: %w", v.Number, err)
+		}
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	s.versions = versions
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	current := versions[len(versions)-1]
+	for _, fn := range listeners {
+		fn(current)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// CostReporter charges a request admitted by a Middleware with a cost that
+// is only known while it is served, such as the complexity of a GraphQL
+// query, which the server computes after parsing it. The charge goes to
+// the limiter of the request's key on top of the cost of CostFunc, so a
+// complex query consumes a proportional budget. Set CostReporting to
+// attach one to every admitted request; handlers get it with
+// CostReporterFromContext or charge through ChargeCost.
+//
+// Charges are counted as rate limited when rejected, logged and emitted as
+// events, and only reported in DryRun mode; they are not passed to
+// OnAllowed, which has seen the request already.
+type CostReporter struct {
+	m       *Middleware
+	r       *http.Request
+	key     string
+	charged atomic.Int64
+}
+
+// costReporterKey is the context key for CostReporter.
+type costReporterKey struct{}
+
+// withCostReporter returns r carrying a CostReporter for key.
+func withCostReporter(r *http.Request, m *Middleware, key string) *http.Request {
+	c := &CostReporter{m: m, r: r, key: key}
+	return r.WithContext(context.WithValue(r.Context(), costReporterKey{}, c))
+}
+
+// CostReporterFromContext returns the CostReporter of the request of ctx,
+// and false if the request was not admitted by a Middleware with
+// CostReporting.
+func CostReporterFromContext(ctx context.Context) (*CostReporter, bool) {
+	c, ok := ctx.Value(costReporterKey{}).(*CostReporter)
+	return c, ok
+}
+
+// ChargeCost charges n to the CostReporter of ctx; see Charge. Without
+// one it does nothing and returns nil, so code reporting costs also runs
+// without the middleware, for example in tests.
+func ChargeCost(ctx context.Context, n int) error {
+	if c, ok := CostReporterFromContext(ctx); ok {
+		return c.Charge(n)
+	}
+	return nil
+}
+
+// Key returns the key charges go to.
+func (c *CostReporter) Key() string {
+	return c.key
+}
+
+// Charged returns the cost charged so far.
+func (c *CostReporter) Charged() int {
+	return int(c.charged.Load())
+}
+
+// Charge charges n to the key if its budget allows, and returns an
+// *ErrLimited otherwise, whose RetryAfter a GraphQL server can pass on in
+// its error. Exempt keys are never charged. n of zero or less does
+// nothing.
+func (c *CostReporter) Charge(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if o, d := c.m.override(c.key); d > 0 {
+		if o.exempt {
+			return nil
+		}
+		return c.limited(n, RateLimitInfo{Key: c.key, RetryAfter: d}, &ErrLimited{RetryAfter: d})
+	}
+
+	limiter := c.m.getLimiter(c.key)
+	if limiter.AllowN(n) {
+		c.charged.Add(int64(n))
+		return nil
+	}
+	err := error(&ErrLimited{})
+	if ch, ok := limiter.(Checker); ok {
+		if cerr := ch.CheckN(n); cerr != nil {
+			err = cerr
+		}
+	}
+	return c.limited(n, rateLimitInfo(c.key, err), err)
+}
+
+// WaitN charges n to the key, waiting until its budget allows it or ctx
+// is done. It fails at once with an *ErrLimited if the key is blocked.
+func (c *CostReporter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 || c.m.config.DryRun {
+		return c.Charge(n)
+	}
+	if o, d := c.m.override(c.key); d > 0 {
+		if o.exempt {
+			return nil
+		}
+		return c.limited(n, RateLimitInfo{Key: c.key, RetryAfter: d}, &ErrLimited{RetryAfter: d})
+	}
+	if err := c.m.getLimiter(c.key).WaitN(ctx, n); err != nil {
+		if ctx.Err() != context.Canceled {
+			c.m.countLimited(c.r, rateLimitInfo(c.key, err))
+		}
+		return err
+	}
+	c.charged.Add(int64(n))
+	return nil
+}
+
+// limited rejects a charge of n with err, or only reports it in DryRun
+// mode.
+func (c *CostReporter) limited(n int, info RateLimitInfo, err error) error {
+	if c.m.config.DryRun {
+		c.m.wouldReject(c.r, DryRunEvent{Reason: DryRunRateLimited, Key: c.key, Cost: n, RetryAfter: info.RetryAfter})
+		return nil
+	}
+	c.m.countLimited(c.r, info)
+	return err
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// decay releases capacity that a reset freed linearly over
+// Config.DecayReset instead of all at once. Without it, resetting many
+// keys together, or many FixedWindow keys rolling over on the same
+// boundary, frees every key's full budget at the same instant and invites
+// a synchronized burst. With a zero period it does nothing.
+type decay struct {
+	period time.Duration
+	from   float64   // units still counted at start
+	start  time.Time // when the decay began
+}
+
+// begin starts releasing used units from start on. Units still decaying
+// from an earlier reset are carried over.
+func (d *decay) begin(used float64, start time.Time) {
+	if d.period <= 0 {
+		return
+	}
+	d.from = used + d.left(start)
+	d.start = start
+}
+
+// left returns the units still counted at now.
+func (d *decay) left(now time.Time) float64 {
+	if d.from <= 0 {
+		return 0
+	}
+	elapsed := now.Sub(d.start)
+	if elapsed >= d.period {
+		d.from = 0
+		return 0
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return d.from * float64(d.period-elapsed) / float64(d.period)
+}
+
+// units returns left rounded up, for limiters that count whole requests.
+func (d *decay) units(now time.Time) int {
+	return int(math.Ceil(d.left(now)))
+}
+
+// until returns when at most target units will still be counted. The
+// second result is false if no decay is in progress.
+func (d *decay) until(target float64) (time.Time, bool) {
+	if d.from <= 0 {
+		return time.Time{}, false
+	}
+	if target >= d.from {
+		return d.start, true
+	}
+	if target < 0 {
+		target = 0
+	}
+	return d.start.Add(time.Duration(float64(d.period) * (1 - target/d.from))), true
+}
+
+// rate returns the units released per second while the decay lasts.
+func (d *decay) rate(now time.Time) float64 {
+	if d.left(now) <= 0 {
+		return 0
+	}
+	return d.from / d.period.Seconds()
+}
+
+// restore resumes a decay from a snapshot taken by another process. The
+// units are capped at limit and a start in the future is moved to now.
+func (d *decay) restore(from float64, start time.Time, limit float64, now time.Time) {
+	d.from, d.start = 0, time.Time{}
+	if d.period <= 0 || from <= 0 {
+		return
+	}
+	d.from = math.Min(from, limit)
+	d.start = start
+	if d.start.After(now) {
+		d.start = now
+	}
+}
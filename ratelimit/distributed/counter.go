@@ -0,0 +1,404 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CounterConfig configures a Counter.
+type CounterConfig struct {
+	// Store holds the shared usage.
+	Store Store
+
+	// Period is the usage window length.
+	Period time.Duration
+
+	// Limit is the global per-key limit per window. It only steers
+	// Interval; the Counter itself never rejects anything.
+	Limit int64
+
+	// Interval decides when each key syncs next.
+	Interval AdaptiveInterval
+
+	// Timeout bounds each store call. Defaults to 1s.
+	Timeout time.Duration
+
+	// OnError is called with store errors. Unsynced usage is kept and
+	// retried at the next tick.
+	OnError func(error)
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// Counter counts per-key usage locally and reconciles it with a Store in
+// the background. Each key syncs on its own schedule chosen by
+// CounterConfig.Interval, so the store sees traffic in proportion to how
+// close keys are to their limit rather than to how many keys exist.
+type Counter struct {
+	config CounterConfig
+	keys   map[string]*counterKey
+	mu     sync.Mutex
+	stats  CounterStats
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	// failingSince is when store calls started failing, or zero while
+	// they succeed.
+	failingSince time.Time
+}
+
+// counterKey is the sync state of one key.
+type counterKey struct {
+	window   time.Time
+	pending  int64 // used locally in window, not yet sent
+	inflight int64 // sent, not yet confirmed by the store
+	global   int64 // fleet usage in window at the last sync
+	local    int64 // used locally in window
+	syncing  bool
+	syncedAt time.Time
+	next     time.Time
+	lastUsed time.Time
+
+	// Usage of the previous window that was not sent before it ended.
+	staleWindow  time.Time
+	stalePending int64
+}
+
+// CounterStats reports store traffic.
+type CounterStats struct {
+	Syncs  int64 `json:"syncs"`
+	Errors int64 `json:"errors"`
+}
+
+// NewCounter creates a Counter and starts its sync loop, which wakes every
+// Interval.Min. Call Close to stop it.
+func NewCounter(config CounterConfig) (*Counter, error) {
+	if config.Store == nil {
+		return nil, errors.New("distributed: counter needs a store")
+	}
+	if config.Period <= 0 {
+		return nil, errors.New("distributed: counter period must be positive")
+	}
+	config.Interval = config.Interval.withDefaults()
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	c := &Counter{
+		config: config,
+		keys:   make(map[string]*counterKey),
+		done:   make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.loop()
+
+	return c, nil
+}
+
+// Add records n units used locally by key.
+func (c *Counter) Add(key string, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(c.key(key, c.config.Clock()), n)
+}
+
+// add records n units on k. c.mu must be held.
+func (c *Counter) add(k *counterKey, n int64) {
+	k.pending += n
+	k.local += n
+	k.lastUsed = c.config.Clock()
+}
+
+// Used returns the estimated fleet usage of key in the current window:
+// the total at the last sync plus local usage not yet confirmed.
+func (c *Counter) Used(key string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.key(key, c.config.Clock()).used()
+}
+
+// used is the estimated fleet usage of k. c.mu must be held.
+func (k *counterKey) used() int64 {
+	return k.global + k.inflight + k.pending
+}
+
+// Preload raises the fleet usage known for key in the current window to
+// at least used, for example from Store.Hot at startup.
+func (c *Counter) Preload(key string, used int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := c.key(key, c.config.Clock())
+	if used > k.global {
+		k.global = used
+	}
+}
+
+// FailingSince returns when store calls started failing, or the zero time
+// if the last call succeeded.
+func (c *Counter) FailingSince() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failingSince
+}
+
+// NextSync returns when key is next due to sync, or the zero time if the
+// key is unknown.
+func (c *Counter) NextSync(key string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if k, ok := c.keys[key]; ok {
+		return k.next
+	}
+	return time.Time{}
+}
+
+// Stats returns the store traffic so far.
+func (c *Counter) Stats() CounterStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// key returns the state of key, rolling it over to the window containing
+// now. c.mu must be held.
+func (c *Counter) key(key string, now time.Time) *counterKey {
+	window := WindowStart(now, c.config.Period)
+
+	k, ok := c.keys[key]
+	if !ok {
+		k = &counterKey{window: window, next: now}
+		c.keys[key] = k
+		return k
+	}
+
+	if !k.window.Equal(window) {
+		if k.pending > 0 {
+			k.staleWindow, k.stalePending = k.window, k.pending
+		}
+		k.window = window
+		k.pending = 0
+		k.global = 0
+		k.local = 0
+		k.inflight = 0
+		k.syncedAt = time.Time{}
+		k.next = now
+	}
+	return k
+}
+
+// syncBatch is one key's usage taken for a sync.
+type syncBatch struct {
+	key          string
+	window       time.Time
+	pending      int64
+	staleWindow  time.Time
+	stalePending int64
+}
+
+// Sync sends the usage of every key that is due. It returns the first
+// store error, if any.
+func (c *Counter) Sync(ctx context.Context) error {
+	return c.sync(ctx, false)
+}
+
+// Flush sends the usage of every key regardless of its schedule.
+func (c *Counter) Flush(ctx context.Context) error {
+	return c.sync(ctx, true)
+}
+
+func (c *Counter) sync(ctx context.Context, all bool) error {
+	now := c.config.Clock()
+
+	c.mu.Lock()
+	var batches []syncBatch
+	for key, k := range c.keys {
+		k = c.key(key, now)
+		if !all && now.Before(k.next) && k.stalePending == 0 {
+			continue
+		}
+		if b, ok := c.take(key, k); ok {
+			batches = append(batches, b)
+		}
+	}
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, b := range batches {
+		if err := c.syncKey(ctx, b); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SyncKey sends the usage of key now, regardless of its schedule. It does
+// nothing if the key is already being synced.
+func (c *Counter) SyncKey(ctx context.Context, key string) error {
+	c.mu.Lock()
+	b, ok := c.take(key, c.key(key, c.config.Clock()))
+	c.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return c.syncKey(ctx, b)
+}
+
+// take moves k's pending usage in flight for a sync. It reports false if
+// a sync of k is already running: one sync per key at a time, so usage is
+// never confirmed out of order. c.mu must be held.
+func (c *Counter) take(key string, k *counterKey) (syncBatch, bool) {
+	if k.syncing {
+		return syncBatch{}, false
+	}
+	b := syncBatch{
+		key:          key,
+		window:       k.window,
+		pending:      k.pending,
+		staleWindow:  k.staleWindow,
+		stalePending: k.stalePending,
+	}
+	k.syncing = true
+	k.inflight = k.pending
+	k.pending = 0
+	k.stalePending = 0
+	return b, true
+}
+
+// syncKey sends one key's usage and schedules its next sync.
+func (c *Counter) syncKey(ctx context.Context, b syncBatch) error {
+	ttl := 2 * c.config.Period
+
+	if b.stalePending > 0 {
+		if _, err := c.storeAdd(ctx, b.key, b.staleWindow, b.stalePending, ttl); err != nil {
+			// The window is over; its usage no longer matters.
+			c.failed(err)
+		}
+	}
+
+	total, err := c.storeAdd(ctx, b.key, b.window, b.pending, ttl)
+	now := c.config.Clock()
+
+	c.mu.Lock()
+	k, ok := c.keys[b.key]
+	if ok {
+		k.syncing = false
+	}
+	current := ok && k.window.Equal(b.window)
+
+	if err != nil {
+		if current {
+			// Keep the usage and retry at the next tick.
+			k.pending += k.inflight
+			k.inflight = 0
+			k.next = now
+		} else if ok {
+			// The window ended during the sync.
+			k.staleWindow, k.stalePending = b.window, k.stalePending+b.pending
+		}
+		c.mu.Unlock()
+		c.failed(err)
+		return err
+	}
+	defer c.mu.Unlock()
+
+	c.stats.Syncs++
+	c.failingSince = time.Time{}
+	if !current {
+		return nil
+	}
+	k.inflight = 0
+
+	var rate float64
+	if !k.syncedAt.IsZero() {
+		if elapsed := now.Sub(k.syncedAt).Seconds(); elapsed > 0 {
+			rate = float64(total-k.global) / elapsed
+		}
+	}
+	k.global = total
+	k.syncedAt = now
+	k.next = now.Add(c.config.Interval.Next(total+k.pending, c.config.Limit, rate))
+	return nil
+}
+
+func (c *Counter) storeAdd(ctx context.Context, key string, window time.Time, n int64, ttl time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	return c.config.Store.Add(ctx, key, window, n, ttl)
+}
+
+// failed records an error outside c.mu.
+func (c *Counter) failed(err error) {
+	c.mu.Lock()
+	c.stats.Errors++
+	if c.failingSince.IsZero() {
+		c.failingSince = c.config.Clock()
+	}
+	c.mu.Unlock()
+	c.report(err)
+}
+
+func (c *Counter) report(err error) {
+	if c.config.OnError != nil {
+		c.config.OnError(err)
+	}
+}
+
+// loop syncs due keys and drops keys idle for a whole window.
+func (c *Counter) loop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.Interval.Min)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.dropIdle()
+			c.Sync(context.Background())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// dropIdle forgets keys with nothing to send that were not used for a
+// whole period.
+func (c *Counter) dropIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.config.Clock()
+	for key, k := range c.keys {
+		if now.Sub(k.lastUsed) > c.config.Period && k.pending == 0 && k.stalePending == 0 && !k.syncing {
+			delete(c.keys, key)
+		}
+	}
+}
+
+// Close stops the sync loop, waits for it to exit and sends remaining
+// usage. Later calls do nothing and return nil.
+func (c *Counter) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+		defer cancel()
+		err = c.Flush(ctx)
+	})
+	return err
+}
@@ -0,0 +1,300 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EtcdConfig configures an Etcd client.
+type EtcdConfig struct {
+	// Endpoints are the etcd members' client URLs, e.g.
+	// "http://localhost:2379". Requests go to one endpoint and move on to
+	// the next when it is unreachable.
+	Endpoints []string
+
+	// Prefix is prepended to every key. Defaults to "ratelimit/".
+	Prefix string
+
+	// Timeout bounds each request when the context has no earlier
+	// deadline. Defaults to 1s.
+	Timeout time.Duration
+
+	// Client sends the requests. Defaults to a new http.Client.
+	Client *http.Client
+}
+
+// Etcd is a minimal etcd v3 client covering the key-value, transaction and
+// lease calls used by QuotaPool. It talks to etcd's JSON gateway (the /v3
+// HTTP endpoints served alongside gRPC), so it needs no client library. It
+// is safe for concurrent use.
+type Etcd struct {
+	config  EtcdConfig
+	current int32 // index of the endpoint in use
+}
+
+// EtcdError is an error response from etcd.
+type EtcdError struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+func (e *EtcdError) Error() string {
+	return fmt.Sprintf("etcd: %s (code %d, status %d)", e.Message, e.Code, e.Status)
+}
+
+// ErrLeaseExpired is returned when refreshing a lease etcd no longer has.
+var ErrLeaseExpired = errors.New("distributed: etcd lease expired")
+
+// etcdKV is a key-value pair as returned by etcd. Byte fields are base64
+// in JSON, and 64-bit integers are strings.
+type etcdKV struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	ModRevision    int64  `json:"mod_revision,string"`
+	Lease          int64  `json:"lease,string"`
+}
+
+// etcdCompare is one condition of a transaction. The revision fields are
+// alternatives; at most one may be set.
+type etcdCompare struct {
+	Key            []byte `json:"key"`
+	Result         string `json:"result"`
+	Target         string `json:"target"`
+	CreateRevision int64  `json:"create_revision,string,omitempty"`
+	ModRevision    int64  `json:"mod_revision,string,omitempty"`
+}
+
+// etcdPut is a put request, on its own or inside a transaction.
+type etcdPut struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+// NewEtcd creates a client. No connection is made until the first call.
+func NewEtcd(config EtcdConfig) (*Etcd, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("distributed: etcd needs at least one endpoint")
+	}
+	endpoints := make([]string, len(config.Endpoints))
+	for i, endpoint := range config.Endpoints {
+		endpoints[i] = strings.TrimSuffix(endpoint, "/")
+	}
+	config.Endpoints = endpoints
+	if config.Prefix == "" {
+		config.Prefix = "ratelimit/"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{}
+	}
+	return &Etcd{config: config}, nil
+}
+
+// Ping checks that etcd is reachable.
+func (e *Etcd) Ping(ctx context.Context) error {
+	return e.call(ctx, "/v3/maintenance/status", struct{}{}, nil)
+}
+
+// get returns key, or nil if it does not exist.
+func (e *Etcd) get(ctx context.Context, key string) (*etcdKV, error) {
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	req := struct {
+		Key []byte `json:"key"`
+	}{[]byte(key)}
+	if err := e.call(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.KVs) == 0 {
+		return nil, nil
+	}
+	return &resp.KVs[0], nil
+}
+
+// count returns the number of keys starting with prefix.
+func (e *Etcd) count(ctx context.Context, prefix string) (int64, error) {
+	var resp struct {
+		Count int64 `json:"count,string"`
+	}
+	req := struct {
+		Key       []byte `json:"key"`
+		RangeEnd  []byte `json:"range_end"`
+		CountOnly bool   `json:"count_only"`
+	}{[]byte(prefix), prefixEnd(prefix), true}
+	if err := e.call(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// put sets key to value, attached to lease if it is not zero.
+func (e *Etcd) put(ctx context.Context, key, value string, lease int64) error {
+	return e.call(ctx, "/v3/kv/put", etcdPut{Key: []byte(key), Value: []byte(value), Lease: lease}, nil)
+}
+
+// swap sets key to value if its mod revision is still rev, or if rev is
+// zero and key does not exist. It reports whether the put happened.
+func (e *Etcd) swap(ctx context.Context, key string, rev int64, value string, lease int64) (bool, error) {
+	cmp := etcdCompare{Key: []byte(key), Result: "EQUAL", Target: "MOD", ModRevision: rev}
+	if rev == 0 {
+		cmp = etcdCompare{Key: []byte(key), Result: "EQUAL", Target: "CREATE"}
+	}
+
+	type op struct {
+		RequestPut etcdPut `json:"request_put"`
+	}
+	req := struct {
+		Compare []etcdCompare `json:"compare"`
+		Success []op          `json:"success"`
+	}{
+		Compare: []etcdCompare{cmp},
+		Success: []op{{etcdPut{Key: []byte(key), Value: []byte(value), Lease: lease}}},
+	}
+
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := e.call(ctx, "/v3/kv/txn", req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// grant creates a lease that expires after ttl, rounded up to a second.
+func (e *Etcd) grant(ctx context.Context, ttl time.Duration) (int64, error) {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	var resp struct {
+		ID    int64  `json:"ID,string"`
+		Error string `json:"error"`
+	}
+	req := struct {
+		TTL int64 `json:"TTL,string"`
+	}{seconds}
+	if err := e.call(ctx, "/v3/lease/grant", req, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, &EtcdError{Status: http.StatusOK, Message: resp.Error}
+	}
+	return resp.ID, nil
+}
+
+// keepAlive refreshes lease once. It returns ErrLeaseExpired if etcd no
+// longer has it.
+func (e *Etcd) keepAlive(ctx context.Context, lease int64) error {
+	var resp struct {
+		Result struct {
+			TTL int64 `json:"TTL,string"`
+		} `json:"result"`
+	}
+	req := struct {
+		ID int64 `json:"ID,string"`
+	}{lease}
+	if err := e.call(ctx, "/v3/lease/keepalive", req, &resp); err != nil {
+		return err
+	}
+	if resp.Result.TTL <= 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// revoke deletes lease and every key attached to it.
+func (e *Etcd) revoke(ctx context.Context, lease int64) error {
+	req := struct {
+		ID int64 `json:"ID,string"`
+	}{lease}
+	return e.call(ctx, "/v3/lease/revoke", req, nil)
+}
+
+// call posts req as JSON to path and decodes the response into resp, which
+// may be nil. Unreachable endpoints are skipped in turn.
+func (e *Etcd) call(ctx context.Context, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	endpoints := e.config.Endpoints
+	first := int(atomic.LoadInt32(&e.current))
+	for i := 0; ; i++ {
+		index := (first + i) % len(endpoints)
+		err = e.post(ctx, endpoints[index]+path, body, resp)
+
+		var etcdErr *EtcdError
+		if err == nil || errors.As(err, &etcdErr) || ctx.Err() != nil || i == len(endpoints)-1 {
+			if err == nil {
+				atomic.StoreInt32(&e.current, int32(index))
+			}
+			return err
+		}
+	}
+}
+
+// post sends one request to url.
+func (e *Etcd) post(ctx context.Context, url string, body []byte, resp interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		etcdErr := &EtcdError{Status: res.StatusCode, Message: res.Status}
+		var reply struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+		if json.Unmarshal(data, &reply) == nil && reply.Message != "" {
+			etcdErr.Code, etcdErr.Message = reply.Code, reply.Message
+		}
+		return etcdErr
+	}
+
+	if resp == nil {
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// prefixEnd returns the end of the key range covering every key that
+// starts with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Every byte is 0xff: range to the end of the keyspace.
+	return []byte{0}
+}
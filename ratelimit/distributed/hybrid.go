@@ -0,0 +1,338 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// DegradeMode selects how a Hybrid limiter admits requests while the store
+// is unreachable.
+type DegradeMode int
+
+const (
+	// DegradeLocal enforces a static share of the limit, Limit/Instances,
+	// on each instance. The fleet stays within the limit as long as
+	// Instances is accurate.
+	DegradeLocal DegradeMode = iota
+
+	// DegradeOpen admits everything.
+	DegradeOpen
+
+	// DegradeClosed rejects everything.
+	DegradeClosed
+)
+
+// HybridConfig configures a Hybrid limiter.
+type HybridConfig struct {
+	// Store holds the usage shared by the fleet.
+	Store Store
+
+	// Limit is the number of units each key may use per Period across all
+	// instances.
+	Limit int64
+
+	// Period is the window length.
+	Period time.Duration
+
+	// Instances is the number of instances sharing the limit. It sets the
+	// local share used in DegradeLocal mode and the default MaxDrift.
+	// Defaults to 1.
+	Instances int
+
+	// MaxDrift bounds the units an instance may admit for a key that the
+	// store has not yet confirmed. Reaching it forces a sync of the key
+	// before more is admitted, so the fleet overshoots the limit by at most
+	// Instances*MaxDrift. Defaults to a tenth of the local share, at
+	// least 1.
+	MaxDrift int64
+
+	// Interval schedules background syncs; see AdaptiveInterval.
+	Interval AdaptiveInterval
+
+	// StaleAfter is how long store calls may fail before the limiter
+	// switches to Degrade. Defaults to one second.
+	StaleAfter time.Duration
+
+	// Degrade is the admission policy while the store is unreachable.
+	Degrade DegradeMode
+
+	// Timeout bounds each store call. Defaults to 1s.
+	Timeout time.Duration
+
+	// OnError is called with store errors. May be nil.
+	OnError func(error)
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// Hybrid is a keyed limiter that enforces a global per-key limit across
+// instances without a store round trip per request. Each instance admits
+// against its latest view of fleet usage, kept fresh by a Counter, and
+// syncs a key early once its unconfirmed local usage reaches MaxDrift.
+// When the store is unreachable it falls back to Degrade, and reconciles
+// the usage admitted meanwhile once the store is back.
+type Hybrid struct {
+	config  HybridConfig
+	counter *Counter
+	share   int64
+}
+
+// admission is the outcome of checking a key.
+type admission int
+
+const (
+	admitted admission = iota
+	overLimit
+	overDrift
+)
+
+// NewHybrid creates a Hybrid limiter and starts its sync loop. Call Close
+// to stop it.
+func NewHybrid(config HybridConfig) (*Hybrid, error) {
+	if config.Limit <= 0 {
+		return nil, errors.New("distributed: hybrid limit must be positive")
+	}
+	if config.Instances <= 0 {
+		config.Instances = 1
+	}
+	share := config.Limit / int64(config.Instances)
+	if share < 1 {
+		share = 1
+	}
+	if config.MaxDrift <= 0 {
+		config.MaxDrift = share / 10
+		if config.MaxDrift < 1 {
+			config.MaxDrift = 1
+		}
+	}
+	if config.StaleAfter <= 0 {
+		config.StaleAfter = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	counter, err := NewCounter(CounterConfig{
+		Store:    config.Store,
+		Period:   config.Period,
+		Limit:    config.Limit,
+		Interval: config.Interval,
+		Timeout:  config.Timeout,
+		OnError:  config.OnError,
+		Clock:    config.Clock,
+	})
+	if err != nil {
+		return nil, err
+	}
+	config.Interval = counter.config.Interval
+
+	return &Hybrid{config: config, counter: counter, share: share}, nil
+}
+
+// AllowN reports whether key may use n units now, and records them if so.
+func (h *Hybrid) AllowN(key string, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	if h.Degraded() {
+		return h.allowDegraded(key, int64(n))
+	}
+
+	switch h.admit(key, int64(n)) {
+	case admitted:
+		return true
+	case overLimit:
+		return false
+	}
+
+	// Too much local usage is unconfirmed; see what the fleet did.
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+	if err := h.counter.SyncKey(ctx, key); err != nil {
+		return h.allowDegraded(key, int64(n))
+	}
+	return h.admit(key, int64(n)) == admitted
+}
+
+// admit records n units on key if the fleet estimate and drift allow.
+func (h *Hybrid) admit(key string, n int64) admission {
+	c := h.counter
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := c.key(key, h.config.Clock())
+	if k.used()+n > h.config.Limit {
+		return overLimit
+	}
+	if k.inflight+k.pending+n > h.config.MaxDrift {
+		return overDrift
+	}
+	c.add(k, n)
+	return admitted
+}
+
+// allowDegraded applies the Degrade policy. Admitted usage is still
+// counted, so it reaches the store once it is reachable again.
+func (h *Hybrid) allowDegraded(key string, n int64) bool {
+	switch h.config.Degrade {
+	case DegradeOpen:
+		h.counter.Add(key, n)
+		return true
+	case DegradeClosed:
+		return false
+	}
+
+	c := h.counter
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := c.key(key, h.config.Clock())
+	if k.local+n > h.share {
+		return false
+	}
+	c.add(k, n)
+	return true
+}
+
+// Available returns the units key may still use in the current window, as
+// far as this instance knows.
+func (h *Hybrid) Available(key string) int {
+	c := h.counter
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := c.key(key, h.config.Clock())
+	left := h.config.Limit - k.used()
+	if h.degraded() {
+		switch h.config.Degrade {
+		case DegradeOpen:
+			left = h.config.Limit
+		case DegradeClosed:
+			left = 0
+		default:
+			left = h.share - k.local
+		}
+	}
+	if left < 0 {
+		left = 0
+	}
+	return int(left)
+}
+
+// Degraded reports whether the store has been failing for StaleAfter.
+func (h *Hybrid) Degraded() bool {
+	h.counter.mu.Lock()
+	defer h.counter.mu.Unlock()
+	return h.degraded()
+}
+
+// degraded implements Degraded. counter.mu must be held.
+func (h *Hybrid) degraded() bool {
+	since := h.counter.failingSince
+	return !since.IsZero() && h.config.Clock().Sub(since) >= h.config.StaleAfter
+}
+
+// Reset forgets key's local usage that has not been sent. Usage already
+// recorded by the store stays until its window ends.
+func (h *Hybrid) Reset(key string) {
+	c := h.counter
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, key)
+}
+
+// Preload raises the fleet usage known for key, so Hydrate can prime a
+// Hybrid limiter at startup. Use HydrateConfig.Share 1, since the usage is
+// global. It returns used.
+func (h *Hybrid) Preload(key string, used int) int {
+	h.counter.Preload(key, int64(used))
+	return used
+}
+
+// RetryAfter returns how long until key may have more budget: its next
+// sync or the end of the window, whichever comes first.
+func (h *Hybrid) RetryAfter(key string) time.Duration {
+	now := h.config.Clock()
+	wait := WindowStart(now, h.config.Period).Add(h.config.Period).Sub(now)
+	if next := h.counter.NextSync(key); !next.IsZero() && next.Sub(now) < wait {
+		wait = next.Sub(now)
+	}
+	if wait < h.config.Interval.Min {
+		wait = h.config.Interval.Min
+	}
+	return wait
+}
+
+// Stats returns the store traffic so far.
+func (h *Hybrid) Stats() CounterStats {
+	return h.counter.Stats()
+}
+
+// Close stops the sync loop and sends remaining usage.
+func (h *Hybrid) Close() error {
+	return h.counter.Close()
+}
+
+// Limiter returns a ratelimit.Limiter view of a single key.
+func (h *Hybrid) Limiter(key string) ratelimit.Limiter {
+	return &hybridLimiter{hybrid: h, key: key}
+}
+
+// hybridLimiter adapts one key of a Hybrid to ratelimit.Limiter.
+type hybridLimiter struct {
+	hybrid *Hybrid
+	key    string
+}
+
+func (l *hybridLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+func (l *hybridLimiter) AllowN(n int) bool {
+	return l.hybrid.AllowN(l.key, n)
+}
+
+func (l *hybridLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN polls until n units are admitted or ctx is done, sleeping for
+// RetryAfter between attempts.
+func (l *hybridLimiter) WaitN(ctx context.Context, n int) error {
+	if int64(n) > l.hybrid.config.Limit {
+		return fmt.Errorf("requested %d exceeds rate limit %d", n, l.hybrid.config.Limit)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if l.hybrid.AllowN(l.key, n) {
+			return nil
+		}
+
+		timer := time.NewTimer(l.hybrid.RetryAfter(l.key))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *hybridLimiter) Reset() {
+	l.hybrid.Reset(l.key)
+}
+
+func (l *hybridLimiter) Available() int {
+	return l.hybrid.Available(l.key)
+}
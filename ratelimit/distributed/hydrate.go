@@ -0,0 +1,70 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// HydrateConfig configures Hydrate.
+type HydrateConfig struct {
+	// Period is the usage window length, matching the one used with Add.
+	Period time.Duration
+
+	// TopN is how many of the hottest keys to load. Defaults to 1000.
+	TopN int
+
+	// Share is the fraction of the global usage charged to this instance,
+	// typically 1/N for N instances each enforcing a local share of the
+	// limit. Defaults to 1.
+	Share float64
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// Preloader receives hydrated usage. *ratelimit.Middleware implements it.
+type Preloader interface {
+	// Preload creates the limiter for key if needed and consumes up to
+	// used units of its budget, returning the units consumed.
+	Preload(key string, used int) int
+}
+
+// Hydrate charges the current window's usage of the hottest keys in store
+// to local limiters. Call it at startup, before serving traffic, so a fresh
+// instance does not grant the keys that are busiest right now a full
+// budget while the rest of the fleet has already spent most of it.
+//
+// It returns the number of keys hydrated. Keys whose local share rounds
+// down to zero are skipped.
+func Hydrate(ctx context.Context, store Store, to Preloader, config HydrateConfig) (int, error) {
+	if config.Period <= 0 {
+		return 0, errors.New("distributed: hydrate period must be positive")
+	}
+	if config.TopN <= 0 {
+		config.TopN = 1000
+	}
+	if config.Share <= 0 {
+		config.Share = 1
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	hot, err := store.Hot(ctx, WindowStart(config.Clock(), config.Period), config.TopN)
+	if err != nil {
+		return 0, err
+	}
+
+	hydrated := 0
+	for _, u := range hot {
+		used := int(math.Floor(float64(u.Used) * config.Share))
+		if used <= 0 {
+			continue
+		}
+		to.Preload(u.Key, used)
+		hydrated++
+	}
+	return hydrated, nil
+}
@@ -0,0 +1,77 @@
+package distributed
+
+import "time"
+
+// AdaptiveInterval chooses how long a key may go between syncs with the
+// store. Keys far below their global limit sync rarely, saving store
+// traffic; keys near the limit sync often, since usage the instance has not
+// yet seen is what lets the fleet overshoot.
+type AdaptiveInterval struct {
+	// Min is the interval used at or near the limit. Defaults to 50ms.
+	Min time.Duration
+
+	// Max is the interval used for idle keys. Defaults to 5s.
+	Max time.Duration
+
+	// Edge is the fraction of the limit left at which Min applies.
+	// Between Edge and full headroom the interval grows linearly to Max.
+	// Defaults to 0.1.
+	Edge float64
+
+	// Overshoot bounds the units the fleet may consume between two syncs
+	// of a key, as a fraction of the remaining headroom, given the global
+	// rate observed at the last sync. Defaults to 0.5.
+	Overshoot float64
+}
+
+func (a AdaptiveInterval) withDefaults() AdaptiveInterval {
+	if a.Min <= 0 {
+		a.Min = 50 * time.Millisecond
+	}
+	if a.Max <= 0 {
+		a.Max = 5 * time.Second
+	}
+	if a.Max < a.Min {
+		a.Max = a.Min
+	}
+	if a.Edge <= 0 || a.Edge >= 1 {
+		a.Edge = 0.1
+	}
+	if a.Overshoot <= 0 {
+		a.Overshoot = 0.5
+	}
+	return a
+}
+
+// Next returns the interval until the next sync of a key that has used
+// used of limit units in the current window, with the fleet consuming rate
+// units per second. A limit of zero or less means unlimited, and Max is
+// returned.
+func (a AdaptiveInterval) Next(used, limit int64, rate float64) time.Duration {
+	a = a.withDefaults()
+	if limit <= 0 {
+		return a.Max
+	}
+
+	headroom := 1 - float64(used)/float64(limit)
+	if headroom <= a.Edge {
+		return a.Min
+	}
+
+	interval := a.Min + time.Duration(float64(a.Max-a.Min)*(headroom-a.Edge)/(1-a.Edge))
+
+	// Sync before the fleet could eat into more than Overshoot of what is
+	// left at the current rate.
+	if rate > 0 {
+		remaining := float64(limit - used)
+		bound := time.Duration(remaining * a.Overshoot / rate * float64(time.Second))
+		if bound < interval {
+			interval = bound
+		}
+	}
+
+	if interval < a.Min {
+		interval = a.Min
+	}
+	return interval
+}
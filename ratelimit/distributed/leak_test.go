@@ -0,0 +1,58 @@
+package distributed_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/internal/leakcheck"
+	"github.com/rRateLimit/client/ratelimit/distributed"
+)
+
+func TestCounterCloseStopsGoroutines(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	c, err := distributed.NewCounter(distributed.CounterConfig{Store: distributed.NewMemoryStore(), Period: time.Second, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add("a", 1)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+}
+
+func TestHybridCloseStopsGoroutines(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	h, err := distributed.NewHybrid(distributed.HybridConfig{Store: distributed.NewMemoryStore(), Period: time.Second, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Limiter("a").Allow()
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaserCloseStopsGoroutines(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	coordinator, err := distributed.NewStoreCoordinator(distributed.StoreCoordinatorConfig{Store: distributed.NewMemoryStore(), Limit: 100, Period: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := distributed.NewLeaser(distributed.LeaserConfig{Coordinator: coordinator, Limit: 100, Period: time.Second, Block: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := l.Limiter("a")
+	for i := 0; i < 20; i++ {
+		limiter.Allow()
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,248 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Lease is a block of tokens for one key that a node may spend locally
+// until Expires, the end of the window it was granted from.
+type Lease struct {
+	Key     string    `json:"key"`
+	Window  time.Time `json:"window"`
+	Tokens  int64     `json:"tokens"`
+	Expires time.Time `json:"expires"`
+}
+
+// LeaseCoordinator hands out token leases to nodes. Implementations must
+// be safe for concurrent use.
+type LeaseCoordinator interface {
+	// Lease grants up to want tokens of key from the current window. The
+	// lease holds fewer when the window's budget is nearly spent, and none
+	// when it is gone.
+	Lease(ctx context.Context, key string, want int64) (Lease, error)
+
+	// Surrender gives the tokens in lease back to its window. Tokens of a
+	// window that has ended are dropped.
+	Surrender(ctx context.Context, lease Lease) error
+}
+
+// StoreCoordinatorConfig configures a StoreCoordinator.
+type StoreCoordinatorConfig struct {
+	// Store counts the tokens leased per key and window. It must accept a
+	// negative n in Add, which every store in this package does.
+	Store Store
+
+	// Limit is the number of tokens each key may lease per Period across
+	// all nodes.
+	Limit int64
+
+	// Period is the window length.
+	Period time.Duration
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// StoreCoordinator is a LeaseCoordinator that counts leased tokens in a
+// Store, so any number of coordinators, or nodes using it directly, share
+// the per-key budget. A lease costs one store round trip, or two when it
+// is cut short by the limit.
+type StoreCoordinator struct {
+	config StoreCoordinatorConfig
+}
+
+// NewStoreCoordinator creates a StoreCoordinator.
+func NewStoreCoordinator(config StoreCoordinatorConfig) (*StoreCoordinator, error) {
+	if config.Store == nil {
+		return nil, errors.New("distributed: lease coordinator needs a store")
+	}
+	if config.Limit <= 0 {
+		return nil, errors.New("distributed: lease limit must be positive")
+	}
+	if config.Period <= 0 {
+		return nil, errors.New("distributed: lease period must be positive")
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+	return &StoreCoordinator{config: config}, nil
+}
+
+// Lease implements LeaseCoordinator. The tokens are counted first and the
+// share over the limit taken back, so concurrent leases never grant more
+// than Limit between them.
+func (c *StoreCoordinator) Lease(ctx context.Context, key string, want int64) (Lease, error) {
+	window := WindowStart(c.config.Clock(), c.config.Period)
+	lease := Lease{Key: key, Window: window, Expires: window.Add(c.config.Period)}
+	if want <= 0 {
+		return lease, nil
+	}
+
+	total, err := c.config.Store.Add(ctx, key, window, want, c.ttl())
+	if err != nil {
+		return lease, err
+	}
+	lease.Tokens = want
+	if over := total - c.config.Limit; over > 0 {
+		if over > want {
+			over = want
+		}
+		if _, err := c.config.Store.Add(ctx, key, window, -over, c.ttl()); err != nil {
+			return lease, err
+		}
+		lease.Tokens -= over
+	}
+	return lease, nil
+}
+
+// Surrender implements LeaseCoordinator.
+func (c *StoreCoordinator) Surrender(ctx context.Context, lease Lease) error {
+	if lease.Tokens <= 0 || !c.config.Clock().Before(lease.Expires) {
+		return nil
+	}
+	_, err := c.config.Store.Add(ctx, lease.Key, lease.Window, -lease.Tokens, c.ttl())
+	return err
+}
+
+// ttl keeps a window's count past its end to allow for clock skew
+// between nodes.
+func (c *StoreCoordinator) ttl() time.Duration {
+	return 2 * c.config.Period
+}
+
+// leaseRequest is the body accepted by POST /lease.
+type leaseRequest struct {
+	Key  string `json:"key"`
+	Want int64  `json:"want"`
+}
+
+// LeaseHandler serves a LeaseCoordinator to nodes over HTTP:
+//
+//	POST /lease      {"key","want"}, replies with the Lease
+//	POST /surrender  a Lease, replies 204
+//
+// Mount it under a prefix with http.StripPrefix and point
+// HTTPCoordinator at the prefix.
+func LeaseHandler(c LeaseCoordinator) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/lease", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req leaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+			http.Error(w, "invalid lease request", http.StatusBadRequest)
+			return
+		}
+		lease, err := c.Lease(r.Context(), req.Key, req.Want)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lease)
+	})
+
+	mux.HandleFunc("/surrender", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var lease Lease
+		if err := json.NewDecoder(r.Body).Decode(&lease); err != nil || lease.Key == "" {
+			http.Error(w, "invalid lease", http.StatusBadRequest)
+			return
+		}
+		if err := c.Surrender(r.Context(), lease); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+// LeaseError is a non-success reply from a LeaseHandler.
+type LeaseError struct {
+	Status  int
+	Message string
+}
+
+func (e *LeaseError) Error() string {
+	return fmt.Sprintf("distributed: lease coordinator: %s (status %d)", e.Message, e.Status)
+}
+
+// HTTPCoordinator is a LeaseCoordinator that forwards to a LeaseHandler,
+// so nodes need no access to the store.
+type HTTPCoordinator struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPCoordinator creates an HTTPCoordinator for the LeaseHandler
+// mounted at url. A nil client uses http.DefaultClient.
+func NewHTTPCoordinator(url string, client *http.Client) *HTTPCoordinator {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPCoordinator{url: strings.TrimSuffix(url, "/"), client: client}
+}
+
+// Lease implements LeaseCoordinator.
+func (c *HTTPCoordinator) Lease(ctx context.Context, key string, want int64) (Lease, error) {
+	var lease Lease
+	err := c.post(ctx, "/lease", leaseRequest{Key: key, Want: want}, &lease)
+	return lease, err
+}
+
+// Surrender implements LeaseCoordinator.
+func (c *HTTPCoordinator) Surrender(ctx context.Context, lease Lease) error {
+	if lease.Tokens <= 0 {
+		return nil
+	}
+	return c.post(ctx, "/surrender", lease, nil)
+}
+
+func (c *HTTPCoordinator) post(ctx context.Context, path string, body, resp interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+		leaseErr := &LeaseError{Status: res.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if leaseErr.Message == "" {
+			leaseErr.Message = res.Status
+		}
+		return leaseErr
+	}
+
+	if resp == nil {
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
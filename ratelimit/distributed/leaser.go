@@ -0,0 +1,457 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// LeaserConfig configures a Leaser.
+type LeaserConfig struct {
+	// Coordinator grants the leases.
+	Coordinator LeaseCoordinator
+
+	// Limit is the number of tokens each key may use per Period across
+	// all nodes. It must match the coordinator's; the Leaser only uses it
+	// to reject requests that could never be granted.
+	Limit int64
+
+	// Period is the window length. It must match the coordinator's.
+	Period time.Duration
+
+	// Block is how many tokens a node leases for a key at a time. Larger
+	// blocks mean fewer coordinator round trips but coarser sharing
+	// between nodes. Defaults to a hundredth of Limit, at least 1.
+	Block int64
+
+	// RenewBelow is the number of local tokens under which a key's lease
+	// is topped up in the background, so requests rarely wait for the
+	// coordinator. Defaults to half of Block.
+	RenewBelow int64
+
+	// Timeout bounds each coordinator call. Defaults to 1s.
+	Timeout time.Duration
+
+	// OnError is called with coordinator errors. May be nil.
+	OnError func(error)
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// LeaserStats reports how a Leaser used its coordinator.
+type LeaserStats struct {
+	// Leases is the number of leases taken while a request waited.
+	Leases int64 `json:"leases"`
+
+	// Renewals is the number of leases taken in the background.
+	Renewals int64 `json:"renewals"`
+
+	// Surrendered is the number of unspent tokens given back.
+	Surrendered int64 `json:"surrendered"`
+}
+
+// Leaser is the node side of token leasing. It leases blocks of tokens
+// per key from a LeaseCoordinator and spends them locally, so most
+// requests cost no round trip. When a key's local tokens fall below
+// RenewBelow another block is leased in the background; only a request
+// that finds too few tokens waits for the coordinator. Once the
+// coordinator has no tokens left for a key, requests are rejected locally
+// until the window ends.
+//
+// Leased tokens lapse at the end of their window. Reset and Close give
+// unspent tokens back so other nodes can use them.
+type Leaser struct {
+	config LeaserConfig
+
+	mu    sync.Mutex
+	keys  map[string]*leasedKey
+	stats LeaserStats
+
+	renewals chan string
+	done     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// leasedKey is one key's local share.
+type leasedKey struct {
+	lease    Lease // Tokens holds the unspent local tokens
+	spent    bool  // the coordinator had none left in lease.Window
+	renewing bool
+	lastUsed time.Time
+
+	refill sync.Mutex // serializes the key's coordinator calls
+}
+
+// NewLeaser creates a Leaser and starts its background renewals. Call
+// Close to stop them and surrender unspent tokens.
+func NewLeaser(config LeaserConfig) (*Leaser, error) {
+	if config.Coordinator == nil {
+		return nil, errors.New("distributed: leaser needs a coordinator")
+	}
+	if config.Limit <= 0 {
+		return nil, errors.New("distributed: lease limit must be positive")
+	}
+	if config.Period <= 0 {
+		return nil, errors.New("distributed: lease period must be positive")
+	}
+	if config.Block <= 0 {
+		config.Block = config.Limit / 100
+		if config.Block < 1 {
+			config.Block = 1
+		}
+	}
+	if config.RenewBelow <= 0 {
+		config.RenewBelow = config.Block / 2
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	l := &Leaser{
+		config:   config,
+		keys:     make(map[string]*leasedKey),
+		renewals: make(chan string, 64),
+		done:     make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.loop()
+	return l, nil
+}
+
+// key returns key's state, creating it on first use. The caller must hold
+// l.mu.
+func (l *Leaser) key(key string, now time.Time) *leasedKey {
+	k, ok := l.keys[key]
+	if !ok {
+		k = &leasedKey{}
+		l.keys[key] = k
+	}
+	if !now.Before(k.lease.Expires) {
+		// The window ended: unspent tokens lapse.
+		k.lease.Tokens = 0
+		k.spent = false
+	}
+	return k
+}
+
+// take spends n local tokens of key if there are enough, and schedules a
+// renewal when the rest runs low. It returns the key and whether the
+// coordinator is worth asking for more.
+func (l *Leaser) take(key string, n int64) (bool, *leasedKey, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.config.Clock()
+	k := l.key(key, now)
+	k.lastUsed = now
+	if k.lease.Tokens < n {
+		return false, k, !k.spent
+	}
+	k.lease.Tokens -= n
+
+	if k.lease.Tokens < l.config.RenewBelow && !k.spent && !k.renewing {
+		select {
+		case l.renewals <- key:
+			k.renewing = true
+		default:
+			// The renewal queue is full; a later request retries.
+		}
+	}
+	return true, k, true
+}
+
+// add merges a new lease into k. The caller must hold l.mu.
+func (l *Leaser) add(k *leasedKey, lease Lease) {
+	switch {
+	case lease.Window.After(k.lease.Window):
+		k.lease = lease
+		k.spent = false
+	case lease.Window.Equal(k.lease.Window):
+		k.lease.Tokens += lease.Tokens
+	default:
+		// Granted from a window that has since ended.
+		return
+	}
+	if lease.Tokens == 0 {
+		k.spent = true
+	}
+}
+
+// Allow is shorthand for AllowN(key, 1).
+func (l *Leaser) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether key may use n tokens now, and spends them if so.
+// When the local tokens run short it leases at least Block more first.
+func (l *Leaser) AllowN(key string, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	ok, k, ask := l.take(key, int64(n))
+	if ok || !ask {
+		return ok
+	}
+
+	k.refill.Lock()
+	defer k.refill.Unlock()
+
+	// A renewal or another caller may have leased while this one waited.
+	ok, _, ask = l.take(key, int64(n))
+	if ok || !ask {
+		return ok
+	}
+
+	l.mu.Lock()
+	want := int64(n) - k.lease.Tokens
+	l.mu.Unlock()
+	if want < l.config.Block {
+		want = l.config.Block
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+	lease, err := l.config.Coordinator.Lease(ctx, key, want)
+	if err != nil {
+		l.report(err)
+		return false
+	}
+
+	l.mu.Lock()
+	l.stats.Leases++
+	l.add(l.key(key, l.config.Clock()), lease)
+	l.mu.Unlock()
+
+	ok, _, _ = l.take(key, int64(n))
+	return ok
+}
+
+// renew tops up key's lease by a Block.
+func (l *Leaser) renew(key string) {
+	l.mu.Lock()
+	k := l.key(key, l.config.Clock())
+	l.mu.Unlock()
+
+	k.refill.Lock()
+	defer k.refill.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+	lease, err := l.config.Coordinator.Lease(ctx, key, l.config.Block)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k = l.key(key, l.config.Clock())
+	k.renewing = false
+	if err != nil {
+		l.report(err)
+		return
+	}
+	l.stats.Renewals++
+	l.add(k, lease)
+}
+
+// Available returns the tokens key holds locally. It makes no round trip.
+func (l *Leaser) Available(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k, ok := l.keys[key]
+	if !ok || !l.config.Clock().Before(k.lease.Expires) {
+		return 0
+	}
+	return int(k.lease.Tokens)
+}
+
+// Reset surrenders key's unspent tokens.
+func (l *Leaser) Reset(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+	if err := l.surrender(ctx, key); err != nil {
+		l.report(err)
+	}
+}
+
+// surrender gives key's unspent tokens back to the coordinator.
+func (l *Leaser) surrender(ctx context.Context, key string) error {
+	l.mu.Lock()
+	k, ok := l.keys[key]
+	if !ok {
+		l.mu.Unlock()
+		return nil
+	}
+	lease := k.lease
+	k.lease.Tokens = 0
+	k.spent = false
+	l.mu.Unlock()
+
+	if lease.Tokens <= 0 || !l.config.Clock().Before(lease.Expires) {
+		return nil
+	}
+	if err := l.config.Coordinator.Surrender(ctx, lease); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.stats.Surrendered += lease.Tokens
+	l.mu.Unlock()
+	return nil
+}
+
+// RetryAfter returns how long until key may be granted tokens again: the
+// end of the window once the coordinator has none left, otherwise a
+// tenth of the period.
+func (l *Leaser) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.config.Clock()
+	end := WindowStart(now, l.config.Period).Add(l.config.Period).Sub(now)
+	if k, ok := l.keys[key]; ok && k.spent && now.Before(k.lease.Expires) {
+		end = k.lease.Expires.Sub(now)
+	} else if end > l.config.Period/10 {
+		end = l.config.Period / 10
+	}
+	if end < time.Millisecond {
+		end = time.Millisecond
+	}
+	return end
+}
+
+// Stats returns the coordinator usage so far.
+func (l *Leaser) Stats() LeaserStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *Leaser) report(err error) {
+	if l.config.OnError != nil {
+		l.config.OnError(err)
+	}
+}
+
+// loop runs renewals and drops keys idle for a whole period.
+func (l *Leaser) loop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.config.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case key := <-l.renewals:
+			l.renew(key)
+		case <-ticker.C:
+			l.dropIdle()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// dropIdle forgets keys without local tokens that were not used for a
+// whole period.
+func (l *Leaser) dropIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.config.Clock()
+	for key, k := range l.keys {
+		if now.Sub(k.lastUsed) > l.config.Period && !k.renewing &&
+			(k.lease.Tokens == 0 || !now.Before(k.lease.Expires)) {
+			delete(l.keys, key)
+		}
+	}
+}
+
+// Close stops the background renewals, waits for them to finish and
+// surrenders every key's unspent tokens. The Leaser must not be used
+// afterwards. Later calls do nothing and return nil.
+func (l *Leaser) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		l.wg.Wait()
+
+		l.mu.Lock()
+		keys := make([]string, 0, len(l.keys))
+		for key := range l.keys {
+			keys = append(keys, key)
+		}
+		l.mu.Unlock()
+
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+			if surrenderErr := l.surrender(ctx, key); err == nil {
+				err = surrenderErr
+			}
+			cancel()
+		}
+	})
+	return err
+}
+
+// Limiter returns a ratelimit.Limiter view of a single key.
+func (l *Leaser) Limiter(key string) ratelimit.Limiter {
+	return &leasedLimiter{leaser: l, key: key}
+}
+
+// leasedLimiter adapts one key of a Leaser to ratelimit.Limiter.
+type leasedLimiter struct {
+	leaser *Leaser
+	key    string
+}
+
+func (l *leasedLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+func (l *leasedLimiter) AllowN(n int) bool {
+	return l.leaser.AllowN(l.key, n)
+}
+
+func (l *leasedLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN polls until n tokens are granted or ctx is done, sleeping for
+// RetryAfter between attempts.
+func (l *leasedLimiter) WaitN(ctx context.Context, n int) error {
+	if int64(n) > l.leaser.config.Limit {
+		return fmt.Errorf("requested %d exceeds rate limit %d", n, l.leaser.config.Limit)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if l.leaser.AllowN(l.key, n) {
+			return nil
+		}
+
+		timer := time.NewTimer(l.leaser.RetryAfter(l.key))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *leasedLimiter) Reset() {
+	l.leaser.Reset(l.key)
+}
+
+func (l *leasedLimiter) Available() int {
+	return l.leaser.Available(l.key)
+}
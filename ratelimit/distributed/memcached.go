@@ -0,0 +1,493 @@
+package distributed
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemcachedConfig configures a MemcachedStore.
+type MemcachedConfig struct {
+	// Address is the memcached server, e.g. "localhost:11211".
+	Address string
+
+	// Prefix is prepended to every key. Defaults to "ratelimit:".
+	Prefix string
+
+	// HotKeys is how many of the most used keys each window's hot list
+	// keeps for Hot. Defaults to 100.
+	HotKeys int
+
+	// MaxIdleConns is how many idle connections to keep. Defaults to 8.
+	MaxIdleConns int
+
+	// Timeout bounds each command round trip when the context has no
+	// earlier deadline. Defaults to 500ms.
+	Timeout time.Duration
+}
+
+// MemcachedStore is a Store backed by memcached, for deployments that
+// already run memcached fleets. It speaks the memcached text protocol
+// directly, so it needs no client library. It is safe for concurrent use.
+//
+// Each key's usage is a counter per window, changed with incr and decr
+// and created with add, so concurrent instances never lose an update.
+// memcached has no sorted sets, so each window also has a hot list of its
+// HotKeys most used keys, updated with gets and cas:
+//
+//	<prefix>u:<window ms>:<key>   usage counter
+//	<prefix>hot:<window ms>       JSON list of the hottest keys, for Hot
+//
+// Keys that memcached cannot store as is (too long, or containing spaces
+// or control characters) are replaced by their SHA-1 in counter names.
+type MemcachedStore struct {
+	config MemcachedConfig
+	idle   chan *memcachedConn
+	closed chan struct{}
+	once   sync.Once
+
+	// hotMin caches, per window, the usage a key needs to enter the hot
+	// list, so most Adds skip reading it.
+	mu     sync.Mutex
+	hotMin map[int64]int64
+}
+
+// memcachedConn is a pooled connection.
+type memcachedConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// MemcachedError is an error reply from memcached.
+type MemcachedError string
+
+func (e MemcachedError) Error() string {
+	return "memcached: " + string(e)
+}
+
+// errNotFound and errNotStored are the NOT_FOUND and NOT_STORED/EXISTS
+// replies, which the store handles itself.
+var (
+	errNotFound  = errors.New("memcached: not found")
+	errNotStored = errors.New("memcached: not stored")
+)
+
+// NewMemcachedStore creates a store. Connections are opened lazily.
+func NewMemcachedStore(config MemcachedConfig) *MemcachedStore {
+	if config.Prefix == "" {
+		config.Prefix = "ratelimit:"
+	}
+	if config.HotKeys <= 0 {
+		config.HotKeys = 100
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = 8
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 500 * time.Millisecond
+	}
+
+	return &MemcachedStore{
+		config: config,
+		idle:   make(chan *memcachedConn, config.MaxIdleConns),
+		closed: make(chan struct{}),
+		hotMin: make(map[int64]int64),
+	}
+}
+
+// Close closes all idle connections. In-flight commands finish normally.
+func (s *MemcachedStore) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		for {
+			select {
+			case mc := <-s.idle:
+				mc.conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Ping checks that memcached is reachable.
+func (s *MemcachedStore) Ping(ctx context.Context) error {
+	line, err := s.do(ctx, "version\r\n")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "VERSION ") {
+		return fmt.Errorf("memcached: unexpected reply %q to version", line)
+	}
+	return nil
+}
+
+// Add implements Store. A negative n is applied with decr, which memcached
+// stops at zero.
+func (s *MemcachedStore) Add(ctx context.Context, key string, window time.Time, n int64, ttl time.Duration) (int64, error) {
+	ms := strconv.FormatInt(window.UnixMilli(), 10)
+	name := s.config.Prefix + "u:" + ms + ":" + safeKey(key)
+
+	cmd, delta := "incr", n
+	if n < 0 {
+		cmd, delta = "decr", -n
+	}
+	for {
+		used, err := s.counter(ctx, cmd, name, delta)
+		if err == errNotFound {
+			// First use in the window: create the counter. If another
+			// instance wins the race, add fails and incr is retried.
+			initial := n
+			if initial < 0 {
+				initial = 0
+			}
+			err = s.store(ctx, "add", name, strconv.FormatInt(initial, 10), ttl, 0)
+			if err == errNotStored {
+				continue
+			}
+			used = initial
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if n > 0 {
+			if err := s.promote(ctx, window, key, used, ttl); err != nil {
+				return used, err
+			}
+		}
+		return used, nil
+	}
+}
+
+// Hot implements Store.
+func (s *MemcachedStore) Hot(ctx context.Context, window time.Time, n int) ([]Usage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	hot, _, err := s.hotList(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot, nil
+}
+
+// promote records key's usage in the window's hot list if it qualifies.
+func (s *MemcachedStore) promote(ctx context.Context, window time.Time, key string, used int64, ttl time.Duration) error {
+	ms := window.UnixMilli()
+	s.mu.Lock()
+	threshold, known := s.hotMin[ms]
+	s.mu.Unlock()
+	if known && used <= threshold {
+		return nil
+	}
+
+	name := s.config.Prefix + "hot:" + strconv.FormatInt(ms, 10)
+	for {
+		hot, cas, err := s.hotList(ctx, window)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for i := range hot {
+			if hot[i].Key == key {
+				if hot[i].Used >= used {
+					return nil
+				}
+				hot[i].Used = used
+				found = true
+				break
+			}
+		}
+		if !found {
+			hot = append(hot, Usage{Key: key, Used: used})
+		}
+		sortUsage(hot)
+		if len(hot) > s.config.HotKeys {
+			hot = hot[:s.config.HotKeys]
+		}
+
+		data, err := json.Marshal(hot)
+		if err != nil {
+			return err
+		}
+		if cas == 0 {
+			err = s.store(ctx, "add", name, string(data), ttl, 0)
+		} else {
+			err = s.store(ctx, "cas", name, string(data), ttl, cas)
+		}
+		if err == errNotStored || err == errNotFound {
+			// Another instance changed the list first.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.setHotMin(ms, hot)
+		return nil
+	}
+}
+
+// setHotMin caches the usage needed to enter the window's hot list and
+// forgets windows older than the one before it.
+func (s *MemcachedStore) setHotMin(ms int64, hot []Usage) {
+	var threshold int64
+	if len(hot) >= s.config.HotKeys {
+		threshold = hot[len(hot)-1].Used
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hotMin[ms] = threshold
+	for w := range s.hotMin {
+		if w < ms && len(s.hotMin) > 2 {
+			delete(s.hotMin, w)
+		}
+	}
+}
+
+// hotList reads the window's hot list with its CAS value, which is zero if
+// the list does not exist.
+func (s *MemcachedStore) hotList(ctx context.Context, window time.Time) ([]Usage, uint64, error) {
+	name := s.config.Prefix + "hot:" + strconv.FormatInt(window.UnixMilli(), 10)
+	data, cas, err := s.gets(ctx, name)
+	if err == errNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var hot []Usage
+	if err := json.Unmarshal(data, &hot); err != nil {
+		return nil, 0, fmt.Errorf("memcached: invalid hot list %s: %w", name, err)
+	}
+	return hot, cas, nil
+}
+
+// counter runs incr or decr and returns the new value.
+func (s *MemcachedStore) counter(ctx context.Context, cmd, name string, delta int64) (int64, error) {
+	line, err := s.do(ctx, cmd+" "+name+" "+strconv.FormatInt(delta, 10)+"\r\n")
+	if err != nil {
+		return 0, err
+	}
+	if line == "NOT_FOUND" {
+		return 0, errNotFound
+	}
+	value, err := strconv.ParseUint(line, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("memcached: unexpected reply %q to %s", line, cmd)
+	}
+	return int64(value), nil
+}
+
+// store runs add or cas. A reply of NOT_STORED or EXISTS is returned as
+// errNotStored and NOT_FOUND as errNotFound.
+func (s *MemcachedStore) store(ctx context.Context, cmd, name, value string, ttl time.Duration, cas uint64) error {
+	header := cmd + " " + name + " 0 " + strconv.FormatInt(expiry(ttl), 10) + " " + strconv.Itoa(len(value))
+	if cmd == "cas" {
+		header += " " + strconv.FormatUint(cas, 10)
+	}
+	line, err := s.do(ctx, header+"\r\n"+value+"\r\n")
+	if err != nil {
+		return err
+	}
+
+	switch line {
+	case "STORED":
+		return nil
+	case "NOT_STORED", "EXISTS":
+		return errNotStored
+	case "NOT_FOUND":
+		return errNotFound
+	}
+	return fmt.Errorf("memcached: unexpected reply %q to %s", line, cmd)
+}
+
+// gets reads name with its CAS value.
+func (s *MemcachedStore) gets(ctx context.Context, name string) ([]byte, uint64, error) {
+	mc, err := s.get(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, cas, err := mc.gets(ctx, s.config.Timeout, name)
+	if err != nil && err != errNotFound {
+		var mcErr MemcachedError
+		if !errors.As(err, &mcErr) {
+			mc.conn.Close()
+			return nil, 0, err
+		}
+	}
+	s.put(mc)
+	return data, cas, err
+}
+
+// do sends cmd on a pooled connection and returns the reply line.
+func (s *MemcachedStore) do(ctx context.Context, cmd string) (string, error) {
+	mc, err := s.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	line, err := mc.roundTrip(ctx, s.config.Timeout, cmd)
+	if err != nil {
+		var mcErr MemcachedError
+		if !errors.As(err, &mcErr) {
+			// The connection state is unknown after an I/O error.
+			mc.conn.Close()
+			return "", err
+		}
+	}
+	s.put(mc)
+	return line, err
+}
+
+// get returns an idle connection or dials a new one.
+func (s *MemcachedStore) get(ctx context.Context) (*memcachedConn, error) {
+	select {
+	case <-s.closed:
+		return nil, ErrStoreClosed
+	case mc := <-s.idle:
+		return mc, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full.
+func (s *MemcachedStore) put(mc *memcachedConn) {
+	select {
+	case <-s.closed:
+		mc.conn.Close()
+	case s.idle <- mc:
+	default:
+		mc.conn.Close()
+	}
+}
+
+// roundTrip writes cmd and reads one reply line. Error replies are
+// returned as a MemcachedError and leave the connection usable.
+func (mc *memcachedConn) roundTrip(ctx context.Context, timeout time.Duration, cmd string) (string, error) {
+	mc.deadline(ctx, timeout)
+	if _, err := mc.conn.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+	return mc.readLine()
+}
+
+// gets runs gets for one key and returns its value and CAS value.
+func (mc *memcachedConn) gets(ctx context.Context, timeout time.Duration, name string) ([]byte, uint64, error) {
+	line, err := mc.roundTrip(ctx, timeout, "gets "+name+"\r\n")
+	if err != nil {
+		return nil, 0, err
+	}
+	if line == "END" {
+		return nil, 0, errNotFound
+	}
+
+	// VALUE <key> <flags> <bytes> <cas>
+	fields := strings.Fields(line)
+	if len(fields) != 5 || fields[0] != "VALUE" {
+		return nil, 0, fmt.Errorf("memcached: unexpected reply %q to gets", line)
+	}
+	size, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, 0, fmt.Errorf("memcached: malformed value length %q", fields[3])
+	}
+	cas, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("memcached: malformed cas %q", fields[4])
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(mc.reader, data); err != nil {
+		return nil, 0, err
+	}
+	if end, err := mc.readLine(); err != nil || end != "END" {
+		if err == nil {
+			err = fmt.Errorf("memcached: unexpected %q after value", end)
+		}
+		return nil, 0, err
+	}
+	return data[:size], cas, nil
+}
+
+// deadline bounds the next round trip by timeout or ctx, whichever ends
+// first.
+func (mc *memcachedConn) deadline(ctx context.Context, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	mc.conn.SetDeadline(deadline)
+}
+
+// readLine reads one reply line without its CRLF. ERROR, CLIENT_ERROR and
+// SERVER_ERROR replies are returned as a MemcachedError.
+func (mc *memcachedConn) readLine() (string, error) {
+	line, err := mc.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", MemcachedError(line)
+	}
+	return line, nil
+}
+
+// expiry converts ttl to a memcached expiration: whole seconds, rounded
+// up, at least one. Longer than 30 days would be read as a Unix time.
+func expiry(ttl time.Duration) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if max := int64(30 * 24 * 60 * 60); seconds > max {
+		seconds = max
+	}
+	return seconds
+}
+
+// safeKey returns key if memcached can store it within a counter name,
+// otherwise its SHA-1.
+func safeKey(key string) string {
+	if len(key) <= 200 {
+		ok := true
+		for i := 0; i < len(key); i++ {
+			if key[i] <= ' ' || key[i] == 0x7f {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return key
+		}
+	}
+	sum := sha1.Sum([]byte(key))
+	return "sha1-" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,519 @@
+package distributed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QuotaConfig configures a QuotaPool.
+type QuotaConfig struct {
+	// Etcd holds the pool.
+	Etcd *Etcd
+
+	// Name identifies the pool. Instances with the same Name share Limit.
+	Name string
+
+	// Limit is the number of tokens the pool hands out per Period across
+	// all instances.
+	Limit int64
+
+	// Period is the window length. The pool refills at the start of each
+	// window.
+	Period time.Duration
+
+	// Instance names this instance among the pool's members. Defaults to
+	// the host name with a random suffix.
+	Instance string
+
+	// Batch is how many tokens AllowN asks the pool for at a time. Larger
+	// batches mean fewer etcd round trips but coarser sharing. Defaults to
+	// a hundredth of Limit, at least 1.
+	Batch int64
+
+	// LeaseTTL is how long the pool keeps counting this instance as a
+	// member after it stops refreshing its lease. Defaults to 10s.
+	LeaseTTL time.Duration
+
+	// OnError is called with etcd errors from AllowN and the lease
+	// refresh. May be nil.
+	OnError func(error)
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// QuotaPool is a global token pool shared by the instances of a service
+// through etcd. Each instance draws tokens from the pool in batches with
+// RequestQuota and spends them locally, so requests cost no round trip
+// until the local batch runs out. Tokens not spent can be handed back
+// with ReturnQuota; Close does so for the local batch.
+//
+// The pool lives under the etcd client's prefix:
+//
+//	quota/<name>/members/<instance>   one key per live instance, attached
+//	                                  to the instance's lease
+//	quota/<name>/pool/<window ms>     tokens granted from the window, in
+//	                                  decimal, attached to a lease that
+//	                                  outlives the window
+//
+// A grant reads the window's key and the member count, sizes the grant,
+// and writes the new total in a transaction that only succeeds if the key
+// is unchanged, retrying on conflict. Instances that die stop being
+// counted as members once their lease expires; tokens they held lapse at
+// the end of the window.
+type QuotaPool struct {
+	config  QuotaConfig
+	members string // key prefix of the member keys
+
+	mu     sync.Mutex
+	window time.Time
+	tokens int64 // granted to this instance in window, not yet spent
+	taken  int64 // granted to this instance in window, net of returns
+	lease  int64
+
+	refill sync.Mutex // serializes AllowN's pool requests
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// Grant is a number of tokens drawn from a QuotaPool. The tokens may be
+// spent until the end of the window they were granted from.
+type Grant struct {
+	Window time.Time `json:"window"`
+	Tokens int64     `json:"tokens"`
+}
+
+// NewQuotaPool joins the pool named in config and starts refreshing this
+// instance's lease. Call Close to leave.
+func NewQuotaPool(ctx context.Context, config QuotaConfig) (*QuotaPool, error) {
+	if config.Etcd == nil {
+		return nil, errors.New("distributed: quota pool needs an etcd client")
+	}
+	if config.Name == "" {
+		return nil, errors.New("distributed: quota pool needs a name")
+	}
+	if config.Limit <= 0 {
+		return nil, errors.New("distributed: quota limit must be positive")
+	}
+	if config.Period <= 0 {
+		return nil, errors.New("distributed: quota period must be positive")
+	}
+	if config.Instance == "" {
+		config.Instance = instanceName()
+	}
+	if config.Batch <= 0 {
+		config.Batch = config.Limit / 100
+		if config.Batch < 1 {
+			config.Batch = 1
+		}
+	}
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = 10 * time.Second
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	q := &QuotaPool{
+		config:  config,
+		members: config.Etcd.config.Prefix + "quota/" + config.Name + "/members/",
+		done:    make(chan struct{}),
+	}
+	if err := q.join(ctx); err != nil {
+		return nil, err
+	}
+
+	q.wg.Add(1)
+	go q.loop()
+	return q, nil
+}
+
+// instanceName returns the host name with a random suffix.
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "instance"
+	}
+	var b [4]byte
+	rand.Read(b[:])
+	return host + "-" + hex.EncodeToString(b[:])
+}
+
+// join registers this instance as a member under a new lease.
+func (q *QuotaPool) join(ctx context.Context) error {
+	lease, err := q.config.Etcd.grant(ctx, q.config.LeaseTTL)
+	if err != nil {
+		return err
+	}
+	if err := q.config.Etcd.put(ctx, q.members+q.config.Instance, "", lease); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.lease = lease
+	q.mu.Unlock()
+	return nil
+}
+
+// poolKey returns the key counting the tokens granted from window.
+func (q *QuotaPool) poolKey(window time.Time) string {
+	return q.config.Etcd.config.Prefix + "quota/" + q.config.Name + "/pool/" +
+		strconv.FormatInt(window.UnixMilli(), 10)
+}
+
+// roll starts a new local window if the current one has ended. q.mu must
+// be held.
+func (q *QuotaPool) roll(now time.Time) {
+	window := WindowStart(now, q.config.Period)
+	if !window.Equal(q.window) {
+		q.window = window
+		q.tokens = 0
+		q.taken = 0
+	}
+}
+
+// RequestQuota draws up to want tokens from the pool for the current
+// window. The grant is this instance's fair share of what is left: the
+// remaining tokens split evenly between the live members, halved while
+// this instance has taken less than half of an even split of Limit and
+// raised by half once it has taken more than three quarters of it. It is
+// never more than want or what is left, and at least one token while any
+// are left. A grant of zero tokens means the pool is exhausted for the
+// window.
+//
+// The caller owns the granted tokens; they are not added to the batch
+// AllowN spends from.
+func (q *QuotaPool) RequestQuota(ctx context.Context, want int64) (Grant, error) {
+	if want <= 0 {
+		return Grant{Window: WindowStart(q.config.Clock(), q.config.Period)}, nil
+	}
+
+	etcd := q.config.Etcd
+	for {
+		now := q.config.Clock()
+		window := WindowStart(now, q.config.Period)
+		key := q.poolKey(window)
+		kv, err := etcd.get(ctx, key)
+		if err != nil {
+			return Grant{}, err
+		}
+		var granted, rev, lease int64
+		if kv != nil {
+			if granted, err = strconv.ParseInt(string(kv.Value), 10, 64); err != nil {
+				return Grant{}, fmt.Errorf("distributed: invalid pool value %q at %s", kv.Value, key)
+			}
+			rev, lease = kv.ModRevision, kv.Lease
+		}
+
+		left := q.config.Limit - granted
+		if left <= 0 {
+			return Grant{Window: window}, nil
+		}
+		members, err := etcd.count(ctx, q.members)
+		if err != nil {
+			return Grant{}, err
+		}
+		if members < 1 {
+			members = 1
+		}
+
+		q.mu.Lock()
+		q.roll(now)
+		taken := q.taken
+		q.mu.Unlock()
+
+		n := q.share(left, members, taken)
+		if n > want {
+			n = want
+		}
+
+		created := lease == 0
+		if created {
+			// The window's key expires a period after the window ends.
+			if lease, err = etcd.grant(ctx, 2*q.config.Period); err != nil {
+				return Grant{}, err
+			}
+		}
+		ok, err := etcd.swap(ctx, key, rev, strconv.FormatInt(granted+n, 10), lease)
+		if created && !ok {
+			// Another instance created the key; its lease is used instead.
+			etcd.revoke(ctx, lease)
+		}
+		if err != nil {
+			return Grant{}, err
+		}
+		if ok {
+			q.mu.Lock()
+			if q.window.Equal(window) {
+				q.taken += n
+			}
+			q.mu.Unlock()
+			return Grant{Window: window, Tokens: n}, nil
+		}
+		// Another instance changed the pool first; size the grant again.
+		if err := ctx.Err(); err != nil {
+			return Grant{}, err
+		}
+	}
+}
+
+// share sizes a grant from the left tokens for one of members instances
+// that has taken taken tokens in the window.
+func (q *QuotaPool) share(left, members, taken int64) int64 {
+	n := left / members
+	even := q.config.Limit / members
+	switch {
+	case taken < even/2:
+		n /= 2
+	case taken > even*3/4:
+		n = n * 3 / 2
+	}
+
+	if n < 1 {
+		n = 1
+	}
+	if n > left {
+		n = left
+	}
+	return n
+}
+
+// ReturnQuota gives unspent tokens of g back to the pool so other
+// instances can draw them. Tokens of a window that has ended have already
+// lapsed, so returning them does nothing.
+func (q *QuotaPool) ReturnQuota(ctx context.Context, g Grant) error {
+	if g.Tokens <= 0 || !g.Window.Equal(WindowStart(q.config.Clock(), q.config.Period)) {
+		return nil
+	}
+
+	etcd := q.config.Etcd
+	key := q.poolKey(g.Window)
+	for {
+		kv, err := etcd.get(ctx, key)
+		if err != nil || kv == nil {
+			return err
+		}
+		granted, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("distributed: invalid pool value %q at %s", kv.Value, key)
+		}
+
+		granted -= g.Tokens
+		if granted < 0 {
+			granted = 0
+		}
+		ok, err := etcd.swap(ctx, key, kv.ModRevision, strconv.FormatInt(granted, 10), kv.Lease)
+		if err != nil {
+			return err
+		}
+		if ok {
+			q.mu.Lock()
+			if q.window.Equal(g.Window) {
+				q.taken -= g.Tokens
+			}
+			q.mu.Unlock()
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// take spends n local tokens if there are enough. It returns how many
+// tokens are left locally.
+func (q *QuotaPool) take(n int64) (bool, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll(q.config.Clock())
+	if q.tokens < n {
+		return false, q.tokens
+	}
+	q.tokens -= n
+	return true, q.tokens
+}
+
+// Allow is shorthand for AllowN(1).
+func (q *QuotaPool) Allow() bool {
+	return q.AllowN(1)
+}
+
+// AllowN reports whether n tokens may be spent now, and spends them if so.
+// When the local batch runs short it draws at least Batch more from the
+// pool first.
+func (q *QuotaPool) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+	ok, have := q.take(int64(n))
+	if ok {
+		return true
+	}
+
+	q.refill.Lock()
+	defer q.refill.Unlock()
+
+	// Another caller may have refilled while this one waited.
+	if ok, have = q.take(int64(n)); ok {
+		return true
+	}
+
+	want := int64(n) - have
+	if want < q.config.Batch {
+		want = q.config.Batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.Etcd.config.Timeout)
+	defer cancel()
+	g, err := q.RequestQuota(ctx, want)
+	if err != nil {
+		q.report(err)
+		return false
+	}
+
+	q.mu.Lock()
+	q.roll(q.config.Clock())
+	if q.window.Equal(g.Window) {
+		q.tokens += g.Tokens
+	}
+	q.mu.Unlock()
+
+	ok, _ = q.take(int64(n))
+	return ok
+}
+
+// Wait is shorthand for WaitN(ctx, 1).
+func (q *QuotaPool) Wait(ctx context.Context) error {
+	return q.WaitN(ctx, 1)
+}
+
+// WaitN polls until n tokens are granted or ctx is done. Between attempts
+// it sleeps a tenth of the period, or until the window ends if sooner.
+func (q *QuotaPool) WaitN(ctx context.Context, n int) error {
+	if int64(n) > q.config.Limit {
+		return fmt.Errorf("requested %d exceeds rate limit %d", n, q.config.Limit)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if q.AllowN(n) {
+			return nil
+		}
+
+		now := q.config.Clock()
+		wait := WindowStart(now, q.config.Period).Add(q.config.Period).Sub(now)
+		if wait > q.config.Period/10 {
+			wait = q.config.Period / 10
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Reset gives the local batch back to the pool.
+func (q *QuotaPool) Reset() {
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.Etcd.config.Timeout)
+	defer cancel()
+	if err := q.returnLocal(ctx); err != nil {
+		q.report(err)
+	}
+}
+
+// returnLocal returns the unspent local tokens to the pool.
+func (q *QuotaPool) returnLocal(ctx context.Context) error {
+	q.mu.Lock()
+	q.roll(q.config.Clock())
+	g := Grant{Window: q.window, Tokens: q.tokens}
+	q.tokens = 0
+	q.mu.Unlock()
+
+	return q.ReturnQuota(ctx, g)
+}
+
+// Available returns the tokens left in the local batch.
+func (q *QuotaPool) Available() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll(q.config.Clock())
+	return int(q.tokens)
+}
+
+// Instance returns the name this instance is a member under.
+func (q *QuotaPool) Instance() string {
+	return q.config.Instance
+}
+
+func (q *QuotaPool) report(err error) {
+	if q.config.OnError != nil {
+		q.config.OnError(err)
+	}
+}
+
+// loop refreshes the lease three times per LeaseTTL and joins again if it
+// expired anyway.
+func (q *QuotaPool) loop() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.config.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		lease := q.lease
+		q.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), q.config.Etcd.config.Timeout)
+		err := q.config.Etcd.keepAlive(ctx, lease)
+		if errors.Is(err, ErrLeaseExpired) {
+			err = q.join(ctx)
+		}
+		cancel()
+		if err != nil {
+			q.report(err)
+		}
+	}
+}
+
+// Close stops refreshing the lease, returns the local batch to the pool
+// and leaves it.
+func (q *QuotaPool) Close() error {
+	var err error
+	q.once.Do(func() {
+		close(q.done)
+		q.wg.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), q.config.Etcd.config.Timeout)
+		defer cancel()
+		err = q.returnLocal(ctx)
+
+		q.mu.Lock()
+		lease := q.lease
+		q.mu.Unlock()
+		if revokeErr := q.config.Etcd.revoke(ctx, lease); err == nil {
+			err = revokeErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,317 @@
+package distributed
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisConfig configures a RedisStore.
+type RedisConfig struct {
+	// Address is the Redis server, e.g. "localhost:6379".
+	Address string
+
+	// Password, if set, is sent with AUTH on every new connection.
+	Password string
+
+	// DB selects the database on every new connection.
+	DB int
+
+	// Prefix is prepended to every key. Defaults to "ratelimit:".
+	Prefix string
+
+	// MaxIdleConns is how many idle connections to keep. Defaults to 8.
+	MaxIdleConns int
+
+	// Timeout bounds each command round trip when the context has no
+	// earlier deadline. Defaults to 500ms.
+	Timeout time.Duration
+}
+
+// RedisStore is a Store backed by Redis. It speaks the Redis protocol
+// directly, so it needs no client library. It is safe for concurrent use.
+//
+// Each key's usage lives in a counter and in a sorted set per window, both
+// expiring with the window:
+//
+//	<prefix>u:<window ms>:<key>   usage counter
+//	<prefix>hot:<window ms>       key -> usage, for Hot
+type RedisStore struct {
+	config RedisConfig
+	idle   chan *redisConn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// redisConn is a pooled connection.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	buf    []byte
+}
+
+// ErrStoreClosed is returned by commands issued after Close.
+var ErrStoreClosed = errors.New("distributed: store closed")
+
+// RedisError is an error reply from Redis.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// addScript increments the counter and the hot set atomically.
+const addScript = `
+local used = redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+redis.call('ZINCRBY', KEYS[2], ARGV[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[2], ARGV[2])
+return used
+`
+
+// NewRedisStore creates a store. Connections are opened lazily.
+func NewRedisStore(config RedisConfig) *RedisStore {
+	if config.Prefix == "" {
+		config.Prefix = "ratelimit:"
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = 8
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 500 * time.Millisecond
+	}
+
+	return &RedisStore{
+		config: config,
+		idle:   make(chan *redisConn, config.MaxIdleConns),
+		closed: make(chan struct{}),
+	}
+}
+
+// Close closes all idle connections. In-flight commands finish normally.
+func (s *RedisStore) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		for {
+			select {
+			case rc := <-s.idle:
+				rc.conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Ping checks that Redis is reachable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	_, err := s.do(ctx, "PING")
+	return err
+}
+
+// Add implements Store.
+func (s *RedisStore) Add(ctx context.Context, key string, window time.Time, n int64, ttl time.Duration) (int64, error) {
+	ms := strconv.FormatInt(window.UnixMilli(), 10)
+	reply, err := s.do(ctx, "EVAL", addScript, "2",
+		s.config.Prefix+"u:"+ms+":"+key,
+		s.config.Prefix+"hot:"+ms,
+		strconv.FormatInt(n, 10),
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+		key)
+	if err != nil {
+		return 0, err
+	}
+
+	used, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %T to EVAL", reply)
+	}
+	return used, nil
+}
+
+// Hot implements Store.
+func (s *RedisStore) Hot(ctx context.Context, window time.Time, n int) ([]Usage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	ms := strconv.FormatInt(window.UnixMilli(), 10)
+	reply, err := s.do(ctx, "ZREVRANGE", s.config.Prefix+"hot:"+ms, "0", strconv.Itoa(n-1), "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok || len(items)%2 != 0 {
+		return nil, fmt.Errorf("redis: unexpected reply to ZREVRANGE")
+	}
+
+	hot := make([]Usage, 0, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		key, _ := items[i].(string)
+		score, _ := items[i+1].(string)
+		used, err := strconv.ParseFloat(score, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid score %q for %q", score, key)
+		}
+		hot = append(hot, Usage{Key: key, Used: int64(used)})
+	}
+	return hot, nil
+}
+
+// do sends one command on a pooled connection and reads its reply.
+func (s *RedisStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	rc, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.roundTrip(ctx, s.config.Timeout, args)
+	if err != nil {
+		var redisErr RedisError
+		if !errors.As(err, &redisErr) {
+			// The connection state is unknown after an I/O error.
+			rc.conn.Close()
+			return nil, err
+		}
+	}
+	s.put(rc)
+	return reply, err
+}
+
+// get returns an idle connection or dials and prepares a new one.
+func (s *RedisStore) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case <-s.closed:
+		return nil, ErrStoreClosed
+	case rc := <-s.idle:
+		return rc, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if s.config.Password != "" {
+		if _, err := rc.roundTrip(ctx, s.config.Timeout, []string{"AUTH", s.config.Password}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.config.DB != 0 {
+		if _, err := rc.roundTrip(ctx, s.config.Timeout, []string{"SELECT", strconv.Itoa(s.config.DB)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full.
+func (s *RedisStore) put(rc *redisConn) {
+	select {
+	case <-s.closed:
+		rc.conn.Close()
+	case s.idle <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+// roundTrip writes a command and reads its reply. An error reply is
+// returned as a RedisError and leaves the connection usable.
+func (rc *redisConn) roundTrip(ctx context.Context, timeout time.Duration, args []string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	rc.conn.SetDeadline(deadline)
+
+	rc.buf = rc.buf[:0]
+	rc.buf = append(rc.buf, '*')
+	rc.buf = strconv.AppendInt(rc.buf, int64(len(args)), 10)
+	rc.buf = append(rc.buf, '\r', '\n')
+	for _, arg := range args {
+		rc.buf = append(rc.buf, '$')
+		rc.buf = strconv.AppendInt(rc.buf, int64(len(arg)), 10)
+		rc.buf = append(rc.buf, '\r', '\n')
+		rc.buf = append(rc.buf, arg...)
+		rc.buf = append(rc.buf, '\r', '\n')
+	}
+	if _, err := rc.conn.Write(rc.buf); err != nil {
+		return nil, err
+	}
+
+	return readReply(rc.reader)
+}
+
+// readReply reads one RESP2 reply. Bulk strings are returned as string,
+// integers as int64, arrays as []interface{} and nil replies as nil.
+// Error replies inside arrays are returned as RedisError items.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RedisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readReply(r)
+			var redisErr RedisError
+			if errors.As(err, &redisErr) {
+				// Keep reading so the connection stays in sync.
+				item, err = redisErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
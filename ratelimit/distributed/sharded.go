@@ -0,0 +1,137 @@
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit/sharding"
+)
+
+// ShardedStore spreads keys over several stores, such as independent
+// Redis or memcached servers, by consistent hashing. Every key lives on
+// exactly one shard, so counters stay exact; instances agree on placement
+// as long as they list the same shard names and hash options.
+type ShardedStore struct {
+	ring *sharding.Ring
+
+	mu     sync.RWMutex
+	shards map[string]Store
+}
+
+// NewShardedStore creates a ShardedStore over shards, keyed by a stable
+// shard name. The name, not the store's address, decides placement, so a
+// shard can move to a new address without moving its keys.
+func NewShardedStore(shards map[string]Store, opts ...sharding.Option) *ShardedStore {
+	names := make([]string, 0, len(shards))
+	copied := make(map[string]Store, len(shards))
+	for name, store := range shards {
+		names = append(names, name)
+		copied[name] = store
+	}
+	return &ShardedStore{
+		ring:   sharding.NewRing(names, opts...),
+		shards: copied,
+	}
+}
+
+// AddShard adds or replaces the shard called name. About 1/N of the keys
+// move to a new shard and start counting from zero there.
+func (s *ShardedStore) AddShard(name string, store Store) {
+	s.mu.Lock()
+	s.shards[name] = store
+	s.mu.Unlock()
+	s.ring.Add(name)
+}
+
+// RemoveShard removes the shard called name and returns it, or nil if
+// there was none. Its keys move to the remaining shards.
+func (s *ShardedStore) RemoveShard(name string) Store {
+	s.ring.Remove(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store := s.shards[name]
+	delete(s.shards, name)
+	return store
+}
+
+// Shard returns the name of the shard key lives on.
+func (s *ShardedStore) Shard(key string) string {
+	return s.ring.Get(key)
+}
+
+// store returns the shard for key.
+func (s *ShardedStore) store(key string) (Store, error) {
+	name := s.ring.Get(key)
+	s.mu.RLock()
+	store, ok := s.shards[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("distributed: no shard for key %q", key)
+	}
+	return store, nil
+}
+
+// Add implements Store.
+func (s *ShardedStore) Add(ctx context.Context, key string, window time.Time, n int64, ttl time.Duration) (int64, error) {
+	store, err := s.store(key)
+	if err != nil {
+		return 0, err
+	}
+	return store.Add(ctx, key, window, n, ttl)
+}
+
+// Hot implements Store by asking every shard in parallel and merging the
+// answers. It fails if any shard does.
+func (s *ShardedStore) Hot(ctx context.Context, window time.Time, n int) ([]Usage, error) {
+	s.mu.RLock()
+	stores := make([]Store, 0, len(s.shards))
+	for _, store := range s.shards {
+		stores = append(stores, store)
+	}
+	s.mu.RUnlock()
+
+	results := make([][]Usage, len(stores))
+	errs := make([]error, len(stores))
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		wg.Add(1)
+		go func(i int, store Store) {
+			defer wg.Done()
+			results[i], errs[i] = store.Hot(ctx, window, n)
+		}(i, store)
+	}
+	wg.Wait()
+
+	var hot []Usage
+	for i := range stores {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		hot = append(hot, results[i]...)
+	}
+	sortUsage(hot)
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot, nil
+}
+
+// Close closes every shard that has a Close method and returns the first
+// error.
+func (s *ShardedStore) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var err error
+	for _, store := range s.shards {
+		if c, ok := store.(io.Closer); ok {
+			if closeErr := c.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
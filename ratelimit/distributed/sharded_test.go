@@ -0,0 +1,114 @@
+package distributed_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit/distributed"
+)
+
+// closingStore is a MemoryStore that records being closed.
+type closingStore struct {
+	*distributed.MemoryStore
+	closed bool
+}
+
+func (s *closingStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestShardedStore(t *testing.T) {
+	ctx := context.Background()
+	window := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	shards := map[string]*closingStore{
+		"a": {MemoryStore: distributed.NewMemoryStore()},
+		"b": {MemoryStore: distributed.NewMemoryStore()},
+	}
+	s := distributed.NewShardedStore(map[string]distributed.Store{"a": shards["a"], "b": shards["b"]})
+
+	for i := 0; i < 20; i++ {
+		key := "k" + strconv.Itoa(i)
+		for j := 0; j <= i; j++ {
+			if _, err := s.Add(ctx, key, window, 1, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The key is counted on its shard only.
+		name := s.Shard(key)
+		other := map[string]string{"a": "b", "b": "a"}[name]
+		if n, _ := shards[name].Add(ctx, key, window, 0, time.Minute); n != int64(i+1) {
+			t.Errorf("key %s counts %d on its shard %s, want %d", key, n, name, i+1)
+		}
+		if n, _ := shards[other].Add(ctx, key, window, 0, time.Minute); n != 0 {
+			t.Errorf("key %s counts %d on shard %s too", key, n, other)
+		}
+	}
+
+	hot, err := s.Hot(ctx, window, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hot) != 3 || hot[0].Key != "k19" || hot[1].Key != "k18" || hot[2].Key != "k17" {
+		t.Errorf("Hot = %+v, want k19, k18 and k17 across shards", hot)
+	}
+
+	if err := s.Close(); err != nil || !shards["a"].closed || !shards["b"].closed {
+		t.Errorf("Close = %v, closed a %v, b %v", err, shards["a"].closed, shards["b"].closed)
+	}
+}
+
+func TestShardedStoreMovesKeysOfRemovedShard(t *testing.T) {
+	ctx := context.Background()
+	s := distributed.NewShardedStore(map[string]distributed.Store{
+		"a": distributed.NewMemoryStore(),
+		"b": distributed.NewMemoryStore(),
+	})
+
+	key := "k"
+	name := s.Shard(key)
+	if removed := s.RemoveShard(name); removed == nil {
+		t.Fatalf("RemoveShard(%s) = nil", name)
+	}
+	if s.RemoveShard(name) != nil {
+		t.Error("second RemoveShard returned a store")
+	}
+	if got := s.Shard(key); got == name || got == "" {
+		t.Errorf("Shard after removing %s = %q", name, got)
+	}
+	if _, err := s.Add(ctx, key, time.Now(), 1, time.Minute); err != nil {
+		t.Errorf("Add after removing a shard: %v", err)
+	}
+
+	s.AddShard(name, distributed.NewMemoryStore())
+	if got := s.Shard(key); got != name {
+		t.Errorf("Shard after adding %s back = %q", name, got)
+	}
+}
+
+// failingStore is a shard whose Hot fails.
+type failingStore struct{ distributed.Store }
+
+var errDown = errors.New("shard down")
+
+func (failingStore) Hot(context.Context, time.Time, int) ([]distributed.Usage, error) {
+	return nil, errDown
+}
+
+func TestShardedStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := distributed.NewShardedStore(nil).Add(ctx, "k", time.Now(), 1, time.Minute); err == nil {
+		t.Error("Add without shards succeeded")
+	}
+
+	s := distributed.NewShardedStore(map[string]distributed.Store{
+		"a": distributed.NewMemoryStore(),
+		"b": failingStore{},
+	})
+	if _, err := s.Hot(ctx, time.Now(), 10); !errors.Is(err, errDown) {
+		t.Errorf("Hot with a failing shard = %v, want %v", err, errDown)
+	}
+}
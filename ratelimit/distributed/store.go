@@ -0,0 +1,119 @@
+// Package distributed shares rate limit usage between instances through a
+// central store such as Redis or memcached.
+//
+// Usage is counted per key in fixed windows of a configured period. The
+// store also keeps, per window, the set of keys ranked by usage, so a new
+// instance can find the hottest keys and start from their shared usage
+// instead of a full local budget.
+package distributed
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Usage is the units a key consumed in one window.
+type Usage struct {
+	Key  string `json:"key"`
+	Used int64  `json:"used"`
+}
+
+// Store is a central usage store. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Add adds n units to key's usage in the window starting at window and
+	// returns the new total. Counts are dropped once ttl has elapsed.
+	Add(ctx context.Context, key string, window time.Time, n int64, ttl time.Duration) (int64, error)
+
+	// Hot returns up to n keys with the highest usage in the window
+	// starting at window, most used first.
+	Hot(ctx context.Context, window time.Time, n int) ([]Usage, error)
+}
+
+// WindowStart returns the start of the window of length period containing t.
+func WindowStart(t time.Time, period time.Duration) time.Time {
+	return t.Truncate(period)
+}
+
+// MemoryStore is an in-process Store, for tests and single-host setups.
+type MemoryStore struct {
+	mu      sync.Mutex
+	windows map[int64]*memoryWindow
+	now     func() time.Time
+}
+
+// memoryWindow holds the counts of one window.
+type memoryWindow struct {
+	used    map[string]int64
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		windows: make(map[int64]*memoryWindow),
+		now:     time.Now,
+	}
+}
+
+// Add implements Store.
+func (s *MemoryStore) Add(ctx context.Context, key string, window time.Time, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.expire(now)
+
+	w, ok := s.windows[window.UnixNano()]
+	if !ok {
+		w = &memoryWindow{used: make(map[string]int64)}
+		s.windows[window.UnixNano()] = w
+	}
+	w.expires = now.Add(ttl)
+	w.used[key] += n
+	return w.used[key], nil
+}
+
+// Hot implements Store.
+func (s *MemoryStore) Hot(ctx context.Context, window time.Time, n int) ([]Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(s.now())
+
+	w, ok := s.windows[window.UnixNano()]
+	if !ok {
+		return nil, nil
+	}
+
+	hot := make([]Usage, 0, len(w.used))
+	for key, used := range w.used {
+		hot = append(hot, Usage{Key: key, Used: used})
+	}
+	sortUsage(hot)
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot, nil
+}
+
+// expire drops windows past their ttl. s.mu must be held.
+func (s *MemoryStore) expire(now time.Time) {
+	for start, w := range s.windows {
+		if now.After(w.expires) {
+			delete(s.windows, start)
+		}
+	}
+}
+
+// sortUsage orders usage most used first, then by key.
+func sortUsage(usage []Usage) {
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Used != usage[j].Used {
+			return usage[i].Used > usage[j].Used
+		}
+		return usage[i].Key < usage[j].Key
+	})
+}
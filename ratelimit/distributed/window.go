@@ -0,0 +1,275 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// WindowAlgorithm selects how a WindowLimiter counts usage.
+type WindowAlgorithm int
+
+const (
+	// FixedWindowCounter admits up to Limit units per fixed window.
+	FixedWindowCounter WindowAlgorithm = iota
+
+	// SlidingWindowCounter also counts the previous window, weighted by the
+	// share of it that still falls within the last Period. It smooths the
+	// burst a fixed window allows across a window boundary at the cost of
+	// a second store call.
+	SlidingWindowCounter
+)
+
+// WindowConfig configures a WindowLimiter.
+type WindowConfig struct {
+	// Store holds the counters. It must accept a negative n in Add, which
+	// every store in this package does.
+	Store Store
+
+	// Limit is the number of units each key may use per Period.
+	Limit int64
+
+	// Period is the window length.
+	Period time.Duration
+
+	// Algorithm selects fixed or sliding window counting.
+	Algorithm WindowAlgorithm
+
+	// FailOpen admits requests while the store is unreachable. By default
+	// they are rejected.
+	FailOpen bool
+
+	// Timeout bounds the store calls of one decision. Defaults to 1s.
+	Timeout time.Duration
+
+	// OnError is called with store errors. May be nil.
+	OnError func(error)
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// WindowLimiter is a keyed limiter that counts every request in a Store,
+// so all instances share exact per-key counters. Each decision costs one
+// store round trip, or two with SlidingWindowCounter; see Hybrid for a
+// limiter that avoids them.
+//
+// A request is counted first and taken back if it went over the limit, so
+// concurrent instances never admit more than Limit between them.
+type WindowLimiter struct {
+	config WindowConfig
+}
+
+// NewWindowLimiter creates a WindowLimiter.
+func NewWindowLimiter(config WindowConfig) (*WindowLimiter, error) {
+	if config.Store == nil {
+		return nil, errors.New("distributed: window limiter needs a store")
+	}
+	if config.Limit <= 0 {
+		return nil, errors.New("distributed: window limit must be positive")
+	}
+	if config.Period <= 0 {
+		return nil, errors.New("distributed: window period must be positive")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+	return &WindowLimiter{config: config}, nil
+}
+
+// AllowN reports whether key may use n units now, and records them if so.
+func (l *WindowLimiter) AllowN(key string, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+
+	now := l.config.Clock()
+	window := WindowStart(now, l.config.Period)
+	ttl := l.ttl()
+
+	var previous float64
+	if l.config.Algorithm == SlidingWindowCounter {
+		prev, err := l.config.Store.Add(ctx, key, window.Add(-l.config.Period), 0, ttl)
+		if err != nil {
+			return l.failed(err)
+		}
+		previous = float64(prev) * l.weight(now, window)
+	}
+
+	used, err := l.config.Store.Add(ctx, key, window, int64(n), ttl)
+	if err != nil {
+		return l.failed(err)
+	}
+	if float64(used)+previous <= float64(l.config.Limit) {
+		return true
+	}
+
+	// Over the limit: take the units back so they do not count against
+	// later requests.
+	if _, err := l.config.Store.Add(ctx, key, window, -int64(n), ttl); err != nil {
+		l.report(err)
+	}
+	return false
+}
+
+// Available returns the units key may still use now.
+func (l *WindowLimiter) Available(key string) int {
+	used, err := l.used(key)
+	if err != nil {
+		l.report(err)
+		return 0
+	}
+	if left := float64(l.config.Limit) - used; left > 0 {
+		return int(left)
+	}
+	return 0
+}
+
+// Reset clears key's usage in the current window. Usage in the previous
+// window, which SlidingWindowCounter still weighs, is kept.
+func (l *WindowLimiter) Reset(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+
+	window := WindowStart(l.config.Clock(), l.config.Period)
+	used, err := l.config.Store.Add(ctx, key, window, 0, l.ttl())
+	if err == nil && used > 0 {
+		_, err = l.config.Store.Add(ctx, key, window, -used, l.ttl())
+	}
+	if err != nil {
+		l.report(err)
+	}
+}
+
+// RetryAfter returns how long until key may use n more units, assuming no
+// other usage meanwhile.
+func (l *WindowLimiter) RetryAfter(key string, n int) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+
+	now := l.config.Clock()
+	window := WindowStart(now, l.config.Period)
+	end := window.Add(l.config.Period).Sub(now)
+	if l.config.Algorithm != SlidingWindowCounter {
+		return end
+	}
+
+	used, err := l.config.Store.Add(ctx, key, window, 0, l.ttl())
+	if err != nil {
+		return end
+	}
+	prev, err := l.config.Store.Add(ctx, key, window.Add(-l.config.Period), 0, l.ttl())
+	room := float64(l.config.Limit - used - int64(n))
+	if err != nil || room < 0 || prev == 0 {
+		return end
+	}
+
+	// The previous window's weight falls linearly to zero at window end.
+	at := window.Add(time.Duration(float64(l.config.Period) * (1 - room/float64(prev))))
+	if wait := at.Sub(now); wait > 0 && wait < end {
+		return wait
+	}
+	return end
+}
+
+// used returns key's weighted usage now.
+func (l *WindowLimiter) used(key string) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.Timeout)
+	defer cancel()
+
+	now := l.config.Clock()
+	window := WindowStart(now, l.config.Period)
+	used, err := l.config.Store.Add(ctx, key, window, 0, l.ttl())
+	if err != nil || l.config.Algorithm != SlidingWindowCounter {
+		return float64(used), err
+	}
+	prev, err := l.config.Store.Add(ctx, key, window.Add(-l.config.Period), 0, l.ttl())
+	return float64(used) + float64(prev)*l.weight(now, window), err
+}
+
+// weight returns the share of the previous window that is still within
+// the last Period at now.
+func (l *WindowLimiter) weight(now, window time.Time) float64 {
+	return 1 - float64(now.Sub(window))/float64(l.config.Period)
+}
+
+// ttl keeps a window's counter for as long as the next window weighs it.
+func (l *WindowLimiter) ttl() time.Duration {
+	return 2 * l.config.Period
+}
+
+// failed applies the failure policy to a store error.
+func (l *WindowLimiter) failed(err error) bool {
+	l.report(err)
+	return l.config.FailOpen
+}
+
+func (l *WindowLimiter) report(err error) {
+	if l.config.OnError != nil {
+		l.config.OnError(err)
+	}
+}
+
+// Limiter returns a ratelimit.Limiter view of a single key.
+func (l *WindowLimiter) Limiter(key string) ratelimit.Limiter {
+	return &windowKeyLimiter{limiter: l, key: key}
+}
+
+// windowKeyLimiter adapts one key of a WindowLimiter to ratelimit.Limiter.
+type windowKeyLimiter struct {
+	limiter *WindowLimiter
+	key     string
+}
+
+func (l *windowKeyLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+func (l *windowKeyLimiter) AllowN(n int) bool {
+	return l.limiter.AllowN(l.key, n)
+}
+
+func (l *windowKeyLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN polls until n units are admitted or ctx is done, sleeping for
+// RetryAfter between attempts.
+func (l *windowKeyLimiter) WaitN(ctx context.Context, n int) error {
+	if int64(n) > l.limiter.config.Limit {
+		return fmt.Errorf("requested %d exceeds rate limit %d", n, l.limiter.config.Limit)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if l.limiter.AllowN(l.key, n) {
+			return nil
+		}
+
+		timer := time.NewTimer(l.limiter.RetryAfter(l.key, n))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *windowKeyLimiter) Reset() {
+	l.limiter.Reset(l.key)
+}
+
+func (l *windowKeyLimiter) Available() int {
+	return l.limiter.Available(l.key)
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"time"
+)
+
+// StateDump is a point-in-time view of every keyed limiter, for debugging.
+// It is taken under the owner's lock, so no key is created or dropped while
+// it is collected.
+type StateDump struct {
+	TakenAt time.Time          `json:"taken_at"`
+	Source  string             `json:"source,omitempty"`
+	Keys    map[string]KeyDump `json:"keys"`
+}
+
+// KeyDump is the state of one key in a StateDump.
+type KeyDump struct {
+	// Available is the remaining budget as reported by Limiter.Available.
+	Available int `json:"available"`
+
+	// LastAccess is when the key was last used.
+	LastAccess time.Time `json:"last_access"`
+
+	// State is the limiter's Snapshot, if it implements Snapshotter.
+	State json.RawMessage `json:"state,omitempty"`
+}
+
+// DumpKey builds the KeyDump of one limiter. Packages that hold their own
+// keyed limiters, such as the sidecar server, use it to produce dumps in
+// the same format.
+func DumpKey(limiter Limiter, lastAccess time.Time) KeyDump {
+	kd := KeyDump{
+		Available:  limiter.Available(),
+		LastAccess: lastAccess,
+	}
+	if s, ok := limiter.(Snapshotter); ok {
+		if data, err := s.Snapshot(); err == nil {
+			kd.State = data
+		}
+	}
+	return kd
+}
+
+// Dump returns the state of every key.
+func (m *Middleware) Dump() StateDump {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dump := StateDump{
+		TakenAt: time.Now(),
+		Source:  "middleware",
+		Keys:    make(map[string]KeyDump, len(m.limiters)),
+	}
+	for key, entry := range m.limiters {
+		dump.Keys[key] = DumpKey(entry.limiter, entry.lastAccess)
+	}
+	return dump
+}
+
+// DumpHandler serves Dump as JSON. Mount it behind authentication, since
+// keys may identify clients.
+func (m *Middleware) DumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Dump())
+	})
+}
+
+// DumpState writes Dump to w as indented JSON, for incident diagnosis,
+// for example from a signal handler or a debug command.
+func (m *Middleware) DumpState(w io.Writer) error {
+	return writeIndented(w, m.Dump())
+}
+
+// DumpState writes the Dump of every policy to w as indented JSON, keyed
+// by policy name.
+func (r *Registry) DumpState(w io.Writer) error {
+	dumps := make(map[string]StateDump)
+	for _, name := range r.Names() {
+		if m := r.Policy(name); m != nil {
+			dumps[name] = m.Dump()
+		}
+	}
+	return writeIndented(w, dumps)
+}
+
+func writeIndented(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Publish exports the aggregate statistics of the middleware, its counters
+// and the numbers of active keys and queued requests, as the expvar
+// variable name, so that they are served at /debug/vars with the runtime
+// statistics. Per key state is left out. Like expvar.Publish, it panics
+// if name is already published.
+func (m *Middleware) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Summary(0, false)
+	}))
+}
+
+// Publish exports the aggregate statistics of every policy, keyed by
+// policy name, as the expvar variable name. Policies registered later are
+// included. Like expvar.Publish, it panics if name is already published.
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Summary(0, false)
+	}))
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLimited reports that a request was rate limited, with the metadata
+// callers need to tell clients when to retry. WaitN returns it when the
+// context deadline passes before capacity became available; it wraps the
+// context error, so errors.Is(err, context.DeadlineExceeded) still holds.
+type ErrLimited struct {
+	// RetryAfter is how long until the request could be admitted.
+	RetryAfter time.Duration
+
+	// Limit is the number of requests allowed per period, or zero if it
+	// is not known.
+	Limit int
+
+	// Remaining is the number of requests that could be admitted now.
+	Remaining int
+
+	// Err is the underlying cause, such as context.DeadlineExceeded.
+	Err error
+}
+
+// Error implements error.
+func (e *ErrLimited) Error() string {
+	msg := fmt.Sprintf("rate limited: retry after %s (limit %d, remaining %d)",
+		e.RetryAfter, e.Limit, e.Remaining)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying cause.
+func (e *ErrLimited) Unwrap() error {
+	return e.Err
+}
+
+// Checker is implemented by limiters that can report, without consuming
+// capacity, whether a request would be admitted and if not when to retry.
+type Checker interface {
+	// Check returns nil if a single request would be admitted now,
+	// otherwise an *ErrLimited.
+	Check() error
+
+	// CheckN returns nil if n requests would be admitted now,
+	// otherwise an *ErrLimited.
+	CheckN(n int) error
+}
+
+// RetryAfter extracts the retry delay from an error returned by a limiter.
+func RetryAfter(err error) (time.Duration, bool) {
+	var limited *ErrLimited
+	if errors.As(err, &limited) {
+		return limited.RetryAfter, true
+	}
+	return 0, false
+}
+
+// deadlineError converts the context error ending a wait into an
+// *ErrLimited when the wait failed because of a deadline; cancellation is
+// returned unchanged. limited is called with the limiter's mutex held.
+func deadlineError(err error, limited func() *ErrLimited) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	e := limited()
+	if e == nil {
+		// Capacity appeared just as the deadline passed. The limit is
+		// unknown here, so headers leave it out.
+		e = &ErrLimited{}
+	}
+	e.Err = err
+	return e
+}
+
+// checkResult turns the outcome of a limiter's limited() computation into
+// the error returned by CheckN.
+func checkResult(e *ErrLimited) error {
+	if e == nil {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// EventRateLimited reports a request rejected because its key exceeded
+	// its rate or is blocked, including failed waits and Registry calls.
+	EventRateLimited EventKind = iota + 1
+
+	// EventDenied reports a request rejected by the Deny rule.
+	EventDenied
+
+	// EventShed reports a request shed by QueueHandler because the queue
+	// was full.
+	EventShed
+
+	// EventEvicted reports a key dropped to stay within MaxKeys.
+	EventEvicted
+
+	// EventBlocked and EventExempted report a key blocked with Block or
+	// exempted with Exempt.
+	EventBlocked
+	EventExempted
+
+	// EventLimitChanged reports limits set with SetLimit.
+	EventLimitChanged
+)
+
+// String returns the name of k as used in JSON, such as "rate_limited".
+func (k EventKind) String() string {
+	switch k {
+	case EventRateLimited:
+		return "rate_limited"
+	case EventDenied:
+		return "denied"
+	case EventShed:
+		return "shed"
+	case EventEvicted:
+		return "evicted"
+	case EventBlocked:
+		return "blocked"
+	case EventExempted:
+		return "exempted"
+	case EventLimitChanged:
+		return "limit_changed"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// MarshalText implements encoding.TextMarshaler, so kinds appear by name
+// in JSON.
+func (k EventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Event reports a decision or state change of a Middleware, so that
+// downstream systems can alert on abuse without scraping logs.
+type Event struct {
+	Kind EventKind `json:"kind"`
+	Time time.Time `json:"time"`
+
+	// Key is the limiter key; empty for EventDenied, EventShed and
+	// EventLimitChanged.
+	Key string `json:"key,omitempty"`
+
+	// RetryAfter is how long the client was told to wait, for
+	// EventRateLimited when known.
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+
+	// Until is when the override of EventBlocked or EventExempted ends.
+	Until *time.Time `json:"until,omitempty"`
+
+	// Limit is the new limits of EventLimitChanged.
+	Limit *Limit `json:"limit,omitempty"`
+
+	// Request is the rejected request, or nil for events that do not come
+	// from one, such as Registry calls.
+	Request *http.Request `json:"-"`
+}
+
+// subscriber is a function registered with Subscribe or Events.
+type subscriber struct {
+	id int
+	fn func(Event)
+}
+
+// Subscribe calls fn with every event from now on. fn is called
+// synchronously by the request or call that caused the event, outside
+// the middleware's locks, so it should return quickly.
+func (m *Middleware) Subscribe(fn func(Event)) {
+	m.subscribe(fn)
+}
+
+// Events returns a channel receiving every event from now on, with a
+// buffer of size. Events that do not fit in the buffer are dropped rather
+// than holding up requests. cancel stops the delivery and closes the
+// channel.
+func (m *Middleware) Events(size int) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, size)
+
+	// emit may still call the subscriber after cancel removed it, so the
+	// channel is closed under mu and never sent on afterwards.
+	var mu sync.Mutex
+	var done bool
+	id := m.subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if done {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+
+	return ch, func() {
+		m.subMu.Lock()
+		for i, s := range m.subscribers {
+			if s.id == id {
+				m.subscribers = append(m.subscribers[:i:i], m.subscribers[i+1:]...)
+				break
+			}
+		}
+		m.subMu.Unlock()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if done {
+			return
+		}
+		done = true
+		close(ch)
+	}
+}
+
+// subscribe registers fn and returns its id.
+func (m *Middleware) subscribe(fn func(Event)) int {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	m.nextSubscriber++
+	m.subscribers = append(m.subscribers, subscriber{id: m.nextSubscriber, fn: fn})
+	return m.nextSubscriber
+}
+
+// emit passes e to the subscribers. It must not be called with m.mu held,
+// so that subscribers may call the middleware. The subscribers are called
+// without m.subMu held, so that they may subscribe and cancel too.
+func (m *Middleware) emit(e Event) {
+	// The elements of m.subscribers are never overwritten: subscribe
+	// appends past its length and cancel copies it.
+	m.subMu.RLock()
+	subscribers := m.subscribers
+	m.subMu.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	for _, s := range subscribers {
+		s.fn(e)
+	}
+}
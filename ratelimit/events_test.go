@@ -0,0 +1,76 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func TestMiddlewareSubscriberUnsubscribesItself(t *testing.T) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:        ratelimit.IPKeyFunc,
+		LimiterFactory: func() ratelimit.Limiter { return ratelimit.NewTokenBucket() },
+		MaxKeys:        1,
+	})
+	defer m.Close()
+
+	// A watcher that stops listening, and starts a new subscription, from
+	// within the delivery of the first event
+	events, cancel := m.Events(10)
+	var once sync.Once
+	var later []ratelimit.Event
+	m.Subscribe(func(e ratelimit.Event) {
+		once.Do(func() {
+			cancel()
+			m.Subscribe(func(e ratelimit.Event) { later = append(later, e) })
+		})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			m.Preload(fmt.Sprintf("10.0.0.%d", i), 0)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("emit deadlocked with a subscriber cancelling a subscription")
+	}
+
+	for range events {
+		// Drains until cancel closed the channel
+	}
+	if len(later) != 1 {
+		t.Errorf("subscriber added during delivery got %d events, want 1", len(later))
+	}
+}
+
+func TestMiddlewareEventsCancelWhileEmitting(t *testing.T) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:        ratelimit.IPKeyFunc,
+		LimiterFactory: func() ratelimit.Limiter { return ratelimit.NewTokenBucket() },
+		MaxKeys:        1,
+	})
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m.Preload(fmt.Sprintf("10.%d.0.%d", i, j), 0)
+			}
+		}(i)
+	}
+	for i := 0; i < 200; i++ {
+		_, cancel := m.Events(1)
+		cancel()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// FirstSeen admits the first N distinct keys of every window, such as the
+// first N new clients of a minute, and rejects the keys that come after
+// them until the window ends. Keys it admitted stay admitted for the rest
+// of the window.
+//
+// Admitted keys are remembered in a Bloom filter sized for N keys, so
+// memory is fixed whatever the number of keys seen. A key the filter
+// wrongly reports as admitted, at about the configured false positive
+// rate, is let through; no key is rejected by mistake. The filter is
+// cleared when a window ends, so rotation restores the false positive
+// rate it was sized for.
+type FirstSeen struct {
+	config *Config
+	limit  int
+
+	mu          sync.Mutex
+	filter      *BloomFilter
+	admitted    int // distinct keys added to filter in this window
+	windowStart time.Time
+	stats       FirstSeenStats
+}
+
+// FirstSeenStats describes a FirstSeen limiter. The counters run since it
+// was created or last reset.
+type FirstSeenStats struct {
+	Limit int `json:"limit"`
+
+	// Keys is the number of distinct keys admitted in the current window.
+	Keys int `json:"keys"`
+
+	// Saturation is the fraction of the filter's bits set, about 0.5 once
+	// Limit keys are admitted.
+	Saturation float64 `json:"saturation"`
+
+	// FalsePositiveRate is the estimated probability that a new key is
+	// taken for an admitted one now.
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+
+	Allowed  int64 `json:"allowed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// NewFirstSeen creates a limiter admitting the first limit distinct keys
+// per Config.Period. Of the options, Period sets the window,
+// FalsePositiveRate the accuracy of the Bloom filter (1% by default) and
+// Clock the time source.
+func NewFirstSeen(limit int, opts ...Option) *FirstSeen {
+	cfg := NewConfig(opts...)
+	if cfg.Period <= 0 {
+		cfg.Period = time.Second
+	}
+
+	return &FirstSeen{
+		config:      cfg,
+		limit:       limit,
+		filter:      NewBloomFilter(limit, cfg.FalsePositiveRate),
+		windowStart: cfg.Clock.Now(),
+		stats:       FirstSeenStats{Limit: limit},
+	}
+}
+
+// AllowKey reports whether key is admitted: if it already was in the
+// current window, or if fewer than the limit of distinct keys were.
+func (f *FirstSeen) AllowKey(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate(f.config.Clock.Now())
+
+	if !f.filter.Contains(key) {
+		if f.admitted >= f.limit {
+			f.stats.Rejected++
+			return false
+		}
+		f.filter.Add(key)
+		f.admitted++
+	}
+	f.stats.Allowed++
+	return true
+}
+
+// rotate clears the filter if the current window has ended. The caller
+// must hold f.mu.
+func (f *FirstSeen) rotate(now time.Time) {
+	elapsed := now.Sub(f.windowStart)
+	if elapsed < f.config.Period {
+		return
+	}
+	f.filter.Reset()
+	f.admitted = 0
+	f.windowStart = f.windowStart.Add(elapsed.Truncate(f.config.Period))
+}
+
+// Available returns how many more distinct keys the current window
+// admits.
+func (f *FirstSeen) Available() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate(f.config.Clock.Now())
+	return max(f.limit-f.admitted, 0)
+}
+
+// Saturation returns the fraction of the Bloom filter's bits set in the
+// current window. Past about 0.5 the filter holds more keys than it was
+// sized for and new keys are increasingly let through by mistake.
+func (f *FirstSeen) Saturation() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate(f.config.Clock.Now())
+	return f.filter.Saturation()
+}
+
+// Reset forgets every key and clears the counters.
+func (f *FirstSeen) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.filter.Reset()
+	f.admitted = 0
+	f.windowStart = f.config.Clock.Now()
+	f.stats = FirstSeenStats{Limit: f.limit}
+}
+
+// Stats returns the counters and the state of the current window.
+func (f *FirstSeen) Stats() FirstSeenStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate(f.config.Clock.Now())
+	stats := f.stats
+	stats.Keys = f.admitted
+	stats.Saturation = f.filter.Saturation()
+	stats.FalsePositiveRate = f.filter.FalsePositiveRate()
+	return stats
+}
@@ -8,11 +8,16 @@ import (
 )
 
 // FixedWindow implements the fixed window rate limiting algorithm.
-// It tracks requests within fixed time windows.
+// It tracks requests within fixed time windows. Windows start when the
+// limiter is created or reset, unless Config.AlignWindows aligns them to
+// the wall clock.
 type FixedWindow struct {
 	config      *Config
 	count       int
 	windowStart time.Time
+	decay       decay
+	jitter      *jitter
+	denials     *denialLogger
 	mu          sync.Mutex
 }
 
@@ -20,11 +25,15 @@ type FixedWindow struct {
 func NewFixedWindow(opts ...Option) *FixedWindow {
 	cfg := NewConfig(opts...)
 	
-	return &FixedWindow{
-		config:      cfg,
-		count:       0,
-		windowStart: cfg.Clock.Now(),
+	fw := &FixedWindow{
+		config: cfg,
+		count:  0,
+		decay:  decay{period: cfg.DecayReset},
+		jitter: newJitter(cfg),
 	}
+	fw.windowStart = fw.start(cfg.Clock.Now())
+	fw.denials = newDenialLogger(cfg.Logger, cfg.LogSampleRate)
+	return fw
 }
 
 // Allow checks if a single request can proceed.
@@ -34,12 +43,21 @@ func (fw *FixedWindow) Allow() bool {
 
 // AllowN checks if n requests can proceed.
 func (fw *FixedWindow) AllowN(n int) bool {
+	if fw.allowN(n) {
+		return true
+	}
+	fw.denials.denied("", func() error { return fw.CheckN(n) })
+	return false
+}
+
+// allowN is AllowN without logging.
+func (fw *FixedWindow) allowN(n int) bool {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 	
 	fw.resetIfNewWindow()
 	
-	if fw.count+n <= fw.config.Rate {
+	if fw.used()+n <= fw.config.Rate {
 		fw.count += n
 		return true
 	}
@@ -47,6 +65,34 @@ func (fw *FixedWindow) AllowN(n int) bool {
 	return false
 }
 
+// AllowUpTo admits as many of n requests as the current window has room
+// for, up to n, and returns how many.
+func (fw *FixedWindow) AllowUpTo(n int) int {
+	if granted := fw.allowUpTo(n); granted > 0 || n <= 0 {
+		return granted
+	}
+	fw.denials.denied("", fw.Check)
+	return 0
+}
+
+// allowUpTo is AllowUpTo without logging.
+func (fw *FixedWindow) allowUpTo(n int) int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	
+	fw.resetIfNewWindow()
+	
+	granted := fw.config.Rate - fw.used()
+	if granted > n {
+		granted = n
+	}
+	if granted <= 0 {
+		return 0
+	}
+	fw.count += granted
+	return granted
+}
+
 // Wait blocks until a request can proceed or context is cancelled.
 func (fw *FixedWindow) Wait(ctx context.Context) error {
 	return fw.WaitN(ctx, 1)
@@ -54,42 +100,91 @@ func (fw *FixedWindow) Wait(ctx context.Context) error {
 
 // WaitN blocks until n requests can proceed or context is cancelled.
 func (fw *FixedWindow) WaitN(ctx context.Context, n int) error {
-	if n > fw.config.Rate {
-		return fmt.Errorf("requested %d exceeds rate limit %d", n, fw.config.Rate)
+	fw.mu.Lock()
+	rate := fw.config.Rate
+	fw.mu.Unlock()
+	if n > rate {
+		return fmt.Errorf("requested %d exceeds rate limit %d", n, rate)
+	}
+	
+	// A context that is already done never consumes capacity
+	if err := ctx.Err(); err != nil {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		return deadlineError(err, func() *ErrLimited { return fw.limited(n) })
 	}
 	
 	for {
 		fw.mu.Lock()
 		fw.resetIfNewWindow()
 		
-		if fw.count+n <= fw.config.Rate {
+		if fw.used()+n <= fw.config.Rate {
 			fw.count += n
 			fw.mu.Unlock()
 			return nil
 		}
 		
-		// Calculate wait time until next window
-		nextWindow := fw.windowStart.Add(fw.config.Period)
-		waitDuration := nextWindow.Sub(fw.config.Clock.Now())
+		waitDuration := fw.jitter.apply(fw.retryAfter(n))
 		fw.mu.Unlock()
 		
 		// Wait with context
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			fw.mu.Lock()
+			defer fw.mu.Unlock()
+			return deadlineError(ctx.Err(), func() *ErrLimited { return fw.limited(n) })
 		case <-fw.config.Clock.After(waitDuration):
 			// Continue to next iteration
 		}
 	}
 }
 
-// Reset resets the rate limiter to its initial state.
+// Check returns nil if a single request would be admitted now, otherwise
+// an *ErrLimited describing when to retry.
+func (fw *FixedWindow) Check() error {
+	return fw.CheckN(1)
+}
+
+// CheckN returns nil if n requests would be admitted now, otherwise an
+// *ErrLimited describing when to retry. It does not count the requests.
+func (fw *FixedWindow) CheckN(n int) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	
+	return checkResult(fw.limited(n))
+}
+
+// limited describes why n requests cannot be admitted in the current
+// window, or returns nil if they can. The caller must hold fw.mu.
+func (fw *FixedWindow) limited(n int) *ErrLimited {
+	fw.resetIfNewWindow()
+	
+	used := fw.used()
+	if used+n <= fw.config.Rate {
+		return nil
+	}
+	
+	remaining := fw.config.Rate - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &ErrLimited{
+		RetryAfter: fw.retryAfter(n),
+		Limit:      fw.config.Rate,
+		Remaining:  remaining,
+	}
+}
+
+// Reset resets the rate limiter to its initial state. With DecayReset the
+// requests counted so far are released gradually.
 func (fw *FixedWindow) Reset() {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 	
+	now := fw.config.Clock.Now()
+	fw.decay.begin(float64(fw.count), now)
 	fw.count = 0
-	fw.windowStart = fw.config.Clock.Now()
+	fw.windowStart = fw.start(now)
 }
 
 // Available returns the number of available requests in the current window.
@@ -98,7 +193,7 @@ func (fw *FixedWindow) Available() int {
 	defer fw.mu.Unlock()
 	
 	fw.resetIfNewWindow()
-	available := fw.config.Rate - fw.count
+	available := fw.config.Rate - fw.used()
 	if available < 0 {
 		return 0
 	}
@@ -113,7 +208,46 @@ func (fw *FixedWindow) resetIfNewWindow() {
 	if now.After(windowEnd) || now.Equal(windowEnd) {
 		// Calculate how many windows have passed
 		windowsPassed := int(now.Sub(fw.windowStart) / fw.config.Period)
+		fw.decay.begin(float64(fw.count), windowEnd)
 		fw.windowStart = fw.windowStart.Add(time.Duration(windowsPassed) * fw.config.Period)
+		if fw.config.AlignWindows {
+			// Realign after Reconfigure changed the period.
+			fw.windowStart = fw.start(now)
+		}
 		fw.count = 0
 	}
+}
+
+// start returns the start of the window containing now: now itself, or
+// with AlignWindows the last multiple of the period since the zero time.
+func (fw *FixedWindow) start(now time.Time) time.Time {
+	if fw.config.AlignWindows {
+		return now.Truncate(fw.config.Period)
+	}
+	return now
+}
+
+// used returns the requests counted against the current window, including
+// those a DecayReset has not released yet.
+func (fw *FixedWindow) used() int {
+	return fw.count + fw.decay.units(fw.config.Clock.Now())
+}
+
+// retryAfter returns how long until n more requests may fit: the start of
+// the next window, or earlier if a decay releases enough before then.
+func (fw *FixedWindow) retryAfter(n int) time.Duration {
+	now := fw.config.Clock.Now()
+	wait := fw.windowStart.Add(fw.config.Period).Sub(now)
+	
+	if fw.count+n <= fw.config.Rate {
+		if at, ok := fw.decay.until(float64(fw.config.Rate - fw.count - n)); ok {
+			if d := at.Sub(now); d < wait {
+				wait = d
+			}
+		}
+	}
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return wait
 }
\ No newline at end of file
@@ -0,0 +1,173 @@
+package forecast
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Config configures a Forecaster. Zero fields take their defaults.
+type Config struct {
+	// Interval is the length of the intervals whose request counts are
+	// recorded and forecast. Defaults to one hour.
+	Interval time.Duration
+
+	// Lead is how many intervals after the current one the multiplier
+	// looks ahead: it follows the highest forecast among them and the
+	// current interval, so limits move before a peak arrives and hold
+	// through it. Defaults to 1.
+	Lead int
+
+	// Baseline is the number of recent intervals whose average is normal
+	// traffic, the reference of the multiplier. Defaults to 24.
+	Baseline int
+
+	// Scale turns the forecast and the baseline into a multiplier. If
+	// nil, the multiplier is forecast/baseline, raising limits as traffic
+	// is expected to grow; return baseline/forecast instead to lower them
+	// and protect a backend ahead of a peak.
+	Scale func(forecast, baseline float64) float64
+
+	// Min and Max bound the multiplier. Default to 0.5 and 2.
+	Min, Max float64
+
+	// Clock is the time source. Defaults to ratelimit.SystemClock.
+	Clock ratelimit.Clock
+}
+
+// maxCatchUp bounds the empty intervals recorded after a quiet period, so
+// the first request after a long idle time does not replay all of it.
+const maxCatchUp = 1000
+
+// Forecaster records traffic per interval, forecasts it with a Model and
+// derives a multiplier from the forecast. It needs no goroutine:
+// intervals are closed by the first call after they end. A Forecaster is
+// safe for concurrent use.
+type Forecaster struct {
+	model  Model
+	config Config
+
+	mu         sync.Mutex
+	start      time.Time // of the current interval; zero before the first call
+	count      float64
+	history    []float64 // the last Baseline interval counts, oldest first
+	multiplier float64
+}
+
+// New returns a forecaster that predicts traffic with model. config may be
+// nil for the defaults.
+func New(model Model, config *Config) *Forecaster {
+	f := &Forecaster{model: model, multiplier: 1}
+	if config != nil {
+		f.config = *config
+	}
+	c := &f.config
+	if c.Interval <= 0 {
+		c.Interval = time.Hour
+	}
+	if c.Lead <= 0 {
+		c.Lead = 1
+	}
+	if c.Baseline <= 0 {
+		c.Baseline = 24
+	}
+	if c.Scale == nil {
+		c.Scale = func(forecast, baseline float64) float64 { return forecast / baseline }
+	}
+	if c.Min <= 0 {
+		c.Min = 0.5
+	}
+	if c.Max <= 0 {
+		c.Max = 2
+	}
+	if c.Clock == nil {
+		c.Clock = ratelimit.SystemClock{}
+	}
+	return f
+}
+
+// Record adds n requests to the current interval.
+func (f *Forecaster) Record(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.roll(f.config.Clock.Now())
+	f.count += float64(n)
+}
+
+// Forecast returns the number of requests expected in an interval, 1
+// being the current one, 2 the next and so on, and whether the model has
+// seen enough to tell.
+func (f *Forecaster) Forecast(steps int) (float64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.roll(f.config.Clock.Now())
+	return f.model.Forecast(max(steps, 1))
+}
+
+// Multiplier returns the multiplier for the coming intervals, between Min
+// and Max, or 1 until the model can forecast. It changes only when an
+// interval ends, so it is cheap to call for every request.
+func (f *Forecaster) Multiplier() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.roll(f.config.Clock.Now())
+	return f.multiplier
+}
+
+// roll closes the intervals that ended by now, feeding their counts to the
+// model, and updates the multiplier. The caller must hold f.mu.
+func (f *Forecaster) roll(now time.Time) {
+	if f.start.IsZero() {
+		f.start = now
+		return
+	}
+	ended := int(now.Sub(f.start) / f.config.Interval)
+	if ended <= 0 {
+		return
+	}
+
+	for i := max(ended-maxCatchUp, 0); i < ended; i++ {
+		f.model.Update(f.count)
+		f.history = append(f.history, f.count)
+		if len(f.history) > f.config.Baseline {
+			f.history = f.history[1:]
+		}
+		f.count = 0
+	}
+	f.start = f.start.Add(time.Duration(ended) * f.config.Interval)
+	f.multiplier = f.compute()
+}
+
+// compute derives the multiplier from the model and the history. The
+// caller must hold f.mu.
+func (f *Forecaster) compute() float64 {
+	var sum float64
+	for _, v := range f.history {
+		sum += v
+	}
+	baseline := sum / float64(len(f.history))
+	if baseline <= 0 {
+		return 1
+	}
+
+	peak, known := 0.0, false
+	for step := 1; step <= f.config.Lead+1; step++ {
+		if v, ok := f.model.Forecast(step); ok {
+			peak, known = math.Max(peak, v), true
+		}
+	}
+	if !known {
+		return 1
+	}
+
+	m := f.config.Scale(peak, baseline)
+	if math.IsNaN(m) || math.IsInf(m, 0) {
+		return 1
+	}
+	return math.Min(math.Max(m, f.config.Min), f.config.Max)
+}
@@ -0,0 +1,145 @@
+package forecast_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+	"github.com/rRateLimit/client/ratelimit/forecast"
+)
+
+func TestExponentialSmoothing(t *testing.T) {
+	m := forecast.NewExponentialSmoothing(0.5)
+	if _, ok := m.Forecast(1); ok {
+		t.Error("Forecast before any observation reported ok")
+	}
+	for _, v := range []float64{10, 20, 40} {
+		m.Update(v)
+	}
+	for _, steps := range []int{1, 5} {
+		if got, ok := m.Forecast(steps); got != 27.5 || !ok {
+			t.Errorf("Forecast(%d) = %g, %v, want 27.5", steps, got, ok)
+		}
+	}
+}
+
+func TestHoltWinters(t *testing.T) {
+	season := []float64{10, 40, 80, 30}
+	tests := []struct {
+		name  string
+		trend float64
+	}{
+		{"flat", 0},
+		{"growing", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := forecast.NewHoltWinters(0.3, 0.1, 0.3, len(season))
+			n := 0
+			observe := func() {
+				m.Update(season[n%len(season)] + tt.trend*float64(n))
+				n++
+			}
+			for i := 0; i < len(season)-1; i++ {
+				observe()
+				if _, ok := m.Forecast(1); ok {
+					t.Fatalf("Forecast after %d observations reported ok, want a full season first", n)
+				}
+			}
+			for n < 40*len(season) {
+				observe()
+			}
+			for steps := 1; steps <= len(season); steps++ {
+				want := season[(n+steps-1)%len(season)] + tt.trend*float64(n+steps-1)
+				got, ok := m.Forecast(steps)
+				if !ok || math.Abs(got-want) > 5 {
+					t.Errorf("Forecast(%d) = %.1f, %v, want about %.1f", steps, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHoltWintersNeverNegative(t *testing.T) {
+	m := forecast.NewHoltWinters(0.9, 0.9, 0.3, 2)
+	for _, v := range []float64{1000, 1000, 500, 500, 100, 100, 0, 0} {
+		m.Update(v)
+	}
+	if got, _ := m.Forecast(10); got < 0 {
+		t.Errorf("Forecast(10) = %g, want at least 0", got)
+	}
+}
+
+// fixed forecasts values by step and records its observations.
+type fixed struct {
+	values   map[int]float64
+	observed []float64
+}
+
+func (m *fixed) Update(value float64) { m.observed = append(m.observed, value) }
+
+func (m *fixed) Forecast(steps int) (float64, bool) {
+	v, ok := m.values[steps]
+	return v, ok
+}
+
+func TestForecasterMultiplier(t *testing.T) {
+	tests := []struct {
+		name     string
+		forecast map[int]float64
+		config   forecast.Config
+		want     float64
+	}{
+		{"no forecast", nil, forecast.Config{}, 1},
+		{"growth", map[int]float64{1: 150}, forecast.Config{}, 1.5},
+		{"clamped to Max", map[int]float64{1: 500}, forecast.Config{}, 2},
+		{"clamped to Min", map[int]float64{1: 10}, forecast.Config{}, 0.5},
+		{"peak within lead", map[int]float64{1: 100, 3: 180}, forecast.Config{Lead: 2}, 1.8},
+		{"peak beyond lead", map[int]float64{1: 100, 4: 180}, forecast.Config{Lead: 2}, 1},
+		{"inverse scale", map[int]float64{1: 200}, forecast.Config{Scale: func(forecast, baseline float64) float64 { return baseline / forecast }}, 0.5},
+		{"invalid scale", map[int]float64{1: 200}, forecast.Config{Scale: func(float64, float64) float64 { return math.NaN() }}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+			model := &fixed{values: tt.forecast}
+			config := tt.config
+			config.Clock = clock
+			f := forecast.New(model, &config)
+
+			f.Record(100)
+			if got := f.Multiplier(); got != 1 {
+				t.Fatalf("Multiplier before an interval ended = %g, want 1", got)
+			}
+			clock.Advance(time.Hour)
+			if got := f.Multiplier(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Multiplier = %g, want %g", got, tt.want)
+			}
+			if len(model.observed) != 1 || model.observed[0] != 100 {
+				t.Errorf("model observed %v, want [100]", model.observed)
+			}
+		})
+	}
+}
+
+func TestForecasterBaselineAndCatchUp(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+	model := &fixed{values: map[int]float64{1: 150}}
+	f := forecast.New(model, &forecast.Config{Interval: time.Minute, Baseline: 2, Max: 10, Clock: clock})
+
+	for _, n := range []int{300, 100, 50} {
+		f.Record(n)
+		clock.Advance(time.Minute)
+	}
+	// The baseline is the average of the last two intervals, 75.
+	if got := f.Multiplier(); got != 2 {
+		t.Errorf("Multiplier = %g, want 150/75", got)
+	}
+
+	clock.Advance(24 * time.Hour)
+	f.Record(1)
+	if got := len(model.observed); got != 3+1000 {
+		t.Errorf("model observed %d intervals, want the 3 recorded and 1000 idle ones", got)
+	}
+}
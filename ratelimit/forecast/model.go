@@ -0,0 +1,141 @@
+// Package forecast predicts traffic from its history and adjusts limits
+// ahead of it, such as raising them before a daily peak. A Forecaster
+// counts requests per interval with Record, feeds the counts to a Model
+// and turns the forecast for the coming intervals into a multiplier for
+// ratelimit.Scaled:
+//
+//	model := forecast.NewHoltWinters(0.3, 0.05, 0.3, 24) // hourly, daily season
+//	f := forecast.New(model, &forecast.Config{Interval: time.Hour, Lead: 2})
+//
+//	limiter := ratelimit.NewScaled(ratelimit.NewTokenBucket(
+//		ratelimit.WithRate(1000),
+//		ratelimit.WithPeriod(time.Minute),
+//	), f.Multiplier)
+//
+//	// for every request
+//	f.Record(1)
+//	if limiter.Allow() { ... }
+package forecast
+
+import "math"
+
+// Model predicts a series of observations, such as the number of requests
+// in consecutive intervals.
+type Model interface {
+	// Update adds the next observation.
+	Update(value float64)
+
+	// Forecast returns the value expected steps observations after the
+	// last one, 1 being the next, and whether the model has seen enough
+	// to tell.
+	Forecast(steps int) (value float64, ok bool)
+}
+
+// ExponentialSmoothing is a Model that forecasts the exponentially
+// weighted average of past observations, the same for every step ahead.
+// It follows the level of a series but not its trend or season. It is not
+// safe for concurrent use; a Forecaster serializes its calls.
+type ExponentialSmoothing struct {
+	alpha float64
+	level float64
+	seen  bool
+}
+
+// NewExponentialSmoothing returns a model with smoothing factor alpha,
+// between 0 and 1. A smaller alpha remembers longer.
+func NewExponentialSmoothing(alpha float64) *ExponentialSmoothing {
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	return &ExponentialSmoothing{alpha: alpha}
+}
+
+// Update implements Model.
+func (e *ExponentialSmoothing) Update(value float64) {
+	if !e.seen {
+		e.level, e.seen = value, true
+		return
+	}
+	e.level += e.alpha * (value - e.level)
+}
+
+// Forecast implements Model.
+func (e *ExponentialSmoothing) Forecast(steps int) (float64, bool) {
+	return e.level, e.seen
+}
+
+// HoltWinters is a Model with additive level, trend and season (triple
+// exponential smoothing), for traffic that repeats with a known period,
+// such as 24 hourly observations a day or 7 daily ones a week. It needs
+// a full season of observations before it forecasts. It is not safe for
+// concurrent use; a Forecaster serializes its calls.
+type HoltWinters struct {
+	alpha, beta, gamma float64
+	season             []float64
+	level, trend       float64
+	seen               int // observations so far
+	first              []float64
+}
+
+// NewHoltWinters returns a model smoothing the level by alpha, the trend
+// by beta and the season by gamma, each between 0 and 1, over a season of
+// length observations.
+func NewHoltWinters(alpha, beta, gamma float64, length int) *HoltWinters {
+	if length < 2 {
+		length = 2
+	}
+	return &HoltWinters{
+		alpha:  clampFactor(alpha, 0.3),
+		beta:   clampFactor(beta, 0.05),
+		gamma:  clampFactor(gamma, 0.3),
+		season: make([]float64, length),
+	}
+}
+
+// clampFactor returns f, or def if f is not between 0 and 1.
+func clampFactor(f, def float64) float64 {
+	if f < 0 || f > 1 {
+		return def
+	}
+	return f
+}
+
+// Update implements Model.
+func (h *HoltWinters) Update(value float64) {
+	m := len(h.season)
+	i := h.seen % m
+	h.seen++
+
+	// The first season sets the level and the seasonal offsets from it.
+	if h.seen <= m {
+		h.first = append(h.first, value)
+		if h.seen == m {
+			var sum float64
+			for _, v := range h.first {
+				sum += v
+			}
+			h.level = sum / float64(m)
+			for j, v := range h.first {
+				h.season[j] = v - h.level
+			}
+			h.first = nil
+		}
+		return
+	}
+
+	prev := h.level
+	h.level = h.alpha*(value-h.season[i]) + (1-h.alpha)*(h.level+h.trend)
+	h.trend = h.beta*(h.level-prev) + (1-h.beta)*h.trend
+	h.season[i] = h.gamma*(value-h.level) + (1-h.gamma)*h.season[i]
+}
+
+// Forecast implements Model. Forecasts are never negative.
+func (h *HoltWinters) Forecast(steps int) (float64, bool) {
+	m := len(h.season)
+	if h.seen < m {
+		return 0, false
+	}
+	steps = max(steps, 1)
+	i := (h.seen - 1 + steps) % m
+	return math.Max(h.level+float64(steps)*h.trend+h.season[i], 0), true
+}
@@ -0,0 +1,443 @@
+package geo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/client/internal/filewatch"
+	"github.com/rRateLimit/client/internal/yaml"
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/config"
+)
+
+// CountryRules decide what happens to requests by the country of the
+// client. Countries are ISO 3166-1 codes such as "JP"; Unknown stands for
+// clients whose country is unknown. A request is blocked if its country is
+// in Block, or if Allow is not empty and does not list it. Otherwise it is
+// reported to OnLog if its country is in Log, and limited if its country
+// has a limit in Limits.
+//
+// Rules can be read from YAML or JSON:
+//
+//	block: [KP]
+//	log: [unknown]
+//	limits:
+//	  CN: {rate: 10, period: 1m}
+type CountryRules struct {
+	Allow  []string                `json:"allow,omitempty"`
+	Block  []string                `json:"block,omitempty"`
+	Log    []string                `json:"log,omitempty"`
+	Limits map[string]CountryLimit `json:"limits,omitempty"`
+}
+
+// CountryLimit is the rate each client of a country is held to, with a
+// token bucket of Burst tokens refilled at Rate per Period. A burst of zero
+// means Rate.
+type CountryLimit struct {
+	Rate   int             `json:"rate"`
+	Period config.Duration `json:"period"`
+	Burst  int             `json:"burst,omitempty"`
+}
+
+// ParseCountryRules decodes rules from YAML, or JSON if data starts with
+// "{". Unknown fields are an error.
+func ParseCountryRules(data []byte) (CountryRules, error) {
+	js := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(js, []byte("{")) {
+		var err error
+		if js, err = yaml.ToJSON(data); err != nil {
+			return CountryRules{}, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	var rules CountryRules
+	if err := dec.Decode(&rules); err != nil {
+		return CountryRules{}, err
+	}
+	return rules, nil
+}
+
+// countryRules are CountryRules compiled for lookups.
+type countryRules struct {
+	rules  CountryRules
+	allow  map[string]bool
+	block  map[string]bool
+	log    map[string]bool
+	limits map[string]CountryLimit
+}
+
+// countryCode normalizes a country code of a rule.
+func countryCode(c string) (string, error) {
+	c = strings.TrimSpace(c)
+	switch {
+	case c == "":
+		return "", errors.New("empty country code")
+	case strings.EqualFold(c, Unknown):
+		return Unknown, nil
+	}
+	return strings.ToUpper(c), nil
+}
+
+// compileCountryRules validates rules and prepares them for lookups.
+func compileCountryRules(rules CountryRules) (*countryRules, error) {
+	c := &countryRules{rules: rules, limits: make(map[string]CountryLimit, len(rules.Limits))}
+	lists := []struct {
+		name  string
+		codes []string
+		set   *map[string]bool
+	}{
+		{"allow", rules.Allow, &c.allow},
+		{"block", rules.Block, &c.block},
+		{"log", rules.Log, &c.log},
+	}
+	for _, l := range lists {
+		*l.set = make(map[string]bool, len(l.codes))
+		for _, code := range l.codes {
+			code, err := countryCode(code)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", l.name, err)
+			}
+			(*l.set)[code] = true
+		}
+	}
+	for code, limit := range rules.Limits {
+		normalized, err := countryCode(code)
+		if err != nil {
+			return nil, fmt.Errorf("limits: %w", err)
+		}
+		if limit.Rate <= 0 || limit.Period <= 0 || limit.Burst < 0 {
+			return nil, fmt.Errorf("limits: %s: rate and period must be positive", code)
+		}
+		if limit.Burst == 0 {
+			limit.Burst = limit.Rate
+		}
+		c.limits[normalized] = limit
+	}
+	return c, nil
+}
+
+// CountryFilterConfig configures a CountryFilter. All fields are optional.
+type CountryFilterConfig struct {
+	// OnBlocked is called for blocked requests. If nil, a 403 is sent.
+	OnBlocked func(w http.ResponseWriter, r *http.Request, country string)
+
+	// OnLog is called for requests from a country in CountryRules.Log
+	// before they are limited and served, for example to log them.
+	OnLog func(r *http.Request, country string)
+
+	// OnRateLimited is called for requests over the limit of their
+	// country. If nil, the default response of ratelimit.Middleware is
+	// sent.
+	OnRateLimited func(w http.ResponseWriter, r *http.Request)
+
+	// KeyFunc identifies the clients held to the limit of their country.
+	// If nil, clients are told apart by the address the connection came
+	// from.
+	KeyFunc ratelimit.KeyFunc
+}
+
+// CountryStats counts the requests of one country. Logged requests are
+// also counted as allowed or rate limited.
+type CountryStats struct {
+	Allowed     int64 `json:"allowed"`
+	Blocked     int64 `json:"blocked"`
+	Logged      int64 `json:"logged"`
+	RateLimited int64 `json:"rate_limited"`
+}
+
+type countryCounters struct {
+	allowed, blocked, logged, limited int64
+}
+
+// CountryFilter is an HTTP middleware that blocks, logs or limits requests
+// by the country of the client, following CountryRules that can be changed
+// while it serves requests with Update, Reload or Watch. It counts the
+// requests of every country; see Stats.
+type CountryFilter struct {
+	provider Provider
+	config   CountryFilterConfig
+	rules    atomic.Pointer[countryRules]
+	update   sync.Mutex // serializes Update
+	limits   *ratelimit.Middleware
+
+	mu       sync.RWMutex
+	counters map[string]*countryCounters
+}
+
+// NewCountryFilter creates a filter that looks clients up in p and applies
+// rules. cfg may be nil.
+func NewCountryFilter(p Provider, rules CountryRules, cfg *CountryFilterConfig) (*CountryFilter, error) {
+	compiled, err := compileCountryRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &CountryFilter{provider: p, counters: make(map[string]*countryCounters)}
+	if cfg != nil {
+		f.config = *cfg
+	}
+	f.rules.Store(compiled)
+
+	mwConfig := ratelimit.DefaultMiddlewareConfig()
+	mwConfig.KeyedLimiterFactory = f.newLimiter
+	mwConfig.KeyFunc = f.limitKey
+	mwConfig.OnRateLimited = f.rateLimited
+	f.limits = ratelimit.NewMiddleware(mwConfig)
+	return f, nil
+}
+
+// countryKey is the context key of the client's country.
+type countryKey struct{}
+
+// CountryFromContext returns the country of the client of a request passed
+// on by a CountryFilter.
+func CountryFromContext(ctx context.Context) (string, bool) {
+	country, ok := ctx.Value(countryKey{}).(string)
+	return country, ok
+}
+
+// Country returns the country code of the client of r, or Unknown.
+func (f *CountryFilter) Country(r *http.Request) string {
+	if loc, ok := LookupRequest(f.provider, r); ok && loc.Country != "" {
+		return loc.Country
+	}
+	return Unknown
+}
+
+// Handler returns a handler that applies the rules before passing requests
+// to next. The client's country is available to next through
+// CountryFromContext.
+func (f *CountryFilter) Handler(next http.Handler) http.Handler {
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country, _ := CountryFromContext(r.Context())
+		atomic.AddInt64(&f.countersFor(country).allowed, 1)
+		next.ServeHTTP(w, r)
+	})
+	limited := f.limits.Handler(served)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := f.rules.Load()
+		country := f.Country(r)
+		counters := f.countersFor(country)
+		r = r.WithContext(context.WithValue(r.Context(), countryKey{}, country))
+
+		if rules.block[country] || (len(rules.allow) > 0 && !rules.allow[country]) {
+			atomic.AddInt64(&counters.blocked, 1)
+			if f.config.OnBlocked != nil {
+				f.config.OnBlocked(w, r, country)
+			} else {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			}
+			return
+		}
+
+		if rules.log[country] {
+			atomic.AddInt64(&counters.logged, 1)
+			if f.config.OnLog != nil {
+				f.config.OnLog(r, country)
+			}
+		}
+
+		if _, ok := rules.limits[country]; ok {
+			limited.ServeHTTP(w, r)
+			return
+		}
+		served.ServeHTTP(w, r)
+	})
+}
+
+// limitKey keys the limiters of limited countries by country and client.
+func (f *CountryFilter) limitKey(r *http.Request) string {
+	country, _ := CountryFromContext(r.Context())
+	if f.config.KeyFunc != nil {
+		return country + ":" + f.config.KeyFunc(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return country + ":" + host
+}
+
+// newLimiter creates the limiter of a key from the limit of its country.
+func (f *CountryFilter) newLimiter(key string) ratelimit.Limiter {
+	country, _, _ := strings.Cut(key, ":")
+	limit, ok := f.rules.Load().limits[country]
+	if !ok {
+		// The limit was removed by an Update since the request was
+		// dispatched; the country is no longer limited.
+		return ratelimit.NewTokenBucket(ratelimit.WithRate(math.MaxInt32), ratelimit.WithPeriod(time.Second))
+	}
+	return ratelimit.NewTokenBucket(
+		ratelimit.WithRate(limit.Rate),
+		ratelimit.WithPeriod(time.Duration(limit.Period)),
+		ratelimit.WithBurst(limit.Burst),
+	)
+}
+
+// rateLimited counts a request over the limit of its country and rejects
+// it.
+func (f *CountryFilter) rateLimited(w http.ResponseWriter, r *http.Request) {
+	country, _ := CountryFromContext(r.Context())
+	atomic.AddInt64(&f.countersFor(country).limited, 1)
+	if f.config.OnRateLimited != nil {
+		f.config.OnRateLimited(w, r)
+		return
+	}
+	(&ratelimit.ResponseBuilder{}).Write(w, r)
+}
+
+// countersFor returns the counters of country, creating them if needed.
+func (f *CountryFilter) countersFor(country string) *countryCounters {
+	f.mu.RLock()
+	c, ok := f.counters[country]
+	f.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok = f.counters[country]; !ok {
+		c = &countryCounters{}
+		f.counters[country] = c
+	}
+	return c
+}
+
+// Rules returns the rules in force.
+func (f *CountryFilter) Rules() CountryRules {
+	return f.rules.Load().rules
+}
+
+// Update replaces the rules. Requests are judged by either the old rules
+// or the new ones, and rules that do not validate change nothing. Clients
+// of a country whose limit changed keep their usage, held to the new
+// limit.
+func (f *CountryFilter) Update(rules CountryRules) error {
+	compiled, err := compileCountryRules(rules)
+	if err != nil {
+		return err
+	}
+
+	f.update.Lock()
+	defer f.update.Unlock()
+
+	old := f.rules.Load()
+	f.rules.Store(compiled)
+	f.limits.Reconfigure(f.newLimiter, func(key string, limiter ratelimit.Limiter) bool {
+		country, _, _ := strings.Cut(key, ":")
+		limit, ok := compiled.limits[country]
+		if !ok || limit == old.limits[country] {
+			// Unchanged, or unused until it idles out.
+			return true
+		}
+		rc, ok := limiter.(ratelimit.Reconfigurer)
+		if !ok {
+			return false
+		}
+		rc.Reconfigure(limit.Rate, time.Duration(limit.Period), limit.Burst)
+		return true
+	})
+	return nil
+}
+
+// Reload reads rules from file, as described on ParseCountryRules, and
+// applies them with Update.
+func (f *CountryFilter) Reload(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseCountryRules(data)
+	if err == nil {
+		err = f.Update(rules)
+	}
+	if err != nil {
+		return fmt.Errorf("country rules %s: %w", file, err)
+	}
+	return nil
+}
+
+// Watch reloads the rules from file whenever the file changes, checked by
+// its size and modification time every interval, and whenever the process
+// receives SIGHUP, until ctx is done. An interval of zero or less reloads
+// on SIGHUP only. onReload, if not nil, is called after every reload with
+// its error; a file that fails to load leaves the current rules in place.
+func (f *CountryFilter) Watch(ctx context.Context, file string, interval time.Duration, onReload func(error)) {
+	filewatch.Watch(ctx, file, interval, func() {
+		err := f.Reload(file)
+		if onReload != nil {
+			onReload(err)
+		}
+	})
+}
+
+// Stats returns the counters of every country a request came from.
+func (f *CountryFilter) Stats() map[string]CountryStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	stats := make(map[string]CountryStats, len(f.counters))
+	for country, c := range f.counters {
+		stats[country] = CountryStats{
+			Allowed:     atomic.LoadInt64(&c.allowed),
+			Blocked:     atomic.LoadInt64(&c.blocked),
+			Logged:      atomic.LoadInt64(&c.logged),
+			RateLimited: atomic.LoadInt64(&c.limited),
+		}
+	}
+	return stats
+}
+
+// StatsHandler serves Stats as JSON keyed by country code, or as
+// Prometheus text with a country label if the request has
+// ?format=prometheus.
+func (f *CountryFilter) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := f.Stats()
+		if r.URL.Query().Get("format") != "prometheus" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+			return
+		}
+
+		countries := make([]string, 0, len(stats))
+		for country := range stats {
+			countries = append(countries, country)
+		}
+		sort.Strings(countries)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP ratelimit_geo_requests_total Requests by client country and outcome.")
+		fmt.Fprintln(w, "# TYPE ratelimit_geo_requests_total counter")
+		for _, country := range countries {
+			s := stats[country]
+			for _, o := range []struct {
+				name string
+				n    int64
+			}{{"allowed", s.Allowed}, {"blocked", s.Blocked}, {"logged", s.Logged}, {"rate_limited", s.RateLimited}} {
+				fmt.Fprintf(w, "ratelimit_geo_requests_total{country=%q,outcome=%q} %d\n", country, o.name, o.n)
+			}
+		}
+	})
+}
+
+// Close stops the cleanup of idle client limiters.
+func (f *CountryFilter) Close() {
+	f.limits.Close()
+}
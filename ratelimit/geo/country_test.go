@@ -0,0 +1,181 @@
+package geo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/client/ratelimit/geo"
+)
+
+func TestParseCountryRules(t *testing.T) {
+	yamlRules, err := geo.ParseCountryRules([]byte("block: [KP]\nlog: [unknown]\nlimits:\n  JP: {rate: 10, period: 1m}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonRules, err := geo.ParseCountryRules([]byte(`{"block": ["KP"], "log": ["unknown"], "limits": {"JP": {"rate": 10, "period": "1m"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rules := range []geo.CountryRules{yamlRules, jsonRules} {
+		if len(rules.Block) != 1 || rules.Log[0] != geo.Unknown || rules.Limits["JP"].Rate != 10 {
+			t.Errorf("rules = %+v", rules)
+		}
+	}
+
+	if _, err := geo.ParseCountryRules([]byte("deny: [KP]\n")); err == nil {
+		t.Error("ParseCountryRules accepted an unknown field")
+	}
+}
+
+func TestNewCountryFilterRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules geo.CountryRules
+		want  string
+	}{
+		{"empty code", geo.CountryRules{Block: []string{" "}}, "block: empty country code"},
+		{"zero rate", geo.CountryRules{Limits: map[string]geo.CountryLimit{"JP": {Period: 1}}}, "limits: JP: rate and period must be positive"},
+		{"negative burst", geo.CountryRules{Limits: map[string]geo.CountryLimit{"JP": {Rate: 1, Period: 1, Burst: -1}}}, "limits: JP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := geo.NewCountryFilter(newTable(t), tt.rules, nil)
+			if err == nil {
+				f.Close()
+				t.Fatal("NewCountryFilter succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountryFilter(t *testing.T) {
+	rules, err := geo.ParseCountryRules([]byte("block: [fr]\nlog: [unknown, jp]\nlimits:\n  jp: {rate: 2, period: 1h}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	f, err := geo.NewCountryFilter(newTable(t), rules, &geo.CountryFilterConfig{
+		OnLog: func(r *http.Request, country string) { logged = append(logged, country) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var served string
+	h := f.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served, _ = geo.CountryFromContext(r.Context())
+	}))
+	serve := func(remoteAddr string) int {
+		served = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request(remoteAddr))
+		return w.Code
+	}
+
+	if got := serve("192.0.2.1:1"); got != http.StatusForbidden || served != "" {
+		t.Errorf("FR = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := serve("203.0.113.5:1"); got != http.StatusOK || served != "US" {
+		t.Errorf("US = %d served as %q, want %d as US", got, served, http.StatusOK)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := serve("198.51.100.7:1"); got != want {
+			t.Errorf("JP #%d = %d, want %d", i+1, got, want)
+		}
+	}
+	if got := serve("198.51.100.8:1"); got != http.StatusOK {
+		t.Errorf("another JP client = %d, want %d: clients have their own limit", got, http.StatusOK)
+	}
+	if got := serve("10.0.0.1:1"); got != http.StatusOK || served != geo.Unknown {
+		t.Errorf("unknown = %d served as %q", got, served)
+	}
+
+	if got, want := strings.Join(logged, ","), "JP,JP,JP,JP,unknown"; got != want {
+		t.Errorf("logged %s, want %s", got, want)
+	}
+	stats := f.Stats()
+	if s := stats["JP"]; s.Allowed != 3 || s.RateLimited != 1 || s.Logged != 4 {
+		t.Errorf("JP stats = %+v", s)
+	}
+	if s := stats["FR"]; s.Blocked != 1 || s.Allowed != 0 {
+		t.Errorf("FR stats = %+v", s)
+	}
+
+	w := httptest.NewRecorder()
+	f.StatsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?format=prometheus", nil))
+	if line := `ratelimit_geo_requests_total{country="JP",outcome="rate_limited"} 1`; !strings.Contains(w.Body.String(), line) {
+		t.Errorf("Prometheus stats lack %s:\n%s", line, w.Body)
+	}
+}
+
+func TestCountryFilterAllowList(t *testing.T) {
+	f, err := geo.NewCountryFilter(newTable(t), geo.CountryRules{Allow: []string{"JP", "unknown"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := f.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for remoteAddr, want := range map[string]int{
+		"198.51.100.7:1": http.StatusOK,
+		"10.0.0.1:1":     http.StatusOK,
+		"203.0.113.5:1":  http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request(remoteAddr))
+		if w.Code != want {
+			t.Errorf("%s = %d, want %d", remoteAddr, w.Code, want)
+		}
+	}
+}
+
+func TestCountryFilterReloadKeepsUsage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "countries.yaml")
+	write := func(rules string) {
+		if err := os.WriteFile(file, []byte(rules), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("limits:\n  JP: {rate: 2, period: 1h}\n")
+	f, err := geo.NewCountryFilter(newTable(t), geo.CountryRules{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Reload(file); err != nil {
+		t.Fatal(err)
+	}
+	h := f.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("198.51.100.7:1"))
+		return w.Code
+	}
+
+	serve()
+	serve()
+	write("limits:\n  JP: {rate: 3, period: 1h}\n")
+	if err := f.Reload(file); err != nil {
+		t.Fatal(err)
+	}
+	// The client keeps its empty bucket; a new one would admit it.
+	if got := serve(); got != http.StatusTooManyRequests {
+		t.Errorf("request after raising the limit = %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	write("limits:\n  JP: {rate: 0, period: 1h}\n")
+	if err := f.Reload(file); err == nil || !strings.Contains(err.Error(), file) {
+		t.Errorf("Reload of invalid rules = %v, want an error naming the file", err)
+	}
+	if got := f.Rules().Limits["JP"].Rate; got != 3 {
+		t.Errorf("rate after a failed reload = %d, want 3", got)
+	}
+}
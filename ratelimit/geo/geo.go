@@ -0,0 +1,258 @@
+// Package geo limits requests by where they come from. It looks clients
+// up in a geolocation database, either a MaxMind DB file such as GeoLite2
+// Country or GeoIP2 City (see Open) or an in-memory table of networks
+// (see Table), and provides key functions and tiered policies by
+// continent, country and region for ratelimit.Middleware:
+//
+//	db, err := geo.Open("/var/lib/GeoIP/GeoLite2-City.mmdb")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer db.Close()
+//
+//	policy := geo.NewPolicy(db)
+//	policy.Add("US-CA", "california", californiaFactory)
+//	policy.Add("JP", "japan", japanFactory)
+//	policy.Add("continent:EU", "europe", europeFactory)
+//
+//	config := ratelimit.DefaultMiddlewareConfig()
+//	config.KeyFunc = geo.CountryKeyFunc(db)
+//	config.TierFunc = policy.TierFunc
+//	config.Tiers = policy.Tiers()
+//
+// Clients are looked up by the address the connection came from
+// (RemoteAddr), never by forwarding headers, which clients can forge.
+package geo
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Location is where an address is registered.
+type Location struct {
+	// Continent is the two-letter continent code, such as "EU" or "AS".
+	Continent string
+
+	// Country is the ISO 3166-1 country code, such as "JP".
+	Country string
+
+	// Region is the ISO 3166-2 code of the country's first-level
+	// subdivision without the country prefix, such as "CA" for
+	// California, or "" if the database has none.
+	Region string
+}
+
+// Provider looks up the location of an address.
+type Provider interface {
+	// Lookup returns the location of ip, or ok false if it is unknown.
+	Lookup(ip net.IP) (loc Location, ok bool)
+}
+
+// Table is a Provider backed by an in-memory table of networks, for tests,
+// private ranges and deployments without a database file. An address
+// belongs to the most specific network that contains it. A Table is safe
+// for concurrent use.
+type Table struct {
+	mu      sync.RWMutex
+	entries []tableEntry
+}
+
+type tableEntry struct {
+	network *net.IPNet
+	ones    int
+	loc     Location
+}
+
+// NewTable creates an empty table.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Add records that the addresses of network, in CIDR notation or a single
+// address, are at loc. Adding a network again replaces its location.
+func (t *Table) Add(network string, loc Location) error {
+	nets, err := ratelimit.ParseCIDRs(network)
+	if err != nil {
+		return err
+	}
+	n := nets[0]
+	ones, _ := n.Mask.Size()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.entries {
+		if t.entries[i].network.String() == n.String() {
+			t.entries[i].loc = loc
+			return nil
+		}
+	}
+	t.entries = append(t.entries, tableEntry{network: n, ones: ones, loc: loc})
+	return nil
+}
+
+// Lookup returns the location of the most specific network containing ip.
+func (t *Table) Lookup(ip net.IP) (Location, bool) {
+	if ip == nil {
+		return Location{}, false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	best := -1
+	for i := range t.entries {
+		e := &t.entries[i]
+		if e.network.Contains(ip) && (best < 0 || e.ones > t.entries[best].ones) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Location{}, false
+	}
+	return t.entries[best].loc, true
+}
+
+// Chain returns a Provider that asks each of providers in turn and
+// returns the first location found, for example a database file followed
+// by a Table covering private ranges it does not know.
+func Chain(providers ...Provider) Provider {
+	return chain(providers)
+}
+
+type chain []Provider
+
+func (c chain) Lookup(ip net.IP) (Location, bool) {
+	for _, p := range c {
+		if loc, ok := p.Lookup(ip); ok {
+			return loc, true
+		}
+	}
+	return Location{}, false
+}
+
+// Unknown is the key of clients whose location is unknown.
+const Unknown = "unknown"
+
+// CountryKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// country of the client, so every client of a country shares one limit.
+// Clients whose country is unknown share the key Unknown.
+func CountryKeyFunc(p Provider) ratelimit.KeyFunc {
+	return func(r *http.Request) string {
+		if loc, ok := LookupRequest(p, r); ok && loc.Country != "" {
+			return loc.Country
+		}
+		return Unknown
+	}
+}
+
+// RegionKeyFunc returns a ratelimit.KeyFunc that keys requests by the
+// region of the client, such as "US-CA", or by its country if the region
+// is unknown.
+func RegionKeyFunc(p Provider) ratelimit.KeyFunc {
+	return func(r *http.Request) string {
+		loc, ok := LookupRequest(p, r)
+		switch {
+		case !ok || loc.Country == "":
+			return Unknown
+		case loc.Region == "":
+			return loc.Country
+		}
+		return loc.Country + "-" + loc.Region
+	}
+}
+
+// LookupRequest returns the location of the client of r, by its
+// RemoteAddr.
+func LookupRequest(p Provider, r *http.Request) (Location, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return Location{}, false
+	}
+	return p.Lookup(ip)
+}
+
+// Policy assigns clients to tiers by location. Places are regions such as
+// "US-CA", countries such as "JP" or continents such as "continent:EU";
+// a client belongs to the tier of its region if it has one, else to that
+// of its country, else to that of its continent. Places must be added
+// before the policy is used; Add must not be called concurrently with
+// TierFunc.
+type Policy struct {
+	provider Provider
+	places   map[string]string
+	tiers    map[string]func() ratelimit.Limiter
+}
+
+// NewPolicy creates an empty policy that looks clients up in p.
+func NewPolicy(p Provider) *Policy {
+	return &Policy{
+		provider: p,
+		places:   make(map[string]string),
+		tiers:    make(map[string]func() ratelimit.Limiter),
+	}
+}
+
+// Add assigns the clients of place to tier, whose limiters come from
+// factory. Several places may share a tier; a nil factory keeps the one
+// the tier already has.
+func (p *Policy) Add(place, tier string, factory func() ratelimit.Limiter) error {
+	if place == "" || place == "continent:" {
+		return fmt.Errorf("geo: empty place")
+	}
+	if factory == nil && p.tiers[tier] == nil {
+		return fmt.Errorf("geo: tier %q has no limiter factory", tier)
+	}
+	if factory != nil {
+		p.tiers[tier] = factory
+	}
+	if continent, ok := strings.CutPrefix(place, "continent:"); ok {
+		place = "continent:" + strings.ToUpper(continent)
+	} else {
+		place = strings.ToUpper(place)
+	}
+	p.places[place] = tier
+	return nil
+}
+
+// TierFunc returns the tier of the client of r, or "" if its location is
+// in no place of the policy. It is a ratelimit.MiddlewareConfig.TierFunc;
+// requests in no tier use the LimiterFactory of the configuration.
+func (p *Policy) TierFunc(r *http.Request) string {
+	loc, ok := LookupRequest(p.provider, r)
+	if !ok {
+		return ""
+	}
+	if loc.Region != "" {
+		if tier, ok := p.places[loc.Country+"-"+loc.Region]; ok {
+			return tier
+		}
+	}
+	if tier, ok := p.places[loc.Country]; ok && loc.Country != "" {
+		return tier
+	}
+	if tier, ok := p.places["continent:"+loc.Continent]; ok && loc.Continent != "" {
+		return tier
+	}
+	return ""
+}
+
+// Tiers returns the limiter factory of every tier, for
+// ratelimit.MiddlewareConfig.Tiers.
+func (p *Policy) Tiers() map[string]func() ratelimit.Limiter {
+	tiers := make(map[string]func() ratelimit.Limiter, len(p.tiers))
+	for tier, factory := range p.tiers {
+		tiers[tier] = factory
+	}
+	return tiers
+}
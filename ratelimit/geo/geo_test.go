@@ -0,0 +1,162 @@
+package geo_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/geo"
+)
+
+// newTable returns a table of the networks the tests use.
+func newTable(t *testing.T) *geo.Table {
+	t.Helper()
+	table := geo.NewTable()
+	for network, loc := range map[string]geo.Location{
+		"203.0.113.0/24":  {Continent: "NA", Country: "US", Region: "CA"},
+		"203.0.113.0/28":  {Continent: "NA", Country: "US", Region: "NY"},
+		"198.51.100.0/24": {Continent: "AS", Country: "JP"},
+		"192.0.2.0/24":    {Continent: "EU", Country: "FR"},
+		"2001:db8::/32":   {Continent: "EU", Country: "DE", Region: "BE"},
+	} {
+		if err := table.Add(network, loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return table
+}
+
+func request(remoteAddr string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestTableLookupMostSpecific(t *testing.T) {
+	table := newTable(t)
+
+	tests := []struct {
+		ip   string
+		want string
+		ok   bool
+	}{
+		{"203.0.113.5", "NY", true},
+		{"203.0.113.200", "CA", true},
+		{"2001:db8::1", "BE", true},
+		{"10.0.0.1", "", false},
+	}
+	for _, tt := range tests {
+		loc, ok := table.Lookup(net.ParseIP(tt.ip))
+		if loc.Region != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%s) = %+v, %v, want region %q, %v", tt.ip, loc, ok, tt.want, tt.ok)
+		}
+	}
+
+	if err := table.Add("203.0.113.0/28", geo.Location{Country: "US", Region: "NJ"}); err != nil {
+		t.Fatal(err)
+	}
+	if loc, _ := table.Lookup(net.ParseIP("203.0.113.5")); loc.Region != "NJ" {
+		t.Errorf("after replacing the network, region = %q, want NJ", loc.Region)
+	}
+	if err := table.Add("not a network", geo.Location{}); err == nil {
+		t.Error("Add accepted an invalid network")
+	}
+}
+
+func TestChain(t *testing.T) {
+	private := geo.NewTable()
+	private.Add("10.0.0.0/8", geo.Location{Country: "ZZ"})
+	p := geo.Chain(newTable(t), private)
+
+	if loc, ok := p.Lookup(net.ParseIP("10.1.2.3")); !ok || loc.Country != "ZZ" {
+		t.Errorf("Lookup(10.1.2.3) = %+v, %v, want ZZ from the second provider", loc, ok)
+	}
+	if loc, ok := p.Lookup(net.ParseIP("192.0.2.1")); !ok || loc.Country != "FR" {
+		t.Errorf("Lookup(192.0.2.1) = %+v, %v, want FR", loc, ok)
+	}
+	if _, ok := p.Lookup(net.ParseIP("172.16.0.1")); ok {
+		t.Error("Lookup(172.16.0.1) found a location")
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	table := newTable(t)
+	country, region := geo.CountryKeyFunc(table), geo.RegionKeyFunc(table)
+
+	tests := []struct {
+		remoteAddr      string
+		country, region string
+	}{
+		{"203.0.113.200:1234", "US", "US-CA"},
+		{"198.51.100.7:1234", "JP", "JP"},
+		{"[2001:db8::1]:443", "DE", "DE-BE"},
+		{"192.0.2.1", "FR", "FR"},
+		{"10.0.0.1:1234", geo.Unknown, geo.Unknown},
+		{"@", geo.Unknown, geo.Unknown},
+	}
+	for _, tt := range tests {
+		r := request(tt.remoteAddr)
+		if got := country(r); got != tt.country {
+			t.Errorf("CountryKeyFunc(%s) = %q, want %q", tt.remoteAddr, got, tt.country)
+		}
+		if got := region(r); got != tt.region {
+			t.Errorf("RegionKeyFunc(%s) = %q, want %q", tt.remoteAddr, got, tt.region)
+		}
+	}
+
+	// Forwarding headers are not trusted.
+	r := request("10.0.0.1:1234")
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	if got := country(r); got != geo.Unknown {
+		t.Errorf("CountryKeyFunc with X-Forwarded-For = %q, want %q", got, geo.Unknown)
+	}
+}
+
+func TestPolicyTierFunc(t *testing.T) {
+	factory := func() ratelimit.Limiter {
+		return ratelimit.NewFixedWindow(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour))
+	}
+	p := geo.NewPolicy(newTable(t))
+	for _, a := range []struct{ place, tier string }{
+		{"us-ca", "california"},
+		{"US", "us"},
+		{"continent:eu", "europe"},
+		{"DE-BE", "berlin"},
+	} {
+		if err := p.Add(a.place, a.tier, factory); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Add("JP", "europe", nil); err != nil {
+		t.Errorf("Add with the factory of an existing tier: %v", err)
+	}
+	if err := p.Add("KR", "asia", nil); err == nil {
+		t.Error("Add accepted a new tier without a factory")
+	}
+	if err := p.Add("continent:", "asia", factory); err == nil {
+		t.Error("Add accepted an empty place")
+	}
+
+	tests := []struct {
+		remoteAddr string
+		tier       string
+	}{
+		{"203.0.113.200:1", "california"},
+		{"203.0.113.5:1", "us"},
+		{"[2001:db8::1]:1", "berlin"},
+		{"192.0.2.1:1", "europe"},
+		{"198.51.100.7:1", "europe"},
+		{"10.0.0.1:1", ""},
+	}
+	for _, tt := range tests {
+		if got := p.TierFunc(request(tt.remoteAddr)); got != tt.tier {
+			t.Errorf("TierFunc(%s) = %q, want %q", tt.remoteAddr, got, tt.tier)
+		}
+	}
+	if tiers := p.Tiers(); len(tiers) != 4 {
+		t.Errorf("Tiers has %d tiers, want 4", len(tiers))
+	}
+}
@@ -0,0 +1,421 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// Reader is a Provider backed by a MaxMind DB file (.mmdb), the format of
+// the GeoLite2 and GeoIP2 databases. The file is read into memory once;
+// to pick up a new release, open it again and swap the Reader. A Reader is
+// safe for concurrent use.
+type Reader struct {
+	meta      Metadata
+	tree      []byte
+	data      []byte
+	nodeBytes int
+	ipv4Start uint
+}
+
+// Metadata describes a MaxMind DB file.
+type Metadata struct {
+	// DatabaseType is the kind of database, such as "GeoLite2-City".
+	DatabaseType string
+
+	// BuildTime is when the file was built.
+	BuildTime time.Time
+
+	// IPVersion is 4 for a database of IPv4 addresses only, else 6.
+	IPVersion int
+
+	// Languages are the languages names are given in.
+	Languages []string
+
+	// Description is the description of the database by language.
+	Description map[string]string
+
+	// NodeCount and RecordSize describe the search tree.
+	NodeCount  uint
+	RecordSize int
+}
+
+// metadataMarker precedes the metadata at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// ErrInvalidDatabase is returned for a file that is not a valid MaxMind
+// DB.
+var ErrInvalidDatabase = errors.New("geo: invalid MaxMind DB")
+
+// Open reads the MaxMind DB file at path.
+func Open(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// FromBytes reads a MaxMind DB from the contents of its file. The Reader
+// keeps data, which must not be modified afterwards.
+func FromBytes(data []byte) (*Reader, error) {
+	i := bytes.LastIndex(data, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrInvalidDatabase)
+	}
+	start := i + len(metadataMarker)
+	raw, _, err := (&decoder{data: data[start:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	meta := Metadata{
+		DatabaseType: stringOf(m["database_type"]),
+		BuildTime:    time.Unix(int64(uintOf(m["build_epoch"])), 0).UTC(),
+		IPVersion:    int(uintOf(m["ip_version"])),
+		NodeCount:    uint(uintOf(m["node_count"])),
+		RecordSize:   int(uintOf(m["record_size"])),
+		Description:  make(map[string]string),
+	}
+	if langs, ok := m["languages"].([]any); ok {
+		for _, l := range langs {
+			meta.Languages = append(meta.Languages, stringOf(l))
+		}
+	}
+	if desc, ok := m["description"].(map[string]any); ok {
+		for lang, d := range desc {
+			meta.Description[lang] = stringOf(d)
+		}
+	}
+
+	if major := uintOf(m["binary_format_major_version"]); major != 2 {
+		return nil, fmt.Errorf("%w: format version %d", ErrInvalidDatabase, major)
+	}
+	switch meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidDatabase, meta.RecordSize)
+	}
+	if meta.IPVersion != 4 && meta.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", ErrInvalidDatabase, meta.IPVersion)
+	}
+
+	r := &Reader{meta: meta, nodeBytes: meta.RecordSize / 4}
+	treeSize := meta.NodeCount * uint(r.nodeBytes)
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("%w: search tree exceeds file", ErrInvalidDatabase)
+	}
+	r.tree = data[:treeSize]
+	r.data = data[treeSize+16 : i]
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree.
+	if meta.IPVersion == 6 {
+		node := uint(0)
+		for b := 0; b < 96 && node < meta.NodeCount; b++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Metadata returns the description of the database.
+func (r *Reader) Metadata() Metadata {
+	return r.meta
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node uint, bit int) uint {
+	b := r.tree[node*uint(r.nodeBytes):]
+	switch r.meta.RecordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Record returns the data recorded for ip, decoded into maps, slices,
+// strings, bools, []byte, float64, float32, int32, uint64 and *big.Int,
+// and the length in bits of the network prefix it is recorded for, an
+// IPv4 prefix for an IPv4 address. The data is nil if the database has no
+// record for ip.
+func (r *Reader) Record(ip net.IP) (any, int, error) {
+	node := uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		node = r.ipv4Start
+	} else if ip = ip.To16(); ip == nil || r.meta.IPVersion == 4 {
+		return nil, 0, nil
+	}
+
+	bits := len(ip) * 8
+	i := 0
+	for ; i < bits && node < r.meta.NodeCount; i++ {
+		node = r.record(node, int(ip[i/8]>>(7-uint(i%8)))&1)
+	}
+	prefix := i
+
+	switch {
+	case node == r.meta.NodeCount:
+		return nil, prefix, nil
+	case node < r.meta.NodeCount:
+		return nil, 0, fmt.Errorf("%w: search tree too deep", ErrInvalidDatabase)
+	}
+	offset := node - r.meta.NodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, 0, fmt.Errorf("%w: data offset %d out of range", ErrInvalidDatabase, offset)
+	}
+	v, _, err := (&decoder{data: r.data}).decode(offset, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	return v, prefix, nil
+}
+
+// Lookup returns the location of ip from a GeoIP2 or GeoLite2 Country or
+// City record. Addresses with neither a country nor a continent, and
+// addresses the database cannot be read for, are unknown.
+func (r *Reader) Lookup(ip net.IP) (Location, bool) {
+	v, _, err := r.Record(ip)
+	if err != nil || v == nil {
+		return Location{}, false
+	}
+	m, _ := v.(map[string]any)
+
+	loc := Location{
+		Continent: stringOf(field(m, "continent", "code")),
+		Country:   stringOf(field(m, "country", "iso_code")),
+	}
+	if loc.Country == "" {
+		loc.Country = stringOf(field(m, "registered_country", "iso_code"))
+	}
+	if subs, ok := m["subdivisions"].([]any); ok && len(subs) > 0 {
+		sub, _ := subs[0].(map[string]any)
+		loc.Region = stringOf(sub["iso_code"])
+	}
+	if loc.Country == "" && loc.Continent == "" {
+		return Location{}, false
+	}
+	return loc, true
+}
+
+// field returns m[outer][inner], or nil.
+func field(m map[string]any, outer, inner string) any {
+	o, _ := m[outer].(map[string]any)
+	return o[inner]
+}
+
+func stringOf(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func uintOf(v any) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
+
+// maxDepth bounds the nesting of decoded values, so a corrupt file whose
+// pointers form a cycle fails instead of exhausting the stack.
+const maxDepth = 64
+
+// decoder decodes the data section of a MaxMind DB. Pointers are offsets
+// into data.
+type decoder struct {
+	data []byte
+}
+
+// Data types of the MaxMind DB format.
+const (
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+var errTruncated = errors.New("truncated data")
+
+// bytes returns the n bytes at offset.
+func (d *decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset > uint(len(d.data)) || n > uint(len(d.data))-offset {
+		return nil, errTruncated
+	}
+	return d.data[offset : offset+n], nil
+}
+
+// decode decodes the value at offset and returns it with the offset that
+// follows it.
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		size := uint(ctrl>>3) & 3
+		b, err := d.bytes(offset, size+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var target uint
+		switch size {
+		case 0:
+			target = uint(ctrl&7)<<8 | uint(b[0])
+		case 1:
+			target = (uint(ctrl&7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			target = (uint(ctrl&7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			target = uint(binary.BigEndian.Uint32(b))
+		}
+		v, _, err := d.decode(target, depth+1)
+		return v, offset + size + 1, err
+	}
+	if typ == 0 {
+		b, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(b[0])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			v, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEnd:
+		return nil, 0, fmt.Errorf("unexpected data type %d", typ)
+	}
+
+	b, err = d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("unsigned integer of %d bytes", size)
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of %d bytes", size)
+		}
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		return int32(u), offset, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("uint128 of %d bytes", size)
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
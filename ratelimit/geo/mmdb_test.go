@@ -0,0 +1,301 @@
+package geo_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit/geo"
+)
+
+// mmdb builds MaxMind DB files with 24-bit records for tests. Networks
+// must not overlap.
+type mmdb struct {
+	ipVersion int
+	root      *trieNode
+	data      bytes.Buffer
+}
+
+type trieNode struct {
+	child [2]*trieNode
+	data  [2]int // offset+1 of the data of a leaf record, or 0
+}
+
+func newMMDB(ipVersion int) *mmdb {
+	return &mmdb{ipVersion: ipVersion, root: &trieNode{}}
+}
+
+// insert records value for network. IPv4 networks of an IPv6 database go
+// under ::/96.
+func (db *mmdb) insert(t *testing.T, network string, value any) {
+	t.Helper()
+	_, n, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := []byte(n.IP)
+	ones, _ := n.Mask.Size()
+	if v4 := n.IP.To4(); v4 != nil && db.ipVersion == 6 {
+		ip, ones = append(make([]byte, 12), v4...), ones+96
+	}
+	db.insertRaw(ip, ones, db.encode(value))
+}
+
+// insertRaw records the data at offset for the first ones bits of ip.
+func (db *mmdb) insertRaw(ip []byte, ones, offset int) {
+	node := db.root
+	for i := 0; i < ones-1; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		if node.child[bit] == nil {
+			node.child[bit] = &trieNode{}
+		}
+		node = node.child[bit]
+	}
+	node.data[ip[(ones-1)/8]>>(7-uint((ones-1)%8))&1] = offset + 1
+}
+
+// encode appends v to the data section and returns its offset.
+func (db *mmdb) encode(v any) int {
+	offset := db.data.Len()
+	writeValue(&db.data, v)
+	return offset
+}
+
+// bytes returns the file.
+func (db *mmdb) bytes(meta map[string]any) []byte {
+	var nodes []*trieNode
+	index := make(map[*trieNode]int)
+	var number func(n *trieNode)
+	number = func(n *trieNode) {
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil {
+				number(c)
+			}
+		}
+	}
+	number(db.root)
+
+	count := len(nodes)
+	var file bytes.Buffer
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := count
+			switch {
+			case n.child[bit] != nil:
+				record = index[n.child[bit]]
+			case n.data[bit] != 0:
+				record = count + 16 + n.data[bit] - 1
+			}
+			file.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(db.data.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+
+	m := map[string]any{
+		"binary_format_major_version": uint64(2),
+		"binary_format_minor_version": uint64(0),
+		"ip_version":                  uint64(db.ipVersion),
+		"node_count":                  uint64(count),
+		"record_size":                 uint64(24),
+		"database_type":               "Test-City",
+		"build_epoch":                 uint64(1700000000),
+		"languages":                   []any{"en"},
+		"description":                 map[string]any{"en": "test database"},
+	}
+	for k, v := range meta {
+		m[k] = v
+	}
+	writeValue(&file, m)
+	return file.Bytes()
+}
+
+// writeValue encodes v in the MaxMind DB data format.
+func writeValue(w *bytes.Buffer, v any) {
+	control := func(typ, size int) {
+		if typ <= 7 {
+			w.WriteByte(byte(typ<<5 | size))
+		} else {
+			w.Write([]byte{byte(size), byte(typ - 7)})
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		w.WriteString(v)
+	case uint64:
+		var b []byte
+		for ; v > 0; v >>= 8 {
+			b = append([]byte{byte(v)}, b...)
+		}
+		control(9, len(b))
+		w.Write(b)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		control(14, size)
+	case []any:
+		control(11, len(v))
+		for _, e := range v {
+			writeValue(w, e)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		control(7, len(keys))
+		for _, k := range keys {
+			writeValue(w, k)
+			writeValue(w, v[k])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// city returns a GeoIP2 City record.
+func city(continent, country, region string) map[string]any {
+	m := map[string]any{
+		"continent": map[string]any{"code": continent},
+		"country":   map[string]any{"iso_code": country},
+	}
+	if region != "" {
+		m["subdivisions"] = []any{map[string]any{"iso_code": region}}
+	}
+	return m
+}
+
+func TestReaderLookup(t *testing.T) {
+	db := newMMDB(6)
+	db.insert(t, "81.2.69.0/24", city("EU", "GB", "ENG"))
+	db.insert(t, "89.160.0.0/16", city("EU", "SE", ""))
+	db.insert(t, "175.16.199.0/24", city("AS", "CN", "22"))
+	db.insert(t, "2001:db8::/32", city("NA", "US", "CA"))
+	db.insert(t, "192.0.2.0/24", map[string]any{"registered_country": map[string]any{"iso_code": "JP"}})
+	db.insert(t, "198.51.100.0/24", map[string]any{"traits": map[string]any{"is_anycast": true}})
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, db.bytes(nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := geo.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want geo.Location
+		ok   bool
+	}{
+		{"81.2.69.142", geo.Location{Continent: "EU", Country: "GB", Region: "ENG"}, true},
+		{"89.160.20.112", geo.Location{Continent: "EU", Country: "SE"}, true},
+		{"175.16.199.1", geo.Location{Continent: "AS", Country: "CN", Region: "22"}, true},
+		{"::ffff:175.16.199.1", geo.Location{Continent: "AS", Country: "CN", Region: "22"}, true},
+		{"2001:db8::1", geo.Location{Continent: "NA", Country: "US", Region: "CA"}, true},
+		{"192.0.2.1", geo.Location{Country: "JP"}, true},
+		{"198.51.100.1", geo.Location{}, false},
+		{"10.0.0.1", geo.Location{}, false},
+		{"2001:db9::1", geo.Location{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := r.Lookup(net.ParseIP(tt.ip))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%s) = %+v, %v, want %+v, %v", tt.ip, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, prefix, err := r.Record(net.ParseIP("81.2.69.142")); prefix != 24 || err != nil {
+		t.Errorf("Record(81.2.69.142) prefix = %d, %v, want 24", prefix, err)
+	}
+	if _, prefix, err := r.Record(net.ParseIP("2001:db8::1")); prefix != 32 || err != nil {
+		t.Errorf("Record(2001:db8::1) prefix = %d, %v, want 32", prefix, err)
+	}
+
+	meta := r.Metadata()
+	want := geo.Metadata{
+		DatabaseType: "Test-City",
+		BuildTime:    time.Unix(1700000000, 0).UTC(),
+		IPVersion:    6,
+		Languages:    []string{"en"},
+		Description:  map[string]string{"en": "test database"},
+		NodeCount:    meta.NodeCount,
+		RecordSize:   24,
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("Metadata = %+v, want %+v", meta, want)
+	}
+}
+
+func TestReaderIPv4Database(t *testing.T) {
+	db := newMMDB(4)
+	db.insert(t, "203.0.113.0/24", city("OC", "AU", ""))
+	r, err := geo.FromBytes(db.bytes(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := r.Lookup(net.ParseIP("203.0.113.9")); !ok || got.Country != "AU" {
+		t.Errorf("Lookup(203.0.113.9) = %+v, %v, want AU", got, ok)
+	}
+	if v, _, err := r.Record(net.ParseIP("2001:db8::1")); v != nil || err != nil {
+		t.Errorf("Record of an IPv6 address = %v, %v, want nothing", v, err)
+	}
+}
+
+func TestReaderRejectsInvalidDatabases(t *testing.T) {
+	valid := func(meta map[string]any) []byte {
+		db := newMMDB(6)
+		db.insert(t, "192.0.2.0/24", city("NA", "US", ""))
+		return db.bytes(meta)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no metadata", []byte("not a database")},
+		{"format version", valid(map[string]any{"binary_format_major_version": uint64(3)})},
+		{"record size", valid(map[string]any{"record_size": uint64(20)})},
+		{"IP version", valid(map[string]any{"ip_version": uint64(5)})},
+		{"tree exceeds file", valid(map[string]any{"node_count": uint64(1 << 20)})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := geo.FromBytes(tt.data); !errors.Is(err, geo.ErrInvalidDatabase) {
+				t.Errorf("FromBytes = %v, want ErrInvalidDatabase", err)
+			}
+		})
+	}
+}
+
+func TestReaderRejectsPointerCycle(t *testing.T) {
+	db := newMMDB(4)
+	// A pointer to itself.
+	db.data.Write([]byte{1 << 5, 0})
+	db.insertRaw(net.ParseIP("192.0.2.0").To4(), 24, 0)
+	r, err := geo.FromBytes(db.bytes(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := r.Record(net.ParseIP("192.0.2.1")); !errors.Is(err, geo.ErrInvalidDatabase) {
+		t.Errorf("Record = %v, want ErrInvalidDatabase", err)
+	}
+	if _, ok := r.Lookup(net.ParseIP("192.0.2.1")); ok {
+		t.Error("Lookup found a location in a corrupt record")
+	}
+}
@@ -0,0 +1,239 @@
+// Package health provides liveness and readiness endpoints for limiter
+// infrastructure such as ratelimitd, so orchestration platforms can restart
+// or route around unhealthy instances.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check reports the health of one dependency or subsystem. It returns nil
+// when healthy. A check must return once ctx is done: a timed out check is
+// reported as failed right away, but its goroutine lives until it returns.
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of running one Check.
+type CheckResult struct {
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Report is the JSON body served by the health endpoints.
+type Report struct {
+	Status    string                 `json:"status"`
+	Timestamp time.Time              `json:"timestamp"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Status values used in reports.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Registry holds named liveness and readiness checks.
+type Registry struct {
+	liveness  map[string]Check
+	readiness map[string]Check
+	timeout   time.Duration
+	mu        sync.RWMutex
+}
+
+// NewRegistry creates a registry whose checks are each bounded by timeout.
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &Registry{
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+		timeout:   timeout,
+	}
+}
+
+// AddLiveness registers a check that must pass for the process to be
+// considered alive. A failing liveness check should lead to a restart.
+func (r *Registry) AddLiveness(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.liveness[name] = check
+}
+
+// AddReadiness registers a check that must pass before the process should
+// receive traffic.
+func (r *Registry) AddReadiness(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readiness[name] = check
+}
+
+// Live runs the liveness checks.
+func (r *Registry) Live(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := copyChecks(r.liveness)
+	r.mu.RUnlock()
+
+	return r.run(ctx, checks)
+}
+
+// Ready runs the liveness and readiness checks; a process that is not
+// alive is not ready either.
+func (r *Registry) Ready(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := copyChecks(r.liveness)
+	for name, check := range r.readiness {
+		checks[name] = check
+	}
+	r.mu.RUnlock()
+
+	return r.run(ctx, checks)
+}
+
+// LiveHandler serves the liveness report, typically at /healthz.
+func (r *Registry) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Live(req.Context()))
+	})
+}
+
+// ReadyHandler serves the readiness report, typically at /readyz.
+func (r *Registry) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Ready(req.Context()))
+	})
+}
+
+// Register mounts /healthz and /readyz on mux.
+func (r *Registry) Register(mux *http.ServeMux) {
+	mux.Handle("/healthz", r.LiveHandler())
+	mux.Handle("/readyz", r.ReadyHandler())
+}
+
+// run executes checks concurrently and aggregates their results.
+func (r *Registry) run(ctx context.Context, checks map[string]Check) Report {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, checks[name])
+	}
+	wg.Wait()
+
+	report := Report{
+		Status:    StatusOK,
+		Timestamp: time.Now(),
+		Checks:    make(map[string]CheckResult, len(names)),
+	}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+// runCheck runs one check, treating a timeout as a failure.
+func runCheck(ctx context.Context, check Check) CheckResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: StatusOK, Duration: time.Since(start)}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// writeReport writes report as JSON with 200 or 503 depending on status.
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func copyChecks(src map[string]Check) map[string]Check {
+	dst := make(map[string]Check, len(src))
+	for name, check := range src {
+		dst[name] = check
+	}
+	return dst
+}
+
+// Flag records the outcome of a one-off event such as loading
+// configuration. Its Check fails until Set has been called with nil.
+type Flag struct {
+	err error
+	set bool
+	mu  sync.RWMutex
+}
+
+// Set records the outcome of the event.
+func (f *Flag) Set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+	f.set = true
+}
+
+// Check reports the recorded outcome.
+func (f *Flag) Check(ctx context.Context) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.set {
+		return errNotReported
+	}
+	return f.err
+}
+
+// LagCheck returns a Check that fails when lag reports more than max, for
+// example the replication or synchronisation delay against a shared store.
+func LagCheck(lag func() time.Duration, max time.Duration) Check {
+	return func(ctx context.Context) error {
+		if l := lag(); l > max {
+			return fmt.Errorf("lag %s exceeds %s", l, max)
+		}
+		return nil
+	}
+}
+
+var errNotReported = errors.New("not reported yet")
@@ -0,0 +1,92 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit/health"
+)
+
+func TestRegistryEndpoints(t *testing.T) {
+	var config health.Flag
+	r := health.NewRegistry(time.Second)
+	r.AddLiveness("loop", func(context.Context) error { return nil })
+	r.AddReadiness("config", config.Check)
+	mux := http.NewServeMux()
+	r.Register(mux)
+
+	get := func(path string) (int, health.Report) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report health.Report
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return w.Code, report
+	}
+
+	tests := []struct {
+		name        string
+		set         func()
+		live, ready int
+		configErr   string
+	}{
+		{"not reported", func() {}, http.StatusOK, http.StatusServiceUnavailable, "not reported yet"},
+		{"failed", func() { config.Set(errors.New("bad file")) }, http.StatusOK, http.StatusServiceUnavailable, "bad file"},
+		{"loaded", func() { config.Set(nil) }, http.StatusOK, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.set()
+			if code, report := get("/healthz"); code != tt.live || len(report.Checks) != 1 {
+				t.Errorf("/healthz = %d with %d checks, want %d with 1", code, len(report.Checks), tt.live)
+			}
+			code, report := get("/readyz")
+			if code != tt.ready {
+				t.Errorf("/readyz = %d, want %d", code, tt.ready)
+			}
+			if _, ok := report.Checks["loop"]; !ok {
+				t.Error("/readyz does not run the liveness checks")
+			}
+			if got := report.Checks["config"].Error; got != tt.configErr {
+				t.Errorf("config check error = %q, want %q", got, tt.configErr)
+			}
+		})
+	}
+}
+
+func TestRegistryTimesOutChecks(t *testing.T) {
+	r := health.NewRegistry(10 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	r.AddLiveness("stuck", func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	start := time.Now()
+	report := r.Live(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Live took %s, want it bounded by the timeout", elapsed)
+	}
+	if report.Status != health.StatusFail || report.Checks["stuck"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("report = %+v, want the stuck check failed by the deadline", report)
+	}
+}
+
+func TestLagCheck(t *testing.T) {
+	lag := time.Second
+	check := health.LagCheck(func() time.Duration { return lag }, 2*time.Second)
+	if err := check(context.Background()); err != nil {
+		t.Errorf("lag 1s: %v", err)
+	}
+	lag = 3 * time.Second
+	if err := check(context.Background()); err == nil {
+		t.Error("lag 3s passed, want an error")
+	}
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision bounds of HyperLogLog.
+const (
+	MinPrecision     = 4
+	MaxPrecision     = 18
+	DefaultPrecision = 14
+)
+
+// ErrPrecisionMismatch is returned when merging HyperLogLog sketches of
+// different precisions.
+var ErrPrecisionMismatch = errors.New("ratelimit: HyperLogLog precisions differ")
+
+// HyperLogLog estimates the number of distinct keys added to it in a fixed
+// amount of memory: 2^precision registers of one byte, with a standard
+// error of about 1.04/sqrt(2^precision), 0.8% at the default precision of
+// 14 (16 KiB). It is not safe for concurrent use.
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+
+	// hist counts the registers holding each value, so estimates take
+	// constant time instead of a pass over the registers.
+	hist [65]int
+}
+
+// NewHyperLogLog returns an empty sketch with 2^precision registers. The
+// precision is clamped to MinPrecision..MaxPrecision; zero means
+// DefaultPrecision.
+func NewHyperLogLog(precision int) *HyperLogLog {
+	switch {
+	case precision == 0:
+		precision = DefaultPrecision
+	case precision < MinPrecision:
+		precision = MinPrecision
+	case precision > MaxPrecision:
+		precision = MaxPrecision
+	}
+
+	h := &HyperLogLog{precision: uint8(precision), registers: make([]uint8, 1<<precision)}
+	h.hist[0] = len(h.registers)
+	return h
+}
+
+// Precision returns the precision of the sketch.
+func (h *HyperLogLog) Precision() int {
+	return int(h.precision)
+}
+
+// hashKey hashes key to 64 well mixed bits: FNV-1a followed by the
+// splitmix64 finalizer, as FNV alone spreads similar keys poorly.
+func hashKey(key string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	x := f.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// position returns the register and value that hash x sets.
+func (h *HyperLogLog) position(x uint64) (int, uint8) {
+	index := int(x >> (64 - h.precision))
+	rest := x<<h.precision | 1<<(h.precision-1) // bound the run of zeros
+	return index, uint8(bits.LeadingZeros64(rest) + 1)
+}
+
+// set raises register i to v if it is lower, reporting whether it was.
+func (h *HyperLogLog) set(i int, v uint8) bool {
+	old := h.registers[i]
+	if v <= old {
+		return false
+	}
+	h.registers[i] = v
+	h.hist[old]--
+	h.hist[v]++
+	return true
+}
+
+// Add adds key and reports whether the sketch changed. A key already added
+// never changes it; a new key usually does, so false means the key was
+// probably seen before.
+func (h *HyperLogLog) Add(key string) bool {
+	return h.set(h.position(hashKey(key)))
+}
+
+// Changes reports whether adding key would change the sketch, without
+// adding it.
+func (h *HyperLogLog) Changes(key string) bool {
+	i, v := h.position(hashKey(key))
+	return v > h.registers[i]
+}
+
+// Count returns the estimated number of distinct keys added.
+func (h *HyperLogLog) Count() uint64 {
+	return uint64(math.Round(estimate(&h.hist, len(h.registers))))
+}
+
+// estimate is the HyperLogLog estimate for a histogram of m registers,
+// with linear counting for small cardinalities. 64-bit hashes need no
+// large range correction.
+func estimate(hist *[65]int, m int) float64 {
+	var sum float64
+	for v := len(hist) - 1; v >= 0; v-- {
+		sum = sum/2 + float64(hist[v])
+	}
+
+	mf := float64(m)
+	var alpha float64
+	switch m {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/mf)
+	}
+
+	e := alpha * mf * mf / sum
+	if e <= 2.5*mf && hist[0] > 0 {
+		return mf * math.Log(mf/float64(hist[0]))
+	}
+	return e
+}
+
+// Merge adds the keys of other, so h estimates the union of both.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if other.precision != h.precision {
+		return ErrPrecisionMismatch
+	}
+	for i, v := range other.registers {
+		h.set(i, v)
+	}
+	return nil
+}
+
+// Reset empties the sketch.
+func (h *HyperLogLog) Reset() {
+	clear(h.registers)
+	h.hist = [65]int{}
+	h.hist[0] = len(h.registers)
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Info describes the state of a limiter at a point in time, for reporting
+// it to callers, for example in X-RateLimit-* headers.
+type Info struct {
+	// Limit is the number of requests allowed per period, as in
+	// ErrLimited.
+	Limit int `json:"limit"`
+
+	// Remaining is the number of requests that could be admitted now.
+	Remaining int `json:"remaining"`
+
+	// ResetAt is when the limiter is back to its full capacity if no more
+	// requests are admitted: the end of the window for FixedWindow, when
+	// the last request leaves the window for SlidingWindow and SlidingLog,
+	// and when the bucket is full for TokenBucket.
+	ResetAt time.Time `json:"reset_at"`
+
+	// NextAvailable is when a single request could next be admitted; it
+	// is the time of the call if one could be admitted at once.
+	NextAvailable time.Time `json:"next_available"`
+}
+
+// Inspector is implemented by limiters that can describe their state
+// without consuming capacity.
+type Inspector interface {
+	// Info returns the current state of the limiter.
+	Info() Info
+}
+
+// SetInfoHeaders sets X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset, in whole seconds until info.ResetAt, for a response
+// sent at now. Unlike SetRateLimitHeaders it suits admitted requests too.
+func SetInfoHeaders(w http.ResponseWriter, info Info, now time.Time) {
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(info.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(info.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(retryAfterSeconds(max(info.ResetAt.Sub(now), 0))))
+}
+
+// nextAvailable returns when a request limited as described by limited
+// could be admitted, or now if limited is nil.
+func nextAvailable(now time.Time, limited *ErrLimited) time.Time {
+	if limited == nil {
+		return now
+	}
+	return now.Add(limited.RetryAfter)
+}
+
+// Info returns the state of the bucket. Tokens promised to queued waiters
+// count as taken. In warm-up mode Remaining is 1 when a request would be
+// admitted now, else 0, and ResetAt is NextAvailable. With pacing,
+// Remaining is 0 until the next request may be admitted.
+func (tb *TokenBucket) Info() Info {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.config.Clock.Now()
+	next := nextAvailable(now, tb.limited(1))
+	if tb.warmup != nil {
+		return Info{
+			Limit:         tb.config.Rate,
+			Remaining:     tb.warmup.available(now),
+			ResetAt:       next,
+			NextAvailable: next,
+		}
+	}
+
+	free := tb.free(now) - float64(tb.waiters.units)
+	reset := now
+	if missing := float64(tb.config.Burst) - free; missing > 0 {
+		reset = now.Add(tb.refillTime(missing, now))
+	}
+	remaining := max(int(free), 0)
+	if tb.paceWait(now) > 0 {
+		remaining = 0
+	}
+	return Info{
+		Limit:         tb.config.Rate,
+		Remaining:     remaining,
+		ResetAt:       reset,
+		NextAvailable: next,
+	}
+}
+
+// Info returns the state of the current window.
+func (fw *FixedWindow) Info() Info {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	now := fw.config.Clock.Now()
+	next := nextAvailable(now, fw.limited(1))
+	return Info{
+		Limit:         fw.config.Rate,
+		Remaining:     max(fw.config.Rate-fw.used(), 0),
+		ResetAt:       fw.windowStart.Add(fw.config.Period),
+		NextAvailable: next,
+	}
+}
+
+// Info returns the state of the window ending now. Capacity promised to
+// queued waiters counts as used.
+func (sw *SlidingWindow) Info() Info {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.config.Clock.Now()
+	next := nextAvailable(now, sw.limited(1))
+	used := sw.used(now)
+	reset := now
+	if used > 0 {
+		reset = now.Add(sw.waitDuration(now, used))
+	}
+	return Info{
+		Limit:         sw.config.Rate,
+		Remaining:     max(sw.config.Rate-used-sw.waiters.units, 0),
+		ResetAt:       reset,
+		NextAvailable: next,
+	}
+}
+
+// Info returns the state of the default key, as used by Allow.
+func (sl *SlidingLog) Info() Info {
+	return sl.InfoKey("")
+}
+
+// InfoKey returns the state of the window ending now for key.
+func (sl *SlidingLog) InfoKey(key string) Info {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := sl.config.Clock.Now()
+	info := Info{
+		Limit:         sl.config.Rate,
+		Remaining:     sl.config.Rate,
+		ResetAt:       now,
+		NextAvailable: nextAvailable(now, sl.limited(key, 1)),
+	}
+	if log, ok := sl.logs[key]; ok {
+		used := sl.count(log, now)
+		info.Remaining = max(sl.config.Rate-used, 0)
+		if used > 0 {
+			info.ResetAt = now.Add(sl.waitDuration(log, now, used))
+		}
+	}
+	return info
+}
+
+// Info returns the state of the key.
+func (k *slidingLogKey) Info() Info { return k.log.InfoKey(k.key) }
+
+// Info returns the state of the base limiter at the scaled limits.
+func (s *Scaled) Info() Info {
+	s.apply()
+	return s.base.(Inspector).Info()
+}
+
+// Info returns the state of the current window, counting distinct keys
+// rather than requests.
+func (f *FirstSeen) Info() Info {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.config.Clock.Now()
+	f.rotate(now)
+	reset := f.windowStart.Add(f.config.Period)
+	next := now
+	if f.admitted >= f.limit {
+		next = reset
+	}
+	return Info{
+		Limit:         f.limit,
+		Remaining:     max(f.limit-f.admitted, 0),
+		ResetAt:       reset,
+		NextAvailable: next,
+	}
+}
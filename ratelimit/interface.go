@@ -1,13 +1,23 @@
 // Package ratelimit provides rate limiting functionality for Go applications.
-// It includes multiple algorithms such as Token Bucket, Fixed Window, and Sliding Window.
+// It includes multiple algorithms such as Token Bucket, Fixed Window, Sliding Window,
+// and Sliding Log.
+//
+// The exported API of this package and its subpackages is recorded in
+// api/v1.txt and stays backward compatible for all v1 releases. New
+// capabilities are added as new functions, options and optional interfaces
+// such as Refunder, never as methods on existing interfaces like Limiter.
 package ratelimit
 
 import (
 	"context"
+	"log/slog"
 	"time"
 )
 
 // Limiter is the core interface for all rate limiting implementations.
+// The limiters of this package start no goroutines: refills and window
+// rollovers are computed lazily on each call, so a limiter per key costs
+// only its memory and is garbage collected once dropped.
 type Limiter interface {
 	// Allow checks if a single request can proceed.
 	Allow() bool
@@ -28,6 +38,25 @@ type Limiter interface {
 	Available() int
 }
 
+// Refunder is implemented by limiters that can take back capacity that
+// was admitted but never used.
+type Refunder interface {
+	// ReturnN returns n previously admitted units to the limiter.
+	ReturnN(n int)
+}
+
+// Closer is implemented by limiters that run background work, such as a
+// refill timer or a sync with a remote store, which must be stopped once
+// the limiter is no longer used. Middleware and Transport close the
+// limiters they create per key when they discard them, so such limiters
+// do not leak goroutines at scale. None of the limiters of this package
+// need it.
+type Closer interface {
+	// Close stops the background work of the limiter. The limiter must not
+	// be used afterwards.
+	Close() error
+}
+
 // Config represents the common configuration for rate limiters.
 type Config struct {
 	// Rate is the number of requests allowed per period.
@@ -40,6 +69,73 @@ type Config struct {
 	// This is mainly used by token bucket algorithm.
 	Burst int
 
+	// WarmupPeriod enables TokenBucket warm-up mode: after idle time the
+	// rate ramps from Rate/ColdFactor up to Rate over this period.
+	WarmupPeriod time.Duration
+
+	// ColdFactor is how many times slower than Rate a fully cold
+	// TokenBucket admits requests. Defaults to 3.
+	ColdFactor float64
+
+	// Retention is how long SlidingLog keeps entries for auditing.
+	// Values shorter than Period are raised to Period.
+	Retention time.Duration
+
+	// BucketThreshold is the number of entries per window above which
+	// SlidingLog switches a key to bucketed counting. Zero disables it.
+	BucketThreshold int
+
+	// Admission orders callers blocked in Wait/WaitN. Defaults to
+	// AdmissionFIFO.
+	Admission Admission
+
+	// DecayReset, when positive, makes Reset and FixedWindow window
+	// rollovers release the used capacity linearly over this interval
+	// instead of all at once. Applies to TokenBucket (outside warm-up
+	// mode), FixedWindow and SlidingWindow.
+	DecayReset time.Duration
+
+	// AlignWindows makes FixedWindow windows start on multiples of Period
+	// of the wall clock in UTC, such as :00 of every minute, instead of
+	// when the limiter was created or reset, so that instances reset
+	// together.
+	AlignWindows bool
+
+	// Pacing makes TokenBucket spread out bursts, admitting requests at
+	// least Period/Rate apart instead of all at once, for
+	// calling upstreams that are sensitive to bursts. Warm-up mode paces
+	// requests already and ignores it.
+	Pacing bool
+
+	// Jitter lengthens the waits of Wait and WaitN in TokenBucket,
+	// FixedWindow, SlidingWindow and SlidingLog by a random part of up to
+	// this fraction of them, at most 1, so that callers limited together
+	// do not all retry at the same moment. Zero disables it.
+	Jitter float64
+
+	// Seed seeds the random sources of Probabilistic and Jitter, so tests
+	// can reproduce their decisions. Zero seeds them from the clock.
+	Seed int64
+
+	// Precision sets the number of HyperLogLog registers of Cardinality,
+	// 2^Precision. Zero means DefaultPrecision.
+	Precision int
+
+	// FalsePositiveRate is the false positive rate the Bloom filter of
+	// FirstSeen is sized for. Zero means DefaultFalsePositiveRate.
+	FalsePositiveRate float64
+
+	// Logger logs the requests that TokenBucket, FixedWindow,
+	// SlidingWindow and SlidingLog reject in Allow and AllowN, with their
+	// limit, remaining budget and retry delay, at level Info. Nil disables
+	// logging.
+	Logger *slog.Logger
+
+	// LogSampleRate is the fraction of rejections Logger logs, such as
+	// 0.01 for one in a hundred, so that an attack does not flood the
+	// logs. Zero logs every rejection.
+	LogSampleRate float64
+
 	// Clock allows for custom time source (useful for testing).
 	Clock Clock
 }
@@ -103,6 +199,105 @@ func WithBurst(burst int) Option {
 	}
 }
 
+// WithWarmup enables warm-up mode with the given warm-up period.
+func WithWarmup(period time.Duration) Option {
+	return func(c *Config) {
+		c.WarmupPeriod = period
+	}
+}
+
+// WithColdFactor sets how much slower a cold warm-up bucket starts.
+func WithColdFactor(factor float64) Option {
+	return func(c *Config) {
+		c.ColdFactor = factor
+	}
+}
+
+// WithRetention sets how long SlidingLog keeps audit entries.
+func WithRetention(retention time.Duration) Option {
+	return func(c *Config) {
+		c.Retention = retention
+	}
+}
+
+// WithBucketThreshold sets the per-key entry count at which SlidingLog
+// switches to bucketed counting.
+func WithBucketThreshold(threshold int) Option {
+	return func(c *Config) {
+		c.BucketThreshold = threshold
+	}
+}
+
+// WithAdmission sets how TokenBucket and SlidingWindow order blocked
+// callers.
+func WithAdmission(admission Admission) Option {
+	return func(c *Config) {
+		c.Admission = admission
+	}
+}
+
+// WithDecayReset spreads the capacity freed by Reset and window rollovers
+// over interval.
+func WithDecayReset(interval time.Duration) Option {
+	return func(c *Config) {
+		c.DecayReset = interval
+	}
+}
+
+// WithAlignedWindows aligns FixedWindow windows to multiples of the period
+// of the wall clock.
+func WithAlignedWindows() Option {
+	return func(c *Config) {
+		c.AlignWindows = true
+	}
+}
+
+// WithPacing spreads TokenBucket bursts over the period when enabled.
+func WithPacing(enabled bool) Option {
+	return func(c *Config) {
+		c.Pacing = enabled
+	}
+}
+
+// WithJitter lengthens wait durations by a random part of up to fraction
+// of them, to de-synchronize callers that were limited together.
+func WithJitter(fraction float64) Option {
+	return func(c *Config) {
+		c.Jitter = fraction
+	}
+}
+
+// WithSeed seeds the random sources of Probabilistic and Jitter.
+func WithSeed(seed int64) Option {
+	return func(c *Config) {
+		c.Seed = seed
+	}
+}
+
+// WithLogger logs rejected requests to logger, sampled at sampleRate
+// (see Config.LogSampleRate).
+func WithLogger(logger *slog.Logger, sampleRate float64) Option {
+	return func(c *Config) {
+		c.Logger = logger
+		c.LogSampleRate = sampleRate
+	}
+}
+
+// WithPrecision sets the HyperLogLog precision of Cardinality.
+func WithPrecision(precision int) Option {
+	return func(c *Config) {
+		c.Precision = precision
+	}
+}
+
+// WithFalsePositiveRate sets the Bloom filter false positive rate of
+// FirstSeen.
+func WithFalsePositiveRate(rate float64) Option {
+	return func(c *Config) {
+		c.FalsePositiveRate = rate
+	}
+}
+
 // WithClock sets a custom clock implementation.
 func WithClock(clock Clock) Option {
 	return func(c *Config) {
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// jitter lengthens wait durations by a random part of up to a fraction of
+// them, so that callers limited at the same moment do not all wake, and
+// hit the limiter or the upstream behind it, at the same window boundary.
+// A nil jitter leaves durations unchanged. It is not safe for concurrent
+// use; limiters call it with their mutex held.
+type jitter struct {
+	fraction float64
+	rand     *rand.Rand
+}
+
+// newJitter returns the jitter configured by Config.Jitter, or nil if
+// there is none.
+func newJitter(cfg *Config) *jitter {
+	if cfg.Jitter <= 0 {
+		return nil
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = cfg.Clock.Now().UnixNano()
+	}
+	return &jitter{
+		fraction: math.Min(cfg.Jitter, 1),
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// apply returns d lengthened by up to the jitter fraction of d. Waits are
+// never shortened, as waking before capacity is available would only
+// cost another wait.
+func (j *jitter) apply(d time.Duration) time.Duration {
+	if j == nil || d <= 0 {
+		return d
+	}
+	return d + time.Duration(j.rand.Float64()*j.fraction*float64(d))
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AllowKey decides a request of cost for key as Handler does, for servers
+// that do not use net/http, such as fasthttp, which compute the key
+// themselves. Blocked and exempt keys, DryRun, counters, logs and events
+// apply; the Deny and Bypass rules and the callbacks, which take an
+// *http.Request, do not. It reports whether the request is admitted, and
+// for a rejection the RateLimitInfo for the response headers:
+//
+//	info, ok := m.AllowKey(key, 1)
+//	if !ok {
+//		for name, value := range info.Headers() {
+//			w.Header().Set(name, value)
+//		}
+//		w.WriteHeader(http.StatusTooManyRequests)
+//		return
+//	}
+//
+// The module github.com/rRateLimit/client/adapters/fasthttp wraps it for
+// fasthttp.
+func (m *Middleware) AllowKey(key string, cost int) (RateLimitInfo, bool) {
+	cost = max(cost, 1)
+	info := RateLimitInfo{Key: key}
+	if o, d := m.override(key); d > 0 {
+		if o.exempt {
+			atomic.AddInt64(&m.counters.allowed, 1)
+			return info, true
+		}
+		info.RetryAfter = d
+		return info, m.rejectKey(info, cost)
+	}
+
+	limiter := m.getLimiter(key)
+	if limiter.AllowN(cost) {
+		atomic.AddInt64(&m.counters.allowed, 1)
+		return info, true
+	}
+	if c, ok := limiter.(Checker); ok {
+		info = rateLimitInfo(key, c.CheckN(cost))
+	}
+	return info, m.rejectKey(info, cost)
+}
+
+// rejectKey counts a rejection of AllowKey, reporting whether DryRun mode
+// admits the request anyway.
+func (m *Middleware) rejectKey(info RateLimitInfo, cost int) bool {
+	if m.config.DryRun {
+		atomic.AddInt64(&m.counters.dryRun, 1)
+		atomic.AddInt64(&m.counters.allowed, 1)
+		return true
+	}
+	m.countLimited(nil, info)
+	return false
+}
+
+// Headers returns the headers that Handler sets on a response rejected
+// as described by info: Retry-After, and X-RateLimit-Limit and
+// X-RateLimit-Remaining when the limit is known.
+func (info RateLimitInfo) Headers() map[string]string {
+	h := map[string]string{"Retry-After": strconv.Itoa(retryAfterSeconds(info.RetryAfter))}
+	if info.Limit > 0 {
+		h["X-RateLimit-Limit"] = strconv.Itoa(info.Limit)
+		h["X-RateLimit-Remaining"] = strconv.Itoa(info.Remaining)
+	}
+	return h
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strings"
+)
+
+// KeySeparator separates the parts of keys built by KeyJoin.
+const KeySeparator = ":"
+
+// keyEscaper and keyUnescaper encode the colons of key parts, so that
+// joined parts cannot pass for others and a tier ends at the first colon
+// of a limiter map key.
+var (
+	keyEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	keyUnescaper = strings.NewReplacer("%3A", ":", "%25", "%")
+)
+
+// KeyJoin returns a KeyFunc that keys requests by the keys of all funcs,
+// joined with KeySeparator, for example KeyJoin(IPKeyFunc, PathKeyFunc) to
+// limit each client per path. Colons in the parts are escaped as %3A, and
+// percent signs as %25, so that parts "a" and "b:c" and parts "a:b" and
+// "c" give different keys.
+func KeyJoin(funcs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		parts := make([]string, len(funcs))
+		for i, f := range funcs {
+			parts[i] = keyEscaper.Replace(f(r))
+		}
+		return strings.Join(parts, KeySeparator)
+	}
+}
+
+// KeyPrefix returns a KeyFunc that prefixes the keys of f with prefix, so
+// that middlewares or policies sharing a store cannot collide.
+func KeyPrefix(prefix string, f KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		return prefix + f(r)
+	}
+}
+
+// ConstantKeyFunc returns a KeyFunc that keys every request by key, so
+// that all of them share one limiter; it also suits as a fallback, such
+// as "anonymous".
+func ConstantKeyFunc(key string) KeyFunc {
+	return func(r *http.Request) string {
+		return key
+	}
+}
+
+// HeaderKeyFunc returns a KeyFunc that keys requests by the value of the
+// header name, such as "X-API-Key". Requests without it are keyed by
+// fallback; a nil fallback uses IPKeyFunc.
+func HeaderKeyFunc(name string, fallback KeyFunc) KeyFunc {
+	return valueKeyFunc(func(r *http.Request) string {
+		return r.Header.Get(name)
+	}, fallback)
+}
+
+// CookieKeyFunc returns a KeyFunc that keys requests by the value of the
+// cookie name, such as a session ID. Requests without it are keyed by
+// fallback; a nil fallback uses IPKeyFunc.
+func CookieKeyFunc(name string, fallback KeyFunc) KeyFunc {
+	return valueKeyFunc(func(r *http.Request) string {
+		if c, err := r.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	}, fallback)
+}
+
+// QueryKeyFunc returns a KeyFunc that keys requests by the query
+// parameter name, such as "api_key". Requests without it are keyed by
+// fallback; a nil fallback uses IPKeyFunc.
+func QueryKeyFunc(name string, fallback KeyFunc) KeyFunc {
+	return valueKeyFunc(func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}, fallback)
+}
+
+// ContextKeyFunc returns a KeyFunc that keys requests by the string
+// stored in their context under key, such as a user ID an authentication
+// middleware or a router adapter put there. Requests without it are keyed
+// by fallback; a nil fallback uses IPKeyFunc.
+func ContextKeyFunc(key interface{}, fallback KeyFunc) KeyFunc {
+	return valueKeyFunc(func(r *http.Request) string {
+		v, _ := r.Context().Value(key).(string)
+		return v
+	}, fallback)
+}
+
+// valueKeyFunc keys requests by value, or by fallback when it is empty.
+func valueKeyFunc(value KeyFunc, fallback KeyFunc) KeyFunc {
+	if fallback == nil {
+		fallback = IPKeyFunc
+	}
+	return func(r *http.Request) string {
+		if v := value(r); v != "" {
+			return v
+		}
+		return fallback(r)
+	}
+}
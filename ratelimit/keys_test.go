@@ -0,0 +1,41 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func TestKeyJoinEscapesSeparator(t *testing.T) {
+	join := ratelimit.KeyJoin(ratelimit.HeaderKeyFunc("X-A", nil), ratelimit.HeaderKeyFunc("X-B", nil))
+	key := func(a, b string) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-A", a)
+		r.Header.Set("X-B", b)
+		return join(r)
+	}
+
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"user", "/path", "user:/path"},
+		{"a", "b:c", "a:b%3Ac"},
+		{"a:b", "c", "a%3Ab:c"},
+		{"a%3Ab", "c", "a%253Ab:c"},
+		{"2001:db8::1", "/", "2001%3Adb8%3A%3A1:/"},
+	}
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		got := key(tt.a, tt.b)
+		if got != tt.want {
+			t.Errorf("KeyJoin(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+		if other, ok := seen[got]; ok {
+			t.Errorf("parts %q and %q collide with %s", tt.a+"|"+tt.b, other, got)
+		}
+		seen[got] = tt.a + "|" + tt.b
+	}
+}
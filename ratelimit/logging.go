@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// denialLogger logs rejected requests through log/slog, sampled so that a
+// flood of rejections, as in an attack, does not flood the logs too. A nil
+// denialLogger logs nothing.
+type denialLogger struct {
+	logger *slog.Logger
+	rate   float64
+	seen   atomic.Uint64
+}
+
+// newDenialLogger returns a logger for logger logging the fraction rate of
+// the rejections, or nil without a logger. A rate that is not in (0, 1]
+// logs every rejection.
+func newDenialLogger(logger *slog.Logger, rate float64) *denialLogger {
+	if logger == nil {
+		return nil
+	}
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	return &denialLogger{logger: logger, rate: rate}
+}
+
+// sample counts a rejection and reports whether to log it. Rejections are
+// sampled evenly rather than at random: with a rate of 0.01, every
+// hundredth one is logged.
+func (l *denialLogger) sample() bool {
+	if l == nil {
+		return false
+	}
+	n := l.seen.Add(1)
+	return uint64(float64(n)*l.rate) != uint64(float64(n-1)*l.rate)
+}
+
+// log logs a rejection described by info, with the metadata of r if it is
+// not nil. Call it only for rejections that sample selected.
+func (l *denialLogger) log(r *http.Request, info RateLimitInfo) {
+	ctx := context.Background()
+	attrs := make([]slog.Attr, 0, 6)
+	if info.Key != "" {
+		attrs = append(attrs, slog.String("key", info.Key))
+	}
+	attrs = append(attrs,
+		slog.Int("limit", info.Limit),
+		slog.Int("remaining", info.Remaining),
+		slog.Duration("retry_after", info.RetryAfter),
+	)
+	if l.rate < 1 {
+		attrs = append(attrs, slog.Float64("sample_rate", l.rate))
+	}
+	if r != nil {
+		ctx = r.Context()
+		attrs = append(attrs, slog.Group("request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		))
+	}
+	l.logger.LogAttrs(ctx, slog.LevelInfo, "rate limited", attrs...)
+}
+
+// denied logs a rejection of a limiter if it is sampled. check returns
+// the error describing the rejection and is only called for logged ones,
+// outside the limiter's lock.
+func (l *denialLogger) denied(key string, check func() error) {
+	if l.sample() {
+		l.log(nil, rateLimitInfo(key, check()))
+	}
+}
@@ -0,0 +1,254 @@
+package ratelimit
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Keys returns the keys that currently have a limiter, in order.
+func (m *Middleware) Keys() []string {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.limiters))
+	for key := range m.limiters {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Inspect returns the state of key in the format of Dump, and false if the
+// key has no limiter. Unlike the handlers, it does not create one.
+func (m *Middleware) Inspect(key string) (KeyDump, bool) {
+	m.mu.RLock()
+	entry, ok := m.limiters[key]
+	var limiter Limiter
+	var lastAccess time.Time
+	if ok {
+		limiter, lastAccess = entry.limiter, entry.lastAccess
+	}
+	m.mu.RUnlock()
+
+	if !ok {
+		return KeyDump{}, false
+	}
+	return DumpKey(limiter, lastAccess), true
+}
+
+// ResetKey resets the limiter of key, restoring its full budget. It
+// reports whether the key had a limiter.
+func (m *Middleware) ResetKey(key string) bool {
+	m.mu.RLock()
+	entry, ok := m.limiters[key]
+	var limiter Limiter
+	if ok {
+		limiter = entry.limiter
+	}
+	m.mu.RUnlock()
+
+	if ok {
+		limiter.Reset()
+	}
+	return ok
+}
+
+// keyOverride is a Block or Exempt of a key.
+type keyOverride struct {
+	until  time.Time
+	exempt bool
+}
+
+// Block rejects every request for key as rate limited for d, whatever its
+// limiter allows, for example to stop an abusive client at once. Blocked
+// requests do not consume the key's budget and are answered with a
+// Retry-After of the time left. Blocking a key again, or exempting it,
+// replaces the previous override; a d of zero or less unblocks it.
+// Registry calls for the key fail without waiting while it is blocked.
+func (m *Middleware) Block(key string, d time.Duration) {
+	if until, ok := m.setOverride(key, d, false); ok {
+		m.emit(Event{Kind: EventBlocked, Key: key, Until: &until})
+	}
+}
+
+// Exempt admits every request for key for d without consulting or
+// charging its limiter, for example for a customer that support has
+// granted a temporary increase. Exempted requests are counted as allowed
+// and passed to OnAllowed. Exempting a key again, or blocking it, replaces
+// the previous override; a d of zero or less ends the exemption.
+func (m *Middleware) Exempt(key string, d time.Duration) {
+	if until, ok := m.setOverride(key, d, true); ok {
+		m.emit(Event{Kind: EventExempted, Key: key, Until: &until})
+	}
+}
+
+// setOverride blocks or exempts key for d, or clears its override. It
+// returns when the override ends, and false if it was cleared.
+func (m *Middleware) setOverride(key string, d time.Duration, exempt bool) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d <= 0 {
+		if o, ok := m.overrides[key]; ok && o.exempt == exempt {
+			delete(m.overrides, key)
+		}
+		return time.Time{}, false
+	}
+	if m.overrides == nil {
+		m.overrides = make(map[string]keyOverride)
+	}
+	until := time.Now().Add(d)
+	m.overrides[key] = keyOverride{until: until, exempt: exempt}
+	return until, true
+}
+
+// Unblock lifts the block of key. It reports whether the key was blocked.
+func (m *Middleware) Unblock(key string) bool {
+	return m.clearOverride(key, false)
+}
+
+// Unexempt ends the exemption of key. It reports whether the key was
+// exempt.
+func (m *Middleware) Unexempt(key string) bool {
+	return m.clearOverride(key, true)
+}
+
+// clearOverride drops the Block or Exempt of key, reporting whether one
+// was in effect.
+func (m *Middleware) clearOverride(key string, exempt bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.overrides[key]
+	if !ok || o.exempt != exempt {
+		return false
+	}
+	delete(m.overrides, key)
+	return time.Now().Before(o.until)
+}
+
+// Blocked returns the blocked keys and when their blocks end.
+func (m *Middleware) Blocked() map[string]time.Time {
+	return m.listOverrides(false)
+}
+
+// Exempted returns the exempt keys and when their exemptions end.
+func (m *Middleware) Exempted() map[string]time.Time {
+	return m.listOverrides(true)
+}
+
+// listOverrides returns the keys with an override of the given kind in
+// effect, dropping expired ones.
+func (m *Middleware) listOverrides(exempt bool) map[string]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	keys := make(map[string]time.Time)
+	for key, o := range m.overrides {
+		if !now.Before(o.until) {
+			delete(m.overrides, key)
+			continue
+		}
+		if o.exempt == exempt {
+			keys[key] = o.until
+		}
+	}
+	return keys
+}
+
+// override returns the override of key and how long it lasts, or zero if
+// it has none.
+func (m *Middleware) override(key string) (keyOverride, time.Duration) {
+	m.mu.RLock()
+	o, ok := m.overrides[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return keyOverride{}, 0
+	}
+	if d := time.Until(o.until); d > 0 {
+		return o, d
+	}
+
+	// Expired overrides are dropped lazily
+	m.mu.Lock()
+	if o, ok := m.overrides[key]; ok && !time.Now().Before(o.until) {
+		delete(m.overrides, key)
+	}
+	m.mu.Unlock()
+	return keyOverride{}, 0
+}
+
+// serveOverride serves r according to the override of key, rejecting it
+// while the key is blocked and admitting it while exempt. It reports
+// whether r was handled.
+func (m *Middleware) serveOverride(next http.Handler, w http.ResponseWriter, r *http.Request, key string) bool {
+	o, d := m.override(key)
+	switch {
+	case d <= 0:
+		return false
+	case o.exempt:
+		r = m.allowed(r, key, m.cost(r))
+		m.serve(next, w, r)
+	default:
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(d)))
+		m.rateLimited(w, r, RateLimitInfo{Key: key, RetryAfter: d})
+	}
+	return true
+}
+
+// Limit is a rate set at runtime with SetLimit. A Burst of zero means
+// Rate, as for Reconfigurer.
+type Limit struct {
+	Rate   int           `json:"rate"`
+	Period time.Duration `json:"period"`
+	Burst  int           `json:"burst,omitempty"`
+}
+
+// apply reconfigures limiter to l, reporting whether it implements
+// Reconfigurer. It does nothing on a nil l.
+func (l *Limit) apply(limiter Limiter) bool {
+	if l == nil {
+		return false
+	}
+	rc, ok := limiter.(Reconfigurer)
+	if ok {
+		rc.Reconfigure(l.Rate, l.Period, l.Burst)
+	}
+	return ok
+}
+
+// SetLimit changes the limits of every key at runtime, for all tiers,
+// keeping the usage each key has counted (see Reconfigurer). Keys created
+// later get the new limits too, until the next Reconfigure. Limiters that
+// do not implement Reconfigurer keep their limits; SetLimit returns the
+// number of keys that were changed.
+func (m *Middleware) SetLimit(limit Limit) int {
+	m.mu.Lock()
+	m.limit = &limit
+	changed := 0
+	for _, entry := range m.limiters {
+		if m.limit.apply(entry.limiter) {
+			changed++
+		}
+	}
+	m.mu.Unlock()
+
+	m.emit(Event{Kind: EventLimitChanged, Limit: &limit})
+	return changed
+}
+
+// CurrentLimit returns the limits set with SetLimit, and false if there
+// are none.
+func (m *Middleware) CurrentLimit() (Limit, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.limit == nil {
+		return Limit{}, false
+	}
+	return *m.limit, true
+}
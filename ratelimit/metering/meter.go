@@ -0,0 +1,230 @@
+// Package metering exports the usage admitted by the limiters, per key,
+// to metering and billing pipelines. A Meter adds up the requests and
+// cost units of every key and periodically flushes the totals to a Sink,
+// such as a file, an HTTP endpoint or a Kafka topic:
+//
+//	meter := metering.New(&metering.HTTPSink{URL: "https://billing.example.com/usage"}, nil)
+//	defer meter.Close()
+//
+//	config := ratelimit.DefaultMiddlewareConfig()
+//	config.OnAllowed = meter.OnAllowed
+//
+// Usage that a sink fails to take is kept and sent with the next flush,
+// so an outage of the pipeline delays records rather than losing them.
+package metering
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Record is the usage of one key over an interval.
+type Record struct {
+	Key string `json:"key"`
+
+	// Requests is the number of admitted requests and Units the cost they
+	// consumed, which equals Requests unless requests have a cost.
+	Requests int64 `json:"requests"`
+	Units    int64 `json:"units"`
+
+	// Start and End delimit the interval the usage was recorded in.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Sink receives the usage flushed by a Meter.
+type Sink interface {
+	// Export sends records, one per key with usage, sorted by key. An
+	// error makes the Meter send the usage again with the next flush, so
+	// a sink that fails after sending part of the records may see them
+	// twice.
+	Export(ctx context.Context, records []Record) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, records []Record) error
+
+// Export calls f.
+func (f SinkFunc) Export(ctx context.Context, records []Record) error {
+	return f(ctx, records)
+}
+
+// Config configures a Meter. Zero fields take their defaults.
+type Config struct {
+	// Interval is how often usage is flushed. Defaults to one minute.
+	Interval time.Duration
+
+	// Timeout bounds each export. Defaults to ten seconds.
+	Timeout time.Duration
+
+	// OnError is called with export errors. The usage is kept and sent
+	// with the next flush.
+	OnError func(error)
+
+	// Clock is the time source of the record intervals. Defaults to
+	// ratelimit.SystemClock.
+	Clock ratelimit.Clock
+}
+
+// Meter adds up usage per key and flushes it to a Sink from a background
+// goroutine; call Close to stop it. A Meter is safe for concurrent use.
+type Meter struct {
+	sink   Sink
+	config Config
+
+	mu    sync.Mutex
+	usage map[string]*usage
+	start time.Time // of the interval being recorded
+
+	flushMu sync.Mutex // serializes flushes, so intervals do not overlap
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// usage is the usage of a key since the last flush.
+type usage struct {
+	requests, units int64
+}
+
+// New returns a meter flushing to sink and starts its flush loop. config
+// may be nil for the defaults.
+func New(sink Sink, config *Config) *Meter {
+	m := &Meter{sink: sink, usage: make(map[string]*usage), done: make(chan struct{})}
+	if config != nil {
+		m.config = *config
+	}
+	if m.config.Interval <= 0 {
+		m.config.Interval = time.Minute
+	}
+	if m.config.Timeout <= 0 {
+		m.config.Timeout = 10 * time.Second
+	}
+	if m.config.Clock == nil {
+		m.config.Clock = ratelimit.SystemClock{}
+	}
+	m.start = m.config.Clock.Now()
+
+	m.wg.Add(1)
+	go m.loop()
+	return m
+}
+
+// Record adds a request of cost units to the usage of key.
+func (m *Meter) Record(key string, cost int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.usage[key]
+	if !ok {
+		u = &usage{}
+		m.usage[key] = u
+	}
+	u.requests++
+	u.units += int64(cost)
+}
+
+// OnAllowed records an admitted request, with the signature of
+// ratelimit.MiddlewareConfig.OnAllowed.
+func (m *Meter) OnAllowed(_ *http.Request, key string, cost int) {
+	m.Record(key, cost)
+}
+
+// Flush sends the usage recorded since the last flush to the sink. If the
+// sink fails, the usage is kept for the next flush and the error is
+// returned.
+func (m *Meter) Flush(ctx context.Context) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	pending, start, end := m.usage, m.start, m.config.Clock.Now()
+	m.usage, m.start = make(map[string]*usage), end
+	m.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	records := make([]Record, 0, len(pending))
+	for key, u := range pending {
+		records = append(records, Record{Key: key, Requests: u.requests, Units: u.units, Start: start, End: end})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	err := m.sink.Export(ctx, records)
+	if err != nil {
+		m.restore(pending, start)
+	}
+	return err
+}
+
+// restore adds usage that failed to export back to the current interval,
+// which then starts at start.
+func (m *Meter) restore(pending map[string]*usage, start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, p := range pending {
+		if u, ok := m.usage[key]; ok {
+			u.requests += p.requests
+			u.units += p.units
+		} else {
+			m.usage[key] = p
+		}
+	}
+	m.start = start
+}
+
+// Pending returns the number of keys with usage not yet exported.
+func (m *Meter) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.usage)
+}
+
+// loop flushes every Interval until Close.
+func (m *Meter) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flush()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// flush flushes with the configured timeout and reports errors.
+func (m *Meter) flush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	defer cancel()
+
+	err := m.Flush(ctx)
+	if err != nil && m.config.OnError != nil {
+		m.config.OnError(err)
+	}
+	return err
+}
+
+// Close stops the flush loop, waits for it to exit and flushes the
+// remaining usage. Later calls do nothing and return nil.
+func (m *Meter) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+		err = m.flush()
+	})
+	return err
+}
@@ -0,0 +1,24 @@
+package metering_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/internal/leakcheck"
+	"github.com/rRateLimit/client/ratelimit/metering"
+)
+
+func TestMeterCloseStopsGoroutines(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	m := metering.New(metering.NewWriterSink(io.Discard), &metering.Config{Interval: time.Millisecond})
+	m.Record("a", 1)
+	time.Sleep(5 * time.Millisecond)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+}
@@ -0,0 +1,121 @@
+package metering
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// WriterSink writes records to a writer as JSON lines, one record per
+// line, for example to a file that a log shipper forwards:
+//
+//	f, err := os.OpenFile("usage.jsonl", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+//	...
+//	meter := metering.New(metering.NewWriterSink(f), nil)
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink writing to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Export implements Sink. The records of a flush are written with a
+// single Write.
+func (s *WriterSink) Export(_ context.Context, records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(buf.Bytes())
+	return err
+}
+
+// HTTPSink posts the records of each flush to an endpoint as a JSON array.
+// Any status other than 2xx is an error, so the usage is sent again with
+// the next flush; the endpoint should therefore deduplicate by key and
+// interval.
+type HTTPSink struct {
+	// URL is the endpoint.
+	URL string
+
+	// Header is added to every request, for example for authentication.
+	Header http.Header
+
+	// Client sends the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Export implements Sink.
+func (s *HTTPSink) Export(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("metering: %s: %s", s.URL, res.Status)
+	}
+	return nil
+}
+
+// Producer publishes messages to a topic of a message broker such as
+// Kafka. Clients like segmentio/kafka-go or sarama are adapted to it in a
+// few lines, so the package does not depend on any of them.
+type Producer interface {
+	// Produce publishes a message. key is the key of the record, so that
+	// a key's usage stays in one partition.
+	Produce(ctx context.Context, key string, value []byte) error
+}
+
+// ProducerSink publishes every record as a JSON message through a
+// Producer.
+type ProducerSink struct {
+	Producer Producer
+}
+
+// Export implements Sink. It stops at the first error, and all the
+// records of the flush are sent again with the next one.
+func (s *ProducerSink) Export(ctx context.Context, records []Record) error {
+	for _, r := range records {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := s.Producer.Produce(ctx, r.Key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,28 +1,34 @@
 package ratelimit
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// RequestTimeoutHeader is the request header a caller can use to state how
+// long it is willing to wait, either as a Go duration ("1.5s") or in seconds.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
 // KeyFunc is a function that extracts a key from an HTTP request.
 // This key is used to identify and group requests for rate limiting.
 type KeyFunc func(r *http.Request) string
 
-// IPKeyFunc returns the client's IP address as the key.
+// IPKeyFunc returns the client's IP address as the key, without a port.
+// Forwarding headers are only believed from proxies on loopback and
+// private networks (see TrustedProxies); use the KeyFunc of a
+// TrustedProxies to trust other ones.
 func IPKeyFunc(r *http.Request) string {
-	// Try to get real IP from X-Forwarded-For or X-Real-IP headers
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
-	}
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+	return defaultTrustedProxies.KeyFunc(r)
 }
 
 // UserKeyFunc returns a user identifier from the request.
@@ -45,17 +51,102 @@ type MiddlewareConfig struct {
 	// Limiter is a function that creates a new rate limiter for each key.
 	LimiterFactory func() Limiter
 	
+	// KeyedLimiterFactory, if set, is used instead of LimiterFactory and
+	// is given the key, for limiters whose state is kept per key elsewhere,
+	// such as distributed.WindowLimiter.Limiter.
+	KeyedLimiterFactory func(key string) Limiter
+	
 	// KeyFunc extracts the key from the request.
 	KeyFunc KeyFunc
 	
+	// TierFunc assigns a request to a tier, such as "anonymous" or "pro".
+	// Requests in a tier listed in Tiers use that tier's limiter factory;
+	// all others use LimiterFactory. If nil, every request uses
+	// LimiterFactory. Limiters are kept per tier and key, so a key that
+	// changes tier starts with a fresh budget. Limiter map keys, as seen
+	// by events and Preload, are the tier and the key joined by a colon,
+	// with colons in the tier escaped as %3A.
+	TierFunc func(r *http.Request) string
+	
+	// Tiers maps tier names returned by TierFunc to limiter factories.
+	Tiers map[string]func() Limiter
+	
+	// CostFunc returns how many tokens a request consumes.
+	// If nil, every request costs 1; results below 1 are treated as 1.
+	CostFunc func(r *http.Request) int
+	
+	// CostReporting attaches a CostReporter to the context of admitted
+	// requests, through which handlers charge costs that are only known
+	// while serving, such as the complexity of a GraphQL query. It is off
+	// by default as it costs an allocation per request.
+	CostReporting bool
+	
+	// Bypass lists requests that skip rate limiting entirely, such as health
+	// checkers and internal services. It is evaluated before the limiter is
+	// touched, so bypassed requests consume no budget and create no keys.
+	Bypass *AccessRule
+	
+	// Deny lists requests that are rejected with OnDenied before the
+	// limiter is touched. Deny takes precedence over Bypass.
+	Deny *AccessRule
+	
+	// OnDenied is called for requests matching Deny. If nil, a 403 is sent.
+	OnDenied func(w http.ResponseWriter, r *http.Request)
+	
+	// DryRun evaluates Deny and the limits without enforcing them: requests
+	// that would be rejected are counted and reported to OnDryRun, then
+	// served normally. WaitHandler and QueueHandler do not wait in this
+	// mode. Use it to tune limits against production traffic before
+	// enforcing them.
+	DryRun bool
+	
+	// OnDryRun is called in DryRun mode for every request that would have
+	// been rejected, for example to log it. May be nil.
+	OnDryRun func(r *http.Request, event DryRunEvent)
+	
 	// OnRateLimited is called when a request is rate limited.
 	OnRateLimited func(w http.ResponseWriter, r *http.Request)
 	
-	// CleanupInterval is how often to clean up unused limiters.
+	// OnAllowed is called for every request admitted under a key, with the
+	// cost it consumed, before it is served; in DryRun mode also for those
+	// that would have been rejected. Use it to meter usage, for example
+	// with metering.Meter.OnAllowed. It must be safe for concurrent use.
+	// May be nil.
+	OnAllowed func(r *http.Request, key string, cost int)
+	
+	// Logger logs the requests rejected as rate limited, with their key,
+	// limit, remaining budget and retry delay and the method, path, remote
+	// address and user agent of the request, at level Info. Nil disables
+	// logging.
+	Logger *slog.Logger
+	
+	// LogSampleRate is the fraction of rejections Logger logs, such as
+	// 0.01 for one in a hundred, so that an attack does not flood the
+	// logs. Zero logs every rejection.
+	LogSampleRate float64
+	
+	// OnOverloaded is called when a request is shed because the server is
+	// overloaded (for example, the QueueHandler queue is full), as opposed to
+	// the client exceeding its rate. If nil, a 503 with Retry-After is sent.
+	OnOverloaded func(w http.ResponseWriter, r *http.Request)
+	
+	// OverloadRetryAfter is the Retry-After advertised by the default
+	// OnOverloaded response. Defaults to one second.
+	OverloadRetryAfter time.Duration
+	
+	// CleanupInterval is how often to clean up unused limiters. Zero or
+	// less disables the cleanup goroutine.
 	CleanupInterval time.Duration
 	
 	// MaxIdleTime is how long a limiter can be idle before cleanup.
 	MaxIdleTime time.Duration
+	
+	// MaxKeys bounds the number of keys with a limiter between cleanups.
+	// When a new key would exceed it, the least recently used key is
+	// evicted and starts with a fresh budget if it returns. This keeps a
+	// flood of distinct keys, such as a scan from random addresses, from
+	// exhausting memory. Zero means no bound.
+	MaxKeys int
 }
 
 // DefaultMiddlewareConfig returns a default middleware configuration.
@@ -69,26 +160,114 @@ func DefaultMiddlewareConfig() *MiddlewareConfig {
 			)
 		},
 		KeyFunc: IPKeyFunc,
-		OnRateLimited: func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-		},
-		CleanupInterval: 5 * time.Minute,
-		MaxIdleTime:     10 * time.Minute,
+		OnRateLimited:      defaultOnRateLimited,
+		OverloadRetryAfter: time.Second,
+		CleanupInterval:    5 * time.Minute,
+		MaxIdleTime:        10 * time.Minute,
 	}
 }
 
+// DryRunEvent describes a request that DryRun mode let through.
+type DryRunEvent struct {
+	// Reason is DryRunRateLimited or DryRunDenied.
+	Reason string
+	
+	// Key is the limiter key of the request; empty for denials.
+	Key string
+	
+	// Cost is the number of tokens the request asked for.
+	Cost int
+	
+	// RetryAfter is how long the client would have been told to wait, if
+	// the limiter implements Checker.
+	RetryAfter time.Duration
+}
+
+// Reasons reported in DryRunEvent.
+const (
+	DryRunRateLimited = "rate_limited"
+	DryRunDenied      = "denied"
+)
+
+// defaultOnRateLimited responds with 429 Too Many Requests, rendered by a
+// default ResponseBuilder.
+func defaultOnRateLimited(w http.ResponseWriter, r *http.Request) {
+	defaultResponseBuilder.Write(w, r)
+}
+
 // limiterEntry holds a rate limiter and its last access time.
 type limiterEntry struct {
 	limiter    Limiter
+	tier       string        // tier the limiter was created for
 	lastAccess time.Time
+	limited    int64         // rate limited requests, updated atomically
+	elem       *list.Element // position in Middleware.lru
 }
 
 // Middleware creates an HTTP middleware for rate limiting.
 type Middleware struct {
 	config   *MiddlewareConfig
+	bypass   *accessMatcher
+	deny     *accessMatcher
 	limiters map[string]*limiterEntry
+	lru      *list.List // keys, most recently used first
 	mu       sync.RWMutex
 	done     chan struct{}
+	stopped  chan struct{} // closed when the cleanup goroutine has exited
+	closing  sync.Once
+	dropped  []Closer // removed under mu, closed by unlock
+	queued   int64
+	counters middlewareCounters
+	denials  *denialLogger
+	
+	// Changes made at runtime
+	overrides map[string]keyOverride // set by Block and Exempt
+	limit     *Limit                 // set by SetLimit
+	
+	// Event subscribers, see Subscribe
+	subMu          sync.RWMutex
+	subscribers    []subscriber
+	nextSubscriber int
+}
+
+// middlewareCounters holds request outcome counters, updated atomically.
+type middlewareCounters struct {
+	allowed  int64
+	limited  int64
+	shed     int64
+	bypassed int64
+	denied   int64
+	dryRun   int64
+	evicted  int64
+}
+
+// MiddlewareCounters reports how requests through a Middleware were handled.
+type MiddlewareCounters struct {
+	// Allowed is the number of requests passed to the next handler.
+	Allowed int64 `json:"allowed"`
+	
+	// RateLimited is the number of requests rejected because their key
+	// exceeded its rate (429).
+	RateLimited int64 `json:"rate_limited"`
+	
+	// Shed is the number of requests rejected because the server was
+	// overloaded (503).
+	Shed int64 `json:"shed"`
+	
+	// Bypassed is the number of requests that matched Bypass and were
+	// passed to the next handler without rate limiting.
+	Bypassed int64 `json:"bypassed"`
+	
+	// Denied is the number of requests that matched Deny (403).
+	Denied int64 `json:"denied"`
+	
+	// DryRunRejected is the number of requests that DryRun mode served
+	// although they would have been rate limited or denied. They are also
+	// counted in Allowed.
+	DryRunRejected int64 `json:"dry_run_rejected"`
+	
+	// Evicted is the number of keys dropped to stay within MaxKeys.
+	Evicted int64 `json:"evicted"`
 }
 
 // NewMiddleware creates a new rate limiting middleware.
@@ -99,12 +278,22 @@ func NewMiddleware(config *MiddlewareConfig) *Middleware {
 	
 	m := &Middleware{
 		config:   config,
+		bypass:   compileAccessRule(config.Bypass),
+		deny:     compileAccessRule(config.Deny),
 		limiters: make(map[string]*limiterEntry),
+		lru:      list.New(),
 		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		denials:  newDenialLogger(config.Logger, config.LogSampleRate),
 	}
 	
-	// Start cleanup goroutine
-	go m.cleanup()
+	// Start cleanup goroutine; without an interval idle keys are only
+	// dropped to stay within MaxKeys
+	if config.CleanupInterval > 0 {
+		go m.cleanup()
+	} else {
+		close(m.stopped)
+	}
 	
 	return m
 }
@@ -112,45 +301,424 @@ func NewMiddleware(config *MiddlewareConfig) *Middleware {
 // Handler returns an HTTP handler that applies rate limiting.
 func (m *Middleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := m.config.KeyFunc(r)
+		if m.config.DryRun {
+			m.dryRun(next, w, r)
+			return
+		}
+		if m.checkAccess(next, w, r) {
+			return
+		}
+		
+		key := m.keyFor(r)
+		if m.serveOverride(next, w, r, key) {
+			return
+		}
 		limiter := m.getLimiter(key)
 		
-		if !limiter.Allow() {
-			m.config.OnRateLimited(w, r)
+		cost := m.cost(r)
+		if !limiter.AllowN(cost) {
+			info := RateLimitInfo{Key: key}
+			if c, ok := limiter.(Checker); ok {
+				err := c.CheckN(cost)
+				SetRateLimitHeaders(w, err)
+				info = rateLimitInfo(key, err)
+			}
+			m.rateLimited(w, r, info)
 			return
 		}
 		
-		next.ServeHTTP(w, r)
+		r = m.allowed(r, key, cost)
+		m.serve(next, w, r)
 	})
 }
 
+// SetRateLimitHeaders sets Retry-After, and X-RateLimit-Limit and
+// X-RateLimit-Remaining when the limit is known, from an *ErrLimited in
+// err's chain. It does nothing for other errors, so it is safe to call
+// with any limiter error.
+func SetRateLimitHeaders(w http.ResponseWriter, err error) {
+	var limited *ErrLimited
+	if !errors.As(err, &limited) {
+		return
+	}
+	
+	h := w.Header()
+	h.Set("Retry-After", strconv.Itoa(retryAfterSeconds(limited.RetryAfter)))
+	if limited.Limit > 0 {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(limited.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(limited.Remaining))
+	}
+}
+
 // HandlerFunc returns an HTTP handler function that applies rate limiting.
 func (m *Middleware) HandlerFunc(next http.HandlerFunc) http.HandlerFunc {
 	return m.Handler(http.HandlerFunc(next)).ServeHTTP
 }
 
 // WaitHandler returns an HTTP handler that waits for rate limit availability.
+// The wait never outlasts the caller: it is bounded by the request context's
+// deadline and by the RequestTimeoutHeader value when present, with timeout
+// acting as an upper limit. A timeout of zero or less means no static limit.
 func (m *Middleware) WaitHandler(next http.Handler, timeout time.Duration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := m.config.KeyFunc(r)
+		if m.config.DryRun {
+			m.dryRun(next, w, r)
+			return
+		}
+		if m.checkAccess(next, w, r) {
+			return
+		}
+		
+		key := m.keyFor(r)
+		if m.serveOverride(next, w, r, key) {
+			return
+		}
 		limiter := m.getLimiter(key)
 		
-		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		cost := m.cost(r)
+		
+		ctx, cancel := waitContext(r, timeout)
 		defer cancel()
 		
-		if err := limiter.Wait(ctx); err != nil {
-			if err == context.DeadlineExceeded {
-				http.Error(w, "Request timeout while waiting for rate limit", http.StatusRequestTimeout)
-			} else {
-				http.Error(w, fmt.Sprintf("Rate limit error: %v", err), http.StatusTooManyRequests)
-			}
+		if err := limiter.WaitN(ctx, cost); err != nil {
+			m.waitFailed(w, r, key, err)
 			return
 		}
 		
-		next.ServeHTTP(w, r)
+		r = m.allowed(r, key, cost)
+		m.serve(next, w, r)
+	})
+}
+
+// QueueHandler returns an HTTP handler that, like WaitHandler, waits for rate
+// limit availability, but allows at most maxQueue requests to wait at once.
+// Requests arriving while the queue is full are shed via OnOverloaded (503),
+// since the server rather than the client is the bottleneck.
+// A request whose client disconnects leaves the queue immediately.
+func (m *Middleware) QueueHandler(next http.Handler, maxQueue int, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.config.DryRun {
+			m.dryRun(next, w, r)
+			return
+		}
+		if m.checkAccess(next, w, r) {
+			return
+		}
+		
+		key := m.keyFor(r)
+		if m.serveOverride(next, w, r, key) {
+			return
+		}
+		limiter := m.getLimiter(key)
+		cost := m.cost(r)
+		
+		// Requests that can proceed immediately never occupy a queue slot.
+		if limiter.AllowN(cost) {
+			r = m.allowed(r, key, cost)
+			m.serve(next, w, r)
+			return
+		}
+		
+		if atomic.AddInt64(&m.queued, 1) > int64(maxQueue) {
+			atomic.AddInt64(&m.queued, -1)
+			m.overloaded(w, r)
+			return
+		}
+		
+		err := m.queuedWait(r, limiter, cost, timeout)
+		
+		if err != nil {
+			m.waitFailed(w, r, key, err)
+			return
+		}
+		
+		r = m.allowed(r, key, cost)
+		m.serve(next, w, r)
 	})
 }
 
+// queuedWait waits for cost tokens while holding a QueueHandler slot. The
+// slot is released however the wait ends, including when the client
+// disconnects: the request context is cancelled, which removes the waiter
+// from the limiter's queue without consuming tokens.
+func (m *Middleware) queuedWait(r *http.Request, limiter Limiter, cost int, timeout time.Duration) error {
+	defer atomic.AddInt64(&m.queued, -1)
+	
+	ctx, cancel := waitContext(r, timeout)
+	defer cancel()
+	
+	return limiter.WaitN(ctx, cost)
+}
+
+// Queued returns the number of requests currently waiting in QueueHandler.
+func (m *Middleware) Queued() int {
+	return int(atomic.LoadInt64(&m.queued))
+}
+
+// Counters returns a snapshot of the request outcome counters.
+func (m *Middleware) Counters() MiddlewareCounters {
+	return MiddlewareCounters{
+		Allowed:        atomic.LoadInt64(&m.counters.allowed),
+		RateLimited:    atomic.LoadInt64(&m.counters.limited),
+		Shed:           atomic.LoadInt64(&m.counters.shed),
+		Bypassed:       atomic.LoadInt64(&m.counters.bypassed),
+		Denied:         atomic.LoadInt64(&m.counters.denied),
+		DryRunRejected: atomic.LoadInt64(&m.counters.dryRun),
+		Evicted:        atomic.LoadInt64(&m.counters.evicted),
+	}
+}
+
+// dryRun evaluates r as the enforcing handlers would, reports what they
+// would have rejected, and serves r regardless.
+func (m *Middleware) dryRun(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if m.deny.match(r) {
+		m.wouldReject(r, DryRunEvent{Reason: DryRunDenied})
+		m.serve(next, w, r)
+		return
+	}
+	
+	if m.bypass.match(r) {
+		atomic.AddInt64(&m.counters.bypassed, 1)
+		next.ServeHTTP(w, r)
+		return
+	}
+	
+	key := m.keyFor(r)
+	cost := m.cost(r)
+	if o, d := m.override(key); d > 0 {
+		if !o.exempt {
+			m.wouldReject(r, DryRunEvent{Reason: DryRunRateLimited, Key: key, Cost: cost, RetryAfter: d})
+		}
+		r = m.allowed(r, key, cost)
+		m.serve(next, w, r)
+		return
+	}
+	
+	limiter := m.getLimiter(key)
+	if !limiter.AllowN(cost) {
+		event := DryRunEvent{Reason: DryRunRateLimited, Key: key, Cost: cost}
+		if c, ok := limiter.(Checker); ok {
+			event.RetryAfter, _ = RetryAfter(c.CheckN(cost))
+		}
+		m.wouldReject(r, event)
+	}
+	
+	r = m.allowed(r, key, cost)
+	m.serve(next, w, r)
+}
+
+// wouldReject records a rejection skipped by DryRun mode.
+func (m *Middleware) wouldReject(r *http.Request, event DryRunEvent) {
+	atomic.AddInt64(&m.counters.dryRun, 1)
+	if m.config.OnDryRun != nil {
+		m.config.OnDryRun(r, event)
+	}
+}
+
+// checkAccess applies the Deny and Bypass rules. It reports whether the
+// request was handled, either rejected or passed to next unlimited.
+func (m *Middleware) checkAccess(next http.Handler, w http.ResponseWriter, r *http.Request) bool {
+	if m.deny.match(r) {
+		atomic.AddInt64(&m.counters.denied, 1)
+		m.emit(Event{Kind: EventDenied, Request: r})
+		if m.config.OnDenied != nil {
+			m.config.OnDenied(w, r)
+		} else {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+		return true
+	}
+	
+	if m.bypass.match(r) {
+		atomic.AddInt64(&m.counters.bypassed, 1)
+		next.ServeHTTP(w, r)
+		return true
+	}
+	
+	return false
+}
+
+// allowed reports a request admitted under key to OnAllowed, and returns
+// it with its CostReporter if CostReporting is set.
+func (m *Middleware) allowed(r *http.Request, key string, cost int) *http.Request {
+	if m.config.OnAllowed != nil {
+		m.config.OnAllowed(r, key, cost)
+	}
+	if m.config.CostReporting {
+		r = withCostReporter(r, m, key)
+	}
+	return r
+}
+
+// serve passes an admitted request to next.
+func (m *Middleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&m.counters.allowed, 1)
+	next.ServeHTTP(w, r)
+}
+
+// rateLimitInfo builds the RateLimitInfo for key from a CheckN error.
+func rateLimitInfo(key string, err error) RateLimitInfo {
+	info := RateLimitInfo{Key: key}
+	var limited *ErrLimited
+	if errors.As(err, &limited) {
+		info.Limit = limited.Limit
+		info.Remaining = limited.Remaining
+		info.RetryAfter = limited.RetryAfter
+	}
+	return info
+}
+
+// rateLimited rejects a request whose key exceeded its rate. info is made
+// available to OnRateLimited through RateLimitInfoFromContext.
+func (m *Middleware) rateLimited(w http.ResponseWriter, r *http.Request, info RateLimitInfo) {
+	m.countLimited(r, info)
+	r = r.WithContext(context.WithValue(r.Context(), rateLimitInfoKey{}, info))
+	if m.config.OnRateLimited != nil {
+		m.config.OnRateLimited(w, r)
+		return
+	}
+	defaultOnRateLimited(w, r)
+}
+
+// countLimited records a rate limited request described by info, logs it
+// and emits its event. r is nil for Registry calls.
+func (m *Middleware) countLimited(r *http.Request, info RateLimitInfo) {
+	atomic.AddInt64(&m.counters.limited, 1)
+	
+	m.mu.RLock()
+	if entry, ok := m.limiters[info.Key]; ok {
+		atomic.AddInt64(&entry.limited, 1)
+	}
+	m.mu.RUnlock()
+	
+	if m.denials.sample() {
+		m.denials.log(r, info)
+	}
+	m.emit(Event{Kind: EventRateLimited, Key: info.Key, RetryAfter: info.RetryAfter, Request: r})
+}
+
+// overloaded sheds a request because the server cannot take more work.
+func (m *Middleware) overloaded(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&m.counters.shed, 1)
+	m.emit(Event{Kind: EventShed, Request: r})
+	if m.config.OnOverloaded != nil {
+		m.config.OnOverloaded(w, r)
+		return
+	}
+	
+	retryAfter := m.config.OverloadRetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	http.Error(w, "Service overloaded", http.StatusServiceUnavailable)
+}
+
+// waitFailed writes the response for a request whose wait did not succeed.
+// Nothing is written if the client has already gone away.
+func (m *Middleware) waitFailed(w http.ResponseWriter, r *http.Request, key string, err error) {
+	if clientGone(r) {
+		return
+	}
+	
+	m.countLimited(r, rateLimitInfo(key, err))
+	SetRateLimitHeaders(w, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Request timeout while waiting for rate limit", http.StatusRequestTimeout)
+	} else {
+		http.Error(w, fmt.Sprintf("Rate limit error: %v", err), http.StatusTooManyRequests)
+	}
+}
+
+// clientGone reports whether the client disconnected before the request
+// was served. net/http cancels the request context when that happens.
+func clientGone(r *http.Request) bool {
+	return r.Context().Err() == context.Canceled
+}
+
+// waitContext derives the context for waiting on the limiter from the
+// request's own deadline, its RequestTimeoutHeader and the static timeout.
+func waitContext(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	budget := timeout
+	if d, ok := parseRequestTimeout(r.Header.Get(RequestTimeoutHeader)); ok {
+		if budget <= 0 || d < budget {
+			budget = d
+		}
+	}
+	
+	if budget <= 0 {
+		// Only the request context's own deadline applies.
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), budget)
+}
+
+// parseRequestTimeout parses a RequestTimeoutHeader value.
+func parseRequestTimeout(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d, true
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// cost returns the number of tokens the request consumes.
+func (m *Middleware) cost(r *http.Request) int {
+	if m.config.CostFunc == nil {
+		return 1
+	}
+	if n := m.config.CostFunc(r); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// keyFor returns the limiter map key of r.
+func (m *Middleware) keyFor(r *http.Request) string {
+	key := m.config.KeyFunc(r)
+	if m.config.TierFunc != nil {
+		key = tierKey(m.config.TierFunc(r), key)
+	}
+	return key
+}
+
+// tierKey is the limiter map key for key in tier. Keys are always prefixed
+// when TierFunc is set, and colons in the tier are escaped, so neither a
+// tier nor a key can pass for another: tier "plan" with key "pro:1" and
+// tier "plan:pro" with key "1" get different limiters.
+func tierKey(tier, key string) string {
+	return keyEscaper.Replace(tier) + ":" + key
+}
+
+// tierOf returns the tier of a limiter map key, or "" if TierFunc is not
+// set.
+func (m *Middleware) tierOf(key string) string {
+	if m.config.TierFunc == nil {
+		return ""
+	}
+	tier, _, _ := strings.Cut(key, ":")
+	return keyUnescaper.Replace(tier)
+}
+
+// factoryFor returns the limiter factory for key in tier: the factory
+// registered for exactly that tier, or else the keyed or default factory.
+func (m *Middleware) factoryFor(tier, key string) func() Limiter {
+	if m.config.TierFunc != nil {
+		if f, ok := m.config.Tiers[tier]; ok {
+			return f
+		}
+	}
+	if keyed := m.config.KeyedLimiterFactory; keyed != nil {
+		return func() Limiter { return keyed(key) }
+	}
+	return m.config.LimiterFactory
+}
+
 // getLimiter returns the rate limiter for the given key.
 func (m *Middleware) getLimiter(key string) Limiter {
 	m.mu.RLock()
@@ -158,34 +726,127 @@ func (m *Middleware) getLimiter(key string) Limiter {
 	m.mu.RUnlock()
 	
 	if exists {
-		// Update last access time
+		// Update last access time; the limiter is read under the lock as
+		// Reconfigure may replace it
 		m.mu.Lock()
-		entry.lastAccess = time.Now()
+		m.touch(entry)
+		limiter := entry.limiter
 		m.mu.Unlock()
-		return entry.limiter
+		return limiter
 	}
 	
 	// Create new limiter
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	
 	// Double-check after acquiring write lock
 	if entry, exists := m.limiters[key]; exists {
-		entry.lastAccess = time.Now()
-		return entry.limiter
+		m.touch(entry)
+		limiter := entry.limiter
+		m.mu.Unlock()
+		return limiter
+	}
+	
+	tier := m.tierOf(key)
+	limiter := m.factoryFor(tier, key)()
+	if m.limit.apply(limiter) {
+		// Start with the full budget of the new limits
+		limiter.Reset()
+	}
+	evicted := m.insert(key, tier, limiter, time.Now())
+	m.unlock()
+	
+	for _, victim := range evicted {
+		m.emit(Event{Kind: EventEvicted, Key: victim})
+	}
+	return limiter
+}
+
+// touch marks entry as just used. m.mu must be held for writing.
+func (m *Middleware) touch(entry *limiterEntry) {
+	entry.lastAccess = time.Now()
+	if entry.elem != nil {
+		m.lru.MoveToFront(entry.elem)
+	}
+}
+
+// insert adds a limiter for key, replacing any existing one, and evicts
+// the least recently used keys beyond MaxKeys, which it returns. m.mu must
+// be held for writing.
+func (m *Middleware) insert(key, tier string, limiter Limiter, now time.Time) (evicted []string) {
+	if old, ok := m.limiters[key]; ok {
+		m.remove(key, old)
 	}
 	
-	limiter := m.config.LimiterFactory()
 	m.limiters[key] = &limiterEntry{
 		limiter:    limiter,
-		lastAccess: time.Now(),
+		tier:       tier,
+		lastAccess: now,
+		elem:       m.lru.PushFront(key),
 	}
 	
-	return limiter
+	if m.config.MaxKeys <= 0 {
+		return nil
+	}
+	for len(m.limiters) > m.config.MaxKeys {
+		oldest := m.lru.Back()
+		victim := oldest.Value.(string)
+		m.remove(victim, m.limiters[victim])
+		atomic.AddInt64(&m.counters.evicted, 1)
+		evicted = append(evicted, victim)
+	}
+	return evicted
+}
+
+// remove drops the limiter for key, to be closed by unlock if it
+// implements Closer. m.mu must be held for writing.
+func (m *Middleware) remove(key string, entry *limiterEntry) {
+	m.lru.Remove(entry.elem)
+	delete(m.limiters, key)
+	m.drop(entry.limiter)
+}
+
+// drop queues limiter to be closed by unlock if it implements Closer. m.mu
+// must be held for writing.
+func (m *Middleware) drop(limiter Limiter) {
+	if c, ok := limiter.(Closer); ok {
+		m.dropped = append(m.dropped, c)
+	}
+}
+
+// unlock releases m.mu, held for writing, and then closes the limiters
+// dropped meanwhile. Close may block on I/O, so it must not hold up
+// requests waiting for m.mu; closing before returning rather than in a
+// goroutine keeps the promise of Middleware.Close.
+func (m *Middleware) unlock() {
+	dropped := m.dropped
+	m.dropped = nil
+	m.mu.Unlock()
+	
+	for _, c := range dropped {
+		c.Close()
+	}
+}
+
+// Preload creates the limiter for a limiter map key, as produced by
+// KeyFunc and TierFunc, and consumes up to used units of its budget. It
+// returns the units consumed, which are bounded by what is available. Use
+// it at startup to carry over usage recorded elsewhere.
+func (m *Middleware) Preload(key string, used int) int {
+	limiter := m.getLimiter(key)
+	
+	if n := limiter.Available(); n < used {
+		used = n
+	}
+	if used <= 0 || !limiter.AllowN(used) {
+		return 0
+	}
+	return used
 }
 
 // cleanup periodically removes idle limiters.
 func (m *Middleware) cleanup() {
+	defer close(m.stopped)
+	
 	ticker := time.NewTicker(m.config.CleanupInterval)
 	defer ticker.Stop()
 	
@@ -202,19 +863,39 @@ func (m *Middleware) cleanup() {
 // cleanupIdle removes limiters that haven't been accessed recently.
 func (m *Middleware) cleanupIdle() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 	
 	now := time.Now()
 	for key, entry := range m.limiters {
 		if now.Sub(entry.lastAccess) > m.config.MaxIdleTime {
-			delete(m.limiters, key)
+			m.remove(key, entry)
 		}
 	}
 }
 
-// Close stops the cleanup goroutine and releases resources.
+// Close stops the cleanup goroutine and waits for it to exit, so no
+// goroutine started by the middleware is running once Close returns. It
+// also drops the keyed limiters implementing Closer and closes them before
+// returning. The handler keeps serving requests afterwards but no longer
+// drops idle keys on a timer. Close may be called more than once.
 func (m *Middleware) Close() {
-	close(m.done)
+	m.closing.Do(func() { close(m.done) })
+	<-m.stopped
+	
+	var closers []Closer
+	m.mu.Lock()
+	for key, entry := range m.limiters {
+		if c, ok := entry.limiter.(Closer); ok {
+			m.lru.Remove(entry.elem)
+			delete(m.limiters, key)
+			closers = append(closers, c)
+		}
+	}
+	m.mu.Unlock()
+	
+	for _, c := range closers {
+		c.Close()
+	}
 }
 
 // Stats returns statistics about the current limiters.
@@ -228,4 +909,89 @@ func (m *Middleware) Stats() map[string]int {
 	}
 	
 	return stats
-}
\ No newline at end of file
+}
+
+// Snapshot encodes the state of every keyed limiter that implements
+// Snapshotter, so that it can be restored after a restart.
+func (m *Middleware) Snapshot() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	states := make(map[string]json.RawMessage, len(m.limiters))
+	for key, entry := range m.limiters {
+		s, ok := entry.limiter.(Snapshotter)
+		if !ok {
+			continue
+		}
+		data, err := s.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("snapshot key %q: %w", key, err)
+		}
+		states[key] = data
+	}
+	
+	return json.Marshal(states)
+}
+
+// Restore recreates keyed limiters from a Snapshot. Limiters are built with
+// the factory of their tier, or LimiterFactory; keys whose limiter does not
+// implement Snapshotter are skipped.
+func (m *Middleware) Restore(data []byte) error {
+	var states map[string]json.RawMessage
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("decode middleware snapshot: %w", err)
+	}
+	
+	m.mu.Lock()
+	defer m.unlock()
+	
+	now := time.Now()
+	for key, state := range states {
+		tier := m.tierOf(key)
+		limiter := m.factoryFor(tier, key)()
+		s, ok := limiter.(Snapshotter)
+		if !ok {
+			continue
+		}
+		if err := s.Restore(state); err != nil {
+			return fmt.Errorf("restore key %q: %w", key, err)
+		}
+		m.limit.apply(limiter)
+		m.insert(key, tier, limiter, now)
+	}
+	
+	return nil
+}
+
+// Reconfigure switches the middleware to a new keyed limiter factory, for
+// example after a configuration reload, by setting KeyedLimiterFactory of
+// its configuration. The limiter of each existing key is passed to adjust,
+// which may change it in place, for example through Reconfigurer, and
+// reports whether to keep it; callers blocked on a kept limiter keep their
+// place. Other keys are rebuilt with the new factory, or that of their
+// tier, carrying their state over when both limiters implement
+// Snapshotter; replaced limiters that implement Closer are closed before
+// it returns. A nil adjust rebuilds every key. Limits set with SetLimit are
+// dropped.
+func (m *Middleware) Reconfigure(factory func(key string) Limiter, adjust func(key string, limiter Limiter) bool) {
+	m.mu.Lock()
+	defer m.unlock()
+	
+	m.config.KeyedLimiterFactory = factory
+	m.limit = nil
+	for key, entry := range m.limiters {
+		if adjust != nil && adjust(key, entry.limiter) {
+			continue
+		}
+		limiter := m.factoryFor(entry.tier, key)()
+		if old, ok := entry.limiter.(Snapshotter); ok {
+			if next, ok := limiter.(Snapshotter); ok {
+				if data, err := old.Snapshot(); err == nil {
+					next.Restore(data)
+				}
+			}
+		}
+		m.drop(entry.limiter)
+		entry.limiter = limiter
+	}
+}
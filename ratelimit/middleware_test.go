@@ -0,0 +1,200 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/internal/leakcheck"
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func TestMiddlewareTierKeysDoNotCollide(t *testing.T) {
+	newLimiter := func(rate int) func() ratelimit.Limiter {
+		return func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(rate), ratelimit.WithPeriod(time.Hour))
+		}
+	}
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:  ratelimit.HeaderKeyFunc("X-User", nil),
+		TierFunc: func(r *http.Request) string { return r.Header.Get("X-Tier") },
+		Tiers: map[string]func() ratelimit.Limiter{
+			"plan":     newLimiter(1),
+			"plan:pro": newLimiter(100),
+		},
+		LimiterFactory: newLimiter(1),
+	})
+	defer m.Close()
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(tier, user string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Tier", tier)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// A "plan" user choosing a key that looks like a "plan:pro" key must
+	// neither get the pro limits nor share a pro user's limiter.
+	if code := serve("plan", "pro:123"); code != http.StatusOK {
+		t.Fatalf("first plan request: status %d", code)
+	}
+	if code := serve("plan", "pro:123"); code != http.StatusTooManyRequests {
+		t.Fatalf("second plan request: status %d, want 429", code)
+	}
+	for i := 0; i < 10; i++ {
+		if code := serve("plan:pro", "123"); code != http.StatusOK {
+			t.Fatalf("pro request %d: status %d", i, code)
+		}
+	}
+}
+
+func TestMiddlewarePreloadResolvesEscapedTier(t *testing.T) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:  ratelimit.IPKeyFunc,
+		TierFunc: func(r *http.Request) string { return "plan:pro" },
+		Tiers: map[string]func() ratelimit.Limiter{
+			"plan:pro": func() ratelimit.Limiter {
+				return ratelimit.NewFixedWindow(ratelimit.WithRate(100), ratelimit.WithPeriod(time.Hour))
+			},
+		},
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour))
+		},
+	})
+	defer m.Close()
+
+	if got := m.Preload("plan%3Apro:10.0.0.1", 50); got != 50 {
+		t.Errorf("Preload = %d, want 50 from the plan:pro limiter", got)
+	}
+}
+
+// closingLimiter is a limiter running a goroutine until it is closed.
+type closingLimiter struct {
+	ratelimit.Limiter
+	done chan struct{}
+}
+
+func newClosingLimiter() *closingLimiter {
+	l := &closingLimiter{Limiter: ratelimit.NewTokenBucket(), done: make(chan struct{})}
+	go func() { <-l.done }()
+	return l
+}
+
+func (l *closingLimiter) Close() error {
+	close(l.done)
+	return nil
+}
+
+func TestMiddlewareReconfigureClosesReplacedLimiters(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:        ratelimit.IPKeyFunc,
+		LimiterFactory: func() ratelimit.Limiter { return newClosingLimiter() },
+	})
+	for i := 0; i < 10; i++ {
+		m.Preload(fmt.Sprintf("10.0.0.%d", i), 0)
+	}
+	for i := 0; i < 3; i++ {
+		m.Reconfigure(func(string) ratelimit.Limiter { return newClosingLimiter() }, nil)
+	}
+	m.Close()
+}
+
+// closed reports whether l has been closed.
+func (l *closingLimiter) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestMiddlewareClosesDroppedLimitersBeforeReturning(t *testing.T) {
+	var limiters []*closingLimiter
+	newLimiter := func() ratelimit.Limiter {
+		l := newClosingLimiter()
+		limiters = append(limiters, l)
+		return l
+	}
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:        ratelimit.IPKeyFunc,
+		LimiterFactory: newLimiter,
+		MaxKeys:        2,
+	})
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		m.Preload(fmt.Sprintf("10.0.0.%d", i), 0)
+	}
+	if !limiters[0].closed() {
+		t.Error("evicted limiter not closed when the request that evicted it returned")
+	}
+
+	replaced := limiters[1:]
+	m.Reconfigure(func(string) ratelimit.Limiter { return newLimiter() }, nil)
+	for i, l := range replaced {
+		if !l.closed() {
+			t.Errorf("replaced limiter %d not closed when Reconfigure returned", i)
+		}
+	}
+}
+
+func TestMiddlewareCloseStopsGoroutines(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc:         ratelimit.IPKeyFunc,
+		LimiterFactory:  func() ratelimit.Limiter { return newClosingLimiter() },
+		CleanupInterval: time.Millisecond,
+		MaxIdleTime:     time.Millisecond,
+		MaxKeys:         5,
+	})
+	// Keys beyond MaxKeys are evicted, the rest idle out or stay until
+	// Close; every path must close its limiter
+	for i := 0; i < 10; i++ {
+		m.Preload(fmt.Sprintf("10.0.0.%d", i), 0)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		m.Preload(fmt.Sprintf("10.0.1.%d", i), 0)
+	}
+	m.Close()
+	m.Close()
+}
+
+func TestSetRateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want http.Header
+	}{
+		{"limited", &ratelimit.ErrLimited{RetryAfter: 2 * time.Second, Limit: 10, Remaining: 1}, http.Header{
+			"Retry-After":           {"2"},
+			"X-Ratelimit-Limit":     {"10"},
+			"X-Ratelimit-Remaining": {"1"},
+		}},
+		{"unknown limit", fmt.Errorf("wait: %w", &ratelimit.ErrLimited{RetryAfter: time.Second, Err: context.DeadlineExceeded}), http.Header{
+			"Retry-After": {"1"},
+		}},
+		{"other error", errors.New("backend down"), http.Header{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ratelimit.SetRateLimitHeaders(w, tt.err)
+			if got := w.Header(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStop is returned by the function of Paced to stop without an error.
+var ErrStop = errors.New("ratelimit: stop")
+
+// Paced calls fn repeatedly, each call waiting for a unit of limiter, so
+// that a consumer of a queue processes jobs no faster than limiter allows
+// rather than polling with a ticker. fn handles one job per call, receiving
+// it from a channel or polling Kafka, SQS and the like; limiters shared by
+// several consumers pace them together.
+//
+//	err := ratelimit.Paced(ctx, limiter, func(ctx context.Context) error {
+//		job, ok := <-jobs
+//		if !ok {
+//			return ratelimit.ErrStop
+//		}
+//		return process(ctx, job)
+//	})
+//
+// Paced returns nil when fn returns ErrStop, and otherwise the first error
+// of fn, or the error of the wait once ctx is done.
+func Paced(ctx context.Context, limiter Limiter, fn func(ctx context.Context) error) error {
+	for {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		if err := fn(ctx); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+}
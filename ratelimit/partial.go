@@ -0,0 +1,36 @@
+package ratelimit
+
+// PartialAllower is implemented by limiters that can admit part of a
+// batch at once. TokenBucket, FixedWindow and SlidingWindow implement it.
+type PartialAllower interface {
+	// AllowUpTo admits as many of n requests as are available now, up to
+	// n, and returns how many; 0 if none.
+	AllowUpTo(n int) int
+}
+
+// AllowUpTo admits as many of n requests as limiter has available, up to
+// n, and returns how many, so that a batch processor can size its next
+// batch by the budget left instead of failing when n exceeds it:
+//
+//	granted := ratelimit.AllowUpTo(limiter, len(pending))
+//	process(pending[:granted])
+//	pending = pending[granted:]
+//
+// It uses the AllowUpTo of a PartialAllower. For other limiters it tries
+// AllowN from Available down to 1, which is not atomic: concurrent callers
+// may leave it with fewer units than were available.
+func AllowUpTo(limiter Limiter, n int) int {
+	if p, ok := limiter.(PartialAllower); ok {
+		return p.AllowUpTo(n)
+	}
+	k := limiter.Available()
+	if k > n {
+		k = n
+	}
+	for ; k > 0; k-- {
+		if limiter.AllowN(k) {
+			return k
+		}
+	}
+	return 0
+}
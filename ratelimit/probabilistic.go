@@ -0,0 +1,237 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// probabilisticAlpha is the weight of the latest interval when the
+// acceptance probability moves toward the ideal one.
+const probabilisticAlpha = 0.7
+
+// Probabilistic admits each request with an acceptance probability that it
+// adjusts toward a target throughput: at the end of every Config.Period it
+// measures the offered rate and moves the probability toward
+// targetRate/offered. Unlike the counting limiters it keeps no per-request
+// state and never rejects in bursts, but the admitted rate only converges
+// on the target and may overshoot it while traffic changes.
+//
+// The probability starts at 1, so the first interval admits everything.
+// Decisions come from a random source seeded by Config.Seed, so tests can
+// reproduce them. Adjustments are made by the first call after an interval
+// ends; no goroutine is needed.
+type Probabilistic struct {
+	config     *Config
+	targetRate float64
+
+	mu          sync.Mutex
+	rand        *rand.Rand
+	probability float64
+	start       time.Time // of the current interval
+	requests    int64     // offered in the current interval
+	accepted    int64     // admitted in the current interval
+	currentRate float64   // admitted per second in the last interval
+	stats       ProbabilisticStats
+}
+
+// ProbabilisticStats describes a Probabilistic limiter. The counters run
+// since it was created or last reset.
+type ProbabilisticStats struct {
+	// TargetRate is the throughput aimed at, in requests per second.
+	TargetRate float64 `json:"target_rate"`
+
+	// CurrentRate is the admitted rate of the last complete interval, in
+	// requests per second.
+	CurrentRate float64 `json:"current_rate"`
+
+	// AcceptanceProbability is the probability a request is admitted now.
+	AcceptanceProbability float64 `json:"acceptance_probability"`
+
+	Requests int64 `json:"requests"`
+	Accepted int64 `json:"accepted"`
+	Rejected int64 `json:"rejected"`
+}
+
+// NewProbabilistic creates a probabilistic limiter aiming at targetRate
+// requests per second. Of the options, Period sets the adjustment interval
+// (one second by default), Seed the random source and Clock the time
+// source; Rate and Burst are ignored.
+func NewProbabilistic(targetRate float64, opts ...Option) *Probabilistic {
+	cfg := NewConfig(opts...)
+	if cfg.Period <= 0 {
+		cfg.Period = time.Second
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = cfg.Clock.Now().UnixNano()
+	}
+
+	p := &Probabilistic{
+		config:      cfg,
+		targetRate:  targetRate,
+		rand:        rand.New(rand.NewSource(seed)),
+		probability: 1,
+		start:       cfg.Clock.Now(),
+	}
+	p.stats.TargetRate = targetRate
+	return p
+}
+
+// Allow checks if a single request can proceed.
+func (p *Probabilistic) Allow() bool {
+	return p.AllowN(1)
+}
+
+// AllowN admits or rejects n requests together, with the acceptance
+// probability of a single one.
+func (p *Probabilistic) AllowN(n int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.try(n, true) {
+		p.stats.Rejected += int64(n)
+		return false
+	}
+	return true
+}
+
+// Wait blocks until a request can proceed or context is cancelled.
+func (p *Probabilistic) Wait(ctx context.Context) error {
+	return p.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests can proceed or context is cancelled. A
+// rejected caller draws again after the mean interval between admissions
+// at the target rate. Only the first draw counts as offered traffic, so
+// waiting callers do not push the acceptance probability down, and the
+// requests count as rejected only if the context ends first.
+func (p *Probabilistic) WaitN(ctx context.Context, n int) error {
+	// A context that is already done never consumes capacity
+	if err := ctx.Err(); err != nil {
+		return deadlineError(err, func() *ErrLimited { return p.limited(n) })
+	}
+
+	interval := time.Second
+	if p.targetRate > 0 {
+		interval = time.Duration(float64(time.Second) / p.targetRate)
+	}
+
+	for first := true; ; first = false {
+		p.mu.Lock()
+		ok := p.try(n, first)
+		p.mu.Unlock()
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.stats.Rejected += int64(n)
+			p.mu.Unlock()
+			return deadlineError(ctx.Err(), func() *ErrLimited { return p.limited(n) })
+		case <-p.config.Clock.After(interval):
+		}
+	}
+}
+
+// try draws whether n requests are admitted, counting them as offered if
+// offered is true. The caller must hold p.mu.
+func (p *Probabilistic) try(n int, offered bool) bool {
+	p.roll(p.config.Clock.Now())
+
+	if offered {
+		p.requests += int64(n)
+		p.stats.Requests += int64(n)
+	}
+	if p.rand.Float64() >= p.probability {
+		return false
+	}
+	p.accepted += int64(n)
+	p.stats.Accepted += int64(n)
+	return true
+}
+
+// roll adjusts the acceptance probability once the current interval has
+// ended. The caller must hold p.mu.
+func (p *Probabilistic) roll(now time.Time) {
+	elapsed := now.Sub(p.start)
+	if elapsed < p.config.Period {
+		return
+	}
+
+	seconds := elapsed.Seconds()
+	p.currentRate = float64(p.accepted) / seconds
+	ideal := 1.0
+	if offered := float64(p.requests) / seconds; offered > p.targetRate {
+		ideal = math.Max(p.targetRate, 0) / offered
+	}
+	p.probability += probabilisticAlpha * (ideal - p.probability)
+
+	p.start = now
+	p.requests, p.accepted = 0, 0
+}
+
+// limited describes a rejection of n requests. It takes p.mu itself.
+func (p *Probabilistic) limited(n int) *ErrLimited {
+	retryAfter := time.Second
+	if p.targetRate > 0 {
+		retryAfter = time.Duration(float64(n) * float64(time.Second) / p.targetRate)
+	}
+	return &ErrLimited{
+		RetryAfter: retryAfter,
+		Limit:      p.limit(),
+		Remaining:  p.Available(),
+	}
+}
+
+// limit is the number of requests the target rate admits per interval.
+func (p *Probabilistic) limit() int {
+	return int(math.Round(p.targetRate * p.config.Period.Seconds()))
+}
+
+// Reset restores an acceptance probability of 1 and clears the counters.
+func (p *Probabilistic) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probability = 1
+	p.start = p.config.Clock.Now()
+	p.requests, p.accepted, p.currentRate = 0, 0, 0
+	p.stats = ProbabilisticStats{TargetRate: p.targetRate}
+}
+
+// Available returns how many more requests the target rate allows in the
+// current interval. Requests are still admitted by chance, so it is an
+// estimate rather than a guarantee.
+func (p *Probabilistic) Available() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.roll(p.config.Clock.Now())
+	return max(p.limit()-int(p.accepted), 0)
+}
+
+// AcceptanceProbability returns the probability a request is admitted now.
+func (p *Probabilistic) AcceptanceProbability() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.roll(p.config.Clock.Now())
+	return p.probability
+}
+
+// Stats returns the counters and the current acceptance probability.
+func (p *Probabilistic) Stats() ProbabilisticStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.roll(p.config.Clock.Now())
+	stats := p.stats
+	stats.CurrentRate = p.currentRate
+	stats.AcceptanceProbability = p.probability
+	return stats
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies resolves the address of clients behind reverse proxies.
+// X-Forwarded-For can be set by anyone, so it is only believed as far as
+// it was written by trusted proxies: starting from the address the
+// connection came from, the hops are followed from right to left while
+// they are trusted, and the first untrusted hop is the client. Clients
+// therefore cannot choose their key by sending a forged header.
+//
+//	proxies, err := ratelimit.NewTrustedProxies("10.0.0.0/8", "2001:db8::/32")
+//	config.KeyFunc = proxies.KeyFunc
+//
+// A nil *TrustedProxies trusts no proxy and uses the connection address.
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// defaultTrustedProxies are the proxies IPKeyFunc trusts: loopback and
+// private networks, where a reverse proxy usually runs and a client on the
+// internet cannot connect from.
+var defaultTrustedProxies = &TrustedProxies{networks: MustParseCIDRs(
+	"127.0.0.0/8", "::1",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+)}
+
+// NewTrustedProxies returns the proxies in networks, in CIDR notation or
+// single addresses.
+func NewTrustedProxies(networks ...string) (*TrustedProxies, error) {
+	nets, err := ParseCIDRs(networks...)
+	if err != nil {
+		return nil, err
+	}
+	return &TrustedProxies{networks: nets}, nil
+}
+
+// Trusted reports whether ip is one of the proxies.
+func (p *TrustedProxies) Trusted(ip net.IP) bool {
+	if p == nil {
+		return false
+	}
+	for _, n := range p.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client of r, or nil if RemoteAddr
+// is not an address. If the connection came from a trusted proxy, the
+// client is the right-most untrusted hop of X-Forwarded-For, or its
+// left-most hop if all are trusted; without X-Forwarded-For, X-Real-IP is
+// used. A hop that is not an address ends the search at the proxy that
+// reported it.
+func (p *TrustedProxies) ClientIP(r *http.Request) net.IP {
+	ip := parseHop(r.RemoteAddr)
+	if ip == nil || !p.Trusted(ip) {
+		return ip
+	}
+
+	hops := forwardedHops(r)
+	if len(hops) == 0 {
+		if xri := parseHop(r.Header.Get("X-Real-IP")); xri != nil {
+			return xri
+		}
+		return ip
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !p.Trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// KeyFunc returns the address of the client of r as resolved by
+// ClientIP, without a port. It is a MiddlewareConfig.KeyFunc.
+func (p *TrustedProxies) KeyFunc(r *http.Request) string {
+	if ip := p.ClientIP(r); ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedHops returns the hops of all X-Forwarded-For headers of r, in
+// order.
+func forwardedHops(r *http.Request) []string {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address with or without a port, or returns nil.
+func parseHop(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Limiter returns the quota of key as a ratelimit.Limiter, for use as
+// ratelimit.MiddlewareConfig.KeyedLimiterFactory. The usage is kept by q,
+// so limiters of the same key share it, and dropping one loses nothing.
+// The limiter also implements ratelimit.Checker and ratelimit.Inspector.
+func (q *Quota) Limiter(key string) ratelimit.Limiter {
+	return &keyLimiter{quota: q, key: key}
+}
+
+// keyLimiter is the quota of one key as a ratelimit.Limiter.
+type keyLimiter struct {
+	quota *Quota
+	key   string
+}
+
+// Allow records one unit if it is within the quota.
+func (l *keyLimiter) Allow() bool { return l.quota.AllowN(l.key, 1) }
+
+// AllowN records n units if they are within the quota.
+func (l *keyLimiter) AllowN(n int) bool { return l.quota.AllowN(l.key, n) }
+
+// Wait blocks until one unit is within the quota or ctx is done.
+func (l *keyLimiter) Wait(ctx context.Context) error { return l.WaitN(ctx, 1) }
+
+// WaitN blocks until n units are within the quota, which may take until
+// the end of the period, or ctx is done.
+func (l *keyLimiter) WaitN(ctx context.Context, n int) error {
+	// A context that is already done never consumes capacity
+	for ctx.Err() == nil {
+		if l.quota.AllowN(l.key, n) {
+			return nil
+		}
+		var limited *ratelimit.ErrLimited
+		if err := l.quota.Check(l.key, n); !errors.As(err, &limited) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-l.quota.config.Clock.After(limited.RetryAfter):
+		}
+	}
+
+	err := ctx.Err()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var limited *ratelimit.ErrLimited
+	if !errors.As(l.quota.Check(l.key, n), &limited) {
+		limited = &ratelimit.ErrLimited{}
+	}
+	limited.Err = err
+	return limited
+}
+
+// Reset forgets the usage of the key.
+func (l *keyLimiter) Reset() { l.quota.Reset(l.key) }
+
+// Available returns the usage the key may still record, or math.MaxInt
+// without a hard limit.
+func (l *keyLimiter) Available() int {
+	remaining := l.quota.Remaining(l.key)
+	if remaining < 0 || remaining > math.MaxInt {
+		return math.MaxInt
+	}
+	return int(remaining)
+}
+
+// Check returns nil if one unit is within the quota, otherwise an
+// *ratelimit.ErrLimited.
+func (l *keyLimiter) Check() error { return l.quota.Check(l.key, 1) }
+
+// CheckN returns nil if n units are within the quota, otherwise an
+// *ratelimit.ErrLimited.
+func (l *keyLimiter) CheckN(n int) error { return l.quota.Check(l.key, n) }
+
+// Info describes the hard limit with the least usage remaining; without
+// one, Limit is 0 and Remaining math.MaxInt.
+func (l *keyLimiter) Info() ratelimit.Info {
+	now := l.quota.config.Clock.Now()
+	info := ratelimit.Info{Remaining: math.MaxInt, ResetAt: now, NextAvailable: now}
+	for _, u := range l.quota.Usage(l.key) {
+		if u.Remaining < 0 || info.Limit > 0 && int(u.Remaining) >= info.Remaining {
+			continue
+		}
+		info.Limit, info.Remaining, info.ResetAt = int(u.Hard), int(u.Remaining), u.ResetAt
+	}
+	var limited *ratelimit.ErrLimited
+	if errors.As(l.quota.Check(l.key, 1), &limited) {
+		info.NextAvailable = now.Add(limited.RetryAfter)
+	}
+	return info
+}
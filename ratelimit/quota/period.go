@@ -0,0 +1,108 @@
+package quota
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is a calendar period over which usage is counted. Periods follow
+// the calendar rather than a sliding window: a daily quota resets at
+// midnight and a monthly one on the first of the month, in the location
+// of the Quota.
+type Period int
+
+const (
+	Hour Period = iota + 1
+	Day
+	Week // starting on Monday
+	Month
+)
+
+// periods are the periods counted for every key, shortest first.
+var periods = [...]Period{Hour, Day, Week, Month}
+
+// String returns "hour", "day", "week" or "month".
+func (p Period) String() string {
+	switch p {
+	case Hour:
+		return "hour"
+	case Day:
+		return "day"
+	case Week:
+		return "week"
+	case Month:
+		return "month"
+	}
+	return fmt.Sprintf("Period(%d)", int(p))
+}
+
+// ParsePeriod parses the name of a period, as returned by String.
+func ParsePeriod(s string) (Period, error) {
+	for _, p := range periods {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("quota: unknown period %q", s)
+}
+
+// MarshalText implements encoding.TextMarshaler, so periods appear by
+// name in JSON.
+func (p Period) MarshalText() ([]byte, error) {
+	if p.index() < 0 {
+		return nil, fmt.Errorf("quota: unknown period %d", int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Period) UnmarshalText(text []byte) error {
+	parsed, err := ParsePeriod(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// index returns the position of p in periods, or -1 if p is not valid.
+func (p Period) index() int {
+	if p < Hour || p > Month {
+		return -1
+	}
+	return int(p - Hour)
+}
+
+// Start returns the start of the period containing t, in loc.
+func (p Period) Start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch p {
+	case Hour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, loc)
+	case Day:
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case Week:
+		back := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(year, month, day-back, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
+
+// End returns the end of the period starting at start, which is the start
+// of the next one.
+func (p Period) End(start time.Time) time.Time {
+	switch p {
+	case Hour:
+		return start.Add(time.Hour)
+	case Day:
+		return start.AddDate(0, 0, 1)
+	case Week:
+		return start.AddDate(0, 0, 7)
+	case Month:
+		return start.AddDate(0, 1, 0)
+	}
+	return start
+}
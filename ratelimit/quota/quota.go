@@ -0,0 +1,474 @@
+// Package quota tracks long-horizon usage per key, such as the requests of
+// an API key per day and per month, on top of the short-window limits of
+// package ratelimit. Usage is counted per calendar period; each period may
+// have a soft threshold, which reports a warning, and a hard one, beyond
+// which requests are rejected until the period ends:
+//
+//	q := quota.New(&quota.Config{
+//		Limits: []quota.Limit{
+//			{Period: quota.Day, Hard: 10000},
+//			{Period: quota.Month, Soft: 200000, Hard: 250000},
+//		},
+//		OnThreshold: func(e quota.Event) {
+//			log.Printf("%s reached its %s %s quota of %d", e.Key, e.Period, e.Threshold, e.Limit)
+//		},
+//	})
+//
+// A Quota is a ratelimit.Limiter per key through Limiter, so it plugs into
+// ratelimit.Middleware:
+//
+//	config := ratelimit.DefaultMiddlewareConfig()
+//	config.KeyedLimiterFactory = q.Limiter
+//
+// Counters live in memory and can be persisted with Snapshot and Restore.
+// Like the limiters of package ratelimit, a Quota needs no goroutine:
+// periods roll over on the first call after they end.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// Limit is the quota of a key for one period.
+type Limit struct {
+	Period Period `json:"period"`
+
+	// Soft is the usage at which OnThreshold reports that the key is
+	// close to its quota. Requests stay admitted. Zero disables it.
+	Soft int64 `json:"soft,omitempty"`
+
+	// Hard is the usage the key may not exceed: requests that would take
+	// it beyond are rejected until the period ends. Zero disables it.
+	Hard int64 `json:"hard,omitempty"`
+}
+
+// Threshold tells which threshold of a Limit an Event reports.
+type Threshold int
+
+const (
+	Soft Threshold = iota + 1
+	Hard
+)
+
+// String returns "soft" or "hard".
+func (t Threshold) String() string {
+	switch t {
+	case Soft:
+		return "soft"
+	case Hard:
+		return "hard"
+	}
+	return "unknown"
+}
+
+// Event reports that the usage of a key reached a threshold.
+type Event struct {
+	Key       string
+	Period    Period
+	Threshold Threshold
+
+	// Limit is the value of the threshold, and Used the usage that
+	// reached it.
+	Limit int64
+	Used  int64
+
+	// ResetAt is when the period ends and its usage starts over.
+	ResetAt time.Time
+}
+
+// Usage describes the usage of a key for one of its limits.
+type Usage struct {
+	Period Period `json:"period"`
+	Used   int64  `json:"used"`
+	Soft   int64  `json:"soft,omitempty"`
+	Hard   int64  `json:"hard,omitempty"`
+
+	// Remaining is Hard minus Used, or -1 if there is no hard limit.
+	Remaining int64 `json:"remaining"`
+
+	// ResetAt is when the period ends.
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// Config configures a Quota.
+type Config struct {
+	// Limits are the limits of every key.
+	Limits []Limit
+
+	// LimitsFor, if set, returns the limits of a key, such as those of
+	// its plan, instead of Limits. It is called on every request, so plan
+	// changes take effect at once, without losing the usage counted.
+	LimitsFor func(key string) []Limit
+
+	// OnThreshold, if set, is called when the usage of a key reaches a
+	// soft or hard threshold, once per period. It is called synchronously
+	// by the call that recorded the usage, so it should return quickly.
+	OnThreshold func(Event)
+
+	// Location is where calendar periods start, such as midnight of a
+	// day. Defaults to UTC.
+	Location *time.Location
+
+	// Clock is the time source. Defaults to ratelimit.SystemClock.
+	Clock ratelimit.Clock
+}
+
+// sweepInterval is how often keys whose every period has ended are
+// dropped.
+const sweepInterval = time.Hour
+
+// Quota counts the usage of keys per hour, day, week and month and
+// enforces their limits. A Quota is safe for concurrent use.
+type Quota struct {
+	config Config
+
+	mu        sync.Mutex
+	keys      map[string]*counters
+	lastSweep time.Time
+}
+
+// counters are the usage of a key in the current period of each of
+// periods.
+type counters [len(periods)]counter
+
+type counter struct {
+	start time.Time
+	used  int64
+}
+
+// New returns a quota configured by config.
+func New(config *Config) *Quota {
+	q := &Quota{config: *config, keys: make(map[string]*counters)}
+	if q.config.Location == nil {
+		q.config.Location = time.UTC
+	}
+	if q.config.Clock == nil {
+		q.config.Clock = ratelimit.SystemClock{}
+	}
+	q.lastSweep = q.config.Clock.Now()
+	return q
+}
+
+// limits returns the limits of key.
+func (q *Quota) limits(key string) []Limit {
+	if q.config.LimitsFor != nil {
+		return q.config.LimitsFor(key)
+	}
+	return q.config.Limits
+}
+
+// counters returns the counters of key rolled over to now, creating them
+// if create is set, or nil. The caller must hold q.mu.
+func (q *Quota) counters(key string, now time.Time, create bool) *counters {
+	if now.Sub(q.lastSweep) >= sweepInterval {
+		q.sweep(now)
+	}
+
+	c, ok := q.keys[key]
+	if !ok {
+		if !create {
+			return nil
+		}
+		c = &counters{}
+		q.keys[key] = c
+	}
+	for i, p := range periods {
+		if start := p.Start(now, q.config.Location); !c[i].start.Equal(start) {
+			c[i] = counter{start: start}
+		}
+	}
+	return c
+}
+
+// sweep drops the keys whose every period has ended. The caller must hold
+// q.mu.
+func (q *Quota) sweep(now time.Time) {
+	q.lastSweep = now
+	for key, c := range q.keys {
+		expired := true
+		for i, p := range periods {
+			if c[i].used > 0 && p.End(c[i].start).After(now) {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			delete(q.keys, key)
+		}
+	}
+}
+
+// Allow records one unit of usage for key if it is within every hard
+// limit, and reports whether it did.
+func (q *Quota) Allow(key string) bool {
+	return q.AllowN(key, 1)
+}
+
+// AllowN records n units of usage for key if they are within every hard
+// limit, and reports whether it did. Rejected usage is not recorded.
+func (q *Quota) AllowN(key string, n int) bool {
+	limits := q.limits(key)
+	now := q.config.Clock.Now()
+
+	q.mu.Lock()
+	c := q.counters(key, now, true)
+	if q.limited(c, limits, int64(n), now) != nil {
+		q.mu.Unlock()
+		return false
+	}
+	events := q.add(key, c, limits, int64(n))
+	q.mu.Unlock()
+
+	q.emit(events)
+	return true
+}
+
+// Add records n units of usage for key whatever its limits, for usage
+// known only after the fact, such as the tokens a request consumed. The
+// usage may go beyond a hard limit, and later requests are rejected until
+// the period ends.
+func (q *Quota) Add(key string, n int64) {
+	limits := q.limits(key)
+	now := q.config.Clock.Now()
+
+	q.mu.Lock()
+	events := q.add(key, q.counters(key, now, true), limits, n)
+	q.mu.Unlock()
+
+	q.emit(events)
+}
+
+// add records n units in c and returns the events of the thresholds of
+// limits it reaches. The caller must hold q.mu.
+func (q *Quota) add(key string, c *counters, limits []Limit, n int64) []Event {
+	var events []Event
+	for _, l := range limits {
+		i := l.Period.index()
+		if i < 0 {
+			continue
+		}
+		before, after := c[i].used, c[i].used+n
+		for _, t := range [...]struct {
+			threshold Threshold
+			limit     int64
+		}{{Soft, l.Soft}, {Hard, l.Hard}} {
+			if t.limit > 0 && before < t.limit && after >= t.limit {
+				events = append(events, Event{
+					Key:       key,
+					Period:    l.Period,
+					Threshold: t.threshold,
+					Limit:     t.limit,
+					Used:      after,
+					ResetAt:   l.Period.End(c[i].start),
+				})
+			}
+		}
+	}
+	for i := range c {
+		c[i].used += n
+	}
+	return events
+}
+
+// emit calls OnThreshold with events.
+func (q *Quota) emit(events []Event) {
+	if q.config.OnThreshold == nil {
+		return
+	}
+	for _, e := range events {
+		q.config.OnThreshold(e)
+	}
+}
+
+// limited describes why n more units would take c beyond a hard limit,
+// or returns nil if they would not. When several limits are exceeded, it
+// describes the one that resets last. The caller must hold q.mu.
+func (q *Quota) limited(c *counters, limits []Limit, n int64, now time.Time) *ratelimit.ErrLimited {
+	var limited *ratelimit.ErrLimited
+	for _, l := range limits {
+		i := l.Period.index()
+		if i < 0 || l.Hard <= 0 || c[i].used+n <= l.Hard {
+			continue
+		}
+		retryAfter := l.Period.End(c[i].start).Sub(now)
+		if limited == nil || retryAfter > limited.RetryAfter {
+			limited = &ratelimit.ErrLimited{
+				RetryAfter: retryAfter,
+				Limit:      int(l.Hard),
+				Remaining:  int(max(l.Hard-c[i].used, 0)),
+			}
+		}
+	}
+	return limited
+}
+
+// Check returns nil if n units of usage for key are within every hard
+// limit, otherwise a *ratelimit.ErrLimited whose RetryAfter is when the
+// exhausted period ends. It records nothing.
+func (q *Quota) Check(key string, n int) error {
+	limits := q.limits(key)
+	now := q.config.Clock.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := q.counters(key, now, false)
+	if c == nil {
+		c = &counters{}
+	}
+	if limited := q.limited(c, limits, int64(n), now); limited != nil {
+		return limited
+	}
+	return nil
+}
+
+// Usage returns the usage of key for each of its limits.
+func (q *Quota) Usage(key string) []Usage {
+	limits := q.limits(key)
+	now := q.config.Clock.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := q.counters(key, now, false)
+	usage := make([]Usage, 0, len(limits))
+	for _, l := range limits {
+		i := l.Period.index()
+		if i < 0 {
+			continue
+		}
+		u := Usage{
+			Period:    l.Period,
+			Soft:      l.Soft,
+			Hard:      l.Hard,
+			Remaining: -1,
+			ResetAt:   l.Period.End(l.Period.Start(now, q.config.Location)),
+		}
+		if c != nil {
+			u.Used = c[i].used
+		}
+		if l.Hard > 0 {
+			u.Remaining = max(l.Hard-u.Used, 0)
+		}
+		usage = append(usage, u)
+	}
+	return usage
+}
+
+// Used returns the usage of key in the current period p, whether or not
+// the key has a limit for p.
+func (q *Quota) Used(key string, p Period) int64 {
+	i := p.index()
+	if i < 0 {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := q.counters(key, q.config.Clock.Now(), false)
+	if c == nil {
+		return 0
+	}
+	return c[i].used
+}
+
+// Remaining returns the usage key may still record before reaching one of
+// its hard limits, or -1 if it has none.
+func (q *Quota) Remaining(key string) int64 {
+	remaining := int64(-1)
+	for _, u := range q.Usage(key) {
+		if u.Remaining >= 0 && (remaining < 0 || u.Remaining < remaining) {
+			remaining = u.Remaining
+		}
+	}
+	return remaining
+}
+
+// Reset forgets the usage of key.
+func (q *Quota) Reset(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.keys, key)
+}
+
+// Keys returns the keys with usage in a current period, sorted.
+func (q *Quota) Keys() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sweep(q.config.Clock.Now())
+	keys := make([]string, 0, len(q.keys))
+	for key := range q.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// State is the persisted state of a Quota.
+type State struct {
+	Keys map[string][]CounterState `json:"keys"`
+}
+
+// CounterState is the persisted usage of a key in one period.
+type CounterState struct {
+	Period Period    `json:"period"`
+	Start  time.Time `json:"start"`
+	Used   int64     `json:"used"`
+}
+
+// Snapshot encodes the usage of every key as JSON, so that it survives a
+// restart.
+func (q *Quota) Snapshot() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sweep(q.config.Clock.Now())
+	state := State{Keys: make(map[string][]CounterState, len(q.keys))}
+	for key, c := range q.keys {
+		var periodStates []CounterState
+		for i, p := range periods {
+			if c[i].used > 0 {
+				periodStates = append(periodStates, CounterState{Period: p, Start: c[i].start, Used: c[i].used})
+			}
+		}
+		state.Keys[key] = periodStates
+	}
+	return json.Marshal(state)
+}
+
+// Restore replaces the usage of every key with a snapshot. Counters of
+// periods that have ended since are dropped as the keys are next used.
+func (q *Quota) Restore(data []byte) error {
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("quota: decode snapshot: %w", err)
+	}
+
+	keys := make(map[string]*counters, len(state.Keys))
+	for key, periodStates := range state.Keys {
+		c := &counters{}
+		for _, s := range periodStates {
+			i := s.Period.index()
+			if i < 0 {
+				return fmt.Errorf("quota: snapshot of %q has unknown period %d", key, int(s.Period))
+			}
+			c[i] = counter{start: s.Start, used: s.Used}
+		}
+		keys[key] = c
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.keys = keys
+	return nil
+}
@@ -0,0 +1,214 @@
+package quota_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+	"github.com/rRateLimit/client/ratelimit/quota"
+)
+
+func TestPeriodStart(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	// Sunday 2026-10-18 23:30 UTC is Monday 2026-10-19 08:30 JST.
+	now := time.Date(2026, 10, 18, 23, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		period     quota.Period
+		loc        *time.Location
+		start, end time.Time
+	}{
+		{quota.Hour, time.UTC, time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{quota.Day, time.UTC, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{quota.Week, time.UTC, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{quota.Month, time.UTC, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{quota.Day, jst, time.Date(2026, 10, 19, 0, 0, 0, 0, jst), time.Date(2026, 10, 20, 0, 0, 0, 0, jst)},
+		{quota.Week, jst, time.Date(2026, 10, 19, 0, 0, 0, 0, jst), time.Date(2026, 10, 26, 0, 0, 0, 0, jst)},
+	}
+	for _, tt := range tests {
+		start := tt.period.Start(now, tt.loc)
+		if !start.Equal(tt.start) {
+			t.Errorf("%s in %s: Start = %s, want %s", tt.period, tt.loc, start, tt.start)
+		}
+		if end := tt.period.End(start); !end.Equal(tt.end) {
+			t.Errorf("%s in %s: End = %s, want %s", tt.period, tt.loc, end, tt.end)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	for _, p := range []quota.Period{quota.Hour, quota.Day, quota.Week, quota.Month} {
+		if got, err := quota.ParsePeriod(p.String()); got != p || err != nil {
+			t.Errorf("ParsePeriod(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := quota.ParsePeriod("year"); err == nil {
+		t.Error("ParsePeriod(year) succeeded")
+	}
+}
+
+func TestQuotaHardLimitResetsWithPeriod(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC))
+	q := quota.New(&quota.Config{
+		Limits: []quota.Limit{{Period: quota.Day, Hard: 3}},
+		Clock:  clock,
+	})
+
+	for i := 0; i < 3; i++ {
+		if !q.Allow("k") {
+			t.Fatalf("request %d rejected", i+1)
+		}
+	}
+	if q.Allow("k") {
+		t.Fatal("request beyond the hard limit admitted")
+	}
+	if q.AllowN("other", 4) {
+		t.Error("AllowN beyond the hard limit admitted")
+	}
+	if got := q.Used("other", quota.Day); got != 0 {
+		t.Errorf("rejected usage recorded: Used = %d", got)
+	}
+
+	var limited *ratelimit.ErrLimited
+	if err := q.Check("k", 1); !errors.As(err, &limited) || limited.RetryAfter != time.Hour || limited.Limit != 3 || limited.Remaining != 0 {
+		t.Fatalf("Check = %v, want ErrLimited until midnight", err)
+	}
+	if got := q.Remaining("k"); got != 0 {
+		t.Errorf("Remaining = %d, want 0", got)
+	}
+
+	clock.Advance(time.Hour)
+	if !q.Allow("k") {
+		t.Error("request rejected after the day ended")
+	}
+	if got := q.Remaining("k"); got != 2 {
+		t.Errorf("Remaining = %d, want 2", got)
+	}
+}
+
+func TestQuotaAddGoesBeyondHardLimit(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	q := quota.New(&quota.Config{
+		Limits: []quota.Limit{{Period: quota.Hour, Hard: 10}},
+		Clock:  clock,
+	})
+
+	q.Add("k", 25)
+	if got := q.Used("k", quota.Hour); got != 25 {
+		t.Errorf("Used = %d, want 25", got)
+	}
+	if q.Allow("k") {
+		t.Error("request admitted beyond the hard limit")
+	}
+	if u := q.Usage("k"); len(u) != 1 || u[0].Remaining != 0 {
+		t.Errorf("Usage = %+v, want one limit with nothing remaining", u)
+	}
+}
+
+func TestQuotaReportsThresholdsOncePerPeriod(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 31, 23, 0, 0, 0, time.UTC))
+	var events []quota.Event
+	q := quota.New(&quota.Config{
+		Limits:      []quota.Limit{{Period: quota.Month, Soft: 2, Hard: 3}},
+		OnThreshold: func(e quota.Event) { events = append(events, e) },
+		Clock:       clock,
+	})
+
+	for i := 0; i < 5; i++ {
+		q.Allow("k")
+	}
+	want := []quota.Threshold{quota.Soft, quota.Hard}
+	if len(events) != len(want) {
+		t.Fatalf("%d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if e.Threshold != want[i] || e.Key != "k" || e.Period != quota.Month || !e.ResetAt.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("event %d = %+v", i, e)
+		}
+	}
+
+	clock.Advance(time.Hour)
+	events = nil
+	q.AllowN("k", 2)
+	if len(events) != 1 || events[0].Threshold != quota.Soft {
+		t.Errorf("events in the next month = %+v, want the soft threshold again", events)
+	}
+}
+
+func TestQuotaLimitsFor(t *testing.T) {
+	plans := map[string]int64{"free": 1, "pro": 3}
+	q := quota.New(&quota.Config{
+		LimitsFor: func(key string) []quota.Limit {
+			return []quota.Limit{{Period: quota.Day, Hard: plans[key]}}
+		},
+		Clock: clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)),
+	})
+
+	if !q.Allow("free") || q.Allow("free") {
+		t.Error("free plan did not admit exactly 1 request")
+	}
+	if !q.AllowN("pro", 3) || q.Allow("pro") {
+		t.Error("pro plan did not admit exactly 3 requests")
+	}
+}
+
+func TestQuotaSnapshotRestore(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	config := &quota.Config{
+		Limits: []quota.Limit{{Period: quota.Day, Hard: 5}, {Period: quota.Month, Hard: 100}},
+		Clock:  clock,
+	}
+	q := quota.New(config)
+	q.AllowN("a", 4)
+	q.AllowN("b", 1)
+
+	data, err := q.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := quota.New(config)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Keys(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Keys = %v, want [a b]", got)
+	}
+	if got := restored.Remaining("a"); got != 1 {
+		t.Errorf("Remaining(a) = %d, want 1", got)
+	}
+
+	// The day ends, the month does not.
+	clock.Advance(12 * time.Hour)
+	if got, want := restored.Used("a", quota.Day), int64(0); got != want {
+		t.Errorf("Used(a, day) = %d, want %d", got, want)
+	}
+	if got, want := restored.Used("a", quota.Month), int64(4); got != want {
+		t.Errorf("Used(a, month) = %d, want %d", got, want)
+	}
+
+	if err := restored.Restore([]byte(`{"keys": {"a": [{"period": "year", "used": 1}]}}`)); err == nil {
+		t.Error("Restore accepted an unknown period")
+	}
+}
+
+func TestQuotaLimiter(t *testing.T) {
+	q := quota.New(&quota.Config{
+		Limits: []quota.Limit{{Period: quota.Hour, Hard: 2}},
+		Clock:  clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)),
+	})
+	l := q.Limiter("k")
+
+	if !l.Allow() || !l.Allow() || l.Allow() {
+		t.Error("limiter did not admit exactly 2 requests")
+	}
+	var limited *ratelimit.ErrLimited
+	if err := l.(ratelimit.Checker).Check(); !errors.As(err, &limited) || limited.RetryAfter != 30*time.Minute {
+		t.Errorf("Check = %v, want ErrLimited for 30m", err)
+	}
+	l.Reset()
+	if got := q.Used("k", quota.Hour); got != 0 {
+		t.Errorf("Used after Reset = %d", got)
+	}
+}
@@ -0,0 +1,183 @@
+// Package ratelimittest provides helpers for testing Limiter
+// implementations and the code that uses them.
+package ratelimittest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+// Spec describes the limits a Limiter implementation promises, for
+// TestLimiter.
+type Spec struct {
+	// New creates the limiter under test. It must take all times from
+	// clock, for example through ratelimit.WithClock.
+	New func(clock ratelimit.Clock) ratelimit.Limiter
+
+	// Capacity is Available of a new or reset limiter, all of which
+	// AllowN must admit at once: Burst for a token bucket, Rate for the
+	// windowed limiters.
+	Capacity int
+
+	// Limit is the most units the limiter may admit within any interval
+	// of Period: Burst plus Rate for a token bucket, twice Rate for a
+	// fixed window, which admits a full window on both sides of a
+	// boundary, and Rate for a sliding window.
+	Limit  int
+	Period time.Duration
+
+	// Steps is the number of random operations. Defaults to 1000.
+	Steps int
+
+	// Seed seeds the operations; a failure reports the seed to replay.
+	Seed int64
+}
+
+// TestLimiter checks that the limiter created by spec.New keeps the
+// invariants of the ratelimit.Limiter contract over a random sequence of
+// calls in virtual time:
+//
+//   - a new limiter has Capacity available and admits all of it at once
+//   - Available is never negative
+//   - no interval of Period admits more than Limit units
+//   - Reset restores the full Capacity
+//   - WaitN with a context that is already done fails and consumes nothing
+//
+// It returns an error describing the first violation, or nil. Calls are
+// made from a single goroutine.
+func TestLimiter(spec Spec) error {
+	if spec.New == nil || spec.Capacity <= 0 || spec.Limit < spec.Capacity || spec.Period <= 0 {
+		return errors.New("ratelimittest: Spec needs New, Capacity, Limit of at least Capacity and Period")
+	}
+	steps := spec.Steps
+	if steps <= 0 {
+		steps = 1000
+	}
+
+	c := &checker{spec: spec, clock: clocktest.NewFakeClock(time.Time{}), rng: rand.New(rand.NewSource(spec.Seed))}
+	c.limiter = spec.New(c.clock)
+	if err := c.checkFull("new limiter"); err != nil {
+		return c.fail(0, err)
+	}
+
+	for step := 1; step <= steps; step++ {
+		if err := c.step(); err != nil {
+			return c.fail(step, err)
+		}
+	}
+	return nil
+}
+
+// Conformance runs TestLimiter and fails t on a violation.
+func Conformance(t testing.TB, spec Spec) {
+	t.Helper()
+	if err := TestLimiter(spec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// admission is a successful AllowN.
+type admission struct {
+	at time.Time
+	n  int
+}
+
+// checker runs the operations of TestLimiter.
+type checker struct {
+	spec    Spec
+	clock   *clocktest.FakeClock
+	rng     *rand.Rand
+	limiter ratelimit.Limiter
+
+	admitted []admission // within the last Period
+}
+
+// fail wraps err with the step and seed that reproduce it.
+func (c *checker) fail(step int, err error) error {
+	return fmt.Errorf("ratelimittest: step %d (seed %d): %w", step, c.spec.Seed, err)
+}
+
+// step advances the clock by a random amount and makes a random call.
+func (c *checker) step() error {
+	// Mostly about the time one unit takes, sometimes a whole period
+	unit := c.spec.Period / time.Duration(c.spec.Capacity)
+	if c.rng.Intn(10) == 0 {
+		c.clock.Advance(time.Duration(c.rng.Int63n(int64(c.spec.Period) + 1)))
+	} else {
+		c.clock.Advance(time.Duration(c.rng.Int63n(2*int64(unit) + 1)))
+	}
+
+	switch op := c.rng.Intn(100); {
+	case op < 70:
+		return c.allow(1 + c.rng.Intn(min(3, c.spec.Capacity)))
+	case op < 90:
+		if n := c.limiter.Available(); n < 0 {
+			return fmt.Errorf("Available() = %d, want >= 0", n)
+		}
+		return nil
+	case op < 95:
+		c.limiter.Reset()
+		c.admitted = nil
+		return c.checkFull("after Reset")
+	default:
+		return c.cancelledWait()
+	}
+}
+
+// allow calls AllowN(n) and checks the admissions of the last Period.
+func (c *checker) allow(n int) error {
+	if !c.limiter.AllowN(n) {
+		return nil
+	}
+	now := c.clock.Now()
+	c.admitted = append(c.admitted, admission{at: now, n: n})
+
+	total, keep := 0, 0
+	for i, a := range c.admitted {
+		if !a.at.After(now.Add(-c.spec.Period)) {
+			keep = i + 1
+			continue
+		}
+		total += a.n
+	}
+	c.admitted = c.admitted[keep:]
+	if total > c.spec.Limit {
+		return fmt.Errorf("admitted %d units within %s, want at most %d", total, c.spec.Period, c.spec.Limit)
+	}
+	return nil
+}
+
+// checkFull checks that the limiter has its full capacity and admits it
+// at once.
+func (c *checker) checkFull(when string) error {
+	if n := c.limiter.Available(); n != c.spec.Capacity {
+		return fmt.Errorf("%s: Available() = %d, want %d", when, n, c.spec.Capacity)
+	}
+	if !c.limiter.AllowN(c.spec.Capacity) {
+		return fmt.Errorf("%s: AllowN(%d) = false, want true", when, c.spec.Capacity)
+	}
+	c.admitted = append(c.admitted, admission{at: c.clock.Now(), n: c.spec.Capacity})
+	return nil
+}
+
+// cancelledWait checks that WaitN with a cancelled context fails without
+// consuming capacity.
+func (c *checker) cancelledWait() error {
+	before := c.limiter.Available()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.limiter.WaitN(ctx, 1); err == nil {
+		return errors.New("WaitN with a cancelled context = nil, want an error")
+	}
+	if after := c.limiter.Available(); after < before {
+		return fmt.Errorf("WaitN with a cancelled context consumed %d units", before-after)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package ratelimittest
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// AlwaysAllow is a Limiter that admits every request, for testing the
+// admitted branch of code that uses a Limiter.
+type AlwaysAllow struct{}
+
+// Allow returns true.
+func (AlwaysAllow) Allow() bool { return true }
+
+// AllowN returns true.
+func (AlwaysAllow) AllowN(n int) bool { return true }
+
+// Wait returns at once, failing only if ctx is already done.
+func (a AlwaysAllow) Wait(ctx context.Context) error { return a.WaitN(ctx, 1) }
+
+// WaitN returns at once, failing only if ctx is already done.
+func (AlwaysAllow) WaitN(ctx context.Context, n int) error { return ctx.Err() }
+
+// Reset does nothing.
+func (AlwaysAllow) Reset() {}
+
+// Available returns math.MaxInt.
+func (AlwaysAllow) Available() int { return math.MaxInt }
+
+// Check returns nil.
+func (AlwaysAllow) Check() error { return nil }
+
+// CheckN returns nil.
+func (AlwaysAllow) CheckN(n int) error { return nil }
+
+// AlwaysDeny is a Limiter that rejects every request, for testing the
+// rejected branch of code that uses a Limiter. Its errors report
+// RetryAfter, so that a Middleware sets Retry-After from it.
+type AlwaysDeny struct {
+	RetryAfter time.Duration
+}
+
+// Allow returns false.
+func (AlwaysDeny) Allow() bool { return false }
+
+// AllowN returns false.
+func (AlwaysDeny) AllowN(n int) bool { return false }
+
+// Wait fails at once; see WaitN.
+func (d AlwaysDeny) Wait(ctx context.Context) error { return d.WaitN(ctx, 1) }
+
+// WaitN fails at once rather than blocking until ctx is done: with ctx's
+// error if it is already done, and otherwise with an *ErrLimited wrapping
+// context.DeadlineExceeded, as a real limiter returns when the deadline
+// passes.
+func (d AlwaysDeny) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.limited(context.DeadlineExceeded)
+}
+
+// Reset does nothing.
+func (AlwaysDeny) Reset() {}
+
+// Available returns 0.
+func (AlwaysDeny) Available() int { return 0 }
+
+// Check returns an *ErrLimited.
+func (d AlwaysDeny) Check() error { return d.CheckN(1) }
+
+// CheckN returns an *ErrLimited.
+func (d AlwaysDeny) CheckN(n int) error { return d.limited(nil) }
+
+func (d AlwaysDeny) limited(err error) error {
+	return &ratelimit.ErrLimited{RetryAfter: d.RetryAfter, Err: err}
+}
+
+// SequenceLimiter is a Limiter whose decisions are scripted. Each call to
+// Allow, AllowN, Wait or WaitN takes the next decision; once the script
+// runs out, the last decision repeats, and an empty script admits
+// everything. Denied waits fail at once like those of AlwaysDeny, so
+// tests never sleep. It is safe for concurrent use and records its calls.
+type SequenceLimiter struct {
+	// RetryAfter is reported by the errors of denied waits.
+	RetryAfter time.Duration
+
+	mu        sync.Mutex
+	decisions []bool
+	next      int
+	calls     []int
+	resets    int
+}
+
+// NewSequenceLimiter returns a SequenceLimiter that admits or rejects
+// requests in the order of decisions.
+func NewSequenceLimiter(decisions ...bool) *SequenceLimiter {
+	return &SequenceLimiter{decisions: decisions}
+}
+
+// Allow takes the next decision.
+func (s *SequenceLimiter) Allow() bool {
+	return s.AllowN(1)
+}
+
+// AllowN takes the next decision, whatever n is.
+func (s *SequenceLimiter) AllowN(n int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, n)
+	decision := s.peek()
+	s.next++
+	return decision
+}
+
+// Wait takes the next decision; see WaitN.
+func (s *SequenceLimiter) Wait(ctx context.Context) error {
+	return s.WaitN(ctx, 1)
+}
+
+// WaitN takes the next decision, returning nil if it admits and an
+// *ErrLimited wrapping context.DeadlineExceeded otherwise. A context that
+// is already done fails with its error without taking a decision.
+func (s *SequenceLimiter) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.AllowN(n) {
+		return nil
+	}
+	return &ratelimit.ErrLimited{RetryAfter: s.RetryAfter, Err: context.DeadlineExceeded}
+}
+
+// Reset counts the call; it does not restart the script.
+func (s *SequenceLimiter) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resets++
+}
+
+// Available returns 1 if the next decision admits and 0 otherwise,
+// without taking it.
+func (s *SequenceLimiter) Available() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.peek() {
+		return 1
+	}
+	return 0
+}
+
+// Calls returns the n of every decision taken so far, in order.
+func (s *SequenceLimiter) Calls() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int(nil), s.calls...)
+}
+
+// Resets returns the number of calls to Reset.
+func (s *SequenceLimiter) Resets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resets
+}
+
+// peek returns the next decision.
+func (s *SequenceLimiter) peek() bool {
+	switch {
+	case len(s.decisions) == 0:
+		return true
+	case s.next < len(s.decisions):
+		return s.decisions[s.next]
+	default:
+		return s.decisions[len(s.decisions)-1]
+	}
+}
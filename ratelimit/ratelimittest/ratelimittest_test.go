@@ -0,0 +1,146 @@
+package ratelimittest_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/ratelimittest"
+)
+
+func TestConformance(t *testing.T) {
+	const rate = 10
+	tests := []struct {
+		name  string
+		new   func(...ratelimit.Option) ratelimit.Limiter
+		limit int
+	}{
+		{"token bucket", func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewTokenBucket(opts...) }, 2 * rate},
+		{"fixed window", func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewFixedWindow(opts...) }, 2 * rate},
+		{"sliding window", func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewSlidingWindow(opts...) }, rate},
+		{"sliding log", func(opts ...ratelimit.Option) ratelimit.Limiter { return ratelimit.NewSlidingLog(opts...) }, rate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(0); seed < 5; seed++ {
+				ratelimittest.Conformance(t, ratelimittest.Spec{
+					New: func(clock ratelimit.Clock) ratelimit.Limiter {
+						return tt.new(ratelimit.WithRate(rate), ratelimit.WithBurst(rate), ratelimit.WithClock(clock))
+					},
+					Capacity: rate,
+					Limit:    tt.limit,
+					Period:   time.Second,
+					Seed:     seed,
+				})
+			}
+		})
+	}
+}
+
+func TestTestLimiterReportsViolations(t *testing.T) {
+	tokenBucket := func(clock ratelimit.Clock) ratelimit.Limiter {
+		return ratelimit.NewTokenBucket(ratelimit.WithRate(10), ratelimit.WithBurst(10), ratelimit.WithClock(clock))
+	}
+	tests := []struct {
+		name string
+		spec ratelimittest.Spec
+		want string
+	}{
+		{"invalid spec", ratelimittest.Spec{New: tokenBucket, Capacity: 10, Limit: 5, Period: time.Second}, "Spec needs"},
+		{"wrong capacity", ratelimittest.Spec{New: tokenBucket, Capacity: 5, Limit: 20, Period: time.Second}, "step 0 (seed 0): new limiter: Available() = 10, want 5"},
+		{"unlimited", ratelimittest.Spec{
+			New:      func(ratelimit.Clock) ratelimit.Limiter { return ratelimittest.AlwaysAllow{} },
+			Capacity: 1, Limit: 1, Period: time.Second,
+		}, "new limiter: Available()"},
+		// A token bucket refills during the period, so its limit is
+		// burst plus rate.
+		{"over the limit", ratelimittest.Spec{New: tokenBucket, Capacity: 10, Limit: 10, Period: time.Second}, "units within 1s, want at most 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ratelimittest.TestLimiter(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("TestLimiter = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlwaysAllow(t *testing.T) {
+	var l ratelimit.Limiter = ratelimittest.AlwaysAllow{}
+	if !l.Allow() || !l.AllowN(1000) || l.Wait(context.Background()) != nil {
+		t.Error("AlwaysAllow rejected a request")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WaitN(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitN with a cancelled context = %v, want Canceled", err)
+	}
+}
+
+func TestAlwaysDeny(t *testing.T) {
+	d := ratelimittest.AlwaysDeny{RetryAfter: time.Minute}
+	if d.Allow() || d.AllowN(1) || d.Available() != 0 {
+		t.Error("AlwaysDeny admitted a request")
+	}
+
+	var limited *ratelimit.ErrLimited
+	if err := d.Check(); !errors.As(err, &limited) || limited.RetryAfter != time.Minute {
+		t.Errorf("Check = %v, want an *ErrLimited with RetryAfter 1m", err)
+	}
+	err := d.Wait(context.Background())
+	if !errors.As(err, &limited) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v, want an *ErrLimited wrapping DeadlineExceeded", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.WaitN(ctx, 1); err != context.Canceled {
+		t.Errorf("WaitN with a cancelled context = %v, want Canceled", err)
+	}
+}
+
+func TestSequenceLimiter(t *testing.T) {
+	s := ratelimittest.NewSequenceLimiter(true, false, true, false)
+	s.RetryAfter = time.Second
+
+	if !s.Allow() || s.AllowN(3) {
+		t.Fatal("first decisions do not follow the script")
+	}
+	if s.Available() != 1 {
+		t.Errorf("Available before an admitting decision = %d, want 1", s.Available())
+	}
+	if err := s.WaitN(context.Background(), 2); err != nil {
+		t.Errorf("WaitN on an admitting decision = %v", err)
+	}
+	var limited *ratelimit.ErrLimited
+	if err := s.Wait(context.Background()); !errors.As(err, &limited) || limited.RetryAfter != time.Second {
+		t.Errorf("Wait on a rejecting decision = %v, want an *ErrLimited with RetryAfter 1s", err)
+	}
+	// The last decision repeats.
+	if s.Allow() || s.Allow() {
+		t.Error("decision after the script admitted, want the last one repeated")
+	}
+
+	// A done context takes no decision.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.WaitN(ctx, 5); err != context.Canceled {
+		t.Errorf("WaitN with a cancelled context = %v, want Canceled", err)
+	}
+	s.Reset()
+	s.Reset()
+	if got, want := s.Calls(), []int{1, 3, 2, 1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Calls = %v, want %v", got, want)
+	}
+	if s.Resets() != 2 {
+		t.Errorf("Resets = %d, want 2", s.Resets())
+	}
+
+	if empty := ratelimittest.NewSequenceLimiter(); !empty.Allow() || empty.Available() != 1 {
+		t.Error("an empty script rejected a request")
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Reconfigurer is implemented by limiters whose limits can be changed in
+// place, for example when a configuration file is reloaded. Unlike
+// replacing the limiter, this keeps the usage it has counted and the
+// callers blocked in Wait, which are re-evaluated against the new limits.
+// Blocked callers asking for more than the new limits admit at once wait
+// until their context is done.
+type Reconfigurer interface {
+	// Reconfigure sets the rate per period and, for TokenBucket, the
+	// burst size; a burst of zero means rate.
+	Reconfigure(rate int, period time.Duration, burst int)
+}
+
+// Reconfigure changes the bucket's limits. Tokens earned so far are
+// credited at the old rate, and the bucket is capped at the new burst
+// size; in warm-up mode the stored permits are carried over.
+func (tb *TokenBucket) Reconfigure(rate int, period time.Duration, burst int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.warmup == nil {
+		tb.refill()
+	}
+	if burst == 0 {
+		burst = rate
+	}
+	tb.config.Rate = rate
+	tb.config.Period = period
+	tb.config.Burst = burst
+	tb.refillAmount = float64(rate)
+	tb.refillPeriod = period
+	tb.tokens = min(tb.tokens, float64(burst))
+
+	if tb.warmup != nil {
+		now := tb.config.Clock.Now()
+		tb.warmup.resync(now)
+		old := tb.warmup
+		tb.warmup = newWarmup(tb.config, now)
+		tb.warmup.storedPermits = math.Min(old.storedPermits, tb.warmup.maxPermits)
+		tb.warmup.nextFree = old.nextFree
+	}
+	tb.waiters.notifyHead()
+}
+
+// Reconfigure changes the window's limits. The current window keeps its
+// count and start, and ends after the new period.
+func (fw *FixedWindow) Reconfigure(rate int, period time.Duration, burst int) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.resetIfNewWindow()
+	fw.config.Rate = rate
+	fw.config.Period = period
+}
+
+// Reconfigure changes the window's limits. Requests already recorded
+// count against the new rate for as long as they fall within the new
+// period.
+func (sw *SlidingWindow) Reconfigure(rate int, period time.Duration, burst int) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.config.Rate = rate
+	sw.config.Period = period
+	sw.waiters.notifyHead()
+}
+
+// Reconfigure changes the limits of every key. Logged requests count
+// against the new rate for as long as they fall within the new period;
+// the retention grows to the period if it is shorter. Bucketed keys keep
+// the bucket size of the original period.
+func (sl *SlidingLog) Reconfigure(rate int, period time.Duration, burst int) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.config.Rate = rate
+	sl.config.Period = period
+	if sl.config.Retention < period {
+		sl.config.Retention = period
+	}
+
+	// A longer period brings logged entries back into the window
+	windowStart := sl.windowStart(sl.config.Clock.Now())
+	for _, log := range sl.logs {
+		log.entries.rewindow(windowStart)
+	}
+}
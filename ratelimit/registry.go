@@ -0,0 +1,219 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownPolicy is returned by Registry for a name that is not
+// registered.
+var ErrUnknownPolicy = errors.New("ratelimit: unknown policy")
+
+// Registry manages the named limit policies of a service in one place.
+// Each policy keeps a limiter per key, so a call site only names the
+// policy and the key it limits:
+//
+//	registry := ratelimit.NewRegistry()
+//	registry.Register("search-api", func() ratelimit.Limiter {
+//		return ratelimit.NewTokenBucket(ratelimit.WithRate(100), ratelimit.WithPeriod(time.Minute))
+//	})
+//
+//	if !registry.Allow("search-api", userID) {
+//		// rejected
+//	}
+//
+// Policies are Middlewares, so a policy can also limit HTTP requests
+// through Policy(name).Handler, sharing its keys with the other call
+// sites, and every policy's keys, counters and state can be inspected with
+// Summary, StatsHandler or the Middleware methods.
+type Registry struct {
+	mu       sync.RWMutex
+	policies map[string]*Middleware
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{policies: make(map[string]*Middleware)}
+}
+
+// Register adds a policy whose keys get limiters from factory, with the
+// idle key cleanup of DefaultMiddlewareConfig. It fails if name is
+// already registered.
+func (r *Registry) Register(name string, factory func() Limiter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.policies[name]; ok {
+		return fmt.Errorf("ratelimit: policy %q already registered", name)
+	}
+	config := DefaultMiddlewareConfig()
+	config.LimiterFactory = factory
+	r.policies[name] = NewMiddleware(config)
+	return nil
+}
+
+// RegisterMiddleware adds m as the policy name, for control over its
+// configuration or to share the limiters of a Middleware that already
+// serves HTTP requests. It fails if name is already registered.
+func (r *Registry) RegisterMiddleware(name string, m *Middleware) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.policies[name]; ok {
+		return fmt.Errorf("ratelimit: policy %q already registered", name)
+	}
+	r.policies[name] = m
+	return nil
+}
+
+// Unregister removes the policy name and closes it. It reports whether
+// the policy was registered.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	m, ok := r.policies[name]
+	delete(r.policies, name)
+	r.mu.Unlock()
+
+	if ok {
+		m.Close()
+	}
+	return ok
+}
+
+// Policy returns the policy name, or nil if it is not registered.
+func (r *Registry) Policy(name string) *Middleware {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.policies[name]
+}
+
+// Names returns the names of the registered policies in order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.policies))
+	for name := range r.policies {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Limiter returns the limiter of key under the policy name, creating it
+// if needed, or ErrUnknownPolicy.
+func (r *Registry) Limiter(name, key string) (Limiter, error) {
+	m := r.Policy(name)
+	if m == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPolicy, name)
+	}
+	return m.getLimiter(key), nil
+}
+
+// Allow reports whether one request for key is allowed by the policy
+// name. Requests for a policy that is not registered are not allowed.
+func (r *Registry) Allow(name, key string) bool {
+	return r.AllowN(name, key, 1)
+}
+
+// AllowN reports whether n requests for key are allowed by the policy
+// name, and records them if so. The outcome is counted in the policy's
+// Counters. Requests for a blocked key are not allowed, and those for an
+// exempt key are allowed without being charged.
+func (r *Registry) AllowN(name, key string, n int) bool {
+	m := r.Policy(name)
+	if m == nil {
+		return false
+	}
+	o, d := m.override(key)
+	if d > 0 && o.exempt {
+		atomic.AddInt64(&m.counters.allowed, 1)
+		return true
+	}
+	if d > 0 || !m.getLimiter(key).AllowN(n) {
+		m.countLimited(nil, RateLimitInfo{Key: key})
+		return false
+	}
+	atomic.AddInt64(&m.counters.allowed, 1)
+	return true
+}
+
+// Wait blocks until one request for key is allowed by the policy name or
+// ctx is done.
+func (r *Registry) Wait(ctx context.Context, name, key string) error {
+	return r.WaitN(ctx, name, key, 1)
+}
+
+// WaitN blocks until n requests for key are allowed by the policy name or
+// ctx is done. A wait that fails is counted as rate limited. For a
+// blocked key it fails at once with an *ErrLimited whose RetryAfter is
+// the time left, and for an exempt key it succeeds at once.
+func (r *Registry) WaitN(ctx context.Context, name, key string, n int) error {
+	m := r.Policy(name)
+	if m == nil {
+		return fmt.Errorf("%w %q", ErrUnknownPolicy, name)
+	}
+	if o, d := m.override(key); d > 0 {
+		if o.exempt {
+			atomic.AddInt64(&m.counters.allowed, 1)
+			return nil
+		}
+		m.countLimited(nil, RateLimitInfo{Key: key, RetryAfter: d})
+		return &ErrLimited{RetryAfter: d}
+	}
+	if err := m.getLimiter(key).WaitN(ctx, n); err != nil {
+		m.countLimited(nil, rateLimitInfo(key, err))
+		return err
+	}
+	atomic.AddInt64(&m.counters.allowed, 1)
+	return nil
+}
+
+// Summary returns the statistics of every policy, with up to top
+// offenders each, as described on Middleware.Summary.
+func (r *Registry) Summary(top int, withKeys bool) map[string]MiddlewareStats {
+	r.mu.RLock()
+	policies := make(map[string]*Middleware, len(r.policies))
+	for name, m := range r.policies {
+		policies[name] = m
+	}
+	r.mu.RUnlock()
+
+	stats := make(map[string]MiddlewareStats, len(policies))
+	for name, m := range policies {
+		stats[name] = m.Summary(top, withKeys)
+	}
+	return stats
+}
+
+// StatsHandler serves Summary as JSON, keyed by policy name, with the
+// query parameters of Middleware.StatsHandler. Mount it behind
+// authentication, since keys may identify clients.
+func (r *Registry) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		top, withKeys, ok := statsQuery(w, req)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.Summary(top, withKeys))
+	})
+}
+
+// Close closes every policy, stopping their cleanup goroutines.
+func (r *Registry) Close() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, m := range r.policies {
+		m.Close()
+	}
+}
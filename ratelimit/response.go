@@ -0,0 +1,232 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitInfo describes why a request was rate limited. Middleware stores
+// it in the request context before calling OnRateLimited.
+type RateLimitInfo struct {
+	// Key is the limiter key of the request.
+	Key string
+
+	// Limit is the number of requests allowed per period, or 0 if the
+	// limiter does not implement Checker.
+	Limit int
+
+	// Remaining is the budget left for the key.
+	Remaining int
+
+	// RetryAfter is how long until the request could be admitted, or 0 if
+	// unknown.
+	RetryAfter time.Duration
+}
+
+// rateLimitInfoKey is the context key for RateLimitInfo.
+type rateLimitInfoKey struct{}
+
+// RateLimitInfoFromContext returns the RateLimitInfo of a rate limited
+// request, as seen by OnRateLimited.
+func RateLimitInfoFromContext(ctx context.Context) (RateLimitInfo, bool) {
+	info, ok := ctx.Value(rateLimitInfoKey{}).(RateLimitInfo)
+	return info, ok
+}
+
+// retryAfterSeconds rounds a retry delay up to whole seconds, as used by
+// the Retry-After header.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ResponseBuilder renders rate limited responses. It negotiates the format
+// from the Accept header: RFC 7807 application/problem+json,
+// application/json, or text/plain, and sets Retry-After and X-RateLimit-*
+// headers from the request's RateLimitInfo.
+//
+// Detail may contain the template variables {retry_after} (whole seconds),
+// {limit}, {remaining} and {key}.
+type ResponseBuilder struct {
+	// Status is the response status. Defaults to 429.
+	Status int
+
+	// Title is the short summary used as the problem title and the JSON
+	// error. Defaults to "Too Many Requests".
+	Title string
+
+	// Detail is the human readable explanation, used as the text body.
+	// Defaults to "Rate limit exceeded".
+	Detail string
+
+	// Type is the problem type URI. Defaults to "about:blank".
+	Type string
+
+	// DefaultFormat is used when the client accepts any format: one of
+	// "text", "json" or "problem". Defaults to "text".
+	DefaultFormat string
+}
+
+// Response formats.
+const (
+	formatText    = "text"
+	formatJSON    = "json"
+	formatProblem = "problem"
+)
+
+// defaultResponseBuilder renders the response used when OnRateLimited is nil.
+var defaultResponseBuilder = &ResponseBuilder{}
+
+// OnRateLimited returns a function suitable for MiddlewareConfig.OnRateLimited.
+func (b *ResponseBuilder) OnRateLimited() func(w http.ResponseWriter, r *http.Request) {
+	return b.Write
+}
+
+// Write renders the rate limited response for r.
+func (b *ResponseBuilder) Write(w http.ResponseWriter, r *http.Request) {
+	info, _ := RateLimitInfoFromContext(r.Context())
+
+	status := b.Status
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	title := b.Title
+	if title == "" {
+		title = "Too Many Requests"
+	}
+	detail := b.Detail
+	if detail == "" {
+		detail = "Rate limit exceeded"
+	}
+	detail = expandTemplate(detail, info)
+
+	h := w.Header()
+	if info.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(retryAfterSeconds(info.RetryAfter)))
+	}
+	if info.Limit > 0 {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(info.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(info.Remaining))
+	}
+
+	switch negotiateFormat(r.Header.Get("Accept"), b.DefaultFormat) {
+	case formatProblem:
+		problemType := b.Type
+		if problemType == "" {
+			problemType = "about:blank"
+		}
+		body := map[string]interface{}{
+			"type":     problemType,
+			"title":    title,
+			"status":   status,
+			"detail":   detail,
+			"instance": r.URL.Path,
+		}
+		addInfo(body, info)
+		writeJSONBody(w, "application/problem+json", status, body)
+
+	case formatJSON:
+		body := map[string]interface{}{
+			"error":   title,
+			"message": detail,
+		}
+		addInfo(body, info)
+		writeJSONBody(w, "application/json", status, body)
+
+	default:
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		w.Write([]byte(detail + "\n"))
+	}
+}
+
+// addInfo adds the known RateLimitInfo fields to a JSON body.
+func addInfo(body map[string]interface{}, info RateLimitInfo) {
+	if info.RetryAfter > 0 {
+		body["retry_after"] = retryAfterSeconds(info.RetryAfter)
+	}
+	if info.Limit > 0 {
+		body["limit"] = info.Limit
+		body["remaining"] = info.Remaining
+	}
+}
+
+func writeJSONBody(w http.ResponseWriter, contentType string, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// expandTemplate substitutes template variables in s.
+func expandTemplate(s string, info RateLimitInfo) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return strings.NewReplacer(
+		"{retry_after}", strconv.Itoa(retryAfterSeconds(info.RetryAfter)),
+		"{limit}", strconv.Itoa(info.Limit),
+		"{remaining}", strconv.Itoa(info.Remaining),
+		"{key}", info.Key,
+	).Replace(s)
+}
+
+// negotiateFormat picks the response format with the highest quality in an
+// Accept header. Ties go to the more specific media type.
+func negotiateFormat(accept, fallback string) string {
+	if fallback == "" {
+		fallback = formatText
+	}
+	if accept == "" {
+		return fallback
+	}
+
+	best, bestQ, bestSpecific := fallback, -1.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		var format string
+		specific := 2
+		switch mediaType {
+		case "application/problem+json":
+			format = formatProblem
+		case "application/json":
+			format = formatJSON
+		case "text/plain":
+			format = formatText
+		case "application/*":
+			format, specific = formatJSON, 1
+			if fallback == formatProblem {
+				format = formatProblem
+			}
+		case "text/*":
+			format, specific = formatText, 1
+		case "*/*":
+			format, specific = fallback, 0
+		default:
+			continue
+		}
+
+		if q > bestQ || (q == bestQ && specific > bestSpecific) {
+			best, bestQ, bestSpecific = format, q, specific
+		}
+	}
+	return best
+}
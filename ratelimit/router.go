@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Router applies different limits to different routes from a single
+// middleware. Each route is a path pattern, optionally restricted to some
+// methods, with its own limiter factory; limiters are kept per route and per
+// key, so a client's budget on one route does not affect another.
+//
+// Patterns are matched segment by segment. A "*" segment matches exactly one
+// path segment, and a trailing "*" matches any remainder:
+//
+//	/api/users/*        /api/users/42, /api/users/42/posts
+//	/api/*/settings     /api/users/settings
+//	/login              /login only
+//
+// When several routes match, the most specific one wins: the one with more
+// literal segments, then the one with fewer wildcards, then the one
+// registered first. Requests matching no route use the base configuration,
+// or pass through unlimited if it has no LimiterFactory or
+// KeyedLimiterFactory.
+type Router struct {
+	config   *MiddlewareConfig
+	routes   []*route
+	fallback *Middleware
+}
+
+// route is one registered pattern and its middleware.
+type route struct {
+	pattern  string
+	segments []string
+	methods  map[string]bool
+	literals int
+	wildcard int
+	mw       *Middleware
+}
+
+// NewRouter creates a router. config supplies the key function, responses
+// and cleanup settings shared by all routes, and the limiter for requests
+// that match no route.
+func NewRouter(config *MiddlewareConfig) *Router {
+	if config == nil {
+		config = DefaultMiddlewareConfig()
+	}
+
+	rt := &Router{config: config}
+	if config.LimiterFactory != nil || config.KeyedLimiterFactory != nil {
+		rt.fallback = NewMiddleware(config)
+	}
+	return rt
+}
+
+// Handle registers a route. Requests whose path matches pattern and whose
+// method is one of methods (any method if none are given) are limited by
+// limiters from factory. Handle returns rt so registrations can be chained.
+// It must not be called concurrently with request handling.
+func (rt *Router) Handle(pattern string, factory func() Limiter, methods ...string) *Router {
+	cfg := *rt.config
+	cfg.LimiterFactory = factory
+	cfg.KeyedLimiterFactory = nil
+
+	r := &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		mw:       NewMiddleware(&cfg),
+	}
+	for _, seg := range r.segments {
+		if seg == "*" {
+			r.wildcard++
+		} else {
+			r.literals++
+		}
+	}
+	if len(methods) > 0 {
+		r.methods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			r.methods[strings.ToUpper(m)] = true
+		}
+	}
+
+	rt.routes = append(rt.routes, r)
+	return rt
+}
+
+// Handler returns an HTTP handler that limits each request by its route.
+func (rt *Router) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := rt.Match(r)
+		if mw == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mw.Handler(next).ServeHTTP(w, r)
+	})
+}
+
+// Match returns the middleware that handles r, or nil if r is not limited.
+func (rt *Router) Match(r *http.Request) *Middleware {
+	segments := splitPath(r.URL.Path)
+
+	var best *route
+	for _, candidate := range rt.routes {
+		if candidate.methods != nil && !candidate.methods[r.Method] {
+			continue
+		}
+		if !matchSegments(candidate.segments, segments) {
+			continue
+		}
+		if best == nil || candidate.moreSpecific(best) {
+			best = candidate
+		}
+	}
+
+	if best == nil {
+		return rt.fallback
+	}
+	return best.mw
+}
+
+// Route returns the middleware registered for pattern, for example to read
+// its Counters or Stats, or nil if there is none. If pattern was registered
+// several times for different methods, the first registration is returned.
+func (rt *Router) Route(pattern string) *Middleware {
+	for _, r := range rt.routes {
+		if r.pattern == pattern {
+			return r.mw
+		}
+	}
+	return nil
+}
+
+// Close stops the cleanup goroutines of all routes.
+func (rt *Router) Close() {
+	for _, r := range rt.routes {
+		r.mw.Close()
+	}
+	if rt.fallback != nil {
+		rt.fallback.Close()
+	}
+}
+
+// moreSpecific reports whether r should win over other when both match.
+func (r *route) moreSpecific(other *route) bool {
+	if r.literals != other.literals {
+		return r.literals > other.literals
+	}
+	if r.wildcard != other.wildcard {
+		return r.wildcard < other.wildcard
+	}
+	// Method-restricted routes beat catch-all ones; otherwise the earlier
+	// registration (other) wins.
+	return r.methods != nil && other.methods == nil
+}
+
+// splitPath splits a path into segments, ignoring empty ones.
+func splitPath(path string) []string {
+	parts := strings.Split(path, "/")
+	segments := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			segments = append(segments, p)
+		}
+	}
+	return segments
+}
+
+// MatchPath reports whether a request path matches a route pattern, with
+// the wildcards described on Router.
+func MatchPath(pattern, path string) bool {
+	return matchSegments(splitPath(pattern), splitPath(path))
+}
+
+// matchSegments reports whether path matches pattern.
+func matchSegments(pattern, path []string) bool {
+	for i, seg := range pattern {
+		if seg == "*" && i == len(pattern)-1 {
+			// A trailing wildcard needs at least one segment to match.
+			return len(path) > i
+		}
+		if i >= len(path) {
+			return false
+		}
+		if seg != "*" && seg != path[i] {
+			return false
+		}
+	}
+	return len(path) == len(pattern)
+}
@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Scaled is a limiter whose limits are those of a base limiter scaled by a
+// multiplier that can change at any time, such as one following a
+// schedule, a forecast or a detected spike. The multiplier is consulted on
+// every call, so no goroutine is needed; a change keeps the base limiter's
+// state (see Reconfigurer). Callers already blocked in Wait when it
+// changes see it once they are re-evaluated.
+//
+// Multipliers compose by multiplying them:
+//
+//	limiter := ratelimit.NewScaled(base, func() float64 {
+//		return forecaster.Multiplier() * monitor.Multiplier()
+//	})
+type Scaled struct {
+	base       Limiter
+	multiplier func() float64
+
+	mu      sync.Mutex
+	rate    int
+	period  time.Duration
+	burst   int
+	applied float64
+}
+
+// NewScaled returns a limiter that scales base by multiplier, which must
+// return a positive number and be safe for concurrent use. The base
+// limiter's current limits are the unscaled ones. base must be a
+// TokenBucket, FixedWindow, SlidingWindow or SlidingLog, as other limiters
+// cannot be scaled; NewScaled panics otherwise.
+func NewScaled(base Limiter, multiplier func() float64) *Scaled {
+	config, ok := limitsOf(base)
+	if !ok {
+		panic("ratelimit: NewScaled needs a TokenBucket, FixedWindow, SlidingWindow or SlidingLog")
+	}
+
+	s := &Scaled{
+		base:       base,
+		multiplier: multiplier,
+		rate:       config.Rate,
+		period:     config.Period,
+		burst:      config.Burst,
+		applied:    1,
+	}
+	s.apply()
+	return s
+}
+
+// limitsOf returns a copy of the configuration of one of the package's
+// limiters.
+func limitsOf(l Limiter) (Config, bool) {
+	switch l := l.(type) {
+	case *TokenBucket:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return *l.config, true
+	case *FixedWindow:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return *l.config, true
+	case *SlidingWindow:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return *l.config, true
+	case *SlidingLog:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return *l.config, true
+	}
+	return Config{}, false
+}
+
+// apply scales the base limiter by the multiplier in force now, if it has
+// changed. The multiplier is consulted without holding s.mu, so it may
+// call back into the limiter.
+func (s *Scaled) apply() {
+	m := s.multiplier()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m == s.applied {
+		return
+	}
+	s.applied = m
+	s.reconfigure()
+}
+
+// reconfigure sets the scaled limits on the base limiter. The caller must
+// hold s.mu.
+func (s *Scaled) reconfigure() {
+	s.base.(Reconfigurer).Reconfigure(scaleLimit(s.rate, s.applied), s.period, scaleLimit(s.burst, s.applied))
+}
+
+// scaleLimit scales n by m, to no less than 1 unless n is 0.
+func scaleLimit(n int, m float64) int {
+	if n == 0 {
+		return 0
+	}
+	scaled := int(math.Round(float64(n) * m))
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// Multiplier returns the multiplier in force now.
+func (s *Scaled) Multiplier() float64 {
+	s.apply()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applied
+}
+
+// Allow checks if a single request can proceed.
+func (s *Scaled) Allow() bool {
+	return s.AllowN(1)
+}
+
+// AllowN checks if n requests can proceed at the scaled limits.
+func (s *Scaled) AllowN(n int) bool {
+	s.apply()
+	return s.base.AllowN(n)
+}
+
+// Wait blocks until a request can proceed or context is cancelled.
+func (s *Scaled) Wait(ctx context.Context) error {
+	return s.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests can proceed at the scaled limits or
+// context is cancelled.
+func (s *Scaled) WaitN(ctx context.Context, n int) error {
+	s.apply()
+	return s.base.WaitN(ctx, n)
+}
+
+// Check returns nil if a single request would be admitted now, otherwise
+// an *ErrLimited describing when to retry.
+func (s *Scaled) Check() error {
+	return s.CheckN(1)
+}
+
+// CheckN returns nil if n requests would be admitted now at the scaled
+// limits, otherwise an *ErrLimited describing when to retry.
+func (s *Scaled) CheckN(n int) error {
+	s.apply()
+	return s.base.(Checker).CheckN(n)
+}
+
+// Reset resets the base limiter. The multiplier is unaffected.
+func (s *Scaled) Reset() {
+	s.base.Reset()
+}
+
+// Available returns the number of requests the base limiter would admit
+// now at the scaled limits.
+func (s *Scaled) Available() int {
+	s.apply()
+	return s.base.Available()
+}
+
+// Reconfigure changes the unscaled limits, which the multiplier keeps
+// scaling.
+func (s *Scaled) Reconfigure(rate int, period time.Duration, burst int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if burst == 0 {
+		burst = rate
+	}
+	s.rate, s.period, s.burst = rate, period, burst
+	s.reconfigure()
+}
+
+// Snapshot encodes the state of the base limiter.
+func (s *Scaled) Snapshot() ([]byte, error) {
+	return s.base.(Snapshotter).Snapshot()
+}
+
+// Restore replaces the state of the base limiter with a snapshot.
+func (s *Scaled) Restore(data []byte) error {
+	return s.base.(Snapshotter).Restore(data)
+}
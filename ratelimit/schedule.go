@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// ScheduleRule scales the rate of a Scheduled limiter at certain times of
+// day, on certain weekdays or on certain dates.
+type ScheduleRule struct {
+	// StartHour and EndHour are the hours of the day the rule applies,
+	// from StartHour up to but not including EndHour, which may be 24. A
+	// range that ends before it starts wraps past midnight, such as 22
+	// to 6. Both zero means the whole day.
+	StartHour int
+	EndHour   int
+
+	// Weekdays are the days the rule applies on; none means every day.
+	// They are checked against the current day, so a range past midnight
+	// continues into the next day only if that day is listed too.
+	Weekdays []time.Weekday
+
+	// Dates are the calendar days the rule applies on, such as holidays,
+	// by the year, month and day each is written with; none means every
+	// day.
+	Dates []time.Time
+
+	// Location is the time zone of the hours, weekdays and dates. Nil
+	// means UTC.
+	Location *time.Location
+
+	// Multiplier scales the rate, and the burst of a TokenBucket, while
+	// the rule applies: 1.5 for business hours, 0.5 at night. It must be
+	// positive; the scaled rate is never below 1.
+	Multiplier float64
+}
+
+// matches reports whether the rule applies at t.
+func (r *ScheduleRule) matches(t time.Time) bool {
+	loc := r.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+
+	if r.StartHour != 0 || r.EndHour != 0 {
+		h := t.Hour()
+		if r.StartHour < r.EndHour {
+			if h < r.StartHour || h >= r.EndHour {
+				return false
+			}
+		} else if h < r.StartHour && h >= r.EndHour {
+			return false
+		}
+	}
+
+	if len(r.Weekdays) > 0 {
+		found := false
+		for _, d := range r.Weekdays {
+			if d == t.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.Dates) > 0 {
+		y, m, d := t.Date()
+		found := false
+		for _, date := range r.Dates {
+			if dy, dm, dd := date.Date(); dy == y && dm == m && dd == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Scheduled is a limiter whose rate follows a schedule, such as a higher
+// rate during business hours or a lower one at night. It scales the rate
+// of a base limiter by the Multiplier of the first ScheduleRule that
+// applies, or by 1 if none does, keeping the base limiter's state when
+// the multiplier changes (see Scaled).
+//
+// The schedule is checked on every call, so no goroutine is needed: the
+// new rate takes effect with the first call after a rule starts or ends.
+// Callers already blocked in Wait at that moment see it once they are
+// re-evaluated.
+type Scheduled struct {
+	scaled *Scaled
+	rules  []ScheduleRule
+	clock  Clock
+}
+
+// NewScheduled returns a limiter that scales base by the first of rules
+// that applies. The base limiter's current limits are the unscaled ones.
+// base must be a TokenBucket, FixedWindow, SlidingWindow or SlidingLog,
+// as other limiters cannot be scaled; NewScheduled panics otherwise.
+func NewScheduled(base Limiter, rules []ScheduleRule) *Scheduled {
+	config, ok := limitsOf(base)
+	if !ok {
+		panic("ratelimit: NewScheduled needs a TokenBucket, FixedWindow, SlidingWindow or SlidingLog")
+	}
+
+	s := &Scheduled{rules: rules, clock: config.Clock}
+	s.scaled = NewScaled(base, func() float64 { return s.multiplierAt(s.clock.Now()) })
+	return s
+}
+
+// multiplierAt returns the multiplier of the first rule that applies at t.
+func (s *Scheduled) multiplierAt(t time.Time) float64 {
+	for i := range s.rules {
+		if s.rules[i].matches(t) {
+			return s.rules[i].Multiplier
+		}
+	}
+	return 1
+}
+
+// Multiplier returns the multiplier in force now.
+func (s *Scheduled) Multiplier() float64 {
+	return s.scaled.Multiplier()
+}
+
+// Allow checks if a single request can proceed.
+func (s *Scheduled) Allow() bool {
+	return s.AllowN(1)
+}
+
+// AllowN checks if n requests can proceed at the scheduled rate.
+func (s *Scheduled) AllowN(n int) bool {
+	return s.scaled.AllowN(n)
+}
+
+// Wait blocks until a request can proceed or context is cancelled.
+func (s *Scheduled) Wait(ctx context.Context) error {
+	return s.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests can proceed at the scheduled rate or
+// context is cancelled.
+func (s *Scheduled) WaitN(ctx context.Context, n int) error {
+	return s.scaled.WaitN(ctx, n)
+}
+
+// Check returns nil if a single request would be admitted now, otherwise
+// an *ErrLimited describing when to retry.
+func (s *Scheduled) Check() error {
+	return s.CheckN(1)
+}
+
+// CheckN returns nil if n requests would be admitted now at the scheduled
+// rate, otherwise an *ErrLimited describing when to retry.
+func (s *Scheduled) CheckN(n int) error {
+	return s.scaled.CheckN(n)
+}
+
+// Reset resets the base limiter. The schedule is unaffected.
+func (s *Scheduled) Reset() {
+	s.scaled.Reset()
+}
+
+// Available returns the number of requests the base limiter would admit
+// now at the scheduled rate.
+func (s *Scheduled) Available() int {
+	return s.scaled.Available()
+}
+
+// Reconfigure changes the unscaled limits, which the schedule keeps
+// scaling.
+func (s *Scheduled) Reconfigure(rate int, period time.Duration, burst int) {
+	s.scaled.Reconfigure(rate, period, burst)
+}
+
+// Snapshot encodes the state of the base limiter.
+func (s *Scheduled) Snapshot() ([]byte, error) {
+	return s.scaled.Snapshot()
+}
+
+// Restore replaces the state of the base limiter with a snapshot.
+func (s *Scheduled) Restore(data []byte) error {
+	return s.scaled.Restore(data)
+}
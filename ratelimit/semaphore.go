@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Semaphore is a weighted semaphore bounding concurrency rather than rate:
+// callers acquire units of a fixed size before work and release them
+// after. Its Acquire, TryAcquire and Release follow
+// golang.org/x/sync/semaphore.Weighted, so it can replace one: waiters are
+// served in arrival order, and a large request at the head of the queue is
+// not starved by smaller ones behind it. In addition it reports statistics
+// and can be resized while in use.
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List // of *semaphoreWaiter
+	stats   SemaphoreStats
+}
+
+// semaphoreWaiter is a caller blocked in Acquire.
+type semaphoreWaiter struct {
+	n     int64
+	ready chan struct{} // closed when the units are granted
+}
+
+// SemaphoreStats describes a Semaphore. The counters run since it was
+// created.
+type SemaphoreStats struct {
+	Size    int64 `json:"size"`
+	InUse   int64 `json:"in_use"`
+	Waiting int   `json:"waiting"`
+
+	// Acquired counts successful Acquire and TryAcquire calls, Rejected
+	// failed TryAcquire calls and Cancelled Acquire calls whose context
+	// ended first.
+	Acquired  int64 `json:"acquired"`
+	Rejected  int64 `json:"rejected"`
+	Cancelled int64 `json:"cancelled"`
+
+	// WaitTime is the total time Acquire calls spent blocked.
+	WaitTime time.Duration `json:"wait_time"`
+}
+
+// NewSemaphore returns a semaphore of size units.
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Acquire acquires n units, blocking until they are available or ctx is
+// done, in which case it returns ctx.Err() and acquires nothing. A
+// context that is already done never acquires units. Acquiring more than
+// the size blocks until the semaphore is resized or ctx is done.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if err := ctx.Err(); err != nil {
+		s.mu.Lock()
+		s.stats.Cancelled++
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.stats.Acquired++
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &semaphoreWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.stats.WaitTime += time.Since(start)
+		s.stats.Cancelled++
+		select {
+		case <-w.ready:
+			// Granted while the context ended: give the units back.
+			s.cur -= n
+			s.stats.Acquired--
+			s.notify()
+		default:
+			front := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// Waiters behind a large request may fit now.
+			if front && s.size > s.cur {
+				s.notify()
+			}
+		}
+		return ctx.Err()
+
+	case <-w.ready:
+		s.mu.Lock()
+		s.stats.WaitTime += time.Since(start)
+		s.mu.Unlock()
+		return nil
+	}
+}
+
+// TryAcquire acquires n units without blocking and reports whether it
+// did. It fails while others are waiting, as Acquire would queue behind
+// them.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.stats.Acquired++
+		return true
+	}
+	s.stats.Rejected++
+	return false
+}
+
+// Release releases n units. It panics if more units are released than are
+// held.
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cur -= n
+	if s.cur < 0 {
+		panic("ratelimit: Semaphore released more than held")
+	}
+	s.notify()
+}
+
+// Resize changes the size of the semaphore. Growing it admits waiters at
+// once; shrinking it below the units in use takes effect as they are
+// released, without interrupting their holders.
+func (s *Semaphore) Resize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = size
+	s.notify()
+}
+
+// Size returns the size of the semaphore.
+func (s *Semaphore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// InUse returns the units currently held.
+func (s *Semaphore) InUse() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// Stats returns the state and counters of the semaphore.
+func (s *Semaphore) Stats() SemaphoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Size = s.size
+	stats.InUse = s.cur
+	stats.Waiting = s.waiters.Len()
+	return stats
+}
+
+// notify grants units to waiters in order, stopping at the first that
+// does not fit so it is not starved. The caller must hold s.mu.
+func (s *Semaphore) notify() {
+	for {
+		next := s.waiters.Front()
+		if next == nil {
+			return
+		}
+		w := next.Value.(*semaphoreWaiter)
+		if s.size-s.cur < w.n {
+			return
+		}
+		s.cur += w.n
+		s.stats.Acquired++
+		s.waiters.Remove(next)
+		close(w.ready)
+	}
+}
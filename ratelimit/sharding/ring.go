@@ -0,0 +1,217 @@
+// Package sharding partitions keys across nodes with consistent hashing,
+// so keyed limits can be spread over several limiter instances or store
+// shards and every instance routes a key to the same place.
+//
+// Each node is placed on a hash ring at a number of virtual points. A key
+// belongs to the node owning the first point at or after the key's hash.
+// Adding or removing a node moves only the keys between its points and
+// their neighbours, about 1/N of all keys, so most keys keep their
+// counters.
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultReplicas is the number of virtual points per node. More points
+// spread keys more evenly at the cost of memory and slower changes.
+const DefaultReplicas = 150
+
+// HashFunc hashes a key or virtual point name onto the ring. Every
+// instance that must agree on key placement has to use the same function.
+type HashFunc func(data []byte) uint64
+
+// DefaultHash is the default HashFunc: 64-bit FNV-1a followed by the
+// MurmurHash3 finalizer. FNV alone leaves the high bits of short, similar
+// inputs such as "node#1" and "node#2" close together, which bunches a
+// node's virtual points on the ring.
+func DefaultHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Option configures a Ring.
+type Option func(*Ring)
+
+// WithReplicas sets the number of virtual points per node. Defaults to
+// DefaultReplicas.
+func WithReplicas(n int) Option {
+	return func(r *Ring) {
+		if n > 0 {
+			r.replicas = n
+		}
+	}
+}
+
+// WithHash sets the hash function. Defaults to DefaultHash.
+func WithHash(hash HashFunc) Option {
+	return func(r *Ring) {
+		if hash != nil {
+			r.hash = hash
+		}
+	}
+}
+
+// point is one virtual node on the ring.
+type point struct {
+	hash uint64
+	node string
+}
+
+// Ring is a consistent hash ring. It is safe for concurrent use.
+type Ring struct {
+	hash     HashFunc
+	replicas int
+
+	mu     sync.RWMutex
+	nodes  map[string]struct{}
+	points []point // sorted by hash, then node
+}
+
+// NewRing creates a ring holding nodes.
+func NewRing(nodes []string, opts ...Option) *Ring {
+	r := &Ring{
+		hash:     DefaultHash,
+		replicas: DefaultReplicas,
+		nodes:    make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.Add(nodes...)
+	return r
+}
+
+// Add places nodes on the ring. Nodes already present are ignored.
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added := false
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+		for i := 0; i < r.replicas; i++ {
+			r.points = append(r.points, point{hash: r.hash([]byte(node + "#" + strconv.Itoa(i))), node: node})
+		}
+		added = true
+	}
+	if !added {
+		return
+	}
+	// Ties between colliding points go to the smaller node name, so the
+	// order does not depend on the order nodes were added in.
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].node < r.points[j].node
+	})
+}
+
+// Remove takes nodes off the ring. Their keys move to the next node on
+// the ring.
+func (r *Ring) Remove(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := false
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			delete(r.nodes, node)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	kept := r.points[:0]
+	for _, p := range r.points {
+		if _, ok := r.nodes[p.node]; ok {
+			kept = append(kept, p)
+		}
+	}
+	r.points = kept
+}
+
+// Get returns the node key belongs to, or "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.points[r.search(key)].node
+}
+
+// GetN returns up to n distinct nodes for key, starting with Get(key) and
+// continuing clockwise. The later nodes are where the key would move if
+// the earlier ones were removed, which makes them natural fallbacks or
+// replicas.
+func (r *Ring) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i, start := 0, r.search(key); len(nodes) < n; i++ {
+		p := r.points[(start+i)%len(r.points)]
+		if !seen[p.node] {
+			seen[p.node] = true
+			nodes = append(nodes, p.node)
+		}
+	}
+	return nodes
+}
+
+// search returns the index of the first point at or after key's hash,
+// wrapping around. The caller must hold r.mu and the ring must not be
+// empty.
+func (r *Ring) search(key string) int {
+	h := r.hash([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+// Nodes returns the nodes on the ring, sorted.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Len returns the number of nodes on the ring.
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.nodes)
+}
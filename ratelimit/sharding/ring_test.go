@@ -0,0 +1,127 @@
+package sharding_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/rRateLimit/client/ratelimit/sharding"
+)
+
+func keys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func TestRingEmpty(t *testing.T) {
+	r := sharding.NewRing(nil)
+	if got := r.Get("k"); got != "" {
+		t.Errorf("Get on an empty ring = %q", got)
+	}
+	if got := r.GetN("k", 3); got != nil {
+		t.Errorf("GetN on an empty ring = %v", got)
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	r := sharding.NewRing(nodes)
+	counts := make(map[string]int)
+	all := keys(30000)
+	for _, k := range all {
+		counts[r.Get(k)]++
+	}
+	for _, node := range nodes {
+		if share := float64(counts[node]) / float64(len(all)); share < 0.25 || share > 0.42 {
+			t.Errorf("node %s holds %.0f%% of the keys, want about a third", node, share*100)
+		}
+	}
+}
+
+func TestRingPlacementIgnoresAddOrder(t *testing.T) {
+	r1 := sharding.NewRing([]string{"a", "b", "c"})
+	r2 := sharding.NewRing([]string{"c"})
+	r2.Add("a", "b", "a")
+	if got := r2.Nodes(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) || r2.Len() != 3 {
+		t.Fatalf("Nodes = %v, Len = %d", got, r2.Len())
+	}
+	for _, k := range keys(1000) {
+		if r1.Get(k) != r2.Get(k) {
+			t.Fatalf("key %s placed on %s and %s", k, r1.Get(k), r2.Get(k))
+		}
+	}
+}
+
+func TestRingMovesFewKeys(t *testing.T) {
+	r := sharding.NewRing([]string{"a", "b", "c"})
+	all := keys(20000)
+	before := make(map[string]string, len(all))
+	for _, k := range all {
+		before[k] = r.Get(k)
+	}
+
+	r.Add("d")
+	moved := 0
+	for _, k := range all {
+		if got := r.Get(k); got != before[k] {
+			moved++
+			if got != "d" {
+				t.Fatalf("key %s moved from %s to %s, not to the new node", k, before[k], got)
+			}
+		}
+	}
+	if share := float64(moved) / float64(len(all)); share < 0.15 || share > 0.35 {
+		t.Errorf("adding a fourth node moved %.0f%% of the keys, want about a quarter", share*100)
+	}
+
+	r.Remove("d", "missing")
+	for _, k := range all {
+		if got := r.Get(k); got != before[k] {
+			t.Fatalf("after removing the new node, key %s is on %s, want %s", k, got, before[k])
+		}
+	}
+}
+
+func TestRingGetN(t *testing.T) {
+	r := sharding.NewRing([]string{"a", "b", "c", "d"}, sharding.WithReplicas(50))
+	for _, k := range keys(200) {
+		nodes := r.GetN(k, 3)
+		if len(nodes) != 3 || nodes[0] != r.Get(k) {
+			t.Fatalf("GetN(%s, 3) = %v, want 3 nodes starting with %s", k, nodes, r.Get(k))
+		}
+		seen := make(map[string]bool)
+		for _, n := range nodes {
+			if seen[n] {
+				t.Fatalf("GetN(%s, 3) = %v repeats a node", k, nodes)
+			}
+			seen[n] = true
+		}
+	}
+
+	if got := r.GetN("k", 10); len(got) != 4 {
+		t.Errorf("GetN(k, 10) = %v, want all 4 nodes", got)
+	}
+	if got := r.GetN("k", 0); got != nil {
+		t.Errorf("GetN(k, 0) = %v, want nil", got)
+	}
+
+	// The second node is where the key goes when the first is removed.
+	next := r.GetN("k", 2)
+	r.Remove(next[0])
+	if got := r.Get("k"); got != next[1] {
+		t.Errorf("after removing %s, Get(k) = %s, want %s", next[0], got, next[1])
+	}
+}
+
+func TestRingCollidingPoints(t *testing.T) {
+	constant := func([]byte) uint64 { return 42 }
+	for _, order := range [][]string{{"b", "a", "c"}, {"c", "b", "a"}} {
+		r := sharding.NewRing(order, sharding.WithHash(constant), sharding.WithReplicas(3))
+		if got := r.Get("k"); got != "a" {
+			t.Errorf("ring of %v: Get = %s, want the smallest node a", order, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package ratelimit
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority is the importance of a request to a Shedder. Under pressure,
+// requests are dropped from the lowest priority up.
+type Priority int
+
+const (
+	// PriorityLow is for work that can be dropped first, such as
+	// prefetches, batch jobs and crawlers.
+	PriorityLow Priority = iota
+
+	// PriorityNormal is the priority of requests a PriorityFunc does not
+	// classify.
+	PriorityNormal
+
+	// PriorityHigh is for requests that are dropped only when dropping
+	// every lower priority request is not enough.
+	PriorityHigh
+
+	// PriorityCritical requests, such as health checks or payment
+	// callbacks, are never dropped.
+	PriorityCritical
+)
+
+// String returns "low", "normal", "high" or "critical".
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return "priority(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParsePriority parses the String form of a priority.
+func ParsePriority(s string) (Priority, bool) {
+	for p := PriorityLow; p <= PriorityCritical; p++ {
+		if strings.EqualFold(s, p.String()) {
+			return p, true
+		}
+	}
+	return PriorityNormal, false
+}
+
+// HeaderPriority returns a PriorityFunc that reads the priority from a
+// request header such as "X-Priority: low". Requests without the header,
+// or with an unknown value, are PriorityNormal. Clients can set any
+// priority this way, so strip the header at the edge unless it comes from
+// trusted services.
+func HeaderPriority(header string) func(r *http.Request) Priority {
+	return func(r *http.Request) Priority {
+		p, _ := ParsePriority(r.Header.Get(header))
+		return p
+	}
+}
+
+// PressureFunc reports how loaded the server is, in proportion to its
+// capacity: 0 is idle, 1 is saturated. Values above 1 are allowed.
+type PressureFunc func() float64
+
+// QueuePressure reports the depth of the QueueHandler queue of m
+// relative to maxQueue, the limit that handler was given.
+func QueuePressure(m *Middleware, maxQueue int) PressureFunc {
+	return func() float64 {
+		return float64(m.Queued()) / float64(max(maxQueue, 1))
+	}
+}
+
+// ShedderConfig configures a Shedder. Every signal that is set adds to
+// the pressure, which is the highest of them.
+type ShedderConfig struct {
+	// PriorityFunc classifies requests. If nil, every request is
+	// PriorityNormal.
+	PriorityFunc func(r *http.Request) Priority
+
+	// MaxInFlight is the number of requests in the handler at which the
+	// server is saturated. Zero disables the signal.
+	MaxInFlight int
+
+	// TargetLatency is the handler latency at which the server is
+	// saturated, compared with a moving average of recent requests. The
+	// average decays while no request completes, so shedding every
+	// request cannot keep it high for good. Zero disables the signal.
+	TargetLatency time.Duration
+
+	// Signals are further pressure sources, such as QueuePressure or the
+	// CPU utilization of the process.
+	Signals []PressureFunc
+
+	// Threshold is the pressure at which shedding starts. Above it, the
+	// excess up to a pressure of 1 is split evenly between the low, normal
+	// and high priorities: as it grows, low priority requests are dropped
+	// with a rising probability until all are, then normal ones, then
+	// high ones. Defaults to 0.8.
+	Threshold float64
+
+	// OnShed is called for dropped requests. If nil, a 503 with
+	// Retry-After is sent.
+	OnShed func(w http.ResponseWriter, r *http.Request, p Priority)
+
+	// RetryAfter is the Retry-After advertised by the default OnShed
+	// response. Defaults to one second.
+	RetryAfter time.Duration
+}
+
+// ShedderStats counts the requests of a Shedder by priority.
+type ShedderStats struct {
+	// Pressure is the pressure at the time of the call.
+	Pressure float64 `json:"pressure"`
+
+	Admitted map[string]int64 `json:"admitted"`
+	Shed     map[string]int64 `json:"shed"`
+}
+
+// Shedder is an HTTP middleware that drops requests under pressure,
+// lowest priority first, to keep the server responsive for the requests
+// that matter. Unlike rate limiting, it does not judge clients but the
+// load of the server: with no pressure, nothing is dropped.
+//
+// Admitted requests carry their priority in the context (see
+// ContextWithPriority), so limiters waited on downstream serve them in
+// the same order.
+type Shedder struct {
+	config ShedderConfig
+
+	inFlight int64
+
+	mu       sync.Mutex
+	latency  float64 // moving average in seconds; 0 before the first request
+	observed time.Time
+
+	admitted [PriorityCritical + 1]int64
+	shed     [PriorityCritical + 1]int64
+}
+
+// latencyAlpha is the weight of the latest request in the moving average
+// of latency.
+const latencyAlpha = 0.05
+
+// latencyDecay is how many TargetLatency periods without a completed
+// request reduce the latency average by a factor of e.
+const latencyDecay = 20
+
+// NewShedder creates a shedder.
+func NewShedder(config ShedderConfig) *Shedder {
+	if config.Threshold <= 0 || config.Threshold >= 1 {
+		config.Threshold = 0.8
+	}
+	return &Shedder{config: config}
+}
+
+// Pressure returns the current pressure, the highest of its signals.
+func (s *Shedder) Pressure() float64 {
+	var p float64
+	if s.config.MaxInFlight > 0 {
+		p = float64(atomic.LoadInt64(&s.inFlight)) / float64(s.config.MaxInFlight)
+	}
+	if s.config.TargetLatency > 0 {
+		target := s.config.TargetLatency.Seconds()
+		s.mu.Lock()
+		latency := s.latency
+		if latency > 0 {
+			idle := time.Since(s.observed).Seconds()
+			latency *= math.Exp(-idle / (latencyDecay * target))
+		}
+		s.mu.Unlock()
+		p = math.Max(p, latency/target)
+	}
+	for _, signal := range s.config.Signals {
+		p = math.Max(p, signal())
+	}
+	return p
+}
+
+// DropProbability returns the probability that a request of priority p is
+// dropped at pressure.
+func (s *Shedder) DropProbability(p Priority, pressure float64) float64 {
+	if p >= PriorityCritical || pressure <= s.config.Threshold {
+		return 0
+	}
+	excess := (pressure - s.config.Threshold) / (1 - s.config.Threshold)
+	band := excess*float64(PriorityCritical) - float64(p)
+	return math.Min(math.Max(band, 0), 1)
+}
+
+// priority classifies r, clamping the result to the known priorities.
+func (s *Shedder) priority(r *http.Request) Priority {
+	if s.config.PriorityFunc == nil {
+		return PriorityNormal
+	}
+	switch p := s.config.PriorityFunc(r); {
+	case p < PriorityLow:
+		return PriorityLow
+	case p > PriorityCritical:
+		return PriorityCritical
+	default:
+		return p
+	}
+}
+
+// Handler returns a handler that drops requests under pressure before
+// they reach next.
+func (s *Shedder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := s.priority(r)
+		if drop := s.DropProbability(p, s.Pressure()); drop > 0 && rand.Float64() < drop {
+			atomic.AddInt64(&s.shed[p], 1)
+			s.reject(w, r, p)
+			return
+		}
+
+		atomic.AddInt64(&s.admitted[p], 1)
+		atomic.AddInt64(&s.inFlight, 1)
+		start := time.Now()
+		defer func() {
+			atomic.AddInt64(&s.inFlight, -1)
+			s.observe(time.Since(start))
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ContextWithPriority(r.Context(), int(p))))
+	})
+}
+
+// observe adds the latency of a request to the moving average.
+func (s *Shedder) observe(d time.Duration) {
+	if s.config.TargetLatency <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observed = time.Now()
+	if s.latency == 0 {
+		s.latency = d.Seconds()
+		return
+	}
+	s.latency += latencyAlpha * (d.Seconds() - s.latency)
+}
+
+// reject writes the response for a dropped request.
+func (s *Shedder) reject(w http.ResponseWriter, r *http.Request, p Priority) {
+	if s.config.OnShed != nil {
+		s.config.OnShed(w, r, p)
+		return
+	}
+
+	retryAfter := s.config.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	http.Error(w, "Service overloaded", http.StatusServiceUnavailable)
+}
+
+// Stats returns the counters of the shedder by priority name.
+func (s *Shedder) Stats() ShedderStats {
+	stats := ShedderStats{
+		Pressure: s.Pressure(),
+		Admitted: make(map[string]int64, len(s.admitted)),
+		Shed:     make(map[string]int64, len(s.shed)),
+	}
+	for p := PriorityLow; p <= PriorityCritical; p++ {
+		stats.Admitted[p.String()] = atomic.LoadInt64(&s.admitted[p])
+		stats.Shed[p.String()] = atomic.LoadInt64(&s.shed[p])
+	}
+	return stats
+}
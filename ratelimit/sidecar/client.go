@@ -0,0 +1,273 @@
+package sidecar
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// ClientConfig configures a sidecar Client.
+type ClientConfig struct {
+	// Network and Address locate the daemon, e.g. "unix" and
+	// "/run/ratelimitd.sock".
+	Network string
+	Address string
+
+	// MaxIdleConns is how many idle connections to keep. Defaults to 8.
+	MaxIdleConns int
+
+	// Timeout bounds each request round trip. Defaults to 100ms.
+	Timeout time.Duration
+
+	// FailOpen makes Limiter views allow requests when the daemon is
+	// unreachable. By default they deny.
+	FailOpen bool
+}
+
+// Client talks to a ratelimitd daemon. It is safe for concurrent use.
+type Client struct {
+	config ClientConfig
+	idle   chan *clientConn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// clientConn is a pooled connection with its reusable buffers.
+type clientConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	reqBuf  []byte
+	respBuf [responseSize]byte
+}
+
+// ErrClientClosed is returned by requests made after Close.
+var ErrClientClosed = errors.New("sidecar: client closed")
+
+// NewClient creates a client. Connections are opened lazily.
+func NewClient(config ClientConfig) *Client {
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = 8
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 100 * time.Millisecond
+	}
+
+	return &Client{
+		config: config,
+		idle:   make(chan *clientConn, config.MaxIdleConns),
+		closed: make(chan struct{}),
+	}
+}
+
+// Dial creates a client and verifies the daemon is reachable.
+func Dial(network, address string) (*Client, error) {
+	c := NewClient(ClientConfig{Network: network, Address: address})
+
+	cc, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	c.put(cc)
+
+	return c, nil
+}
+
+// Close closes all idle connections. In-flight requests finish normally.
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		for {
+			select {
+			case cc := <-c.idle:
+				cc.conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// AllowN consumes n units for key if available.
+func (c *Client) AllowN(key string, n int) (bool, error) {
+	resp, err := c.do(request{op: OpAllow, n: uint32(n), key: key})
+	if err != nil {
+		return false, err
+	}
+	return resp.status == StatusOK, nil
+}
+
+// WaitN blocks until n units for key are admitted or ctx is done. It
+// sleeps for the retry hint returned by the daemon between attempts.
+func (c *Client) WaitN(ctx context.Context, key string, n int) error {
+	for {
+		resp, err := c.do(request{op: OpAllow, n: uint32(n), key: key})
+		if err != nil {
+			return err
+		}
+		if resp.status == StatusOK {
+			return nil
+		}
+
+		wait := resp.retryAfter
+		if wait <= 0 {
+			wait = time.Millisecond
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Available returns the units currently available for key.
+func (c *Client) Available(key string) (int, error) {
+	resp, err := c.do(request{op: OpAvailable, key: key})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.available), nil
+}
+
+// Reset resets key to its initial state.
+func (c *Client) Reset(key string) error {
+	_, err := c.do(request{op: OpReset, key: key})
+	return err
+}
+
+// ReturnN gives n unused units for key back to the daemon.
+func (c *Client) ReturnN(key string, n int) error {
+	_, err := c.do(request{op: OpReturn, n: uint32(n), key: key})
+	return err
+}
+
+// Ping checks that the daemon is reachable and serving requests.
+func (c *Client) Ping() error {
+	_, err := c.do(request{op: OpPing})
+	return err
+}
+
+// Limiter returns a ratelimit.Limiter view of a single key. Daemon errors
+// are resolved according to ClientConfig.FailOpen.
+func (c *Client) Limiter(key string) ratelimit.Limiter {
+	return &keyLimiter{client: c, key: key}
+}
+
+// do performs one request/response round trip on a pooled connection.
+func (c *Client) do(req request) (response, error) {
+	cc, err := c.get()
+	if err != nil {
+		return response{}, err
+	}
+
+	cc.conn.SetDeadline(time.Now().Add(c.config.Timeout))
+
+	cc.reqBuf, err = writeRequest(cc.conn, cc.reqBuf, req)
+	if err != nil {
+		cc.conn.Close()
+		return response{}, err
+	}
+
+	resp, err := readResponse(cc.reader, &cc.respBuf)
+	if err != nil {
+		cc.conn.Close()
+		return response{}, err
+	}
+	c.put(cc)
+
+	if resp.status == StatusError {
+		return resp, fmt.Errorf("sidecar: op %d failed for key %q", req.op, req.key)
+	}
+	return resp, nil
+}
+
+// get returns an idle connection or dials a new one.
+func (c *Client) get() (*clientConn, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClientClosed
+	case cc := <-c.idle:
+		return cc, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout(c.config.Network, c.config.Address, c.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// put returns a healthy connection to the pool, closing it if the pool
+// is full or the client is closed.
+func (c *Client) put(cc *clientConn) {
+	select {
+	case <-c.closed:
+		cc.conn.Close()
+		return
+	default:
+	}
+
+	select {
+	case c.idle <- cc:
+	default:
+		cc.conn.Close()
+	}
+}
+
+// keyLimiter adapts a Client key to ratelimit.Limiter.
+type keyLimiter struct {
+	client *Client
+	key    string
+}
+
+func (k *keyLimiter) Allow() bool {
+	return k.AllowN(1)
+}
+
+func (k *keyLimiter) AllowN(n int) bool {
+	ok, err := k.client.AllowN(k.key, n)
+	if err != nil {
+		return k.client.config.FailOpen
+	}
+	return ok
+}
+
+func (k *keyLimiter) Wait(ctx context.Context) error {
+	return k.WaitN(ctx, 1)
+}
+
+func (k *keyLimiter) WaitN(ctx context.Context, n int) error {
+	err := k.client.WaitN(ctx, k.key, n)
+	if err != nil && ctx.Err() == nil && k.client.config.FailOpen {
+		return nil
+	}
+	return err
+}
+
+func (k *keyLimiter) Reset() {
+	k.client.Reset(k.key)
+}
+
+func (k *keyLimiter) Available() int {
+	n, err := k.client.Available(k.key)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// ReturnN implements ratelimit.Refunder.
+func (k *keyLimiter) ReturnN(n int) {
+	k.client.ReturnN(k.key, n)
+}
@@ -0,0 +1,155 @@
+// Package sidecar lets applications delegate rate limiting to a local
+// daemon (ratelimitd) that owns the per-key buckets, so limiter state
+// survives application restarts and is shared by every process on a node.
+//
+// Clients and the daemon speak a small fixed-layout binary protocol,
+// normally over a Unix socket. Each connection carries one request at a
+// time; clients keep a pool of connections to issue requests concurrently.
+//
+// Request frame (big-endian):
+//
+//	version  uint8
+//	op       uint8
+//	n        uint32
+//	keyLen   uint16
+//	key      [keyLen]byte
+//
+// Response frame (big-endian):
+//
+//	status     uint8
+//	available  uint32
+//	retryAfter int64 (nanoseconds)
+package sidecar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ProtocolVersion is the version byte carried by every request.
+const ProtocolVersion = 1
+
+// MaxKeyLength is the longest key the protocol can carry.
+const MaxKeyLength = 1<<16 - 1
+
+// Op identifies a request operation.
+type Op uint8
+
+// Operations understood by the daemon.
+const (
+	OpAllow     Op = 1 // consume n units if available
+	OpAvailable Op = 2 // report available units without consuming
+	OpReset     Op = 3 // reset the key to its initial state
+	OpReturn    Op = 4 // give n unused units back
+	OpPing      Op = 5 // check the daemon is serving; touches no key
+)
+
+// Status is the outcome carried in a response.
+type Status uint8
+
+// Response statuses.
+const (
+	StatusOK     Status = 0
+	StatusDenied Status = 1
+	StatusError  Status = 2
+)
+
+const (
+	requestHeaderSize = 8
+	responseSize      = 13
+)
+
+// ErrKeyTooLong is returned when a key exceeds MaxKeyLength.
+var ErrKeyTooLong = errors.New("sidecar: key too long")
+
+// request is a decoded request frame.
+type request struct {
+	op  Op
+	n   uint32
+	key string
+}
+
+// response is a decoded response frame.
+type response struct {
+	status     Status
+	available  uint32
+	retryAfter time.Duration
+}
+
+// writeRequest encodes req into buf and writes it to w in a single call.
+func writeRequest(w io.Writer, buf []byte, req request) ([]byte, error) {
+	if len(req.key) > MaxKeyLength {
+		return buf, ErrKeyTooLong
+	}
+
+	size := requestHeaderSize + len(req.key)
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+
+	buf[0] = ProtocolVersion
+	buf[1] = byte(req.op)
+	binary.BigEndian.PutUint32(buf[2:6], req.n)
+	binary.BigEndian.PutUint16(buf[6:8], uint16(len(req.key)))
+	copy(buf[8:], req.key)
+
+	_, err := w.Write(buf)
+	return buf, err
+}
+
+// readRequest decodes one request frame from r.
+func readRequest(r io.Reader, buf []byte) (request, []byte, error) {
+	if cap(buf) < requestHeaderSize {
+		buf = make([]byte, requestHeaderSize)
+	}
+	header := buf[:requestHeaderSize]
+	if _, err := io.ReadFull(r, header); err != nil {
+		return request{}, buf, err
+	}
+
+	if header[0] != ProtocolVersion {
+		return request{}, buf, fmt.Errorf("sidecar: unsupported protocol version %d", header[0])
+	}
+
+	req := request{
+		op: Op(header[1]),
+		n:  binary.BigEndian.Uint32(header[2:6]),
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(header[6:8]))
+	if cap(buf) < keyLen {
+		buf = make([]byte, keyLen)
+	}
+	key := buf[:keyLen]
+	if _, err := io.ReadFull(r, key); err != nil {
+		return request{}, buf, err
+	}
+	req.key = string(key)
+
+	return req, buf, nil
+}
+
+// writeResponse encodes resp and writes it to w.
+func writeResponse(w io.Writer, buf *[responseSize]byte, resp response) error {
+	buf[0] = byte(resp.status)
+	binary.BigEndian.PutUint32(buf[1:5], resp.available)
+	binary.BigEndian.PutUint64(buf[5:13], uint64(resp.retryAfter))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// readResponse decodes one response frame from r.
+func readResponse(r io.Reader, buf *[responseSize]byte) (response, error) {
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return response{}, err
+	}
+	return response{
+		status:     Status(buf[0]),
+		available:  binary.BigEndian.Uint32(buf[1:5]),
+		retryAfter: time.Duration(binary.BigEndian.Uint64(buf[5:13])),
+	}, nil
+}
@@ -0,0 +1,353 @@
+package sidecar
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+// ServerConfig configures a sidecar Server.
+type ServerConfig struct {
+	// LimiterFactory creates the limiter for a key on first use.
+	LimiterFactory func(key string) ratelimit.Limiter
+
+	// RetryInterval is the time needed to earn one unit, used to compute
+	// the retry hint sent with denied requests (for example Period/Rate).
+	RetryInterval time.Duration
+
+	// MaxIdleTime is how long a key may go unused before it is dropped.
+	// Zero keeps keys forever.
+	MaxIdleTime time.Duration
+}
+
+// serverEntry holds a key's limiter and its last access time.
+type serverEntry struct {
+	limiter    ratelimit.Limiter
+	lastAccess time.Time
+}
+
+// Server owns per-key limiters and answers sidecar protocol requests.
+type Server struct {
+	config   ServerConfig
+	limiters map[string]*serverEntry
+	mu       sync.Mutex
+
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	connMu    sync.Mutex
+	wg        sync.WaitGroup
+	closed    bool
+}
+
+// NewServer creates a new sidecar server.
+func NewServer(config ServerConfig) *Server {
+	return &Server{
+		config:    config,
+		limiters:  make(map[string]*serverEntry),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("sidecar: server closed")
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.connMu.Unlock()
+
+	defer func() {
+		s.connMu.Lock()
+		delete(s.listeners, l)
+		s.connMu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			s.connMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		s.connMu.Lock()
+		s.conns[conn] = struct{}{}
+		s.connMu.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops all listeners and connections and waits for handlers to exit.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.connMu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// handleConn serves requests on one connection until it is closed.
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.connMu.Lock()
+		delete(s.conns, conn)
+		s.connMu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	var (
+		buf     []byte
+		respBuf [responseSize]byte
+		req     request
+		err     error
+	)
+
+	for {
+		req, buf, err = readRequest(reader, buf)
+		if err != nil {
+			return
+		}
+
+		if err := writeResponse(conn, &respBuf, s.handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+// handle executes a single request.
+func (s *Server) handle(req request) response {
+	if req.op == OpPing {
+		return response{status: StatusOK}
+	}
+
+	limiter := s.getLimiter(req.key)
+	n := int(req.n)
+
+	switch req.op {
+	case OpAllow:
+		if limiter.AllowN(n) {
+			return response{status: StatusOK, available: available(limiter)}
+		}
+		avail := available(limiter)
+		return response{
+			status:     StatusDenied,
+			available:  avail,
+			retryAfter: s.retryAfter(n, int(avail)),
+		}
+
+	case OpAvailable:
+		return response{status: StatusOK, available: available(limiter)}
+
+	case OpReset:
+		limiter.Reset()
+		return response{status: StatusOK, available: available(limiter)}
+
+	case OpReturn:
+		r, ok := limiter.(ratelimit.Refunder)
+		if !ok {
+			return response{status: StatusError}
+		}
+		r.ReturnN(n)
+		return response{status: StatusOK, available: available(limiter)}
+	}
+
+	return response{status: StatusError}
+}
+
+// retryAfter estimates how long until n units are available.
+func (s *Server) retryAfter(n, avail int) time.Duration {
+	missing := n - avail
+	if missing < 1 {
+		missing = 1
+	}
+
+	s.mu.Lock()
+	interval := s.config.RetryInterval
+	s.mu.Unlock()
+
+	return time.Duration(missing) * interval
+}
+
+// Reconfigure switches to a new limiter factory and retry interval, for
+// example after a policy change. Existing keys are rebuilt with the new
+// factory; keys whose limiters implement ratelimit.Snapshotter carry their
+// state over, so a change does not hand every client a fresh burst.
+func (s *Server) Reconfigure(factory func(key string) ratelimit.Limiter, retryInterval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config.LimiterFactory = factory
+	s.config.RetryInterval = retryInterval
+
+	for key, entry := range s.limiters {
+		limiter := factory(key)
+		if old, ok := entry.limiter.(ratelimit.Snapshotter); ok {
+			if next, ok := limiter.(ratelimit.Snapshotter); ok {
+				if data, err := old.Snapshot(); err == nil {
+					next.Restore(data)
+				}
+			}
+		}
+		entry.limiter = limiter
+	}
+}
+
+// getLimiter returns the limiter for key, creating it if needed.
+func (s *Server) getLimiter(key string) ratelimit.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if entry, exists := s.limiters[key]; exists {
+		entry.lastAccess = now
+		return entry.limiter
+	}
+
+	limiter := s.config.LimiterFactory(key)
+	s.limiters[key] = &serverEntry{
+		limiter:    limiter,
+		lastAccess: now,
+	}
+	return limiter
+}
+
+// RunCleanup removes idle keys every interval until ctx is done.
+func (s *Server) RunCleanup(ctx context.Context, interval time.Duration) {
+	if s.config.MaxIdleTime <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, entry := range s.limiters {
+				if now.Sub(entry.lastAccess) > s.config.MaxIdleTime {
+					delete(s.limiters, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Keys returns the number of keys currently held.
+func (s *Server) Keys() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.limiters)
+}
+
+// available clamps a limiter's availability to the protocol field.
+func available(l ratelimit.Limiter) uint32 {
+	a := l.Available()
+	if a < 0 {
+		return 0
+	}
+	return uint32(a)
+}
+
+// Dump returns the state of every key, taken under the server lock.
+func (s *Server) Dump() ratelimit.StateDump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dump := ratelimit.StateDump{
+		TakenAt: time.Now(),
+		Source:  "sidecar",
+		Keys:    make(map[string]ratelimit.KeyDump, len(s.limiters)),
+	}
+	for key, entry := range s.limiters {
+		dump.Keys[key] = ratelimit.DumpKey(entry.limiter, entry.lastAccess)
+	}
+	return dump
+}
+
+// Snapshot encodes the state of every key whose limiter implements
+// ratelimit.Snapshotter.
+func (s *Server) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make(map[string]json.RawMessage, len(s.limiters))
+	for key, entry := range s.limiters {
+		snap, ok := entry.limiter.(ratelimit.Snapshotter)
+		if !ok {
+			continue
+		}
+		data, err := snap.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("snapshot key %q: %w", key, err)
+		}
+		states[key] = data
+	}
+
+	return json.Marshal(states)
+}
+
+// Restore recreates keys from a Snapshot using LimiterFactory.
+func (s *Server) Restore(data []byte) error {
+	var states map[string]json.RawMessage
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("decode sidecar snapshot: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, state := range states {
+		limiter := s.config.LimiterFactory(key)
+		snap, ok := limiter.(ratelimit.Snapshotter)
+		if !ok {
+			continue
+		}
+		if err := snap.Restore(state); err != nil {
+			return fmt.Errorf("restore key %q: %w", key, err)
+		}
+		s.limiters[key] = &serverEntry{
+			limiter:    limiter,
+			lastAccess: now,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package sidecar_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/internal/leakcheck"
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+	"github.com/rRateLimit/client/ratelimit/sidecar"
+)
+
+// serve starts server on a Unix socket and returns its address. The
+// server is closed when the test ends.
+func serve(t *testing.T, server *sidecar.Server) string {
+	t.Helper()
+
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir.
+	dir, err := os.MkdirTemp("", "sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	addr := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(l) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; !errors.Is(err, sidecar.ErrServerClosed) {
+			t.Errorf("Serve = %v, want ErrServerClosed", err)
+		}
+	})
+	return addr
+}
+
+func newServer(clock ratelimit.Clock, algorithm func(...ratelimit.Option) ratelimit.Limiter) *sidecar.Server {
+	return sidecar.NewServer(sidecar.ServerConfig{
+		LimiterFactory: func(string) ratelimit.Limiter {
+			return algorithm(ratelimit.WithRate(3), ratelimit.WithPeriod(time.Hour), ratelimit.WithBurst(3), ratelimit.WithClock(clock))
+		},
+		RetryInterval: 20 * time.Minute,
+	})
+}
+
+func tokenBucket(opts ...ratelimit.Option) ratelimit.Limiter {
+	return ratelimit.NewTokenBucket(opts...)
+}
+
+func fixedWindow(opts ...ratelimit.Option) ratelimit.Limiter {
+	return ratelimit.NewFixedWindow(opts...)
+}
+
+func TestClientServer(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	server := newServer(clock, tokenBucket)
+	c, err := sidecar.Dial("unix", serve(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if server.Keys() != 0 {
+		t.Errorf("Ping created %d keys", server.Keys())
+	}
+
+	for i, r := range []struct {
+		n    int
+		want bool
+	}{{2, true}, {2, false}, {1, true}, {1, false}} {
+		if ok, err := c.AllowN("k", r.n); ok != r.want || err != nil {
+			t.Errorf("AllowN #%d (%d) = %v, %v, want %v", i+1, r.n, ok, err, r.want)
+		}
+	}
+	if n, err := c.Available("k"); n != 0 || err != nil {
+		t.Errorf("Available = %d, %v, want 0", n, err)
+	}
+	if n, _ := c.Available("other"); n != 3 {
+		t.Errorf("Available(other) = %d, want 3: keys are independent", n)
+	}
+
+	if err := c.ReturnN("k", 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Available("k"); n != 2 {
+		t.Errorf("Available after ReturnN(2) = %d, want 2", n)
+	}
+	if err := c.Reset("k"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Available("k"); n != 3 {
+		t.Errorf("Available after Reset = %d, want 3", n)
+	}
+	if server.Keys() != 2 {
+		t.Errorf("Keys = %d, want 2", server.Keys())
+	}
+
+	l := c.Limiter("view")
+	if !l.AllowN(3) || l.Allow() || l.Available() != 0 {
+		t.Error("Limiter view does not follow the daemon's key")
+	}
+}
+
+func TestServerCloseStopsGoroutines(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	dir, err := os.MkdirTemp("", "sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(ratelimit.SystemClock{}, tokenBucket)
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(l) }()
+
+	c, err := sidecar.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.AllowN("k", 1)
+
+	server.Close()
+	if err := <-done; !errors.Is(err, sidecar.ErrServerClosed) {
+		t.Errorf("Serve = %v, want ErrServerClosed", err)
+	}
+	if err := server.Serve(l); !errors.Is(err, sidecar.ErrServerClosed) {
+		t.Errorf("Serve after Close = %v, want ErrServerClosed", err)
+	}
+	if _, err := c.AllowN("k", 1); err == nil {
+		t.Error("AllowN succeeded after the server closed")
+	}
+}
+
+func TestClientWaitNUsesRetryHint(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	c := sidecar.NewClient(sidecar.ClientConfig{Network: "unix", Address: serve(t, newServer(clock, tokenBucket))})
+	defer c.Close()
+
+	if err := c.WaitN(context.Background(), "k", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The hint is 20 minutes, far beyond the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.WaitN(ctx, "k", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitN = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitN returned after %s, want at the deadline", elapsed)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	addr := serve(t, newServer(clock, fixedWindow))
+	c := sidecar.NewClient(sidecar.ClientConfig{Network: "unix", Address: addr})
+
+	if err := c.ReturnN("k", 1); err == nil {
+		t.Error("ReturnN on a limiter without refunds succeeded")
+	}
+	if _, err := c.AllowN(strings.Repeat("k", sidecar.MaxKeyLength+1), 1); !errors.Is(err, sidecar.ErrKeyTooLong) {
+		t.Errorf("AllowN with a long key = %v, want ErrKeyTooLong", err)
+	}
+	if ok, err := c.AllowN("k", 1); !ok || err != nil {
+		t.Errorf("AllowN after failed requests = %v, %v, want the client usable", ok, err)
+	}
+
+	c.Close()
+	if _, err := c.AllowN("k", 1); !errors.Is(err, sidecar.ErrClientClosed) {
+		t.Errorf("AllowN after Close = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestServerClosesConnectionOnBadVersion(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	conn, err := net.Dial("unix", serve(t, newServer(clock, tokenBucket)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte{sidecar.ProtocolVersion + 1, byte(sidecar.OpPing), 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Read after a bad version = %v, want EOF", err)
+	}
+}
+
+func TestLimiterFailOpen(t *testing.T) {
+	// Nothing listens at the address.
+	addr := filepath.Join(t.TempDir(), "none")
+	for _, failOpen := range []bool{false, true} {
+		c := sidecar.NewClient(sidecar.ClientConfig{Network: "unix", Address: addr, FailOpen: failOpen})
+		l := c.Limiter("k")
+		if got := l.Allow(); got != failOpen {
+			t.Errorf("FailOpen %v: Allow = %v", failOpen, got)
+		}
+		err := l.Wait(context.Background())
+		if failOpen && err != nil || !failOpen && err == nil {
+			t.Errorf("FailOpen %v: Wait = %v", failOpen, err)
+		}
+		c.Close()
+	}
+}
+
+func TestServerStateSurvivesReconfigureAndRestore(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	server := newServer(clock, tokenBucket)
+	c := sidecar.NewClient(sidecar.ClientConfig{Network: "unix", Address: serve(t, server)})
+	defer c.Close()
+
+	c.AllowN("k", 2)
+	server.Reconfigure(func(string) ratelimit.Limiter {
+		return ratelimit.NewTokenBucket(ratelimit.WithRate(10), ratelimit.WithPeriod(time.Hour), ratelimit.WithBurst(10), ratelimit.WithClock(clock))
+	}, time.Minute)
+	if n, _ := c.Available("k"); n != 1 {
+		t.Errorf("Available after Reconfigure = %d, want the 1 token left", n)
+	}
+
+	data, err := server.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newServer(clock, tokenBucket)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Dump().Keys["k"].Available; got != 1 {
+		t.Errorf("restored key has %d available, want 1", got)
+	}
+	if err := restored.Restore([]byte("{")); err == nil {
+		t.Error("Restore accepted a corrupt snapshot")
+	}
+}
@@ -0,0 +1,142 @@
+package simulate
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Process is a synthetic arrival process.
+type Process interface {
+	// Arrivals returns the arrival times of the requests within [0, d),
+	// as offsets from the start, in order. Random processes draw from
+	// rng, so the same seed gives the same arrivals.
+	Arrivals(d time.Duration, rng *rand.Rand) []time.Duration
+}
+
+// Constant is a request every 1/Rate seconds.
+type Constant struct {
+	// Rate is the number of requests per second.
+	Rate float64
+}
+
+// Arrivals implements Process.
+func (p Constant) Arrivals(d time.Duration, _ *rand.Rand) []time.Duration {
+	if p.Rate <= 0 {
+		return nil
+	}
+	gap := time.Duration(float64(time.Second) / p.Rate)
+	if gap <= 0 {
+		gap = 1
+	}
+	var arrivals []time.Duration
+	for t := time.Duration(0); t < d; t += gap {
+		arrivals = append(arrivals, t)
+	}
+	return arrivals
+}
+
+// Poisson is requests arriving independently at an average of Rate per
+// second, as from many uncoordinated clients.
+type Poisson struct {
+	Rate float64
+}
+
+// Arrivals implements Process.
+func (p Poisson) Arrivals(d time.Duration, rng *rand.Rand) []time.Duration {
+	return thinned(d, rng, p.Rate, func(time.Duration) float64 { return 1 })
+}
+
+// Bursty is Size requests arriving at once every Every, starting at
+// Offset, as from batch jobs or clients retrying together. Bursts at
+// window boundaries reveal the boundary anomalies of fixed windows.
+type Bursty struct {
+	Size   int
+	Every  time.Duration
+	Offset time.Duration
+}
+
+// Arrivals implements Process.
+func (p Bursty) Arrivals(d time.Duration, _ *rand.Rand) []time.Duration {
+	if p.Size <= 0 || p.Every <= 0 {
+		return nil
+	}
+	var arrivals []time.Duration
+	for t := p.Offset; t < d; t += p.Every {
+		if t < 0 {
+			continue
+		}
+		for i := 0; i < p.Size; i++ {
+			arrivals = append(arrivals, t)
+		}
+	}
+	return arrivals
+}
+
+// Diurnal is Poisson arrivals whose rate follows a sine wave around Mean
+// requests per second, between Mean*(1-Amplitude) and
+// Mean*(1+Amplitude), peaking a quarter Period after the start, like the
+// daily cycle of user traffic.
+type Diurnal struct {
+	Mean float64
+
+	// Amplitude is the relative swing of the rate, from 0 to 1.
+	Amplitude float64
+
+	// Period is the length of a cycle. Defaults to 24 hours; shorter
+	// periods compress the cycle into shorter simulations.
+	Period time.Duration
+}
+
+// Arrivals implements Process.
+func (p Diurnal) Arrivals(d time.Duration, rng *rand.Rand) []time.Duration {
+	a := math.Max(0, math.Min(p.Amplitude, 1))
+	period := p.Period
+	if period <= 0 {
+		period = 24 * time.Hour
+	}
+	return thinned(d, rng, p.Mean*(1+a), func(t time.Duration) float64 {
+		phase := 2 * math.Pi * float64(t) / float64(period)
+		return (1 + a*math.Sin(phase)) / (1 + a)
+	})
+}
+
+// thinned draws Poisson arrivals at peak requests per second and keeps
+// each with the probability accept returns for its time, which gives a
+// Poisson process whose rate varies as peak*accept(t).
+func thinned(d time.Duration, rng *rand.Rand, peak float64, accept func(time.Duration) float64) []time.Duration {
+	if peak <= 0 {
+		return nil
+	}
+	var arrivals []time.Duration
+	t := 0.0
+	for {
+		t += rng.ExpFloat64() / peak
+		at := time.Duration(t * float64(time.Second))
+		if at >= d {
+			return arrivals
+		}
+		if rng.Float64() < accept(at) {
+			arrivals = append(arrivals, at)
+		}
+	}
+}
+
+// Merge superposes processes, for example bursts on top of Poisson
+// background traffic.
+func Merge(processes ...Process) Process {
+	return merged(processes)
+}
+
+type merged []Process
+
+// Arrivals implements Process.
+func (m merged) Arrivals(d time.Duration, rng *rand.Rand) []time.Duration {
+	var arrivals []time.Duration
+	for _, p := range m {
+		arrivals = append(arrivals, p.Arrivals(d, rng)...)
+	}
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i] < arrivals[j] })
+	return arrivals
+}
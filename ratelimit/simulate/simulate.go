@@ -0,0 +1,175 @@
+// Package simulate compares limiters deterministically by driving them
+// with synthetic arrival processes over virtual time:
+//
+//	results := simulate.Run(simulate.Config{
+//		Duration: time.Minute,
+//		Process:  simulate.Merge(simulate.Poisson{Rate: 80}, simulate.Bursty{Size: 100, Every: 10 * time.Second}),
+//	},
+//		simulate.Candidate{Name: "token bucket", Rate: 100, Period: time.Second, New: func(clock ratelimit.Clock) ratelimit.Limiter {
+//			return ratelimit.NewTokenBucket(ratelimit.WithRate(100), ratelimit.WithBurst(100), ratelimit.WithClock(clock))
+//		}},
+//		simulate.Candidate{Name: "fixed window", Rate: 100, Period: time.Second, New: func(clock ratelimit.Clock) ratelimit.Limiter {
+//			return ratelimit.NewFixedWindow(ratelimit.WithRate(100), ratelimit.WithClock(clock))
+//		}},
+//	)
+//	simulate.WriteTable(os.Stdout, results)
+//
+// Every candidate sees the same arrivals, generated from Config.Seed, and
+// time only moves from one arrival to the next on a clocktest.FakeClock,
+// so a simulation of a day takes as long as its requests and gives the
+// same results on every run.
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+// Config configures a simulation. Zero fields take their defaults.
+type Config struct {
+	// Duration is the virtual time simulated. Defaults to one minute.
+	Duration time.Duration
+
+	// Process generates the arrivals.
+	Process Process
+
+	// Seed seeds the random processes. The same seed gives the same
+	// arrivals.
+	Seed int64
+
+	// Window is the resolution of Result.Timeline and Result.PeakWindow.
+	// Defaults to one second.
+	Window time.Duration
+}
+
+// Candidate is a limiter under comparison.
+type Candidate struct {
+	Name string
+
+	// New creates the limiter. It must take all times from clock, for
+	// example through ratelimit.WithClock.
+	New func(clock ratelimit.Clock) ratelimit.Limiter
+
+	// Rate per Period is the limit the candidate is meant to enforce.
+	// Admissions that exceed it over any interval of Period are reported
+	// as anomalies; a zero Rate disables the check.
+	Rate   int
+	Period time.Duration
+}
+
+// Result is the outcome of a candidate.
+type Result struct {
+	Name string `json:"name"`
+
+	// Offered is the number of arrivals, of which Accepted were allowed
+	// and Rejected were not.
+	Offered  int `json:"offered"`
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+
+	// AcceptedRate is the accepted requests per second over the
+	// simulation.
+	AcceptedRate float64 `json:"accepted_rate"`
+
+	// PeakWindow is the most requests accepted in one Window of the
+	// timeline, and PeakPeriod the most accepted in any interval of the
+	// candidate's Period, which shows how far bursts overshoot the rate.
+	PeakWindow int `json:"peak_window"`
+	PeakPeriod int `json:"peak_period,omitempty"`
+
+	// Anomalies is the number of accepted requests that brought the
+	// admissions of the preceding Period over Rate, as happens when a
+	// fixed window admits a full window on both sides of a boundary.
+	// FirstAnomaly is the time of the first one.
+	Anomalies    int           `json:"anomalies"`
+	FirstAnomaly time.Duration `json:"first_anomaly,omitempty"`
+
+	// Timeline is the number of requests accepted in each Window.
+	Timeline []int `json:"timeline"`
+}
+
+// Run drives every candidate with the arrivals of config.Process and
+// returns their results in order.
+func Run(config Config, candidates ...Candidate) []Result {
+	if config.Duration <= 0 {
+		config.Duration = time.Minute
+	}
+	if config.Window <= 0 {
+		config.Window = time.Second
+	}
+	var arrivals []time.Duration
+	if config.Process != nil {
+		arrivals = config.Process.Arrivals(config.Duration, rand.New(rand.NewSource(config.Seed)))
+	}
+
+	results := make([]Result, len(candidates))
+	for i, c := range candidates {
+		results[i] = run(config, arrivals, c)
+	}
+	return results
+}
+
+// run drives one candidate.
+func run(config Config, arrivals []time.Duration, c Candidate) Result {
+	clock := clocktest.NewFakeClock(time.Time{})
+	start := clock.Now()
+	limiter := c.New(clock)
+
+	windows := int((config.Duration + config.Window - 1) / config.Window)
+	r := Result{Name: c.Name, Offered: len(arrivals), Timeline: make([]int, windows)}
+	var accepted []time.Duration
+	oldest := 0 // first accepted request within the Period before the current one
+	for _, at := range arrivals {
+		clock.Set(start.Add(at))
+		if !limiter.Allow() {
+			r.Rejected++
+			continue
+		}
+		r.Accepted++
+		r.Timeline[int(at/config.Window)]++
+
+		if c.Period <= 0 {
+			continue
+		}
+		accepted = append(accepted, at)
+		for accepted[oldest] <= at-c.Period {
+			oldest++
+		}
+		if oldest > len(accepted)/2 {
+			// Only the last Period is needed
+			accepted = append(accepted[:0], accepted[oldest:]...)
+			oldest = 0
+		}
+		inPeriod := len(accepted) - oldest
+		r.PeakPeriod = max(r.PeakPeriod, inPeriod)
+		if c.Rate > 0 && inPeriod > c.Rate {
+			if r.Anomalies == 0 {
+				r.FirstAnomaly = at
+			}
+			r.Anomalies++
+		}
+	}
+
+	for _, n := range r.Timeline {
+		r.PeakWindow = max(r.PeakWindow, n)
+	}
+	r.AcceptedRate = float64(r.Accepted) / config.Duration.Seconds()
+	return r
+}
+
+// WriteTable writes results as an aligned text table.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tOFFERED\tACCEPTED\tREJECTED\tRATE/S\tPEAK WINDOW\tPEAK PERIOD\tANOMALIES")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f\t%d\t%d\t%d\n",
+			r.Name, r.Offered, r.Accepted, r.Rejected, r.AcceptedRate, r.PeakWindow, r.PeakPeriod, r.Anomalies)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,146 @@
+package simulate_test
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/simulate"
+)
+
+func ms(ns ...int) []time.Duration {
+	d := make([]time.Duration, len(ns))
+	for i, n := range ns {
+		d[i] = time.Duration(n) * time.Millisecond
+	}
+	return d
+}
+
+func TestDeterministicProcesses(t *testing.T) {
+	tests := []struct {
+		name    string
+		process simulate.Process
+		d       time.Duration
+		want    []time.Duration
+	}{
+		{"constant", simulate.Constant{Rate: 4}, time.Second, ms(0, 250, 500, 750)},
+		{"constant without rate", simulate.Constant{}, time.Second, nil},
+		{"bursty", simulate.Bursty{Size: 2, Every: time.Second, Offset: 500 * time.Millisecond}, 2 * time.Second, ms(500, 500, 1500, 1500)},
+		{"bursty before start", simulate.Bursty{Size: 1, Every: time.Second, Offset: -500 * time.Millisecond}, 2 * time.Second, ms(500, 1500)},
+		{"bursty without size", simulate.Bursty{Every: time.Second}, time.Second, nil},
+		{"merged", simulate.Merge(simulate.Constant{Rate: 2}, simulate.Bursty{Size: 1, Every: time.Second, Offset: 200 * time.Millisecond}), time.Second, ms(0, 200, 500)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.process.Arrivals(tt.d, rand.New(rand.NewSource(1)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Arrivals = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomProcesses(t *testing.T) {
+	const d = 100 * time.Second
+	tests := []struct {
+		name    string
+		process simulate.Process
+		// first and second are the expected requests in each half of d.
+		first, second float64
+	}{
+		{"poisson", simulate.Poisson{Rate: 50}, 2500, 2500},
+		// The rate is 50±40 over a cycle of d, above the mean in the
+		// first half and below it in the second.
+		{"diurnal", simulate.Diurnal{Mean: 50, Amplitude: 0.8, Period: d}, 2500 + 4000/math.Pi, 2500 - 4000/math.Pi},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.process.Arrivals(d, rand.New(rand.NewSource(1)))
+			if again := tt.process.Arrivals(d, rand.New(rand.NewSource(1))); !reflect.DeepEqual(got, again) {
+				t.Error("the same seed gave different arrivals")
+			}
+			var halves [2]float64
+			for i, at := range got {
+				if at < 0 || at >= d || i > 0 && at < got[i-1] {
+					t.Fatalf("arrival %d at %v is out of order or range", i, at)
+				}
+				halves[at/(d/2)]++
+			}
+			for i, want := range []float64{tt.first, tt.second} {
+				if halves[i] < want*0.9 || halves[i] > want*1.1 {
+					t.Errorf("half %d has %g arrivals, want about %.0f", i+1, halves[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunComparesCandidates(t *testing.T) {
+	// Two bursts of 100 straddle the boundary of a one second window.
+	config := simulate.Config{
+		Duration: 3 * time.Second,
+		Process: simulate.Merge(
+			simulate.Bursty{Size: 100, Every: time.Minute, Offset: 900 * time.Millisecond},
+			simulate.Bursty{Size: 100, Every: time.Minute, Offset: time.Second},
+		),
+	}
+	results := simulate.Run(config,
+		simulate.Candidate{Name: "token bucket", Rate: 200, Period: time.Second, New: func(clock ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewTokenBucket(ratelimit.WithRate(100), ratelimit.WithBurst(100), ratelimit.WithClock(clock))
+		}},
+		simulate.Candidate{Name: "fixed window", Rate: 100, Period: time.Second, New: func(clock ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(100), ratelimit.WithBurst(100), ratelimit.WithClock(clock))
+		}},
+	)
+
+	want := []simulate.Result{
+		{
+			Name: "token bucket", Offered: 200, Accepted: 110, Rejected: 90, AcceptedRate: 110.0 / 3,
+			PeakWindow: 100, PeakPeriod: 110, Timeline: []int{100, 10, 0},
+		},
+		{
+			Name: "fixed window", Offered: 200, Accepted: 200, AcceptedRate: 200.0 / 3,
+			PeakWindow: 100, PeakPeriod: 200, Anomalies: 100, FirstAnomaly: time.Second, Timeline: []int{100, 100, 0},
+		},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Run =\n%+v\nwant\n%+v", results, want)
+	}
+}
+
+func TestRunDefaults(t *testing.T) {
+	results := simulate.Run(simulate.Config{Duration: 2500 * time.Millisecond},
+		simulate.Candidate{Name: "idle", New: func(ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewTokenBucket(ratelimit.WithRate(1), ratelimit.WithBurst(1))
+		}},
+	)
+	// Without a process nothing arrives; the last window is partial.
+	if r := results[0]; r.Offered != 0 || len(r.Timeline) != 3 {
+		t.Errorf("result = %+v, want no arrivals over 3 windows", r)
+	}
+	if r := simulate.Run(simulate.Config{}); len(r) != 0 {
+		t.Errorf("Run without candidates = %v", r)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	err := simulate.WriteTable(&buf, []simulate.Result{
+		{Name: "token bucket", Offered: 10, Accepted: 7, Rejected: 3, AcceptedRate: 0.5, PeakWindow: 2, PeakPeriod: 4, Anomalies: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "NAME") {
+		t.Fatalf("table = %q", buf.String())
+	}
+	if got := strings.Fields(lines[1]); !reflect.DeepEqual(got, []string{"token", "bucket", "10", "7", "3", "0.50", "2", "4", "1"}) {
+		t.Errorf("row = %q", got)
+	}
+}
@@ -0,0 +1,523 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogEntry is a single admitted request recorded by SlidingLog.
+type LogEntry struct {
+	Time   time.Time
+	Key    string
+	Weight int
+}
+
+// SlidingLog implements the sliding log rate limiting algorithm.
+// It records every admitted request per key, which gives exact limiting
+// and an audit trail available through Entries. Keys whose traffic exceeds
+// Config.BucketThreshold entries per window switch to a bucketed counter
+// to bound memory; their audit trail is then kept at bucket granularity.
+//
+// SlidingLog itself implements Limiter for the empty key; use Key to obtain
+// a Limiter bound to a specific key.
+type SlidingLog struct {
+	config     *Config
+	logs       map[string]*keyLog
+	bucketSize time.Duration
+	lastSweep  time.Time
+	jitter     *jitter
+	denials    *denialLogger
+	mu         sync.Mutex
+}
+
+// keyLog holds the recorded requests of one key.
+type keyLog struct {
+	entries logRing
+	// buckets is non-nil once the key has switched to the bucketed backend.
+	buckets map[int64]int
+}
+
+// NewSlidingLog creates a new SlidingLog rate limiter.
+func NewSlidingLog(opts ...Option) *SlidingLog {
+	cfg := NewConfig(opts...)
+
+	if cfg.Retention < cfg.Period {
+		cfg.Retention = cfg.Period
+	}
+
+	bucketSize := cfg.Period / 60
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+
+	return &SlidingLog{
+		config:     cfg,
+		logs:       make(map[string]*keyLog),
+		bucketSize: bucketSize,
+		lastSweep:  cfg.Clock.Now(),
+		jitter:     newJitter(cfg),
+		denials:    newDenialLogger(cfg.Logger, cfg.LogSampleRate),
+	}
+}
+
+// Allow checks if a single request can proceed.
+func (sl *SlidingLog) Allow() bool {
+	return sl.AllowKeyN("", 1)
+}
+
+// AllowN checks if n requests can proceed.
+func (sl *SlidingLog) AllowN(n int) bool {
+	return sl.AllowKeyN("", n)
+}
+
+// Wait blocks until a request can proceed or context is cancelled.
+func (sl *SlidingLog) Wait(ctx context.Context) error {
+	return sl.WaitKeyN(ctx, "", 1)
+}
+
+// WaitN blocks until n requests can proceed or context is cancelled.
+func (sl *SlidingLog) WaitN(ctx context.Context, n int) error {
+	return sl.WaitKeyN(ctx, "", n)
+}
+
+// Reset clears the log of every key.
+func (sl *SlidingLog) Reset() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.logs = make(map[string]*keyLog)
+}
+
+// Available returns the number of requests the empty key may still make.
+func (sl *SlidingLog) Available() int {
+	return sl.AvailableKey("")
+}
+
+// AllowKey checks if a single request for key can proceed.
+func (sl *SlidingLog) AllowKey(key string) bool {
+	return sl.AllowKeyN(key, 1)
+}
+
+// AllowKeyN checks if n requests for key can proceed and records them.
+func (sl *SlidingLog) AllowKeyN(key string, n int) bool {
+	sl.mu.Lock()
+	ok, _ := sl.tryAcquire(key, n)
+	sl.mu.Unlock()
+
+	if !ok {
+		sl.denials.denied(key, func() error { return sl.CheckKeyN(key, n) })
+	}
+	return ok
+}
+
+// WaitKeyN blocks until n requests for key can proceed or context is cancelled.
+func (sl *SlidingLog) WaitKeyN(ctx context.Context, key string, n int) error {
+	sl.mu.Lock()
+	rate := sl.config.Rate
+	sl.mu.Unlock()
+	if n > rate {
+		return fmt.Errorf("requested %d exceeds rate limit %d", n, rate)
+	}
+
+	// A context that is already done never consumes capacity
+	if err := ctx.Err(); err != nil {
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		return deadlineError(err, func() *ErrLimited { return sl.limited(key, n) })
+	}
+
+	for {
+		sl.mu.Lock()
+		ok, waitDuration := sl.tryAcquire(key, n)
+		waitDuration = sl.jitter.apply(waitDuration)
+		sl.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		// Wait with context
+		select {
+		case <-ctx.Done():
+			sl.mu.Lock()
+			defer sl.mu.Unlock()
+			return deadlineError(ctx.Err(), func() *ErrLimited { return sl.limited(key, n) })
+		case <-sl.config.Clock.After(waitDuration):
+			// Continue to next iteration
+		}
+	}
+}
+
+// Check returns nil if a single request for the empty key would be
+// admitted now, otherwise an *ErrLimited describing when to retry.
+func (sl *SlidingLog) Check() error {
+	return sl.CheckKeyN("", 1)
+}
+
+// CheckN is CheckKeyN for the empty key.
+func (sl *SlidingLog) CheckN(n int) error {
+	return sl.CheckKeyN("", n)
+}
+
+// CheckKeyN returns nil if n requests for key would be admitted now,
+// otherwise an *ErrLimited describing when to retry. It records nothing.
+func (sl *SlidingLog) CheckKeyN(key string, n int) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	return checkResult(sl.limited(key, n))
+}
+
+// AvailableKey returns the number of requests key may still make in the
+// current window.
+func (sl *SlidingLog) AvailableKey(key string) int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := sl.config.Clock.Now()
+	log, exists := sl.logs[key]
+	if !exists {
+		return sl.config.Rate
+	}
+
+	sl.prune(log, now)
+	available := sl.config.Rate - sl.count(log, now)
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// ResetKey clears the log of a single key.
+func (sl *SlidingLog) ResetKey(key string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	delete(sl.logs, key)
+}
+
+// Entries returns the recorded requests of key within the retention period,
+// oldest first. For keys on the bucketed backend, each entry summarises one
+// bucket: Time is the bucket start and Weight the total admitted in it.
+func (sl *SlidingLog) Entries(key string) []LogEntry {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	log, exists := sl.logs[key]
+	if !exists {
+		return nil
+	}
+	sl.prune(log, sl.config.Clock.Now())
+
+	entries := log.entries.appendTo(make([]LogEntry, 0, log.entries.len()+len(log.buckets)))
+
+	if log.buckets != nil {
+		ids := make([]int64, 0, len(log.buckets))
+		for id := range log.buckets {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for _, id := range ids {
+			entries = append(entries, LogEntry{
+				Time:   time.Unix(0, id*int64(sl.bucketSize)),
+				Key:    key,
+				Weight: log.buckets[id],
+			})
+		}
+	}
+
+	return entries
+}
+
+// Keys returns the keys that currently have recorded requests.
+func (sl *SlidingLog) Keys() []string {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	keys := make([]string, 0, len(sl.logs))
+	for key := range sl.logs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Key returns a Limiter that applies the sliding log to a single key.
+func (sl *SlidingLog) Key(key string) Limiter {
+	return &slidingLogKey{log: sl, key: key}
+}
+
+// tryAcquire records n requests for key if they fit in the window,
+// otherwise it returns how long until they might.
+func (sl *SlidingLog) tryAcquire(key string, n int) (bool, time.Duration) {
+	now := sl.config.Clock.Now()
+	sl.sweep(now)
+
+	log, exists := sl.logs[key]
+	if !exists {
+		log = &keyLog{}
+		sl.logs[key] = log
+	}
+	sl.prune(log, now)
+
+	current := sl.count(log, now)
+	if current+n > sl.config.Rate {
+		return false, sl.waitDuration(log, now, current+n-sl.config.Rate)
+	}
+
+	if log.buckets != nil {
+		log.buckets[sl.bucketID(now)] += n
+		return true, 0
+	}
+
+	log.entries.push(LogEntry{
+		Time:   now,
+		Key:    key,
+		Weight: n,
+	})
+
+	if threshold := sl.config.BucketThreshold; threshold > 0 && log.entries.window > threshold {
+		sl.convertToBuckets(log)
+	}
+
+	return true, 0
+}
+
+// limited describes why n requests for key cannot be admitted now, or
+// returns nil if they can. The caller must hold sl.mu.
+func (sl *SlidingLog) limited(key string, n int) *ErrLimited {
+	log, exists := sl.logs[key]
+	if !exists {
+		if n <= sl.config.Rate {
+			return nil
+		}
+		log = &keyLog{}
+	}
+
+	now := sl.config.Clock.Now()
+	sl.prune(log, now)
+
+	current := sl.count(log, now)
+	if current+n <= sl.config.Rate {
+		return nil
+	}
+
+	remaining := sl.config.Rate - current
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &ErrLimited{
+		RetryAfter: sl.waitDuration(log, now, current+n-sl.config.Rate),
+		Limit:      sl.config.Rate,
+		Remaining:  remaining,
+	}
+}
+
+// windowStart returns the start of the limiting window ending at now.
+func (sl *SlidingLog) windowStart(now time.Time) time.Time {
+	return now.Add(-sl.config.Period)
+}
+
+// bucketID returns the bucket that t falls into.
+func (sl *SlidingLog) bucketID(t time.Time) int64 {
+	return t.UnixNano() / int64(sl.bucketSize)
+}
+
+// count returns the weight admitted for log within the current window.
+func (sl *SlidingLog) count(log *keyLog, now time.Time) int {
+	windowStart := sl.windowStart(now)
+
+	log.entries.advance(windowStart)
+	count := log.entries.total
+
+	// A bucket partially inside the window is counted in full, which errs
+	// on the side of rejecting.
+	startID := sl.bucketID(windowStart)
+	for id, c := range log.buckets {
+		if id >= startID {
+			count += c
+		}
+	}
+
+	return count
+}
+
+// waitDuration returns how long until at least excess weight leaves the window.
+func (sl *SlidingLog) waitDuration(log *keyLog, now time.Time, excess int) time.Duration {
+	windowStart := sl.windowStart(now)
+
+	type slot struct {
+		expires time.Time
+		weight  int
+	}
+	var slots []slot
+
+	log.entries.advance(windowStart)
+	for i := log.entries.len() - log.entries.window; i < log.entries.len(); i++ {
+		e := log.entries.at(i)
+		slots = append(slots, slot{e.Time.Add(sl.config.Period), e.Weight})
+	}
+	startID := sl.bucketID(windowStart)
+	for id, c := range log.buckets {
+		if id >= startID {
+			end := time.Unix(0, (id+1)*int64(sl.bucketSize))
+			slots = append(slots, slot{end.Add(sl.config.Period), c})
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].expires.Before(slots[j].expires) })
+
+	freed := 0
+	for _, s := range slots {
+		freed += s.weight
+		if freed >= excess {
+			return s.expires.Sub(now)
+		}
+	}
+	return time.Millisecond * 10 // Small wait if nothing will expire
+}
+
+// prune drops entries and buckets that are older than the retention period.
+func (sl *SlidingLog) prune(log *keyLog, now time.Time) {
+	cutoff := now.Add(-sl.config.Retention)
+
+	for log.entries.len() > 0 && !log.entries.at(0).Time.After(cutoff) {
+		log.entries.pop()
+	}
+
+	cutoffID := sl.bucketID(cutoff)
+	for id := range log.buckets {
+		if id < cutoffID {
+			delete(log.buckets, id)
+		}
+	}
+}
+
+// sweep removes keys with nothing left to retain. It runs at most once
+// per Period so that idle keys do not accumulate.
+func (sl *SlidingLog) sweep(now time.Time) {
+	if now.Sub(sl.lastSweep) < sl.config.Period {
+		return
+	}
+	sl.lastSweep = now
+
+	for key, log := range sl.logs {
+		sl.prune(log, now)
+		if log.entries.len() == 0 && len(log.buckets) == 0 {
+			delete(sl.logs, key)
+		}
+	}
+}
+
+// convertToBuckets moves a high-traffic key to the bucketed backend.
+func (sl *SlidingLog) convertToBuckets(log *keyLog) {
+	log.buckets = make(map[int64]int)
+	for i := 0; i < log.entries.len(); i++ {
+		e := log.entries.at(i)
+		log.buckets[sl.bucketID(e.Time)] += e.Weight
+	}
+	log.entries = logRing{}
+}
+
+// logRing is a FIFO of log entries in a ring buffer. It tracks the entries
+// at its tail that are within the limiting window and their total weight,
+// so that counting and pruning take amortized constant time.
+type logRing struct {
+	buf    []LogEntry
+	head   int // index of the oldest entry
+	n      int
+	window int // number of newest entries within the window
+	total  int // weight of those entries
+}
+
+// push appends e, which is within the window.
+func (r *logRing) push(e LogEntry) {
+	if r.n == len(r.buf) {
+		buf := make([]LogEntry, max(2*len(r.buf), 8))
+		for i := 0; i < r.n; i++ {
+			buf[i] = *r.at(i)
+		}
+		r.buf, r.head = buf, 0
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = e
+	r.n++
+	r.window++
+	r.total += e.Weight
+}
+
+// pop removes the oldest entry.
+func (r *logRing) pop() {
+	if r.window == r.n {
+		r.window--
+		r.total -= r.buf[r.head].Weight
+	}
+	r.buf[r.head] = LogEntry{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+}
+
+// advance moves the window past the entries at or before windowStart.
+func (r *logRing) advance(windowStart time.Time) {
+	for r.window > 0 {
+		e := r.at(r.n - r.window)
+		if e.Time.After(windowStart) {
+			return
+		}
+		r.window--
+		r.total -= e.Weight
+	}
+}
+
+// rewindow recomputes the window from scratch, for when it may have grown.
+func (r *logRing) rewindow(windowStart time.Time) {
+	r.window, r.total = 0, 0
+	for i := r.n - 1; i >= 0 && r.at(i).Time.After(windowStart); i-- {
+		r.window++
+		r.total += r.at(i).Weight
+	}
+}
+
+// at returns the i-th oldest entry.
+func (r *logRing) at(i int) *LogEntry {
+	return &r.buf[(r.head+i)%len(r.buf)]
+}
+
+// len returns the number of entries.
+func (r *logRing) len() int {
+	return r.n
+}
+
+// appendTo appends the entries to dst, oldest first.
+func (r *logRing) appendTo(dst []LogEntry) []LogEntry {
+	for i := 0; i < r.n; i++ {
+		dst = append(dst, *r.at(i))
+	}
+	return dst
+}
+
+// slidingLogKey is a Limiter bound to one key of a SlidingLog.
+type slidingLogKey struct {
+	log *SlidingLog
+	key string
+}
+
+func (k *slidingLogKey) Allow() bool { return k.log.AllowKeyN(k.key, 1) }
+
+func (k *slidingLogKey) AllowN(n int) bool { return k.log.AllowKeyN(k.key, n) }
+
+func (k *slidingLogKey) Wait(ctx context.Context) error { return k.log.WaitKeyN(ctx, k.key, 1) }
+
+func (k *slidingLogKey) WaitN(ctx context.Context, n int) error {
+	return k.log.WaitKeyN(ctx, k.key, n)
+}
+
+func (k *slidingLogKey) Reset() { k.log.ResetKey(k.key) }
+
+func (k *slidingLogKey) Available() int { return k.log.AvailableKey(k.key) }
+
+func (k *slidingLogKey) Check() error { return k.log.CheckKeyN(k.key, 1) }
+
+func (k *slidingLogKey) CheckN(n int) error { return k.log.CheckKeyN(k.key, n) }
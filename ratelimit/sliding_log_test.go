@@ -0,0 +1,102 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+func TestSlidingLogWindow(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	sl := ratelimit.NewSlidingLog(
+		ratelimit.WithRate(3),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithRetention(time.Minute),
+		ratelimit.WithClock(clock),
+	)
+
+	for i := 0; i < 3; i++ {
+		if !sl.AllowKey("a") {
+			t.Fatalf("request %d denied", i)
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+	if sl.AllowKey("a") {
+		t.Fatal("request over the limit admitted")
+	}
+
+	// The first request leaves the window one period after it was made
+	clock.Advance(700 * time.Millisecond)
+	if got := sl.AvailableKey("a"); got != 1 {
+		t.Fatalf("AvailableKey = %d, want 1", got)
+	}
+	if !sl.AllowKey("a") {
+		t.Fatal("request denied after the oldest left the window")
+	}
+
+	// Requests outside the window stay in the log until the retention ends
+	clock.Advance(10 * time.Second)
+	if got := sl.AvailableKey("a"); got != 3 {
+		t.Fatalf("AvailableKey = %d, want 3", got)
+	}
+	if got := len(sl.Entries("a")); got != 4 {
+		t.Fatalf("len(Entries) = %d, want 4", got)
+	}
+	clock.Advance(time.Minute)
+	if got := len(sl.Entries("a")); got != 0 {
+		t.Fatalf("len(Entries) = %d after retention, want 0", got)
+	}
+}
+
+func TestSlidingLogReconfigureLongerPeriod(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	sl := ratelimit.NewSlidingLog(
+		ratelimit.WithRate(2),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithRetention(time.Minute),
+		ratelimit.WithClock(clock),
+	)
+
+	sl.AllowKeyN("a", 2)
+	clock.Advance(2 * time.Second)
+	if got := sl.AvailableKey("a"); got != 2 {
+		t.Fatalf("AvailableKey = %d, want 2", got)
+	}
+
+	// The logged requests are back within a ten second window
+	sl.Reconfigure(2, 10*time.Second, 0)
+	if got := sl.AvailableKey("a"); got != 0 {
+		t.Fatalf("AvailableKey = %d after Reconfigure, want 0", got)
+	}
+}
+
+func TestSlidingLogRestore(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	opts := []ratelimit.Option{
+		ratelimit.WithRate(5),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithRetention(time.Minute),
+		ratelimit.WithClock(clock),
+	}
+	sl := ratelimit.NewSlidingLog(opts...)
+	sl.AllowKeyN("a", 2)
+	clock.Advance(2 * time.Second)
+	sl.AllowKeyN("a", 3)
+
+	data, err := sl.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := ratelimit.NewSlidingLog(opts...)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.AvailableKey("a"); got != 2 {
+		t.Errorf("AvailableKey = %d, want 2", got)
+	}
+	if got := len(restored.Entries("a")); got != 2 {
+		t.Errorf("len(Entries) = %d, want 2", got)
+	}
+}
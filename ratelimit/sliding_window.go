@@ -1,7 +1,6 @@
 package ratelimit
 
 import (
-	"container/list"
 	"context"
 	"fmt"
 	"sync"
@@ -10,11 +9,17 @@ import (
 
 // SlidingWindow implements the sliding window rate limiting algorithm.
 // It provides more accurate rate limiting than fixed window by tracking
-// individual request timestamps.
+// individual request timestamps. The timestamps are kept in a ring buffer
+// that grows to the most requests ever held in the window, so Allow does
+// not allocate once it has.
 type SlidingWindow struct {
 	config    *Config
-	requests  *list.List
+	requests  requestRing
 	mu        sync.Mutex
+	waiters   waitQueue
+	decay     decay
+	jitter    *jitter
+	denials   *denialLogger
 }
 
 // requestTime represents a request with its timestamp and count.
@@ -29,7 +34,10 @@ func NewSlidingWindow(opts ...Option) *SlidingWindow {
 	
 	return &SlidingWindow{
 		config:   cfg,
-		requests: list.New(),
+		waiters:  waitQueue{edf: cfg.Admission == AdmissionEDF},
+		decay:    decay{period: cfg.DecayReset},
+		jitter:   newJitter(cfg),
+		denials:  newDenialLogger(cfg.Logger, cfg.LogSampleRate),
 	}
 }
 
@@ -39,77 +47,143 @@ func (sw *SlidingWindow) Allow() bool {
 }
 
 // AllowN checks if n requests can proceed.
+// It never succeeds ahead of callers already blocked in WaitN.
 func (sw *SlidingWindow) AllowN(n int) bool {
+	if sw.allowN(n) {
+		return true
+	}
+	sw.denials.denied("", func() error { return sw.CheckN(n) })
+	return false
+}
+
+// allowN is AllowN without logging.
+func (sw *SlidingWindow) allowN(n int) bool {
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 	
 	now := sw.config.Clock.Now()
 	sw.removeOldRequests(now)
 	
-	currentCount := sw.countRequests()
-	if currentCount+n <= sw.config.Rate {
-		sw.requests.PushBack(&requestTime{
-			time:  now,
-			count: n,
-		})
+	currentCount := sw.used(now)
+	if sw.waiters.Len() == 0 && currentCount+n <= sw.config.Rate {
+		sw.requests.push(now, n)
 		return true
 	}
 	
 	return false
 }
 
+// AllowUpTo admits as many of n requests as the window has room for, up
+// to n, and returns how many. While callers are waiting it admits none.
+func (sw *SlidingWindow) AllowUpTo(n int) int {
+	if granted := sw.allowUpTo(n); granted > 0 || n <= 0 {
+		return granted
+	}
+	sw.denials.denied("", sw.Check)
+	return 0
+}
+
+// allowUpTo is AllowUpTo without logging.
+func (sw *SlidingWindow) allowUpTo(n int) int {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	
+	now := sw.config.Clock.Now()
+	sw.removeOldRequests(now)
+	
+	granted := sw.config.Rate - sw.used(now)
+	if granted > n {
+		granted = n
+	}
+	if sw.waiters.Len() > 0 || granted <= 0 {
+		return 0
+	}
+	sw.requests.push(now, granted)
+	return granted
+}
+
 // Wait blocks until a request can proceed or context is cancelled.
 func (sw *SlidingWindow) Wait(ctx context.Context) error {
 	return sw.WaitN(ctx, 1)
 }
 
 // WaitN blocks until n requests can proceed or context is cancelled.
+// Blocked callers are served in order of priority (see ContextWithPriority)
+// and then arrival, so large requests are not starved by smaller ones.
 func (sw *SlidingWindow) WaitN(ctx context.Context, n int) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	
 	if n > sw.config.Rate {
 		return fmt.Errorf("requested %d exceeds rate limit %d", n, sw.config.Rate)
 	}
 	
-	for {
-		sw.mu.Lock()
-		now := sw.config.Clock.Now()
-		sw.removeOldRequests(now)
-		
-		currentCount := sw.countRequests()
-		if currentCount+n <= sw.config.Rate {
-			sw.requests.PushBack(&requestTime{
-				time:  now,
-				count: n,
-			})
-			sw.mu.Unlock()
-			return nil
-		}
-		
-		// Calculate wait time based on oldest request
-		var waitDuration time.Duration
-		if sw.requests.Len() > 0 {
-			oldest := sw.requests.Front().Value.(*requestTime)
-			waitDuration = sw.config.Period - now.Sub(oldest.time)
-		} else {
-			waitDuration = time.Millisecond * 10 // Small wait if no requests
-		}
-		sw.mu.Unlock()
-		
-		// Wait with context
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-sw.config.Clock.After(waitDuration):
-			// Continue to next iteration
-		}
+	return waitTurn(ctx, &sw.mu, &sw.waiters, sw.config.Clock, sw.jitter, n, sw)
+}
+
+// tryAcquire records n requests if they fit in the window, otherwise it
+// returns how long until they might. The caller must hold sw.mu.
+func (sw *SlidingWindow) tryAcquire(n int) (bool, time.Duration) {
+	now := sw.config.Clock.Now()
+	sw.removeOldRequests(now)
+	
+	currentCount := sw.used(now)
+	if currentCount+n <= sw.config.Rate {
+		sw.requests.push(now, n)
+		return true, 0
+	}
+	
+	return false, sw.waitDuration(now, currentCount+n-sw.config.Rate)
+}
+
+// Check returns nil if a single request would be admitted now, otherwise
+// an *ErrLimited describing when to retry.
+func (sw *SlidingWindow) Check() error {
+	return sw.CheckN(1)
+}
+
+// CheckN returns nil if n requests would be admitted now, otherwise an
+// *ErrLimited describing when to retry. It records no requests.
+func (sw *SlidingWindow) CheckN(n int) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	
+	return checkResult(sw.limited(n))
+}
+
+// limited describes why n requests cannot be admitted now, or returns nil
+// if they can. Capacity promised to queued waiters counts as used.
+// The caller must hold sw.mu.
+func (sw *SlidingWindow) limited(n int) *ErrLimited {
+	now := sw.config.Clock.Now()
+	sw.removeOldRequests(now)
+	
+	used := sw.used(now) + sw.waiters.units
+	if used+n <= sw.config.Rate {
+		return nil
+	}
+	
+	remaining := sw.config.Rate - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &ErrLimited{
+		RetryAfter: sw.waitDuration(now, used+n-sw.config.Rate),
+		Limit:      sw.config.Rate,
+		Remaining:  remaining,
 	}
 }
 
-// Reset resets the rate limiter to its initial state.
+// Reset resets the rate limiter to its initial state. With DecayReset the
+// requests in the window are released gradually.
 func (sw *SlidingWindow) Reset() {
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 	
-	sw.requests.Init()
+	now := sw.config.Clock.Now()
+	sw.removeOldRequests(now)
+	sw.decay.begin(float64(sw.countRequests()), now)
+	sw.requests.reset()
 }
 
 // Available returns the number of available requests in the current window.
@@ -120,7 +194,7 @@ func (sw *SlidingWindow) Available() int {
 	now := sw.config.Clock.Now()
 	sw.removeOldRequests(now)
 	
-	available := sw.config.Rate - sw.countRequests()
+	available := sw.config.Rate - sw.used(now)
 	if available < 0 {
 		return 0
 	}
@@ -132,24 +206,107 @@ func (sw *SlidingWindow) removeOldRequests(now time.Time) {
 	windowStart := now.Add(-sw.config.Period)
 	
 	// Remove all requests older than the window
-	for sw.requests.Len() > 0 {
-		front := sw.requests.Front()
-		req := front.Value.(*requestTime)
-		
-		if req.time.Before(windowStart) {
-			sw.requests.Remove(front)
-		} else {
-			break
+	for sw.requests.len() > 0 && sw.requests.at(0).time.Before(windowStart) {
+		sw.requests.pop()
+	}
+}
+
+// waitDuration returns how long until at least excess requests have left
+// the window or been released by a DecayReset.
+func (sw *SlidingWindow) waitDuration(now time.Time, excess int) time.Duration {
+	left := float64(sw.decay.units(now))
+	freed := 0
+	for i := 0; i < sw.requests.len(); i++ {
+		req := sw.requests.at(i)
+		if need := float64(excess - freed); need <= left {
+			at, _ := sw.decay.until(left - need)
+			if at.Before(req.time.Add(sw.config.Period)) {
+				return sw.decayWait(at, now)
+			}
+		}
+		freed += req.count
+		if freed >= excess {
+			// removeOldRequests keeps entries until they are strictly older
+			// than the window start, hence the extra nanosecond.
+			return req.time.Add(sw.config.Period).Sub(now) + time.Nanosecond
 		}
 	}
+	if need := float64(excess - freed); need <= left {
+		at, _ := sw.decay.until(left - need)
+		return sw.decayWait(at, now)
+	}
+	return time.Millisecond * 10 // Small wait if no requests
+}
+
+// decayWait returns the wait until at, at least a millisecond.
+func (sw *SlidingWindow) decayWait(at, now time.Time) time.Duration {
+	if d := at.Sub(now); d > time.Millisecond {
+		return d
+	}
+	return time.Millisecond
 }
 
-// countRequests counts the total number of requests in the list.
+// used returns the requests counted in the window, including those a
+// DecayReset has not released yet.
+func (sw *SlidingWindow) used(now time.Time) int {
+	return sw.countRequests() + sw.decay.units(now)
+}
+
+// countRequests counts the total number of requests in the window.
 func (sw *SlidingWindow) countRequests() int {
-	count := 0
-	for e := sw.requests.Front(); e != nil; e = e.Next() {
-		req := e.Value.(*requestTime)
-		count += req.count
+	return sw.requests.total
+}
+
+// requestRing is a FIFO of requests in a ring buffer, with their total
+// count.
+type requestRing struct {
+	buf   []requestTime
+	head  int // index of the oldest request
+	n     int
+	total int
+}
+
+// push appends n requests at t, merging them into the newest entry if it
+// has the same time.
+func (r *requestRing) push(t time.Time, n int) {
+	r.total += n
+	if r.n > 0 {
+		if last := r.at(r.n - 1); last.time.Equal(t) {
+			last.count += n
+			return
+		}
 	}
-	return count
+	if r.n == len(r.buf) {
+		buf := make([]requestTime, max(2*len(r.buf), 8))
+		for i := 0; i < r.n; i++ {
+			buf[i] = *r.at(i)
+		}
+		r.buf, r.head = buf, 0
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = requestTime{time: t, count: n}
+	r.n++
+}
+
+// pop removes the oldest entry.
+func (r *requestRing) pop() {
+	r.total -= r.buf[r.head].count
+	r.buf[r.head] = requestTime{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+}
+
+// at returns the i-th oldest entry.
+func (r *requestRing) at(i int) *requestTime {
+	return &r.buf[(r.head+i)%len(r.buf)]
+}
+
+// len returns the number of entries.
+func (r *requestRing) len() int {
+	return r.n
+}
+
+// reset removes every entry, keeping the buffer.
+func (r *requestRing) reset() {
+	clear(r.buf)
+	r.head, r.n, r.total = 0, 0, 0
 }
\ No newline at end of file
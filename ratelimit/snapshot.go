@@ -0,0 +1,292 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Snapshotter is implemented by limiters whose state can be persisted and
+// restored, for example across a rolling restart, so that clients do not
+// receive a fresh burst every time the process starts.
+type Snapshotter interface {
+	// Snapshot encodes the current limiter state.
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the limiter state with a previously taken snapshot.
+	Restore(data []byte) error
+}
+
+// snapshot is the envelope written by Snapshot. Algorithm guards against
+// restoring a snapshot into a limiter of a different kind.
+type snapshot struct {
+	Algorithm string          `json:"algorithm"`
+	TakenAt   time.Time       `json:"taken_at"`
+	State     json.RawMessage `json:"state"`
+}
+
+// Algorithm names used in snapshots.
+const (
+	algorithmTokenBucket   = "token_bucket"
+	algorithmFixedWindow   = "fixed_window"
+	algorithmSlidingWindow = "sliding_window"
+	algorithmSlidingLog    = "sliding_log"
+)
+
+func encodeSnapshot(algorithm string, now time.Time, state interface{}) ([]byte, error) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(snapshot{
+		Algorithm: algorithm,
+		TakenAt:   now,
+		State:     raw,
+	})
+}
+
+func decodeSnapshot(algorithm string, data []byte, state interface{}) error {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Algorithm != algorithm {
+		return fmt.Errorf("snapshot is for %q, not %q", snap.Algorithm, algorithm)
+	}
+	if err := json.Unmarshal(snap.State, state); err != nil {
+		return fmt.Errorf("decode %s state: %w", algorithm, err)
+	}
+	return nil
+}
+
+// TokenBucketState is the persisted state of a TokenBucket.
+type TokenBucketState struct {
+	Tokens     float64   `json:"tokens"`
+	LastRefill time.Time `json:"last_refill"`
+
+	// Warm-up mode state; zero unless the bucket uses WithWarmup.
+	StoredPermits float64   `json:"stored_permits,omitempty"`
+	NextFree      time.Time `json:"next_free,omitempty"`
+
+	// Tokens a DecayReset has not released yet, counted from DecayStart.
+	Decaying   float64   `json:"decaying,omitempty"`
+	DecayStart time.Time `json:"decay_start,omitempty"`
+
+	// NextPaced is when WithPacing admits the next request.
+	NextPaced time.Time `json:"next_paced,omitempty"`
+}
+
+// Snapshot encodes the current state of the bucket.
+func (tb *TokenBucket) Snapshot() ([]byte, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	state := TokenBucketState{
+		Tokens:     tb.tokens,
+		LastRefill: tb.lastRefill,
+		Decaying:   tb.decay.from,
+		DecayStart: tb.decay.start,
+		NextPaced:  tb.nextPaced,
+	}
+	if tb.warmup != nil {
+		state.StoredPermits = tb.warmup.storedPermits
+		state.NextFree = tb.warmup.nextFree
+	}
+
+	return encodeSnapshot(algorithmTokenBucket, tb.config.Clock.Now(), state)
+}
+
+// Restore replaces the bucket state with a snapshot. Tokens are capped at
+// the current burst size, and refill, decay and pacing resume from the
+// recorded times, none of which may lie in the future.
+func (tb *TokenBucket) Restore(data []byte) error {
+	var state TokenBucketState
+	if err := decodeSnapshot(algorithmTokenBucket, data, &state); err != nil {
+		return err
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.tokens = min(state.Tokens, float64(tb.config.Burst))
+	if tb.tokens < 0 {
+		tb.tokens = 0
+	}
+	tb.lastRefill = state.LastRefill
+	now := tb.config.Clock.Now()
+	if tb.lastRefill.After(now) {
+		tb.lastRefill = now
+	}
+	if tb.warmup != nil && !state.NextFree.IsZero() {
+		tb.warmup.storedPermits = math.Max(0, math.Min(state.StoredPermits, tb.warmup.maxPermits))
+		tb.warmup.nextFree = state.NextFree
+	}
+	tb.decay.restore(state.Decaying, state.DecayStart, float64(tb.config.Burst), now)
+	tb.nextPaced = time.Time{}
+	if tb.config.Pacing && tb.config.Rate > 0 && state.NextPaced.After(now) {
+		// Pacing schedules at most a burst ahead.
+		spacing := tb.config.Period / time.Duration(tb.config.Rate)
+		tb.nextPaced = state.NextPaced
+		if limit := now.Add(time.Duration(tb.config.Burst) * spacing); tb.nextPaced.After(limit) {
+			tb.nextPaced = limit
+		}
+	}
+	tb.waiters.notifyHead()
+	return nil
+}
+
+// FixedWindowState is the persisted state of a FixedWindow.
+type FixedWindowState struct {
+	Count       int       `json:"count"`
+	WindowStart time.Time `json:"window_start"`
+
+	// Requests of earlier windows a DecayReset has not released yet,
+	// counted from DecayStart.
+	Decaying   float64   `json:"decaying,omitempty"`
+	DecayStart time.Time `json:"decay_start,omitempty"`
+}
+
+// Snapshot encodes the current state of the window.
+func (fw *FixedWindow) Snapshot() ([]byte, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return encodeSnapshot(algorithmFixedWindow, fw.config.Clock.Now(), FixedWindowState{
+		Count:       fw.count,
+		WindowStart: fw.windowStart,
+		Decaying:    fw.decay.from,
+		DecayStart:  fw.decay.start,
+	})
+}
+
+// Restore replaces the window state with a snapshot. A snapshot whose
+// window has already ended simply starts a new window on next use; one
+// whose window starts in the future, as after a clock step back, starts
+// a window now. The count is capped at the current rate.
+func (fw *FixedWindow) Restore(data []byte) error {
+	var state FixedWindowState
+	if err := decodeSnapshot(algorithmFixedWindow, data, &state); err != nil {
+		return err
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	now := fw.config.Clock.Now()
+	fw.count = state.Count
+	if fw.count > fw.config.Rate {
+		fw.count = fw.config.Rate
+	}
+	if fw.count < 0 {
+		fw.count = 0
+	}
+	fw.windowStart = state.WindowStart
+	if fw.windowStart.After(now) {
+		fw.windowStart = fw.start(now)
+	}
+	fw.decay.restore(state.Decaying, state.DecayStart, float64(fw.config.Rate), now)
+	return nil
+}
+
+// SlidingWindowState is the persisted state of a SlidingWindow.
+type SlidingWindowState struct {
+	Requests []SlidingWindowRequest `json:"requests"`
+}
+
+// SlidingWindowRequest is one admitted request in a SlidingWindowState.
+type SlidingWindowRequest struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+// Snapshot encodes the requests currently inside the window.
+func (sw *SlidingWindow) Snapshot() ([]byte, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.config.Clock.Now()
+	sw.removeOldRequests(now)
+
+	state := SlidingWindowState{Requests: make([]SlidingWindowRequest, 0, sw.requests.len())}
+	for i := 0; i < sw.requests.len(); i++ {
+		req := sw.requests.at(i)
+		state.Requests = append(state.Requests, SlidingWindowRequest{Time: req.time, Count: req.count})
+	}
+
+	return encodeSnapshot(algorithmSlidingWindow, now, state)
+}
+
+// Restore replaces the recorded requests with those in a snapshot.
+func (sw *SlidingWindow) Restore(data []byte) error {
+	var state SlidingWindowState
+	if err := decodeSnapshot(algorithmSlidingWindow, data, &state); err != nil {
+		return err
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.requests.reset()
+	for _, req := range state.Requests {
+		sw.requests.push(req.Time, req.Count)
+	}
+	sw.waiters.notifyHead()
+	return nil
+}
+
+// SlidingLogState is the persisted state of a SlidingLog.
+type SlidingLogState struct {
+	Keys map[string]SlidingLogKeyState `json:"keys"`
+}
+
+// SlidingLogKeyState is the persisted log of one SlidingLog key.
+type SlidingLogKeyState struct {
+	Entries []LogEntry    `json:"entries,omitempty"`
+	Buckets map[int64]int `json:"buckets,omitempty"`
+}
+
+// Snapshot encodes the retained log of every key.
+func (sl *SlidingLog) Snapshot() ([]byte, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := sl.config.Clock.Now()
+	state := SlidingLogState{Keys: make(map[string]SlidingLogKeyState, len(sl.logs))}
+	for key, log := range sl.logs {
+		sl.prune(log, now)
+		keyState := SlidingLogKeyState{Entries: log.entries.appendTo(nil)}
+		if log.buckets != nil {
+			keyState.Buckets = log.buckets
+		}
+		state.Keys[key] = keyState
+	}
+
+	return encodeSnapshot(algorithmSlidingLog, now, state)
+}
+
+// Restore replaces the logs of all keys with those in a snapshot.
+func (sl *SlidingLog) Restore(data []byte) error {
+	var state SlidingLogState
+	if err := decodeSnapshot(algorithmSlidingLog, data, &state); err != nil {
+		return err
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	windowStart := sl.windowStart(sl.config.Clock.Now())
+	sl.logs = make(map[string]*keyLog, len(state.Keys))
+	for key, keyState := range state.Keys {
+		log := &keyLog{}
+		for _, e := range keyState.Entries {
+			log.entries.push(e)
+		}
+		log.entries.rewindow(windowStart)
+		if keyState.Buckets != nil {
+			log.buckets = keyState.Buckets
+		}
+		sl.logs[key] = log
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+// restore takes a snapshot of from and restores it into to.
+func restore(t *testing.T, from, to ratelimit.Snapshotter) {
+	t.Helper()
+	data, err := from.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenBucketRestoreKeepsDecay(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	opts := []ratelimit.Option{
+		ratelimit.WithRate(10),
+		ratelimit.WithBurst(10),
+		ratelimit.WithDecayReset(10 * time.Second),
+		ratelimit.WithClock(clock),
+	}
+	tb := ratelimit.NewTokenBucket(opts...)
+	tb.AllowN(10)
+	tb.Reset()
+
+	restored := ratelimit.NewTokenBucket(opts...)
+	restore(t, tb, restored)
+	if got := restored.Available(); got != 0 {
+		t.Errorf("Available after restoring a fresh decay = %d, want 0", got)
+	}
+	clock.Advance(5 * time.Second)
+	if got := restored.Available(); got != 5 {
+		t.Errorf("Available halfway through the decay = %d, want 5", got)
+	}
+}
+
+func TestTokenBucketRestoreKeepsPacing(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	opts := func(clock ratelimit.Clock) []ratelimit.Option {
+		return []ratelimit.Option{ratelimit.WithRate(10), ratelimit.WithBurst(10), ratelimit.WithPacing(true), ratelimit.WithClock(clock)}
+	}
+
+	clock := clocktest.NewFakeClock(start)
+	tb := ratelimit.NewTokenBucket(opts(clock)...)
+	tb.Allow()
+	restored := ratelimit.NewTokenBucket(opts(clock)...)
+	restore(t, tb, restored)
+	if restored.Allow() {
+		t.Error("restored bucket admitted ahead of the pacing schedule")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !restored.Allow() {
+		t.Error("restored bucket rejected a request on schedule")
+	}
+
+	// A schedule an hour ahead, from a process whose clock was wrong, is
+	// capped at a burst.
+	ahead := clocktest.NewFakeClock(start.Add(time.Hour))
+	tb = ratelimit.NewTokenBucket(opts(ahead)...)
+	tb.Allow()
+	clock = clocktest.NewFakeClock(start)
+	restored = ratelimit.NewTokenBucket(opts(clock)...)
+	restore(t, tb, restored)
+	clock.Advance(time.Second)
+	if !restored.Allow() {
+		t.Error("restored bucket still paced a burst after the snapshot's schedule")
+	}
+}
+
+func TestFixedWindowRestore(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	opts := func(clock ratelimit.Clock, rate int) []ratelimit.Option {
+		return []ratelimit.Option{
+			ratelimit.WithRate(rate),
+			ratelimit.WithPeriod(time.Minute),
+			ratelimit.WithBurst(rate),
+			ratelimit.WithDecayReset(10 * time.Second),
+			ratelimit.WithClock(clock),
+		}
+	}
+
+	t.Run("decay", func(t *testing.T) {
+		clock := clocktest.NewFakeClock(start)
+		fw := ratelimit.NewFixedWindow(opts(clock, 10)...)
+		fw.AllowN(10)
+		fw.Reset()
+		restored := ratelimit.NewFixedWindow(opts(clock, 10)...)
+		restore(t, fw, restored)
+		clock.Advance(5 * time.Second)
+		if got := restored.Available(); got != 5 {
+			t.Errorf("Available halfway through the decay = %d, want 5", got)
+		}
+	})
+
+	t.Run("future window", func(t *testing.T) {
+		fw := ratelimit.NewFixedWindow(opts(clocktest.NewFakeClock(start.Add(time.Hour)), 10)...)
+		fw.AllowN(4)
+		clock := clocktest.NewFakeClock(start)
+		restored := ratelimit.NewFixedWindow(opts(clock, 10)...)
+		restore(t, fw, restored)
+		if got := restored.Available(); got != 6 {
+			t.Errorf("Available = %d, want the window's 6", got)
+		}
+		// The window starts now rather than in an hour, and its requests
+		// have decayed 10 seconds after it ends.
+		clock.Advance(time.Minute + 10*time.Second)
+		if got := restored.Available(); got != 10 {
+			t.Errorf("Available after the window = %d, want a new window of 10", got)
+		}
+	})
+
+	t.Run("count above rate", func(t *testing.T) {
+		clock := clocktest.NewFakeClock(start)
+		fw := ratelimit.NewFixedWindow(opts(clock, 10)...)
+		fw.AllowN(10)
+		restored := ratelimit.NewFixedWindow(opts(clock, 5)...)
+		restore(t, fw, restored)
+		if got := restored.Available(); got != 0 {
+			t.Errorf("Available = %d, want 0", got)
+		}
+	})
+
+	t.Run("negative count", func(t *testing.T) {
+		clock := clocktest.NewFakeClock(start)
+		fw := ratelimit.NewFixedWindow(opts(clock, 5)...)
+		data := fmt.Sprintf(`{"algorithm":"fixed_window","state":{"count":-5,"window_start":%q}}`, start.Format(time.RFC3339))
+		if err := fw.Restore([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got := fw.Available(); got != 5 {
+			t.Errorf("Available = %d, want at most the rate of 5", got)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTopOffenders is the number of top offenders StatsHandler reports
+// unless the request asks for another number.
+const DefaultTopOffenders = 10
+
+// MiddlewareStats is a summary of a Middleware for operators.
+type MiddlewareStats struct {
+	TakenAt time.Time `json:"taken_at"`
+
+	// ActiveKeys is the number of keys with a limiter. Idle keys are
+	// dropped after MaxIdleTime.
+	ActiveKeys int `json:"active_keys"`
+
+	// Queued is the number of requests waiting in QueueHandler.
+	Queued int `json:"queued"`
+
+	Counters MiddlewareCounters `json:"counters"`
+
+	// Keys holds the state of every active key. It is nil when the summary
+	// was taken without keys.
+	Keys map[string]KeyStats `json:"keys,omitempty"`
+
+	// TopOffenders lists the active keys with the most rate limited
+	// requests, most limited first. Keys never limited are not listed.
+	TopOffenders []Offender `json:"top_offenders"`
+}
+
+// KeyStats is the state of one key in MiddlewareStats.
+type KeyStats struct {
+	Remaining   int       `json:"remaining"`
+	RateLimited int64     `json:"rate_limited"`
+	LastAccess  time.Time `json:"last_access"`
+}
+
+// Offender is a key and the number of its requests that were rate limited
+// since its limiter was created.
+type Offender struct {
+	Key         string `json:"key"`
+	RateLimited int64  `json:"rate_limited"`
+}
+
+// Summary returns the middleware statistics with up to top offenders. Per
+// key state is included when withKeys is true.
+func (m *Middleware) Summary(top int, withKeys bool) MiddlewareStats {
+	stats := MiddlewareStats{
+		TakenAt:  time.Now(),
+		Queued:   m.Queued(),
+		Counters: m.Counters(),
+	}
+
+	m.mu.RLock()
+	stats.ActiveKeys = len(m.limiters)
+	if withKeys {
+		stats.Keys = make(map[string]KeyStats, len(m.limiters))
+	}
+	offenders := make([]Offender, 0)
+	for key, entry := range m.limiters {
+		limited := atomic.LoadInt64(&entry.limited)
+		if withKeys {
+			stats.Keys[key] = KeyStats{
+				Remaining:   entry.limiter.Available(),
+				RateLimited: limited,
+				LastAccess:  entry.lastAccess,
+			}
+		}
+		if limited > 0 {
+			offenders = append(offenders, Offender{Key: key, RateLimited: limited})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].RateLimited != offenders[j].RateLimited {
+			return offenders[i].RateLimited > offenders[j].RateLimited
+		}
+		return offenders[i].Key < offenders[j].Key
+	})
+	if top >= 0 && len(offenders) > top {
+		offenders = offenders[:top]
+	}
+	stats.TopOffenders = offenders
+
+	return stats
+}
+
+// StatsHandler serves Summary as JSON. The query parameter top sets the
+// number of offenders (default DefaultTopOffenders) and keys=false omits
+// the per-key state, which can be large. Mount it behind authentication,
+// since keys may identify clients.
+func (m *Middleware) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		top, withKeys, ok := statsQuery(w, r)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Summary(top, withKeys))
+	})
+}
+
+// statsQuery parses the top and keys parameters of a stats request. If
+// they are invalid it responds with 400 and returns false.
+func statsQuery(w http.ResponseWriter, r *http.Request) (top int, withKeys bool, ok bool) {
+	query := r.URL.Query()
+
+	top = DefaultTopOffenders
+	if v := query.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "top must be a non-negative integer", http.StatusBadRequest)
+			return 0, false, false
+		}
+		top = n
+	}
+
+	withKeys = true
+	if v := query.Get("keys"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "keys must be a boolean", http.StatusBadRequest)
+			return 0, false, false
+		}
+		withKeys = b
+	}
+	return top, withKeys, true
+}
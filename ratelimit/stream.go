@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// MessageLimiter limits the messages of a long-lived connection, such as
+// a WebSocket or a server-sent event stream, which a Middleware otherwise
+// only sees as its initial request. Messages are charged like requests of
+// the connection's key: blocked and exempt keys, counters, logs and events
+// all apply, so a separate Middleware for messages keeps their budget and
+// statistics apart from those of requests. In DryRun mode messages over
+// the limit are reported to OnDryRun and admitted.
+type MessageLimiter struct {
+	m   *Middleware
+	r   *http.Request
+	key string
+
+	// own is the limiter of ConnMessages; nil charges the key's limiter
+	// in the middleware.
+	own Limiter
+}
+
+// Messages returns a MessageLimiter for the connection of r charging the
+// limiter of its key, so that all connections of a user share one budget.
+// Every message looks the limiter up like a request, which keeps the key
+// from being dropped as idle or evicted while the connection is active.
+func (m *Middleware) Messages(r *http.Request) *MessageLimiter {
+	return &MessageLimiter{m: m, r: r, key: m.keyFor(r)}
+}
+
+// ConnMessages returns a MessageLimiter for the connection of r with a
+// limiter of its own, from the factory of its key, so that every
+// connection has its own budget. The limiter is not kept by the
+// middleware: it ends with the connection, and limits set later with
+// SetLimit do not reach it.
+func (m *Middleware) ConnMessages(r *http.Request) *MessageLimiter {
+	key := m.keyFor(r)
+	limiter := m.factoryFor(m.tierOf(key), key)()
+
+	m.mu.RLock()
+	if m.limit.apply(limiter) {
+		limiter.Reset()
+	}
+	m.mu.RUnlock()
+	return &MessageLimiter{m: m, r: r, key: key, own: limiter}
+}
+
+// limiter returns the limiter to charge.
+func (l *MessageLimiter) limiter() Limiter {
+	if l.own != nil {
+		return l.own
+	}
+	return l.m.getLimiter(l.key)
+}
+
+// Key returns the key messages are charged to.
+func (l *MessageLimiter) Key() string {
+	return l.key
+}
+
+// Allow reports whether a message is within the limit.
+func (l *MessageLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n messages, or a message of cost n, are within
+// the limit, charging them if so.
+func (l *MessageLimiter) AllowN(n int) bool {
+	return l.check(n) == nil
+}
+
+// Wait waits until a message is within the limit; see WaitN.
+func (l *MessageLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN waits until n messages are within the limit, slowing the
+// connection down rather than rejecting them. It fails if ctx is done
+// first, or at once with an *ErrLimited if the key is blocked.
+func (l *MessageLimiter) WaitN(ctx context.Context, n int) error {
+	if l.m.config.DryRun {
+		return l.check(n)
+	}
+	if ok, err := l.override(n); ok {
+		return err
+	}
+	if err := l.limiter().WaitN(ctx, n); err != nil {
+		if ctx.Err() != context.Canceled {
+			l.m.countLimited(l.r, rateLimitInfo(l.key, err))
+		}
+		return err
+	}
+	l.allowed(n)
+	return nil
+}
+
+// Reader wraps read, the function a WebSocket read loop calls for every
+// message, such as the ReadMessage method of a connection, charging each
+// message it returns. With wait, a message over the limit is returned once
+// it is within it, which holds up the loop and so the client; otherwise
+// the message is dropped and an *ErrLimited returned, on which the loop
+// would typically close the connection with status 1008 (policy
+// violation):
+//
+//	read := messages.Reader(conn.ReadMessage, false)
+//	for {
+//		kind, data, err := read()
+//		...
+//	}
+func (l *MessageLimiter) Reader(read func() (int, []byte, error), wait bool) func() (int, []byte, error) {
+	return func() (int, []byte, error) {
+		kind, data, err := read()
+		if err != nil {
+			return kind, data, err
+		}
+		if wait {
+			err = l.Wait(l.r.Context())
+		} else {
+			err = l.check(1)
+		}
+		if err != nil {
+			return kind, nil, err
+		}
+		return kind, data, nil
+	}
+}
+
+// Flusher wraps w, the ResponseWriter of a server-sent event stream, so
+// that each Flush waits until an event is within the limit. The stream
+// then sends events no faster than the limit, and events written while
+// waiting go out together with the next flush. A flush is skipped if the
+// client goes away while waiting.
+func (l *MessageLimiter) Flusher(w http.ResponseWriter) http.ResponseWriter {
+	return &gatedWriter{ResponseWriter: w, l: l}
+}
+
+// check charges n messages, returning an *ErrLimited if they are over the
+// limit.
+func (l *MessageLimiter) check(n int) error {
+	if ok, err := l.override(n); ok {
+		return err
+	}
+	limiter := l.limiter()
+	if limiter.AllowN(n) {
+		l.allowed(n)
+		return nil
+	}
+
+	err := error(&ErrLimited{})
+	if c, ok := limiter.(Checker); ok {
+		if cerr := c.CheckN(n); cerr != nil {
+			err = cerr
+		}
+	}
+	return l.limited(n, rateLimitInfo(l.key, err), err)
+}
+
+// override applies a Block or Exempt of the key, reporting whether there
+// is one.
+func (l *MessageLimiter) override(n int) (bool, error) {
+	o, d := l.m.override(l.key)
+	switch {
+	case d <= 0:
+		return false, nil
+	case o.exempt:
+		l.allowed(n)
+		return true, nil
+	default:
+		return true, l.limited(n, RateLimitInfo{Key: l.key, RetryAfter: d}, &ErrLimited{RetryAfter: d})
+	}
+}
+
+// limited rejects n messages over the limit with err, or only reports
+// them in DryRun mode.
+func (l *MessageLimiter) limited(n int, info RateLimitInfo, err error) error {
+	if l.m.config.DryRun {
+		l.m.wouldReject(l.r, DryRunEvent{Reason: DryRunRateLimited, Key: l.key, Cost: n, RetryAfter: info.RetryAfter})
+		l.allowed(n)
+		return nil
+	}
+	l.m.countLimited(l.r, info)
+	return err
+}
+
+// allowed counts n admitted messages.
+func (l *MessageLimiter) allowed(n int) {
+	atomic.AddInt64(&l.m.counters.allowed, 1)
+	l.m.allowed(l.r, l.key, n)
+}
+
+// gatedWriter is the ResponseWriter of MessageLimiter.Flusher.
+type gatedWriter struct {
+	http.ResponseWriter
+	l *MessageLimiter
+}
+
+// Flush waits for the limiter and flushes the wrapped writer.
+func (w *gatedWriter) Flush() {
+	if w.l.Wait(w.l.r.Context()) != nil {
+		return
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *gatedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,48 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func TestMessagesKeepKeyActive(t *testing.T) {
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc: ratelimit.HeaderKeyFunc("X-User", nil),
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(2), ratelimit.WithPeriod(time.Hour))
+		},
+		MaxKeys: 2,
+	})
+	defer m.Close()
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(user string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		return r
+	}
+	serve := func(user string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request(user))
+		return w.Code
+	}
+
+	messages := m.Messages(request("a"))
+	if !messages.Allow() {
+		t.Fatal("first message denied")
+	}
+	serve("b")
+	if !messages.Allow() {
+		t.Fatal("second message denied")
+	}
+	// The messages made a more recently used than b, so c evicts b and a
+	// keeps its spent budget.
+	serve("c")
+	if code := serve("a"); code != http.StatusTooManyRequests {
+		t.Errorf("request after two messages: status %d, want 429 from the shared budget", code)
+	}
+}
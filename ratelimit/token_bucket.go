@@ -9,6 +9,14 @@ import (
 
 // TokenBucket implements the token bucket rate limiting algorithm.
 // It allows bursts of traffic while maintaining an average rate.
+//
+// With WithWarmup, the bucket instead paces requests and, after idle time,
+// ramps its rate up from Rate/ColdFactor to Rate over the warm-up period,
+// protecting cold backends from full-rate traffic right after startup.
+//
+// With WithPacing(true), a burst is spread out: requests are admitted at
+// least Period/Rate apart, so traffic flows at the configured rate rather
+// than in bursts.
 type TokenBucket struct {
 	config       *Config
 	tokens       float64
@@ -16,6 +24,12 @@ type TokenBucket struct {
 	mu           sync.Mutex
 	refillAmount float64
 	refillPeriod time.Duration
+	waiters      waitQueue
+	warmup       *warmup
+	decay        decay
+	jitter       *jitter
+	denials      *denialLogger
+	nextPaced    time.Time // with Pacing, when the next request may be admitted
 }
 
 // NewTokenBucket creates a new TokenBucket rate limiter.
@@ -26,15 +40,25 @@ func NewTokenBucket(opts ...Option) *TokenBucket {
 		cfg.Burst = cfg.Rate
 	}
 	
-	refillPeriod := cfg.Period / time.Duration(cfg.Rate)
-	
-	return &TokenBucket{
+	// Rate tokens per Period rather than one per Period/Rate, which would
+	// round to whole nanoseconds and be far off at byte rates.
+	tb := &TokenBucket{
 		config:       cfg,
 		tokens:       float64(cfg.Burst),
 		lastRefill:   cfg.Clock.Now(),
-		refillAmount: 1.0,
-		refillPeriod: refillPeriod,
+		refillAmount: float64(cfg.Rate),
+		refillPeriod: cfg.Period,
+		waiters:      waitQueue{edf: cfg.Admission == AdmissionEDF},
+		decay:        decay{period: cfg.DecayReset},
+		jitter:       newJitter(cfg),
+		denials:      newDenialLogger(cfg.Logger, cfg.LogSampleRate),
+	}
+	
+	if cfg.WarmupPeriod > 0 {
+		tb.warmup = newWarmup(cfg, tb.lastRefill)
 	}
+	
+	return tb
 }
 
 // Allow checks if a single request can proceed.
@@ -43,18 +67,58 @@ func (tb *TokenBucket) Allow() bool {
 }
 
 // AllowN checks if n requests can proceed.
+// It never succeeds ahead of callers already blocked in WaitN.
 func (tb *TokenBucket) AllowN(n int) bool {
+	if tb.allowN(n) {
+		return true
+	}
+	tb.denials.denied("", func() error { return tb.CheckN(n) })
+	return false
+}
+
+// AllowUpTo admits as many of n requests as there are tokens for, up to n,
+// and returns how many. While callers are waiting it admits none.
+func (tb *TokenBucket) AllowUpTo(n int) int {
+	if granted := tb.allowUpTo(n); granted > 0 || n <= 0 {
+		return granted
+	}
+	tb.denials.denied("", tb.Check)
+	return 0
+}
+
+// allowUpTo is AllowUpTo without logging.
+func (tb *TokenBucket) allowUpTo(n int) int {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	
-	tb.refill()
+	if tb.waiters.Len() > 0 {
+		return 0
+	}
 	
-	if tb.tokens >= float64(n) {
-		tb.tokens -= float64(n)
-		return true
+	// Pacing and warmup may still refuse the tokens available
+	k := tb.available()
+	if k > n {
+		k = n
+	}
+	for ; k > 0; k-- {
+		if ok, _ := tb.tryAcquire(k); ok {
+			return k
+		}
 	}
+	return 0
+}
+
+// allowN is AllowN without logging.
+func (tb *TokenBucket) allowN(n int) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
 	
-	return false
+	if tb.waiters.Len() > 0 {
+		return false
+	}
+	
+	ok, _ := tb.tryAcquire(n)
+	return ok
 }
 
 // Wait blocks until a request can proceed or context is cancelled.
@@ -63,52 +127,206 @@ func (tb *TokenBucket) Wait(ctx context.Context) error {
 }
 
 // WaitN blocks until n requests can proceed or context is cancelled.
+// Blocked callers are served in order of priority (see ContextWithPriority)
+// and then arrival, so large requests are not starved by smaller ones.
 func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	
 	if n > tb.config.Burst {
 		return fmt.Errorf("requested tokens %d exceeds burst size %d", n, tb.config.Burst)
 	}
 	
-	for {
-		tb.mu.Lock()
-		tb.refill()
-		
-		if tb.tokens >= float64(n) {
-			tb.tokens -= float64(n)
-			tb.mu.Unlock()
+	return waitTurn(ctx, &tb.mu, &tb.waiters, tb.config.Clock, tb.jitter, n, tb)
+}
+
+// Check returns nil if a single request would be admitted now, otherwise
+// an *ErrLimited describing when to retry.
+func (tb *TokenBucket) Check() error {
+	return tb.CheckN(1)
+}
+
+// CheckN returns nil if n requests would be admitted now, otherwise an
+// *ErrLimited describing when to retry. It consumes no tokens.
+func (tb *TokenBucket) CheckN(n int) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	
+	return checkResult(tb.limited(n))
+}
+
+// limited describes why n tokens cannot be taken now, or returns nil if
+// they can. Tokens promised to queued waiters count as taken.
+// The caller must hold tb.mu.
+func (tb *TokenBucket) limited(n int) *ErrLimited {
+	now := tb.config.Clock.Now()
+	
+	if tb.warmup != nil {
+		tb.warmup.resync(now)
+		if !tb.warmup.nextFree.After(now) && tb.waiters.Len() == 0 {
 			return nil
 		}
-		
-		// Calculate wait time for required tokens
-		tokensNeeded := float64(n) - tb.tokens
-		waitDuration := time.Duration(tokensNeeded * float64(tb.refillPeriod))
-		tb.mu.Unlock()
-		
-		// Wait with context
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-tb.config.Clock.After(waitDuration):
-			// Continue to next iteration
+		return &ErrLimited{
+			RetryAfter: tb.warmup.nextFree.Sub(now),
+			Limit:      tb.config.Rate,
+			Remaining:  0,
 		}
 	}
+	
+	tb.refill()
+	
+	needed := float64(n + tb.waiters.units)
+	free := tb.free(now)
+	wait := tb.paceWait(now)
+	if free >= needed && wait == 0 {
+		return nil
+	}
+	
+	remaining := int(free) - tb.waiters.units
+	if remaining < 0 || wait > 0 {
+		remaining = 0
+	}
+	if free < needed {
+		wait = max(wait, tb.refillTime(needed-free, now))
+	}
+	return &ErrLimited{
+		RetryAfter: wait,
+		Limit:      tb.config.Rate,
+		Remaining:  remaining,
+	}
+}
+
+// tryAcquire takes n tokens if available, otherwise it returns how long
+// until they will be. The caller must hold tb.mu.
+func (tb *TokenBucket) tryAcquire(n int) (bool, time.Duration) {
+	if tb.warmup != nil {
+		return tb.warmup.tryAcquire(n, tb.config.Clock.Now())
+	}
+	
+	tb.refill()
+	
+	now := tb.config.Clock.Now()
+	free := tb.free(now)
+	wait := tb.paceWait(now)
+	if free >= float64(n) && wait == 0 {
+		tb.tokens -= float64(n)
+		tb.pace(n, now)
+		return true, 0
+	}
+	
+	// Calculate wait time for required tokens
+	if free < float64(n) {
+		wait = max(wait, tb.refillTime(float64(n)-free, now))
+	}
+	return false, wait
+}
+
+// paceWait returns how long until pacing admits the next request, or 0
+// if it does now or Pacing is off. The caller must hold tb.mu.
+func (tb *TokenBucket) paceWait(now time.Time) time.Duration {
+	if !tb.config.Pacing || !tb.nextPaced.After(now) {
+		return 0
+	}
+	return tb.nextPaced.Sub(now)
+}
+
+// pace schedules the next request after n requests admitted at now, each
+// taking Period/Rate. The caller must hold tb.mu.
+func (tb *TokenBucket) pace(n int, now time.Time) {
+	if !tb.config.Pacing || tb.config.Rate <= 0 {
+		return
+	}
+	if tb.nextPaced.Before(now) {
+		tb.nextPaced = now
+	}
+	spacing := tb.config.Period / time.Duration(tb.config.Rate)
+	tb.nextPaced = tb.nextPaced.Add(time.Duration(n) * spacing)
+}
+
+// free returns the tokens that may be taken at now: the bucket minus what
+// a DecayReset has not released yet. The caller must hold tb.mu.
+func (tb *TokenBucket) free(now time.Time) float64 {
+	return tb.tokens - tb.decay.left(now)
+}
+
+// refillTime returns how long until missing more tokens are free. While a
+// decay is in progress tokens are freed faster than the refill rate, so
+// the result may be early; callers check again when it has elapsed.
+func (tb *TokenBucket) refillTime(missing float64, now time.Time) time.Duration {
+	if d := tb.decay.rate(now); d > 0 {
+		rate := tb.refillAmount/tb.refillPeriod.Seconds() + d
+		return time.Duration(missing / rate * float64(time.Second))
+	}
+	return time.Duration(missing / tb.refillAmount * float64(tb.refillPeriod))
+}
+
+// Refund returns a single token to the bucket.
+func (tb *TokenBucket) Refund() {
+	tb.ReturnN(1)
 }
 
-// Reset resets the rate limiter to its initial state.
+// ReturnN gives back n tokens that were admitted but not used, for example
+// when the downstream call failed before doing any work. The bucket never
+// grows beyond its burst size.
+func (tb *TokenBucket) ReturnN(n int) {
+	if n <= 0 {
+		return
+	}
+	
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	
+	if tb.warmup != nil {
+		tb.warmup.giveBack(n, tb.config.Clock.Now())
+	} else {
+		tb.refill()
+		tb.tokens = min(tb.tokens+float64(n), float64(tb.config.Burst))
+	}
+	tb.waiters.notifyHead()
+}
+
+// Reset resets the rate limiter to its initial state. With DecayReset the
+// bucket refills to its burst size gradually.
 func (tb *TokenBucket) Reset() {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	
+	now := tb.config.Clock.Now()
+	if tb.warmup == nil {
+		tb.refill()
+		tb.decay.begin(float64(tb.config.Burst)-tb.tokens, now)
+	}
 	tb.tokens = float64(tb.config.Burst)
-	tb.lastRefill = tb.config.Clock.Now()
+	tb.lastRefill = now
+	tb.nextPaced = time.Time{}
+	if tb.warmup != nil {
+		tb.warmup.reset(tb.lastRefill)
+	}
 }
 
 // Available returns the number of available tokens.
+// In warm-up mode it is 1 when a request would be admitted now, else 0;
+// with pacing it is 0 until the next request may be admitted.
 func (tb *TokenBucket) Available() int {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	
+	return tb.available()
+}
+
+// available is Available with tb.mu held.
+func (tb *TokenBucket) available() int {
+	if tb.warmup != nil {
+		return tb.warmup.available(tb.config.Clock.Now())
+	}
+	
 	tb.refill()
-	return int(tb.tokens)
+	now := tb.config.Clock.Now()
+	free := tb.free(now)
+	if free < 0 || tb.paceWait(now) > 0 {
+		return 0
+	}
+	return int(free)
 }
 
 // refill adds tokens based on elapsed time since last refill.
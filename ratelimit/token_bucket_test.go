@@ -0,0 +1,65 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+func TestTokenBucketPacingThroughput(t *testing.T) {
+	const (
+		rate     = 100
+		period   = time.Minute
+		duration = 10 * time.Minute
+	)
+
+	clock := clocktest.NewFakeClock(time.Time{})
+	tb := ratelimit.NewTokenBucket(
+		ratelimit.WithRate(rate),
+		ratelimit.WithPeriod(period),
+		ratelimit.WithBurst(10),
+		ratelimit.WithPacing(true),
+		ratelimit.WithClock(clock),
+	)
+
+	admitted := 0
+	for elapsed := time.Duration(0); elapsed < duration; elapsed += 10 * time.Millisecond {
+		for tb.Allow() {
+			admitted++
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+
+	want := int(duration / period * rate)
+	if admitted < want-1 || admitted > want+1 {
+		t.Errorf("admitted %d requests in %v, want %d", admitted, duration, want)
+	}
+}
+
+func TestTokenBucketPacingSpacing(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	tb := ratelimit.NewTokenBucket(
+		ratelimit.WithRate(10),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithBurst(5),
+		ratelimit.WithPacing(true),
+		ratelimit.WithClock(clock),
+	)
+
+	if !tb.Allow() {
+		t.Fatal("first request denied")
+	}
+	if tb.Allow() {
+		t.Fatal("second request admitted without spacing")
+	}
+	clock.Advance(99 * time.Millisecond)
+	if tb.Allow() {
+		t.Fatal("request admitted before Period/Rate elapsed")
+	}
+	clock.Advance(time.Millisecond)
+	if !tb.Allow() {
+		t.Fatal("request denied after Period/Rate elapsed")
+	}
+}
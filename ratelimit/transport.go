@@ -0,0 +1,300 @@
+package ratelimit
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransportConfig configures a Transport. A request is limited by the
+// first of Routes it matches, else by its host's limiter from HostLimiter,
+// else by Limiter; it is sent unlimited if none applies.
+type TransportConfig struct {
+	// Limiter paces requests no route or host limiter applies to; each
+	// waits for one token.
+	Limiter Limiter
+
+	// Routes give endpoints limiters of their own, in order of precedence.
+	Routes []TransportRoute
+
+	// HostLimiter, if set, gives every destination host not matched by a
+	// route a limiter of its own, so a slow or strict API does not use up
+	// the budget of others. It is called with the host of the URL, such as
+	// "api.example.com" or "localhost:8080", the first time it is seen.
+	HostLimiter func(host string) Limiter
+
+	// MaxHosts bounds the number of host limiters; the least recently used
+	// are dropped beyond it, and closed if they implement Closer. Defaults
+	// to 1024.
+	MaxHosts int
+
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// HonorRetryAfter couples the transport to the remote rate limit:
+	// after a 429 or 503 response with a Retry-After header, no request
+	// limited by the same limiter is sent until the time it names, so
+	// concurrent callers do not keep hitting a server that already refused
+	// them.
+	HonorRetryAfter bool
+
+	// MaxRetryAfter bounds the pause after a Retry-After, in case the
+	// server asks for an unreasonable one. Defaults to one minute.
+	MaxRetryAfter time.Duration
+
+	// Clock is the time source. Defaults to SystemClock.
+	Clock Clock
+}
+
+// TransportRoute matches requests by destination host and path.
+type TransportRoute struct {
+	// Host is the host the route applies to, with or without a port;
+	// empty matches every host.
+	Host string
+
+	// Path is a pattern with the wildcards of Router, such as
+	// "/repos/*/issues"; empty matches every path.
+	Path string
+
+	// Limiter creates the limiter shared by all requests of the route. It
+	// is called once, by NewTransport.
+	Limiter func() Limiter
+}
+
+// Transport is an http.RoundTripper that rate limits the requests of an
+// http.Client, for calling APIs that enforce a rate limit of their own:
+//
+//	client := &http.Client{Transport: ratelimit.NewTransport(&ratelimit.TransportConfig{
+//		Limiter:         ratelimit.NewTokenBucket(ratelimit.WithRate(10), ratelimit.WithPeriod(time.Second)),
+//		HonorRetryAfter: true,
+//	})}
+//
+// Requests wait for their limiter until their context ends, in which case
+// RoundTrip returns the error of the limiter.
+type Transport struct {
+	config TransportConfig
+	shared *transportLimiter
+	routes []transportRoute
+
+	mu    sync.Mutex
+	hosts map[string]*transportLimiter
+	lru   *list.List // hosts, most recently used first
+}
+
+// transportRoute is a route with its parsed pattern and limiter.
+type transportRoute struct {
+	host     string
+	segments []string // nil matches every path
+	limiter  *transportLimiter
+}
+
+// transportLimiter is a limiter and the Retry-After pause of the requests
+// it limits.
+type transportLimiter struct {
+	limiter     Limiter
+	pausedUntil time.Time     // guarded by Transport.mu
+	elem        *list.Element // position in Transport.lru, for host limiters
+}
+
+// NewTransport returns a transport configured by config.
+func NewTransport(config *TransportConfig) *Transport {
+	t := &Transport{
+		config: *config,
+		hosts:  make(map[string]*transportLimiter),
+		lru:    list.New(),
+	}
+	if t.config.Base == nil {
+		t.config.Base = http.DefaultTransport
+	}
+	if t.config.MaxHosts <= 0 {
+		t.config.MaxHosts = 1024
+	}
+	if t.config.MaxRetryAfter <= 0 {
+		t.config.MaxRetryAfter = time.Minute
+	}
+	if t.config.Clock == nil {
+		t.config.Clock = SystemClock{}
+	}
+
+	if config.Limiter != nil {
+		t.shared = &transportLimiter{limiter: config.Limiter}
+	}
+	for _, r := range config.Routes {
+		route := transportRoute{
+			host:    strings.ToLower(r.Host),
+			limiter: &transportLimiter{limiter: r.Limiter()},
+		}
+		if r.Path != "" {
+			route.segments = splitPath(r.Path)
+		}
+		t.routes = append(t.routes, route)
+	}
+	return t
+}
+
+// limiterFor returns the limiter of req, or nil if req is not limited.
+func (t *Transport) limiterFor(req *http.Request) *transportLimiter {
+	host := strings.ToLower(req.URL.Host)
+	var path []string
+	for _, r := range t.routes {
+		if r.host != "" && r.host != host && r.host != strings.ToLower(req.URL.Hostname()) {
+			continue
+		}
+		if r.segments != nil {
+			if path == nil {
+				path = splitPath(req.URL.Path)
+			}
+			if !matchSegments(r.segments, path) {
+				continue
+			}
+		}
+		return r.limiter
+	}
+
+	if t.config.HostLimiter == nil {
+		return t.shared
+	}
+
+	t.mu.Lock()
+	if l, ok := t.hosts[host]; ok {
+		t.lru.MoveToFront(l.elem)
+		t.mu.Unlock()
+		return l
+	}
+	l := &transportLimiter{limiter: t.config.HostLimiter(host), elem: t.lru.PushFront(host)}
+	t.hosts[host] = l
+	var dropped []Closer
+	for len(t.hosts) > t.config.MaxHosts {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		host := oldest.Value.(string)
+		if c, ok := t.hosts[host].limiter.(Closer); ok {
+			dropped = append(dropped, c)
+		}
+		delete(t.hosts, host)
+	}
+	t.mu.Unlock()
+
+	// Close may block on I/O, so it must not hold up requests waiting for
+	// t.mu.
+	for _, c := range dropped {
+		c.Close()
+	}
+	return l
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.limiterFor(req)
+	if l == nil {
+		return t.config.Base.RoundTrip(req)
+	}
+
+	if err := t.waitPause(req, l); err != nil {
+		return nil, err
+	}
+	if err := l.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	// A pause may have started while the request waited for the limiter.
+	if err := t.waitPause(req, l); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.config.Base.RoundTrip(req)
+	if err == nil && t.config.HonorRetryAfter {
+		t.observe(resp, l)
+	}
+	return resp, err
+}
+
+// waitPause blocks while a Retry-After pause of l lasts or until the
+// context of req ends.
+func (t *Transport) waitPause(req *http.Request, l *transportLimiter) error {
+	for {
+		t.mu.Lock()
+		wait := l.pausedUntil.Sub(t.config.Clock.Now())
+		t.mu.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-req.Context().Done():
+			return req.Context().Err()
+		case <-t.config.Clock.After(wait):
+		}
+	}
+}
+
+// observe pauses l if resp asks to retry later.
+func (t *Transport) observe(resp *http.Response, l *transportLimiter) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	now := t.config.Clock.Now()
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok || wait <= 0 {
+		return
+	}
+	if wait > t.config.MaxRetryAfter {
+		wait = t.config.MaxRetryAfter
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := now.Add(wait); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// PausedUntil returns when the Retry-After pause of Config.Limiter ends,
+// or a time in the past if there is none.
+func (t *Transport) PausedUntil() time.Time {
+	if t.shared == nil {
+		return time.Time{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.shared.pausedUntil
+}
+
+// PausedUntilFor returns when the Retry-After pause of the limiter that
+// applies to req ends, or a time in the past if there is none.
+func (t *Transport) PausedUntilFor(req *http.Request) time.Time {
+	l := t.limiterFor(req)
+	if l == nil {
+		return time.Time{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return l.pausedUntil
+}
+
+// Hosts returns the number of host limiters.
+func (t *Transport) Hosts() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.hosts)
+}
+
+// parseRetryAfter parses a Retry-After header value, in seconds or as an
+// HTTP date, into a delay from now.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,47 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/client/ratelimit"
+)
+
+func TestTransportClosesEvictedHostLimiters(t *testing.T) {
+	var limiters []*closingLimiter
+	transport := ratelimit.NewTransport(&ratelimit.TransportConfig{
+		HostLimiter: func(string) ratelimit.Limiter {
+			l := newClosingLimiter()
+			limiters = append(limiters, l)
+			return l
+		},
+		MaxHosts: 1,
+		Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+		}),
+	})
+	defer func() {
+		for _, l := range limiters[1:] {
+			l.Close()
+		}
+	}()
+
+	for _, host := range []string{"a.example", "b.example"} {
+		resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if !limiters[0].closed() {
+		t.Error("evicted host limiter not closed when RoundTrip returned")
+	}
+}
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// priorityKey is the context key for per-call wait priority.
+type priorityKey struct{}
+
+// ContextWithPriority returns a context that carries a wait priority.
+// Blocked Wait/WaitN calls made with a higher priority are served before
+// those with a lower one; calls with equal priority are served in arrival
+// order. The default priority is 0.
+func ContextWithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the wait priority stored in ctx, or 0.
+func PriorityFromContext(ctx context.Context) int {
+	if p, ok := ctx.Value(priorityKey{}).(int); ok {
+		return p
+	}
+	return 0
+}
+
+// Admission selects how blocked Wait/WaitN callers are ordered.
+type Admission int
+
+const (
+	// AdmissionFIFO serves callers by priority and then arrival.
+	AdmissionFIFO Admission = iota
+
+	// AdmissionEDF serves callers by priority and then earliest deadline
+	// first, taken from the context; callers without a deadline go after
+	// those with one. A caller at the head of the queue that can no longer
+	// get its units before its deadline fails at once with an *ErrLimited
+	// wrapping context.DeadlineExceeded, instead of holding up callers
+	// behind it that still can. Suits internal batch APIs that mix urgent
+	// and lazy callers.
+	AdmissionEDF
+)
+
+// waiter is a blocked WaitN call.
+type waiter struct {
+	n        int
+	priority int
+	deadline time.Time // zero if none
+	seq      uint64
+	index    int
+	wake     chan struct{}
+}
+
+// waitQueue orders blocked callers by priority and then by arrival, or by
+// deadline in EDF mode. It is not safe for concurrent use; the owning
+// limiter's mutex guards it.
+type waitQueue struct {
+	items []*waiter
+	seq   uint64
+	units int
+	edf   bool
+}
+
+func (q *waitQueue) Len() int { return len(q.items) }
+
+func (q *waitQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if q.edf && !a.deadline.Equal(b.deadline) {
+		if a.deadline.IsZero() || b.deadline.IsZero() {
+			return b.deadline.IsZero()
+		}
+		return a.deadline.Before(b.deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (q *waitQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(q.items)
+	q.items = append(q.items, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := q.items
+	last := len(old) - 1
+	w := old[last]
+	old[last] = nil
+	w.index = -1
+	q.items = old[:last]
+	return w
+}
+
+// enqueue adds a waiter for n units at the given priority and deadline.
+func (q *waitQueue) enqueue(n, priority int, deadline time.Time) *waiter {
+	q.seq++
+	w := &waiter{
+		n:        n,
+		priority: priority,
+		deadline: deadline,
+		seq:      q.seq,
+		wake:     make(chan struct{}, 1),
+	}
+	heap.Push(q, w)
+	q.units += n
+	return w
+}
+
+// remove takes w out of the queue and wakes the new head, if any.
+func (q *waitQueue) remove(w *waiter) {
+	if w.index >= 0 {
+		heap.Remove(q, w.index)
+		q.units -= w.n
+	}
+	q.notifyHead()
+}
+
+// head returns the waiter that is next in line, or nil.
+func (q *waitQueue) head() *waiter {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0]
+}
+
+// notifyHead wakes the head waiter so it re-checks capacity.
+func (q *waitQueue) notifyHead() {
+	if h := q.head(); h != nil {
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// acquirer is the limiter side of waitTurn. Both methods are called with
+// the limiter's mutex held.
+type acquirer interface {
+	// tryAcquire takes n units if available, otherwise it reports how long
+	// to wait before they could be.
+	tryAcquire(n int) (bool, time.Duration)
+
+	// limited describes why n units cannot be admitted now, or returns nil
+	// if they can.
+	limited(n int) *ErrLimited
+}
+
+// waitTurn blocks until the caller reaches the front of q and a can acquire
+// n units, or ctx is done. mu must be held on entry and is held on return.
+// A wait ended by the context deadline returns an *ErrLimited.
+//
+// Cancellation guarantees:
+//   - Units are taken only by the successful tryAcquire that ends the wait,
+//     so a waiter that returns an error has consumed nothing. Failed
+//     attempts compute a wait time without reserving capacity.
+//   - A cancelled waiter leaves the queue before waitTurn returns, its
+//     units stop counting against Checker results and AllowN, and the new
+//     head is woken at once rather than when its timer fires.
+//   - Its position is forfeited, not handed on: the waiters behind it keep
+//     their relative order and each moves up one place.
+//   - A context that is already done fails immediately without taking
+//     units, even if they are available.
+func waitTurn(ctx context.Context, mu *sync.Mutex, q *waitQueue, clock Clock, j *jitter, n int, a acquirer) error {
+	if err := ctx.Err(); err != nil {
+		return deadlineError(err, func() *ErrLimited { return a.limited(n) })
+	}
+
+	if q.Len() == 0 {
+		if ok, _ := a.tryAcquire(n); ok {
+			return nil
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	w := q.enqueue(n, PriorityFromContext(ctx), deadline)
+	for {
+		var timer <-chan time.Time
+		if q.head() == w {
+			ok, wait := a.tryAcquire(n)
+			if ok {
+				q.remove(w)
+				return nil
+			}
+			if q.edf && !deadline.IsZero() && clock.Now().Add(wait).After(deadline) {
+				// Cannot make it; let the waiters behind try.
+				q.remove(w)
+				return deadlineError(context.DeadlineExceeded, func() *ErrLimited { return a.limited(n) })
+			}
+			timer = clock.After(j.apply(wait))
+		}
+
+		mu.Unlock()
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			q.remove(w)
+			return deadlineError(ctx.Err(), func() *ErrLimited { return a.limited(n) })
+		case <-w.wake:
+		case <-timer:
+		}
+		mu.Lock()
+	}
+}
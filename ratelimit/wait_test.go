@@ -0,0 +1,193 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/client/ratelimit"
+	"github.com/rRateLimit/client/ratelimit/clocktest"
+)
+
+// waitAsync starts WaitN on l and returns a channel receiving its result.
+func waitAsync(ctx context.Context, l ratelimit.Limiter, n int) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- l.WaitN(ctx, n) }()
+	return done
+}
+
+// retryAfter returns how long l reports until n requests are admitted.
+func retryAfter(t *testing.T, l ratelimit.Checker, n int) time.Duration {
+	t.Helper()
+	d, _ := ratelimit.RetryAfter(l.CheckN(n))
+	return d
+}
+
+// awaitRetryAfter polls until l reports want until n requests are
+// admitted, which shows that queued waiters have joined.
+func awaitRetryAfter(t *testing.T, l ratelimit.Checker, n int, want time.Duration) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if retryAfter(t, l, n) == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("RetryAfter = %v, want %v", retryAfter(t, l, n), want)
+}
+
+func TestTokenBucketWaitCancelled(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	tb := ratelimit.NewTokenBucket(
+		ratelimit.WithRate(1),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithBurst(1),
+		ratelimit.WithClock(clock),
+	)
+	tb.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := waitAsync(ctx, tb, 1)
+	clock.BlockUntil(1)
+
+	// The waiter's token is promised to it while it waits
+	if d := retryAfter(t, tb, 1); d != 2*time.Second {
+		t.Fatalf("RetryAfter with a waiter = %v, want 2s", d)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) || err != ctx.Err() {
+		t.Fatalf("WaitN = %v, want ctx.Err()", err)
+	}
+
+	// Its queue slot is freed and it consumed nothing
+	if d := retryAfter(t, tb, 1); d != time.Second {
+		t.Errorf("RetryAfter after cancel = %v, want 1s", d)
+	}
+	clock.Advance(time.Second)
+	if got := tb.Available(); got != 1 {
+		t.Errorf("Available = %d, want 1", got)
+	}
+	if !tb.Allow() {
+		t.Error("Allow denied after the waiter was cancelled")
+	}
+}
+
+func TestTokenBucketWaitCancelledKeepsOrder(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	tb := ratelimit.NewTokenBucket(
+		ratelimit.WithRate(1),
+		ratelimit.WithPeriod(time.Second),
+		ratelimit.WithBurst(1),
+		ratelimit.WithClock(clock),
+	)
+	tb.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := waitAsync(ctx, tb, 1)
+	clock.BlockUntil(1)
+	second := waitAsync(context.Background(), tb, 1)
+	awaitRetryAfter(t, tb, 1, 3*time.Second)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("first WaitN = %v, want context.Canceled", err)
+	}
+	awaitRetryAfter(t, tb, 1, 2*time.Second)
+
+	// The second waiter moves up and gets the next token
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if err := <-second; err != nil {
+		t.Fatalf("second WaitN = %v", err)
+	}
+	if got := tb.Available(); got != 0 {
+		t.Errorf("Available = %d, want 0", got)
+	}
+}
+
+func TestWaitCancelledConsumesNothing(t *testing.T) {
+	limiters := []struct {
+		name string
+		new  func(ratelimit.Clock) ratelimit.Limiter
+	}{
+		{"TokenBucket", func(c ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewTokenBucket(ratelimit.WithRate(2), ratelimit.WithPeriod(time.Second), ratelimit.WithBurst(2), ratelimit.WithClock(c))
+		}},
+		{"FixedWindow", func(c ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewFixedWindow(ratelimit.WithRate(2), ratelimit.WithPeriod(time.Second), ratelimit.WithClock(c))
+		}},
+		{"SlidingWindow", func(c ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewSlidingWindow(ratelimit.WithRate(2), ratelimit.WithPeriod(time.Second), ratelimit.WithClock(c))
+		}},
+		{"SlidingLog", func(c ratelimit.Clock) ratelimit.Limiter {
+			return ratelimit.NewSlidingLog(ratelimit.WithRate(2), ratelimit.WithPeriod(time.Second), ratelimit.WithClock(c))
+		}},
+	}
+
+	for _, tt := range limiters {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.NewFakeClock(time.Time{})
+			l := tt.new(clock)
+
+			// An already cancelled context fails even with capacity left
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := l.WaitN(ctx, 1); err != context.Canceled {
+				t.Fatalf("WaitN with a done context = %v, want context.Canceled", err)
+			}
+			if got := l.Available(); got != 2 {
+				t.Fatalf("Available = %d, want 2", got)
+			}
+
+			l.AllowN(2)
+			ctx, cancel = context.WithCancel(context.Background())
+			done := waitAsync(ctx, l, 2)
+			clock.BlockUntil(1)
+			cancel()
+			if err := <-done; err != context.Canceled {
+				t.Fatalf("WaitN = %v, want context.Canceled", err)
+			}
+
+			clock.Advance(2 * time.Second)
+			if got := l.Available(); got != 2 {
+				t.Errorf("Available after the period = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestQueueHandlerReleasesSlotOnDisconnect(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Time{})
+	m := ratelimit.NewMiddleware(&ratelimit.MiddlewareConfig{
+		KeyFunc: ratelimit.ConstantKeyFunc("all"),
+		LimiterFactory: func() ratelimit.Limiter {
+			return ratelimit.NewTokenBucket(ratelimit.WithRate(1), ratelimit.WithPeriod(time.Hour), ratelimit.WithBurst(1), ratelimit.WithClock(clock))
+		},
+	})
+	defer m.Close()
+	handler := m.QueueHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 1, 0)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	clock.BlockUntil(1)
+	if got := m.Queued(); got != 1 {
+		t.Fatalf("Queued = %d, want 1", got)
+	}
+
+	cancel()
+	<-served
+	if got := m.Queued(); got != 0 {
+		t.Errorf("Queued after disconnect = %d, want 0", got)
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// warmup implements the warm-up mode of TokenBucket, modelled on Guava's
+// SmoothWarmingUp. While the limiter has been idle it accumulates "stored
+// permits"; spending a stored permit above the threshold is slower than the
+// stable rate, so after idle time the admitted rate starts at
+// Rate/ColdFactor and ramps linearly to Rate over WarmupPeriod.
+//
+// Requests are paced rather than admitted in bursts: a request is admitted
+// when the next free slot has arrived, and its cost pushes that slot into
+// the future for later callers.
+type warmup struct {
+	stableInterval   float64 // nanoseconds per permit at full rate
+	coldInterval     float64 // nanoseconds per permit when fully cold
+	coolDownInterval float64 // nanoseconds of idle time per stored permit
+	thresholdPermits float64
+	maxPermits       float64
+	slope            float64
+	storedPermits    float64
+	nextFree         time.Time
+}
+
+// defaultColdFactor matches Guava's hard-coded cold factor.
+const defaultColdFactor = 3.0
+
+// newWarmup creates the warm-up state for cfg. It starts fully cold.
+func newWarmup(cfg *Config, now time.Time) *warmup {
+	coldFactor := cfg.ColdFactor
+	if coldFactor < 1 {
+		coldFactor = defaultColdFactor
+	}
+
+	stable := float64(cfg.Period) / float64(cfg.Rate)
+	cold := stable * coldFactor
+	period := float64(cfg.WarmupPeriod)
+
+	threshold := 0.5 * period / stable
+	max := threshold + 2*period/(stable+cold)
+
+	w := &warmup{
+		stableInterval:   stable,
+		coldInterval:     cold,
+		thresholdPermits: threshold,
+		maxPermits:       max,
+		coolDownInterval: period / max,
+		slope:            (cold - stable) / (max - threshold),
+	}
+	w.reset(now)
+	return w
+}
+
+// reset returns the limiter to its cold initial state.
+func (w *warmup) reset(now time.Time) {
+	w.storedPermits = w.maxPermits
+	w.nextFree = now
+}
+
+// resync credits stored permits for time spent idle.
+func (w *warmup) resync(now time.Time) {
+	if now.After(w.nextFree) {
+		idle := float64(now.Sub(w.nextFree))
+		w.storedPermits = math.Min(w.maxPermits, w.storedPermits+idle/w.coolDownInterval)
+		w.nextFree = now
+	}
+}
+
+// tryAcquire admits n permits if the next free slot has arrived, otherwise
+// it reports how long until it does.
+func (w *warmup) tryAcquire(n int, now time.Time) (bool, time.Duration) {
+	w.resync(now)
+	if w.nextFree.After(now) {
+		return false, w.nextFree.Sub(now)
+	}
+
+	permits := float64(n)
+	spend := math.Min(permits, w.storedPermits)
+	fresh := permits - spend
+	cost := w.storedPermitsToWaitTime(spend) + fresh*w.stableInterval
+
+	w.storedPermits -= spend
+	w.nextFree = now.Add(time.Duration(cost))
+	return true, 0
+}
+
+// storedPermitsToWaitTime integrates the per-permit interval over the
+// stored permits being spent, from the top of the store downwards.
+func (w *warmup) storedPermitsToWaitTime(toTake float64) float64 {
+	cost := 0.0
+	above := w.storedPermits - w.thresholdPermits
+	if above > 0 {
+		take := math.Min(above, toTake)
+		cost = take * (w.permitsToInterval(above) + w.permitsToInterval(above-take)) / 2
+		toTake -= take
+	}
+	return cost + w.stableInterval*toTake
+}
+
+// permitsToInterval returns the per-permit interval with p permits stored
+// above the threshold.
+func (w *warmup) permitsToInterval(p float64) float64 {
+	return w.stableInterval + p*w.slope
+}
+
+// available reports whether a request could be admitted now.
+func (w *warmup) available(now time.Time) int {
+	w.resync(now)
+	if w.nextFree.After(now) {
+		return 0
+	}
+	return 1
+}
+
+// giveBack pulls the next free slot back by the stable cost of n permits,
+// but never into the past.
+func (w *warmup) giveBack(n int, now time.Time) {
+	w.nextFree = w.nextFree.Add(-time.Duration(float64(n) * w.stableInterval))
+	if w.nextFree.Before(now) {
+		w.nextFree = now
+	}
+}
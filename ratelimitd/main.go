@@ -0,0 +1,12 @@
+// Command ratelimitd is the same program as rrl daemon; see internal/ratelimitd.
+package main
+
+import (
+	"os"
+
+	"github.com/rRateLimit/client/internal/ratelimitd"
+)
+
+func main() {
+	ratelimitd.Main(os.Args[1:])
+}
@@ -32,6 +32,8 @@ type AdaptiveRateLimiter struct {
 	lastAdjust    time.Time
 	mu            sync.RWMutex
 	done          chan struct{}
+	stopped       chan struct{} // 調整ループの終了を通知
+	stopOnce      sync.Once
 }
 
 // SlidingWindow は時間ベースのスライディングウィンドウ
@@ -64,6 +66,7 @@ func NewAdaptiveRateLimiter(baseRate float64) *AdaptiveRateLimiter {
 		},
 		lastAdjust: time.Now(),
 		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 	}
 	
 	arl.currentRate.Store(baseRate)
@@ -115,6 +118,8 @@ func (arl *AdaptiveRateLimiter) recordRequest(success bool, latency time.Duratio
 
 // adjustLoop は定期的にレートを調整
 func (arl *AdaptiveRateLimiter) adjustLoop() {
+	defer close(arl.stopped)
+	
 	ticker := time.NewTicker(arl.adjustInterval)
 	defer ticker.Stop()
 	
@@ -202,9 +207,11 @@ func (arl *AdaptiveRateLimiter) GetMetrics() (rate float64, successRate float64,
 	return
 }
 
-// Stop はレートリミッターを停止
+// Stop は調整ループを停止し、終了を待ちます。停止後もレートは最後の値で
+// 固定されたまま使えます。複数回呼び出しても安全です
 func (arl *AdaptiveRateLimiter) Stop() {
-	close(arl.done)
+	arl.stopOnce.Do(func() { close(arl.done) })
+	<-arl.stopped
 }
 
 // SlidingWindow のメソッド
@@ -65,6 +65,26 @@ func (g *GCRA) AllowN(n int) bool {
 	return true
 }
 
+// Refund は1セル分の許可を返却します
+func (g *GCRA) Refund() {
+	g.ReturnN(1)
+}
+
+// ReturnN は許可済みだが使われなかったnセル分を返却します
+// TATを巻き戻しますが、現在時刻より前には戻さない（バーストを超えた貯金を防ぐ）
+func (g *GCRA) ReturnN(n int) {
+	if n <= 0 {
+		return
+	}
+	
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	
+	now := g.now()
+	tat := g.tat.Load().(float64)
+	g.tat.Store(math.Max(tat-float64(n)*g.tau, now))
+}
+
 // AllowAt は指定時刻でのリクエストを許可するかチェックします（テスト用）
 func (g *GCRA) AllowAt(t time.Time) bool {
 	g.mu.Lock()
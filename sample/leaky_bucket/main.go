@@ -16,6 +16,9 @@ type LeakyBucket struct {
 	mu         sync.Mutex
 	processing chan struct{}    // 処理ゴルーチンの制御
 	done       chan struct{}    // 終了シグナル
+	wg         sync.WaitGroup   // 実行中のバックグラウンドゴルーチン
+	stopOnce   sync.Once
+	closed     bool
 }
 
 // Request はキューに保存されるリクエストを表します
@@ -36,6 +39,7 @@ func NewLeakyBucket(capacity int, rate time.Duration) *LeakyBucket {
 	}
 	
 	// バックグラウンドでリクエストを処理
+	lb.wg.Add(1)
 	go lb.leak()
 	
 	return lb
@@ -46,6 +50,10 @@ func (lb *LeakyBucket) Submit(id int) (chan bool, error) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 	
+	if lb.closed {
+		return nil, fmt.Errorf("bucket is stopped")
+	}
+	
 	// キューが満杯の場合は拒否
 	if lb.queue.Len() >= lb.capacity {
 		return nil, fmt.Errorf("bucket is full")
@@ -70,6 +78,8 @@ func (lb *LeakyBucket) Submit(id int) (chan bool, error) {
 
 // leak はキューからリクエストを一定レートで処理します
 func (lb *LeakyBucket) leak() {
+	defer lb.wg.Done()
+	
 	ticker := time.NewTicker(lb.rate)
 	defer ticker.Stop()
 	
@@ -116,9 +126,25 @@ func (lb *LeakyBucket) GetQueueSize() int {
 	return lb.queue.Len()
 }
 
-// Stop はリーキーバケットを停止します
+// Stop はリーキーバケットを停止し、処理ゴルーチンの終了を待ちます。
+// キューに残ったリクエストには false を通知し、以降の Submit はエラーになります。
+// 複数回呼び出しても安全です
 func (lb *LeakyBucket) Stop() {
-	close(lb.done)
+	lb.stopOnce.Do(func() {
+		close(lb.done)
+		lb.wg.Wait()
+		
+		lb.mu.Lock()
+		defer lb.mu.Unlock()
+		
+		lb.closed = true
+		for e := lb.queue.Front(); e != nil; e = e.Next() {
+			req := e.Value.(*Request)
+			req.Done <- false
+			close(req.Done)
+		}
+		lb.queue.Init()
+	})
 }
 
 // AdaptiveLeakyBucket は負荷に応じて処理レートを調整するリーキーバケット
@@ -141,6 +167,7 @@ func NewAdaptiveLeakyBucket(capacity int, initialRate, minRate, maxRate time.Dur
 	}
 	
 	// レート調整ループを開始
+	alb.wg.Add(1)
 	go alb.adjustRate()
 	
 	return alb
@@ -148,6 +175,8 @@ func NewAdaptiveLeakyBucket(capacity int, initialRate, minRate, maxRate time.Dur
 
 // adjustRate は定期的に処理レートを調整します
 func (alb *AdaptiveLeakyBucket) adjustRate() {
+	defer alb.wg.Done()
+	
 	ticker := time.NewTicker(alb.adjustPeriod)
 	defer ticker.Stop()
 	
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+	
+	"github.com/rRateLimit/client/ratelimit/sharding"
 )
 
 // RedisTokenBucket はRedisベースの分散トークンバケット（シミュレーション）
@@ -174,40 +176,15 @@ func (drl *DistributedRateLimiter) getNodeUsage(nodeID string) int64 {
 
 // ConsistentHashRateLimiter はコンシステントハッシュを使用
 type ConsistentHashRateLimiter struct {
-	ring    *HashRing
+	ring    *sharding.Ring
 	buckets map[string]*RedisTokenBucket
 	redis   *RedisSimulator
 }
 
-// HashRing はコンシステントハッシュリング
-type HashRing struct {
-	nodes        []string
-	virtualNodes int
-	ring         map[uint32]string
-	sortedKeys   []uint32
-}
-
 // NewConsistentHashRateLimiter は新しいコンシステントハッシュリミッターを作成
 func NewConsistentHashRateLimiter(nodes []string, capacity, rate int64, redis *RedisSimulator) *ConsistentHashRateLimiter {
-	ring := &HashRing{
-		nodes:        nodes,
-		virtualNodes: 150,
-		ring:         make(map[uint32]string),
-	}
-	
-	// リングを構築
-	for _, node := range nodes {
-		for i := 0; i < ring.virtualNodes; i++ {
-			hash := hashString(fmt.Sprintf("%s:%d", node, i))
-			ring.ring[hash] = node
-		}
-	}
-	
-	// ソート済みキーを作成
-	ring.sortedKeys = make([]uint32, 0, len(ring.ring))
-	for k := range ring.ring {
-		ring.sortedKeys = append(ring.sortedKeys, k)
-	}
+	// リングを構築（ratelimit/sharding を使用）
+	ring := sharding.NewRing(nodes)
 	
 	// バケットを作成
 	buckets := make(map[string]*RedisTokenBucket)
@@ -230,7 +207,7 @@ func NewConsistentHashRateLimiter(nodes []string, capacity, rate int64, redis *R
 // Allow はユーザーのリクエストを許可
 func (chrl *ConsistentHashRateLimiter) Allow(userID string) bool {
 	// ユーザーIDからノードを決定
-	node := chrl.ring.GetNode(userID)
+	node := chrl.ring.Get(userID)
 	
 	// 対応するバケットでチェック
 	if bucket, exists := chrl.buckets[node]; exists {
@@ -240,35 +217,6 @@ func (chrl *ConsistentHashRateLimiter) Allow(userID string) bool {
 	return false
 }
 
-// GetNode はキーに対応するノードを取得
-func (hr *HashRing) GetNode(key string) string {
-	if len(hr.sortedKeys) == 0 {
-		return ""
-	}
-	
-	hash := hashString(key)
-	
-	// 二分探索で最も近いノードを見つける
-	idx := 0
-	for i := range hr.sortedKeys {
-		if hr.sortedKeys[i] >= hash {
-			idx = i
-			break
-		}
-	}
-	
-	return hr.ring[hr.sortedKeys[idx]]
-}
-
-// hashString は文字列をハッシュ化
-func hashString(s string) uint32 {
-	h := uint32(0)
-	for _, c := range s {
-		h = h*31 + uint32(c)
-	}
-	return h
-}
-
 // RedisSimulator のメソッド
 func NewRedisSimulator() *RedisSimulator {
 	return &RedisSimulator{
@@ -420,7 +368,7 @@ func main() {
 	
 	fmt.Println("\nユーザーのノード割当:")
 	for _, user := range users {
-		node := chrl.ring.GetNode(user)
+		node := chrl.ring.Get(user)
 		userNodes[user] = node
 		fmt.Printf("%s → %s\n", user, node)
 	}
@@ -448,7 +396,7 @@ func main() {
 	fmt.Println("\n再割当後:")
 	for _, user := range users {
 		oldNode := userNodes[user]
-		newNode := chrl2.ring.GetNode(user)
+		newNode := chrl2.ring.Get(user)
 		fmt.Printf("%s: %s → %s\n", user, oldNode, newNode)
 	}
 	
@@ -19,6 +19,9 @@ type WFQScheduler struct {
 	// 処理エンジン
 	processor chan *Request
 	done      chan struct{}
+	stopped   chan struct{} // 処理ループの終了を通知
+	stopOnce  sync.Once
+	closed    bool
 }
 
 // Queue は各クラス/ユーザーのキュー
@@ -54,6 +57,7 @@ func NewWFQScheduler() *WFQScheduler {
 		heap:      &VirtualTimeHeap{},
 		processor: make(chan *Request, 100),
 		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
 	}
 	
 	heap.Init(wfq.heap)
@@ -88,6 +92,10 @@ func (wfq *WFQScheduler) Enqueue(queueID string, requestID string, size int) (ch
 	wfq.mu.Lock()
 	defer wfq.mu.Unlock()
 	
+	if wfq.closed {
+		return nil, fmt.Errorf("scheduler is stopped")
+	}
+	
 	queue, exists := wfq.queues[queueID]
 	if !exists {
 		return nil, fmt.Errorf("queue %s not found", queueID)
@@ -121,6 +129,8 @@ func (wfq *WFQScheduler) Enqueue(queueID string, requestID string, size int) (ch
 
 // processLoop はリクエストを処理するメインループ
 func (wfq *WFQScheduler) processLoop() {
+	defer close(wfq.stopped)
+	
 	ticker := time.NewTicker(10 * time.Millisecond) // 処理レート
 	defer ticker.Stop()
 	
@@ -213,9 +223,28 @@ func (wfq *WFQScheduler) GetStats() map[string]map[string]interface{} {
 	return stats
 }
 
-// Stop はスケジューラーを停止
+// Stop はスケジューラーを停止し、処理ループの終了を待ちます。
+// 未処理のリクエストには false を通知し、以降の Enqueue はエラーになります。
+// 複数回呼び出しても安全です
 func (wfq *WFQScheduler) Stop() {
-	close(wfq.done)
+	wfq.stopOnce.Do(func() {
+		close(wfq.done)
+		<-wfq.stopped
+		
+		wfq.mu.Lock()
+		defer wfq.mu.Unlock()
+		
+		wfq.closed = true
+		for _, queue := range wfq.queues {
+			for _, request := range queue.requests {
+				request.Done <- false
+				close(request.Done)
+			}
+			queue.requests = nil
+			queue.active = false
+		}
+		*wfq.heap = (*wfq.heap)[:0]
+	})
 }
 
 // Heap インターフェースの実装
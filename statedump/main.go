@@ -0,0 +1,12 @@
+// Command statedump is the same program as rrl ctl; see internal/statedump.
+package main
+
+import (
+	"os"
+
+	"github.com/rRateLimit/client/internal/statedump"
+)
+
+func main() {
+	statedump.Main(os.Args[1:])
+}